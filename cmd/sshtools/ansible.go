@@ -0,0 +1,61 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/aoaeoe/sshTools/pkg/sshtools"
+)
+
+// importAnsibleCommand copies the hosts of an Ansible inventory into the
+// config file, with their groups as tags, skipping aliases it already has:
+// sshtools import-ansible inventory.yml
+// sshtools import-ansible -n hosts.ini
+func importAnsibleCommand(args []string) {
+	fs := flag.NewFlagSet("import-ansible", flag.ExitOnError)
+	configFlag := fs.String("config", sshtools.DefaultConfigFile(), "Path to the configuration file to add the hosts to")
+	dryRunFlag := fs.Bool("n", false, "Only show what would be imported")
+	_ = fs.Parse(args)
+	if fs.NArg() != 1 {
+		fmt.Fprintln(os.Stderr, "usage: sshtools import-ansible [-config config.json] [-n] inventory.ini|inventory.yml")
+		os.Exit(2)
+	}
+	inventory := fs.Arg(0)
+
+	opts := commonFlags{configFile: *configFlag, noSecrets: true}
+	config, err := opts.load()
+	if err != nil {
+		exitConfigError(err)
+	}
+	servers, notes, err := sshtools.ImportAnsible(inventory)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Error:", err)
+		os.Exit(1)
+	}
+
+	for _, note := range notes {
+		fmt.Println("note:", note)
+	}
+	added := 0
+	for i := range servers {
+		server := &servers[i]
+		if config.ServerByAlias(server.Alias) != nil {
+			fmt.Printf("skipped %s: alias already configured\n", server.Alias)
+			continue
+		}
+		if !*dryRunFlag {
+			if err = sshtools.AppendServer(*configFlag, server); err != nil {
+				fmt.Fprintln(os.Stderr, "Error:", err)
+				os.Exit(1)
+			}
+		}
+		added++
+		fmt.Printf("added %s (%s@%s)\n", server.Alias, server.User, server.Addr())
+	}
+	if *dryRunFlag {
+		fmt.Printf("Would import %d of %d host(s) from %s.\n", added, len(servers), inventory)
+		return
+	}
+	fmt.Printf("Imported %d of %d host(s) from %s into %s.\n", added, len(servers), inventory, *configFlag)
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/aoaeoe/sshTools/pkg/sshtools"
+)
+
+// auditCommand reports the banner, algorithms and host keys of servers and
+// flags weak ones, without logging in:
+// sshtools audit web1
+// sshtools audit -tag prod -o json
+func auditCommand(args []string) {
+	fs := flag.NewFlagSet("audit", flag.ExitOnError)
+	var opts commonFlags
+	var fleet fleetFlags
+	opts.register(fs, "audit")
+	fleet.register(fs)
+	concurrencyFlag := fs.Int("concurrency", fleetParallel, "Audit at most this many servers at once")
+	outputFlag := fs.String("o", "text", "Output format: text or json")
+	positional := parseArgs(fs, args)
+	if *outputFlag != "text" && *outputFlag != "json" {
+		fmt.Fprintf(os.Stderr, "unknown output format %q\n", *outputFlag)
+		os.Exit(2)
+	}
+	if len(positional) > 1 {
+		fmt.Fprintln(os.Stderr, "usage: sshtools audit [<alias> | -tag <tag> | -hosts <a,b,...> | -match <regexp>] [-o json]")
+		os.Exit(2)
+	}
+	if len(positional) == 1 {
+		fleet.target(&opts, positional[0])
+	}
+
+	config, err := opts.load()
+	if err != nil {
+		exitConfigError(err)
+	}
+	var servers []*sshtools.Server
+	if fleet.selected(&opts) || sshtools.IsPattern(opts.alias) || sshtools.IsPattern(opts.ip) {
+		if servers, err = fleet.servers(config, &opts); err != nil {
+			fmt.Fprintln(os.Stderr, "Error:", err)
+			os.Exit(2)
+		}
+	} else {
+		servers = []*sshtools.Server{selectServer(config, opts.alias, opts.ip, opts.tag)}
+	}
+	if len(servers) == 0 {
+		fmt.Fprintln(os.Stderr, "Error: no server matched")
+		os.Exit(1)
+	}
+
+	results := make([]*sshtools.AuditResult, len(servers))
+	forEachServer(servers, *concurrencyFlag, func(i int, server *sshtools.Server) {
+		res, errs := dialer.Audit(server)
+		if errs != nil {
+			res = &sshtools.AuditResult{Alias: server.Alias, Address: server.Addr(), Error: errs.Error()}
+		}
+		results[i] = res
+	})
+
+	failed := false
+	for _, res := range results {
+		failed = failed || res.Failed()
+	}
+	if *outputFlag == "json" {
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		_ = encoder.Encode(results)
+	} else {
+		for i, res := range results {
+			if i > 0 {
+				fmt.Println()
+			}
+			printAudit(res)
+		}
+	}
+	if failed {
+		os.Exit(1)
+	}
+}
+
+// printAudit prints the result of one server.
+func printAudit(res *sshtools.AuditResult) {
+	fmt.Printf("%s (%s)\n", res.Alias, res.Address)
+	if res.Error != "" {
+		fmt.Printf("  FAILED  %s\n", res.Error)
+		return
+	}
+	fmt.Printf("  %-11s %s (connected in %dms)\n", "banner", res.Banner, res.ConnectMs)
+	for _, list := range []struct {
+		name  string
+		names []string
+	}{
+		{"kex", res.KeyExchanges},
+		{"host keys", res.HostKeyAlgorithms},
+		{"ciphers", res.Ciphers},
+		{"macs", res.MACs},
+		{"compression", res.Compressions},
+	} {
+		fmt.Printf("  %-11s %s\n", list.name, strings.Join(list.names, ", "))
+	}
+	for _, key := range res.HostKeys {
+		bits := ""
+		if key.Bits > 0 {
+			bits = fmt.Sprintf(" (%d bits)", key.Bits)
+		}
+		fmt.Printf("  %-11s %s %s%s\n", "host key", key.Type, key.Fingerprint, bits)
+	}
+	if len(res.Findings) == 0 {
+		fmt.Println("  No weak algorithms found.")
+	}
+	for _, finding := range res.Findings {
+		fmt.Printf("  %-4s %s\n", strings.ToUpper(finding.Level), finding.Message)
+	}
+}
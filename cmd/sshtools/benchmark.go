@@ -0,0 +1,30 @@
+package main
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/aoaeoe/sshTools/pkg/sshtools"
+)
+
+// benchmarkSize is how much data -benchmark streams.
+const benchmarkSize = 256 << 20
+
+// runBenchmark measures how fast session output arrives from server, so
+// changes to the streaming code can be compared.
+func runBenchmark(opts *commonFlags, config *sshtools.Config, server *sshtools.Server) (err error) {
+	client, err := dialServer(opts, config, server)
+	if err != nil {
+		return
+	}
+	defer func() {
+		_ = client.Close()
+	}()
+	elapsed, err := client.Benchmark(benchmarkSize)
+	if err != nil {
+		return fmt.Errorf("benchmark failed: %v", err)
+	}
+	fmt.Printf("Received %s in %s: %.1f MB/s\n", sshtools.FormatBytes(benchmarkSize),
+		elapsed.Round(time.Millisecond), benchmarkSize/1e6/elapsed.Seconds())
+	return
+}
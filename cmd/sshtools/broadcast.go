@@ -0,0 +1,80 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/aoaeoe/sshTools/pkg/sshtools"
+	"golang.org/x/crypto/ssh"
+)
+
+// runBroadcast opens a shell on every server selected by -tag, -hosts or an
+// -alias / -ip pattern and types into all of them at once:
+// sshtools -broadcast -tag web
+// Servers that cannot be reached are reported and left out.
+func runBroadcast(opts *commonFlags, fleet *fleetFlags, config *sshtools.Config) (err error) {
+	servers, err := fleet.servers(config, opts)
+	if err != nil {
+		return
+	}
+	if len(servers) == 0 {
+		return errors.New("no server matched")
+	}
+
+	fmt.Printf("Connecting to %d servers...\n", len(servers))
+	started := time.Now()
+	clients := make([]*sshtools.Client, len(servers))
+	forEachServer(servers, fleetParallel, func(i int, server *sshtools.Server) {
+		client, errs := dialServer(opts, config, server)
+		if errs != nil {
+			fmt.Fprintf(os.Stderr, "%s: %v\n", server.Alias, errs)
+			recordSession(server, started, false, errs)
+			return
+		}
+		clients[i] = client
+	})
+	defer func() {
+		for _, client := range clients {
+			if client != nil {
+				_ = client.Close()
+			}
+		}
+	}()
+
+	b := &sshtools.Broadcast{Stdin: os.Stdin, Stdout: os.Stdout, Stderr: os.Stderr}
+	for i, client := range clients {
+		if client == nil {
+			continue
+		}
+		command := servers[i].RemoteCommand
+		if opts.command != "" {
+			command = opts.command
+		}
+		session, command, errs := client.NewUserSession(command)
+		if errs != nil {
+			fmt.Fprintf(os.Stderr, "%s: failed to create session: %v\n", servers[i].Alias, errs)
+			continue
+		}
+		defer func(session *ssh.Session) {
+			_ = session.Close()
+		}(session)
+		host := &sshtools.BroadcastHost{Name: servers[i].Alias, Session: session, Command: command,
+			TermType: servers[i].TermType(), Modes: servers[i].PTYModes()}
+		if opts.term != "" {
+			host.TermType = opts.term
+		}
+		b.Hosts = append(b.Hosts, host)
+	}
+	if len(b.Hosts) == 0 {
+		return errors.New("no server could be reached")
+	}
+	err = b.Run()
+	for i, client := range clients {
+		if client != nil {
+			recordSession(servers[i], started, false, err)
+		}
+	}
+	return
+}
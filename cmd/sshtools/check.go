@@ -0,0 +1,35 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/aoaeoe/sshTools/pkg/sshtools"
+)
+
+// checkCommand validates the config file and reports every problem:
+// sshtools check [-config config.json]
+func checkCommand(args []string) {
+	fs := flag.NewFlagSet("check", flag.ExitOnError)
+	configFile := fs.String("config", sshtools.DefaultConfigFile(), "Path to the configuration file")
+	_ = fs.Parse(args)
+
+	config, problems, err := sshtools.ParseConfigFile(*configFile)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s: %v\n", *configFile, err)
+		os.Exit(1)
+	}
+
+	errors := 0
+	for _, p := range problems {
+		fmt.Printf("%s: %s\n", *configFile, p)
+		if !p.Warning {
+			errors++
+		}
+	}
+	fmt.Printf("%s: %d servers, %d errors, %d warnings\n", *configFile, len(config.Servers), errors, len(problems)-errors)
+	if errors > 0 {
+		os.Exit(1)
+	}
+}
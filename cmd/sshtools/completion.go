@@ -0,0 +1,124 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+)
+
+// subcommands are completed as the first argument.
+var subcommands = []string{
+	"add", "audit", "check", "completion", "config", "copy-id", "dash", "debug-report", "discover", "doctor", "edit", "exec", "export",
+	"facts", "fingerprint", "fwd", "get", "history", "import-ansible", "import-putty", "import-sshconfig", "keygen", "known-hosts", "list",
+	"mount", "nc", "ping", "ports", "push-file", "put", "recent", "replay", "rm", "rotate-key", "run", "run-script", "secret", "sftp",
+	"status", "sync", "tail", "tunnel", "tunnels", "unmount", "verify", "vpn", "watch",
+}
+
+// bashCompletion completes subcommands and server aliases as the first
+// argument, and -alias, -tag and -provider values, reading the config given
+// with -config, if any.
+const bashCompletion = `_sshtools() {
+    local cur prev config=() i subcommand=
+    cur="${COMP_WORDS[COMP_CWORD]}"
+    prev="${COMP_WORDS[COMP_CWORD-1]}"
+    for ((i = 1; i < COMP_CWORD; i++)); do
+        case "${COMP_WORDS[i]}" in
+            -config|--config) config=(-config "${COMP_WORDS[i+1]}") ;;
+        esac
+    done
+    case "$prev" in
+        -alias|--alias)
+            COMPREPLY=($(compgen -W "$(sshtools list -o names "${config[@]}" 2>/dev/null)" -- "$cur"))
+            return ;;
+        -tag|--tag)
+            COMPREPLY=($(compgen -W "all $(sshtools list -o tags "${config[@]}" 2>/dev/null)" -- "$cur"))
+            return ;;
+        -provider|--provider)
+            COMPREPLY=($(compgen -W "$(sshtools list -o providers "${config[@]}" 2>/dev/null)" -- "$cur"))
+            return ;;
+        -config|--config|-env-file|--env-file)
+            COMPREPLY=($(compgen -f -- "$cur"))
+            return ;;
+    esac
+    [[ $COMP_CWORD -gt 1 && " %[1]s " == *" ${COMP_WORDS[1]} "* ]] && subcommand=${COMP_WORDS[1]}
+    if [[ -z $subcommand && "$cur" != -* ]]; then
+        local words="$(sshtools list -o names "${config[@]}" 2>/dev/null)"
+        [[ $COMP_CWORD -eq 1 ]] && words="%[1]s $words"
+        COMPREPLY=($(compgen -W "$words" -- "$cur"))
+    fi
+}
+complete -o default -F _sshtools sshtools
+`
+
+// zshCompletion is the zsh counterpart of bashCompletion.
+const zshCompletion = `#compdef sshtools
+
+_sshtools() {
+    local -a config subcommands
+    local i=${words[(I)-config]}
+    (( i > 0 && i < CURRENT - 1 )) && config=(-config ${words[i+1]})
+    subcommands=(%[1]s)
+    case ${words[CURRENT-1]} in
+        -alias)
+            compadd -- ${(f)"$(sshtools list -o names $config 2>/dev/null)"}
+            return ;;
+        -tag)
+            compadd -- all ${(f)"$(sshtools list -o tags $config 2>/dev/null)"}
+            return ;;
+        -provider)
+            compadd -- ${(f)"$(sshtools list -o providers $config 2>/dev/null)"}
+            return ;;
+        -config|-env-file)
+            _files
+            return ;;
+    esac
+    if (( CURRENT > 2 && ${subcommands[(Ie)${words[2]}]} )) || [[ $PREFIX == -* ]]; then
+        _files
+        return
+    fi
+    (( CURRENT == 2 )) && compadd -- $subcommands
+    compadd -- ${(f)"$(sshtools list -o names $config 2>/dev/null)"}
+}
+
+compdef _sshtools sshtools
+`
+
+// fishCompletion is the fish counterpart of bashCompletion.
+const fishCompletion = `function __sshtools_list
+    set -l tokens (commandline -opc)
+    set -l config
+    if set -l i (contains -i -- -config $tokens); and set -q tokens[(math $i + 1)]
+        set config -config $tokens[(math $i + 1)]
+    end
+    sshtools list -o $argv[1] $config 2>/dev/null
+end
+
+complete -c sshtools -n __fish_use_subcommand -f -a '%[1]s'
+complete -c sshtools -n __fish_use_subcommand -f -a '(__sshtools_list names)' -d server
+complete -c sshtools -o alias -x -a '(__sshtools_list names)'
+complete -c sshtools -o tag -x -a 'all (__sshtools_list tags)'
+complete -c sshtools -o provider -x -a '(__sshtools_list providers)'
+complete -c sshtools -o config -r -F
+complete -c sshtools -o env-file -r -F
+`
+
+// completionCommand prints a shell completion script:
+// source <(sshtools completion bash)
+// sshtools completion fish > ~/.config/fish/completions/sshtools.fish
+func completionCommand(args []string) {
+	if len(args) != 1 {
+		fmt.Fprintln(os.Stderr, "usage: sshtools completion bash|zsh|fish")
+		os.Exit(2)
+	}
+	switch args[0] {
+	case "bash":
+		fmt.Printf(bashCompletion, strings.Join(subcommands, " "))
+	case "zsh":
+		fmt.Printf(zshCompletion, strings.Join(subcommands, " "))
+	case "fish":
+		fmt.Printf(fishCompletion, strings.Join(subcommands, " "))
+	default:
+		fmt.Fprintf(os.Stderr, "unsupported shell %q, use bash, zsh or fish\n", args[0])
+		os.Exit(2)
+	}
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/aoaeoe/sshTools/pkg/sshtools"
+)
+
+// convertConfig writes the config file from in another format, JSON, YAML
+// or TOML by the extension of to:
+// sshtools config convert config.json config.yaml
+func convertConfig(args []string, usage string) {
+	fs := flag.NewFlagSet("config convert", flag.ExitOnError)
+	forceFlag := fs.Bool("force", false, "Overwrite the target file if it exists")
+	positional := parseArgs(fs, args)
+	if len(positional) != 2 {
+		fmt.Fprintln(os.Stderr, usage)
+		os.Exit(2)
+	}
+	from, to := positional[0], positional[1]
+
+	data, err := sshtools.ReadConfigFile(from)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %s: %v\n", from, err)
+		os.Exit(1)
+	}
+	// 有错误的配置不转换，免得问题被带到新文件里
+	config, problems, err := sshtools.ParseConfigFile(from)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %s: %v\n", from, err)
+		os.Exit(1)
+	}
+	errs := 0
+	for _, p := range problems {
+		if !p.Warning {
+			fmt.Fprintf(os.Stderr, "%s: %s\n", from, p)
+			errs++
+		}
+	}
+	if errs > 0 {
+		fmt.Fprintf(os.Stderr, "Error: %s has %d errors, fix them before converting\n", from, errs)
+		os.Exit(1)
+	}
+
+	out, err := sshtools.ConvertConfig(data, sshtools.FormatJSON, sshtools.ConfigFormat(to))
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Error: failed to convert the config:", err)
+		os.Exit(1)
+	}
+	perm := os.FileMode(0o600)
+	if info, errs := os.Stat(from); errs == nil {
+		perm = info.Mode().Perm()
+	}
+	flags := os.O_WRONLY | os.O_CREATE | os.O_EXCL
+	if *forceFlag {
+		flags = os.O_WRONLY | os.O_CREATE | os.O_TRUNC
+	}
+	file, err := os.OpenFile(to, flags, perm)
+	if errors.Is(err, os.ErrExist) {
+		fmt.Fprintf(os.Stderr, "Error: %s already exists, use -force to overwrite it\n", to)
+		os.Exit(1)
+	}
+	if err == nil {
+		_, err = file.Write(out)
+		if errs := file.Close(); err == nil {
+			err = errs
+		}
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Error:", err)
+		os.Exit(1)
+	}
+	fmt.Printf("Converted %d servers from %s to %s.\n", len(config.Servers), from, to)
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/aoaeoe/sshTools/pkg/sshtools"
+	"golang.org/x/crypto/ssh"
+)
+
+// copyIDCommand installs a local public key on a server, like ssh-copy-id:
+// sshtools copy-id web1
+// sshtools copy-id -i ~/.ssh/deploy.pub -use-key web1
+func copyIDCommand(args []string) {
+	fs := flag.NewFlagSet("copy-id", flag.ExitOnError)
+	var opts commonFlags
+	opts.register(fs, "install the key on")
+	keyFlag := fs.String("i", "", "Public key to install (default: the server's private_key, or ~/.ssh/id_ed25519.pub, id_ecdsa.pub, id_rsa.pub)")
+	useKeyFlag := fs.Bool("use-key", false, "Switch the server's config entry to the key once it works")
+	positional := parseArgs(fs, args)
+	if len(positional) == 1 && opts.alias == "" {
+		opts.alias = positional[0]
+	}
+	if len(positional) > 1 || opts.alias == "" && opts.ip == "" {
+		fmt.Fprintln(os.Stderr, "usage: sshtools copy-id [-i <key.pub>] [-use-key] <alias>")
+		os.Exit(2)
+	}
+
+	config, err := opts.load()
+	if err != nil {
+		exitConfigError(err)
+	}
+	server := selectServer(config, opts.alias, opts.ip, opts.tag)
+	if err = copyID(&opts, config, server, *keyFlag, *useKeyFlag); err != nil {
+		fmt.Fprintln(os.Stderr, "Error:", err)
+		os.Exit(exitCode(err, 1))
+	}
+}
+
+func copyID(opts *commonFlags, config *sshtools.Config, server *sshtools.Server, keyPath string, useKey bool) (err error) {
+	key, err := sshtools.ReadPublicKey(server, keyPath)
+	if err != nil {
+		return
+	}
+	fmt.Printf("Installing %s %s (%s) on %s.\n", key.Key.Type(), ssh.FingerprintSHA256(key.Key), key.Path, server.Alias)
+
+	client, err := dialServer(opts, config, server)
+	if err != nil && server.UseKey && strings.Contains(err.Error(), "unable to authenticate") {
+		// 配置的密钥还不能登录时改用密码
+		fmt.Fprintf(os.Stderr, "%s does not accept its private_key yet, trying a password.\n", server.Alias)
+		login := *server
+		login.UseKey = false
+		client, err = dialer.Dial(&login)
+	}
+	if err != nil {
+		return
+	}
+	added, err := client.InstallPublicKey(key)
+	_ = client.Close()
+	if err != nil {
+		return
+	}
+	if added {
+		fmt.Printf("Added the key to ~/.ssh/authorized_keys on %s.\n", server.Alias)
+	} else {
+		fmt.Printf("The key is already in ~/.ssh/authorized_keys on %s.\n", server.Alias)
+	}
+
+	// 只用新密钥重新登录，确认服务器接受它
+	if err = dialer.VerifyPublicKey(server, key); err != nil {
+		return fmt.Errorf("key login failed, check the permissions of ~ and ~/.ssh on the server: %v", err)
+	}
+	fmt.Printf("Logged in to %s with the key.\n", server.Alias)
+
+	if server.UseKey && key.PrivateKey != "" && sameFile(server.PrivateKey, key.PrivateKey) || server.UseAgent && key.PrivateKey == "" {
+		return
+	}
+	if config.ServerByAlias(server.Alias) != server {
+		return
+	}
+	if !useKey && !confirm(fmt.Sprintf("Use the key for %s in %s? [y/N] ", server.Alias, opts.configFile), false) {
+		return
+	}
+	if key.PrivateKey == "" {
+		err = sshtools.SetServerField(opts.configFile, server.Alias, "use_agent", true)
+	} else if err = sshtools.SetServerField(opts.configFile, server.Alias, "private_key", key.PrivateKey); err == nil {
+		err = sshtools.SetServerField(opts.configFile, server.Alias, "use_key", true)
+	}
+	if err != nil {
+		return
+	}
+	fmt.Printf("%s now logs in to %s with the key.\n", opts.configFile, server.Alias)
+	return
+}
+
+// sameFile reports whether the paths a and b name the same file.
+func sameFile(a, b string) bool {
+	a, errA := sshtools.ExpandPath(a)
+	b, errB := sshtools.ExpandPath(b)
+	if errA != nil || errB != nil {
+		return false
+	}
+	infoA, errA := os.Stat(a)
+	infoB, errB := os.Stat(b)
+	return errA == nil && errB == nil && os.SameFile(infoA, infoB)
+}
@@ -0,0 +1,800 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"slices"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+	"unicode/utf8"
+
+	"github.com/aoaeoe/sshTools/pkg/sshtools"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/term"
+)
+
+// dashInterval is how often dash checks the servers unless -interval says
+// otherwise.
+const dashInterval = 15 * time.Second
+
+// dashExecLines is how much output of a quick command dash keeps.
+const dashExecLines = 200
+
+// dashCloseWait is how long dash waits for its sessions to end when it
+// quits before it drops the connections.
+const dashCloseWait = 2 * time.Second
+
+// dashDetach (Ctrl-]) goes back from a session to the dashboard.
+const dashDetach = 0x1d
+
+// dashScreen undoes what a session may have left switched on (colors, a
+// hidden cursor, application cursor keys, mouse reporting, bracketed
+// paste) and makes sure the alternate screen is in use.
+const dashScreen = "\x1b[0m\x1b[?25h\x1b[?1l\x1b[?1000l\x1b[?1002l\x1b[?1006l\x1b[?2004l\x1b[?1049h"
+
+// dashCommand shows the servers with their live status on a full-screen
+// dashboard, opens shells on them in tabs of the one process and runs
+// quick commands on them:
+// sshtools dash
+// sshtools dash -tag prod -interval 30s
+func dashCommand(args []string) {
+	fs := flag.NewFlagSet("dash", flag.ExitOnError)
+	var opts commonFlags
+	var fleet fleetFlags
+	opts.register(fs, "show")
+	fleet.register(fs)
+	intervalFlag := fs.Duration("interval", dashInterval, "Check the servers this often")
+	positional := parseArgs(fs, args)
+	if len(positional) > 1 {
+		fmt.Fprintln(os.Stderr, "usage: sshtools dash [<alias pattern> | -tag <tag> | -hosts <a,b,...> | -match <regexp>] [-interval 15s]")
+		os.Exit(2)
+	}
+	if len(positional) == 1 {
+		fleet.target(&opts, positional[0])
+	}
+	if *intervalFlag <= 0 {
+		fmt.Fprintln(os.Stderr, "-interval must be positive")
+		os.Exit(2)
+	}
+	fd := int(os.Stdin.Fd())
+	if !term.IsTerminal(fd) || !term.IsTerminal(int(os.Stdout.Fd())) {
+		fmt.Fprintln(os.Stderr, "Error: dash needs a terminal")
+		os.Exit(2)
+	}
+
+	config, err := opts.load()
+	if err != nil {
+		exitConfigError(err)
+	}
+	// 未指定时显示所有服务器
+	var servers []*sshtools.Server
+	if !fleet.selected(&opts) && opts.alias == "" && opts.ip == "" {
+		servers = config.ActiveServers(fleet.includeDeprecated)
+		fleet.preview(servers)
+	} else if servers, err = fleet.servers(config, &opts); err != nil {
+		fmt.Fprintln(os.Stderr, "Error:", err)
+		os.Exit(2)
+	}
+	if len(servers) == 0 {
+		fmt.Fprintln(os.Stderr, "Error: no server matched")
+		os.Exit(1)
+	}
+
+	// 后台检查不登录，也不能输出日志打乱屏幕
+	checker := *dialer
+	checker.Verbose = 0
+	if opts.timeout == 0 {
+		checker.Timeout = statusTimeout
+	}
+	d := &dash{
+		opts: &opts, config: config, servers: servers, checker: &checker, interval: *intervalFlag, fd: fd,
+		status:   map[*sshtools.Server]sshtools.ServerStatus{},
+		checking: map[*sshtools.Server]bool{},
+		clients:  map[*sshtools.Server]*sshtools.Client{},
+		recheck:  make(chan struct{}, 1),
+	}
+	if err = d.run(); err != nil {
+		fmt.Fprintln(os.Stderr, "Error:", err)
+		os.Exit(1)
+	}
+}
+
+// dashTab is a session opened from the dashboard.
+type dashTab struct {
+	server  *sshtools.Server
+	tab     *sshtools.Tab
+	started time.Time
+	exited  bool
+}
+
+// dashExec is the latest quick command.
+type dashExec struct {
+	server  *sshtools.Server
+	command string
+	session *ssh.Session
+	output  []string
+	partial string
+	running bool
+	result  string
+}
+
+// dash is the state of the dashboard. Everything below mu is guarded by it.
+type dash struct {
+	opts     *commonFlags
+	config   *sshtools.Config
+	servers  []*sshtools.Server
+	checker  *sshtools.Dialer
+	interval time.Duration
+	fd       int
+	state    *term.State
+	recheck  chan struct{}
+	sessions sync.WaitGroup
+	once     sync.Once
+
+	mu       sync.Mutex
+	status   map[*sshtools.Server]sshtools.ServerStatus
+	checking map[*sshtools.Server]bool
+	checked  time.Time
+	clients  map[*sshtools.Server]*sshtools.Client
+	tabs     []*dashTab
+	// active is the tab on screen, nil while the dashboard is; last is
+	// the one shown most recently, which Tab moves on from.
+	active *dashTab
+	last   *dashTab
+	// paused stops drawing while the screen belongs to someone else:
+	// while connecting, which may prompt, and once dash is closing.
+	paused    bool
+	cursor    int
+	offset    int
+	prompting bool
+	prompt    []rune
+	exec      *dashExec
+	message   string
+	quitting  bool
+}
+
+// dashAction is what a key asks for that has to happen without holding
+// the lock.
+type dashAction struct {
+	quit    bool
+	open    *sshtools.Server
+	exec    *sshtools.Server
+	command string
+}
+
+// run shows the dashboard until it is quit.
+func (d *dash) run() (err error) {
+	if d.state, err = sshtools.MakeRaw(d.fd); err != nil {
+		return
+	}
+	stop := make(chan struct{})
+	defer close(stop)
+	defer d.close()
+	fmt.Print(dashScreen)
+
+	go d.watchStatus(stop)
+	resizeCh := make(chan os.Signal, 1)
+	sshtools.NotifyResize(resizeCh, d.fd, stop)
+	defer signal.Stop(resizeCh)
+	go d.watchResize(resizeCh, stop)
+	sigCh := make(chan os.Signal, 1)
+	signal.Notify(sigCh, os.Interrupt, syscall.SIGTERM, syscall.SIGHUP)
+	defer signal.Stop(sigCh)
+	go func() {
+		select {
+		case <-sigCh:
+			d.close()
+			os.Exit(1)
+		case <-stop:
+		}
+	}()
+
+	d.mu.Lock()
+	d.draw()
+	d.mu.Unlock()
+	buf := make([]byte, 4096)
+	for {
+		n, errs := os.Stdin.Read(buf)
+		if n > 0 {
+			action := d.input(buf[:n])
+			switch {
+			case action.quit:
+				return nil
+			case action.open != nil:
+				d.openTab(action.open)
+			case action.exec != nil:
+				d.runExec(action.exec, action.command)
+			}
+		}
+		if errors.Is(errs, io.EOF) {
+			return nil
+		}
+		if errs != nil {
+			return errs
+		}
+	}
+}
+
+// close ends every session and connection and restores the terminal.
+func (d *dash) close() {
+	d.once.Do(func() {
+		d.mu.Lock()
+		d.paused = true
+		tabs, exec := d.tabs, d.exec
+		clients := make([]*sshtools.Client, 0, len(d.clients))
+		for _, client := range d.clients {
+			clients = append(clients, client)
+		}
+		d.mu.Unlock()
+		for _, t := range tabs {
+			t.tab.Close()
+		}
+		if exec != nil {
+			_ = exec.session.Close()
+		}
+		// 等待会话结束并记入历史；服务器不应答时关闭连接使其结束
+		done := make(chan struct{})
+		go func() {
+			d.sessions.Wait()
+			close(done)
+		}()
+		select {
+		case <-done:
+		case <-time.After(dashCloseWait):
+		}
+		for _, client := range clients {
+			_ = client.Close()
+		}
+		<-done
+		fmt.Print("\x1b[0m\x1b[?25h\x1b[?1049l")
+		_ = sshtools.Restore(d.fd, d.state)
+	})
+}
+
+// watchStatus checks every server each interval, or at once after r.
+func (d *dash) watchStatus(stop <-chan struct{}) {
+	for {
+		d.mu.Lock()
+		for _, server := range d.servers {
+			d.checking[server] = true
+		}
+		d.draw()
+		d.mu.Unlock()
+		forEachServer(d.servers, fleetParallel, func(i int, server *sshtools.Server) {
+			status := d.checker.CheckServer(server, false, "")
+			d.mu.Lock()
+			d.status[server] = status
+			delete(d.checking, server)
+			d.draw()
+			d.mu.Unlock()
+		})
+		d.mu.Lock()
+		d.checked = time.Now()
+		d.draw()
+		d.mu.Unlock()
+
+		select {
+		case <-stop:
+			return
+		case <-d.recheck:
+		case <-time.After(d.interval):
+		}
+	}
+}
+
+// watchResize passes new sizes of the terminal on to every session and
+// redraws the dashboard.
+func (d *dash) watchResize(resizeCh <-chan os.Signal, stop <-chan struct{}) {
+	for {
+		select {
+		case <-stop:
+			return
+		case <-resizeCh:
+			width, height := d.size()
+			d.mu.Lock()
+			for _, t := range d.tabs {
+				t.tab.Resize(width, height)
+			}
+			d.draw()
+			d.mu.Unlock()
+		}
+	}
+}
+
+// size returns the terminal size, or 80x24 if it is unknown.
+func (d *dash) size() (width, height int) {
+	width, height, err := term.GetSize(int(os.Stdout.Fd()))
+	if err != nil || width <= 0 || height <= 0 {
+		return 80, 24
+	}
+	return
+}
+
+// input handles a chunk of keyboard input: it goes to the session on
+// screen up to Ctrl-], the rest are dashboard keys.
+func (d *dash) input(p []byte) (action dashAction) {
+	d.mu.Lock()
+	if t := d.active; t != nil {
+		i := bytes.IndexByte(p, dashDetach)
+		d.mu.Unlock()
+		if i < 0 {
+			t.tab.Send(p)
+			return
+		}
+		// 会话自己结束时由 tabExited 回到仪表板
+		t.tab.Send(p[:i])
+		p = p[i+1:]
+		d.mu.Lock()
+		if d.active == t {
+			t.tab.Detach()
+			d.active = nil
+			fmt.Print(dashScreen)
+		}
+	}
+	defer d.mu.Unlock()
+	action = d.handle(p)
+	d.draw()
+	return
+}
+
+// handle applies dashboard keys. The caller holds d.mu.
+func (d *dash) handle(input []byte) (action dashAction) {
+	for i := 0; i < len(input); i++ {
+		b := input[i]
+		if b != 'q' && b != 3 && b != 4 {
+			d.quitting = false
+		}
+		if d.prompting {
+			switch {
+			case b == '\r' || b == '\n':
+				d.prompting = false
+				if command := strings.TrimSpace(string(d.prompt)); command != "" {
+					return dashAction{exec: d.servers[d.cursor], command: command}
+				}
+			case b == 0x1b || b == 3:
+				d.prompting = false
+				if b == 0x1b {
+					i += len(escapeSequence(input[i:])) - 1
+				}
+			case b == 0x7f || b == 0x08:
+				if len(d.prompt) > 0 {
+					d.prompt = d.prompt[:len(d.prompt)-1]
+				}
+			case b == 0x15: // Ctrl-U
+				d.prompt = nil
+			case b < 0x20:
+			default:
+				r, size := utf8.DecodeRune(input[i:])
+				d.prompt = append(d.prompt, r)
+				i += size - 1
+			}
+			continue
+		}
+
+		switch {
+		case b == 0x1b && i < len(input)-1:
+			seq := escapeSequence(input[i:])
+			switch seq {
+			case "\x1b[A", "\x1bOA":
+				d.move(-1)
+			case "\x1b[B", "\x1bOB":
+				d.move(1)
+			case "\x1b[5~":
+				d.move(-d.listHeight())
+			case "\x1b[6~":
+				d.move(d.listHeight())
+			}
+			i += len(seq) - 1
+		case b == 0x1b:
+			// Esc 关闭命令输出，仍在运行时将其结束
+			if d.exec != nil && d.exec.running {
+				_ = d.exec.session.Close()
+			}
+			d.exec, d.message = nil, ""
+		case b == 'k' || b == 0x10: // Ctrl-P
+			d.move(-1)
+		case b == 'j' || b == 0x0e: // Ctrl-N
+			d.move(1)
+		case b == '\r' || b == '\n':
+			return dashAction{open: d.servers[d.cursor]}
+		case b >= '1' && b <= '9':
+			if n := int(b - '1'); n < len(d.tabs) {
+				d.attach(d.tabs[n])
+				return
+			}
+		case b == '\t':
+			if len(d.tabs) > 0 {
+				d.attach(d.tabs[(slices.Index(d.tabs, d.last)+1)%len(d.tabs)])
+				return
+			}
+		case b == 'x':
+			d.prompting, d.prompt = true, nil
+		case b == 'r':
+			select {
+			case d.recheck <- struct{}{}:
+			default:
+			}
+		case b == 'q' || b == 3 || b == 4: // Ctrl-C, Ctrl-D
+			if len(d.tabs) == 0 || d.quitting {
+				return dashAction{quit: true}
+			}
+			d.quitting = true
+		}
+	}
+	return
+}
+
+// move moves the selection by delta, staying within the servers.
+func (d *dash) move(delta int) {
+	d.cursor = max(min(d.cursor+delta, len(d.servers)-1), 0)
+}
+
+// attach shows t instead of the dashboard. The caller holds d.mu.
+func (d *dash) attach(t *dashTab) {
+	d.active, d.last, d.message = t, t, ""
+	width, height := d.size()
+	t.tab.Attach(os.Stdout, width, height)
+}
+
+// client returns the connection to server, connecting first if needed.
+// Connecting happens on the normal screen with the terminal restored, so
+// that passwords and host keys can be asked for as usual.
+func (d *dash) client(server *sshtools.Server) (client *sshtools.Client, err error) {
+	d.mu.Lock()
+	if client = d.clients[server]; client != nil {
+		d.mu.Unlock()
+		return
+	}
+	d.paused = true
+	d.mu.Unlock()
+
+	fmt.Print("\x1b[?1049l")
+	_ = sshtools.Restore(d.fd, d.state)
+	fmt.Printf("Connecting to %s (%s)...\n", server.Alias, server.Addr())
+	client, err = dialServer(d.opts, d.config, server)
+	if _, errs := sshtools.MakeRaw(d.fd); errs != nil && err == nil {
+		err = fmt.Errorf("failed to re-enter raw mode: %v", errs)
+	}
+	fmt.Print(dashScreen)
+
+	d.mu.Lock()
+	d.paused = false
+	if err == nil {
+		d.clients[server] = client
+	}
+	d.mu.Unlock()
+	return
+}
+
+// dropClient forgets a connection that failed, so the next use dials
+// again.
+func (d *dash) dropClient(server *sshtools.Server) {
+	d.mu.Lock()
+	client := d.clients[server]
+	delete(d.clients, server)
+	d.mu.Unlock()
+	if client != nil {
+		_ = client.Close()
+	}
+}
+
+// notify shows a message on the dashboard.
+func (d *dash) notify(format string, args ...any) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.message = fmt.Sprintf(format, args...)
+	d.draw()
+}
+
+// openTab opens a shell on server in a new tab and shows it.
+func (d *dash) openTab(server *sshtools.Server) {
+	started := time.Now()
+	client, err := d.client(server)
+	if err != nil {
+		recordSession(server, started, false, err)
+		d.notify("%s: %v", server.Alias, err)
+		return
+	}
+	session, command, err := client.NewUserSession(server.RemoteCommand)
+	if err != nil {
+		d.dropClient(server)
+		d.notify("%s: failed to create session: %v", server.Alias, err)
+		return
+	}
+	t := &dashTab{server: server, started: started}
+	t.tab = &sshtools.Tab{Name: server.Alias, Session: session, Command: command,
+		TermType: server.TermType(), Modes: server.PTYModes(), OnExit: func(errs error) {
+			d.tabExited(t, errs)
+		}}
+	if d.opts.term != "" {
+		t.tab.TermType = d.opts.term
+	}
+	d.sessions.Add(1)
+	if err = t.tab.Start(d.size()); err != nil {
+		d.sessions.Done()
+		_ = session.Close()
+		d.notify("%s: failed to start the shell: %v", server.Alias, err)
+		return
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.paused {
+		// dash 正在退出，不再等待这个会话
+		t.tab.Close()
+		return
+	}
+	if t.exited {
+		return
+	}
+	d.tabs = append(d.tabs, t)
+	d.attach(t)
+}
+
+// tabExited removes a tab whose session ended, going back to the dashboard
+// if it was on screen.
+func (d *dash) tabExited(t *dashTab, err error) {
+	defer d.sessions.Done()
+	recordSession(t.server, t.started, false, err)
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	t.exited = true
+	d.tabs = slices.DeleteFunc(d.tabs, func(other *dashTab) bool { return other == t })
+	if d.last == t {
+		d.last = nil
+	}
+	var exitErr *ssh.ExitError
+	switch {
+	case errors.As(err, &exitErr):
+		d.message = fmt.Sprintf("%s: session exited with status %d", t.server.Alias, exitErr.ExitStatus())
+	case err != nil && !errors.Is(err, io.EOF):
+		d.message = fmt.Sprintf("%s: %v", t.server.Alias, err)
+	default:
+		d.message = fmt.Sprintf("%s: session closed", t.server.Alias)
+	}
+	if d.active == t {
+		d.active = nil
+		if !d.paused {
+			fmt.Print(dashScreen)
+		}
+	}
+	d.draw()
+}
+
+// runExec runs command on server without a PTY, showing its output below
+// the servers. It replaces the previous quick command, stopping it if it
+// is still running.
+func (d *dash) runExec(server *sshtools.Server, command string) {
+	client, err := d.client(server)
+	if err != nil {
+		d.notify("%s: %v", server.Alias, err)
+		return
+	}
+	session, remote, err := client.NewUserSession(command)
+	if err != nil {
+		d.dropClient(server)
+		d.notify("%s: failed to create session: %v", server.Alias, err)
+		return
+	}
+	e := &dashExec{server: server, command: command, session: session, running: true}
+	session.Stdout = &dashExecOutput{d: d, e: e}
+	session.Stderr = session.Stdout
+
+	d.mu.Lock()
+	if d.paused {
+		d.mu.Unlock()
+		_ = session.Close()
+		return
+	}
+	if d.exec != nil && d.exec.running {
+		_ = d.exec.session.Close()
+	}
+	d.exec, d.message = e, ""
+	d.draw()
+	d.sessions.Add(1)
+	d.mu.Unlock()
+
+	go func() {
+		defer d.sessions.Done()
+		started := time.Now()
+		errs := session.Run(remote)
+		_ = session.Close()
+		res := &sshtools.ExecResult{Alias: server.Alias, Address: server.Addr(), Command: command,
+			DurationMs: time.Since(started).Milliseconds()}
+		d.mu.Lock()
+		e.running = false
+		if e.partial != "" {
+			e.add(e.partial)
+			e.partial = ""
+		}
+		var exitErr *ssh.ExitError
+		switch {
+		case errs == nil:
+			e.result = "exit 0"
+		case errors.As(errs, &exitErr):
+			res.ExitCode = exitErr.ExitStatus()
+			e.result = fmt.Sprintf("exit %d", res.ExitCode)
+		default:
+			res.ExitCode, res.Error = -1, errs.Error()
+			e.result = errs.Error()
+		}
+		if d.exec == e {
+			d.draw()
+		}
+		d.mu.Unlock()
+		recordCommands(started, res)
+	}()
+}
+
+// add appends a line of output, keeping the last dashExecLines.
+func (e *dashExec) add(line string) {
+	line = strings.ReplaceAll(strings.TrimSuffix(line, "\r"), "\t", "    ")
+	e.output = append(e.output, strings.Map(func(r rune) rune {
+		if r < 0x20 || r == 0x7f {
+			return -1
+		}
+		return r
+	}, line))
+	if len(e.output) > dashExecLines {
+		e.output = e.output[len(e.output)-dashExecLines:]
+	}
+}
+
+// dashExecOutput collects the output of a quick command.
+type dashExecOutput struct {
+	d *dash
+	e *dashExec
+}
+
+func (o *dashExecOutput) Write(p []byte) (int, error) {
+	o.d.mu.Lock()
+	defer o.d.mu.Unlock()
+	lines := strings.Split(o.e.partial+string(p), "\n")
+	for _, line := range lines[:len(lines)-1] {
+		o.e.add(line)
+	}
+	o.e.partial = lines[len(lines)-1]
+	if o.d.exec == o.e {
+		o.d.draw()
+	}
+	return len(p), nil
+}
+
+// execHeight is the height of the quick command pane, 0 without one.
+func (d *dash) execHeight(height int) int {
+	if d.exec == nil {
+		return 0
+	}
+	return min(len(d.exec.output)+1, max(height/3, 3))
+}
+
+// listHeight is the number of server rows that fit on the screen.
+func (d *dash) listHeight() int {
+	_, height := d.size()
+	footer := 1
+	if len(d.tabs) > 0 {
+		footer++
+	}
+	return max(height-2-footer-d.execHeight(height), 1)
+}
+
+// draw repaints the dashboard unless a session or a prompt has the
+// screen. The caller holds d.mu.
+func (d *dash) draw() {
+	if d.paused || d.active != nil {
+		return
+	}
+	width, height := d.size()
+	rows := d.listHeight()
+	if d.cursor < d.offset {
+		d.offset = d.cursor
+	} else if d.cursor >= d.offset+rows {
+		d.offset = d.cursor - rows + 1
+	}
+
+	up := 0
+	for _, status := range d.status {
+		if status.Reachable {
+			up++
+		}
+	}
+	header := fmt.Sprintf("sshtools dash  %d servers, %d up", len(d.servers), up)
+	switch {
+	case len(d.checking) > 0:
+		header += fmt.Sprintf(", checking %d", len(d.checking))
+	case !d.checked.IsZero():
+		header += ", checked at " + d.checked.Format("15:04:05")
+	}
+	lines := []string{
+		"\x1b[1m" + fitWidth(header, width) + "\x1b[0m",
+		"\x1b[2m" + fitWidth(fmt.Sprintf("  %-20s %-30s %-6s %9s  %-16s %s", "ALIAS", "ADDRESS", "STATE", "LATENCY", "VERSION", "SESSIONS"), width) + "\x1b[0m",
+	}
+	for i, server := range d.servers[d.offset:min(d.offset+rows, len(d.servers))] {
+		i += d.offset
+		lines = append(lines, d.serverLine(i, server, width))
+	}
+	for len(lines) < rows+2 {
+		lines = append(lines, "")
+	}
+
+	if e := d.exec; e != nil {
+		state := e.result
+		if e.running {
+			state = "running, Esc to stop"
+		}
+		lines = append(lines, "\x1b[2m"+fitWidth(fmt.Sprintf("── %s on %s (%s) ", e.command, e.server.Alias, state)+strings.Repeat("─", width), width)+"\x1b[0m")
+		output := e.output
+		if e.partial != "" {
+			output = append(slices.Clip(output), e.partial)
+		}
+		for _, line := range output[max(len(output)-d.execHeight(height)+1, 0):] {
+			lines = append(lines, fitWidth(line, width))
+		}
+	}
+	if len(d.tabs) > 0 {
+		var names []string
+		for i, t := range d.tabs {
+			name := fmt.Sprintf("[%d] %s", i+1, t.server.Alias)
+			if t == d.last {
+				name = "\x1b[1m" + name + "\x1b[0m"
+			}
+			names = append(names, name)
+		}
+		lines = append(lines, "Sessions: "+strings.Join(names, "  "))
+	}
+
+	cursor := "\x1b[?25l"
+	switch {
+	case d.prompting:
+		prompt := fmt.Sprintf("Run on %s: %s", d.servers[d.cursor].Alias, string(d.prompt))
+		lines = append(lines, fitWidth(prompt, width))
+		cursor = fmt.Sprintf("\x1b[%d;%dH\x1b[?25h", len(lines), min(utf8.RuneCountInString(prompt)+1, width))
+	case d.quitting:
+		lines = append(lines, "\x1b[7m"+fitWidth(fmt.Sprintf("Sessions still open: %d. Press q again to close them and quit.", len(d.tabs)), width)+"\x1b[0m")
+	case d.message != "":
+		lines = append(lines, "\x1b[7m"+fitWidth(d.message, width)+"\x1b[0m")
+	default:
+		lines = append(lines, "\x1b[2m"+fitWidth("↑/↓ select  Enter shell  1-9/Tab switch  Ctrl-] back  ~. close  x run  r check  q quit", width)+"\x1b[0m")
+	}
+	fmt.Printf("\x1b[H\x1b[2J%s%s", strings.Join(lines, "\r\n"), cursor)
+}
+
+// serverLine is the row of server i, with its live status and the numbers
+// of the sessions open on it.
+func (d *dash) serverLine(i int, server *sshtools.Server, width int) string {
+	status, checked := d.status[server]
+	state, color, latency, version := "?", "\x1b[2m", "-", ""
+	switch {
+	case checked && status.Reachable:
+		state, color = "up", "\x1b[32m"
+		latency = fmt.Sprintf("%.1fms", status.LatencyMs)
+		version = strings.TrimPrefix(status.Version, "SSH-2.0-")
+	case checked:
+		state, color, version = "DOWN", "\x1b[31m", status.Error
+	case d.checking[server]:
+		state = "..."
+	}
+	var tabs []string
+	for n, t := range d.tabs {
+		if t.server == server {
+			tabs = append(tabs, fmt.Sprintf("[%d]", n+1))
+		}
+	}
+	line := fitWidth(fmt.Sprintf("  %-20s %-30s %-6s %9s  %-16s %s", server.Alias, server.Addr(), state, latency,
+		fitWidth(version, 16), strings.Join(tabs, " ")), width)
+	if i == d.cursor {
+		return "\x1b[7m" + line + "\x1b[0m"
+	}
+	// 只给状态列上色，别名或地址超出列宽时不上色
+	at := len(fmt.Sprintf("  %-20s %-30s ", server.Alias, server.Addr()))
+	if at+len(state) <= len(line) && line[at:at+len(state)] == state {
+		line = line[:at] + color + state + "\x1b[0m" + line[at+len(state):]
+	}
+	return line
+}
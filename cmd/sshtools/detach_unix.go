@@ -0,0 +1,37 @@
+//go:build !windows
+
+package main
+
+import (
+	"os"
+	"syscall"
+
+	"golang.org/x/sys/unix"
+)
+
+func detachedProcAttr() *syscall.SysProcAttr {
+	return &syscall.SysProcAttr{Setsid: true}
+}
+
+// detachStdio points stdin at /dev/null and stdout/stderr at logPath so a
+// background process no longer holds on to the terminal.
+func detachStdio(logPath string) (err error) {
+	devNull, err := os.Open(os.DevNull)
+	if err != nil {
+		return
+	}
+	defer func() { _ = devNull.Close() }()
+	logFile, err := os.OpenFile(logPath, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o600)
+	if err != nil {
+		return
+	}
+	defer func() { _ = logFile.Close() }()
+
+	if err = unix.Dup2(int(devNull.Fd()), int(os.Stdin.Fd())); err != nil {
+		return
+	}
+	if err = unix.Dup2(int(logFile.Fd()), int(os.Stdout.Fd())); err != nil {
+		return
+	}
+	return unix.Dup2(int(logFile.Fd()), int(os.Stderr.Fd()))
+}
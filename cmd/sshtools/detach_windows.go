@@ -0,0 +1,15 @@
+//go:build windows
+
+package main
+
+import "syscall"
+
+const detachedProcess = 0x00000008
+
+func detachedProcAttr() *syscall.SysProcAttr {
+	return &syscall.SysProcAttr{CreationFlags: detachedProcess}
+}
+
+func detachStdio(logPath string) error {
+	return nil
+}
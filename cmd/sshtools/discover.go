@@ -0,0 +1,139 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/aoaeoe/sshTools/pkg/sshtools"
+)
+
+// discoverTimeout bounds the calls to a provider's API.
+const discoverTimeout = time.Minute
+
+// discoverCommand saves the machines the providers of the config find as
+// servers, refreshing those saved before:
+// sshtools discover              # every provider
+// sshtools discover -prune aws-prod
+func discoverCommand(args []string) {
+	fs := flag.NewFlagSet("discover", flag.ExitOnError)
+	configFlag := fs.String("config", sshtools.DefaultConfigFile(), "Path to the configuration file to save the servers in")
+	dryRunFlag := fs.Bool("n", false, "Only show what would change")
+	pruneFlag := fs.Bool("prune", false, "Remove servers saved from a provider that it no longer finds")
+	names := parseArgs(fs, args)
+
+	opts := commonFlags{configFile: *configFlag, noSecrets: true}
+	config, err := opts.load()
+	if err != nil {
+		exitConfigError(err)
+	}
+	if len(config.Providers) == 0 {
+		fmt.Fprintln(os.Stderr, "Error: no providers configured, add a \"providers\" section to the config file")
+		os.Exit(1)
+	}
+	if len(names) == 0 {
+		for _, p := range config.Providers {
+			names = append(names, p.Name)
+		}
+	}
+
+	for _, name := range names {
+		p := config.ProviderByName(name)
+		if p == nil {
+			fmt.Fprintf(os.Stderr, "Error: no provider named %s in the config\n", name)
+			os.Exit(2)
+		}
+		if err = saveDiscovered(config, *configFlag, p, *dryRunFlag, *pruneFlag); err != nil {
+			fmt.Fprintln(os.Stderr, "Error:", err)
+			os.Exit(exitCode(err, 1))
+		}
+	}
+}
+
+// saveDiscovered adds the servers provider p finds to filename and updates
+// the addresses of those it found before.
+func saveDiscovered(config *sshtools.Config, filename string, p *sshtools.ProviderConfig, dryRun, prune bool) (err error) {
+	ctx, cancel := context.WithTimeout(context.Background(), discoverTimeout)
+	defer cancel()
+	servers, err := sshtools.Discover(ctx, p)
+	if err != nil {
+		return
+	}
+
+	found := map[string]bool{}
+	added, updated, unchanged, removed := 0, 0, 0, 0
+	for i := range servers {
+		server := &servers[i]
+		found[sshtools.AliasKey(server.Alias)] = true
+		existing := config.ServerByAlias(server.Alias)
+		switch {
+		case existing == nil:
+			if !dryRun {
+				if err = sshtools.AppendServer(filename, server); err != nil {
+					return
+				}
+			}
+			added++
+			fmt.Printf("added %s (%s@%s)\n", server.Alias, server.User, server.Addr())
+		case existing.Provider != p.Name:
+			fmt.Printf("skipped %s: alias already configured\n", server.Alias)
+		case existing.Address == server.Address:
+			unchanged++
+		default:
+			if !dryRun {
+				if err = sshtools.SetServerField(filename, existing.Alias, "address", server.Address); err != nil {
+					return
+				}
+			}
+			updated++
+			fmt.Printf("updated %s: %s -> %s\n", existing.Alias, existing.Address, server.Address)
+		}
+	}
+	if prune {
+		for _, s := range config.Servers {
+			if s.Provider != p.Name || found[sshtools.AliasKey(s.Alias)] {
+				continue
+			}
+			if !dryRun {
+				if err = sshtools.RemoveServer(filename, s.Alias); err != nil {
+					return
+				}
+			}
+			removed++
+			fmt.Printf("removed %s: no longer found\n", s.Alias)
+		}
+	}
+
+	summary := "%s: %d added, %d updated, %d unchanged, %d removed\n"
+	if dryRun {
+		summary = "%s: would add %d, update %d, leave %d unchanged and remove %d\n"
+	}
+	fmt.Printf(summary, p.Name, added, updated, unchanged, removed)
+	return
+}
+
+// discoverServers adds the machines the named providers find to config for
+// this run only.
+func discoverServers(config *sshtools.Config, names []string) (err error) {
+	ctx, cancel := context.WithTimeout(context.Background(), discoverTimeout)
+	defer cancel()
+	for _, name := range names {
+		p := config.ProviderByName(strings.TrimSpace(name))
+		if p == nil {
+			return fmt.Errorf("no provider named %s in the config", name)
+		}
+		servers, errs := sshtools.Discover(ctx, p)
+		if errs != nil {
+			return errs
+		}
+		added, errs := config.AddDiscovered(servers)
+		if errs != nil {
+			return errs
+		}
+		dialer.Logf(1, "provider %s found %d servers, %d not configured already", p.Name, len(servers), added)
+	}
+	return
+}
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/aoaeoe/sshTools/pkg/sshtools"
+)
+
+// doctorCommand validates the config file like check, also looks for
+// insecure settings, and suggests a fix for each problem. It exits 1 when
+// it finds errors (or warnings, with -strict), for use in CI:
+// sshtools doctor [-config config.json] [-strict]
+func doctorCommand(args []string) {
+	fs := flag.NewFlagSet("doctor", flag.ExitOnError)
+	configFile := fs.String("config", sshtools.DefaultConfigFile(), "Path to the configuration file")
+	strictFlag := fs.Bool("strict", false, "Exit with an error on warnings too")
+	_ = fs.Parse(args)
+	if fs.NArg() > 0 {
+		fmt.Fprintln(os.Stderr, "usage: sshtools doctor [-config config.json] [-strict]")
+		os.Exit(2)
+	}
+
+	config, problems, err := sshtools.Diagnose(*configFile)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s: %v\n", *configFile, err)
+		os.Exit(1)
+	}
+
+	errors := 0
+	for _, p := range problems {
+		fmt.Printf("%s: %s\n", *configFile, p)
+		if p.Fix != "" {
+			fmt.Printf("    fix: %s\n", p.Fix)
+		}
+		if !p.Warning {
+			errors++
+		}
+	}
+	warnings := len(problems) - errors
+	if len(problems) == 0 {
+		fmt.Printf("%s: %d servers, no problems found\n", *configFile, len(config.Servers))
+		return
+	}
+	fmt.Printf("%s: %d servers, %d errors, %d warnings\n", *configFile, len(config.Servers), errors, warnings)
+	if errors > 0 || *strictFlag && warnings > 0 {
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,220 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/aoaeoe/sshTools/pkg/sshtools"
+	"github.com/pkg/sftp"
+	"golang.org/x/term"
+)
+
+// editCommand edits a remote file with the local $EDITOR over SFTP, or
+// without a path the server's entry in the config file:
+// sshtools edit web1:/etc/nginx/nginx.conf
+// sshtools edit -b web1:/etc/ssh/sshd_config
+// sshtools edit web1
+func editCommand(args []string) {
+	fs := flag.NewFlagSet("edit", flag.ExitOnError)
+	var opts commonFlags
+	opts.register(fs, "edit on")
+	opts.registerBecome(fs)
+	yesFlag := fs.Bool("y", false, "Upload the changes without showing the diff and asking")
+	_ = fs.Parse(args)
+
+	alias, remotePath, ok := strings.Cut(fs.Arg(0), ":")
+	if fs.NArg() != 1 || alias == "" || ok && remotePath == "" {
+		fmt.Fprintln(os.Stderr, "usage: sshtools edit [-b] [-y] <alias>:<path> | <alias>")
+		os.Exit(2)
+	}
+	if !ok {
+		if err := editServer(opts.configFile, alias); err != nil {
+			fmt.Fprintln(os.Stderr, "Error:", err)
+			os.Exit(exitCode(err, 1))
+		}
+		return
+	}
+	config, err := opts.load()
+	if err != nil {
+		exitConfigError(err)
+	}
+	server := selectServer(config, alias, "", opts.tag)
+	if err = editRemoteFile(&opts, config, server, remotePath, *yesFlag); err != nil {
+		fmt.Fprintln(os.Stderr, "Error:", err)
+		os.Exit(exitCode(err, 1))
+	}
+}
+
+func editRemoteFile(opts *commonFlags, config *sshtools.Config, server *sshtools.Server, remotePath string, yes bool) (err error) {
+	client, err := dialServer(opts, config, server)
+	if err != nil {
+		return
+	}
+	defer func(client *sshtools.Client) {
+		_ = client.Close()
+	}(client)
+
+	// -b 时通过 sudo 读写，用于 root 所有的文件
+	var sftpClient *sftp.Client
+	var file *sshtools.RemoteFile
+	if opts.become {
+		client.Become = true
+		if client.BecomePassword, err = becomePassword(server); err != nil {
+			return
+		}
+		if file, err = client.SudoGetFile(remotePath); err != nil {
+			return
+		}
+	} else {
+		if sftpClient, err = client.SFTP(); err != nil {
+			return
+		}
+		defer func() { _ = sftpClient.Close() }()
+		if file, err = sshtools.GetFile(sftpClient, remotePath); err != nil {
+			return
+		}
+	}
+	if !file.Exists && !confirm(fmt.Sprintf("%s:%s does not exist, create it? [y/N] ", server.Alias, remotePath), false) {
+		return errors.New("nothing to edit")
+	}
+	if sftpClient != nil {
+		if err = sshtools.CheckWritable(sftpClient, file); err != nil {
+			return fmt.Errorf("%v; use -b to save it through sudo", err)
+		}
+	}
+
+	// Keep the file name so the editor picks the right syntax.
+	local, err := os.CreateTemp("", "sshtools-*-"+path.Base(remotePath))
+	if err != nil {
+		return
+	}
+	localPath := local.Name()
+	_, err = local.Write(file.Data)
+	if errs := local.Close(); err == nil {
+		err = errs
+	}
+	if err != nil {
+		return
+	}
+	keep := false
+	defer func() {
+		if !keep {
+			_ = os.Remove(localPath)
+		}
+	}()
+
+	if err = runEditor(localPath); err != nil {
+		keep = true
+		return fmt.Errorf("editor failed: %v (your copy is at %s)", err, localPath)
+	}
+	edited, err := os.ReadFile(localPath)
+	if err != nil {
+		return
+	}
+	if file.Exists && bytes.Equal(edited, file.Data) {
+		fmt.Println("No changes.")
+		return
+	}
+	if !yes && term.IsTerminal(int(os.Stdin.Fd())) {
+		name := server.Alias + ":" + remotePath
+		printDiff(sshtools.UnifiedDiff(name, name+" (edited)", file.Data, edited))
+		if !confirm(fmt.Sprintf("Upload the changes to %s? [Y/n] ", name), true) {
+			keep = true
+			fmt.Printf("Not uploaded, your copy is at %s\n", localPath)
+			return
+		}
+	}
+
+	if sftpClient != nil {
+		err = sshtools.PutFile(sftpClient, file, edited)
+	} else {
+		err = client.SudoPutFile(file, edited)
+	}
+	if errors.Is(err, sshtools.ErrRemoteChanged) {
+		keep = true
+		saved := saveElsewhere(localPath)
+		return fmt.Errorf("%s:%s was changed by someone else while you were editing, not overwriting it; your version is at %s", server.Alias, remotePath, saved)
+	}
+	if err != nil {
+		keep = true
+		return fmt.Errorf("%v (your copy is at %s)", err, localPath)
+	}
+	fmt.Printf("Saved %s:%s\n", server.Alias, remotePath)
+	return
+}
+
+// printDiff prints a unified diff, in color on a terminal unless NO_COLOR
+// is set.
+func printDiff(diff string) {
+	color := term.IsTerminal(int(os.Stdout.Fd())) && os.Getenv("NO_COLOR") == ""
+	for _, line := range strings.SplitAfter(diff, "\n") {
+		code := ""
+		switch {
+		case !color:
+		case strings.HasPrefix(line, "---"), strings.HasPrefix(line, "+++"):
+			code = "1"
+		case strings.HasPrefix(line, "@@"):
+			code = "36"
+		case strings.HasPrefix(line, "-"):
+			code = "31"
+		case strings.HasPrefix(line, "+"):
+			code = "32"
+		}
+		if code != "" {
+			line = "\x1b[" + code + "m" + strings.TrimSuffix(line, "\n") + "\x1b[0m\n"
+		}
+		fmt.Print(line)
+	}
+}
+
+// runEditor opens path in $VISUAL, $EDITOR or vi. The variable may contain
+// arguments, so it goes through the shell.
+func runEditor(path string) error {
+	editor := os.Getenv("VISUAL")
+	if editor == "" {
+		editor = os.Getenv("EDITOR")
+	}
+	if editor == "" {
+		editor = "vi"
+	}
+	cmd := exec.Command("sh", "-c", editor+` "$1"`, "sshtools", path)
+	cmd.Stdin, cmd.Stdout, cmd.Stderr = os.Stdin, os.Stdout, os.Stderr
+	return cmd.Run()
+}
+
+// saveElsewhere offers to move the edited copy to a path of the user's
+// choosing and returns where it ended up.
+func saveElsewhere(localPath string) string {
+	fmt.Printf("Save your version to [%s]: ", localPath)
+	var dest string
+	_, _ = fmt.Scanln(&dest)
+	if dest = strings.TrimSpace(dest); dest == "" {
+		return localPath
+	}
+	if expanded, err := sshtools.ExpandPath(dest); err == nil {
+		dest = expanded
+	}
+	if abs, err := filepath.Abs(dest); err == nil {
+		dest = abs
+	}
+	if err := os.Rename(localPath, dest); err != nil {
+		// Probably another filesystem; copy instead.
+		data, errs := os.ReadFile(localPath)
+		if errs == nil {
+			errs = os.WriteFile(dest, data, 0o600)
+		}
+		if errs != nil {
+			fmt.Fprintln(os.Stderr, "Error:", errs)
+			return localPath
+		}
+		_ = os.Remove(localPath)
+	}
+	return dest
+}
@@ -0,0 +1,353 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aoaeoe/sshTools/pkg/sshtools"
+	"golang.org/x/term"
+)
+
+// execCommand runs a single command without a shell, on one server or
+// several at once:
+// sshtools exec -alias web1 [-o json|jsonl] -- uptime
+// sshtools exec 'web-*' [-dry-run] -- uptime
+// sshtools exec -hosts web1,web2,web3 [-concurrency 5] -- uptime
+// sshtools exec -alias web1 -q -clean -- cat file > local
+func execCommand(args []string) {
+	fs := flag.NewFlagSet("exec", flag.ExitOnError)
+	var opts commonFlags
+	var fleet fleetFlags
+	opts.register(fs, "run the command on")
+	opts.registerEnv(fs)
+	opts.registerBecome(fs)
+	opts.registerQuiet(fs)
+	fleet.register(fs)
+	cleanFlag := fs.Bool("clean", false, "Drop what the remote login shell prints before the command runs (MOTD, banners from rc files); needs a POSIX shell")
+	concurrencyFlag := fs.Int("concurrency", fleetParallel, "With -hosts or -tag, run on at most this many servers at once")
+	outputFlag := fs.String("o", "text", "Output format: text, json or jsonl (one compact object per server and line)")
+	fs.StringVar(outputFlag, "output", "text", "Same as -o")
+	maxOutputFlag := fs.String("max-output", "", "Stop capturing output after this size, e.g. 10M (default 10M for json, unlimited for text)")
+	killFlag := fs.Bool("kill-on-truncate", false, "Kill the remote command once -max-output is reached")
+	killAfterFlag := fs.Duration("kill-after", 0, "After forwarding Ctrl+C or another signal, kill the remote command if it is still running this long later")
+	timeoutFlag := fs.Duration("command-timeout", 0, "Kill the remote command and report a timeout when it runs longer than this (default: command_timeout)")
+	stdinFileFlag := fs.String("stdin-file", "", "Send this file to the command's stdin instead of local stdin; with several servers, to each of them")
+	_ = fs.Parse(args)
+	// 第一个参数后跟 -- 时是目标：别名或 'web-*' 这样的模式
+	rest := fs.Args()
+	consumed := len(args) > len(rest) && args[len(args)-len(rest)-1] == "--"
+	if !consumed && slices.Index(rest, "--") > 0 && opts.alias == "" && opts.ip == "" && !fleet.selected(&opts) {
+		fleet.target(&opts, rest[0])
+		_ = fs.Parse(rest[1:])
+	}
+
+	command := strings.Join(fs.Args(), " ")
+	if command == "" {
+		fmt.Fprintln(os.Stderr, "usage: sshtools exec (<alias> | <pattern> | -alias <alias> | -hosts <a,b,...> | -tag <tag> | -match <regexp>) [flags] -- <command>")
+		os.Exit(2)
+	}
+	if *outputFlag != "text" && *outputFlag != "json" && *outputFlag != "jsonl" {
+		fmt.Fprintf(os.Stderr, "unknown output format %q\n", *outputFlag)
+		os.Exit(2)
+	}
+	if *stdinFileFlag != "" && opts.become {
+		fmt.Fprintln(os.Stderr, "Error: -stdin-file cannot be used with -b, stdin carries the sudo password")
+		os.Exit(2)
+	}
+	if *stdinFileFlag != "" {
+		if _, errs := os.Stat(*stdinFileFlag); errs != nil {
+			fmt.Fprintln(os.Stderr, "Error:", errs)
+			os.Exit(2)
+		}
+	}
+	maxOutput, err := sshtools.ParseSize(*maxOutputFlag)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Error:", err)
+		os.Exit(2)
+	}
+	if *maxOutputFlag == "" && *outputFlag != "text" {
+		maxOutput = sshtools.DefaultMaxCapture
+	}
+
+	config, err := opts.load()
+	if err != nil {
+		exitConfigError(err)
+	}
+	if fleet.selected(&opts) || fleet.dryRun {
+		execFleet(&opts, &fleet, config, command, fleetExecOptions{
+			parallel: *concurrencyFlag,
+			json:     *outputFlag != "text",
+			lines:    *outputFlag == "jsonl",
+			max:      maxOutput,
+			kill:     *killFlag,
+			clean:    *cleanFlag,
+			grace:    *killAfterFlag,
+			timeout:  *timeoutFlag,
+			stdin:    *stdinFileFlag,
+		})
+		return
+	}
+	server := selectServer(config, opts.alias, opts.ip, opts.tag)
+
+	started := time.Now()
+	client, err := dialServer(&opts, config, server)
+	if err != nil {
+		recordCommands(started, &sshtools.ExecResult{Alias: server.Alias, Address: server.Addr(), Command: command, ExitCode: -1, Error: err.Error()})
+		fmt.Fprintln(os.Stderr, "Error:", err)
+		os.Exit(exitCode(err, exitConnect))
+	}
+	defer func(client *sshtools.Client) {
+		_ = client.Close()
+	}(client)
+	client.SkipPreamble = *cleanFlag
+	client.ForwardSignals, client.KillAfter = true, *killAfterFlag
+	client.CommandTimeout = *timeoutFlag
+	if opts.become {
+		client.Become = true
+		if client.BecomePassword, err = becomePassword(server); err != nil {
+			fmt.Fprintln(os.Stderr, "Error:", err)
+			os.Exit(exitCode(err, exitConnect))
+		}
+	}
+
+	// 标准输入是管道或文件时转发给远程命令
+	if *stdinFileFlag != "" {
+		file, errs := os.Open(*stdinFileFlag)
+		if errs != nil {
+			_ = client.Close()
+			fmt.Fprintln(os.Stderr, "Error:", errs)
+			os.Exit(2)
+		}
+		defer func() { _ = file.Close() }()
+		client.Stdin = file
+	} else if !term.IsTerminal(int(os.Stdin.Fd())) && !opts.become {
+		client.Stdin = os.Stdin
+	}
+
+	var res *sshtools.ExecResult
+	if *outputFlag != "text" {
+		res = client.Capture(command, maxOutput, *killFlag)
+		printResult(res, *outputFlag == "jsonl")
+	} else {
+		res = client.Exec(command, os.Stdout, os.Stderr, maxOutput, *killFlag)
+		printTruncation(res, maxOutput)
+		if res.Error != "" {
+			fmt.Fprintln(os.Stderr, "Error:", res.Error)
+		}
+	}
+
+	_ = client.Close()
+	recordCommands(started, res)
+	os.Exit(resultStatus(res))
+}
+
+// fleetExecOptions are the exec flags that apply to each server of a
+// fleet run.
+type fleetExecOptions struct {
+	parallel int
+	json     bool
+	max      int64
+	kill     bool
+	// clean drops the output of the remote login shell that precedes the
+	// command's.
+	clean bool
+	// grace is how long the command may run on after a forwarded signal
+	// before it is killed, 0 for as long as it likes.
+	grace time.Duration
+	// timeout overrides the servers' command_timeout when set.
+	timeout time.Duration
+	// stdin is a file sent to the stdin of the command on every server.
+	stdin string
+	// lines prints each server's result as one line of JSON (jsonl) as
+	// soon as it is known, rather than a list at the end.
+	lines bool
+	// script is run instead of the command when set, by run-script.
+	script *sshtools.Script
+	// render gives each server its own command when set, by run.
+	render func(server *sshtools.Server) (string, error)
+}
+
+// execFleet runs command on every selected server, parallel at a time.
+// Output lines are prefixed with the alias, and a summary of exit codes
+// follows. The exit code is 0 when the command succeeded everywhere.
+func execFleet(opts *commonFlags, fleet *fleetFlags, config *sshtools.Config, command string, o fleetExecOptions) {
+	servers, err := fleet.servers(config, opts)
+	if err != nil || len(servers) == 0 {
+		if err == nil {
+			err = fmt.Errorf("no server matched")
+		}
+		fmt.Fprintln(os.Stderr, "Error:", err)
+		os.Exit(exitCode(err, 1))
+	}
+	// 命令模板按每台服务器的变量展开，任何一台展开失败时都不执行
+	commands := make([]string, len(servers))
+	for i, server := range servers {
+		commands[i] = command
+		if o.render != nil {
+			if commands[i], err = o.render(server); err != nil {
+				fmt.Fprintln(os.Stderr, "Error:", err)
+				os.Exit(2)
+			}
+		}
+	}
+	// 先依次取得 sudo 密码，避免并发提示
+	passwords := make([]string, len(servers))
+	if opts.become {
+		for i, server := range servers {
+			if passwords[i], err = becomePassword(server); err != nil {
+				fmt.Fprintln(os.Stderr, "Error:", err)
+				os.Exit(exitCode(err, exitConnect))
+			}
+		}
+	}
+
+	name := "exec"
+	switch {
+	case o.script != nil:
+		name = "run-script"
+	case o.render != nil:
+		name = "run"
+	}
+	inhibitor := opts.preventSleep("sshtools " + name)
+	defer inhibitor.Release()
+
+	width := 0
+	for _, server := range servers {
+		width = max(width, len(server.Alias))
+	}
+	var outMu, errMu sync.Mutex
+	start := time.Now()
+	results := make([]*sshtools.ExecResult, len(servers))
+	forEachServer(servers, o.parallel, func(i int, server *sshtools.Server) {
+		command := commands[i]
+		if o.lines {
+			defer func() {
+				outMu.Lock()
+				defer outMu.Unlock()
+				printResult(results[i], true)
+			}()
+		}
+		client, errs := dialServer(opts, config, server)
+		if errs != nil {
+			results[i] = &sshtools.ExecResult{Alias: server.Alias, Address: server.Addr(), Command: command, ExitCode: -1, Error: errs.Error()}
+			return
+		}
+		defer func() {
+			_ = client.Close()
+		}()
+		client.Become, client.BecomePassword = opts.become, passwords[i]
+		client.SkipPreamble = o.clean
+		client.ForwardSignals, client.KillAfter = true, o.grace
+		client.CommandTimeout = o.timeout
+		if o.stdin != "" {
+			file, errs := os.Open(o.stdin)
+			if errs != nil {
+				results[i] = &sshtools.ExecResult{Alias: server.Alias, Address: server.Addr(), Command: command, ExitCode: -1, Error: errs.Error()}
+				return
+			}
+			defer func() { _ = file.Close() }()
+			client.Stdin = file
+		}
+		switch {
+		case o.json && o.script != nil:
+			results[i] = client.CaptureScript(o.script, o.max, o.kill)
+			return
+		case o.json:
+			results[i] = client.Capture(command, o.max, o.kill)
+			return
+		}
+		prefix := fmt.Sprintf("%-*s | ", width, server.Alias)
+		stdout := sshtools.NewPrefixWriter(os.Stdout, &outMu, prefix)
+		stderr := sshtools.NewPrefixWriter(os.Stderr, &errMu, prefix)
+		if o.script != nil {
+			results[i] = client.RunScript(o.script, stdout, stderr, o.max, o.kill)
+		} else {
+			results[i] = client.Exec(command, stdout, stderr, o.max, o.kill)
+		}
+		_ = stdout.Flush()
+		_ = stderr.Flush()
+	})
+
+	// 脚本没有可重新执行的命令行，不记入历史
+	if o.script == nil {
+		recordCommands(start, results...)
+	}
+	failures := 0
+	for _, res := range results {
+		if res.ExitCode != 0 {
+			failures++
+		}
+	}
+	switch {
+	case o.lines:
+	case o.json:
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		_ = encoder.Encode(results)
+	case opts.quiet:
+	default:
+		fmt.Fprintln(os.Stderr)
+		for _, res := range results {
+			switch {
+			case res.TimedOut:
+				fmt.Fprintf(os.Stderr, "  %-*s TIMEOUT %s\n", width, res.Alias, res.Error)
+			case res.Error != "":
+				fmt.Fprintf(os.Stderr, "  %-*s FAILED  %s\n", width, res.Alias, res.Error)
+			case res.Truncated:
+				fmt.Fprintf(os.Stderr, "  %-*s exit %d  (output truncated)\n", width, res.Alias, res.ExitCode)
+			default:
+				fmt.Fprintf(os.Stderr, "  %-*s exit %d\n", width, res.Alias, res.ExitCode)
+			}
+		}
+		fmt.Fprintf(os.Stderr, "%d succeeded, %d failed.\n", len(results)-failures, failures)
+	}
+	notifier.Done(name, len(servers), time.Since(start), failures)
+	if failures > 0 {
+		os.Exit(1)
+	}
+}
+
+func printTruncation(res *sshtools.ExecResult, max int64) {
+	if !res.Truncated {
+		return
+	}
+	switch {
+	case res.Killed:
+		fmt.Fprintf(os.Stderr, "sshtools: output truncated at %d bytes, remote command killed\n", max)
+	default:
+		fmt.Fprintf(os.Stderr, "sshtools: output truncated at %d bytes (command produced %d bytes)\n", max, res.OriginalSize)
+	}
+}
+
+// printResult prints res as indented JSON, or with lines on a single line.
+func printResult(res *sshtools.ExecResult, lines bool) {
+	encoder := json.NewEncoder(os.Stdout)
+	if !lines {
+		encoder.SetIndent("", "  ")
+	}
+	_ = encoder.Encode(res)
+}
+
+// resultStatus is the exit status for a single command's result: exitTimeout
+// when it was killed for running too long, its exit code otherwise.
+func resultStatus(res *sshtools.ExecResult) int {
+	if res.TimedOut {
+		return exitTimeout
+	}
+	return exitStatus(res.ExitCode)
+}
+
+// exitStatus maps a remote exit code to ours; like ssh, 255 means the
+// command's status is unknown.
+func exitStatus(code int) int {
+	if code < 0 || code > 255 {
+		return 255
+	}
+	return code
+}
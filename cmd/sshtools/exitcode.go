@@ -0,0 +1,48 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/aoaeoe/sshTools/pkg/sshtools"
+	"golang.org/x/crypto/ssh"
+)
+
+// Exit statuses for failures of sshtools itself. They sit just below 255,
+// which like ssh means any other connection failure, so that commands
+// passing a remote status through rarely collide with them.
+const (
+	exitConfig  = 251
+	exitAuth    = 252
+	exitTimeout = 253
+	exitHostKey = 254
+	exitConnect = 255
+)
+
+// exitCode returns the exit status for err: the status of a remote command
+// that exited non-zero, that of the kind of failure, or fallback.
+func exitCode(err error, fallback int) int {
+	var exit *ssh.ExitError
+	if errors.As(err, &exit) {
+		return exitStatus(exit.ExitStatus())
+	}
+	switch sshtools.KindOf(err) {
+	case sshtools.ErrorConfig:
+		return exitConfig
+	case sshtools.ErrorAuth:
+		return exitAuth
+	case sshtools.ErrorTimeout:
+		return exitTimeout
+	case sshtools.ErrorHostKey:
+		return exitHostKey
+	}
+	return fallback
+}
+
+// exitConfigError reports a config that failed to load and exits, with
+// exitConfig unless a provider's discovery failed in another way.
+func exitConfigError(err error) {
+	fmt.Fprintln(os.Stderr, "Error loading config:", err)
+	os.Exit(exitCode(err, exitConfig))
+}
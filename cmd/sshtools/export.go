@@ -0,0 +1,56 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/aoaeoe/sshTools/pkg/sshtools"
+)
+
+// exportCommand writes the servers in a format other tools read:
+// sshtools export -format ssh_config > ~/.ssh/sshtools.conf
+// sshtools export -format ssh_config -tag web -o web.conf
+func exportCommand(args []string) {
+	fs := flag.NewFlagSet("export", flag.ExitOnError)
+	var opts commonFlags
+	fs.StringVar(&opts.configFile, "config", sshtools.DefaultConfigFile(), "Path to the configuration file")
+	opts.noSecrets = true
+	formatFlag := fs.String("format", "ssh_config", "Output format: ssh_config")
+	tagFlag := fs.String("tag", "", "Only export servers with this tag")
+	outputFlag := fs.String("o", "", "Write to this file instead of standard output")
+	_ = fs.Parse(args)
+	if fs.NArg() > 0 {
+		fmt.Fprintln(os.Stderr, "usage: sshtools export [-format ssh_config] [-tag tag] [-o file]")
+		os.Exit(2)
+	}
+	if *formatFlag != "ssh_config" {
+		fmt.Fprintf(os.Stderr, "unknown export format %q\n", *formatFlag)
+		os.Exit(2)
+	}
+
+	config, err := opts.load()
+	if err != nil {
+		exitConfigError(err)
+	}
+	var servers []*sshtools.Server
+	for i := range config.Servers {
+		if *tagFlag == "" || config.Servers[i].HasTag(*tagFlag) {
+			servers = append(servers, &config.Servers[i])
+		}
+	}
+
+	var out bytes.Buffer
+	fmt.Fprintf(&out, "# Generated by sshtools export from %s, changes here are overwritten.\n\n", opts.configFile)
+	_ = sshtools.ExportSSHConfig(&out, servers)
+	if *outputFlag == "" {
+		_, _ = os.Stdout.Write(out.Bytes())
+		return
+	}
+	if err = os.WriteFile(*outputFlag, out.Bytes(), 0o600); err != nil {
+		fmt.Fprintln(os.Stderr, "Error:", err)
+		os.Exit(1)
+	}
+	fmt.Printf("Exported %d server(s) to %s.\n", len(servers), *outputFlag)
+}
@@ -0,0 +1,153 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/aoaeoe/sshTools/pkg/sshtools"
+)
+
+// factsCommand logs in to the selected servers, every active one by
+// default, and reports their OS, kernel, CPUs, memory, disks and uptime:
+// sshtools facts -tag prod
+// sshtools facts -hosts web1,web2 -o json
+func factsCommand(args []string) {
+	fs := flag.NewFlagSet("facts", flag.ExitOnError)
+	var opts commonFlags
+	var fleet fleetFlags
+	opts.register(fs, "gather facts from")
+	fleet.register(fs)
+	concurrencyFlag := fs.Int("concurrency", fleetParallel, "Gather from at most this many servers at once")
+	outputFlag := fs.String("o", "text", "Output format: text or json")
+	_ = fs.Parse(args)
+	if *outputFlag != "text" && *outputFlag != "json" {
+		fmt.Fprintf(os.Stderr, "unknown output format %q\n", *outputFlag)
+		os.Exit(2)
+	}
+	if fs.NArg() > 0 {
+		fmt.Fprintln(os.Stderr, "usage: sshtools facts [-tag <tag> | -hosts <a,b,...> | -alias <pattern>] [-o json]")
+		os.Exit(2)
+	}
+
+	config, err := opts.load()
+	if err != nil {
+		exitConfigError(err)
+	}
+	var servers []*sshtools.Server
+	if !fleet.selected(&opts) && opts.alias == "" && opts.ip == "" {
+		servers = config.ActiveServers(fleet.includeDeprecated)
+		fleet.preview(servers)
+	} else if servers, err = fleet.servers(config, &opts); err != nil {
+		fmt.Fprintln(os.Stderr, "Error:", err)
+		os.Exit(2)
+	}
+	if len(servers) == 0 {
+		fmt.Fprintln(os.Stderr, "Error: no server matched")
+		os.Exit(1)
+	}
+
+	inhibitor := opts.preventSleep("sshtools facts")
+	defer inhibitor.Release()
+	results := make([]sshtools.Facts, len(servers))
+	forEachServer(servers, *concurrencyFlag, func(i int, server *sshtools.Server) {
+		results[i] = sshtools.Facts{Alias: server.Alias, Address: server.Addr()}
+		client, errs := dialServer(&opts, config, server)
+		if errs != nil {
+			results[i].Error = errs.Error()
+			return
+		}
+		defer func() {
+			_ = client.Close()
+		}()
+		if results[i], errs = client.GatherFacts(); errs != nil {
+			results[i].Error = fmt.Sprintf("failed to gather facts: %v", errs)
+		}
+	})
+
+	failed := 0
+	for _, f := range results {
+		if f.Error != "" {
+			failed++
+		}
+	}
+	if *outputFlag == "json" {
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		_ = encoder.Encode(results)
+	} else {
+		printFactsTable(results)
+	}
+	if failed > 0 {
+		os.Exit(1)
+	}
+}
+
+// printFactsTable prints a line per server. Memory is used of total, and
+// the disk column is the root filesystem plus the fullest other one when
+// it is fuller.
+func printFactsTable(results []sshtools.Facts) {
+	width := len("ALIAS")
+	for _, f := range results {
+		width = max(width, len(f.Alias))
+	}
+	fmt.Printf("%-*s %-28s %-24s %5s %-19s %-8s %s\n", width, "ALIAS", "OS", "KERNEL", "CPUS", "MEMORY", "UPTIME", "DISK")
+	for _, f := range results {
+		if f.Error != "" {
+			fmt.Printf("%-*s FAILED  %s\n", width, f.Alias, f.Error)
+			continue
+		}
+		cpus, memory, uptime := "-", "-", "-"
+		if f.CPUs > 0 {
+			cpus = fmt.Sprint(f.CPUs)
+		}
+		if f.MemoryTotal > 0 {
+			memory = sshtools.FormatBytes(float64(f.MemoryTotal))
+			if f.MemoryAvailable > 0 {
+				memory = sshtools.FormatBytes(float64(f.MemoryTotal-f.MemoryAvailable)) + "/" + memory
+			}
+		}
+		if f.UptimeSeconds > 0 {
+			uptime = formatUptime(time.Duration(f.UptimeSeconds * float64(time.Second)))
+		}
+		line := fmt.Sprintf("%-*s %-28s %-24s %5s %-19s %-8s %s", width, f.Alias, f.OS,
+			strings.TrimSpace(f.Kernel+" "+f.Arch), cpus, memory, uptime, factsDisk(&f))
+		fmt.Println(strings.TrimRight(line, " "))
+	}
+}
+
+// factsDisk summarises disk usage for the table, e.g. "/ 41% /data 93%".
+func factsDisk(f *sshtools.Facts) string {
+	var parts []string
+	root := f.Disk("/")
+	if root != nil {
+		parts = append(parts, fmt.Sprintf("/ %.0f%%", root.Percent()))
+	}
+	var fullest *sshtools.DiskUsage
+	for i := range f.Disks {
+		d := &f.Disks[i]
+		if d.Mount != "/" && (fullest == nil || d.Percent() > fullest.Percent()) {
+			fullest = d
+		}
+	}
+	if fullest != nil && (root == nil || fullest.Percent() > root.Percent()) {
+		parts = append(parts, fmt.Sprintf("%s %.0f%%", fullest.Mount, fullest.Percent()))
+	}
+	if len(parts) == 0 {
+		return "-"
+	}
+	return strings.Join(parts, " ")
+}
+
+// formatUptime formats d as days and hours, or hours and minutes when
+// shorter than a day.
+func formatUptime(d time.Duration) string {
+	days := int(d.Hours()) / 24
+	if days > 0 {
+		return fmt.Sprintf("%dd%dh", days, int(d.Hours())%24)
+	}
+	return fmt.Sprintf("%dh%dm", int(d.Hours()), int(d.Minutes())%60)
+}
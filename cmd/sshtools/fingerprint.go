@@ -0,0 +1,66 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/aoaeoe/sshTools/pkg/sshtools"
+	"golang.org/x/crypto/ssh"
+)
+
+// fingerprintCommand prints the host key fingerprints a server offers, for
+// pinning them in host_key_fingerprint:
+// sshtools fingerprint -alias web1
+func fingerprintCommand(args []string) {
+	fs := flag.NewFlagSet("fingerprint", flag.ExitOnError)
+	var opts commonFlags
+	opts.register(fs, "fetch the host keys of")
+	sshfpFlag := fs.Bool("sshfp", false, "Print SSHFP records for the DNS zone instead, for verify_host_key_dns")
+	_ = fs.Parse(args)
+
+	config, err := opts.load()
+	if err != nil {
+		exitConfigError(err)
+	}
+	server := selectServer(config, opts.alias, opts.ip, opts.tag)
+
+	keys, err := dialer.FetchHostKeys(server)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Error:", err)
+		os.Exit(exitCode(err, 1))
+	}
+	if *sshfpFlag {
+		for _, key := range keys {
+			for _, line := range sshtools.SSHFPRecords(server.Address+".", key) {
+				fmt.Println(line)
+			}
+		}
+		return
+	}
+	for _, key := range keys {
+		fmt.Printf("%s %s\n", key.Type(), ssh.FingerprintSHA256(key))
+	}
+	if server.HostKeyFingerprint != "" {
+		fmt.Printf("pinned: %s\n", server.HostKeyFingerprint)
+	}
+}
+
+// pinHostKey offers to save the host key of a server that has none pinned
+// into the config file.
+func pinHostKey(opts *commonFlags, config *sshtools.Config, client *sshtools.Client) {
+	server := client.Server
+	if server.HostKeyFingerprint != "" || client.HostKey == nil || config.ServerByAlias(server.Alias) != server {
+		return
+	}
+	pin := client.HostKey.Type() + " " + ssh.FingerprintSHA256(client.HostKey)
+	if !confirm(fmt.Sprintf("Pin host key %s for %s in %s? [y/N] ", pin, server.Alias, opts.configFile), false) {
+		return
+	}
+	if err := sshtools.SetServerField(opts.configFile, server.Alias, "host_key_fingerprint", pin); err != nil {
+		fmt.Fprintln(os.Stderr, "Error:", err)
+		return
+	}
+	server.HostKeyFingerprint = pin
+	fmt.Printf("Pinned the host key of %s.\n", server.Alias)
+}
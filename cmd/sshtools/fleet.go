@@ -0,0 +1,133 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"regexp"
+	"slices"
+	"strings"
+	"sync"
+
+	"github.com/aoaeoe/sshTools/pkg/sshtools"
+)
+
+// fleetParallel bounds how many servers a fleet operation talks to at once.
+const fleetParallel = 10
+
+// fleetFlags select the servers of a fleet-wide operation, together with
+// the -alias, -ip and -tag of commonFlags.
+type fleetFlags struct {
+	hosts             string
+	match             string
+	includeDeprecated bool
+	dryRun            bool
+	// pattern is a glob given in place of an alias, see target.
+	pattern string
+}
+
+func (f *fleetFlags) register(fs *flag.FlagSet) {
+	fs.StringVar(&f.hosts, "hosts", "", "Select these servers, by comma-separated aliases")
+	fs.StringVar(&f.match, "match", "", "Select the servers whose alias or address matches this regular expression as a whole")
+	fs.BoolVar(&f.includeDeprecated, "include-deprecated", false, "Include servers marked deprecated")
+	fs.BoolVar(&f.dryRun, "dry-run", false, "Only print the servers that would be selected")
+}
+
+// selected reports whether servers are chosen as a fleet: by -tag, -hosts,
+// -match or a target pattern.
+func (f *fleetFlags) selected(opts *commonFlags) bool {
+	return opts.tag != "" || f.hosts != "" || f.match != "" || f.pattern != ""
+}
+
+// target takes a server given as an argument: a glob such as 'web-*'
+// selects every matching server, anything else is an alias.
+func (f *fleetFlags) target(opts *commonFlags, target string) {
+	if sshtools.IsPattern(target) {
+		f.pattern = target
+	} else {
+		opts.alias = target
+	}
+}
+
+// servers returns the servers chosen by -hosts, or by a target pattern or
+// an -alias / -ip pattern, -match and -tag; all must match when given
+// together. With -dry-run it lists them and exits instead.
+func (f *fleetFlags) servers(config *sshtools.Config, opts *commonFlags) (servers []*sshtools.Server, err error) {
+	if servers, err = f.choose(config, opts); err == nil {
+		f.preview(servers)
+	}
+	return
+}
+
+// preview lists servers and exits when -dry-run is set.
+func (f *fleetFlags) preview(servers []*sshtools.Server) {
+	if !f.dryRun {
+		return
+	}
+	if len(servers) == 0 {
+		fmt.Fprintln(os.Stderr, "No server matched.")
+		os.Exit(1)
+	}
+	for _, server := range servers {
+		fmt.Printf("%s\t%s@%s\n", server.Alias, server.User, server.Addr())
+	}
+	os.Exit(0)
+}
+
+func (f *fleetFlags) choose(config *sshtools.Config, opts *commonFlags) (servers []*sshtools.Server, err error) {
+	// -match 单独使用时从全部服务器中选择，与其他条件一起时再过滤
+	var byRegexp []*sshtools.Server
+	if f.match != "" {
+		re, errs := regexp.Compile(f.match)
+		if errs != nil {
+			return nil, fmt.Errorf("invalid -match: %v", errs)
+		}
+		byRegexp = config.MatchRegexp(re)
+	}
+	var matched []*sshtools.Server
+	switch {
+	case f.hosts != "":
+		for _, alias := range strings.Split(f.hosts, ",") {
+			server := config.ServerByAlias(strings.TrimSpace(alias))
+			if server == nil {
+				return nil, fmt.Errorf("unknown alias %q in -hosts", strings.TrimSpace(alias))
+			}
+			servers = append(servers, server)
+		}
+		return
+	case f.pattern != "":
+		matched = config.MatchPattern(f.pattern)
+	case opts.alias != "":
+		matched = config.MatchAlias(opts.alias)
+	case opts.ip != "":
+		matched = config.MatchAddress(opts.ip)
+	case f.match != "":
+		matched = byRegexp
+	case opts.tag != "":
+		return config.ServersByTag(opts.tag, f.includeDeprecated), nil
+	default:
+		return nil, errors.New("select servers with -tag, -hosts, -match, -alias, -ip or a pattern such as 'web-*'")
+	}
+	for _, server := range matched {
+		if (!server.Deprecated || f.includeDeprecated) && (opts.tag == "" || server.HasTag(opts.tag)) &&
+			(f.match == "" || slices.Contains(byRegexp, server)) {
+			servers = append(servers, server)
+		}
+	}
+	return
+}
+
+// forEachServer runs fn for every server, parallel at a time.
+func forEachServer(servers []*sshtools.Server, parallel int, fn func(i int, server *sshtools.Server)) {
+	var wg sync.WaitGroup
+	sem := make(chan struct{}, max(parallel, 1))
+	for i, server := range servers {
+		sem <- struct{}{}
+		wg.Go(func() {
+			defer func() { <-sem }()
+			fn(i, server)
+		})
+	}
+	wg.Wait()
+}
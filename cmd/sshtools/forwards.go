@@ -0,0 +1,175 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"strings"
+
+	"github.com/aoaeoe/sshTools/pkg/sshtools"
+	"golang.org/x/term"
+)
+
+// forward starts a local forward, moving to a free port when the requested
+// one is taken and -auto-port is set or the user agrees. The address in use
+// is exported as SSHTOOLS_FORWARD_<n>_ADDR for commands we run.
+func forward(opts *commonFlags, client *sshtools.Client, n int, localAddr, remoteAddr string) (listener net.Listener, err error) {
+	localAddr = client.Server.LocalBindAddress(localAddr)
+	listener, err = client.LocalForward(localAddr, remoteAddr)
+	if errors.Is(err, sshtools.ErrPortInUse) && (opts.autoPort || confirm(fmt.Sprintf("%s is already in use, forward from a free port instead? [Y/n] ", localAddr), true)) {
+		listener, err = client.LocalForward(sshtools.AnyPort(localAddr), remoteAddr)
+	}
+	if errors.Is(err, sshtools.ErrPortInUse) {
+		err = fmt.Errorf("%v (pass -auto-port to use a free port instead)", err)
+	}
+	if err != nil {
+		return
+	}
+	printForward(fmt.Sprintf("Forwarding %s -> %s", listener.Addr(), remoteAddr))
+	_ = os.Setenv(fmt.Sprintf("SSHTOOLS_FORWARD_%d_ADDR", n), listener.Addr().String())
+	return
+}
+
+// printForward prints a forward that was set up, in bold on a terminal so
+// the chosen address stands out.
+func printForward(line string) {
+	if term.IsTerminal(int(os.Stdout.Fd())) {
+		line = "\x1b[1m" + line + "\x1b[0m"
+	}
+	fmt.Println(line)
+}
+
+// startForwards sets up the server's configured local_forwards,
+// remote_forwards and dynamic_forwards in forwards.
+func startForwards(opts *commonFlags, client *sshtools.Client, forwards *sshtools.ForwardSet) (err error) {
+	defer func() {
+		if err != nil {
+			forwards.Close()
+		}
+	}()
+	for i, f := range client.Server.LocalForwards {
+		listener, errs := forward(opts, client, i+1, f.Local, f.Remote)
+		if errs != nil {
+			return errs
+		}
+		forwards.Track("L", listener, f.Remote)
+	}
+	for _, f := range client.Server.RemoteForwards {
+		listener, errs := client.RemoteForward(f.Remote, f.Local)
+		if errs != nil {
+			return errs
+		}
+		info := forwards.Track("R", listener, f.Local)
+		printForward(describeForward(info, client.Server.Alias))
+	}
+	for _, addr := range client.Server.DynamicForwards {
+		listener, errs := client.DynamicForward(addr)
+		if errs != nil {
+			return errs
+		}
+		info := forwards.Track("D", listener, "")
+		printForward(describeForward(info, client.Server.Alias))
+	}
+	return
+}
+
+// describeForward announces a forward once it is open.
+func describeForward(info sshtools.ForwardInfo, alias string) string {
+	switch info.Kind {
+	case "R":
+		return fmt.Sprintf("Forwarding %s on %s -> %s", info.Listen, alias, info.Target)
+	case "D":
+		return fmt.Sprintf("SOCKS5 proxy on %s", info.Listen)
+	}
+	return fmt.Sprintf("Forwarding %s -> %s", info.Listen, info.Target)
+}
+
+// serveForwardControl lets sshtools fwd manage the forwards of ctl under
+// key, or under key.<pid> when another session or tunnel has key. It only
+// warns when that fails. The returned func stops serving.
+func serveForwardControl(key string, ctl sshtools.ForwardController) (stop func()) {
+	control, err := sshtools.ListenForwardControl(key, ctl)
+	if errors.Is(err, sshtools.ErrForwardControlInUse) {
+		key = fmt.Sprintf("%s.%d", key, os.Getpid())
+		control, err = sshtools.ListenForwardControl(key, ctl)
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Warning: sshtools fwd will not reach this connection:", err)
+		return func() {}
+	}
+	dialer.Logf(1, "forwards can be managed with: sshtools fwd list %s", key)
+	return func() {
+		_ = control.Close()
+	}
+}
+
+// serveControlAPI serves the control API for conns under name when
+// control_api is set. It only warns when that fails. The returned func
+// stops serving.
+func serveControlAPI(config *sshtools.Config, name string, conns []*sshtools.APIConnection) (stop func()) {
+	if !config.ControlAPI {
+		return func() {}
+	}
+	api, err := sshtools.ListenControlAPI(name, conns)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Warning: control API not available:", err)
+		return func() {}
+	}
+	dialer.Logf(1, "control API listening on %s", api.Path())
+	return func() {
+		_ = api.Close()
+	}
+}
+
+// confirm asks a yes/no question on the terminal; an empty answer means
+// def. It answers no when stdin is not a terminal.
+func confirm(question string, def bool) bool {
+	if !term.IsTerminal(int(os.Stdin.Fd())) {
+		return false
+	}
+	fmt.Print(question)
+	line, _ := bufio.NewReader(os.Stdin).ReadString('\n')
+	switch strings.ToLower(strings.TrimSpace(line)) {
+	case "":
+		return def
+	case "y", "yes":
+		return true
+	}
+	return false
+}
+
+// escapeCommands is the help for the ~C command line.
+const escapeCommands = `Commands:
+      -L[bind_address:]port:host:hostport    Request local forward
+      -R[bind_address:]port:host:hostport    Request remote forward
+      -D[bind_address:]port                  Request dynamic forward
+      -KL[bind_address:]port                 Cancel local forward
+      -KR[bind_address:]port                 Cancel remote forward
+      -KD[bind_address:]port                 Cancel dynamic forward`
+
+// commandLine returns the ~C handler of a session, which adds to and
+// cancels the forwards of its connection.
+func commandLine(client *sshtools.Client, forwards *sshtools.ForwardSet) func(line string) (string, error) {
+	return func(line string) (msg string, err error) {
+		if line == "" {
+			return
+		}
+		cancel, kind, spec, errs := sshtools.ParseForwardCommand(line)
+		if errs != nil {
+			return escapeCommands, nil
+		}
+		if cancel {
+			if err = forwards.CancelForward(kind, spec); err == nil {
+				msg = fmt.Sprintf("Cancelled -%s forward %s", kind, spec)
+			}
+			return
+		}
+		info, err := forwards.AddForward(kind, spec)
+		if err != nil {
+			return
+		}
+		return describeForward(info, client.Server.Alias), nil
+	}
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/aoaeoe/sshTools/pkg/sshtools"
+)
+
+// fwdCommand manages the forwards of running sessions and tunnels, like
+// ~C but from another terminal or a script:
+// sshtools fwd list
+// sshtools fwd add web1 -L 8080:localhost:80
+// sshtools fwd rm web1 -L 8080
+func fwdCommand(args []string) {
+	usage := "usage: sshtools fwd list [name] | add <name> -L|-R [bind:]port:host:hostport | add <name> -D [bind:]port | rm <name> -L|-R|-D [bind:]port"
+	if len(args) == 0 {
+		fmt.Fprintln(os.Stderr, usage)
+		os.Exit(2)
+	}
+	switch args[0] {
+	case "list", "ls":
+		if len(args) > 2 {
+			fmt.Fprintln(os.Stderr, usage)
+			os.Exit(2)
+		}
+		fwdList(args[1:])
+		return
+	case "add", "rm":
+	default:
+		fmt.Fprintln(os.Stderr, usage)
+		os.Exit(2)
+	}
+	if len(args) < 3 {
+		fmt.Fprintln(os.Stderr, usage)
+		os.Exit(2)
+	}
+	// "-L 8080:db:80" 和 "-L8080:db:80" 两种写法都接受
+	line := strings.Join(args[2:], " ")
+	if args[0] == "rm" && strings.HasPrefix(line, "-") {
+		line = "-K" + line[1:]
+	}
+	cancel, kind, spec, err := sshtools.ParseForwardCommand(line)
+	if err != nil || cancel != (args[0] == "rm") {
+		fmt.Fprintln(os.Stderr, usage)
+		os.Exit(2)
+	}
+	name := args[1]
+	if cancel {
+		if err = sshtools.CancelForward(name, kind, spec); err != nil {
+			fmt.Fprintln(os.Stderr, "Error:", err)
+			os.Exit(1)
+		}
+		fmt.Printf("Cancelled -%s forward %s of %s\n", kind, spec, name)
+		return
+	}
+	info, err := sshtools.AddForward(name, kind, spec)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Error:", err)
+		os.Exit(1)
+	}
+	fmt.Printf("%s: %s\n", name, info)
+}
+
+// fwdList prints the forwards of the named session or tunnel, or of every
+// one that accepts sshtools fwd.
+func fwdList(names []string) {
+	if len(names) == 0 {
+		var err error
+		if names, err = sshtools.ForwardControls(); err != nil {
+			fmt.Fprintln(os.Stderr, "Error:", err)
+			os.Exit(1)
+		}
+		if len(names) == 0 {
+			fmt.Println("No running sessions or tunnels.")
+			return
+		}
+	}
+	failed := false
+	for _, name := range names {
+		forwards, err := sshtools.ListForwards(name)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "Error:", err)
+			failed = true
+			continue
+		}
+		fmt.Println(name)
+		if len(forwards) == 0 {
+			fmt.Println("  no forwards")
+		}
+		for _, f := range forwards {
+			fmt.Printf("  %s\n", f)
+		}
+	}
+	if failed {
+		os.Exit(1)
+	}
+}
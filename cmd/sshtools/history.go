@@ -0,0 +1,358 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aoaeoe/sshTools/pkg/sshtools"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/term"
+)
+
+// recordSession adds a finished interactive session to the history. A
+// non-zero exit status of the remote shell still counts as a success.
+func recordSession(server *sshtools.Server, started time.Time, readOnly bool, err error) {
+	entry := sshtools.HistoryEntry{
+		Alias:    server.Alias,
+		Address:  server.Addr(),
+		Time:     started,
+		Success:  true,
+		Seconds:  time.Since(started).Seconds(),
+		ReadOnly: readOnly,
+	}
+	var exitErr *ssh.ExitError
+	if err != nil && !errors.As(err, &exitErr) {
+		entry.Success, entry.Error = false, err.Error()
+	}
+	if errs := sshtools.RecordHistory(entry); errs != nil {
+		fmt.Fprintln(os.Stderr, "warning: failed to record history:", errs)
+	}
+}
+
+// recordCommands adds the results of a command run on one or more servers,
+// started at started, to the history.
+func recordCommands(started time.Time, results ...*sshtools.ExecResult) {
+	entries := make([]sshtools.HistoryEntry, 0, len(results))
+	for _, res := range results {
+		entries = append(entries, sshtools.HistoryEntry{
+			Alias:    res.Alias,
+			Address:  res.Address,
+			Time:     started,
+			Success:  res.Error == "",
+			Seconds:  float64(res.DurationMs) / 1000,
+			Error:    res.Error,
+			Command:  res.Command,
+			ExitCode: res.ExitCode,
+		})
+	}
+	if err := sshtools.RecordHistory(entries...); err != nil {
+		fmt.Fprintln(os.Stderr, "warning: failed to record history:", err)
+	}
+}
+
+// historyServer finds the server a history entry refers to: a configured
+// alias, or a user@host[:port] target connected to ad hoc. nil if neither.
+func historyServer(config *sshtools.Config, alias string) *sshtools.Server {
+	if server := config.ServerByAlias(alias); server != nil {
+		return server
+	}
+	if strings.Contains(alias, "@") {
+		if server, err := sshtools.ParseTarget(alias); err == nil {
+			return server
+		}
+	}
+	return nil
+}
+
+// recentServers returns the servers of the last n distinct sessions that
+// can still be connected to, most recent first.
+func recentServers(config *sshtools.Config, n int) (servers []*sshtools.Server, entries []sshtools.HistoryEntry) {
+	history, err := sshtools.LoadHistory()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "warning:", err)
+		return
+	}
+	for _, entry := range sshtools.Recent(history, 0) {
+		if len(servers) == n {
+			break
+		}
+		if server := historyServer(config, entry.Alias); server != nil {
+			servers = append(servers, server)
+			entries = append(entries, entry)
+		}
+	}
+	return
+}
+
+// lastServer returns the server of the most recent session.
+func lastServer(config *sshtools.Config) (*sshtools.Server, error) {
+	history, err := sshtools.LoadHistory()
+	if err != nil {
+		return nil, err
+	}
+	recent := sshtools.Recent(history, 1)
+	if len(recent) == 0 {
+		return nil, fmt.Errorf("no connection history yet")
+	}
+	alias := recent[0].Alias
+	server := historyServer(config, alias)
+	if server == nil {
+		return nil, fmt.Errorf("%s, the last server connected to, is no longer in the config", alias)
+	}
+	return server, nil
+}
+
+// implicitServer returns the server to connect to when none is given: the
+// one marked default, else that of the most recent session, else nil.
+func implicitServer(config *sshtools.Config) *sshtools.Server {
+	if server := config.DefaultServer(); server != nil {
+		return server
+	}
+	server, err := lastServer(config)
+	if err != nil {
+		return nil
+	}
+	return server
+}
+
+// recentCommand lists the servers connected to most recently and, on a
+// terminal, connects to the one picked by number:
+// sshtools recent [-n 10]
+func recentCommand(args []string) {
+	fs := flag.NewFlagSet("recent", flag.ExitOnError)
+	var opts commonFlags
+	opts.register(fs, "connect to")
+	n := fs.Int("n", 10, "Number of servers to list")
+	_ = fs.Parse(args)
+
+	config, err := opts.load()
+	if err != nil {
+		exitConfigError(err)
+	}
+	servers, entries := recentServers(config, *n)
+	if len(servers) == 0 {
+		fmt.Println("No recent connections.")
+		return
+	}
+	for i, server := range servers {
+		e := entries[i]
+		result := "ok"
+		if !e.Success {
+			result = "failed"
+		}
+		fmt.Printf("%d. %s (%s) %s, %s, %s\n", i+1, server.Alias, server.Addr(),
+			e.Time.Format("2006-01-02 15:04"), e.Duration().Round(time.Second), result)
+	}
+	if !term.IsTerminal(int(os.Stdin.Fd())) {
+		return
+	}
+
+	fmt.Print("Connect to (number, Enter to quit): ")
+	var choice string
+	_, _ = fmt.Scanln(&choice)
+	if choice = strings.TrimSpace(choice); choice == "" {
+		return
+	}
+	i, err := strconv.Atoi(choice)
+	if err != nil || i < 1 || i > len(servers) {
+		fmt.Fprintln(os.Stderr, "Error: no server selected")
+		os.Exit(1)
+	}
+	server := servers[i-1]
+	fmt.Printf("Connecting to %s (%s:%d)...\n", server.Alias, server.Address, server.Port)
+	if err = connectToServer(&opts, config, server); err != nil {
+		fmt.Println("Error:", err)
+	}
+}
+
+// historyCommand prints the connection and command history, filtered,
+// wipes it, or runs an entry again:
+// sshtools history [-alias web*] [-since 7d] [-until 2024-05-01] [-commands|-sessions] [-n 20]
+// sshtools history rerun [N|!N|!!]
+// sshtools history clear
+func historyCommand(args []string) {
+	if len(args) > 0 {
+		switch {
+		case args[0] == "clear":
+			if len(args) > 1 {
+				fmt.Fprintln(os.Stderr, "usage: sshtools history clear")
+				os.Exit(2)
+			}
+			if err := sshtools.ClearHistory(); err != nil {
+				fmt.Fprintln(os.Stderr, "Error:", err)
+				os.Exit(1)
+			}
+			fmt.Println("History cleared.")
+			return
+		case args[0] == "rerun":
+			historyRerun(args[1:])
+			return
+		case strings.HasPrefix(args[0], "!"):
+			historyRerun(args)
+			return
+		}
+	}
+
+	fs := flag.NewFlagSet("history", flag.ExitOnError)
+	aliasFlag := fs.String("alias", "", "Only entries of aliases matching this glob or prefix")
+	sinceFlag := fs.String("since", "", "Only entries from this date, date and time, or duration ago (e.g. 2024-05-01, 2h, 7d)")
+	untilFlag := fs.String("until", "", "Only entries before this date, date and time, or duration ago")
+	commandsFlag := fs.Bool("commands", false, "Only commands run with exec and run")
+	sessionsFlag := fs.Bool("sessions", false, "Only interactive sessions")
+	n := fs.Int("n", 0, "Only the last n matching entries")
+	_ = fs.Parse(args)
+	if fs.NArg() > 0 || *commandsFlag && *sessionsFlag {
+		fmt.Fprintln(os.Stderr, "usage: sshtools history [-alias <pattern>] [-since <time>] [-until <time>] [-commands|-sessions] [-n N] | rerun [N|!N|!!] | clear")
+		os.Exit(2)
+	}
+	filter := sshtools.HistoryFilter{Alias: *aliasFlag, Commands: *commandsFlag, Sessions: *sessionsFlag}
+	now := time.Now()
+	var err error
+	if *sinceFlag != "" {
+		if filter.Since, err = sshtools.ParseHistoryTime(*sinceFlag, now); err != nil {
+			fmt.Fprintln(os.Stderr, "Error:", err)
+			os.Exit(2)
+		}
+	}
+	if *untilFlag != "" {
+		if filter.Until, err = sshtools.ParseHistoryTime(*untilFlag, now); err != nil {
+			fmt.Fprintln(os.Stderr, "Error:", err)
+			os.Exit(2)
+		}
+	}
+
+	history, err := sshtools.LoadHistory()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Error:", err)
+		os.Exit(1)
+	}
+	// 编号是在完整历史中的位置，过滤后仍可用于 rerun
+	var numbers []int
+	for i, e := range history {
+		if filter.Match(e) {
+			numbers = append(numbers, i+1)
+		}
+	}
+	if *n > 0 && len(numbers) > *n {
+		numbers = numbers[len(numbers)-*n:]
+	}
+	for _, number := range numbers {
+		e := history[number-1]
+		var result string
+		switch {
+		case !e.Success:
+			result = "failed: " + e.Error
+		case e.IsSession() && e.ReadOnly:
+			result = "ok (read-only)"
+		case e.IsSession():
+			result = "ok"
+		default:
+			result = fmt.Sprintf("exit %d", e.ExitCode)
+		}
+		fmt.Printf("%5d  %s %-16s %-24s %8s %s", number, e.Time.Format("2006-01-02 15:04:05"), e.Alias, e.Address,
+			e.Duration().Round(time.Second), result)
+		if !e.IsSession() {
+			fmt.Printf("  $ %s", e.Command)
+		}
+		fmt.Println()
+	}
+}
+
+// historyRerun runs a history entry again: the command of a command entry
+// on the same server, or a new session for a session entry. N and !N are
+// entry numbers as printed by sshtools history, !-N counts back from the
+// end and !! (the default) is the last command. -alias or -ip runs it on
+// another server.
+func historyRerun(args []string) {
+	fs := flag.NewFlagSet("history rerun", flag.ExitOnError)
+	var opts commonFlags
+	opts.register(fs, "run the entry on instead of its own")
+	positional := parseArgs(fs, args)
+	usage := "usage: sshtools history rerun [N|!N|!-N|!!]"
+	if len(positional) > 1 {
+		fmt.Fprintln(os.Stderr, usage)
+		os.Exit(2)
+	}
+	ref := "!!"
+	if len(positional) == 1 {
+		ref = positional[0]
+	}
+
+	history, err := sshtools.LoadHistory()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Error:", err)
+		os.Exit(1)
+	}
+	var entry *sshtools.HistoryEntry
+	if ref == "!!" {
+		for i := len(history) - 1; i >= 0 && entry == nil; i-- {
+			if !history[i].IsSession() {
+				entry = &history[i]
+			}
+		}
+		if entry == nil {
+			fmt.Fprintln(os.Stderr, "Error: no command in the history yet")
+			os.Exit(1)
+		}
+	} else {
+		number, errs := strconv.Atoi(strings.TrimPrefix(ref, "!"))
+		if errs != nil {
+			fmt.Fprintln(os.Stderr, usage)
+			os.Exit(2)
+		}
+		if number < 0 {
+			number += len(history) + 1
+		}
+		if number < 1 || number > len(history) {
+			fmt.Fprintf(os.Stderr, "Error: no history entry %s\n", ref)
+			os.Exit(1)
+		}
+		entry = &history[number-1]
+	}
+
+	config, err := opts.load()
+	if err != nil {
+		exitConfigError(err)
+	}
+	var server *sshtools.Server
+	if opts.alias != "" || opts.ip != "" {
+		server = selectServer(config, opts.alias, opts.ip, opts.tag)
+	} else if server = historyServer(config, entry.Alias); server == nil {
+		fmt.Fprintf(os.Stderr, "Error: %s is no longer in the config\n", entry.Alias)
+		os.Exit(1)
+	}
+	if entry.IsSession() {
+		// 只读会话仍以只读方式重连
+		opts.readOnly = entry.ReadOnly
+		fmt.Printf("Connecting to %s (%s:%d)...\n", server.Alias, server.Address, server.Port)
+		if err = connectToServer(&opts, config, server); err != nil {
+			fmt.Println("Error:", err)
+		}
+		return
+	}
+
+	// 回显等价的命令，便于复制
+	fmt.Fprintf(os.Stderr, "sshtools exec -alias %s -- %s\n", server.Alias, entry.Command)
+	started := time.Now()
+	client, err := dialServer(&opts, config, server)
+	if err != nil {
+		recordCommands(started, &sshtools.ExecResult{Alias: server.Alias, Address: server.Addr(), Command: entry.Command, ExitCode: -1, Error: err.Error()})
+		fmt.Fprintln(os.Stderr, "Error:", err)
+		os.Exit(exitCode(err, exitConnect))
+	}
+	if !term.IsTerminal(int(os.Stdin.Fd())) {
+		client.Stdin = os.Stdin
+	}
+	res := client.Exec(entry.Command, os.Stdout, os.Stderr, 0, false)
+	if res.Error != "" {
+		fmt.Fprintln(os.Stderr, "Error:", res.Error)
+	}
+	_ = client.Close()
+	recordCommands(started, res)
+	os.Exit(resultStatus(res))
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/aoaeoe/sshTools/pkg/sshtools"
+	"golang.org/x/crypto/ssh"
+)
+
+// keygenCommand creates a key pair like ssh-keygen, and optionally makes a
+// server log in with it:
+// sshtools keygen -type ed25519 -out ~/.ssh/id_work
+// sshtools keygen -type rsa -bits 4096 -passphrase -server web1 -install
+func keygenCommand(args []string) {
+	fs := flag.NewFlagSet("keygen", flag.ExitOnError)
+	var opts commonFlags
+	opts.register(fs, "log in to with the key")
+	typeFlag := fs.String("type", sshtools.KeyTypeEd25519, "Key type: ed25519, ecdsa or rsa")
+	bitsFlag := fs.Int("bits", 0, "Key size: 256, 384 or 521 for ecdsa (default 256), 2048 or more for rsa (default 3072)")
+	outFlag := fs.String("out", "", "Path of the private key; the public key goes next to it as .pub (default: ~/.ssh/id_<type>)")
+	commentFlag := fs.String("comment", sshtools.DefaultKeyComment(), "Comment stored with the key")
+	passphraseFlag := fs.Bool("passphrase", false, "Encrypt the private key with a passphrase, prompted for (read from stdin when not a terminal)")
+	serverFlag := fs.String("server", "", "Set the key as private_key (with use_key) of this server alias")
+	installFlag := fs.Bool("install", false, "With -server, first install the key on the server like copy-id")
+	_ = fs.Parse(args)
+	if fs.NArg() > 0 || *installFlag && *serverFlag == "" {
+		fmt.Fprintln(os.Stderr, "usage: sshtools keygen [-type ed25519|ecdsa|rsa] [-bits N] [-out <path>] [-passphrase] [-server <alias> [-install]]")
+		os.Exit(2)
+	}
+	switch *typeFlag {
+	case sshtools.KeyTypeEd25519, sshtools.KeyTypeECDSA, sshtools.KeyTypeRSA:
+	default:
+		fmt.Fprintf(os.Stderr, "unknown key type %q, want ed25519, ecdsa or rsa\n", *typeFlag)
+		os.Exit(2)
+	}
+	out := *outFlag
+	if out == "" {
+		out = filepath.Join("~", ".ssh", "id_"+*typeFlag)
+	}
+
+	// 先检查配置，避免生成密钥后才发现别名不存在
+	var config *sshtools.Config
+	var server *sshtools.Server
+	if *serverFlag != "" {
+		var err error
+		if config, err = opts.load(); err != nil {
+			exitConfigError(err)
+		}
+		if server = config.ServerByAlias(*serverFlag); server == nil {
+			fmt.Fprintf(os.Stderr, "Error: unknown alias %q\n", *serverFlag)
+			os.Exit(2)
+		}
+	}
+
+	keyOpts := sshtools.KeyOptions{Type: *typeFlag, Bits: *bitsFlag, Comment: *commentFlag}
+	if *passphraseFlag {
+		passphrase, err := readSecret("passphrase", filepath.Base(out))
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "Error:", err)
+			os.Exit(2)
+		}
+		keyOpts.Passphrase = []byte(passphrase)
+	}
+	key, err := sshtools.GenerateKey(out, keyOpts)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Error:", err)
+		os.Exit(1)
+	}
+	fmt.Printf("Your private key is in %s and the public key in %s.\n", key.PrivateKey, key.Path)
+	fmt.Printf("Fingerprint: %s %s\n", ssh.FingerprintSHA256(key.Key), key.Comment)
+	fmt.Println(key.AuthorizedLine())
+	if server == nil {
+		return
+	}
+
+	if *installFlag {
+		// copy-id 验证新密钥可用后才写入配置
+		if err = copyID(&opts, config, server, key.Path, true); err != nil {
+			fmt.Fprintln(os.Stderr, "Error:", err)
+			os.Exit(exitCode(err, 1))
+		}
+		return
+	}
+	if err = sshtools.SetServersFields(opts.configFile, []string{server.Alias}, map[string]any{"private_key": key.PrivateKey, "use_key": true}); err != nil {
+		fmt.Fprintln(os.Stderr, "Error:", err)
+		os.Exit(1)
+	}
+	fmt.Printf("%s now logs in to %s with the key; install it there with: sshtools copy-id %s\n", opts.configFile, server.Alias, server.Alias)
+}
@@ -0,0 +1,162 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/aoaeoe/sshTools/pkg/sshtools"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+// hostKeyResult is the outcome of fetching one server's host key.
+type hostKeyResult struct {
+	server *sshtools.Server
+	key    ssh.PublicKey
+	state  string
+	known  []string
+	err    error
+}
+
+// knownHostsCommand manages known_hosts entries:
+// sshtools known-hosts prefetch -tag all [-y]
+func knownHostsCommand(args []string) {
+	if len(args) == 0 || args[0] != "prefetch" {
+		fmt.Fprintln(os.Stderr, "usage: sshtools known-hosts prefetch (-tag <tag> | -alias <pattern>) [-y]")
+		os.Exit(2)
+	}
+	fs := flag.NewFlagSet("known-hosts prefetch", flag.ExitOnError)
+	var opts commonFlags
+	var fleet fleetFlags
+	opts.register(fs, "prefetch")
+	fleet.register(fs)
+	yesFlag := fs.Bool("y", false, "Add new keys without asking for confirmation")
+	_ = fs.Parse(args[1:])
+
+	config, err := opts.load()
+	if err != nil {
+		exitConfigError(err)
+	}
+	servers, err := fleet.servers(config, &opts)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Error:", err)
+		os.Exit(exitCode(err, 1))
+	}
+	path, err := sshtools.KnownHostsPath()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Error:", err)
+		os.Exit(exitCode(err, 1))
+	}
+
+	// Every hop of every server, once each.
+	var hops []*sshtools.Server
+	seen := map[string]bool{}
+	for _, server := range servers {
+		for _, hop := range config.Hops(server) {
+			if !seen[hop.Addr()] {
+				seen[hop.Addr()] = true
+				hops = append(hops, hop)
+			}
+		}
+	}
+	if len(hops) == 0 {
+		fmt.Fprintln(os.Stderr, "Error: no server matched")
+		os.Exit(1)
+	}
+
+	results := make([]hostKeyResult, len(hops))
+	forEachServer(hops, fleetParallel, func(i int, server *sshtools.Server) {
+		res := hostKeyResult{server: server}
+		if res.key, res.err = dialer.FetchHostKey(server); res.err == nil {
+			var known []knownhosts.KnownKey
+			res.state, known, res.err = sshtools.CheckKnownHost(path, server, res.key)
+			for _, k := range known {
+				res.known = append(res.known, fmt.Sprintf("%s %s (%s:%d)", k.Key.Type(), ssh.FingerprintSHA256(k.Key), k.Filename, k.Line))
+			}
+		}
+		results[i] = res
+	})
+
+	var fresh, conflicts, failed []hostKeyResult
+	knownCount := 0
+	for _, res := range results {
+		switch {
+		case res.err != nil:
+			failed = append(failed, res)
+		case res.state == sshtools.HostKnown:
+			knownCount++
+		case res.state == sshtools.HostConflict:
+			conflicts = append(conflicts, res)
+		default:
+			fresh = append(fresh, res)
+		}
+	}
+
+	printNewKeys(fresh)
+	if knownCount > 0 {
+		fmt.Printf("%d host(s) already known with matching keys (not shown).\n", knownCount)
+	}
+	if len(conflicts) > 0 {
+		fmt.Printf("\n\x1b[1;31mCONFLICTING host keys (%d), NOT changed:\x1b[0m\n", len(conflicts))
+		for _, res := range conflicts {
+			fmt.Printf("  %-20s %-22s presented %s %s\n", res.server.Alias, res.server.Addr(), res.key.Type(), ssh.FingerprintSHA256(res.key))
+			for _, k := range res.known {
+				fmt.Printf("  %-20s %-22s on record %s\n", "", "", k)
+			}
+		}
+		fmt.Println("  Verify these out of band; if the host was really rekeyed, remove the old entry")
+		fmt.Println("  (ssh-keygen -R <host>) and prefetch again.")
+	}
+	if len(failed) > 0 {
+		fmt.Printf("\nUnreachable (%d):\n", len(failed))
+		for _, res := range failed {
+			fmt.Printf("  %-20s %v\n", res.server.Alias, res.err)
+		}
+	}
+
+	if len(fresh) > 0 {
+		if !*yesFlag && !confirm(fmt.Sprintf("\nAdd %d new host key(s) to %s? [y/N] ", len(fresh), path), false) {
+			fmt.Println("Nothing written.")
+			os.Exit(1)
+		}
+		hosts := make([]*sshtools.Server, len(fresh))
+		keys := make([]ssh.PublicKey, len(fresh))
+		for i, res := range fresh {
+			hosts[i], keys[i] = res.server, res.key
+		}
+		if err = sshtools.AppendKnownHosts(path, hosts, keys); err != nil {
+			fmt.Fprintln(os.Stderr, "Error:", err)
+			os.Exit(exitCode(err, 1))
+		}
+		fmt.Printf("Added %d host key(s) to %s.\n", len(fresh), path)
+	}
+	if len(conflicts) > 0 || len(failed) > 0 {
+		os.Exit(1)
+	}
+}
+
+// printNewKeys lists new keys grouped by fingerprint, so hosts sharing a
+// key (e.g. cloned images) stand out.
+func printNewKeys(fresh []hostKeyResult) {
+	if len(fresh) == 0 {
+		return
+	}
+	groups := map[string][]string{}
+	var fingerprints []string
+	for _, res := range fresh {
+		fp := res.key.Type() + " " + ssh.FingerprintSHA256(res.key)
+		if _, ok := groups[fp]; !ok {
+			fingerprints = append(fingerprints, fp)
+		}
+		groups[fp] = append(groups[fp], fmt.Sprintf("%s (%s)", res.server.Alias, res.server.Addr()))
+	}
+	sort.Strings(fingerprints)
+	fmt.Printf("New host keys (%d):\n", len(fresh))
+	for _, fp := range fingerprints {
+		fmt.Printf("  %s\n", fp)
+		fmt.Printf("      %s\n", strings.Join(groups[fp], ", "))
+	}
+}
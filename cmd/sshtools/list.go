@@ -0,0 +1,89 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+
+	"github.com/aoaeoe/sshTools/pkg/sshtools"
+)
+
+// listCommand prints the configured servers for people and for scripts:
+// sshtools list [-o text|json|names|tags|providers] [-tag web]
+// names, tags and providers print one value per line and nothing else, for
+// shell completion.
+func listCommand(args []string) {
+	fs := flag.NewFlagSet("list", flag.ExitOnError)
+	var opts commonFlags
+	fs.StringVar(&opts.configFile, "config", sshtools.DefaultConfigFile(), "Path to the configuration file")
+	opts.noSecrets = true
+	outputFlag := fs.String("o", "text", "Output format: text, json, names, tags or providers")
+	tagFlag := fs.String("tag", "", "Only list servers with this tag")
+	_ = fs.Parse(args)
+
+	switch *outputFlag {
+	case "text", "json", "names", "tags", "providers":
+	default:
+		fmt.Fprintf(os.Stderr, "unknown output format %q\n", *outputFlag)
+		os.Exit(2)
+	}
+	config, err := opts.load()
+	if err != nil {
+		exitConfigError(err)
+	}
+	switch *outputFlag {
+	case "tags":
+		for _, tag := range config.TagNames() {
+			fmt.Println(tag)
+		}
+		return
+	case "providers":
+		for _, p := range config.Providers {
+			fmt.Println(p.Name)
+		}
+		return
+	}
+
+	entries := config.Inventory()
+	if *tagFlag != "" {
+		kept := entries[:0]
+		for _, e := range entries {
+			if *tagFlag == "all" || slices.Contains(e.Tags, *tagFlag) {
+				kept = append(kept, e)
+			}
+		}
+		entries = kept
+	}
+	switch *outputFlag {
+	case "json":
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		_ = encoder.Encode(entries)
+	case "names":
+		for _, e := range entries {
+			fmt.Println(e.Alias)
+		}
+	default:
+		fmt.Printf("%-20s %-28s %-12s %-10s %s\n", "ALIAS", "ADDRESS", "USER", "AUTH", "TAGS")
+		for _, e := range entries {
+			address := strings.Join(e.Addresses, ",")
+			if e.Port != 22 {
+				address += fmt.Sprintf(":%d", e.Port)
+			}
+			alias := e.Alias
+			if e.Default {
+				alias += " [default]"
+			}
+			if e.Favorite {
+				alias += " *"
+			}
+			if e.Deprecated {
+				alias += " [deprecated]"
+			}
+			fmt.Printf("%-20s %-28s %-12s %-10s %s\n", alias, address, e.User, e.Auth, strings.Join(e.Tags, ","))
+		}
+	}
+}
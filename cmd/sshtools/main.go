@@ -0,0 +1,741 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aoaeoe/sshTools/pkg/sshtools"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/term"
+)
+
+// notifier reports completion of long-running operations (fleet exec, transfers).
+var notifier *sshtools.Notifier
+
+func connectToServer(opts *commonFlags, config *sshtools.Config, server *sshtools.Server) (err error) {
+	defer func(started time.Time) {
+		recordSession(server, started, opts.readOnly, err)
+	}(time.Now())
+	client, err := dialServer(opts, config, server)
+	if err != nil {
+		return
+	}
+	defer func() {
+		if client == nil {
+			return
+		}
+		if errs := client.Close(); errs != nil && client.Lost() == nil {
+			fmt.Println(errs.Error())
+		}
+	}()
+
+	if opts.pin {
+		pinHostKey(opts, config, client)
+	}
+	if err = client.RunOnConnect(os.Stdout, os.Stderr); err != nil {
+		return
+	}
+	// ~C 和 sshtools fwd 添加的转发与配置的转发一起在连接结束时关闭
+	forwards := sshtools.NewForwardSet(client)
+	if err = startForwards(opts, client, forwards); err != nil {
+		return
+	}
+	defer forwards.Close()
+	defer serveForwardControl(server.Alias, forwards)()
+
+	command := server.RemoteCommand
+	if opts.command != "" {
+		command = opts.command
+	}
+	// 未指定命令时进入 -attach 或配置的 tmux/screen 会话，重连后回到原来的会话
+	attach := config.AttachSession(server)
+	if opts.attach != "" {
+		attach = opts.attach
+	}
+	if command == "" && attach != "" && attach != sshtools.AttachNone {
+		if command, err = sshtools.AttachCommand(attach); err != nil {
+			return
+		}
+	}
+	var password string
+	if opts.become {
+		if password, err = becomePassword(server); err != nil {
+			return
+		}
+		command = sshtools.SudoCommand(command, false)
+	}
+	var share *sshtools.Share
+	if opts.share != "" {
+		if share, err = sshtools.ListenShare(opts.share); err != nil {
+			return
+		}
+		defer func(share *sshtools.Share) {
+			_ = share.Close()
+		}(share)
+		share.ReadWrite = opts.shareRW
+		share.Title = server.Alias
+		fmt.Fprintf(os.Stderr, "Sharing this session on %s, observers run: sshtools watch %s\n", opts.share, opts.share)
+	}
+
+	// 录制覆盖重连前后的所有会话
+	var recorder *sshtools.Recorder
+	if opts.record != "" {
+		width, height, errs := term.GetSize(int(os.Stdout.Fd()))
+		if errs != nil || width <= 0 || height <= 0 {
+			width, height = 80, 24
+		}
+		if recorder, err = sshtools.NewRecorder(opts.record, width, height, server.User+"@"+server.Alias); err != nil {
+			return
+		}
+		defer func() {
+			if errs := recorder.Close(); errs != nil {
+				fmt.Fprintln(os.Stderr, "Error:", errs)
+				return
+			}
+			fmt.Fprintf(os.Stderr, "Session recorded to %s, play it with: sshtools replay %s\n", opts.record, opts.record)
+		}()
+	}
+	// 会话日志同样覆盖重连前后的所有会话
+	var sessionLog *sshtools.SessionLog
+	if opts.logFile != "" {
+		if sessionLog, err = sshtools.OpenSessionLog(opts.logFile, server.User+"@"+server.Alias, opts.logTimes, opts.logStrip); err != nil {
+			return
+		}
+		defer func() {
+			if errs := sessionLog.Close(); errs != nil {
+				fmt.Fprintln(os.Stderr, "Error:", errs)
+			}
+		}()
+	}
+	// 写入磁盘前遮盖密码和密钥；注册在关闭文件之后，先于其执行
+	var record io.Writer
+	switch {
+	case recorder != nil && sessionLog != nil:
+		record = io.MultiWriter(recorder, sessionLog)
+	case recorder != nil:
+		record = recorder
+	case sessionLog != nil:
+		record = sessionLog
+	}
+	if record != nil {
+		var redactor *sshtools.Redactor
+		if redactor, err = sshtools.NewRedactor(record, append(server.Secrets(), password), config.RedactPatterns); err != nil {
+			return
+		}
+		defer func() {
+			_ = redactor.Flush()
+		}()
+		record = redactor
+	}
+	// 重连模式下所有会话共用一个 stdin 读取者，断线的会话不会吞掉之后的输入
+	var relay *stdinRelay
+	if opts.reconnect {
+		relay = newStdinRelay()
+	}
+	for {
+		var stdin io.Reader = os.Stdin
+		var input *relayReader
+		if relay != nil {
+			input = relay.reader()
+			stdin = input
+		}
+		err = runSession(opts, config, server, client, forwards, stdin, command, password, share, record)
+		if input != nil {
+			_ = input.Close()
+		}
+		// keepalive 无回应时报告连接丢失，而不是会话的退出错误
+		if lost := client.Lost(); lost != nil {
+			err = lost
+		}
+		if !opts.reconnect || !connectionDropped(client, err) {
+			return
+		}
+		if client.Lost() == nil {
+			err = fmt.Errorf("connection to %s lost: %v", server.Alias, err)
+		}
+		fmt.Fprintln(os.Stderr, err)
+		forwards.Close()
+		_ = client.Close()
+		if client, err = redial(opts, config, server, relay); err != nil {
+			return
+		}
+		forwards.Reset(client)
+		if err = startForwards(opts, client, forwards); err != nil {
+			return
+		}
+	}
+}
+
+// runSession runs one interactive session on client until it ends.
+func runSession(opts *commonFlags, config *sshtools.Config, server *sshtools.Server, client *sshtools.Client, forwards *sshtools.ForwardSet,
+	stdin io.Reader, command, password string, share *sshtools.Share, record io.Writer) (err error) {
+	session, command, err := client.NewUserSession(command)
+	if err != nil {
+		err = fmt.Errorf("failed to create session on server %s: %v", server.Addr(), err)
+		return
+	}
+	defer func(session *ssh.Session) {
+		_ = session.Close()
+	}(session)
+
+	if !opts.quiet {
+		defer showBanner(config, server)()
+	}
+	t := sshtools.NewTerminal(session, stdin, os.Stdout, os.Stderr)
+	t.Command = command
+	t.Quiet = opts.quiet
+	t.ReadOnly = opts.readOnly
+	t.TermType, t.Modes = server.TermType(), server.PTYModes()
+	if opts.term != "" {
+		t.TermType = opts.term
+	}
+	t.PasteDelay = time.Duration(server.PasteDelayMs) * time.Millisecond
+	t.Clipboard = server.Clipboard
+	t.Triggers = config.OutputTriggers(server)
+	t.OnTrigger = func(trigger sshtools.OutputTrigger, line string) {
+		message := trigger.Message
+		if message == "" {
+			message = line
+		}
+		notifier.Notify("sshtools: "+server.Alias, message, trigger.Bell)
+	}
+	t.Expect = server.ExpectScript
+	t.Become, t.BecomePassword = opts.become, password
+	t.MaxDuration = opts.maxDuration
+	if t.MaxDuration == 0 {
+		t.MaxDuration, _ = time.ParseDuration(server.SessionMaxDuration)
+	}
+	if server.IdleTimeout != "" {
+		t.IdleTimeout, _ = time.ParseDuration(server.IdleTimeout)
+		t.IdleAction, t.IdleCommand = server.IdleAction, server.IdleCommand
+		// 没有可以核对的密码时不锁定，空闲超时后断开
+		if t.IdleAction == sshtools.IdleLock {
+			if t.UnlockPassword = unlockPassword(server); t.UnlockPassword == "" {
+				fmt.Fprintf(os.Stderr, "Warning: %s has no password to unlock with, it disconnects after %s idle instead of locking\n", server.Alias, server.IdleTimeout)
+				t.IdleAction = sshtools.IdleDisconnect
+			}
+		}
+	}
+	// sz 发送的文件默认保存到当前目录
+	switch config.ZmodemDir {
+	case "":
+		t.ZmodemDir = "."
+	case sshtools.ZmodemNone:
+	default:
+		if t.ZmodemDir, err = sshtools.ExpandPath(config.ZmodemDir); err != nil {
+			return
+		}
+	}
+	t.Log = dialer.Logf
+	t.CommandLine = commandLine(client, forwards)
+	t.Share = share
+	t.Record = record
+	// 环境标签在会话期间保留在终端最后一行
+	if !config.DisableStatusLine {
+		t.StatusLine = config.LabelText(server)
+		if os.Getenv("NO_COLOR") == "" {
+			t.StatusColor = config.LabelColor(server)
+		}
+	}
+	return t.Run()
+}
+
+// showBanner prints the server's connection banner and label, and sets the
+// window title when configured. Colors and titles are only used on a
+// terminal, and colors not at all with NO_COLOR. The returned func restores
+// the title.
+func showBanner(config *sshtools.Config, server *sshtools.Server) (restore func()) {
+	restore = func() {}
+	tty := term.IsTerminal(int(os.Stdout.Fd()))
+	noColor := os.Getenv("NO_COLOR") != ""
+	banner, err := config.RenderBanner(server, tty && !noColor)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "warning: %s: %v\n", server.Alias, err)
+	} else if banner != "" {
+		fmt.Println(strings.TrimRight(banner, "\n"))
+	}
+	if label := config.RenderLabel(server, tty && !noColor); label != "" {
+		fmt.Println(label)
+	}
+	if config.SetTitle && tty {
+		fmt.Print(sshtools.SetTitle(server.User + "@" + server.Alias))
+		restore = func() { fmt.Print(sshtools.RestoreTitle()) }
+	}
+	return
+}
+
+func selectServer(config *sshtools.Config, alias, ip, tag string) *sshtools.Server {
+	// 如果有别名或 IP 地址参数，查找对应的服务器（支持通配符、前缀和 CIDR）
+	candidates := make([]*sshtools.Server, 0, len(config.Servers))
+	switch {
+	case alias != "":
+		candidates = config.MatchAlias(alias)
+	case ip != "":
+		candidates = config.MatchAddress(ip)
+	default:
+		for i := range config.Servers {
+			candidates = append(candidates, &config.Servers[i])
+		}
+	}
+	// 指定标签时只保留带该标签的服务器
+	if tag != "" {
+		candidates = slices.DeleteFunc(candidates, func(server *sshtools.Server) bool {
+			return !server.HasTag(tag)
+		})
+	}
+	selection := strings.TrimSpace(alias + " " + ip)
+	if tag != "" {
+		selection = strings.TrimSpace(selection + " tag " + tag)
+	}
+
+	var selectedServer *sshtools.Server
+	switch {
+	case len(config.Servers) == 0:
+		fmt.Fprintln(os.Stderr, "Error: no servers configured, connect with user@host[:port] or add servers to the config file")
+		os.Exit(1)
+	case len(candidates) == 0:
+		fmt.Fprintln(os.Stderr, "Error: no server matched", selection)
+		os.Exit(1)
+	case len(candidates) == 1 && selection != "":
+		selectedServer = candidates[0]
+	default:
+		if selection != "" {
+			fmt.Printf("%d servers matched %s:\n", len(candidates), selection)
+			if !term.IsTerminal(int(os.Stdin.Fd())) {
+				printServers(candidates, 1)
+				fmt.Fprintln(os.Stderr, "Error: ambiguous server selection, use a more specific -alias, -ip or -tag")
+				os.Exit(1)
+			}
+		}
+		// 进入交互式选择，收藏的服务器排在最前，其余按连接的频率和新近程度排序
+		history, err := sshtools.LoadHistory()
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "warning:", err)
+		}
+		sshtools.SortByUse(candidates, history, time.Now())
+		// picker_prewarm 时在选择期间预先连接，选中后直接使用
+		var prewarm *sshtools.Prewarmer
+		if config.PickerPrewarm {
+			prewarm = sshtools.NewPrewarmer(dialer)
+			dialer.Prewarmed = prewarm
+		}
+		selectedServer = pickServer(candidates, prewarm)
+		prewarm.Keep(selectedServer)
+		if selectedServer == nil {
+			fmt.Fprintln(os.Stderr, "Error: no server selected")
+			os.Exit(1)
+		}
+	}
+
+	// 处理已废弃的别名
+	target, notice, err := config.Resolve(selectedServer, time.Now())
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Error:", err)
+		os.Exit(exitCode(err, 1))
+	}
+	if notice != "" {
+		fmt.Fprintf(os.Stderr, "\x1b[1;33m!! %s\x1b[0m\n", notice)
+	}
+	return target
+}
+
+// printServers lists servers numbered from first.
+func printServers(servers []*sshtools.Server, first int) {
+	dim := term.IsTerminal(int(os.Stdout.Fd()))
+	for i, server := range servers {
+		line := fmt.Sprintf("%d. %s (%s:%d)", first+i, server.Alias, server.Address, server.Port)
+		if server.Favorite {
+			line += " *"
+		}
+		if server.Deprecated {
+			line += " [deprecated]"
+			if dim {
+				line = "\x1b[2m" + line + "\x1b[0m"
+			}
+		}
+		fmt.Println(line)
+	}
+}
+
+// pickServer lets the user choose one of servers, in their order: in the
+// full-screen picker on a terminal, pre-dialing with prewarm when set,
+// otherwise at a numbered prompt. It returns nil when nothing was chosen.
+func pickServer(servers []*sshtools.Server, prewarm *sshtools.Prewarmer) *sshtools.Server {
+	if term.IsTerminal(int(os.Stdin.Fd())) && term.IsTerminal(int(os.Stdout.Fd())) {
+		server, err := runPicker(servers, prewarm)
+		if err == nil {
+			return server
+		}
+		fmt.Fprintln(os.Stderr, "warning:", err)
+	}
+	return promptServer(servers)
+}
+
+// promptServer prompts for a number or alias from servers; nil if the
+// answer matches none of them. Answering #tag lists only the servers with
+// that tag, and # alone all of them again.
+func promptServer(servers []*sshtools.Server) *sshtools.Server {
+	all := servers
+	for {
+		fmt.Println("Please select a server to connect to (#tag to filter by tag, * marks favorites):")
+		printServers(servers, 1)
+		var choice string
+		_, _ = fmt.Scanln(&choice)
+		choice = strings.TrimSpace(choice)
+		if tag, ok := strings.CutPrefix(choice, "#"); ok {
+			servers = filterByTag(all, tag)
+			if len(servers) == 0 {
+				fmt.Printf("No server has the tag %q.\n", tag)
+				servers = all
+			}
+			continue
+		}
+		if n, err := strconv.Atoi(choice); err == nil && n >= 1 && n <= len(servers) {
+			return servers[n-1]
+		}
+		for _, server := range servers {
+			if sshtools.AliasEqual(server.Alias, choice) {
+				return server
+			}
+		}
+		return nil
+	}
+}
+
+// filterByTag returns the servers with tag, or all of them for an empty tag.
+func filterByTag(servers []*sshtools.Server, tag string) (tagged []*sshtools.Server) {
+	if tag == "" {
+		return servers
+	}
+	for _, server := range servers {
+		if server.HasTag(tag) {
+			tagged = append(tagged, server)
+		}
+	}
+	return
+}
+
+// adHocServer builds the server for a user@host[:port] target, adding it to
+// the config file first with -save.
+func adHocServer(opts *commonFlags, config *sshtools.Config) (server *sshtools.Server, err error) {
+	server, err = sshtools.ParseTarget(opts.target)
+	if err != nil || !opts.save {
+		return
+	}
+
+	fmt.Printf("Alias for %s@%s: ", server.User, server.Addr())
+	var alias string
+	_, _ = fmt.Scanln(&alias)
+	if alias = strings.TrimSpace(alias); alias == "" {
+		err = fmt.Errorf("an alias is required to save %s", opts.target)
+		return
+	}
+	if config.ServerByAlias(alias) != nil {
+		err = fmt.Errorf("alias %q already exists in %s", alias, opts.configFile)
+		return
+	}
+	server.Alias = alias
+	if err = sshtools.AppendServer(opts.configFile, server); err != nil {
+		return
+	}
+	fmt.Printf("Saved %s to %s.\n", alias, opts.configFile)
+	return
+}
+
+func main() {
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "ports":
+			portsCommand(os.Args[2:])
+			return
+		case "exec":
+			execCommand(os.Args[2:])
+			return
+		case "facts":
+			factsCommand(os.Args[2:])
+			return
+		case "rotate-key":
+			rotateKeyCommand(os.Args[2:])
+			return
+		case "keygen":
+			keygenCommand(os.Args[2:])
+			return
+		case "tail":
+			tailCommand(os.Args[2:])
+			return
+		case "mount":
+			mountCommand(os.Args[2:])
+			return
+		case "unmount":
+			unmountCommand(os.Args[2:])
+			return
+		case "run":
+			runCommand(os.Args[2:])
+			return
+		case "run-script":
+			runScriptCommand(os.Args[2:])
+			return
+		case "push-file":
+			pushFileCommand(os.Args[2:])
+			return
+		case "fingerprint":
+			fingerprintCommand(os.Args[2:])
+			return
+		case "fwd":
+			fwdCommand(os.Args[2:])
+			return
+		case "known-hosts":
+			knownHostsCommand(os.Args[2:])
+			return
+		case "copy-id":
+			copyIDCommand(os.Args[2:])
+			return
+		case "nc":
+			ncCommand(os.Args[2:])
+			return
+		case "edit":
+			editCommand(os.Args[2:])
+			return
+		case "put":
+			putCommand(os.Args[2:])
+			return
+		case "get":
+			getCommand(os.Args[2:])
+			return
+		case "check":
+			checkCommand(os.Args[2:])
+			return
+		case "doctor":
+			doctorCommand(os.Args[2:])
+			return
+		case "debug-report":
+			debugReportCommand(os.Args[2:])
+			return
+		case "ping":
+			pingCommand(os.Args[2:])
+			return
+		case "tunnel":
+			tunnelCommand(os.Args[2:])
+			return
+		case "tunnels":
+			tunnelsCommand(os.Args[2:])
+			return
+		case "vpn":
+			vpnCommand(os.Args[2:])
+			return
+		case "status":
+			statusCommand(os.Args[2:])
+			return
+		case "watch":
+			watchCommand(os.Args[2:])
+			return
+		case "recent":
+			recentCommand(os.Args[2:])
+			return
+		case "history":
+			historyCommand(os.Args[2:])
+			return
+		case "list":
+			listCommand(os.Args[2:])
+			return
+		case "add":
+			addCommand(os.Args[2:])
+			return
+		case "rm":
+			rmCommand(os.Args[2:])
+			return
+		case "config":
+			configCommand(os.Args[2:])
+			return
+		case "secret":
+			secretCommand(os.Args[2:])
+			return
+		case "sftp":
+			sftpCommand(os.Args[2:])
+			return
+		case "sync":
+			syncCommand(os.Args[2:])
+			return
+		case "audit":
+			auditCommand(os.Args[2:])
+			return
+		case "verify":
+			verifyCommand(os.Args[2:])
+			return
+		case "dash":
+			dashCommand(os.Args[2:])
+			return
+		case "import-sshconfig":
+			importSSHConfigCommand(os.Args[2:])
+			return
+		case "discover":
+			discoverCommand(os.Args[2:])
+			return
+		case "export":
+			exportCommand(os.Args[2:])
+			return
+		case "import-ansible":
+			importAnsibleCommand(os.Args[2:])
+			return
+		case "import-putty":
+			importPuTTYCommand(os.Args[2:])
+			return
+		case "replay":
+			replayCommand(os.Args[2:])
+			return
+		case "completion":
+			completionCommand(os.Args[2:])
+			return
+		}
+	}
+
+	// 只接收别名或 IP 地址参数，配置文件路径可以自定义；也可直接传 user@host:port
+	var opts commonFlags
+	opts.register(flag.CommandLine, "connect to")
+	flag.StringVar(&opts.command, "cmd", "", "Run this command on the remote PTY instead of the login shell")
+	opts.registerEnv(flag.CommandLine)
+	opts.registerBecome(flag.CommandLine)
+	opts.registerQuiet(flag.CommandLine)
+	flag.StringVar(&opts.term, "term", "", "Request the PTY with this TERM instead of the local one, e.g. xterm or vt100 (default: term)")
+	flag.BoolVar(&opts.readOnly, "read-only", false, "Watch the session without sending any keystrokes (~. disconnects)")
+	flag.BoolVar(&opts.reconnect, "reconnect", false, "Reconnect and reopen the session when the connection drops")
+	flag.StringVar(&opts.attach, "attach", "", `Start the shell in this remote tmux or screen session, e.g. tmux:work, or "none" for a bare shell (default: attach_session)`)
+	flag.StringVar(&opts.record, "record", "", "Record the session output to this file in asciicast v2 format")
+	flag.StringVar(&opts.logFile, "log-file", "", "Append the session output to this text file")
+	flag.BoolVar(&opts.logTimes, "log-timestamps", false, "With -log-file, prefix each line with the time it was printed")
+	flag.BoolVar(&opts.logStrip, "log-strip-ansi", false, "With -log-file, remove colors and other terminal escape sequences")
+	controlFlag := flag.String("O", "", "Control an active connection multiplexer: check or exit")
+	muxMasterFlag := flag.Bool("mux-master", false, "Run as the background control master (used internally)")
+	flag.StringVar(&opts.share, "share", "", "Let others watch this session through a unix socket at this path")
+	flag.BoolVar(&opts.shareRW, "share-rw", false, "With -share, also forward observers' keystrokes to the session")
+	flag.BoolVar(&opts.pin, "pin", false, "Offer to save the server's host key as its host_key_fingerprint if none is pinned")
+	flag.BoolVar(&opts.gatewayPorts, "g", false, "Let other machines connect to forwards without a bind address, as ssh -g (default: gateway_ports)")
+	flag.DurationVar(&opts.maxDuration, "session-max-duration", 0, "Close the session after it has run this long, warning a minute before (default: session_max_duration)")
+	flag.DurationVar(&opts.stayConnected, "stay-connected", 0, "Keep the connection open this long after the session ends, for exec, put and other commands to reuse (default: stay_connected)")
+	benchmarkFlag := flag.Bool("benchmark", false, "Measure session throughput to the server and exit")
+	hideFlags(flag.CommandLine, "benchmark")
+	lastFlag := flag.Bool("last", false, "Reconnect to the server of the most recent session")
+	pickFlag := flag.Bool("pick", false, "Choose the server from the list even when one is marked default or was used before")
+	flag.BoolVar(&opts.save, "save", false, "Add the user@host[:port] target to the config file under a prompted alias")
+	var fleet fleetFlags
+	fleet.register(flag.CommandLine)
+	broadcastFlag := flag.Bool("broadcast", false, "Open shells on the servers selected by -tag, -hosts or an -alias pattern and type into all of them")
+	// The target may appear anywhere among the flags.
+	for args := os.Args[1:]; ; {
+		_ = flag.CommandLine.Parse(args)
+		if flag.NArg() == 0 || opts.target != "" {
+			break
+		}
+		opts.target, args = flag.Arg(0), flag.Args()[1:]
+	}
+
+	// Load config file
+	config, err := opts.load()
+	if err != nil {
+		exitConfigError(err)
+	}
+
+	if (fleet.match != "" || fleet.dryRun) && !*broadcastFlag {
+		fmt.Fprintln(os.Stderr, "-match and -dry-run select the servers of -broadcast or of commands such as exec")
+		os.Exit(2)
+	}
+	if *broadcastFlag {
+		if opts.target != "" {
+			fleet.target(&opts, opts.target)
+		}
+		if err = runBroadcast(&opts, &fleet, config); err != nil {
+			fmt.Println("Error:", err)
+			os.Exit(exitCode(err, 1))
+		}
+		return
+	}
+
+	var selectedServer *sshtools.Server
+	switch {
+	case opts.target != "" && !strings.Contains(opts.target, "@") && config.ServerByAlias(opts.target) != nil:
+		// 参数是已配置的别名时连接该服务器，而不是同名主机
+		selectedServer = selectServer(config, opts.target, "", "")
+	case opts.target != "":
+		selectedServer, err = adHocServer(&opts, config)
+		if err != nil {
+			fmt.Println("Error:", err)
+			os.Exit(exitCode(err, 1))
+		}
+	case *lastFlag:
+		if selectedServer, err = lastServer(config); err != nil {
+			fmt.Println("Error:", err)
+			os.Exit(exitCode(err, 1))
+		}
+	default:
+		// 未指定服务器时连接标记为 default 的服务器，没有时连接上次使用的服务器
+		if opts.alias == "" && opts.ip == "" && opts.tag == "" && !*pickFlag {
+			selectedServer = implicitServer(config)
+		}
+		if selectedServer == nil {
+			selectedServer = selectServer(config, opts.alias, opts.ip, opts.tag)
+		}
+	}
+
+	if opts.attach != "" && opts.attach != sshtools.AttachNone {
+		if _, err = sshtools.AttachCommand(opts.attach); err != nil {
+			fmt.Fprintln(os.Stderr, "Error: -attach:", err)
+			os.Exit(2)
+		}
+	}
+
+	// 未指定 -stay-connected 时使用配置中的 stay_connected
+	stayConnectedSet := false
+	flag.Visit(func(f *flag.Flag) {
+		stayConnectedSet = stayConnectedSet || f.Name == "stay-connected"
+	})
+	if !stayConnectedSet && !*muxMasterFlag {
+		if opts.stayConnected, err = config.StayConnected(selectedServer); err != nil {
+			fmt.Fprintln(os.Stderr, "Error:", err)
+			os.Exit(exitConfig)
+		}
+	}
+
+	if *muxMasterFlag {
+		if err = runControlMaster(config, selectedServer, opts.stayConnected); err != nil {
+			fmt.Println("Error:", err)
+			os.Exit(exitCode(err, 1))
+		}
+		return
+	}
+	if *benchmarkFlag {
+		if err = runBenchmark(&opts, config, selectedServer); err != nil {
+			fmt.Println("Error:", err)
+			os.Exit(exitCode(err, 1))
+		}
+		return
+	}
+	if *controlFlag != "" {
+		if err = controlCommand(*controlFlag, selectedServer); err != nil {
+			fmt.Println("Error:", err)
+			os.Exit(exitCode(err, 1))
+		}
+		return
+	}
+
+	// 连接所选服务器
+	if !opts.quiet {
+		fmt.Printf("Connecting to %s (%s:%d)...\n", selectedServer.Alias, selectedServer.Address, selectedServer.Port)
+	}
+	err = connectToServer(&opts, config, selectedServer)
+	var exitErr *ssh.ExitError
+	switch {
+	case errors.As(err, &exitErr):
+		// 与 ssh 相同，远程 shell 或命令的退出码作为本程序的退出码
+		os.Exit(exitCode(err, 1))
+	case err != nil:
+		fmt.Fprintln(os.Stderr, "Error:", err)
+		os.Exit(exitCode(err, exitConnect))
+	}
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/aoaeoe/sshTools/pkg/sshtools"
+)
+
+// mountCommand mounts a remote directory at a local one through FUSE and
+// SFTP, until interrupted or unmounted:
+// sshtools mount web1:/var/www ./www
+// sshtools mount -ro -cache 30s web1:/var/log ./logs
+func mountCommand(args []string) {
+	fs := flag.NewFlagSet("mount", flag.ExitOnError)
+	var opts commonFlags
+	opts.register(fs, "mount from")
+	cacheFlag := fs.Duration("cache", 5*time.Second, "Cache file attributes and directory entries this long (0 to ask the server every time)")
+	roFlag := fs.Bool("ro", false, "Mount read-only")
+	allowOtherFlag := fs.Bool("allow-other", false, "Let other local users access the mount (needs user_allow_other in /etc/fuse.conf)")
+	positional := parseArgs(fs, args)
+	alias, remotePath, ok := "", "", false
+	if len(positional) == 2 {
+		alias, remotePath, ok = splitRemote(positional[0])
+	}
+	if !ok {
+		fmt.Fprintln(os.Stderr, "usage: sshtools mount [-ro] [-cache 5s] [-allow-other] <alias>:<path> <dir>")
+		os.Exit(2)
+	}
+	dir := positional[1]
+
+	config, err := opts.load()
+	if err != nil {
+		exitConfigError(err)
+	}
+	server := selectServer(config, alias, "", opts.tag)
+	client, err := dialServer(&opts, config, server)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Error:", err)
+		os.Exit(exitCode(err, exitConnect))
+	}
+	defer func(client *sshtools.Client) {
+		_ = client.Close()
+	}(client)
+	sftpClient, err := client.SFTP()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Error:", err)
+		os.Exit(1)
+	}
+	defer func() { _ = sftpClient.Close() }()
+
+	mount, err := sshtools.MountSFTP(sftpClient, remotePath, dir, sshtools.MountOptions{
+		Name:       server.Alias + ":" + remotePath,
+		CacheTTL:   *cacheFlag,
+		ReadOnly:   *roFlag,
+		AllowOther: *allowOtherFlag,
+	})
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Error:", err)
+		os.Exit(1)
+	}
+	fmt.Printf("Mounted %s:%s at %s. Press Ctrl-C or run \"sshtools unmount %s\" to unmount.\n", server.Alias, remotePath, dir, dir)
+
+	// 卸载失败（目录仍在使用）时继续运行，可以稍后再试
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM, syscall.SIGHUP)
+	go func() {
+		for range signals {
+			if errs := mount.Unmount(); errs != nil {
+				fmt.Fprintln(os.Stderr, "Error:", errs)
+			}
+		}
+	}()
+	// 连接断开后文件都无法访问，卸载并退出
+	go func() {
+		_ = client.Wait()
+		fmt.Fprintf(os.Stderr, "Connection to %s lost, unmounting %s.\n", server.Alias, dir)
+		_ = mount.Unmount()
+	}()
+	mount.Wait()
+}
+
+// unmountCommand unmounts a directory mounted by sshtools mount:
+// sshtools unmount ./www
+func unmountCommand(args []string) {
+	fs := flag.NewFlagSet("unmount", flag.ExitOnError)
+	_ = fs.Parse(args)
+	if fs.NArg() != 1 {
+		fmt.Fprintln(os.Stderr, "usage: sshtools unmount <dir>")
+		os.Exit(2)
+	}
+	if err := sshtools.UnmountDir(fs.Arg(0)); err != nil {
+		fmt.Fprintln(os.Stderr, "Error:", err)
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,210 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"time"
+
+	"github.com/aoaeoe/sshTools/pkg/sshtools"
+)
+
+// dialServer connects to server, going through its control master when
+// control_persist or -stay-connected is set, or when one is running
+// anyway. A missing master is started in the background first; if that
+// fails we fall back to a direct connection.
+func dialServer(opts *commonFlags, config *sshtools.Config, server *sshtools.Server) (client *sshtools.Client, err error) {
+	defer func() {
+		if client != nil {
+			client.Env = sessionEnv(opts, server)
+			client.ForwardAgent = opts.forwardAgent
+		}
+	}()
+	if opts.gatewayPorts {
+		server.GatewayPorts = true
+	}
+	if opts.wake {
+		if err = wakeServer(opts, config, server); err != nil {
+			return
+		}
+	} else if opts.wait {
+		if err = waitForServer(opts, server); err != nil {
+			return
+		}
+	}
+
+	_, enabled, err := config.ControlPersist(server)
+	if err != nil {
+		return
+	}
+	// 远程转发的连接由服务器发往请求它的连接，经控制主进程时收不到
+	stay := opts.stayConnected > 0 && len(server.RemoteForwards) == 0
+	// Ad-hoc user@host targets have no config entry for a master to load.
+	shareable := config.ServerByAlias(server.Alias) == server
+	// 转发的 agent 通道由服务器发往持有连接的进程，经控制主进程时无法应答
+	if opts.forwardAgent || server.ForwardAgent {
+		shareable = false
+	}
+	if !shareable {
+		return dialer.Dial(server)
+	}
+
+	path, err := sshtools.ControlPath(server.Alias)
+	if err != nil {
+		return
+	}
+	// 其他会话以 -stay-connected 保持的连接也可以复用
+	if _, errs := os.Stat(path + ".pub"); errs == nil || enabled || stay {
+		if client, err = sshtools.DialControl(path, server); err == nil {
+			dialer.Logf(1, "using control master %s", path)
+			return
+		}
+	}
+	if !enabled && !stay {
+		return dialer.Dial(server)
+	}
+
+	dialer.Logf(1, "starting control master for %s", server.Alias)
+	if errs := startControlMaster(opts, server, path); errs != nil {
+		fmt.Fprintln(os.Stderr, "control master:", errs)
+		return dialer.Dial(server)
+	}
+	if client, err = sshtools.DialControl(path, server); err != nil {
+		fmt.Fprintln(os.Stderr, "control master:", err)
+		return dialer.Dial(server)
+	}
+	return
+}
+
+// sessionEnv merges the server's set_env with -env-file, reporting the
+// variables the file overrides.
+func sessionEnv(opts *commonFlags, server *sshtools.Server) []sshtools.EnvVar {
+	env, overridden := sshtools.MergeEnv(server.SetEnv, opts.env)
+	for _, name := range overridden {
+		fmt.Fprintf(os.Stderr, "warning: %s from -env-file overrides set_env of %s\n", name, server.Alias)
+	}
+	return env
+}
+
+// startControlMaster re-executes ourselves in -mux-master mode and waits for
+// the socket to come up. The child shares our terminal until it has
+// authenticated so it can prompt, then detaches.
+func startControlMaster(opts *commonFlags, server *sshtools.Server, path string) (err error) {
+	self, err := os.Executable()
+	if err != nil {
+		return
+	}
+	args := append([]string{"-mux-master", "-config", opts.configFile, "-alias", server.Alias}, opts.verbosity()...)
+	if opts.acceptKey {
+		args = append(args, "-accept-changed-host-key")
+	}
+	if opts.askPass {
+		args = append(args, "-ask-pass")
+	}
+	if opts.auth != "" {
+		args = append(args, "-auth", opts.auth)
+	}
+	if opts.legacy {
+		args = append(args, "-legacy")
+	}
+	if opts.timeout > 0 {
+		args = append(args, "-timeout", opts.timeout.String())
+	}
+	if opts.retries >= 0 {
+		args = append(args, "-retries", fmt.Sprint(opts.retries))
+	}
+	if opts.stayConnected > 0 {
+		args = append(args, "-stay-connected", opts.stayConnected.String())
+	}
+	cmd := exec.Command(self, args...)
+	cmd.Stdin, cmd.Stdout, cmd.Stderr = os.Stdin, os.Stdout, os.Stderr
+	cmd.Env = childEnv()
+	cmd.SysProcAttr = detachedProcAttr()
+	if err = cmd.Start(); err != nil {
+		return
+	}
+
+	exited := make(chan error, 1)
+	go func() {
+		exited <- cmd.Wait()
+	}()
+
+	ticker := time.NewTicker(50 * time.Millisecond)
+	defer ticker.Stop()
+	for {
+		select {
+		case errs := <-exited:
+			if errs == nil {
+				errs = fmt.Errorf("exited before the socket was ready")
+			}
+			return errs
+		case <-ticker.C:
+			if _, errs := os.Stat(path + ".pub"); errs == nil {
+				return nil
+			}
+		}
+	}
+}
+
+// runControlMaster is the body of the background master process. A master
+// started for -stay-connected stays that long after its last client.
+func runControlMaster(config *sshtools.Config, server *sshtools.Server, stayConnected time.Duration) (err error) {
+	persist, enabled, err := config.ControlPersist(server)
+	if err != nil {
+		return
+	}
+	if !enabled || stayConnected > persist && persist > 0 {
+		persist = stayConnected
+	}
+	path, err := sshtools.ControlPath(server.Alias)
+	if err != nil {
+		return
+	}
+
+	client, err := dialer.Dial(server)
+	if err != nil {
+		return
+	}
+	defer func(client *sshtools.Client) {
+		_ = client.Close()
+	}(client)
+
+	master := &sshtools.ControlMaster{Client: client, Path: path, Persist: persist}
+	if err = master.Listen(); err != nil {
+		return
+	}
+	forwards := sshtools.NewForwardSet(client)
+	defer forwards.Close()
+	conn := &sshtools.APIConnection{Name: server.Alias, Server: server, Forwards: forwards,
+		Client: func() *sshtools.Client { return client }}
+	defer serveControlAPI(config, "mux-"+server.Alias, []*sshtools.APIConnection{conn})()
+	if err = detachStdio(filepath.Join(filepath.Dir(path), server.Alias+".log")); err != nil {
+		return
+	}
+	return master.Serve()
+}
+
+// controlCommand implements -O check|exit.
+func controlCommand(op string, server *sshtools.Server) (err error) {
+	path, err := sshtools.ControlPath(server.Alias)
+	if err != nil {
+		return
+	}
+	switch op {
+	case "check":
+		pid, errs := sshtools.ControlCheck(path, server)
+		if errs != nil {
+			return errs
+		}
+		fmt.Printf("Master running (pid=%d)\n", pid)
+	case "exit":
+		if err = sshtools.ControlExit(path, server); err != nil {
+			return
+		}
+		fmt.Println("Exit request sent.")
+	default:
+		err = fmt.Errorf("unknown control command %q (want check or exit)", op)
+	}
+	return
+}
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"net"
+	"os"
+
+	"github.com/aoaeoe/sshTools/pkg/sshtools"
+)
+
+// ncCommand connects stdin and stdout to host:port through a server, like
+// ssh -W, for use as the ProxyCommand of other tools:
+// sshtools nc bastion db1.internal 22
+func ncCommand(args []string) {
+	fs := flag.NewFlagSet("nc", flag.ExitOnError)
+	var opts commonFlags
+	opts.register(fs, "connect through")
+	positional := parseArgs(fs, args)
+	if len(positional) == 3 && opts.alias == "" && opts.ip == "" {
+		opts.alias, positional = positional[0], positional[1:]
+	}
+	if len(positional) != 2 || opts.alias == "" && opts.ip == "" {
+		fmt.Fprintln(os.Stderr, "usage: sshtools nc <alias> <host> <port>")
+		os.Exit(2)
+	}
+	host, port := positional[0], positional[1]
+	if _, err := net.LookupPort("tcp", port); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: invalid port %q\n", port)
+		os.Exit(2)
+	}
+
+	config, err := opts.load()
+	if err != nil {
+		exitConfigError(err)
+	}
+	server := selectServer(config, opts.alias, opts.ip, opts.tag)
+	client, err := dialServer(&opts, config, server)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Error:", err)
+		os.Exit(exitCode(err, 1))
+	}
+	defer func(client *sshtools.Client) {
+		_ = client.Close()
+	}(client)
+	if err = client.ForwardStdio(net.JoinHostPort(host, port), os.Stdin, os.Stdout); err != nil {
+		fmt.Fprintln(os.Stderr, "Error:", err)
+		os.Exit(exitCode(err, 1))
+	}
+}
@@ -0,0 +1,358 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/aoaeoe/sshTools/pkg/sshtools"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/term"
+)
+
+// dialer carries the connection options shared by every command.
+var dialer = &sshtools.Dialer{}
+
+// commonFlags are accepted by the default connect mode and by every subcommand.
+type commonFlags struct {
+	configFile   string
+	alias        string
+	ip           string
+	tag          string
+	providers    string
+	verbose      bool
+	veryVerbose  bool
+	debug3       bool
+	debugFile    string
+	notify       bool
+	noSleep      bool
+	autoPort     bool
+	wait         bool
+	wake         bool
+	waitTimeout  time.Duration
+	envFile      string
+	env          []sshtools.EnvVar
+	become       bool
+	attach       string
+	acceptKey    bool
+	askPass      bool
+	auth         string
+	legacy       bool
+	inet         bool
+	compression  bool
+	inet6        bool
+	timeout      time.Duration
+	retries      int
+	forwardAgent bool
+	gatewayPorts bool
+	quiet        bool
+	// noSecrets leaves an encrypted config locked, for commands that do
+	// not connect.
+	noSecrets bool
+
+	// connect mode only
+	command   string
+	readOnly  bool
+	reconnect bool
+	record    string
+	logFile   string
+	logTimes  bool
+	logStrip  bool
+	target    string
+	save      bool
+	share     string
+	shareRW   bool
+	pin       bool
+	term      string
+	// maxDuration closes the session after this long
+	maxDuration time.Duration
+	// stayConnected keeps the connection of the session open this long
+	// afterwards, through a control master
+	stayConnected time.Duration
+}
+
+func (f *commonFlags) register(fs *flag.FlagSet, action string) {
+	fs.StringVar(&f.configFile, "config", sshtools.DefaultConfigFile(), "Path to the configuration file")
+	fs.StringVar(&f.alias, "alias", "", "Server alias to "+action)
+	fs.StringVar(&f.ip, "ip", "", "IP address of the server to "+action)
+	fs.StringVar(&f.tag, "tag", "", "Only consider servers with this tag (\"all\" for every server)")
+	fs.StringVar(&f.providers, "provider", "", "Also consider the machines these providers of the config discover (comma-separated names)")
+	fs.BoolVar(&f.verbose, "v", false, "Log connection diagnostics (DNS, dial, negotiated algorithms, host key, auth methods) to stderr")
+	fs.BoolVar(&f.veryVerbose, "vv", false, "Like -v, plus the offered algorithms and periodic throughput and latency")
+	fs.BoolVar(&f.debug3, "vvv", false, "Like -vv, plus every channel and request")
+	fs.StringVar(&f.debugFile, "debug-file", "", "Append the diagnostics to this file, with timestamps, instead of stderr (implies -v)")
+	fs.BoolVar(&f.notify, "notify", false, "Always notify when a long-running operation finishes")
+	fs.BoolVar(&f.wait, "wait", false, "Retry until the server accepts SSH connections")
+	fs.DurationVar(&f.waitTimeout, "wait-timeout", 5*time.Minute, "Give up -wait after this long")
+	fs.BoolVar(&f.wake, "wake", false, "Send a Wake-on-LAN packet to the server's mac_address, then wait until it accepts SSH connections")
+	fs.BoolVar(&f.autoPort, "auto-port", false, "Forward from a free local port when the configured one is in use")
+	fs.BoolVar(&f.noSleep, "prevent-sleep", false, "Keep this machine awake during transfers, fleet runs and tunnels")
+	fs.BoolVar(&f.acceptKey, "accept-changed-host-key", false, "Replace the known_hosts entry of a server whose host key changed")
+	fs.BoolVar(&f.askPass, "ask-pass", false, "Prompt for the password even when config.json has one")
+	fs.StringVar(&f.auth, "auth", "", "Try these auth methods in order instead of auth_methods (comma-separated: agent, publickey, keyboard-interactive, password)")
+	fs.DurationVar(&f.timeout, "timeout", 0, "Give up the connect and the handshake after this long each (default: connect_timeout, or 15s)")
+	fs.IntVar(&f.retries, "retries", -1, "Retry a failed connection this many times with exponential backoff (default: connection_attempts less one)")
+	fs.BoolVar(&f.compression, "C", false, "Rejected: the SSH library cannot compress, so unlike ssh -C this exits with an error")
+	fs.BoolVar(&f.inet, "4", false, "Connect over IPv4 only")
+	fs.BoolVar(&f.inet6, "6", false, "Connect over IPv6 only")
+	fs.BoolVar(&f.legacy, "legacy", false, "Also offer insecure legacy algorithms (SHA-1 kex, ssh-rsa, CBC ciphers) for old appliances")
+}
+
+// registerEnv adds -env-file and -A to commands that run user sessions.
+func (f *commonFlags) registerEnv(fs *flag.FlagSet) {
+	fs.StringVar(&f.envFile, "env-file", "", "Send the KEY=VALUE lines of this .env file as the session environment")
+	fs.BoolVar(&f.forwardAgent, "A", false, "Forward the local ssh-agent, so the remote host can use its keys (only for hosts you trust)")
+}
+
+// registerQuiet adds -q to commands whose output is meant for piping.
+func (f *commonFlags) registerQuiet(fs *flag.FlagSet) {
+	fs.BoolVar(&f.quiet, "q", false, "Quiet: leave out the tool's own messages (connecting, retries, exit messages, summaries); errors are still reported")
+}
+
+// verbosity returns the -v level and -debug-file as command line arguments,
+// for passing on to child processes.
+func (f *commonFlags) verbosity() (args []string) {
+	switch {
+	case f.debug3:
+		args = []string{"-vvv"}
+	case f.veryVerbose:
+		args = []string{"-vv"}
+	case f.verbose:
+		args = []string{"-v"}
+	}
+	if f.debugFile != "" {
+		args = append(args, "-debug-file", f.debugFile)
+	}
+	return
+}
+
+// load reads the config file and applies the shared options.
+func (f *commonFlags) load() (config *sshtools.Config, err error) {
+	if f.inet && f.inet6 {
+		fmt.Fprintln(os.Stderr, "Error: -4 and -6 cannot be combined")
+		os.Exit(2)
+	}
+	// x/crypto/ssh 只实现了 none 压缩，不能悄悄地不压缩
+	if f.compression {
+		fmt.Fprintln(os.Stderr, "Error: -C is not supported: the SSH library only implements \"none\" compression")
+		os.Exit(2)
+	}
+	switch {
+	case f.debug3:
+		dialer.Verbose = 3
+	case f.veryVerbose:
+		dialer.Verbose = 2
+	case f.verbose, f.debugFile != "":
+		dialer.Verbose = 1
+	}
+	// 日志文件在进程退出时关闭，多个进程可以追加到同一个文件
+	if f.debugFile != "" && dialer.Log == nil {
+		logFile, errs := sshtools.OpenLogFile(f.debugFile)
+		if errs != nil {
+			return nil, errs
+		}
+		dialer.Log = logFile
+	}
+
+	// 没有配置文件时仍可通过 user@host:port 直接连接
+	config, err = sshtools.LoadConfig(f.configFile)
+	if errors.Is(err, os.ErrNotExist) {
+		config, err = &sshtools.Config{}, nil
+	}
+	if err != nil {
+		return
+	}
+	// 配置中有加密的字段时，输入一次主密码在内存中解密
+	if config.Locked() && !f.noSecrets {
+		if err = unlockConfig(f.configFile, config); err != nil {
+			return
+		}
+	}
+	// ${env:...} 和 $(...) 的值在内存中替换，不写回文件
+	if !f.noSecrets {
+		config.Substitute()
+	}
+	// 从云平台实时发现的服务器只加入内存中的配置
+	if f.providers != "" {
+		if err = discoverServers(config, strings.Split(f.providers, ",")); err != nil {
+			return
+		}
+	}
+	// 环境变量只保存在内存中，格式错误时在连接之前失败
+	if f.envFile != "" {
+		if f.env, err = sshtools.ParseEnvFile(f.envFile); err != nil {
+			return
+		}
+	}
+	dialer.AuthCache = !config.DisableAuthCache
+	dialer.AcceptChangedHostKey = f.acceptKey
+	dialer.Legacy = f.legacy
+	switch {
+	case f.inet:
+		dialer.Family = sshtools.FamilyInet
+	case f.inet6:
+		dialer.Family = sshtools.FamilyInet6
+	}
+	dialer.Timeout = f.timeout
+	if f.retries >= 0 {
+		dialer.Attempts = f.retries + 1
+	}
+	dialer.OnRetry = func(server *sshtools.Server, attempt, attempts int, err error, wait time.Duration) {
+		if f.quiet {
+			return
+		}
+		fmt.Fprintf(os.Stderr, "Connecting to %s failed (attempt %d of %d): %v; retrying in %s\n", server.Alias, attempt, attempts, err, wait)
+	}
+	if term.IsTerminal(int(os.Stdin.Fd())) {
+		dialer.PromptPassword = promptPassword
+		dialer.Challenge = answerChallenge
+		dialer.ConfirmHostKey = confirmHostKey
+	} else if f.askPass {
+		err = fmt.Errorf("-ask-pass needs a terminal to prompt on")
+		return
+	}
+	dialer.AskPassword = f.askPass
+	if f.auth != "" {
+		if dialer.AuthMethods, err = sshtools.ParseAuthMethods(f.auth); err != nil {
+			return
+		}
+	}
+	notifier = sshtools.NewNotifier(config)
+	notifier.Force = f.notify
+	notifier.Log = dialer.Logf
+	f.noSleep = f.noSleep || config.PreventSleep
+	return
+}
+
+// promptPassword reads a password from the terminal without echo.
+func promptPassword(prompt string) (string, error) {
+	fmt.Fprint(os.Stderr, prompt)
+	password, err := term.ReadPassword(int(os.Stdin.Fd()))
+	fmt.Fprintln(os.Stderr)
+	return string(password), err
+}
+
+// answerChallenge shows keyboard-interactive prompts on the terminal,
+// reading hidden answers without echo.
+func answerChallenge(name, instruction string, questions []string, echos []bool) (answers []string, err error) {
+	for _, text := range []string{name, instruction} {
+		if text = strings.TrimSpace(text); text != "" {
+			fmt.Fprintln(os.Stderr, text)
+		}
+	}
+	reader := bufio.NewReader(os.Stdin)
+	for i, question := range questions {
+		var answer string
+		if echos[i] {
+			fmt.Fprint(os.Stderr, question)
+			answer, err = reader.ReadString('\n')
+			answer = strings.TrimRight(answer, "\r\n")
+		} else {
+			answer, err = promptPassword(question)
+		}
+		if err != nil {
+			return nil, err
+		}
+		answers = append(answers, answer)
+	}
+	return
+}
+
+// confirmHostKey asks whether to trust the host key of a server seen for
+// the first time.
+func confirmHostKey(server *sshtools.Server, key ssh.PublicKey) bool {
+	fmt.Fprintf(os.Stderr, "The authenticity of %s (%s) can't be established.\n", server.Alias, server.Addr())
+	fmt.Fprintf(os.Stderr, "%s key fingerprint is %s.\n", key.Type(), ssh.FingerprintSHA256(key))
+	return confirm("Trust it and add it to known_hosts? [y/N] ", false)
+}
+
+// registerBecome adds -b, and its longer names -sudo and -become, for
+// commands that run remote commands.
+func (f *commonFlags) registerBecome(fs *flag.FlagSet) {
+	fs.BoolVar(&f.become, "b", false, "Run through sudo, answering its password prompt (see the server's become settings)")
+	fs.BoolVar(&f.become, "sudo", false, "Same as -b")
+	fs.BoolVar(&f.become, "become", false, "Same as -b")
+}
+
+// becomePassword returns the sudo password for -b: from the variable named
+// by the server's become.password_env, from the keychain when its
+// become.password_source is "keychain", or else prompted for.
+func becomePassword(server *sshtools.Server) (string, error) {
+	b := server.Become
+	if b != nil && b.PasswordEnv != "" {
+		if password, ok := os.LookupEnv(b.PasswordEnv); ok {
+			return password, nil
+		}
+	}
+	if b != nil && b.PasswordSource == sshtools.PasswordSourceKeychain {
+		password, err := sshtools.KeychainSecret(server.Alias, sshtools.KeychainSudo)
+		if err == nil {
+			return password, nil
+		}
+		if !errors.Is(err, sshtools.ErrNoKeychainSecret) {
+			return "", fmt.Errorf("sudo password of %s: %v", server.Alias, err)
+		}
+		if !term.IsTerminal(int(os.Stdin.Fd())) {
+			return "", fmt.Errorf("sudo password of %s: %v, store it with sshtools secret set -sudo %s", server.Alias, err, server.Alias)
+		}
+	}
+	if !term.IsTerminal(int(os.Stdin.Fd())) {
+		return "", fmt.Errorf("no sudo password for %s: set become.password_env or run on a terminal", server.Alias)
+	}
+	return promptPassword(fmt.Sprintf("sudo password for %s on %s (empty if none is needed): ", server.User, server.Alias))
+}
+
+// unlockPassword returns what unlocks a session locked by idle_action
+// "lock": the master password of an encrypted config, or else the
+// server's password. It is empty when there is neither.
+func unlockPassword(server *sshtools.Server) string {
+	if masterPassword != "" {
+		return masterPassword
+	}
+	// -no-secrets 时密码仍是密文
+	if sshtools.IsEncrypted(server.Password) {
+		return ""
+	}
+	return server.Password
+}
+
+// preventSleep holds a sleep inhibitor for a long-running operation when
+// -prevent-sleep or prevent_sleep is set. Callers defer the Release.
+func (f *commonFlags) preventSleep(why string) *sshtools.SleepInhibitor {
+	if !f.noSleep {
+		return nil
+	}
+	return sshtools.InhibitSleep(why, os.Stderr)
+}
+
+// parseArgs parses fs with flags allowed between and after the positional
+// arguments, which it returns.
+func parseArgs(fs *flag.FlagSet, args []string) (positional []string) {
+	for {
+		_ = fs.Parse(args)
+		if args = fs.Args(); len(args) == 0 {
+			return
+		}
+		positional, args = append(positional, args[0]), args[1:]
+	}
+}
+
+// hideFlags leaves the named flags out of the usage message of fs.
+func hideFlags(fs *flag.FlagSet, names ...string) {
+	fs.Usage = func() {
+		visible := flag.NewFlagSet(fs.Name(), flag.ContinueOnError)
+		visible.SetOutput(fs.Output())
+		fs.VisitAll(func(f *flag.Flag) {
+			if !slices.Contains(names, f.Name) {
+				visible.Var(f.Value, f.Name, f.Usage)
+			}
+		})
+		fmt.Fprintf(fs.Output(), "Usage of %s:\n", fs.Name())
+		visible.PrintDefaults()
+	}
+}
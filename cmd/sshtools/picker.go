@@ -0,0 +1,315 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"unicode/utf8"
+
+	"github.com/aoaeoe/sshTools/pkg/sshtools"
+	"golang.org/x/term"
+)
+
+// pickerPreviewLines is the height of the connection details below the list.
+const pickerPreviewLines = 7
+
+// pickerPrewarmRecent is how many of the most used servers are pre-dialed
+// besides the selected one.
+const pickerPrewarmRecent = 3
+
+// picker is the full-screen server picker: typing filters the servers by a
+// fuzzy search over alias, address, user and tags, the arrow keys move the
+// selection and Enter picks it.
+type picker struct {
+	servers []*sshtools.Server
+	// prewarm, when set, dials the selected and most used servers while
+	// the picker is open and shows whether the listed ones are reachable.
+	prewarm *sshtools.Prewarmer
+
+	// mu serializes input handling with redraws for prewarm results.
+	mu      sync.Mutex
+	done    bool
+	query   []rune
+	matches []*sshtools.Server
+	cursor  int
+	offset  int
+}
+
+// runPicker shows the picker on the terminal, listing servers in their
+// order; nil if the user cancels with Esc or Ctrl-C.
+func runPicker(servers []*sshtools.Server, prewarm *sshtools.Prewarmer) (selected *sshtools.Server, err error) {
+	p := &picker{servers: servers, prewarm: prewarm}
+	p.filter()
+
+	fd := int(os.Stdin.Fd())
+	state, err := sshtools.MakeRaw(fd)
+	if err != nil {
+		return
+	}
+	// 使用备用屏幕，退出后恢复原来的终端内容
+	fmt.Print("\x1b[?1049h")
+	defer func() {
+		p.mu.Lock()
+		p.done = true
+		p.mu.Unlock()
+		fmt.Print("\x1b[?1049l")
+		_ = sshtools.Restore(fd, state)
+	}()
+	if prewarm != nil {
+		prewarm.OnChange = func() {
+			p.mu.Lock()
+			defer p.mu.Unlock()
+			if !p.done {
+				p.draw()
+			}
+		}
+	}
+
+	buf := make([]byte, 256)
+	p.mu.Lock()
+	p.draw()
+	p.mu.Unlock()
+	for {
+		n, errs := os.Stdin.Read(buf)
+		if errs != nil {
+			return nil, errs
+		}
+		p.mu.Lock()
+		done, server := p.handle(buf[:n])
+		if !done {
+			p.draw()
+		}
+		p.mu.Unlock()
+		if done {
+			return server, nil
+		}
+	}
+}
+
+// filter recomputes the matches of the query and keeps the cursor on them.
+func (p *picker) filter() {
+	p.matches = sshtools.FuzzyFilter(p.servers, string(p.query))
+	p.cursor, p.offset = 0, 0
+}
+
+// move moves the selection by delta, staying within the matches.
+func (p *picker) move(delta int) {
+	p.cursor = max(min(p.cursor+delta, len(p.matches)-1), 0)
+}
+
+// handle applies a chunk of terminal input. done is set on Enter, with the
+// selected server, and on cancel, with nil.
+func (p *picker) handle(input []byte) (done bool, selected *sshtools.Server) {
+	for i := 0; i < len(input); i++ {
+		switch b := input[i]; {
+		case b == '\r' || b == '\n':
+			if len(p.matches) == 0 {
+				continue
+			}
+			return true, p.matches[p.cursor]
+		case b == 3 || b == 4: // Ctrl-C, Ctrl-D
+			return true, nil
+		case b == 0x1b:
+			if i == len(input)-1 {
+				return true, nil // Esc
+			}
+			seq := escapeSequence(input[i:])
+			switch seq {
+			case "\x1b[A", "\x1bOA":
+				p.move(-1)
+			case "\x1b[B", "\x1bOB":
+				p.move(1)
+			case "\x1b[5~":
+				p.move(-p.listHeight())
+			case "\x1b[6~":
+				p.move(p.listHeight())
+			}
+			i += len(seq) - 1
+		case b == 0x10: // Ctrl-P
+			p.move(-1)
+		case b == 0x0e: // Ctrl-N
+			p.move(1)
+		case b == 0x7f || b == 0x08:
+			if len(p.query) > 0 {
+				p.query = p.query[:len(p.query)-1]
+				p.filter()
+			}
+		case b == 0x15: // Ctrl-U
+			p.query = nil
+			p.filter()
+		case b == 0x17: // Ctrl-W
+			query := strings.TrimRight(string(p.query), " ")
+			p.query = []rune(query[:strings.LastIndex(query, " ")+1])
+			p.filter()
+		case b < 0x20:
+		default:
+			r, size := utf8.DecodeRune(input[i:])
+			p.query = append(p.query, r)
+			p.filter()
+			i += size - 1
+		}
+	}
+	return false, nil
+}
+
+// escapeSequence returns the escape sequence at the start of input: ESC [
+// or ESC O, parameters and a final byte.
+func escapeSequence(input []byte) string {
+	if len(input) < 2 || input[1] != '[' && input[1] != 'O' {
+		return string(input[:min(len(input), 2)])
+	}
+	for i := 2; i < len(input); i++ {
+		if input[i] >= 0x40 && input[i] <= 0x7e {
+			return string(input[:i+1])
+		}
+	}
+	return string(input)
+}
+
+// size returns the terminal size, or 80x24 if it is unknown.
+func (p *picker) size() (width, height int) {
+	width, height, err := term.GetSize(int(os.Stdout.Fd()))
+	if err != nil || width <= 0 || height <= 0 {
+		return 80, 24
+	}
+	return
+}
+
+// listHeight is the number of list rows that fit below the query line and
+// above the preview.
+func (p *picker) listHeight() int {
+	_, height := p.size()
+	if height > pickerPreviewLines+5 {
+		height -= pickerPreviewLines + 1
+	}
+	return max(height-2, 1)
+}
+
+// warm has prewarm dial the selected and the most used servers, and check
+// the visible ones.
+func (p *picker) warm(visible []*sshtools.Server) {
+	if p.prewarm == nil {
+		return
+	}
+	if len(p.matches) > 0 {
+		p.prewarm.Warm(p.matches[p.cursor])
+	}
+	for _, server := range p.servers[:min(len(p.servers), pickerPrewarmRecent)] {
+		p.prewarm.Warm(server)
+	}
+	for _, server := range visible {
+		p.prewarm.Check(server)
+	}
+}
+
+// reachDot marks whether a server is reachable: green when it is, red
+// when it is not, hollow while it is being checked.
+func reachDot(state sshtools.Reachability) string {
+	switch state {
+	case sshtools.ReachUp:
+		return "\x1b[32m●\x1b[0m"
+	case sshtools.ReachDown:
+		return "\x1b[31m●\x1b[0m"
+	case sshtools.ReachPending:
+		return "\x1b[2m○\x1b[0m"
+	}
+	return " "
+}
+
+// draw repaints the whole screen: the query, the matches and the details
+// of the selected server. The caller holds p.mu.
+func (p *picker) draw() {
+	width, _ := p.size()
+	rows := p.listHeight()
+	if p.cursor < p.offset {
+		p.offset = p.cursor
+	} else if p.cursor >= p.offset+rows {
+		p.offset = p.cursor - rows + 1
+	}
+	visible := p.matches[p.offset:min(p.offset+rows, len(p.matches))]
+	p.warm(visible)
+	// 预连接时每行前显示可达状态
+	textWidth := width
+	if p.prewarm != nil {
+		textWidth = max(width-2, 1)
+	}
+
+	lines := []string{
+		fitWidth("> "+string(p.query), width),
+		"\x1b[2m" + fitWidth(fmt.Sprintf("  %d/%d  (↑/↓ select, Enter connect, Esc cancel, #tag filter)",
+			len(p.matches), len(p.servers)), width) + "\x1b[0m",
+	}
+	for i, server := range visible {
+		i += p.offset
+		line := "  "
+		if server.Favorite {
+			line = "* "
+		}
+		line += fmt.Sprintf("%-20s %s@%s:%d", server.Alias, server.User, server.Address, server.Port)
+		if len(server.Tags) > 0 {
+			line += "  #" + strings.Join(server.Tags, " #")
+		}
+		line = fitWidth(line, textWidth)
+		switch {
+		case i == p.cursor:
+			line = "\x1b[7m" + line + "\x1b[0m"
+		case server.Deprecated:
+			line = "\x1b[2m" + line + "\x1b[0m"
+		}
+		if p.prewarm != nil {
+			line = reachDot(p.prewarm.State(server)) + " " + line
+		}
+		lines = append(lines, line)
+	}
+	if _, height := p.size(); height > pickerPreviewLines+5 && len(p.matches) > 0 {
+		for len(lines) < rows+2 {
+			lines = append(lines, "")
+		}
+		lines = append(lines, "\x1b[2m"+strings.Repeat("─", width)+"\x1b[0m")
+		for _, line := range previewServer(p.matches[p.cursor]) {
+			lines = append(lines, fitWidth(line, width))
+		}
+	}
+	// 光标停在输入行末尾
+	fmt.Printf("\x1b[H\x1b[2J%s\x1b[1;%dH", strings.Join(lines, "\r\n"), 3+len(p.query))
+}
+
+// previewServer describes how sshtools would connect to server.
+func previewServer(server *sshtools.Server) (lines []string) {
+	lines = append(lines,
+		"Alias:    "+server.Alias,
+		fmt.Sprintf("Address:  %s@%s:%d", server.User, strings.Join(server.AddressList(), ", "), server.Port),
+		"Auth:     "+server.AuthMethod())
+	if len(server.Tags) > 0 {
+		lines = append(lines, "Tags:     "+strings.Join(server.Tags, ", "))
+	}
+	switch {
+	case server.ProxyJump != "":
+		lines = append(lines, "Via:      "+server.ProxyJump)
+	case server.ProxyCommand != "":
+		lines = append(lines, "Via:      "+server.ProxyCommand)
+	case server.UsesSSM():
+		lines = append(lines, "Via:      SSM "+server.InstanceID)
+	case server.WebSocket != "":
+		lines = append(lines, "Via:      "+server.RedactedWebSocket())
+	case server.UsesProxy():
+		lines = append(lines, "Via:      "+server.RedactedProxy())
+	}
+	if server.Deprecated {
+		note := "Deprecated"
+		if server.RedirectTo != "" {
+			note += ", use " + server.RedirectTo
+		}
+		lines = append(lines, note)
+	}
+	return lines[:min(len(lines), pickerPreviewLines-1)]
+}
+
+// fitWidth cuts s to width runes.
+func fitWidth(s string, width int) string {
+	if utf8.RuneCountInString(s) <= width {
+		return s
+	}
+	return string([]rune(s)[:max(width-1, 0)]) + "…"
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/aoaeoe/sshTools/pkg/sshtools"
+)
+
+// pingCommand checks that a server answers SSH without logging in:
+// sshtools ping -alias web1 [-c 3]
+func pingCommand(args []string) {
+	fs := flag.NewFlagSet("ping", flag.ExitOnError)
+	var opts commonFlags
+	opts.register(fs, "ping")
+	countFlag := fs.Int("c", 1, "Number of probes to send")
+	_ = fs.Parse(args)
+
+	config, err := opts.load()
+	if err != nil {
+		exitConfigError(err)
+	}
+	server := selectServer(config, opts.alias, opts.ip, opts.tag)
+
+	failed := false
+	for i := 0; i < *countFlag; i++ {
+		if i > 0 {
+			time.Sleep(time.Second)
+		}
+		res, err := dialer.Probe(server)
+		if err != nil {
+			failed = true
+			fmt.Printf("%s: %v\n", server.Alias, err)
+			continue
+		}
+		fmt.Printf("%s (%s): connect %s, banner %s, %s\n", server.Alias, server.Addr(),
+			res.Connect.Round(10*time.Microsecond), res.Banner.Round(10*time.Microsecond), res.Version)
+	}
+	if failed {
+		os.Exit(1)
+	}
+}
+
+// waitForServer probes server with backoff until it answers SSH or
+// -wait-timeout expires, printing a line per attempt.
+func waitForServer(opts *commonFlags, server *sshtools.Server) (err error) {
+	const maxBackoff = 30 * time.Second
+	deadline := time.Now().Add(opts.waitTimeout)
+	backoff := time.Second
+	for attempt := 1; ; attempt++ {
+		_, err = dialer.Probe(server)
+		if err == nil {
+			if attempt > 1 {
+				fmt.Fprintf(os.Stderr, "%s is up after %d attempts\n", server.Alias, attempt)
+			}
+			return
+		}
+		if time.Now().Add(backoff).After(deadline) {
+			return fmt.Errorf("gave up waiting for %s after %s: %v", server.Alias, opts.waitTimeout, err)
+		}
+		fmt.Fprintf(os.Stderr, "waiting for %s: %v (attempt %d, retrying in %s)\n", server.Alias, err, attempt, backoff)
+		time.Sleep(backoff)
+		backoff = min(backoff*2, maxBackoff)
+	}
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+
+	"github.com/aoaeoe/sshTools/pkg/sshtools"
+)
+
+// portsCommand lists listening sockets on a server and can forward one of
+// them locally: sshtools ports -alias web1 [-forward]
+func portsCommand(args []string) {
+	fs := flag.NewFlagSet("ports", flag.ExitOnError)
+	var opts commonFlags
+	opts.register(fs, "inspect")
+	forwardFlag := fs.Bool("forward", false, "Pick a listening TCP port and forward it locally")
+	_ = fs.Parse(args)
+
+	config, err := opts.load()
+	if err != nil {
+		exitConfigError(err)
+	}
+	server := selectServer(config, opts.alias, opts.ip, opts.tag)
+
+	client, err := dialServer(&opts, config, server)
+	if err != nil {
+		fmt.Println("Error:", err)
+		os.Exit(exitCode(err, 1))
+	}
+	defer func(client *sshtools.Client) {
+		if errs := client.Close(); errs != nil {
+			fmt.Println(errs.Error())
+		}
+	}(client)
+
+	sockets, err := client.ListeningSockets()
+	if err != nil {
+		fmt.Println("Error:", err)
+		os.Exit(exitCode(err, 1))
+	}
+	if len(sockets) == 0 {
+		fmt.Printf("No listening sockets found on %s.\n", server.Alias)
+		return
+	}
+
+	fmt.Printf("%-4s %-5s %-40s %-6s %s\n", "#", "PROTO", "ADDRESS", "PORT", "PROCESS")
+	for i, s := range sockets {
+		process := s.Process
+		if process == "" {
+			process = "-"
+		}
+		fmt.Printf("%-4d %-5s %-40s %-6d %s\n", i+1, s.Proto, s.Address, s.Port, process)
+	}
+	if !*forwardFlag {
+		return
+	}
+
+	reader := bufio.NewReader(os.Stdin)
+	fmt.Print("Select a socket to forward: ")
+	line, _ := reader.ReadString('\n')
+	n, err := strconv.Atoi(strings.TrimSpace(line))
+	if err != nil || n < 1 || n > len(sockets) {
+		fmt.Println("Invalid choice, exiting.")
+		os.Exit(1)
+	}
+	socket := sockets[n-1]
+	if socket.Proto != "tcp" {
+		fmt.Println("Only TCP sockets can be forwarded.")
+		os.Exit(1)
+	}
+
+	fmt.Printf("Local port [%d]: ", socket.Port)
+	line, _ = reader.ReadString('\n')
+	localPort := socket.Port
+	if line = strings.TrimSpace(line); line != "" {
+		if localPort, err = strconv.Atoi(line); err != nil {
+			fmt.Println("Invalid port, exiting.")
+			os.Exit(1)
+		}
+	}
+
+	localAddr := fmt.Sprintf("127.0.0.1:%d", localPort)
+	listener, err := forward(&opts, client, 1, localAddr, socket.ForwardTarget())
+	if err != nil {
+		fmt.Println("Error:", err)
+		os.Exit(exitCode(err, 1))
+	}
+	fmt.Printf("Forwarding on %s, press Ctrl-C to stop.\n", server.Alias)
+
+	interrupt := make(chan os.Signal, 1)
+	signal.Notify(interrupt, os.Interrupt)
+	<-interrupt
+	_ = listener.Close()
+}
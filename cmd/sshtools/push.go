@@ -0,0 +1,211 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/aoaeoe/sshTools/pkg/sshtools"
+)
+
+// pushFileCommand distributes a file to many servers, or rolls a previous
+// run back: sshtools push-file ./sshd_config -to /etc/ssh/sshd_config -tag all -sudo
+// sshtools push-file ./artifact.tar.gz -to /opt/releases/ -tag app -via bastion
+func pushFileCommand(args []string) {
+	fs := flag.NewFlagSet("push-file", flag.ExitOnError)
+	var opts commonFlags
+	var fleet fleetFlags
+	opts.register(fs, "push to")
+	fleet.register(fs)
+	toFlag := fs.String("to", "", "Absolute remote path to install the file at; ending in / installs it in that directory under its own name")
+	viaFlag := fs.String("via", "", "Upload the file once to this server, which copies it to the others with ssh")
+	concurrencyFlag := fs.Int("concurrency", fleetParallel, "Push to at most this many servers at once")
+	sudoFlag := fs.Bool("sudo", false, "Install through sudo -n")
+	validateFlag := fs.String("validate-cmd", "", "Command that must succeed before the install is kept; {} is the staged file")
+	rollbackFlag := fs.String("rollback", "", "Restore the originals replaced by this run id")
+
+	// Allow the source file before the flags.
+	var source string
+	if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		source, args = args[0], args[1:]
+	}
+	_ = fs.Parse(args)
+	if source == "" {
+		source = fs.Arg(0)
+	}
+
+	config, err := opts.load()
+	if err != nil {
+		exitConfigError(err)
+	}
+	if *rollbackFlag != "" {
+		rollbackRun(&opts, config, *rollbackFlag)
+		return
+	}
+
+	if source == "" || !path.IsAbs(*toFlag) {
+		fmt.Fprintln(os.Stderr, "usage: sshtools push-file <file> -to /absolute/remote/path[/] (-tag <tag> | -alias <pattern>) [-via <alias>] [-sudo] [-validate-cmd cmd]")
+		os.Exit(2)
+	}
+	dest := *toFlag
+	if strings.HasSuffix(dest, "/") {
+		dest = path.Join(dest, filepath.Base(source))
+	}
+	data, err := os.ReadFile(source)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Error:", err)
+		os.Exit(exitCode(err, 1))
+	}
+	servers, err := fleet.servers(config, &opts)
+	if err != nil || len(servers) == 0 {
+		if err == nil {
+			err = fmt.Errorf("no server matched")
+		}
+		fmt.Fprintln(os.Stderr, "Error:", err)
+		os.Exit(exitCode(err, 1))
+	}
+
+	inhibitor := opts.preventSleep("sshtools push-file")
+	defer inhibitor.Release()
+
+	// 经中转服务器分发时本机只上传一次，由它转发本机的 ssh-agent 登录各目标
+	var relay *sshtools.PushRelay
+	if *viaFlag != "" {
+		staging := config.ServerByAlias(*viaFlag)
+		if staging == nil {
+			fmt.Fprintf(os.Stderr, "Error: unknown alias %q in -via\n", *viaFlag)
+			os.Exit(2)
+		}
+		client, errs := dialServer(&opts, config, staging)
+		if errs != nil {
+			fmt.Fprintln(os.Stderr, "Error:", errs)
+			os.Exit(exitCode(errs, exitConnect))
+		}
+		defer func() {
+			_ = client.Close()
+		}()
+		client.ForwardAgent = true
+		fmt.Printf("Uploading %s to %s...\n", source, staging.Alias)
+		if relay, errs = client.NewPushRelay(data); errs != nil {
+			fmt.Fprintln(os.Stderr, "Error:", errs)
+			os.Exit(1)
+		}
+		defer func() {
+			_ = relay.Close()
+		}()
+	}
+
+	start := time.Now()
+	run := &sshtools.PushRun{
+		ID:          sshtools.NewRunID(start),
+		Source:      source,
+		Dest:        dest,
+		SHA256:      sshtools.SHA256(data),
+		Sudo:        *sudoFlag,
+		ValidateCmd: *validateFlag,
+		Via:         *viaFlag,
+		Started:     start,
+		Hosts:       make([]sshtools.PushHost, len(servers)),
+	}
+	pushOpts := sshtools.PushOptions{
+		Dest:        run.Dest,
+		Backup:      sshtools.BackupPath(run.Dest, run.ID),
+		Sudo:        run.Sudo,
+		ValidateCmd: run.ValidateCmd,
+		Relay:       relay,
+	}
+	via := ""
+	if run.Via != "" {
+		via = " via " + run.Via
+	}
+	fmt.Printf("Run %s: pushing %s (sha256 %s) to %s on %d servers%s\n", run.ID, source, run.SHA256[:12], run.Dest, len(servers), via)
+
+	forEachServer(servers, *concurrencyFlag, func(i int, server *sshtools.Server) {
+		client, errs := dialServer(&opts, config, server)
+		if errs != nil {
+			run.Hosts[i] = sshtools.PushHost{Alias: server.Alias, Address: server.Address, Status: sshtools.PushFailed, Error: errs.Error()}
+		} else {
+			run.Hosts[i] = client.PushFile(data, pushOpts)
+			_ = client.Close()
+		}
+		printPushHost(run.Hosts[i])
+	})
+
+	failures := 0
+	for _, host := range run.Hosts {
+		if host.Status != sshtools.PushOK {
+			failures++
+		}
+	}
+	if err = run.Save(); err != nil {
+		fmt.Fprintln(os.Stderr, "Error:", err)
+	}
+	fmt.Printf("Run %s: %d updated, %d failed.\n", run.ID, len(servers)-failures, failures)
+	if failures < len(servers) {
+		fmt.Printf("Undo with: sshtools push-file -rollback %s\n", run.ID)
+	}
+	notifier.Done("push-file", len(servers), time.Since(start), failures)
+	if failures > 0 {
+		// os.Exit 不执行 defer，先删除中转服务器上的文件
+		if relay != nil {
+			_ = relay.Close()
+		}
+		os.Exit(1)
+	}
+}
+
+func printPushHost(host sshtools.PushHost) {
+	switch {
+	case host.Status == sshtools.PushFailed:
+		fmt.Printf("  %-20s FAILED  %s\n", host.Alias, host.Error)
+	case host.Existed:
+		fmt.Printf("  %-20s %-7s backup %s\n", host.Alias, host.Status, host.Backup)
+	default:
+		fmt.Printf("  %-20s %-7s (new file)\n", host.Alias, host.Status)
+	}
+}
+
+// rollbackRun restores the originals on every host where run id succeeded.
+func rollbackRun(opts *commonFlags, config *sshtools.Config, id string) {
+	run, err := sshtools.LoadRun(id)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Error:", err)
+		os.Exit(exitCode(err, 1))
+	}
+
+	failures, total := 0, 0
+	for i := range run.Hosts {
+		host := &run.Hosts[i]
+		if host.Status != sshtools.PushOK {
+			continue
+		}
+		total++
+		server := config.ServerByAlias(host.Alias)
+		if server == nil {
+			err = fmt.Errorf("server %q is no longer in the config", host.Alias)
+		} else if client, errs := dialServer(opts, config, server); errs != nil {
+			err = errs
+		} else {
+			err = client.RollbackFile(*host, run.Dest, run.Sudo)
+			_ = client.Close()
+		}
+		if err != nil {
+			failures++
+			fmt.Printf("  %-20s FAILED  %s\n", host.Alias, err)
+			continue
+		}
+		host.Status = sshtools.PushRolledBack
+		fmt.Printf("  %-20s rolled back\n", host.Alias)
+	}
+	if err = run.Save(); err != nil {
+		fmt.Fprintln(os.Stderr, "Error:", err)
+	}
+	fmt.Printf("Run %s: %d restored, %d failed.\n", run.ID, total-failures, failures)
+	if failures > 0 {
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/aoaeoe/sshTools/pkg/sshtools"
+)
+
+// importPuTTYCommand copies the saved sessions of PuTTY into the config
+// file, skipping aliases it already has:
+// sshtools import-putty                      # the registry, or ~/.putty/sessions
+// sshtools import-putty -file sessions.reg [-n]
+func importPuTTYCommand(args []string) {
+	fs := flag.NewFlagSet("import-putty", flag.ExitOnError)
+	configFlag := fs.String("config", sshtools.DefaultConfigFile(), "Path to the configuration file to add the sessions to")
+	fileFlag := fs.String("file", "", "A .reg export of the PuTTY sessions or a directory like ~/.putty/sessions (default: the local PuTTY's sessions)")
+	dryRunFlag := fs.Bool("n", false, "Only show what would be imported")
+	_ = fs.Parse(args)
+	if fs.NArg() > 0 {
+		fmt.Fprintln(os.Stderr, "usage: sshtools import-putty [-file sessions.reg|dir] [-config config.json] [-n]")
+		os.Exit(2)
+	}
+
+	opts := commonFlags{configFile: *configFlag, noSecrets: true}
+	config, err := opts.load()
+	if err != nil {
+		exitConfigError(err)
+	}
+	servers, notes, err := sshtools.ImportPuTTY(*fileFlag)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Error:", err)
+		os.Exit(1)
+	}
+	source := *fileFlag
+	if source == "" {
+		source = "PuTTY"
+	}
+
+	for _, note := range notes {
+		fmt.Println("note:", note)
+	}
+	added := 0
+	for i := range servers {
+		server := &servers[i]
+		if config.ServerByAlias(server.Alias) != nil {
+			fmt.Printf("skipped %s: alias already configured\n", server.Alias)
+			continue
+		}
+		if !*dryRunFlag {
+			if err = sshtools.AppendServer(*configFlag, server); err != nil {
+				fmt.Fprintln(os.Stderr, "Error:", err)
+				os.Exit(1)
+			}
+		}
+		added++
+		fmt.Printf("added %s (%s@%s)\n", server.Alias, server.User, server.Addr())
+	}
+	if *dryRunFlag {
+		fmt.Printf("Would import %d of %d session(s) from %s.\n", added, len(servers), source)
+		return
+	}
+	fmt.Printf("Imported %d of %d session(s) from %s into %s.\n", added, len(servers), source, *configFlag)
+}
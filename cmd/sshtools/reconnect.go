@@ -0,0 +1,199 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aoaeoe/sshTools/pkg/sshtools"
+	"golang.org/x/crypto/ssh"
+)
+
+// stdinRelay reads stdin for every session of a -reconnect run. A session
+// whose connection dropped is still waiting in a read of stdin, which would
+// swallow the first keystrokes meant for the next session; with the relay,
+// whatever that read returns goes to whoever reads next.
+type stdinRelay struct {
+	chunks chan []byte
+	err    error // set before chunks is closed
+}
+
+func newStdinRelay() *stdinRelay {
+	r := &stdinRelay{chunks: make(chan []byte)}
+	go func() {
+		for {
+			buf := make([]byte, 32<<10)
+			n, err := os.Stdin.Read(buf)
+			if n > 0 {
+				r.chunks <- buf[:n]
+			}
+			if err != nil {
+				r.err = err
+				close(r.chunks)
+				return
+			}
+		}
+	}()
+	return r
+}
+
+// reader returns a reader of the relayed input that reports EOF once
+// closed. It passes for stdin with the terminal, so it can be put into raw
+// mode and sized.
+func (r *stdinRelay) reader() *relayReader {
+	return &relayReader{relay: r, done: make(chan struct{})}
+}
+
+type relayReader struct {
+	relay   *stdinRelay
+	done    chan struct{}
+	once    sync.Once
+	pending []byte
+}
+
+func (rr *relayReader) Read(p []byte) (int, error) {
+	if len(rr.pending) == 0 {
+		select {
+		case chunk, ok := <-rr.relay.chunks:
+			if !ok {
+				return 0, rr.relay.err
+			}
+			rr.pending = chunk
+		case <-rr.done:
+			return 0, io.EOF
+		}
+	}
+	n := copy(p, rr.pending)
+	rr.pending = rr.pending[n:]
+	return n, nil
+}
+
+// Fd returns the file descriptor of stdin.
+func (rr *relayReader) Fd() uintptr {
+	return os.Stdin.Fd()
+}
+
+func (rr *relayReader) Close() error {
+	rr.once.Do(func() { close(rr.done) })
+	return nil
+}
+
+// prompt reads a line of relayed input, echoing it if echo is set.
+func (r *stdinRelay) prompt(prompt string, echo bool) (line string, err error) {
+	fd := int(os.Stdin.Fd())
+	state, err := sshtools.MakeRaw(fd)
+	if err != nil {
+		return
+	}
+	defer func() {
+		_ = sshtools.Restore(fd, state)
+	}()
+	fmt.Fprint(os.Stderr, prompt)
+	input := r.reader()
+	defer func() {
+		_ = input.Close()
+	}()
+
+	var typed []rune
+	buf := make([]byte, 256)
+	for {
+		n, errs := input.Read(buf)
+		if errs != nil {
+			return "", errs
+		}
+		for _, c := range string(buf[:n]) {
+			switch c {
+			case '\r', '\n':
+				fmt.Fprint(os.Stderr, "\r\n")
+				return string(typed), nil
+			case 3: // Ctrl-C
+				fmt.Fprint(os.Stderr, "\r\n")
+				return "", errors.New("interrupted")
+			case 0x7f, '\b':
+				if len(typed) > 0 {
+					typed = typed[:len(typed)-1]
+					if echo {
+						fmt.Fprint(os.Stderr, "\b \b")
+					}
+				}
+			default:
+				if c >= 0x20 {
+					typed = append(typed, c)
+					if echo {
+						fmt.Fprint(os.Stderr, string(c))
+					}
+				}
+			}
+		}
+	}
+}
+
+// promptPassword is promptPassword reading through the relay.
+func (r *stdinRelay) promptPassword(prompt string) (string, error) {
+	return r.prompt(prompt, false)
+}
+
+// challenge is answerChallenge reading through the relay.
+func (r *stdinRelay) challenge(name, instruction string, questions []string, echos []bool) (answers []string, err error) {
+	for _, text := range []string{name, instruction} {
+		if text = strings.TrimSpace(text); text != "" {
+			fmt.Fprintln(os.Stderr, text)
+		}
+	}
+	for i, question := range questions {
+		answer, errs := r.prompt(question, echos[i])
+		if errs != nil {
+			return nil, errs
+		}
+		answers = append(answers, answer)
+	}
+	return
+}
+
+// connectionDropped reports whether a session ended because its connection
+// went away rather than because the remote shell exited or we hung up.
+func connectionDropped(client *sshtools.Client, err error) bool {
+	var exitErr *ssh.ExitError
+	if err == nil || errors.As(err, &exitErr) {
+		return false
+	}
+	return client.Dropped(time.Second)
+}
+
+// redial connects to server again for -reconnect, retrying with backoff
+// until it succeeds or the user gives up with Ctrl-C. Prompts read through
+// relay, and a host key that is not known yet is refused.
+func redial(opts *commonFlags, config *sshtools.Config, server *sshtools.Server, relay *stdinRelay) (client *sshtools.Client, err error) {
+	const maxBackoff = 30 * time.Second
+	if dialer.PromptPassword != nil {
+		defer func(prompt func(string) (string, error), challenge func(string, string, []string, []bool) ([]string, error),
+			confirm func(*sshtools.Server, ssh.PublicKey) bool) {
+			dialer.PromptPassword, dialer.Challenge, dialer.ConfirmHostKey = prompt, challenge, confirm
+		}(dialer.PromptPassword, dialer.Challenge, dialer.ConfirmHostKey)
+		dialer.PromptPassword, dialer.Challenge, dialer.ConfirmHostKey = relay.promptPassword, relay.challenge, nil
+	}
+
+	interrupt := make(chan os.Signal, 1)
+	signal.Notify(interrupt, os.Interrupt)
+	defer signal.Stop(interrupt)
+	backoff := time.Second
+	for {
+		fmt.Fprintf(os.Stderr, "Reconnecting to %s in %s (Ctrl-C to give up)...\n", server.Alias, backoff)
+		select {
+		case <-interrupt:
+			return nil, fmt.Errorf("gave up reconnecting to %s", server.Alias)
+		case <-time.After(backoff):
+		}
+		if client, err = dialServer(opts, config, server); err == nil {
+			fmt.Fprintf(os.Stderr, "Reconnected to %s.\n", server.Alias)
+			return
+		}
+		fmt.Fprintf(os.Stderr, "Reconnect failed: %v\n", err)
+		backoff = min(backoff*2, maxBackoff)
+	}
+}
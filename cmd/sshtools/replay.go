@@ -0,0 +1,36 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/aoaeoe/sshTools/pkg/sshtools"
+)
+
+// replayCommand plays a session recorded with -record:
+// sshtools replay [-speed 2] [-idle 1s] session.cast
+func replayCommand(args []string) {
+	fs := flag.NewFlagSet("replay", flag.ExitOnError)
+	var opts sshtools.ReplayOptions
+	fs.Float64Var(&opts.Speed, "speed", 1, "Play this many times faster")
+	fs.DurationVar(&opts.MaxIdle, "idle", 0, "Shorten pauses longer than this, e.g. 2s")
+	positional := parseArgs(fs, args)
+	if len(positional) != 1 || opts.Speed <= 0 {
+		fmt.Fprintln(os.Stderr, "usage: sshtools replay [-speed 2] [-idle 2s] <recording.cast>")
+		os.Exit(2)
+	}
+
+	f, err := os.Open(positional[0])
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Error:", err)
+		os.Exit(1)
+	}
+	defer func(f *os.File) {
+		_ = f.Close()
+	}(f)
+	if err = sshtools.Replay(f, os.Stdout, opts); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %s: %v\n", positional[0], err)
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,182 @@
+package main
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"runtime"
+	"runtime/debug"
+	"strings"
+	"time"
+
+	"github.com/aoaeoe/sshTools/pkg/sshtools"
+	"golang.org/x/term"
+)
+
+// version is set at build time with -ldflags "-X main.version=...".
+var version = "dev"
+
+// reportSessionOutput bounds the session output kept in a debug report.
+const reportSessionOutput = 16 << 10
+
+// reportFile is one file of a debug report bundle.
+type reportFile struct {
+	name string
+	data []byte
+}
+
+// stampedWriter prefixes each line with the time since start, so the
+// connection log shows how long each stage took.
+type stampedWriter struct {
+	w     io.Writer
+	start time.Time
+}
+
+func (s *stampedWriter) Write(p []byte) (int, error) {
+	line := strings.TrimRight(string(p), "\r\n")
+	_, err := fmt.Fprintf(s.w, "%+10.1fms %s\n", float64(time.Since(s.start).Microseconds())/1000, line)
+	return len(p), err
+}
+
+// debugReportCommand collects a sanitized diagnostic bundle for a server:
+// sshtools debug-report -alias web1 [-o report.tar.gz] [-y]
+func debugReportCommand(args []string) {
+	fs := flag.NewFlagSet("debug-report", flag.ExitOnError)
+	var opts commonFlags
+	opts.register(fs, "diagnose")
+	outFlag := fs.String("o", "", "Bundle to write (default sshtools-report-<alias>-<time>.tar.gz)")
+	yesFlag := fs.Bool("y", false, "Write the bundle without asking for confirmation")
+	commandFlag := fs.String("cmd", "uname -a", "Command to run as the test session")
+	_ = fs.Parse(args)
+
+	config, err := opts.load()
+	if err != nil {
+		exitConfigError(err)
+	}
+	server := selectServer(config, opts.alias, opts.ip, opts.tag)
+
+	files := []reportFile{
+		{"system.txt", systemInfo()},
+		{"config.json", redactedConfig(server)},
+	}
+	files = append(files, diagnose(server, *commandFlag)...)
+	for i := range files {
+		files[i].data = []byte(server.RedactText(string(files[i].data)))
+	}
+
+	for _, f := range files {
+		fmt.Printf("==> %s <==\n%s\n", f.name, strings.TrimRight(string(f.data), "\n"))
+	}
+	out := *outFlag
+	if out == "" {
+		out = fmt.Sprintf("sshtools-report-%s-%s.tar.gz", server.Alias, time.Now().Format("20060102-150405"))
+	}
+	if !*yesFlag && !confirm(fmt.Sprintf("Write the above to %s? [Y/n] ", out), true) {
+		fmt.Println("Nothing written.")
+		return
+	}
+	if err = writeBundle(out, files); err != nil {
+		fmt.Fprintln(os.Stderr, "Error:", err)
+		os.Exit(exitCode(err, 1))
+	}
+	fmt.Printf("Wrote %s\n", out)
+}
+
+func systemInfo() []byte {
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "sshtools version: %s\n", version)
+	if info, ok := debug.ReadBuildInfo(); ok {
+		fmt.Fprintf(&b, "module: %s %s\n", info.Main.Path, info.Main.Version)
+	}
+	fmt.Fprintf(&b, "go: %s %s/%s\n", runtime.Version(), runtime.GOOS, runtime.GOARCH)
+	for _, name := range []string{"TERM", "COLORTERM", "TERM_PROGRAM", "LANG", "LC_ALL", "TMUX", "SSH_AUTH_SOCK"} {
+		value, ok := os.LookupEnv(name)
+		if name == "SSH_AUTH_SOCK" && ok {
+			value = "(set)"
+		}
+		if ok {
+			fmt.Fprintf(&b, "%s=%s\n", name, value)
+		}
+	}
+	fd := int(os.Stdin.Fd())
+	fmt.Fprintf(&b, "stdin is a terminal: %v\n", term.IsTerminal(fd))
+	if width, height, err := term.GetSize(fd); err == nil {
+		fmt.Fprintf(&b, "terminal size: %dx%d\n", width, height)
+	}
+	return b.Bytes()
+}
+
+func redactedConfig(server *sshtools.Server) []byte {
+	data, err := json.MarshalIndent(server.Redacted(), "", "  ")
+	if err != nil {
+		return []byte(err.Error())
+	}
+	return data
+}
+
+// diagnose probes, connects verbosely and runs command, returning the
+// connection log and session output.
+func diagnose(server *sshtools.Server, command string) []reportFile {
+	var log bytes.Buffer
+	start := time.Now()
+	d := &sshtools.Dialer{Verbose: 1, Log: &stampedWriter{w: &log, start: start}, Timeout: dialer.Timeout}
+	if res, err := d.Probe(server); err != nil {
+		d.Logf(1, "probe failed: %v", err)
+	} else {
+		d.Logf(1, "probe: connect %s, banner %s, %s", res.Connect, res.Banner, res.Version)
+	}
+
+	client, err := d.Dial(server)
+	if err != nil {
+		d.Logf(1, "connect failed: %v", err)
+		return []reportFile{{"connection.log", log.Bytes()}}
+	}
+	defer func() { _ = client.Close() }()
+	d.Logf(1, "connected")
+
+	res := client.Capture(command, reportSessionOutput, false)
+	d.Logf(1, "session %q finished: exit code %d in %dms", command, res.ExitCode, res.DurationMs)
+	var session bytes.Buffer
+	fmt.Fprintf(&session, "$ %s\n%s%s", command, res.Stdout, res.Stderr)
+	if res.Truncated {
+		fmt.Fprintf(&session, "\n[output truncated at %d bytes of %d]\n", reportSessionOutput, res.OriginalSize)
+	}
+	if res.Error != "" {
+		fmt.Fprintf(&session, "\nerror: %s\n", res.Error)
+	}
+	return []reportFile{{"connection.log", log.Bytes()}, {"session.txt", session.Bytes()}}
+}
+
+func writeBundle(path string, files []reportFile) (err error) {
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o600)
+	if err != nil {
+		return
+	}
+	defer func(file *os.File) {
+		if errs := file.Close(); errs != nil && err == nil {
+			err = errs
+		}
+	}(file)
+
+	gz := gzip.NewWriter(file)
+	tw := tar.NewWriter(gz)
+	now := time.Now()
+	for _, f := range files {
+		hdr := &tar.Header{Name: "sshtools-report/" + f.name, Mode: 0o600, Size: int64(len(f.data)), ModTime: now}
+		if err = tw.WriteHeader(hdr); err != nil {
+			return
+		}
+		if _, err = tw.Write(f.data); err != nil {
+			return
+		}
+	}
+	if err = tw.Close(); err != nil {
+		return
+	}
+	return gz.Close()
+}
@@ -0,0 +1,183 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/aoaeoe/sshTools/pkg/sshtools"
+	"golang.org/x/crypto/ssh"
+)
+
+// rotateResult is the outcome of a key rotation on one server.
+type rotateResult struct {
+	server *sshtools.Server
+	// oldKey is the key the server logged in with, nil when it used no
+	// key file of its own.
+	oldKey   *sshtools.PublicKey
+	verified bool
+	removed  bool
+	err      error
+}
+
+// rotateKeyCommand replaces the key the selected servers log in with by a
+// new one, across the fleet:
+// sshtools rotate-key -tag all
+// sshtools rotate-key -hosts web1,web2 -new-key ~/.ssh/id_ed25519_2025 -keep-old
+func rotateKeyCommand(args []string) {
+	fs := flag.NewFlagSet("rotate-key", flag.ExitOnError)
+	var opts commonFlags
+	var fleet fleetFlags
+	opts.register(fs, "rotate the key of")
+	fleet.register(fs)
+	newKeyFlag := fs.String("new-key", "", "Path of the new private key (default: ~/.ssh/id_ed25519_<date>)")
+	keepOldFlag := fs.Bool("keep-old", false, "Leave the old key in authorized_keys")
+	yesFlag := fs.Bool("y", false, "Do not ask for confirmation")
+	_ = fs.Parse(args)
+	if fs.NArg() > 0 {
+		fmt.Fprintln(os.Stderr, "usage: sshtools rotate-key (-tag <tag> | -hosts <a,b,...> | -alias <pattern>) [-new-key <path>] [-keep-old]")
+		os.Exit(2)
+	}
+
+	config, err := opts.load()
+	if err != nil {
+		exitConfigError(err)
+	}
+	servers, err := fleet.servers(config, &opts)
+	if err != nil || len(servers) == 0 {
+		if err == nil {
+			err = fmt.Errorf("no server matched")
+		}
+		fmt.Fprintln(os.Stderr, "Error:", err)
+		os.Exit(exitCode(err, 1))
+	}
+	newPath := *newKeyFlag
+	if newPath == "" {
+		newPath = filepath.Join("~", ".ssh", "id_ed25519_"+time.Now().Format("20060102"))
+	}
+	if !*yesFlag && !confirm(fmt.Sprintf("Rotate the key of %d servers to a new key %s? [y/N] ", len(servers), newPath), false) {
+		return
+	}
+
+	newKey, err := sshtools.GenerateKey(newPath, sshtools.KeyOptions{Comment: sshtools.DefaultKeyComment()})
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Error:", err)
+		os.Exit(1)
+	}
+	fmt.Printf("Generated %s %s (%s).\n", newKey.Key.Type(), ssh.FingerprintSHA256(newKey.Key), newKey.PrivateKey)
+
+	inhibitor := opts.preventSleep("sshtools rotate-key")
+	defer inhibitor.Release()
+
+	// 第一步：用旧凭据登录，安装新公钥并确认能用它登录。指向同一账户的
+	// 别名依次安装，否则 authorized_keys 里会出现重复的行
+	accounts := map[string]*sync.Mutex{}
+	for _, server := range servers {
+		accounts[server.User+"@"+server.Addr()] = &sync.Mutex{}
+	}
+	results := make([]rotateResult, len(servers))
+	forEachServer(servers, fleetParallel, func(i int, server *sshtools.Server) {
+		account := accounts[server.User+"@"+server.Addr()]
+		account.Lock()
+		defer account.Unlock()
+		results[i] = installNewKey(&opts, config, server, newKey)
+	})
+	// 第二步：所有服务器都装好新公钥后，再用新密钥登录删除旧公钥，
+	// 避免共用同一账户的别名在第一步中途失去旧密钥
+	if !*keepOldFlag {
+		forEachServer(servers, fleetParallel, func(i int, server *sshtools.Server) {
+			res := &results[i]
+			if res.verified && res.oldKey != nil && !bytes.Equal(res.oldKey.Key.Marshal(), newKey.Key.Marshal()) {
+				res.removed, res.err = removeOldKey(server, newKey, res.oldKey)
+			}
+		})
+	}
+
+	// 第三步：一次写入配置，只切换新密钥已验证可用的服务器
+	var switched []string
+	failures := 0
+	for _, res := range results {
+		alias := res.server.Alias
+		switch {
+		case !res.verified:
+			failures++
+			fmt.Printf("  %-20s FAILED  %v\n", alias, res.err)
+			continue
+		case res.err != nil:
+			failures++
+			fmt.Printf("  %-20s new key works, old key not removed: %v\n", alias, res.err)
+		case res.removed:
+			fmt.Printf("  %-20s rotated, old key removed\n", alias)
+		default:
+			fmt.Printf("  %-20s rotated\n", alias)
+		}
+		if config.ServerByAlias(alias) != res.server {
+			continue
+		}
+		if _, errs := sshtools.ServerEntry(opts.configFile, alias); errs != nil {
+			fmt.Printf("  %-20s not in %s, set its private_key by hand\n", alias, opts.configFile)
+			continue
+		}
+		switched = append(switched, alias)
+	}
+	if len(switched) > 0 {
+		fields := map[string]any{"private_key": newKey.PrivateKey, "use_key": true}
+		if err = sshtools.SetServersFields(opts.configFile, switched, fields); err != nil {
+			fmt.Fprintln(os.Stderr, "Error:", err)
+			os.Exit(1)
+		}
+		fmt.Printf("%s now logs in to %d servers with %s.\n", opts.configFile, len(switched), newKey.PrivateKey)
+	}
+	fmt.Printf("%d rotated, %d failed.\n", len(servers)-failures, failures)
+	if failures > 0 {
+		os.Exit(1)
+	}
+}
+
+// installNewKey logs in to server as configured, adds newKey to its
+// authorized_keys and checks that newKey alone logs in.
+func installNewKey(opts *commonFlags, config *sshtools.Config, server *sshtools.Server, newKey *sshtools.PublicKey) (res rotateResult) {
+	res.server = server
+	if server.UseKey && server.PrivateKey != "" {
+		if key, err := sshtools.ReadPublicKey(server, ""); err == nil {
+			res.oldKey = key
+		}
+	}
+	client, err := dialServer(opts, config, server)
+	if err != nil {
+		res.err = fmt.Errorf("login with the old credentials failed: %v", err)
+		return
+	}
+	_, err = client.InstallPublicKey(newKey)
+	_ = client.Close()
+	if err != nil {
+		res.err = err
+		return
+	}
+	if err = dialer.VerifyPublicKey(server, newKey); err != nil {
+		res.err = fmt.Errorf("login with the new key failed: %v", err)
+		return
+	}
+	res.verified = true
+	return
+}
+
+// removeOldKey logs in to server with newKey and removes oldKey from its
+// authorized_keys.
+func removeOldKey(server *sshtools.Server, newKey, oldKey *sshtools.PublicKey) (removed bool, err error) {
+	login := *server
+	login.Password, login.UseAgent, login.Certificate = "", false, ""
+	login.UseKey, login.PrivateKey = true, newKey.PrivateKey
+	client, err := dialer.Dial(&login)
+	if err != nil {
+		return
+	}
+	defer func() {
+		_ = client.Close()
+	}()
+	return client.RemovePublicKey(oldKey)
+}
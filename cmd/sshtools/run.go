@@ -0,0 +1,144 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/aoaeoe/sshTools/pkg/sshtools"
+	"golang.org/x/term"
+)
+
+// runCommand runs a command template from the config's commands on one
+// server or several:
+// sshtools run web1 restart-svc service=nginx
+// sshtools run -tag web restart-svc
+// sshtools run -l
+func runCommand(args []string) {
+	fs := flag.NewFlagSet("run", flag.ExitOnError)
+	var opts commonFlags
+	var fleet fleetFlags
+	opts.register(fs, "run the command on")
+	opts.registerEnv(fs)
+	opts.registerBecome(fs)
+	fleet.register(fs)
+	listFlag := fs.Bool("l", false, "List the command templates and exit")
+	dryRunFlag := fs.Bool("n", false, "Print the command for each server instead of running it")
+	concurrencyFlag := fs.Int("concurrency", fleetParallel, "With -hosts or -tag, run on at most this many servers at once")
+	outputFlag := fs.String("o", "text", "Output format: text, json or jsonl (one compact object per server and line)")
+	fs.StringVar(outputFlag, "output", "text", "Same as -o")
+	positional := parseArgs(fs, args)
+	usage := "usage: sshtools run [-n] [-b] (<alias> | <pattern> | -hosts <a,b,...> | -tag <tag> | -match <regexp>) <command> [name=value ...]"
+
+	config, err := opts.load()
+	if err != nil {
+		exitConfigError(err)
+	}
+	if *listFlag {
+		for _, name := range config.CommandNames() {
+			fmt.Printf("%s\t%s\n", name, config.Commands[name])
+		}
+		return
+	}
+
+	if len(positional) >= 2 && opts.alias == "" && opts.ip == "" && !fleet.selected(&opts) {
+		fleet.target(&opts, positional[0])
+		positional = positional[1:]
+	}
+	if len(positional) == 0 || opts.alias == "" && opts.ip == "" && !fleet.selected(&opts) {
+		fmt.Fprintln(os.Stderr, usage)
+		os.Exit(2)
+	}
+	if *outputFlag != "text" && *outputFlag != "json" && *outputFlag != "jsonl" {
+		fmt.Fprintf(os.Stderr, "unknown output format %q\n", *outputFlag)
+		os.Exit(2)
+	}
+	name := positional[0]
+	if _, ok := config.Commands[name]; !ok {
+		fmt.Fprintf(os.Stderr, "Error: no command %q in %s, see sshtools run -l\n", name, opts.configFile)
+		os.Exit(2)
+	}
+	vars := make(map[string]string)
+	for _, arg := range positional[1:] {
+		key, value, ok := strings.Cut(arg, "=")
+		if !ok || key == "" {
+			fmt.Fprintf(os.Stderr, "Error: %q is not name=value\n", arg)
+			os.Exit(2)
+		}
+		vars[key] = value
+	}
+	render := func(server *sshtools.Server) (string, error) {
+		return config.RenderCommand(name, server, vars)
+	}
+
+	// -n 只展开模板，不连接
+	if *dryRunFlag {
+		servers := []*sshtools.Server{}
+		if fleet.selected(&opts) {
+			if servers, err = fleet.servers(config, &opts); err != nil {
+				fmt.Fprintln(os.Stderr, "Error:", err)
+				os.Exit(1)
+			}
+		} else {
+			servers = append(servers, selectServer(config, opts.alias, opts.ip, opts.tag))
+		}
+		for _, server := range servers {
+			command, errs := render(server)
+			if errs != nil {
+				fmt.Fprintln(os.Stderr, "Error:", errs)
+				os.Exit(2)
+			}
+			fmt.Printf("%s: %s\n", server.Alias, command)
+		}
+		return
+	}
+
+	o := fleetExecOptions{parallel: *concurrencyFlag, json: *outputFlag != "text", lines: *outputFlag == "jsonl", render: render}
+	if o.json {
+		o.max = sshtools.DefaultMaxCapture
+	}
+	if fleet.selected(&opts) || fleet.dryRun {
+		execFleet(&opts, &fleet, config, name, o)
+		return
+	}
+	server := selectServer(config, opts.alias, opts.ip, opts.tag)
+	command, err := render(server)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Error:", err)
+		os.Exit(2)
+	}
+
+	client, err := dialServer(&opts, config, server)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Error:", err)
+		os.Exit(exitCode(err, exitConnect))
+	}
+	defer func(client *sshtools.Client) {
+		_ = client.Close()
+	}(client)
+	if opts.become {
+		client.Become = true
+		if client.BecomePassword, err = becomePassword(server); err != nil {
+			fmt.Fprintln(os.Stderr, "Error:", err)
+			os.Exit(exitCode(err, exitConnect))
+		}
+	}
+	if !term.IsTerminal(int(os.Stdin.Fd())) && !opts.become {
+		client.Stdin = os.Stdin
+	}
+
+	var res *sshtools.ExecResult
+	if o.json {
+		res = client.Capture(command, o.max, false)
+		printResult(res, o.lines)
+	} else {
+		res = client.Exec(command, os.Stdout, os.Stderr, 0, false)
+		if res.Error != "" {
+			fmt.Fprintln(os.Stderr, "Error:", res.Error)
+		}
+	}
+
+	_ = client.Close()
+	os.Exit(resultStatus(res))
+}
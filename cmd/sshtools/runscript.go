@@ -0,0 +1,102 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/aoaeoe/sshTools/pkg/sshtools"
+	"golang.org/x/term"
+)
+
+// runScriptCommand runs a local script on one server or several, without
+// copying it there first:
+// sshtools run-script web1 ./deploy.sh -args v1.2
+// sshtools run-script -tag web -become ./deploy.sh -args "v1.2 --force"
+func runScriptCommand(args []string) {
+	fs := flag.NewFlagSet("run-script", flag.ExitOnError)
+	var opts commonFlags
+	var fleet fleetFlags
+	opts.register(fs, "run the script on")
+	opts.registerEnv(fs)
+	opts.registerBecome(fs)
+	fleet.register(fs)
+	argsFlag := fs.String("args", "", "Arguments for the script, as shell words")
+	interpreterFlag := fs.String("interpreter", "", "Run the script with this interpreter (default: its #! line, else sh)")
+	pipeFlag := fs.Bool("pipe", false, "Feed the script to the interpreter's stdin instead of uploading it to a temporary file")
+	concurrencyFlag := fs.Int("concurrency", fleetParallel, "With -hosts or -tag, run on at most this many servers at once")
+	outputFlag := fs.String("o", "text", "Output format: text, json or jsonl (one compact object per server and line)")
+	fs.StringVar(outputFlag, "output", "text", "Same as -o")
+	positional := parseArgs(fs, args)
+	if len(positional) == 2 && opts.alias == "" && opts.ip == "" && !fleet.selected(&opts) {
+		fleet.target(&opts, positional[0])
+		positional = positional[1:]
+	}
+	if len(positional) != 1 || opts.alias == "" && opts.ip == "" && !fleet.selected(&opts) {
+		fmt.Fprintln(os.Stderr, "usage: sshtools run-script [-args <args>] [-become] [-pipe] (<alias> | <pattern> | -hosts <a,b,...> | -tag <tag> | -match <regexp>) <script>")
+		os.Exit(2)
+	}
+	if *outputFlag != "text" && *outputFlag != "json" && *outputFlag != "jsonl" {
+		fmt.Fprintf(os.Stderr, "unknown output format %q\n", *outputFlag)
+		os.Exit(2)
+	}
+	if *pipeFlag && opts.become {
+		fmt.Fprintln(os.Stderr, "Error: -pipe cannot be combined with -become, sudo reads its password from stdin")
+		os.Exit(2)
+	}
+
+	script, err := sshtools.LoadScript(positional[0])
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Error:", err)
+		os.Exit(exitCode(err, 1))
+	}
+	script.Args, script.Interpreter, script.Pipe = *argsFlag, *interpreterFlag, *pipeFlag
+
+	config, err := opts.load()
+	if err != nil {
+		exitConfigError(err)
+	}
+	o := fleetExecOptions{parallel: *concurrencyFlag, json: *outputFlag != "text", lines: *outputFlag == "jsonl", script: script}
+	if o.json {
+		o.max = sshtools.DefaultMaxCapture
+	}
+	if fleet.selected(&opts) || fleet.dryRun {
+		execFleet(&opts, &fleet, config, script.String(), o)
+		return
+	}
+	server := selectServer(config, opts.alias, opts.ip, opts.tag)
+
+	client, err := dialServer(&opts, config, server)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Error:", err)
+		os.Exit(exitCode(err, exitConnect))
+	}
+	defer func(client *sshtools.Client) {
+		_ = client.Close()
+	}(client)
+	if opts.become {
+		client.Become = true
+		if client.BecomePassword, err = becomePassword(server); err != nil {
+			fmt.Fprintln(os.Stderr, "Error:", err)
+			os.Exit(exitCode(err, exitConnect))
+		}
+	}
+	// 上传的脚本可以读取本地的管道输入
+	if !term.IsTerminal(int(os.Stdin.Fd())) && !opts.become && !script.Pipe {
+		client.Stdin = os.Stdin
+	}
+
+	var res *sshtools.ExecResult
+	if o.json {
+		res = client.CaptureScript(script, o.max, false)
+		printResult(res, o.lines)
+	} else {
+		res = client.RunScript(script, os.Stdout, os.Stderr, 0, false)
+		if res.Error != "" {
+			fmt.Fprintln(os.Stderr, "Error:", res.Error)
+		}
+	}
+
+	_ = client.Close()
+	os.Exit(resultStatus(res))
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/aoaeoe/sshTools/pkg/sshtools"
+	"golang.org/x/term"
+)
+
+// secretCommand manages the passwords, key passphrases and sudo passwords
+// kept in the OS keychain for servers with "password_source": "keychain":
+// sshtools secret set web1
+// sshtools secret set -passphrase web1
+// sshtools secret set -sudo web1
+// sshtools secret delete web1
+func secretCommand(args []string) {
+	usage := "usage: sshtools secret set|delete [-passphrase | -sudo] [-config config.json] <alias>"
+	if len(args) == 0 || args[0] != "set" && args[0] != "delete" {
+		fmt.Fprintln(os.Stderr, usage)
+		os.Exit(2)
+	}
+	fs := flag.NewFlagSet("secret "+args[0], flag.ExitOnError)
+	configFlag := fs.String("config", sshtools.DefaultConfigFile(), "Path to the configuration file")
+	passphraseFlag := fs.Bool("passphrase", false, "The passphrase of the server's private key instead of its password")
+	sudoFlag := fs.Bool("sudo", false, "The server's sudo password, used by -b, instead of its password")
+	positional := parseArgs(fs, args[1:])
+	if len(positional) != 1 || *passphraseFlag && *sudoFlag {
+		fmt.Fprintln(os.Stderr, usage)
+		os.Exit(2)
+	}
+
+	opts := commonFlags{configFile: *configFlag, noSecrets: true}
+	config, err := opts.load()
+	if err != nil {
+		exitConfigError(err)
+	}
+	server := config.ServerByAlias(positional[0])
+	if server == nil {
+		fmt.Fprintf(os.Stderr, "Error: alias %q not found in %s\n", positional[0], *configFlag)
+		os.Exit(1)
+	}
+	entry := sshtools.KeychainPassword
+	if *passphraseFlag {
+		entry = sshtools.KeychainPassphrase
+	} else if *sudoFlag {
+		entry = sshtools.KeychainSudo
+	}
+	what := entry.String()
+
+	if args[0] == "delete" {
+		err = sshtools.DeleteKeychainSecret(server.Alias, entry)
+		if errors.Is(err, sshtools.ErrNoKeychainSecret) {
+			fmt.Printf("No %s for %s in the keychain.\n", what, server.Alias)
+			return
+		}
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "Error:", err)
+			os.Exit(exitCode(err, 1))
+		}
+		fmt.Printf("Removed the %s for %s from the keychain.\n", what, server.Alias)
+		return
+	}
+
+	secret, err := readSecret(what, server.Alias)
+	if err == nil {
+		err = sshtools.SetKeychainSecret(server.Alias, entry, secret)
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Error:", err)
+		os.Exit(exitCode(err, 1))
+	}
+	fmt.Printf("Stored the %s for %s in the keychain.\n", what, server.Alias)
+	switch {
+	case entry == sshtools.KeychainSudo:
+		if server.Become == nil || server.Become.PasswordSource != sshtools.PasswordSourceKeychain {
+			fmt.Printf("Set \"become\": {\"password_source\": \"keychain\"} for %s in %s to use it.\n", server.Alias, *configFlag)
+		}
+	case server.PasswordSource != sshtools.PasswordSourceKeychain:
+		fmt.Printf("Set \"password_source\": \"keychain\" for %s in %s to use it.\n", server.Alias, *configFlag)
+	}
+}
+
+// readSecret asks twice for a secret on a terminal, or else reads the first
+// line of stdin, e.g. piped from a password manager.
+func readSecret(what, alias string) (secret string, err error) {
+	if !term.IsTerminal(int(os.Stdin.Fd())) {
+		secret, err = bufio.NewReader(os.Stdin).ReadString('\n')
+		if errors.Is(err, io.EOF) {
+			err = nil
+		}
+		secret = strings.TrimRight(secret, "\r\n")
+	} else if secret, err = promptPassword(fmt.Sprintf("%s for %s: ", strings.ToUpper(what[:1])+what[1:], alias)); err == nil && secret != "" {
+		again, errs := promptPassword(fmt.Sprintf("Repeat the %s: ", what))
+		if errs == nil && again != secret {
+			errs = fmt.Errorf("the %ss do not match", what)
+		}
+		err = errs
+	}
+	if err == nil && secret == "" {
+		err = fmt.Errorf("the %s cannot be empty", what)
+	}
+	return
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/aoaeoe/sshTools/pkg/sshtools"
+	"golang.org/x/term"
+)
+
+// masterPasswordEnv supplies the master password of an encrypted config
+// without a prompt; background processes we start get it this way.
+const masterPasswordEnv = "SSHTOOLS_MASTER_PASSWORD"
+
+// masterPassword is the one that unlocked the config, for passing on to
+// background processes.
+var masterPassword string
+
+// configCommand migrates the secrets or the format of the config file:
+// sshtools config encrypt
+// sshtools config decrypt
+// sshtools config convert config.json config.yaml
+func configCommand(args []string) {
+	usage := "usage: sshtools config encrypt|decrypt [-config config.json]\n       sshtools config convert [-force] <from> <to>"
+	if len(args) > 0 && args[0] == "convert" {
+		convertConfig(args[1:], usage)
+		return
+	}
+	if len(args) == 0 || args[0] != "encrypt" && args[0] != "decrypt" {
+		fmt.Fprintln(os.Stderr, usage)
+		os.Exit(2)
+	}
+	fs := flag.NewFlagSet("config "+args[0], flag.ExitOnError)
+	configFlag := fs.String("config", sshtools.DefaultConfigFile(), "Path to the configuration file")
+	_ = fs.Parse(args[1:])
+	if fs.NArg() > 0 {
+		fmt.Fprintln(os.Stderr, usage)
+		os.Exit(2)
+	}
+
+	var password, done string
+	var count int
+	var err error
+	if args[0] == "encrypt" {
+		if password, err = newMasterPassword(); err == nil {
+			count, err = sshtools.EncryptConfigFile(*configFlag, password)
+		}
+		done = "Encrypted"
+	} else {
+		if password, err = askMasterPassword(*configFlag); err == nil {
+			count, err = sshtools.DecryptConfigFile(*configFlag, password)
+		}
+		done = "Decrypted"
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Error:", err)
+		os.Exit(1)
+	}
+	fmt.Printf("%s %d value(s) in %s.\n", done, count, *configFlag)
+}
+
+// newMasterPassword asks for a new master password twice.
+func newMasterPassword() (password string, err error) {
+	if password = os.Getenv(masterPasswordEnv); password != "" {
+		return
+	}
+	if !term.IsTerminal(int(os.Stdin.Fd())) {
+		return "", fmt.Errorf("set %s or run on a terminal to choose a master password", masterPasswordEnv)
+	}
+	if password, err = promptPassword("New master password: "); err != nil {
+		return
+	}
+	if password == "" {
+		return "", errors.New("the master password cannot be empty")
+	}
+	again, err := promptPassword("Repeat the master password: ")
+	if err == nil && again != password {
+		err = errors.New("the passwords do not match")
+	}
+	return
+}
+
+// askMasterPassword returns the master password for configFile from the
+// environment, or else prompts for it.
+func askMasterPassword(configFile string) (string, error) {
+	if password, ok := os.LookupEnv(masterPasswordEnv); ok {
+		return password, nil
+	}
+	if !term.IsTerminal(int(os.Stdin.Fd())) {
+		return "", fmt.Errorf("%s is encrypted: set %s or run on a terminal", configFile, masterPasswordEnv)
+	}
+	return promptPassword(fmt.Sprintf("Master password for %s: ", configFile))
+}
+
+// unlockConfig decrypts the secrets of config, asking again when the
+// master password is wrong.
+func unlockConfig(configFile string, config *sshtools.Config) (err error) {
+	for range 3 {
+		password, errs := askMasterPassword(configFile)
+		if errs != nil {
+			return errs
+		}
+		if err = config.Unlock(password); err == nil {
+			masterPassword = password
+			return
+		}
+		if _, ok := os.LookupEnv(masterPasswordEnv); ok || !errors.Is(err, sshtools.ErrWrongMasterPassword) {
+			return
+		}
+		fmt.Fprintln(os.Stderr, "Wrong master password, try again.")
+	}
+	return
+}
+
+// childEnv is the environment of background processes we start, with the
+// master password so they do not ask for it again.
+func childEnv() []string {
+	env := os.Environ()
+	if masterPassword != "" {
+		env = append(env, masterPasswordEnv+"="+masterPassword)
+	}
+	return env
+}
@@ -0,0 +1,272 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"os/user"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/aoaeoe/sshTools/pkg/sshtools"
+	"golang.org/x/term"
+)
+
+// Authentication choices offered by add.
+const (
+	addAuthKey      = "key"
+	addAuthAgent    = "agent"
+	addAuthPassword = "password"
+)
+
+// wizard asks on the terminal for the values flags did not give.
+type wizard struct {
+	reader      *bufio.Reader
+	interactive bool
+}
+
+// ask returns value when it is set, or else prompts until check accepts an
+// answer; an empty answer means def. Without a terminal, def is taken as
+// is and a bad value ends the program.
+func (w *wizard) ask(label, value, def string, check func(string) error) string {
+	for {
+		if value == "" && w.interactive {
+			if def != "" {
+				fmt.Printf("%s [%s]: ", label, def)
+			} else {
+				fmt.Printf("%s: ", label)
+			}
+			line, _ := w.reader.ReadString('\n')
+			value = strings.TrimSpace(line)
+		}
+		if value == "" {
+			value = def
+		}
+		err := check(value)
+		if err == nil {
+			return value
+		}
+		if !w.interactive {
+			fmt.Fprintf(os.Stderr, "Error: %s: %v\n", strings.ToLower(label), err)
+			os.Exit(2)
+		}
+		fmt.Printf("  %v\n", err)
+		value = ""
+	}
+}
+
+// addCommand adds a server to the config file, asking for whatever the
+// flags leave out:
+// sshtools add
+// sshtools add -alias web1 -address 10.0.0.5 -user deploy -auth key
+func addCommand(args []string) {
+	fs := flag.NewFlagSet("add", flag.ExitOnError)
+	var server sshtools.Server
+	configFlag := fs.String("config", sshtools.DefaultConfigFile(), "Path to the configuration file")
+	fs.StringVar(&server.Alias, "alias", "", "Alias of the new server")
+	fs.StringVar(&server.Address, "address", "", "Host name or IP address")
+	portFlag := fs.String("port", "", "SSH port (default 22)")
+	fs.StringVar(&server.User, "user", "", "User to log in as (default: the local user)")
+	authFlag := fs.String("auth", "", "Authentication: key, agent or password (prompted for when connecting)")
+	fs.StringVar(&server.PrivateKey, "key", "", "Private key file, for -auth key")
+	tagsFlag := fs.String("tags", "", "Comma-separated tags")
+	yesFlag := fs.Bool("y", false, "Add without asking for confirmation")
+	_ = fs.Parse(args)
+	if fs.NArg() > 0 {
+		fmt.Fprintln(os.Stderr, "usage: sshtools add [-alias <alias>] [-address <host>] [-port <port>] [-user <user>] [-auth key|agent|password] [-key <file>] [-tags a,b]")
+		os.Exit(2)
+	}
+
+	opts := commonFlags{configFile: *configFlag}
+	config, err := opts.load()
+	if err != nil {
+		exitConfigError(err)
+	}
+	w := &wizard{reader: bufio.NewReader(os.Stdin), interactive: term.IsTerminal(int(os.Stdin.Fd()))}
+
+	server.Alias = w.ask("Alias", server.Alias, "", func(alias string) error {
+		switch {
+		case alias == "":
+			return fmt.Errorf("an alias is required")
+		case strings.ContainsAny(alias, " \t:@"):
+			return fmt.Errorf("an alias cannot contain spaces, ':' or '@'")
+		case config.ServerByAlias(alias) != nil:
+			return fmt.Errorf("%s is already configured", alias)
+		}
+		return nil
+	})
+	server.Address = w.ask("Address", server.Address, "", func(address string) error {
+		if address == "" || strings.ContainsAny(address, " /@") {
+			return fmt.Errorf("a host name or IP address is required")
+		}
+		return nil
+	})
+	port := w.ask("Port", *portFlag, "22", func(port string) error {
+		if n, errs := strconv.Atoi(port); errs != nil || n < 1 || n > 65535 {
+			return fmt.Errorf("%q is not a port number", port)
+		}
+		return nil
+	})
+	server.Port, _ = strconv.Atoi(port)
+	localUser := ""
+	if usr, errs := user.Current(); errs == nil {
+		localUser = usr.Username
+	}
+	server.User = w.ask("User", server.User, localUser, func(name string) error {
+		if name == "" {
+			return fmt.Errorf("a user is required")
+		}
+		return nil
+	})
+
+	defaultAuth := addAuthPassword
+	if server.PrivateKey != "" {
+		defaultAuth = addAuthKey
+	}
+	auth := w.ask("Authentication (key, agent or password)", *authFlag, defaultAuth, func(auth string) error {
+		switch auth {
+		case addAuthKey, addAuthAgent, addAuthPassword:
+			return nil
+		}
+		return fmt.Errorf("choose key, agent or password")
+	})
+	switch auth {
+	case addAuthKey:
+		server.UseKey = true
+		server.PrivateKey = w.ask("Private key", server.PrivateKey, filepath.Join("~", ".ssh", "id_ed25519"), func(keyPath string) error {
+			expanded, errs := sshtools.ExpandPath(keyPath)
+			if errs == nil {
+				_, errs = os.Stat(expanded)
+			}
+			return errs
+		})
+	case addAuthAgent:
+		server.UseAgent = true
+	case addAuthPassword:
+		// 默认不保存密码，连接时再输入
+		if w.interactive && confirm("Store the password in the config file (it is asked for when connecting otherwise)? [y/N] ", false) {
+			if server.Password, err = promptPassword("Password: "); err != nil {
+				fmt.Fprintln(os.Stderr, "Error:", err)
+				os.Exit(exitCode(err, 1))
+			}
+		}
+	}
+	for _, tag := range strings.Split(w.ask("Tags (comma-separated)", *tagsFlag, "", func(string) error { return nil }), ",") {
+		if tag = strings.TrimSpace(tag); tag != "" {
+			server.Tags = append(server.Tags, tag)
+		}
+	}
+
+	shown := server
+	if shown.Password != "" {
+		shown.Password = "********"
+	}
+	entry, _ := json.MarshalIndent(shown, "", "  ")
+	fmt.Println(string(entry))
+	if w.interactive && !*yesFlag && !confirm(fmt.Sprintf("Add %s to %s? [Y/n] ", server.Alias, *configFlag), true) {
+		fmt.Println("Nothing added.")
+		return
+	}
+	// 配置已加密时，新密码和私钥路径同样加密保存
+	if server.Password, err = config.EncryptValue(server.Password); err == nil {
+		server.PrivateKey, err = config.EncryptValue(server.PrivateKey)
+	}
+	if err == nil {
+		err = sshtools.AppendServer(*configFlag, &server)
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Error:", err)
+		os.Exit(exitCode(err, 1))
+	}
+	fmt.Printf("Added %s to %s.\n", server.Alias, *configFlag)
+}
+
+// rmCommand removes a server from the config file:
+// sshtools rm web1
+func rmCommand(args []string) {
+	fs := flag.NewFlagSet("rm", flag.ExitOnError)
+	configFlag := fs.String("config", sshtools.DefaultConfigFile(), "Path to the configuration file")
+	yesFlag := fs.Bool("y", false, "Remove without asking for confirmation")
+	positional := parseArgs(fs, args)
+	if len(positional) != 1 {
+		fmt.Fprintln(os.Stderr, "usage: sshtools rm [-y] <alias>")
+		os.Exit(2)
+	}
+	alias := positional[0]
+	entry, err := sshtools.ServerEntry(*configFlag, alias)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Error:", err)
+		os.Exit(exitCode(err, 1))
+	}
+	if !*yesFlag {
+		if !term.IsTerminal(int(os.Stdin.Fd())) {
+			fmt.Fprintln(os.Stderr, "Error: pass -y to remove without a terminal")
+			os.Exit(2)
+		}
+		fmt.Println(string(entry))
+		if !confirm(fmt.Sprintf("Remove %s from %s? [y/N] ", alias, *configFlag), false) {
+			fmt.Println("Nothing removed.")
+			return
+		}
+	}
+	if err = sshtools.RemoveServer(*configFlag, alias); err != nil {
+		fmt.Fprintln(os.Stderr, "Error:", err)
+		os.Exit(exitCode(err, 1))
+	}
+	fmt.Printf("Removed %s from %s (the previous version is in %s.bak).\n", alias, *configFlag, *configFlag)
+}
+
+// editServer opens the config entry of alias in the editor and writes it
+// back once it validates.
+func editServer(configFile, alias string) (err error) {
+	entry, err := sshtools.ServerEntry(configFile, alias)
+	if err != nil {
+		return
+	}
+	local, err := os.CreateTemp("", "sshtools-*-"+alias+".json")
+	if err != nil {
+		return
+	}
+	localPath := local.Name()
+	_, err = local.Write(append(entry, '\n'))
+	if errs := local.Close(); err == nil {
+		err = errs
+	}
+	if err != nil {
+		return
+	}
+	keep := false
+	defer func() {
+		if !keep {
+			_ = os.Remove(localPath)
+		}
+	}()
+
+	for {
+		if err = runEditor(localPath); err != nil {
+			keep = true
+			return fmt.Errorf("editor failed: %v (your copy is at %s)", err, localPath)
+		}
+		edited, errs := os.ReadFile(localPath)
+		if errs != nil {
+			return errs
+		}
+		if bytes.Equal(bytes.TrimSpace(edited), bytes.TrimSpace(entry)) {
+			fmt.Println("No changes.")
+			return
+		}
+		if err = sshtools.ReplaceServer(configFile, alias, edited); err == nil {
+			fmt.Printf("Saved %s in %s (the previous version is in %s.bak).\n", alias, configFile, configFile)
+			return
+		}
+		fmt.Fprintln(os.Stderr, "Error:", err)
+		if !confirm("Edit again? [Y/n] ", true) {
+			keep = true
+			return fmt.Errorf("%s was not changed (your copy is at %s)", configFile, localPath)
+		}
+	}
+}
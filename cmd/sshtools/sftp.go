@@ -0,0 +1,73 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/aoaeoe/sshTools/pkg/sshtools"
+	"golang.org/x/term"
+)
+
+// sftpCommand opens an interactive sftp prompt on a server with its
+// configured auth:
+// sshtools sftp web1
+// sshtools sftp web1 < commands.txt
+func sftpCommand(args []string) {
+	fs := flag.NewFlagSet("sftp", flag.ExitOnError)
+	var opts commonFlags
+	opts.register(fs, "browse")
+	positional := parseArgs(fs, args)
+	if len(positional) == 1 && opts.alias == "" {
+		opts.alias = positional[0]
+	}
+	if len(positional) > 1 || opts.alias == "" && opts.ip == "" {
+		fmt.Fprintln(os.Stderr, "usage: sshtools sftp <alias>")
+		os.Exit(2)
+	}
+
+	config, err := opts.load()
+	if err != nil {
+		exitConfigError(err)
+	}
+	server := selectServer(config, opts.alias, opts.ip, opts.tag)
+	if err = runSFTP(&opts, config, server); err != nil {
+		fmt.Fprintln(os.Stderr, "Error:", err)
+		os.Exit(exitCode(err, 1))
+	}
+}
+
+func runSFTP(opts *commonFlags, config *sshtools.Config, server *sshtools.Server) (err error) {
+	client, err := dialServer(opts, config, server)
+	if err != nil {
+		return
+	}
+	defer func(client *sshtools.Client) {
+		_ = client.Close()
+	}(client)
+	sftpClient, err := client.SFTP()
+	if err != nil {
+		return
+	}
+	defer func() { _ = sftpClient.Close() }()
+
+	shell, err := sshtools.NewSFTPShell(sftpClient, os.Stdin, os.Stdout, os.Stderr)
+	if err != nil {
+		return
+	}
+	shell.Prompt = "sftp " + server.Alias + "> "
+	if term.IsTerminal(int(os.Stderr.Fd())) {
+		shell.Progress = func(p *sshtools.TransferProgress) (stop func()) {
+			done, stopped := make(chan struct{}), make(chan struct{})
+			go func() {
+				defer close(stopped)
+				drawProgress(p, done)
+			}()
+			return func() {
+				close(done)
+				<-stopped
+			}
+		}
+	}
+	return shell.Run()
+}
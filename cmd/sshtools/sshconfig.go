@@ -0,0 +1,57 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/aoaeoe/sshTools/pkg/sshtools"
+)
+
+// importSSHConfigCommand copies the hosts of an OpenSSH client config into
+// the config file, skipping aliases it already has:
+// sshtools import-sshconfig [-file ~/.ssh/config] [-n]
+func importSSHConfigCommand(args []string) {
+	fs := flag.NewFlagSet("import-sshconfig", flag.ExitOnError)
+	configFlag := fs.String("config", sshtools.DefaultConfigFile(), "Path to the configuration file to add the hosts to")
+	fileFlag := fs.String("file", sshtools.DefaultSSHConfig, "OpenSSH client config to import")
+	dryRunFlag := fs.Bool("n", false, "Only show what would be imported")
+	_ = fs.Parse(args)
+	if fs.NArg() > 0 {
+		fmt.Fprintln(os.Stderr, "usage: sshtools import-sshconfig [-file ~/.ssh/config] [-config config.json] [-n]")
+		os.Exit(2)
+	}
+
+	opts := commonFlags{configFile: *configFlag, noSecrets: true}
+	config, err := opts.load()
+	if err != nil {
+		exitConfigError(err)
+	}
+	servers, err := sshtools.ImportSSHConfig(*fileFlag)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Error:", err)
+		os.Exit(1)
+	}
+
+	added := 0
+	for i := range servers {
+		server := &servers[i]
+		if config.ServerByAlias(server.Alias) != nil {
+			fmt.Printf("skipped %s: alias already configured\n", server.Alias)
+			continue
+		}
+		if !*dryRunFlag {
+			if err = sshtools.AppendServer(*configFlag, server); err != nil {
+				fmt.Fprintln(os.Stderr, "Error:", err)
+				os.Exit(1)
+			}
+		}
+		added++
+		fmt.Printf("added %s (%s@%s)\n", server.Alias, server.User, server.Addr())
+	}
+	if *dryRunFlag {
+		fmt.Printf("Would import %d of %d host(s) from %s.\n", added, len(servers), *fileFlag)
+		return
+	}
+	fmt.Printf("Imported %d of %d host(s) from %s into %s.\n", added, len(servers), *fileFlag, *configFlag)
+}
@@ -0,0 +1,157 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aoaeoe/sshTools/pkg/sshtools"
+)
+
+// statusTimeout is how long status waits for each server unless -timeout
+// says otherwise.
+const statusTimeout = 3 * time.Second
+
+// statusCommand checks which servers answer on their SSH port, and with
+// -login whether they let us log in:
+// sshtools status [-tag web] [-diff]
+// sshtools status -login -uptime
+func statusCommand(args []string) {
+	fs := flag.NewFlagSet("status", flag.ExitOnError)
+	var opts commonFlags
+	var fleet fleetFlags
+	loginFlag := fs.Bool("login", false, "Also log in to every server that answers, without prompting")
+	opts.register(fs, "check")
+	fleet.register(fs)
+	diffFlag := fs.Bool("diff", false, "Only print servers whose reachability changed since the last run; exit 1 if any went down")
+	uptimeFlag := fs.Bool("uptime", false, "Also log in and show the output of uptime (implies -login)")
+	outputFlag := fs.String("o", "text", "Output format: text or json")
+	_ = fs.Parse(args)
+	if *outputFlag != "text" && *outputFlag != "json" {
+		fmt.Fprintf(os.Stderr, "unknown output format %q\n", *outputFlag)
+		os.Exit(2)
+	}
+	login := *loginFlag || *uptimeFlag
+	command := ""
+	if *uptimeFlag {
+		command = "uptime"
+	}
+
+	config, err := opts.load()
+	if err != nil {
+		exitConfigError(err)
+	}
+	// 未指定时检查所有服务器
+	var servers []*sshtools.Server
+	if !fleet.selected(&opts) && opts.alias == "" && opts.ip == "" {
+		servers = config.ActiveServers(fleet.includeDeprecated)
+		fleet.preview(servers)
+	} else if servers, err = fleet.servers(config, &opts); err != nil {
+		fmt.Fprintln(os.Stderr, "Error:", err)
+		os.Exit(2)
+	}
+	// 未指定 -timeout 时快速跳过不可达的服务器
+	if opts.timeout == 0 {
+		dialer.Timeout = statusTimeout
+	}
+	// 并行登录时无法逐台提示，需要输入的服务器记为登录失败
+	dialer.PromptPassword, dialer.Challenge, dialer.ConfirmHostKey = nil, nil, nil
+
+	var mu sync.Mutex
+	checked := make(map[string]sshtools.ServerStatus, len(servers))
+	forEachServer(servers, fleetParallel, func(i int, server *sshtools.Server) {
+		status := dialer.CheckServer(server, login, command)
+		mu.Lock()
+		checked[server.Alias] = status
+		mu.Unlock()
+	})
+
+	snap, err := sshtools.LoadStatus(opts.configFile)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Error:", err)
+		os.Exit(exitCode(err, 1))
+	}
+	changes := snap.Update(config, checked)
+	if errs := snap.Save(); errs != nil {
+		fmt.Fprintln(os.Stderr, "Error:", errs)
+	}
+
+	if *diffFlag {
+		regressed := false
+		for _, c := range changes {
+			printStatusChange(c)
+			regressed = regressed || c.Regressed()
+		}
+		if regressed {
+			os.Exit(1)
+		}
+		return
+	}
+
+	failed := 0
+	var results []statusResult
+	for _, server := range servers {
+		status := checked[server.Alias]
+		if !status.Reachable || status.LoginError != "" {
+			failed++
+		}
+		results = append(results, statusResult{Alias: server.Alias, ServerStatus: status})
+	}
+	if *outputFlag == "json" {
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		_ = encoder.Encode(results)
+	} else {
+		printStatusTable(results)
+	}
+	if failed > 0 {
+		os.Exit(1)
+	}
+}
+
+// statusResult is one server of status -o json.
+type statusResult struct {
+	Alias string `json:"alias"`
+	sshtools.ServerStatus
+}
+
+// printStatusTable prints a line per server with its latency and version,
+// then the error or the output of -uptime.
+func printStatusTable(results []statusResult) {
+	for _, res := range results {
+		state, latency, detail := "up", "-", res.Output
+		switch {
+		case !res.Reachable:
+			state, detail = "DOWN", res.Error
+		case res.LoginError != "":
+			state, detail = "NOAUTH", res.LoginError
+		}
+		if res.Reachable {
+			latency = fmt.Sprintf("%.1fms", res.LatencyMs)
+		}
+		version := strings.TrimPrefix(res.Version, "SSH-2.0-")
+		line := fmt.Sprintf("%-20s %-30s %-6s %9s  %-24s %s", res.Alias, res.Address, state, latency, version, detail)
+		fmt.Println(strings.TrimRight(line, " "))
+	}
+}
+
+func printStatusChange(c sshtools.StatusChange) {
+	switch c.Kind {
+	case sshtools.StatusDown:
+		fmt.Printf("%s: newly unreachable (%s)\n", c.Alias, c.Now.Error)
+	case sshtools.StatusUp:
+		fmt.Printf("%s: reachable again\n", c.Alias)
+	case sshtools.StatusAdded:
+		state := "reachable"
+		if !c.Now.Reachable {
+			state = "unreachable"
+		}
+		fmt.Printf("%s: new server, %s\n", c.Alias, state)
+	case sshtools.StatusRemoved:
+		fmt.Printf("%s: removed from the config\n", c.Alias)
+	}
+}
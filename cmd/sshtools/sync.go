@@ -0,0 +1,163 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"strings"
+	"time"
+
+	"github.com/aoaeoe/sshTools/pkg/sshtools"
+	"github.com/pkg/sftp"
+	"golang.org/x/term"
+)
+
+// excludeFlags collects repeated -exclude patterns.
+type excludeFlags []string
+
+func (f *excludeFlags) String() string {
+	return strings.Join(*f, ",")
+}
+
+func (f *excludeFlags) Set(pattern string) error {
+	*f = append(*f, pattern)
+	return nil
+}
+
+// syncCommand uploads the new and changed files of a local directory:
+// sshtools sync ./site web1:/var/www/site
+// sshtools sync -delete -exclude .git/ -exclude '*.tmp' -watch ./site web1:/var/www/site
+func syncCommand(args []string) {
+	fs := flag.NewFlagSet("sync", flag.ExitOnError)
+	var opts commonFlags
+	opts.register(fs, "sync to")
+	var excludes excludeFlags
+	fs.Var(&excludes, "exclude", "Skip files and directories matching this pattern (repeatable; \"dir/\" only matches directories)")
+	deleteFlag := fs.Bool("delete", false, "Delete remote files that are not in the local directory")
+	checksumFlag := fs.Bool("checksum", false, "Compare the content of files of the same size instead of their modification times")
+	dryRunFlag := fs.Bool("n", false, "Show what would change without changing anything")
+	watchFlag := fs.Bool("watch", false, "Keep running and sync again whenever a local file changes")
+	intervalFlag := fs.Duration("interval", time.Second, "How often -watch looks for local changes")
+	quietFlag := fs.Bool("q", false, "Don't show the progress bar")
+	paths := parseArgs(fs, args)
+
+	usage := "usage: sshtools sync [-delete] [-exclude pattern] [-checksum] [-n] [-watch] <local dir> <alias>:<remote dir>"
+	if len(paths) != 2 {
+		fmt.Fprintln(os.Stderr, usage)
+		os.Exit(2)
+	}
+	alias, remotePath, ok := splitRemote(paths[1])
+	if !ok {
+		fmt.Fprintln(os.Stderr, usage)
+		os.Exit(2)
+	}
+	localPath := paths[0]
+
+	config, err := opts.load()
+	if err != nil {
+		exitConfigError(err)
+	}
+	server := selectServer(config, alias, "", opts.tag)
+	inhibitor := opts.preventSleep("sshtools sync")
+	defer inhibitor.Release()
+
+	showProgress := !*quietFlag && term.IsTerminal(int(os.Stderr.Fd()))
+	syncOpts := sshtools.SyncOptions{
+		Delete:   *deleteFlag,
+		Exclude:  excludes,
+		Checksum: *checksumFlag,
+		DryRun:   *dryRunFlag,
+		Log: func(format string, args ...any) {
+			if showProgress {
+				// Clear the progress bar; it is redrawn below.
+				fmt.Fprint(os.Stderr, "\r\033[K")
+			}
+			fmt.Fprintf(os.Stderr, format+"\n", args...)
+		},
+	}
+	if err = runSync(&opts, config, server, localPath, remotePath, syncOpts, showProgress, *watchFlag, *intervalFlag); err != nil {
+		fmt.Fprintln(os.Stderr, "Error:", err)
+		os.Exit(exitCode(err, 1))
+	}
+}
+
+func runSync(opts *commonFlags, config *sshtools.Config, server *sshtools.Server, localPath, remotePath string,
+	syncOpts sshtools.SyncOptions, showProgress, watch bool, interval time.Duration) (err error) {
+	client, err := dialServer(opts, config, server)
+	if err != nil {
+		return
+	}
+	defer func(client *sshtools.Client) {
+		_ = client.Close()
+	}(client)
+	sftpClient, err := client.SFTP()
+	if err != nil {
+		return
+	}
+	defer func() { _ = sftpClient.Close() }()
+
+	// 监视前先记录本地状态，同步期间的修改会在下一轮发现
+	state, err := sshtools.TreeState(localPath, syncOpts)
+	if err != nil {
+		return
+	}
+	if err = syncOnce(sftpClient, localPath, remotePath, syncOpts, showProgress); err != nil || !watch {
+		return
+	}
+
+	fmt.Fprintf(os.Stderr, "Watching %s for changes, press Ctrl-C to stop.\n", localPath)
+	interrupt := make(chan os.Signal, 1)
+	signal.Notify(interrupt, os.Interrupt)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-interrupt:
+			return
+		case <-ticker.C:
+		}
+		current, errs := sshtools.TreeState(localPath, syncOpts)
+		if errs != nil {
+			return errs
+		}
+		if current == state {
+			continue
+		}
+		state = current
+		if err = syncOnce(sftpClient, localPath, remotePath, syncOpts, showProgress); err != nil {
+			return
+		}
+	}
+}
+
+// syncOnce runs one sync and prints its summary.
+func syncOnce(client *sftp.Client, localPath, remotePath string, syncOpts sshtools.SyncOptions, showProgress bool) (err error) {
+	syncOpts.Progress = &sshtools.TransferProgress{}
+	stopProgress := func() {}
+	if showProgress {
+		stop := make(chan struct{})
+		stopped := make(chan struct{})
+		go func() {
+			defer close(stopped)
+			drawProgress(syncOpts.Progress, stop)
+		}()
+		stopProgress = func() {
+			close(stop)
+			<-stopped
+		}
+	}
+	start := time.Now()
+	result, err := sshtools.Sync(client, localPath, remotePath, syncOpts)
+	stopProgress()
+	if err != nil {
+		return
+	}
+	summary := "Uploaded %d files (%s), deleted %d, %d unchanged in %s\n"
+	if syncOpts.DryRun {
+		summary = "Would upload %d files (%s) and delete %d, %d unchanged (checked in %s)\n"
+	}
+	fmt.Fprintf(os.Stderr, summary, result.Uploaded, sshtools.FormatBytes(float64(result.Bytes)), result.Deleted, result.Unchanged,
+		time.Since(start).Round(time.Millisecond))
+	return
+}
@@ -0,0 +1,180 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/aoaeoe/sshTools/pkg/sshtools"
+	"golang.org/x/term"
+)
+
+// tailColors are the ANSI colors of the alias prefixes, one per server in
+// turn.
+var tailColors = []string{"36", "33", "35", "32", "34", "31", "96", "93", "95", "92", "94", "91"}
+
+// tailCommand follows files on several servers at once, prefixing every
+// line with the server's alias, until interrupted:
+// sshtools tail -tag web /var/log/nginx/access.log
+// sshtools tail -hosts db1,db2 -n 50 '/var/log/postgresql/*.log'
+func tailCommand(args []string) {
+	fs := flag.NewFlagSet("tail", flag.ExitOnError)
+	var opts commonFlags
+	var fleet fleetFlags
+	opts.register(fs, "follow the files on")
+	fleet.register(fs)
+	linesFlag := fs.Int("n", 10, "Print this many existing lines of each file first")
+	files := parseArgs(fs, args)
+	if len(files) == 0 || *linesFlag < 0 {
+		fmt.Fprintln(os.Stderr, "usage: sshtools tail (-tag <tag> | -hosts <a,b,...> | -alias <pattern>) [-n 10] <file>...")
+		os.Exit(2)
+	}
+
+	config, err := opts.load()
+	if err != nil {
+		exitConfigError(err)
+	}
+	servers, err := fleet.servers(config, &opts)
+	if err != nil || len(servers) == 0 {
+		if err == nil {
+			err = fmt.Errorf("no server matched")
+		}
+		fmt.Fprintln(os.Stderr, "Error:", err)
+		os.Exit(exitCode(err, 1))
+	}
+	inhibitor := opts.preventSleep("sshtools tail")
+	defer inhibitor.Release()
+
+	color := term.IsTerminal(int(os.Stdout.Fd())) && os.Getenv("NO_COLOR") == ""
+	width := 0
+	for _, server := range servers {
+		width = max(width, len(server.Alias))
+	}
+
+	// Ctrl-C 关闭所有连接，各服务器的循环随之结束
+	t := &tailer{opts: &opts, config: config, stop: make(chan struct{}), clients: map[*sshtools.Client]bool{}}
+	interrupt := make(chan os.Signal, 1)
+	signal.Notify(interrupt, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-interrupt
+		t.close()
+	}()
+
+	var outMu, errMu sync.Mutex
+	failed := make([]bool, len(servers))
+	forEachServer(servers, len(servers), func(i int, server *sshtools.Server) {
+		prefix := fmt.Sprintf("%-*s | ", width, server.Alias)
+		if color {
+			prefix = "\x1b[" + tailColors[i%len(tailColors)] + "m" + prefix + "\x1b[0m"
+		}
+		stdout := sshtools.NewPrefixWriter(os.Stdout, &outMu, prefix)
+		stderr := sshtools.NewPrefixWriter(os.Stderr, &errMu, prefix)
+		failed[i] = !t.follow(server, files, *linesFlag, stdout, stderr)
+	})
+	for _, f := range failed {
+		if f {
+			os.Exit(1)
+		}
+	}
+}
+
+// tailer runs the tail of every server and tracks their connections so
+// that Ctrl-C can close them.
+type tailer struct {
+	opts   *commonFlags
+	config *sshtools.Config
+
+	mu       sync.Mutex
+	stop     chan struct{}
+	stopping bool
+	clients  map[*sshtools.Client]bool
+}
+
+// close stops all the tails.
+func (t *tailer) close() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.stopping {
+		return
+	}
+	t.stopping = true
+	close(t.stop)
+	for client := range t.clients {
+		_ = client.Close()
+	}
+}
+
+// track adds client to the connections closed by Ctrl-C, or closes it
+// right away when that already happened.
+func (t *tailer) track(client *sshtools.Client) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.stopping {
+		_ = client.Close()
+		return false
+	}
+	t.clients[client] = true
+	return true
+}
+
+func (t *tailer) untrack(client *sshtools.Client) (stopping bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	delete(t.clients, client)
+	return t.stopping
+}
+
+// follow tails files on server until interrupted. A dropped connection is
+// redialled with backoff, and the tail resumes with new lines only. It
+// returns false if the first connection failed or tail itself exited.
+func (t *tailer) follow(server *sshtools.Server, files []string, lines int, stdout, stderr *sshtools.PrefixWriter) bool {
+	const maxBackoff = 30 * time.Second
+	backoff := time.Second
+	connected := false
+	for {
+		client, err := dialServer(t.opts, t.config, server)
+		if err != nil && !connected {
+			fmt.Fprintln(stderr, "Error:", err)
+			return false
+		}
+		if err == nil {
+			if !t.track(client) {
+				return true
+			}
+			if connected {
+				fmt.Fprintln(stderr, "reconnected")
+			}
+			connected, backoff = true, time.Second
+			err = client.Run(sshtools.TailCommand(files, lines), stdout, stderr)
+			_ = stdout.Flush()
+			_ = stderr.Flush()
+			if t.untrack(client) {
+				return true
+			}
+			dropped := connectionDropped(client, err)
+			_ = client.Close()
+			if !dropped {
+				// tail 自己退出了（例如远程没有 tail），重连也无济于事
+				if err != nil {
+					fmt.Fprintf(stderr, "tail exited: %v\n", err)
+				}
+				return err == nil
+			}
+			// 重连后只输出新行，避免重复
+			lines = 0
+			fmt.Fprintf(stderr, "connection lost, reconnecting in %s\n", backoff)
+		} else {
+			fmt.Fprintf(stderr, "reconnect failed (%v), retrying in %s\n", err, backoff)
+		}
+		select {
+		case <-t.stop:
+			return true
+		case <-time.After(backoff):
+		}
+		backoff = min(backoff*2, maxBackoff)
+	}
+}
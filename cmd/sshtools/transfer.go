@@ -0,0 +1,180 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/aoaeoe/sshTools/pkg/sshtools"
+	"golang.org/x/term"
+)
+
+// putCommand uploads over SFTP: sshtools put [-r] [-p] local.tar.gz web1:/tmp/
+func putCommand(args []string) {
+	transferCommand("put", args)
+}
+
+// getCommand downloads over SFTP: sshtools get [-r] [-p] web1:/var/log/app.log .
+func getCommand(args []string) {
+	transferCommand("get", args)
+}
+
+func transferCommand(name string, args []string) {
+	fs := flag.NewFlagSet(name, flag.ExitOnError)
+	var opts commonFlags
+	opts.register(fs, "transfer with")
+	recursiveFlag := fs.Bool("r", false, "Copy directories recursively")
+	preserveFlag := fs.Bool("p", false, "Preserve modification times (permissions are always kept)")
+	quietFlag := fs.Bool("q", false, "Don't show the progress bar")
+	limitFlag := fs.String("limit", "", "Limit the transfer rate, e.g. 2MB/s or 500K")
+	resumeFlag := fs.Bool("resume", false, "Continue partial files left by an interrupted transfer instead of starting over")
+	paths := parseArgs(fs, args)
+
+	usage := "usage: sshtools put [-r] [-p] [-limit 2MB/s] [-resume] <local path> <alias>:<remote path>"
+	src, dst := 0, 1
+	if name == "get" {
+		usage = "usage: sshtools get [-r] [-p] [-limit 2MB/s] [-resume] <alias>:<remote path> <local path>"
+		src, dst = 1, 0
+	}
+	if len(paths) != 2 {
+		fmt.Fprintln(os.Stderr, usage)
+		os.Exit(2)
+	}
+	alias, remotePath, ok := splitRemote(paths[dst])
+	if !ok {
+		fmt.Fprintln(os.Stderr, usage)
+		os.Exit(2)
+	}
+	localPath := paths[src]
+	limit, err := sshtools.ParseRate(*limitFlag)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Error:", err)
+		os.Exit(2)
+	}
+
+	config, err := opts.load()
+	if err != nil {
+		exitConfigError(err)
+	}
+	server := selectServer(config, alias, "", opts.tag)
+	inhibitor := opts.preventSleep("sshtools " + name)
+	defer inhibitor.Release()
+
+	showProgress := !*quietFlag && term.IsTerminal(int(os.Stderr.Fd()))
+	transfer := sshtools.TransferOptions{
+		Recursive:     *recursiveFlag,
+		PreserveTimes: *preserveFlag,
+		Progress:      &sshtools.TransferProgress{},
+		Limit:         limit,
+		Resume:        *resumeFlag,
+		Log: func(format string, args ...any) {
+			if showProgress {
+				// Clear the progress bar; it is redrawn below.
+				fmt.Fprint(os.Stderr, "\r\033[K")
+			}
+			fmt.Fprintf(os.Stderr, format+"\n", args...)
+		},
+	}
+	start := time.Now()
+	err = runTransfer(&opts, config, server, name, localPath, remotePath, transfer, showProgress)
+	elapsed := time.Since(start)
+	failures := 0
+	if err != nil {
+		failures = 1
+	}
+	notifier.Done(name, 1, elapsed, failures)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Error:", err)
+		os.Exit(exitCode(err, 1))
+	}
+	done := float64(transfer.Progress.Done())
+	verb := map[string]string{"put": "Uploaded", "get": "Downloaded"}[name]
+	fmt.Fprintf(os.Stderr, "%s %s in %s (%s/s)\n", verb, sshtools.FormatBytes(done),
+		elapsed.Round(time.Millisecond), sshtools.FormatBytes(done/max(elapsed.Seconds(), 0.001)))
+}
+
+func runTransfer(opts *commonFlags, config *sshtools.Config, server *sshtools.Server, name, localPath, remotePath string,
+	transfer sshtools.TransferOptions, showProgress bool) (err error) {
+	client, err := dialServer(opts, config, server)
+	if err != nil {
+		return
+	}
+	defer func(client *sshtools.Client) {
+		_ = client.Close()
+	}(client)
+	sftpClient, err := client.SFTP()
+	if err != nil {
+		return
+	}
+	defer func() { _ = sftpClient.Close() }()
+
+	if showProgress {
+		stop := make(chan struct{})
+		stopped := make(chan struct{})
+		go func() {
+			defer close(stopped)
+			drawProgress(transfer.Progress, stop)
+		}()
+		defer func() {
+			close(stop)
+			<-stopped
+		}()
+	}
+	if name == "put" {
+		return sshtools.Upload(sftpClient, localPath, remotePath, transfer)
+	}
+	return sshtools.Download(sftpClient, remotePath, localPath, transfer)
+}
+
+// drawProgress redraws a progress bar on stderr until stop is closed.
+func drawProgress(p *sshtools.TransferProgress, stop <-chan struct{}) {
+	const width = 24
+	ticker := time.NewTicker(200 * time.Millisecond)
+	defer ticker.Stop()
+	start := time.Now()
+	for {
+		select {
+		case <-stop:
+			fmt.Fprint(os.Stderr, "\r\033[K")
+			return
+		case <-ticker.C:
+		}
+		done, total := p.Done(), p.Total()
+		fraction := 1.0
+		if total > 0 {
+			fraction = min(float64(done)/float64(total), 1)
+		}
+		filled := int(fraction * width)
+		bar := strings.Repeat("=", filled) + strings.Repeat(" ", width-filled)
+		if filled < width {
+			bar = bar[:filled] + ">" + bar[filled+1:]
+		}
+		file := filepath.Base(p.Current())
+		if len(file) > 24 {
+			file = "…" + file[len(file)-23:]
+		}
+		rate := float64(done) / time.Since(start).Seconds()
+		fmt.Fprintf(os.Stderr, "\r\033[K%-24s [%s] %3.0f%% %s/%s %s/s", file, bar, fraction*100,
+			sshtools.FormatBytes(float64(done)), sshtools.FormatBytes(float64(total)), sshtools.FormatBytes(rate))
+	}
+}
+
+// splitRemote splits an <alias>:<path> argument. The path is relative to
+// the remote home directory unless absolute; a leading ~/ means the same.
+func splitRemote(arg string) (alias, remotePath string, ok bool) {
+	// C:\... is a local Windows path.
+	if filepath.VolumeName(arg) != "" {
+		return "", "", false
+	}
+	alias, remotePath, ok = strings.Cut(arg, ":")
+	if !ok || alias == "" {
+		return "", "", false
+	}
+	if remotePath == "~" || remotePath == "" {
+		remotePath = "."
+	}
+	return alias, strings.TrimPrefix(remotePath, "~/"), true
+}
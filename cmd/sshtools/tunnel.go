@@ -0,0 +1,510 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"os/exec"
+	"os/signal"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/aoaeoe/sshTools/pkg/sshtools"
+)
+
+// forwardFlags collects repeated -L specs.
+type forwardFlags []sshtools.Forward
+
+func (f *forwardFlags) String() string {
+	specs := make([]string, len(*f))
+	for i, fw := range *f {
+		specs[i] = forwardSpec(fw)
+	}
+	return strings.Join(specs, ",")
+}
+
+func (f *forwardFlags) Set(spec string) error {
+	fw, err := sshtools.ParseForwardSpec(spec)
+	if err != nil {
+		return err
+	}
+	*f = append(*f, fw)
+	return nil
+}
+
+// forwardSpec turns a Forward back into a -L spec.
+func forwardSpec(f sshtools.Forward) string {
+	return f.Local + ":" + f.Remote
+}
+
+// remoteForwardFlags collects repeated -R specs.
+type remoteForwardFlags []sshtools.Forward
+
+func (f *remoteForwardFlags) String() string {
+	specs := make([]string, len(*f))
+	for i, fw := range *f {
+		specs[i] = remoteForwardSpec(fw)
+	}
+	return strings.Join(specs, ",")
+}
+
+func (f *remoteForwardFlags) Set(spec string) error {
+	fw, err := sshtools.ParseRemoteForwardSpec(spec)
+	if err != nil {
+		return err
+	}
+	*f = append(*f, fw)
+	return nil
+}
+
+// remoteForwardSpec turns a remote Forward back into a -R spec.
+func remoteForwardSpec(f sshtools.Forward) string {
+	return f.Remote + ":" + f.Local
+}
+
+// dynamicFlags collects repeated -D specs as listen addresses.
+type dynamicFlags []string
+
+func (f *dynamicFlags) String() string {
+	return strings.Join(*f, ",")
+}
+
+func (f *dynamicFlags) Set(spec string) error {
+	addr, err := sshtools.ParseDynamicSpec(spec)
+	if err != nil {
+		return err
+	}
+	*f = append(*f, addr)
+	return nil
+}
+
+// tunnelForwards are the -L, -R and -D flags of the tunnel commands.
+type tunnelForwards struct {
+	local   forwardFlags
+	remote  remoteForwardFlags
+	dynamic dynamicFlags
+	// gateway is -g: forwards without a bind address listen on every
+	// interface, as with the server's gateway_ports.
+	gateway bool
+}
+
+func (f *tunnelForwards) register(fs *flag.FlagSet) {
+	fs.Var(&f.local, "L", "Forward [bind:]port:host:hostport (repeatable; default: the server's local_forwards)")
+	fs.Var(&f.remote, "R", "Forward [bind:]port on the server to host:hostport here (repeatable; default: the server's remote_forwards)")
+	fs.Var(&f.dynamic, "D", "Run a SOCKS5 proxy on [bind:]port (repeatable; default: the server's dynamic_forwards)")
+	fs.BoolVar(&f.gateway, "g", false, "Let other machines connect to forwards without a bind address, as ssh -g (default: gateway_ports)")
+}
+
+func (f *tunnelForwards) empty() bool {
+	return len(f.local) == 0 && len(f.remote) == 0 && len(f.dynamic) == 0
+}
+
+// metricsFlag registers -metrics on the commands that run tunnels.
+func metricsFlag(fs *flag.FlagSet) *string {
+	return fs.String("metrics", "", "Serve Prometheus metrics of the tunnels at http://`addr`/metrics, e.g. 127.0.0.1:9100")
+}
+
+// tunnelCommand runs port forwards in the foreground, or manages those
+// running in the background:
+// sshtools tunnel web1 -L 8080:localhost:80
+// sshtools tunnel web1 -D 1080
+// sshtools tunnel start -alias bastion -L 5432:db:5432
+// sshtools tunnel status
+// sshtools tunnel stop bastion
+func tunnelCommand(args []string) {
+	usage := "usage: sshtools tunnel <alias> [-L|-R [bind:]port:host:hostport | -D [bind:]port]... | start -alias <alias> [-L|-R|-D ...]... | status | stop <alias>"
+	if len(args) == 0 {
+		fmt.Fprintln(os.Stderr, usage)
+		os.Exit(2)
+	}
+	switch args[0] {
+	case "start":
+		tunnelStart(args[1:])
+	case "status":
+		tunnelStatus()
+	case "stop":
+		if len(args) != 2 {
+			fmt.Fprintln(os.Stderr, usage)
+			os.Exit(2)
+		}
+		if err := tunnelStop(args[1]); err != nil {
+			fmt.Fprintln(os.Stderr, "Error:", err)
+			os.Exit(exitCode(err, 1))
+		}
+	default:
+		tunnelRun(args)
+	}
+}
+
+// tunnelRun keeps the forwards open in the foreground until interrupted.
+// The alias can come first, before the flags.
+func tunnelRun(args []string) {
+	fs := flag.NewFlagSet("tunnel", flag.ExitOnError)
+	var opts commonFlags
+	var forwards tunnelForwards
+	opts.register(fs, "tunnel through")
+	forwards.register(fs)
+	metricsAddr := metricsFlag(fs)
+	var alias string
+	if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		alias, args = args[0], args[1:]
+	}
+	_ = fs.Parse(args)
+	if opts.alias == "" {
+		opts.alias = alias
+	}
+	if opts.alias == "" && opts.ip == "" || fs.NArg() > 0 {
+		fmt.Fprintln(os.Stderr, "usage: sshtools tunnel <alias> [-L|-R [bind:]port:host:hostport | -D [bind:]port]...")
+		os.Exit(2)
+	}
+
+	config, server, forwards := tunnelTarget(&opts, forwards)
+	if state, errs := sshtools.LoadTunnel(server.Alias); errs == nil && state.Running() {
+		fmt.Fprintf(os.Stderr, "Error: a tunnel to %s is already running (pid %d)\n", server.Alias, state.PID)
+		os.Exit(1)
+	}
+	if err := runTunnel(&opts, config, server, forwards, *metricsAddr, false); err != nil {
+		fmt.Fprintln(os.Stderr, "Error:", err)
+		os.Exit(exitCode(err, 1))
+	}
+}
+
+// tunnelTarget loads the config and returns it with the server to tunnel through
+// with the forwards to open: those given, or else its local_forwards,
+// remote_forwards and dynamic_forwards.
+func tunnelTarget(opts *commonFlags, forwards tunnelForwards) (*sshtools.Config, *sshtools.Server, tunnelForwards) {
+	config, err := opts.load()
+	if err != nil {
+		exitConfigError(err)
+	}
+	server := selectServer(config, opts.alias, opts.ip, opts.tag)
+	if forwards.gateway {
+		server.GatewayPorts = true
+	}
+	if forwards.empty() {
+		forwards = tunnelForwards{local: server.LocalForwards, remote: server.RemoteForwards, dynamic: server.DynamicForwards, gateway: forwards.gateway}
+	}
+	if forwards.empty() {
+		fmt.Fprintln(os.Stderr, "Error: no forwards, pass -L, -R or -D, or configure local_forwards, remote_forwards or dynamic_forwards")
+		os.Exit(2)
+	}
+	return config, server, forwards
+}
+
+func tunnelStart(args []string) {
+	fs := flag.NewFlagSet("tunnel start", flag.ExitOnError)
+	var opts commonFlags
+	var forwards tunnelForwards
+	opts.register(fs, "tunnel through")
+	forwards.register(fs)
+	metricsAddr := metricsFlag(fs)
+	daemonFlag := fs.Bool("daemon", false, "Run as the background tunnel process (used internally)")
+	_ = fs.Parse(args)
+
+	config, server, forwards := tunnelTarget(&opts, forwards)
+	if *daemonFlag {
+		if err := runTunnel(&opts, config, server, forwards, *metricsAddr, true); err != nil {
+			fmt.Fprintln(os.Stderr, "Error:", err)
+			os.Exit(exitCode(err, 1))
+		}
+		return
+	}
+
+	if state, errs := sshtools.LoadTunnel(server.Alias); errs == nil && state.Running() {
+		fmt.Fprintf(os.Stderr, "Error: a tunnel to %s is already running (pid %d)\n", server.Alias, state.PID)
+		os.Exit(1)
+	}
+	pid, err := startTunnelDaemon(&opts, server, forwards, *metricsAddr)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Error:", err)
+		os.Exit(exitCode(err, 1))
+	}
+	logFile, _ := sshtools.TunnelFile(server.Alias, ".log")
+	fmt.Printf("Tunnel to %s running in the background (pid %d), log in %s\n", server.Alias, pid, logFile)
+	printForwards(server, forwards)
+	if *metricsAddr != "" {
+		fmt.Printf("  metrics on http://%s/metrics\n", *metricsAddr)
+	}
+}
+
+// startTunnelDaemon re-executes ourselves with -daemon and waits until the
+// tunnel is listening. Like the control master, the child shares our
+// terminal until then so it can prompt for a password.
+func startTunnelDaemon(opts *commonFlags, server *sshtools.Server, forwards tunnelForwards, metricsAddr string) (pid int, err error) {
+	args := []string{"tunnel", "start", "-daemon", "-config", opts.configFile, "-alias", server.Alias}
+	for _, f := range forwards.local {
+		args = append(args, "-L", forwardSpec(f))
+	}
+	for _, f := range forwards.remote {
+		args = append(args, "-R", remoteForwardSpec(f))
+	}
+	for _, addr := range forwards.dynamic {
+		args = append(args, "-D", addr)
+	}
+	if forwards.gateway {
+		args = append(args, "-g")
+	}
+	if metricsAddr != "" {
+		args = append(args, "-metrics", metricsAddr)
+	}
+	return spawnTunnelDaemon(opts, args, []string{server.Alias})
+}
+
+// spawnTunnelDaemon starts ourselves with args and waits until the child
+// has published the state of every tunnel in keys.
+func spawnTunnelDaemon(opts *commonFlags, args, keys []string) (pid int, err error) {
+	self, err := os.Executable()
+	if err != nil {
+		return
+	}
+	if opts.acceptKey {
+		args = append(args, "-accept-changed-host-key")
+	}
+	if opts.askPass {
+		args = append(args, "-ask-pass")
+	}
+	if opts.auth != "" {
+		args = append(args, "-auth", opts.auth)
+	}
+	cmd := exec.Command(self, append(args, opts.verbosity()...)...)
+	cmd.Stdin, cmd.Stdout, cmd.Stderr = os.Stdin, os.Stdout, os.Stderr
+	cmd.Env = childEnv()
+	cmd.SysProcAttr = detachedProcAttr()
+	if err = cmd.Start(); err != nil {
+		return
+	}
+	pid = cmd.Process.Pid
+
+	exited := make(chan error, 1)
+	go func() {
+		exited <- cmd.Wait()
+	}()
+	ready := func() bool {
+		for _, key := range keys {
+			if state, errs := sshtools.LoadTunnel(key); errs != nil || state.PID != pid {
+				return false
+			}
+		}
+		return true
+	}
+	ticker := time.NewTicker(50 * time.Millisecond)
+	defer ticker.Stop()
+	for {
+		select {
+		case errs := <-exited:
+			if errs == nil {
+				errs = fmt.Errorf("tunnel exited before it was ready")
+			}
+			return pid, errs
+		case <-ticker.C:
+			if ready() {
+				return pid, nil
+			}
+		}
+	}
+}
+
+// runTunnel keeps the tunnel up until it is stopped. The background process
+// detaches from the terminal once listening; in the foreground, Ctrl-C
+// stops it.
+func runTunnel(opts *commonFlags, config *sshtools.Config, server *sshtools.Server, forwards tunnelForwards, metricsAddr string, detach bool) (err error) {
+	if dialer.PromptPassword != nil {
+		dialer.PromptPassword = rememberPassword(dialer.PromptPassword)
+	}
+	tunnel := &sshtools.Tunnel{Dialer: dialer, Server: server, Forwards: forwards.local, RemoteForwards: forwards.remote,
+		DynamicForwards: forwards.dynamic}
+	// 远程转发无法经过控制主连接，只有本地和动态转发复用它
+	if len(forwards.remote) == 0 {
+		tunnel.Dial = func(server *sshtools.Server) (*sshtools.Client, error) {
+			return dialServer(opts, config, server)
+		}
+	}
+	if err = tunnel.Listen(); err != nil {
+		return
+	}
+	defer sshtools.RemoveTunnel(server.Alias)
+	defer serveForwardControl(server.Alias, tunnel)()
+	if metricsAddr != "" {
+		metrics, errs := sshtools.ServeMetrics(metricsAddr, func() []sshtools.TunnelState {
+			return []sshtools.TunnelState{tunnel.State()}
+		})
+		if errs != nil {
+			tunnel.Close()
+			return errs
+		}
+		defer func() { _ = metrics.Close() }()
+	}
+
+	pidFile, err := sshtools.TunnelFile(server.Alias, ".pid")
+	if err != nil {
+		tunnel.Close()
+		return
+	}
+	if err = os.WriteFile(pidFile, []byte(strconv.Itoa(os.Getpid())+"\n"), 0o600); err != nil {
+		tunnel.Close()
+		return
+	}
+	if detach {
+		logFile, errs := sshtools.TunnelFile(server.Alias, ".log")
+		if errs != nil {
+			tunnel.Close()
+			return errs
+		}
+		if err = detachStdio(logFile); err != nil {
+			tunnel.Close()
+			return
+		}
+		fmt.Fprintf(os.Stderr, "%s tunnel to %s started\n", time.Now().Format(time.RFC3339), server.Alias)
+	} else {
+		fmt.Printf("Tunnel to %s running\n", server.Alias)
+		printForwards(server, forwards)
+		if metricsAddr != "" {
+			fmt.Printf("  metrics on http://%s/metrics\n", metricsAddr)
+		}
+		fmt.Println("Press Ctrl-C to stop.")
+	}
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM, syscall.SIGHUP)
+	go func() {
+		sig := <-signals
+		fmt.Fprintf(os.Stderr, "%s received %v, stopping\n", time.Now().Format(time.RFC3339), sig)
+		tunnel.Close()
+	}()
+	tunnel.Serve()
+	return
+}
+
+// printForwards lists the forwards of a tunnel to server.
+func printForwards(server *sshtools.Server, forwards tunnelForwards) {
+	for _, f := range forwards.local {
+		fmt.Printf("  %s -> %s\n", server.LocalBindAddress(f.Local), f.Remote)
+	}
+	for _, f := range forwards.remote {
+		fmt.Printf("  %s on %s -> %s\n", server.RemoteBindAddress(f.Remote), server.Alias, f.Local)
+	}
+	for _, addr := range forwards.dynamic {
+		fmt.Printf("  %s (SOCKS5 proxy through %s)\n", server.LocalBindAddress(addr), server.Alias)
+	}
+}
+
+// rememberPassword wraps prompt so it asks only once: the tunnel reuses the
+// answer when it reconnects after leaving the terminal.
+func rememberPassword(prompt func(string) (string, error)) func(string) (string, error) {
+	var mu sync.Mutex
+	var password string
+	var known bool
+	return func(question string) (string, error) {
+		mu.Lock()
+		defer mu.Unlock()
+		if known {
+			return password, nil
+		}
+		answer, err := prompt(question)
+		if err == nil {
+			password, known = answer, true
+		}
+		return answer, err
+	}
+}
+
+// tunnelStatus lists the tunnels, removing the records of dead ones.
+func tunnelStatus() {
+	states, err := sshtools.ListTunnels()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Error:", err)
+		os.Exit(exitCode(err, 1))
+	}
+	if len(states) == 0 {
+		fmt.Println("No tunnels running.")
+		return
+	}
+	printTunnelStates(states)
+}
+
+// printTunnelStates prints a table of tunnels, removing the records of dead
+// ones. Named tunnels show as name (alias).
+func printTunnelStates(states []*sshtools.TunnelState) {
+	fmt.Printf("%-16s %-7s %-13s %-9s %-6s %-9s %-9s %s\n", "ALIAS", "PID", "STATE", "UPTIME", "CONNS", "IN", "OUT", "FORWARDS")
+	for _, s := range states {
+		state := s.State
+		if !s.Running() {
+			state = "exited"
+			sshtools.RemoveTunnel(tunnelKey(s))
+		}
+		specs := make([]string, 0, len(s.Forwards)+len(s.RemoteForwards)+len(s.DynamicForwards))
+		for _, f := range s.Forwards {
+			specs = append(specs, f.Local+"->"+f.Remote)
+		}
+		for _, f := range s.RemoteForwards {
+			specs = append(specs, "R:"+f.Remote+"->"+f.Local)
+		}
+		for _, addr := range s.DynamicForwards {
+			specs = append(specs, "D:"+addr)
+		}
+		alias := s.Alias
+		if s.Name != "" {
+			alias = s.Name + " (" + s.Alias + ")"
+		}
+		fmt.Printf("%-16s %-7d %-13s %-9s %-6d %-9s %-9s %s\n", alias, s.PID, state,
+			time.Since(s.Started).Round(time.Second), s.Connections,
+			sshtools.FormatBytes(float64(s.BytesIn)), sshtools.FormatBytes(float64(s.BytesOut)), strings.Join(specs, " "))
+		if s.State == sshtools.TunnelReconnecting && s.LastError != "" {
+			fmt.Printf("%-16s last error: %s\n", "", s.LastError)
+		}
+	}
+}
+
+// tunnelKey is the name the state of a tunnel is kept under.
+func tunnelKey(s *sshtools.TunnelState) string {
+	if s.Name != "" {
+		return s.Name
+	}
+	return s.Alias
+}
+
+// tunnelStop asks the tunnel to alias to exit and waits for it.
+func tunnelStop(alias string) (err error) {
+	state, err := sshtools.LoadTunnel(alias)
+	if err != nil {
+		return
+	}
+	if state.Name != "" {
+		return fmt.Errorf("%s is one of the configured tunnels, stop them with sshtools tunnels down", alias)
+	}
+	if !state.Running() {
+		sshtools.RemoveTunnel(alias)
+		return fmt.Errorf("the tunnel to %s is not running", alias)
+	}
+	if err = stopTunnelProcess(state); err != nil {
+		return
+	}
+	fmt.Printf("Stopped the tunnel to %s.\n", alias)
+	return
+}
+
+// stopTunnelProcess asks the process that published state to exit and
+// waits for it.
+func stopTunnelProcess(state *sshtools.TunnelState) (err error) {
+	process, err := os.FindProcess(state.PID)
+	if err != nil {
+		return
+	}
+	if errs := process.Signal(syscall.SIGTERM); errs != nil {
+		// Windows can only kill.
+		if err = process.Kill(); err != nil {
+			return
+		}
+		sshtools.RemoveTunnel(tunnelKey(state))
+	}
+	for deadline := time.Now().Add(5 * time.Second); state.Running(); {
+		if time.Now().After(deadline) {
+			return fmt.Errorf("the tunnel process %d did not exit", state.PID)
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+	return
+}
@@ -0,0 +1,270 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/aoaeoe/sshTools/pkg/sshtools"
+)
+
+// tunnelsCommand keeps the tunnels section of the config up in one
+// background process that reconnects dropped tunnels:
+// sshtools tunnels up
+// sshtools tunnels up db metrics
+// sshtools tunnels status
+// sshtools tunnels down
+func tunnelsCommand(args []string) {
+	usage := "usage: sshtools tunnels up [name]... | status | down"
+	if len(args) == 0 {
+		fmt.Fprintln(os.Stderr, usage)
+		os.Exit(2)
+	}
+	var err error
+	switch args[0] {
+	case "up":
+		tunnelsUp(args[1:])
+	case "status":
+		err = tunnelsStatus(args[1:])
+	case "down":
+		err = tunnelsDown()
+	default:
+		fmt.Fprintln(os.Stderr, usage)
+		os.Exit(2)
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Error:", err)
+		os.Exit(exitCode(err, 1))
+	}
+}
+
+func tunnelsUp(args []string) {
+	fs := flag.NewFlagSet("tunnels up", flag.ExitOnError)
+	var opts commonFlags
+	opts.register(fs, "tunnel through")
+	metricsAddr := metricsFlag(fs)
+	daemonFlag := fs.Bool("daemon", false, "Run as the background tunnels process (used internally)")
+	hideFlags(fs, "alias", "ip", "tag", "daemon")
+	names := parseArgs(fs, args)
+
+	config, err := opts.load()
+	if err != nil {
+		exitConfigError(err)
+	}
+	entries, err := selectTunnels(config, names)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Error:", err)
+		os.Exit(2)
+	}
+	if *daemonFlag {
+		if err = runTunnels(config, entries, *metricsAddr); err != nil {
+			fmt.Fprintln(os.Stderr, "Error:", err)
+			os.Exit(exitCode(err, 1))
+		}
+		return
+	}
+
+	keys := make([]string, len(entries))
+	for i, entry := range entries {
+		if state, errs := sshtools.LoadTunnel(entry.Name); errs == nil && state.Running() {
+			fmt.Fprintf(os.Stderr, "Error: tunnel %s is already running (pid %d)\n", entry.Name, state.PID)
+			os.Exit(1)
+		}
+		keys[i] = entry.Name
+	}
+	daemonArgs := []string{"tunnels", "up", "-daemon", "-config", opts.configFile}
+	if *metricsAddr != "" {
+		daemonArgs = append(daemonArgs, "-metrics", *metricsAddr)
+	}
+	pid, err := spawnTunnelDaemon(&opts, append(daemonArgs, keys...), keys)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Error:", err)
+		os.Exit(exitCode(err, 1))
+	}
+	logFile, _ := tunnelsLogFile()
+	fmt.Printf("Tunnels running in the background (pid %d), log in %s\n", pid, logFile)
+	var states []*sshtools.TunnelState
+	for _, key := range keys {
+		if state, errs := sshtools.LoadTunnel(key); errs == nil {
+			states = append(states, state)
+		}
+	}
+	printTunnelStates(states)
+	if *metricsAddr != "" {
+		fmt.Printf("Metrics on http://%s/metrics\n", *metricsAddr)
+	}
+}
+
+// selectTunnels returns the tunnels of the config called names, or all of
+// them.
+func selectTunnels(config *sshtools.Config, names []string) (entries []*sshtools.TunnelConfig, err error) {
+	if len(config.Tunnels) == 0 {
+		return nil, fmt.Errorf("no tunnels configured, add a \"tunnels\" section to the config file")
+	}
+	if len(names) == 0 {
+		for i := range config.Tunnels {
+			entries = append(entries, &config.Tunnels[i])
+		}
+		return
+	}
+	for _, name := range names {
+		entry := config.TunnelByName(name)
+		if entry == nil {
+			return nil, fmt.Errorf("no tunnel named %s in the config", name)
+		}
+		entries = append(entries, entry)
+	}
+	return
+}
+
+// tunnelsLogFile returns the log of the background tunnels process.
+func tunnelsLogFile() (string, error) {
+	dir, err := sshtools.TunnelDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "tunnels.log"), nil
+}
+
+// runTunnels is the background process of tunnels up. Each tunnel is tried
+// once while the terminal is still ours, so passwords can be asked for;
+// those that fail keep being retried with backoff after it detaches.
+func runTunnels(config *sshtools.Config, entries []*sshtools.TunnelConfig, metricsAddr string) (err error) {
+	tunnels := make([]*sshtools.Tunnel, 0, len(entries))
+	for _, entry := range entries {
+		// 每个隧道单独记住密码，重连时不再询问
+		d := *dialer
+		if d.PromptPassword != nil {
+			d.PromptPassword = rememberPassword(d.PromptPassword)
+		}
+		tunnel := &sshtools.Tunnel{Dialer: &d, Server: config.ServerByAlias(entry.Server), Name: entry.Name,
+			Forwards: entry.LocalForwards, RemoteForwards: entry.RemoteForwards, DynamicForwards: entry.DynamicForwards}
+		defer sshtools.RemoveTunnel(entry.Name)
+		defer serveForwardControl(entry.Name, tunnel)()
+		if errs := tunnel.Open(); errs != nil {
+			fmt.Fprintf(os.Stderr, "tunnel %s: %v, retrying in the background\n", entry.Name, errs)
+		}
+		tunnels = append(tunnels, tunnel)
+	}
+	conns := make([]*sshtools.APIConnection, len(tunnels))
+	for i, tunnel := range tunnels {
+		conns[i] = &sshtools.APIConnection{Name: entries[i].Name, Server: tunnel.Server, Client: tunnel.Client, Forwards: tunnel}
+	}
+	defer serveControlAPI(config, "tunnels", conns)()
+	if metricsAddr != "" {
+		metrics, errs := sshtools.ServeMetrics(metricsAddr, func() (states []sshtools.TunnelState) {
+			for _, tunnel := range tunnels {
+				states = append(states, tunnel.State())
+			}
+			return
+		})
+		if errs != nil {
+			for _, tunnel := range tunnels {
+				tunnel.Close()
+			}
+			return errs
+		}
+		defer func() { _ = metrics.Close() }()
+	}
+
+	logFile, err := tunnelsLogFile()
+	if err != nil {
+		return
+	}
+	if err = detachStdio(logFile); err != nil {
+		return
+	}
+	fmt.Fprintf(os.Stderr, "%s started %d tunnels\n", time.Now().Format(time.RFC3339), len(tunnels))
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM, syscall.SIGHUP)
+	go func() {
+		sig := <-signals
+		fmt.Fprintf(os.Stderr, "%s received %v, stopping\n", time.Now().Format(time.RFC3339), sig)
+		for _, tunnel := range tunnels {
+			tunnel.Close()
+		}
+	}()
+	var wg sync.WaitGroup
+	for _, tunnel := range tunnels {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			tunnel.Supervise()
+		}()
+	}
+	wg.Wait()
+	return
+}
+
+// tunnelsStatus lists the configured tunnels, running or not.
+func tunnelsStatus(args []string) (err error) {
+	fs := flag.NewFlagSet("tunnels status", flag.ExitOnError)
+	var opts commonFlags
+	opts.register(fs, "tunnel through")
+	hideFlags(fs, "alias", "ip", "tag")
+	_ = fs.Parse(args)
+
+	config, err := opts.load()
+	if err != nil {
+		return
+	}
+	if len(config.Tunnels) == 0 {
+		fmt.Println("No tunnels configured.")
+		return
+	}
+	var states []*sshtools.TunnelState
+	var down []string
+	for _, entry := range config.Tunnels {
+		if state, errs := sshtools.LoadTunnel(entry.Name); errs == nil && state.Name != "" && state.Running() {
+			states = append(states, state)
+		} else {
+			down = append(down, entry.Name)
+		}
+	}
+	if len(states) > 0 {
+		printTunnelStates(states)
+	}
+	if len(down) > 0 {
+		fmt.Println("Not running:", strings.Join(down, ", "))
+	}
+	return
+}
+
+// tunnelsDown stops every process started by tunnels up.
+func tunnelsDown() (err error) {
+	states, err := sshtools.ListTunnels()
+	if err != nil {
+		return
+	}
+	// 同一进程中的隧道一起停止
+	stopped, pids := 0, map[int]bool{}
+	for _, state := range states {
+		if state.Name == "" {
+			continue
+		}
+		if !pids[state.PID] {
+			if !state.Running() {
+				sshtools.RemoveTunnel(state.Name)
+				continue
+			}
+			if err = stopTunnelProcess(state); err != nil {
+				return
+			}
+			pids[state.PID] = true
+		}
+		stopped++
+	}
+	if stopped == 0 {
+		fmt.Println("No tunnels running.")
+		return
+	}
+	fmt.Printf("Stopped %d tunnels.\n", stopped)
+	return
+}
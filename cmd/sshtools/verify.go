@@ -0,0 +1,91 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/aoaeoe/sshTools/pkg/sshtools"
+)
+
+// verifyCommand compares the checksums of remote files with local copies:
+// sshtools verify web1:/var/www/site ./site
+// sshtools verify -exclude .git/ -sftp web1:backup.tar.gz ./backup.tar.gz
+func verifyCommand(args []string) {
+	fs := flag.NewFlagSet("verify", flag.ExitOnError)
+	var opts commonFlags
+	opts.register(fs, "verify against")
+	opts.registerQuiet(fs)
+	var excludes excludeFlags
+	fs.Var(&excludes, "exclude", "Skip files and directories matching this pattern (repeatable; \"dir/\" only matches directories)")
+	sftpFlag := fs.Bool("sftp", false, "Read the remote files over SFTP instead of running sha256sum on the server")
+	paths := parseArgs(fs, args)
+
+	usage := "usage: sshtools verify [-exclude pattern] [-sftp] <alias>:<remote path> <local path>"
+	if len(paths) != 2 {
+		fmt.Fprintln(os.Stderr, usage)
+		os.Exit(2)
+	}
+	alias, remotePath, ok := splitRemote(paths[0])
+	if !ok {
+		fmt.Fprintln(os.Stderr, usage)
+		os.Exit(2)
+	}
+	localPath := paths[1]
+
+	config, err := opts.load()
+	if err != nil {
+		exitConfigError(err)
+	}
+	server := selectServer(config, alias, "", opts.tag)
+	client, err := dialServer(&opts, config, server)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Error:", err)
+		os.Exit(exitCode(err, 1))
+	}
+	defer func(client *sshtools.Client) {
+		_ = client.Close()
+	}(client)
+
+	start := time.Now()
+	result, err := client.Verify(remotePath, localPath, sshtools.VerifyOptions{
+		Exclude: excludes,
+		SFTP:    *sftpFlag,
+		Log: func(format string, args ...any) {
+			if !opts.quiet {
+				fmt.Fprintf(os.Stderr, format+"\n", args...)
+			}
+		},
+	})
+	if err != nil {
+		_ = client.Close()
+		fmt.Fprintln(os.Stderr, "Error:", err)
+		os.Exit(exitCode(err, 1))
+	}
+	// 比较单个文件时结果中的路径为 "."
+	name := func(rel string) string {
+		if rel == "." {
+			return remotePath
+		}
+		return rel
+	}
+	for _, rel := range result.Mismatched {
+		fmt.Printf("differs       %s\n", name(rel))
+	}
+	for _, rel := range result.OnlyLocal {
+		fmt.Printf("only local    %s\n", name(rel))
+	}
+	for _, rel := range result.OnlyRemote {
+		fmt.Printf("only remote   %s\n", name(rel))
+	}
+	if !opts.quiet {
+		fmt.Fprintf(os.Stderr, "%d files (%s) match, %d differ, %d only local, %d only on %s (checked in %s)\n",
+			result.Matched, sshtools.FormatBytes(float64(result.Bytes)), len(result.Mismatched), len(result.OnlyLocal),
+			len(result.OnlyRemote), server.Alias, time.Since(start).Round(time.Millisecond))
+	}
+	if !result.OK() {
+		_ = client.Close()
+		os.Exit(1)
+	}
+}
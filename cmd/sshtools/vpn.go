@@ -0,0 +1,135 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+
+	"github.com/aoaeoe/sshTools/pkg/sshtools"
+)
+
+// routeFlags collects repeated -route subnets.
+type routeFlags []string
+
+func (f *routeFlags) String() string {
+	return strings.Join(*f, ",")
+}
+
+func (f *routeFlags) Set(route string) error {
+	*f = append(*f, route)
+	return nil
+}
+
+// vpnCommand links a local tun device to one on the server and routes
+// subnets through it until interrupted:
+// sshtools vpn office
+// sshtools vpn office -local 10.99.0.1 -remote 10.99.0.2 -route 10.0.0.0/16
+func vpnCommand(args []string) {
+	fs := flag.NewFlagSet("vpn", flag.ExitOnError)
+	var opts commonFlags
+	var routes routeFlags
+	opts.register(fs, "connect the VPN to")
+	localFlag := fs.String("local", "", "Address of this end of the link (default: the server's vpn.local_address)")
+	remoteFlag := fs.String("remote", "", "Address of the server's end of the link (default: the server's vpn.remote_address)")
+	fs.Var(&routes, "route", "Send this subnet through the link (repeatable; default: the server's vpn.routes)")
+	unitFlag := fs.Int("remote-unit", -1, "Number of the server's tun device (default: the server's vpn.remote_unit, or 0)")
+	skipSetupFlag := fs.Bool("skip-remote-setup", false, "Do not configure the server's tun device with ip")
+	positional := parseArgs(fs, args)
+	if len(positional) == 1 && opts.alias == "" && opts.ip == "" {
+		opts.alias = positional[0]
+	} else if len(positional) > 0 || opts.alias == "" && opts.ip == "" {
+		fmt.Fprintln(os.Stderr, "usage: sshtools vpn <alias> [-local <ip>] [-remote <ip>] [-route <subnet>]...")
+		os.Exit(2)
+	}
+
+	config, err := opts.load()
+	if err != nil {
+		exitConfigError(err)
+	}
+	server := selectServer(config, opts.alias, opts.ip, opts.tag)
+	var vpn sshtools.VPN
+	if server.VPN != nil {
+		vpn = *server.VPN
+	}
+	if *localFlag != "" {
+		vpn.LocalAddress = *localFlag
+	}
+	if *remoteFlag != "" {
+		vpn.RemoteAddress = *remoteFlag
+	}
+	if len(routes) > 0 {
+		vpn.Routes = routes
+	}
+	if *unitFlag >= 0 {
+		vpn.RemoteUnit = *unitFlag
+	}
+	vpn.SkipRemoteSetup = vpn.SkipRemoteSetup || *skipSetupFlag
+	if vpn.LocalAddress == "" || vpn.RemoteAddress == "" {
+		fmt.Fprintf(os.Stderr, "Error: %s has no vpn settings, pass -local and -remote or configure \"vpn\"\n", server.Alias)
+		os.Exit(2)
+	}
+	if err = vpn.Check(); err != nil {
+		fmt.Fprintln(os.Stderr, "Error:", err)
+		os.Exit(2)
+	}
+
+	// 先建本地设备：没有权限时不必连接服务器
+	dev, name, err := sshtools.OpenTunDevice()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Error:", err)
+		os.Exit(1)
+	}
+	defer func() {
+		_ = dev.Close()
+	}()
+
+	client, err := dialServer(&opts, config, server)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Error:", err)
+		os.Exit(exitCode(err, exitConnect))
+	}
+	defer func(client *sshtools.Client) {
+		_ = client.Close()
+	}(client)
+	ch, err := client.OpenTun(vpn.RemoteUnit)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Error:", err)
+		os.Exit(1)
+	}
+	if !vpn.SkipRemoteSetup {
+		if err = client.SetupRemoteTun(&vpn); err != nil {
+			fmt.Fprintln(os.Stderr, "Error:", err)
+			os.Exit(1)
+		}
+	}
+	if err = sshtools.ConfigureTunDevice(name, &vpn); err != nil {
+		fmt.Fprintln(os.Stderr, "Error:", err)
+		os.Exit(1)
+	}
+	fmt.Printf("VPN to %s up: %s %s <-> %s %s\n", server.Alias, name, vpn.LocalAddress, vpn.RemoteDevice(), vpn.RemoteAddress)
+	for _, route := range vpn.Routes {
+		fmt.Printf("  routing %s\n", route)
+	}
+	fmt.Println("Press Ctrl-C to disconnect.")
+
+	// 本地设备关闭时内核删除它和经过它的路由
+	done := make(chan error, 1)
+	go func() {
+		done <- sshtools.PumpTun(dev, ch)
+	}()
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM, syscall.SIGHUP)
+	select {
+	case err = <-done:
+		fmt.Fprintln(os.Stderr, "Error:", err)
+		_ = client.Close()
+		_ = dev.Close()
+		os.Exit(1)
+	case <-signals:
+		_ = ch.Close()
+		fmt.Printf("VPN to %s down.\n", server.Alias)
+	}
+}
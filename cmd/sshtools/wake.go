@@ -0,0 +1,49 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/aoaeoe/sshTools/pkg/sshtools"
+)
+
+// wakeServer sends the Wake-on-LAN packet of server for -wake, from here or
+// through its wake_relay, and waits until it answers SSH.
+func wakeServer(opts *commonFlags, config *sshtools.Config, server *sshtools.Server) (err error) {
+	if server.MacAddress == "" {
+		return fmt.Errorf("-wake needs a mac_address for %s", server.Alias)
+	}
+	if server.WakeRelay == "" {
+		if err = sshtools.SendWakeOnLAN(server); err != nil {
+			return
+		}
+		fmt.Fprintf(os.Stderr, "sent a Wake-on-LAN packet for %s to %s\n", server.Alias, server.WakeBroadcast())
+		return waitForServer(opts, server)
+	}
+
+	// 唤醒包不能跨网段广播，由同一局域网的服务器代为发送
+	relay := config.ServerByAlias(server.WakeRelay)
+	if relay == nil {
+		return fmt.Errorf("wake_relay %q of %s is not in the config", server.WakeRelay, server.Alias)
+	}
+	command, err := sshtools.WakeCommand(server)
+	if err != nil {
+		return
+	}
+	client, err := dialer.Dial(relay)
+	if err != nil {
+		return fmt.Errorf("failed to connect to wake_relay %s: %v", relay.Alias, err)
+	}
+	res := client.Capture(command, sshtools.DefaultMaxCapture, false)
+	_ = client.Close()
+	if res.Error != "" || res.ExitCode != 0 {
+		msg := strings.TrimSpace(res.Stderr)
+		if msg == "" {
+			msg = res.Error
+		}
+		return fmt.Errorf("failed to send the Wake-on-LAN packet through %s (exit %d): %s", relay.Alias, res.ExitCode, msg)
+	}
+	fmt.Fprintf(os.Stderr, "sent a Wake-on-LAN packet for %s through %s\n", server.Alias, relay.Alias)
+	return waitForServer(opts, server)
+}
@@ -0,0 +1,22 @@
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/aoaeoe/sshTools/pkg/sshtools"
+)
+
+// watchCommand attaches to a session shared with -share:
+// sshtools watch /tmp/pair.sock
+func watchCommand(args []string) {
+	if len(args) != 1 {
+		fmt.Fprintln(os.Stderr, "usage: sshtools watch <socket>")
+		os.Exit(2)
+	}
+	if err := sshtools.WatchShare(args[0], os.Stdin, os.Stdout); err != nil {
+		fmt.Fprintln(os.Stderr, "Error:", err)
+		os.Exit(1)
+	}
+	fmt.Println()
+}
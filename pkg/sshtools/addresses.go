@@ -0,0 +1,417 @@
+package sshtools
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Address families for Server.AddressFamily, named as in OpenSSH.
+const (
+	FamilyAny   = "any"
+	FamilyInet  = "inet"
+	FamilyInet6 = "inet6"
+)
+
+// PerAddressTimeout bounds the connect and handshake to each address when a
+// server has more than one to try, so a dead address doesn't use up the
+// whole timeout.
+const PerAddressTimeout = 5 * time.Second
+
+// happyEyeballsDelay is how long a connection attempt runs alone before the
+// next address is tried alongside it (the Connection Attempt Delay of
+// RFC 8305).
+const happyEyeballsDelay = 250 * time.Millisecond
+
+// lastAddressFile holds, per alias, the address that last connected.
+const lastAddressFile = "last-address.json"
+
+// serverJSON is Server without its JSON methods.
+type serverJSON Server
+
+// UnmarshalJSON accepts "address" as a single string or a list of strings.
+func (s *Server) UnmarshalJSON(data []byte) error {
+	var aux struct {
+		serverJSON
+		Address json.RawMessage `json:"address"`
+	}
+	if err := json.Unmarshal(data, &aux); err != nil {
+		return err
+	}
+	*s = Server(aux.serverJSON)
+	s.Address, s.Addresses = "", nil
+	if len(aux.Address) == 0 || string(aux.Address) == "null" {
+		return nil
+	}
+	if aux.Address[0] != '[' {
+		return json.Unmarshal(aux.Address, &s.Address)
+	}
+	if err := json.Unmarshal(aux.Address, &s.Addresses); err != nil {
+		return err
+	}
+	if len(s.Addresses) > 0 {
+		s.Address = s.Addresses[0]
+	}
+	return nil
+}
+
+// MarshalJSON writes "address" as a list when there is more than one.
+func (s Server) MarshalJSON() ([]byte, error) {
+	aux := struct {
+		serverJSON
+		Address any `json:"address"`
+	}{serverJSON(s), s.Address}
+	if len(s.Addresses) > 1 {
+		aux.Address = s.Addresses
+	}
+	return json.Marshal(aux)
+}
+
+// AddressList returns every configured address of s, preferred first.
+func (s *Server) AddressList() []string {
+	if len(s.Addresses) > 0 {
+		return s.Addresses
+	}
+	return []string{s.Address}
+}
+
+// dialCandidate is one place to try: a configured address and, when it is a
+// host name, one of the IPs it resolved to.
+type dialCandidate struct {
+	address string
+	ip      string
+}
+
+func (c dialCandidate) String() string {
+	if c.ip == "" || c.ip == c.address {
+		return c.address
+	}
+	return fmt.Sprintf("%s (%s)", c.address, c.ip)
+}
+
+// candidates returns the addresses to try for server in order: the one that
+// last worked first, then the configured order, with the IPs of each host
+// name ordered by the server's address family. Names that don't resolve are
+// returned as failures.
+func (d *Dialer) candidates(server *Server) (cands []dialCandidate, failures []string) {
+	family := server.AddressFamily
+	if d.Family != "" {
+		family = d.Family
+	}
+	for _, address := range server.AddressList() {
+		// The proxy, proxy command, WebSocket gateway, Session Manager or
+		// jump host does its own resolving.
+		if server.ProxyCommand != "" || server.WebSocket != "" || server.UsesSSM() || server.UsesProxy() || len(server.JumpHosts) > 0 {
+			cands = append(cands, dialCandidate{address: address})
+			continue
+		}
+		if ip := net.ParseIP(address); ip != nil {
+			if d.Family != "" && !inFamily(ip, d.Family) {
+				failures = append(failures, fmt.Sprintf("%s: not an %s address", address, familyName(d.Family)))
+				continue
+			}
+			cands = append(cands, dialCandidate{address: address})
+			continue
+		}
+		d.Logf(1, "resolving %s", address)
+		ctx, cancel := context.WithTimeout(context.Background(), d.timeout(server))
+		ips, err := net.DefaultResolver.LookupIPAddr(ctx, address)
+		cancel()
+		if err != nil {
+			d.Logf(1, "resolving %s failed: %v", address, err)
+			failures = append(failures, fmt.Sprintf("%s: %v", address, err))
+			continue
+		}
+		d.Logf(1, "%s resolved to %s", address, joinIPs(ips))
+		// -4 和 -6 只保留该地址族的地址
+		if d.Family != "" {
+			ips = slices.DeleteFunc(ips, func(ip net.IPAddr) bool { return !inFamily(ip.IP, d.Family) })
+			if len(ips) == 0 {
+				failures = append(failures, fmt.Sprintf("%s: has no %s address", address, familyName(d.Family)))
+				continue
+			}
+		}
+		for _, ip := range preferFamily(ips, family) {
+			cands = append(cands, dialCandidate{address: address, ip: ip.String()})
+		}
+	}
+
+	if len(cands) < 2 || server.Alias == "" {
+		return
+	}
+	if last, ok := readLastAddresses()[server.Alias]; ok {
+		rank := func(c dialCandidate) int {
+			switch {
+			case c == last:
+				return 0
+			case c.address == last.address:
+				return 1
+			}
+			return 2
+		}
+		slices.SortStableFunc(cands, func(a, b dialCandidate) int { return rank(a) - rank(b) })
+	}
+	return
+}
+
+// joinIPs lists ips for diagnostics.
+func joinIPs(ips []net.IPAddr) string {
+	names := make([]string, len(ips))
+	for i, ip := range ips {
+		names[i] = ip.String()
+	}
+	return strings.Join(names, ", ")
+}
+
+// preferFamily orders ips with the preferred family first, keeping the
+// resolver's order otherwise.
+func preferFamily(ips []net.IPAddr, family string) []net.IPAddr {
+	rank := func(ip net.IPAddr) int {
+		isV4 := ip.IP.To4() != nil
+		switch {
+		case family == FamilyInet && !isV4, family == FamilyInet6 && isV4:
+			return 1
+		}
+		return 0
+	}
+	slices.SortStableFunc(ips, func(a, b net.IPAddr) int { return rank(a) - rank(b) })
+	return ips
+}
+
+// inFamily reports whether ip belongs to family, FamilyInet or FamilyInet6.
+func inFamily(ip net.IP, family string) bool {
+	return (ip.To4() != nil) == (family == FamilyInet)
+}
+
+// familyName names family in messages.
+func familyName(family string) string {
+	if family == FamilyInet6 {
+		return "IPv6"
+	}
+	return "IPv4"
+}
+
+// addressTimeout returns the connect/handshake timeout for each of n
+// candidates.
+func (d *Dialer) addressTimeout(server *Server, n int) time.Duration {
+	if n > 1 && d.timeout(server) > PerAddressTimeout {
+		return PerAddressTimeout
+	}
+	return d.timeout(server)
+}
+
+// dialAt opens the transport to server at one candidate address, through
+// the jump host via when it is not nil.
+func (d *Dialer) dialAt(server *Server, cand dialCandidate, timeout time.Duration, via *Client) (conn net.Conn, err error) {
+	target := *server
+	target.Address, target.Addresses = cand.address, nil
+	if via != nil {
+		d.Logf(1, "connecting to %s port %d through %s", cand, server.Port, via.Server.Alias)
+		return dialVia(via, net.JoinHostPort(cand.address, fmt.Sprint(server.Port)), timeout)
+	}
+	if server.UsesSSM() {
+		d.Logf(1, "starting a Session Manager session to %s port %d", server.InstanceID, server.Port)
+		return dialSSM(&target, timeout)
+	}
+	if server.ProxyCommand != "" {
+		d.Logf(1, "executing proxy command: %s", expandProxyCommand(server.ProxyCommand, &target))
+		return dialProxyCommand(&target)
+	}
+	if server.WebSocket != "" {
+		d.Logf(1, "connecting to %s port %d through websocket %s", cand, server.Port, target.RedactedWebSocket())
+		return dialWebSocket(&target, timeout)
+	}
+	if server.UsesProxy() {
+		d.Logf(1, "connecting to %s port %d through proxy %s", cand, server.Port, server.RedactedProxy())
+		return dialProxy(server.Proxy, net.JoinHostPort(cand.address, fmt.Sprint(server.Port)), timeout)
+	}
+	host := cand.address
+	if cand.ip != "" {
+		host = cand.ip
+	}
+	// 选择服务器时已预先建立的连接直接使用
+	if conn = d.Prewarmed.take(prewarmKey(cand, server.Port)); conn != nil {
+		d.Logf(1, "using the connection to %s port %d opened while choosing", cand, server.Port)
+		return
+	}
+	d.Logf(1, "connecting to %s port %d", cand, server.Port)
+	return net.DialTimeout("tcp", net.JoinHostPort(host, fmt.Sprint(server.Port)), timeout)
+}
+
+// dialFailure is a candidate that could not be connected to.
+type dialFailure struct {
+	cand dialCandidate
+	err  error
+}
+
+// connectFirst opens the transport to the first of cands that connects.
+// Attempts start in order, each happyEyeballsDelay after the previous one
+// or as soon as it fails, and run in parallel, so a dead address delays
+// the connection by the delay rather than by a timeout. rest are the
+// candidates that neither won nor failed, to try should the handshake on
+// conn fail; conn is nil when every candidate failed.
+func (d *Dialer) connectFirst(server *Server, cands []dialCandidate, timeout time.Duration, via *Client) (conn net.Conn, winner dialCandidate, rest []dialCandidate, failed []dialFailure) {
+	type result struct {
+		i    int
+		conn net.Conn
+		err  error
+	}
+	if len(cands) == 0 {
+		return
+	}
+	results := make(chan result, len(cands))
+	delay := happyEyeballsDelay
+	// 每次尝试都会启动一个本地进程，依次进行
+	if server.ProxyCommand != "" || server.UsesSSM() {
+		delay = timeout
+	}
+	finished := make([]bool, len(cands))
+	started, pending := 0, 0
+	start := func() {
+		i := started
+		started++
+		pending++
+		go func() {
+			c, err := d.dialAt(server, cands[i], timeout, via)
+			results <- result{i, c, err}
+		}()
+	}
+	start()
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	for pending > 0 {
+		select {
+		case r := <-results:
+			pending--
+			finished[r.i] = true
+			if r.err != nil {
+				failed = append(failed, dialFailure{cands[r.i], r.err})
+				if started < len(cands) {
+					start()
+					timer.Reset(delay)
+				}
+				continue
+			}
+			// 其余仍在进行的尝试连上后直接关闭
+			go func(n int) {
+				for range n {
+					if r := <-results; r.conn != nil {
+						_ = r.conn.Close()
+					}
+				}
+			}(pending)
+			for i, cand := range cands {
+				if !finished[i] {
+					rest = append(rest, cand)
+				}
+			}
+			return r.conn, cands[r.i], rest, failed
+		case <-timer.C:
+			if started < len(cands) {
+				d.Logf(1, "%s has not connected after %s, trying the next address too", cands[started-1], delay)
+				start()
+				timer.Reset(delay)
+			}
+		}
+	}
+	return
+}
+
+// lastAddressMu serializes read-modify-write of the state file within the
+// process (fleet operations dial in parallel).
+var lastAddressMu sync.Mutex
+
+// lastAddress is the on-disk form of a dialCandidate.
+type lastAddress struct {
+	Address string `json:"address"`
+	IP      string `json:"ip,omitempty"`
+}
+
+func lastAddressPath() (string, error) {
+	dir, err := StateDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, lastAddressFile), nil
+}
+
+func readLastAddresses() map[string]dialCandidate {
+	saved := map[string]lastAddress{}
+	if file, err := lastAddressPath(); err == nil {
+		if data, err := os.ReadFile(file); err == nil {
+			_ = json.Unmarshal(data, &saved)
+		}
+	}
+	cands := make(map[string]dialCandidate, len(saved))
+	for alias, a := range saved {
+		cands[alias] = dialCandidate{address: a.Address, ip: a.IP}
+	}
+	return cands
+}
+
+// rememberAddress records the candidate that connected to server, if it had
+// others to choose from.
+func rememberAddress(server *Server, cand dialCandidate, choices int) {
+	if server.Alias == "" || choices < 2 {
+		return
+	}
+	lastAddressMu.Lock()
+	defer lastAddressMu.Unlock()
+	cands := readLastAddresses()
+	if cands[server.Alias] == cand {
+		return
+	}
+	cands[server.Alias] = cand
+	saved := make(map[string]lastAddress, len(cands))
+	for alias, c := range cands {
+		saved[alias] = lastAddress{Address: c.address, IP: c.ip}
+	}
+	file, err := lastAddressPath()
+	if err != nil {
+		return
+	}
+	data, err := json.MarshalIndent(saved, "", "  ")
+	if err != nil {
+		return
+	}
+	tmp := file + ".tmp"
+	if err = os.WriteFile(tmp, data, 0o600); err == nil {
+		_ = os.Rename(tmp, file)
+	}
+}
+
+// allFailed reports every candidate's failure for server. The error has
+// the kind of the failures when they all share one.
+func allFailed(server *Server, failures []string, kinds []ErrorKind) error {
+	kind := ErrorOther
+	if len(kinds) > 0 && !slices.ContainsFunc(kinds, func(k ErrorKind) bool { return k != kinds[0] }) {
+		kind = kinds[0]
+	}
+	return &Error{Kind: kind, Err: fmt.Errorf("failed to connect to server %s, every address failed:\n  %s",
+		server.Alias, strings.Join(failures, "\n  "))}
+}
+
+// isFinal reports whether err rules out the other addresses too: the server
+// rejecting our credentials or a host key we refuse, as opposed to a
+// transport failure worth retrying elsewhere.
+func isFinal(err error) bool {
+	var mismatch *HostKeyMismatchError
+	var changed *HostKeyChangedError
+	var unknown *HostKeyUnknownError
+	var sshfp *SSHFPMismatchError
+	return errors.As(err, &mismatch) || errors.As(err, &changed) || errors.As(err, &unknown) || errors.As(err, &sshfp) || isAuthFailure(err)
+}
+
+// isAuthFailure reports whether err is the server rejecting every auth
+// method we offered.
+func isAuthFailure(err error) bool {
+	return strings.Contains(err.Error(), "unable to authenticate")
+}
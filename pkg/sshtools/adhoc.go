@@ -0,0 +1,366 @@
+package sshtools
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"os/user"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// defaultIdentityFiles are tried, in order, for servers without credentials.
+var defaultIdentityFiles = []string{"id_ed25519", "id_ecdsa", "id_rsa"}
+
+// ParseTarget builds an ad-hoc server from user@host[:port]. The user
+// defaults to the local username and the port to 22; IPv6 hosts are
+// written in brackets.
+func ParseTarget(target string) (server *Server, err error) {
+	userName, hostPort, found := strings.Cut(target, "@")
+	if !found {
+		userName, hostPort = "", target
+	}
+	if userName == "" {
+		usr, errs := user.Current()
+		if errs != nil {
+			err = fmt.Errorf("failed to get local username: %v", errs)
+			return
+		}
+		userName = usr.Username
+	}
+
+	host, port := hostPort, 22
+	if h, p, errs := net.SplitHostPort(hostPort); errs == nil {
+		host = h
+		if port, err = strconv.Atoi(p); err != nil || port < 1 || port > 65535 {
+			err = fmt.Errorf("invalid port in %q", target)
+			return
+		}
+	} else if strings.HasPrefix(hostPort, "[") && strings.HasSuffix(hostPort, "]") {
+		host = hostPort[1 : len(hostPort)-1]
+	}
+	if host == "" || strings.ContainsAny(host, "/ ") {
+		err = fmt.Errorf("invalid target %q, expected [user@]host[:port]", target)
+		return
+	}
+	return &Server{Alias: target, Address: host, Port: port, User: userName}, nil
+}
+
+// defaultIdentities collects keys from ssh-agent and the default identity
+// files. Encrypted or unreadable key files are skipped.
+func (d *Dialer) defaultIdentities(trace *authTrace) (identities []identity) {
+	identities, err := d.agentIdentities(trace)
+	if err != nil && !agentUnavailable(err) {
+		d.Logf(1, "ssh-agent unavailable: %v", err)
+	}
+	return append(identities, d.defaultKeyFiles()...)
+}
+
+// defaultKeyFiles loads the default identity files that exist, with their
+// certificates.
+func (d *Dialer) defaultKeyFiles() (identities []identity) {
+	homeDir, err := getHomeDir()
+	if err != nil {
+		return
+	}
+	for _, name := range defaultIdentityFiles {
+		keyPath := filepath.Join(homeDir, ".ssh", name)
+		key, err := os.ReadFile(keyPath)
+		if err != nil {
+			continue
+		}
+		signer, err := ssh.ParsePrivateKey(key)
+		if err != nil {
+			d.Logf(1, "skipping %s: %v", keyPath, err)
+			continue
+		}
+		if _, errs := os.Stat(keyPath + "-cert.pub"); errs == nil {
+			if certSigner, errs := certSigner(signer, keyPath+"-cert.pub"); errs != nil {
+				d.Logf(1, "skipping certificate: %v", errs)
+			} else {
+				identities = append(identities, identity{certSigner, keyPath + "-cert.pub"})
+			}
+		}
+		identities = append(identities, identity{signer, keyPath})
+	}
+	return
+}
+
+// AppendServer adds server to the servers list in filename, creating the
+// file if needed. Other content of the file is kept as is.
+func AppendServer(filename string, server *Server) (err error) {
+	doc := &jsonObject{}
+	data, err := ReadConfigFile(filename)
+	switch {
+	case errors.Is(err, os.ErrNotExist):
+		// 默认配置目录可能还不存在
+		if err = os.MkdirAll(filepath.Dir(filename), 0o700); err != nil {
+			return
+		}
+	case err != nil:
+		return
+	default:
+		if err = json.Unmarshal(data, &doc); err != nil {
+			err = fmt.Errorf("failed to parse %s: %v", filename, err)
+			return
+		}
+	}
+
+	var servers []json.RawMessage
+	if raw := doc.get("servers"); raw != nil {
+		if err = json.Unmarshal(raw, &servers); err != nil {
+			err = fmt.Errorf("failed to parse servers in %s: %v", filename, err)
+			return
+		}
+	}
+	entry, err := json.Marshal(server)
+	if err != nil {
+		return
+	}
+	if err = doc.set("servers", append(servers, entry)); err != nil {
+		return
+	}
+	return writeConfigDoc(filename, doc)
+}
+
+// SetServerField sets key to value in the entry for alias in filename,
+// keeping the rest of the file as is.
+func SetServerField(filename, alias, key string, value any) (err error) {
+	doc, servers, i, err := readServerEntry(filename, alias)
+	if err != nil {
+		return
+	}
+	if err = servers[i].set(key, value); err != nil {
+		return
+	}
+	return writeServers(filename, doc, servers)
+}
+
+// SetServersFields sets fields in the entries of all of aliases in
+// filename, in one atomic write: either every entry changes or none does.
+func SetServersFields(filename string, aliases []string, fields map[string]any) (err error) {
+	doc, servers, err := readConfigDoc(filename)
+	if err != nil {
+		return
+	}
+	for _, alias := range aliases {
+		found := false
+		for _, entry := range servers {
+			var name string
+			if json.Unmarshal(entry.get("alias"), &name) != nil || !AliasEqual(name, alias) {
+				continue
+			}
+			for key, value := range fields {
+				if err = entry.set(key, value); err != nil {
+					return
+				}
+			}
+			found = true
+			break
+		}
+		if !found {
+			return fmt.Errorf("alias %q not found in %s", alias, filename)
+		}
+	}
+	return writeServers(filename, doc, servers)
+}
+
+// ServerEntry returns the entry for alias in filename as it is written.
+func ServerEntry(filename, alias string) (entry []byte, err error) {
+	_, servers, i, err := readServerEntry(filename, alias)
+	if err != nil {
+		return
+	}
+	return json.MarshalIndent(servers[i], "", "  ")
+}
+
+// ReplaceServer replaces the entry for alias in filename with entry.
+func ReplaceServer(filename, alias string, entry []byte) (err error) {
+	doc, servers, i, err := readServerEntry(filename, alias)
+	if err != nil {
+		return
+	}
+	replacement := &jsonObject{}
+	if err = json.Unmarshal(entry, replacement); err != nil {
+		return jsonError(entry, err)
+	}
+	servers[i] = replacement
+	return writeServers(filename, doc, servers)
+}
+
+// RemoveServer deletes the entry for alias from filename.
+func RemoveServer(filename, alias string) (err error) {
+	doc, servers, i, err := readServerEntry(filename, alias)
+	if err != nil {
+		return
+	}
+	return writeServers(filename, doc, append(servers[:i], servers[i+1:]...))
+}
+
+// readConfigDoc decodes filename, keeping the entries of its servers list
+// as written and the keys of both in their order.
+func readConfigDoc(filename string) (doc *jsonObject, servers []*jsonObject, err error) {
+	data, err := ReadConfigFile(filename)
+	if err != nil {
+		return
+	}
+	if err = json.Unmarshal(data, &doc); err == nil {
+		err = json.Unmarshal(doc.get("servers"), &servers)
+	}
+	if err != nil {
+		err = fmt.Errorf("failed to parse %s: %v", filename, err)
+	}
+	return
+}
+
+// readServerEntry decodes filename and finds the entry for alias in its
+// servers list.
+func readServerEntry(filename, alias string) (doc *jsonObject, servers []*jsonObject, index int, err error) {
+	if doc, servers, err = readConfigDoc(filename); err != nil {
+		return
+	}
+	for i, entry := range servers {
+		var name string
+		if json.Unmarshal(entry.get("alias"), &name) == nil && AliasEqual(name, alias) {
+			return doc, servers, i, nil
+		}
+	}
+	err = fmt.Errorf("alias %q not found in %s", alias, filename)
+	return
+}
+
+// writeServers writes doc to filename with servers as its servers list.
+func writeServers(filename string, doc *jsonObject, servers []*jsonObject) (err error) {
+	if err = doc.set("servers", servers); err != nil {
+		return
+	}
+	return writeConfigDoc(filename, doc)
+}
+
+// writeConfigDoc writes doc to filename indented, in the file's format and
+// keeping its permissions. The result must validate; the previous version
+// is kept in filename.bak and the file is replaced atomically. Comments in
+// YAML and TOML files are lost, and TOML keys are sorted.
+func writeConfigDoc(filename string, doc *jsonObject) (err error) {
+	data, err := json.MarshalIndent(doc, "", "  ")
+	if err != nil {
+		return
+	}
+	data = append(data, '\n')
+	// 写入前校验，不把有错误的配置写回文件
+	merged, _ := mergeIncludes(filename, data)
+	if _, problems, errs := ParseConfig(merged); errs != nil {
+		return fmt.Errorf("refusing to write %s: %v", filename, errs)
+	} else if problems = errorsOnly(problems); len(problems) > 0 {
+		return &ValidationError{File: filename, Problems: problems}
+	}
+	if data, err = ConvertConfig(data, FormatJSON, ConfigFormat(filename)); err != nil {
+		return
+	}
+
+	perm := os.FileMode(0o600)
+	if old, errs := os.ReadFile(filename); errs == nil {
+		if info, errs := os.Stat(filename); errs == nil {
+			perm = info.Mode().Perm()
+		}
+		if err = os.WriteFile(filename+".bak", old, perm); err != nil {
+			return fmt.Errorf("failed to back up %s: %v", filename, err)
+		}
+	}
+	tmp := filename + ".tmp"
+	if err = os.WriteFile(tmp, data, perm); err != nil {
+		return
+	}
+	return os.Rename(tmp, filename)
+}
+
+// jsonObject is a JSON object whose keys keep the order they were written
+// in, so rewriting a config file only changes what was edited.
+type jsonObject struct {
+	keys   []string
+	values map[string]json.RawMessage
+}
+
+// get returns the value of key as written, or nil.
+func (o *jsonObject) get(key string) json.RawMessage {
+	if o == nil {
+		return nil
+	}
+	return o.values[key]
+}
+
+// set sets key to value encoded, adding it at the end if it is new.
+func (o *jsonObject) set(key string, value any) (err error) {
+	raw, err := json.Marshal(value)
+	if err != nil {
+		return
+	}
+	o.setRaw(key, raw)
+	return
+}
+
+func (o *jsonObject) setRaw(key string, raw json.RawMessage) {
+	if o.values == nil {
+		o.values = map[string]json.RawMessage{}
+	}
+	if _, ok := o.values[key]; !ok {
+		o.keys = append(o.keys, key)
+	}
+	o.values[key] = raw
+}
+
+// delete removes key.
+func (o *jsonObject) delete(key string) {
+	if _, ok := o.values[key]; ok {
+		delete(o.values, key)
+		o.keys = slices.DeleteFunc(o.keys, func(k string) bool { return k == key })
+	}
+}
+
+func (o *jsonObject) UnmarshalJSON(data []byte) (err error) {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	if token, errs := dec.Token(); errs != nil || token != json.Delim('{') {
+		return fmt.Errorf("expected a JSON object")
+	}
+	*o = jsonObject{}
+	for dec.More() {
+		token, errs := dec.Token()
+		if errs != nil {
+			return errs
+		}
+		var value json.RawMessage
+		if err = dec.Decode(&value); err != nil {
+			return
+		}
+		// 键重复时与 map 一样取最后的值
+		o.setRaw(token.(string), value)
+	}
+	_, err = dec.Token()
+	return
+}
+
+func (o *jsonObject) MarshalJSON() ([]byte, error) {
+	var b bytes.Buffer
+	b.WriteByte('{')
+	for i, key := range o.keys {
+		if i > 0 {
+			b.WriteByte(',')
+		}
+		name, err := json.Marshal(key)
+		if err != nil {
+			return nil, err
+		}
+		b.Write(name)
+		b.WriteByte(':')
+		b.Write(o.values[key])
+	}
+	b.WriteByte('}')
+	return b.Bytes(), nil
+}
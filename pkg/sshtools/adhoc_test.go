@@ -0,0 +1,134 @@
+package sshtools
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// orderedConfig is a config whose keys are in no particular order.
+const orderedConfig = `{
+  "servers": [
+    {
+      "user": "deploy",
+      "port": 2200,
+      "alias": "web1",
+      "address": "10.0.0.1",
+      "tags": [
+        "web"
+      ]
+    },
+    {
+      "address": "10.0.0.2",
+      "alias": "db1",
+      "user": "postgres"
+    }
+  ],
+  "notify_bell": true,
+  "notify_threshold": "1m"
+}
+`
+
+// writeOrderedConfig writes orderedConfig to a file in a new directory.
+func writeOrderedConfig(t *testing.T, name, data string) string {
+	t.Helper()
+	filename := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(filename, []byte(data), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return filename
+}
+
+func readFile(t *testing.T, filename string) string {
+	t.Helper()
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(data)
+}
+
+func TestSetServerFieldKeepsOrder(t *testing.T) {
+	filename := writeOrderedConfig(t, "config.json", orderedConfig)
+	if err := SetServerField(filename, "WEB1", "address", "10.0.0.9"); err != nil {
+		t.Fatal(err)
+	}
+	// 只有修改的值不同，新的键加在最后
+	want := strings.Replace(orderedConfig, "10.0.0.1", "10.0.0.9", 1)
+	if got := readFile(t, filename); got != want {
+		t.Errorf("wrote\n%s\nwant\n%s", got, want)
+	}
+
+	if err := SetServerField(filename, "db1", "port", 5432); err != nil {
+		t.Fatal(err)
+	}
+	want = strings.Replace(want, `"user": "postgres"`, `"user": "postgres",
+      "port": 5432`, 1)
+	if got := readFile(t, filename); got != want {
+		t.Errorf("wrote\n%s\nwant\n%s", got, want)
+	}
+}
+
+func TestRemoveServerKeepsOrder(t *testing.T) {
+	filename := writeOrderedConfig(t, "config.json", orderedConfig)
+	if err := RemoveServer(filename, "web1"); err != nil {
+		t.Fatal(err)
+	}
+	want := `{
+  "servers": [
+    {
+      "address": "10.0.0.2",
+      "alias": "db1",
+      "user": "postgres"
+    }
+  ],
+  "notify_bell": true,
+  "notify_threshold": "1m"
+}
+`
+	if got := readFile(t, filename); got != want {
+		t.Errorf("wrote\n%s\nwant\n%s", got, want)
+	}
+}
+
+func TestReplaceServerKeepsOrder(t *testing.T) {
+	filename := writeOrderedConfig(t, "config.json", orderedConfig)
+	entry, err := ServerEntry(filename, "db1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "{\n  \"address\": \"10.0.0.2\",\n  \"alias\": \"db1\",\n  \"user\": \"postgres\"\n}"; string(entry) != want {
+		t.Errorf("ServerEntry = %s, want %s", entry, want)
+	}
+
+	// 编辑后的条目按写入的顺序保存
+	entry = []byte(`{"alias": "db1", "user": "postgres", "address": "10.0.0.3"}`)
+	if err = ReplaceServer(filename, "db1", entry); err != nil {
+		t.Fatal(err)
+	}
+	want := strings.Replace(orderedConfig, `      "address": "10.0.0.2",
+      "alias": "db1",
+      "user": "postgres"`, `      "alias": "db1",
+      "user": "postgres",
+      "address": "10.0.0.3"`, 1)
+	if got := readFile(t, filename); got != want {
+		t.Errorf("wrote\n%s\nwant\n%s", got, want)
+	}
+}
+
+func TestSetServerFieldKeepsYAMLOrder(t *testing.T) {
+	config := `servers:
+  - user: deploy
+    alias: web1
+    address: 10.0.0.1
+notify_threshold: 1m
+`
+	filename := writeOrderedConfig(t, "config.yaml", config)
+	if err := SetServerField(filename, "web1", "address", "10.0.0.9"); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := readFile(t, filename), strings.Replace(config, "10.0.0.1", "10.0.0.9", 1); got != want {
+		t.Errorf("wrote\n%s\nwant\n%s", got, want)
+	}
+}
@@ -0,0 +1,67 @@
+package sshtools
+
+import (
+	"errors"
+	"fmt"
+
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+)
+
+// agentIdentities returns the keys held by ssh-agent (or Pageant on
+// Windows). The agent connection is left open on trace for signing.
+func (d *Dialer) agentIdentities(trace *authTrace) (identities []identity, err error) {
+	signers, err := agentSigners(trace)
+	if err != nil {
+		return
+	}
+	for _, signer := range signers {
+		identities = append(identities, identity{signer, "ssh-agent"})
+	}
+	d.Logf(1, "ssh-agent offers %d key(s)", len(signers))
+	return
+}
+
+// agentSigners returns the keys held by ssh-agent, leaving the connection
+// open on trace for signing.
+func agentSigners(trace *authTrace) (signers []ssh.Signer, err error) {
+	conn, err := dialAgent()
+	if err != nil {
+		return
+	}
+	if signers, err = agent.NewClient(conn).Signers(); err != nil {
+		_ = conn.Close()
+		return nil, fmt.Errorf("failed to list agent keys: %v", err)
+	}
+	trace.agents = append(trace.agents, conn)
+	return
+}
+
+// agentUnavailable reports whether err only means no agent is configured,
+// which is not worth logging.
+func agentUnavailable(err error) bool {
+	return errors.Is(err, errNoAgent)
+}
+
+// requestAgentForwarding lets the programs of session use the local
+// ssh-agent. Agent channels opened by the server are answered from the
+// first call on; the agent connection is closed with the client.
+func (c *Client) requestAgentForwarding(session *ssh.Session) error {
+	c.agentOnce.Do(func() {
+		conn, err := dialAgent()
+		if err != nil {
+			c.agentErr = fmt.Errorf("no local agent: %v", err)
+			return
+		}
+		if err = agent.ForwardToAgent(c.Client, agent.NewClient(conn)); err != nil {
+			_ = conn.Close()
+			c.agentErr = err
+			return
+		}
+		c.agentConn = conn
+	})
+	if c.agentErr != nil {
+		return c.agentErr
+	}
+	return agent.RequestAgentForwarding(session)
+}
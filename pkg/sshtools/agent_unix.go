@@ -0,0 +1,21 @@
+//go:build !windows
+
+package sshtools
+
+import (
+	"errors"
+	"io"
+	"net"
+	"os"
+)
+
+var errNoAgent = errors.New("SSH_AUTH_SOCK is not set")
+
+// dialAgent connects to the ssh-agent socket named by SSH_AUTH_SOCK.
+func dialAgent() (io.ReadWriteCloser, error) {
+	sock := os.Getenv("SSH_AUTH_SOCK")
+	if sock == "" {
+		return nil, errNoAgent
+	}
+	return net.Dial("unix", sock)
+}
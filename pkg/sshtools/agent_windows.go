@@ -0,0 +1,152 @@
+package sshtools
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"runtime"
+	"strings"
+	"unsafe"
+
+	"golang.org/x/sys/windows"
+)
+
+var errNoAgent = errors.New("neither the OpenSSH agent nor Pageant is running")
+
+// openSSHAgentPipe is where the Windows OpenSSH agent service listens.
+const openSSHAgentPipe = `\\.\pipe\openssh-ssh-agent`
+
+const (
+	// pageantMagic identifies agent requests sent to Pageant by WM_COPYDATA.
+	pageantMagic  = 0x804e50ba
+	pageantMaxLen = 8192
+	wmCopyData    = 0x004a
+)
+
+var (
+	user32      = windows.NewLazySystemDLL("user32.dll")
+	findWindow  = user32.NewProc("FindWindowW")
+	sendMessage = user32.NewProc("SendMessageW")
+)
+
+// dialAgent connects to the agent named by SSH_AUTH_SOCK, or else the
+// OpenSSH agent service, or else Pageant.
+func dialAgent() (io.ReadWriteCloser, error) {
+	if sock := os.Getenv("SSH_AUTH_SOCK"); sock != "" {
+		if strings.HasPrefix(sock, `\\.\pipe\`) {
+			return os.OpenFile(sock, os.O_RDWR, 0)
+		}
+		return net.Dial("unix", sock)
+	}
+	if pipe, err := os.OpenFile(openSSHAgentPipe, os.O_RDWR, 0); err == nil {
+		return pipe, nil
+	}
+	if pageantWindow() != 0 {
+		return &pageantConn{}, nil
+	}
+	return nil, errNoAgent
+}
+
+// pageantWindow returns Pageant's window, or 0 when it is not running.
+func pageantWindow() uintptr {
+	if findWindow.Find() != nil {
+		return 0
+	}
+	name, _ := windows.UTF16PtrFromString("Pageant")
+	hwnd, _, _ := findWindow.Call(uintptr(unsafe.Pointer(name)), uintptr(unsafe.Pointer(name)))
+	return hwnd
+}
+
+// pageantConn speaks the agent protocol to Pageant, which takes each
+// request through shared memory announced with WM_COPYDATA.
+type pageantConn struct {
+	request []byte
+	reply   []byte
+}
+
+func (c *pageantConn) Write(p []byte) (int, error) {
+	c.request = append(c.request, p...)
+	if len(c.request) < 4 || len(c.request) < 4+int(binary.BigEndian.Uint32(c.request)) {
+		return len(p), nil
+	}
+	reply, err := pageantQuery(c.request)
+	c.request = nil
+	if err != nil {
+		return 0, err
+	}
+	c.reply = append(c.reply, reply...)
+	return len(p), nil
+}
+
+func (c *pageantConn) Read(p []byte) (n int, err error) {
+	if len(c.reply) == 0 {
+		return 0, io.EOF
+	}
+	n = copy(p, c.reply)
+	c.reply = c.reply[n:]
+	return
+}
+
+func (c *pageantConn) Close() error {
+	return nil
+}
+
+// copyData is the COPYDATASTRUCT passed with WM_COPYDATA.
+type copyData struct {
+	data uintptr
+	size uint32
+	ptr  uintptr
+}
+
+// pageantQuery sends one framed agent request to Pageant and returns its
+// framed reply.
+func pageantQuery(request []byte) (reply []byte, err error) {
+	if len(request) > pageantMaxLen {
+		return nil, fmt.Errorf("agent request too long for Pageant")
+	}
+	hwnd := pageantWindow()
+	if hwnd == 0 {
+		return nil, errNoAgent
+	}
+	runtime.LockOSThread()
+	defer runtime.UnlockOSThread()
+	mapName := fmt.Sprintf("PageantRequest%08x", windows.GetCurrentThreadId())
+	name, err := windows.UTF16PtrFromString(mapName)
+	if err != nil {
+		return
+	}
+	mapping, err := windows.CreateFileMapping(windows.InvalidHandle, nil, windows.PAGE_READWRITE, 0, pageantMaxLen, name)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create Pageant request: %v", err)
+	}
+	defer func() {
+		_ = windows.CloseHandle(mapping)
+	}()
+	view, err := windows.MapViewOfFile(mapping, windows.FILE_MAP_WRITE, 0, 0, 0)
+	if err != nil {
+		return nil, fmt.Errorf("failed to map Pageant request: %v", err)
+	}
+	defer func() {
+		_ = windows.UnmapViewOfFile(view)
+	}()
+	// view is memory outside the Go heap, so converting it is safe.
+	shared := unsafe.Slice((*byte)(*(*unsafe.Pointer)(unsafe.Pointer(&view))), pageantMaxLen)
+	copy(shared, request)
+
+	// Pageant opens the mapping by its ANSI name.
+	ansiName := append([]byte(mapName), 0)
+	cds := copyData{data: pageantMagic, size: uint32(len(ansiName)), ptr: uintptr(unsafe.Pointer(&ansiName[0]))}
+	r, _, _ := sendMessage.Call(hwnd, wmCopyData, 0, uintptr(unsafe.Pointer(&cds)))
+	runtime.KeepAlive(ansiName)
+	if r == 0 {
+		return nil, fmt.Errorf("Pageant refused the request")
+	}
+	length := int(binary.BigEndian.Uint32(shared))
+	if length+4 > pageantMaxLen {
+		return nil, fmt.Errorf("reply from Pageant too long")
+	}
+	return append([]byte{}, shared[:4+length]...), nil
+}
@@ -0,0 +1,87 @@
+package sshtools
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// Algorithms restricts what is offered during the key exchange. Empty lists
+// keep the x/crypto/ssh defaults, or with Legacy every algorithm it
+// implements.
+type Algorithms struct {
+	HostKeys     []string `json:"host_key_algorithms,omitempty"`
+	KeyExchanges []string `json:"kex_algorithms,omitempty"`
+	Ciphers      []string `json:"ciphers,omitempty"`
+	MACs         []string `json:"macs,omitempty"`
+	// Legacy also offers the insecure algorithms of old appliances (SHA-1
+	// key exchanges, ssh-rsa and ssh-dss host keys, CBC ciphers), after the
+	// secure ones.
+	Legacy bool `json:"legacy_algorithms,omitempty"`
+}
+
+// knownAlgorithms returns every name x/crypto/ssh implements, including the
+// insecure ones that it does not offer by default.
+func knownAlgorithms() Algorithms {
+	supported, insecure := ssh.SupportedAlgorithms(), ssh.InsecureAlgorithms()
+	return Algorithms{
+		HostKeys:     append(supported.HostKeys, insecure.HostKeys...),
+		KeyExchanges: append(supported.KeyExchanges, insecure.KeyExchanges...),
+		Ciphers:      append(supported.Ciphers, insecure.Ciphers...),
+		MACs:         append(supported.MACs, insecure.MACs...),
+	}
+}
+
+// check returns a message for every algorithm name that x/crypto/ssh does
+// not implement, with the names it does.
+func (a Algorithms) check() (messages []string) {
+	known := knownAlgorithms()
+	for _, field := range []struct {
+		key          string
+		names, known []string
+	}{
+		{"host_key_algorithms", a.HostKeys, known.HostKeys},
+		{"kex_algorithms", a.KeyExchanges, known.KeyExchanges},
+		{"ciphers", a.Ciphers, known.Ciphers},
+		{"macs", a.MACs, known.MACs},
+	} {
+		for _, name := range field.names {
+			if !slices.Contains(field.known, name) {
+				messages = append(messages, fmt.Sprintf("%q has unsupported algorithm %q, supported: %s",
+					field.key, name, strings.Join(field.known, ", ")))
+			}
+		}
+	}
+	return
+}
+
+// withDefaults fills the empty lists of a from defaults.
+func (a Algorithms) withDefaults(defaults Algorithms) Algorithms {
+	a.Legacy = a.Legacy || defaults.Legacy
+	if len(a.HostKeys) == 0 {
+		a.HostKeys = defaults.HostKeys
+	}
+	if len(a.KeyExchanges) == 0 {
+		a.KeyExchanges = defaults.KeyExchanges
+	}
+	if len(a.Ciphers) == 0 {
+		a.Ciphers = defaults.Ciphers
+	}
+	if len(a.MACs) == 0 {
+		a.MACs = defaults.MACs
+	}
+	return a
+}
+
+// apply sets the algorithms on sshConfig.
+func (a Algorithms) apply(sshConfig *ssh.ClientConfig) {
+	if a.Legacy {
+		a = a.withDefaults(knownAlgorithms())
+	}
+	sshConfig.HostKeyAlgorithms = a.HostKeys
+	sshConfig.KeyExchanges = a.KeyExchanges
+	sshConfig.Ciphers = a.Ciphers
+	sshConfig.MACs = a.MACs
+}
@@ -0,0 +1,444 @@
+package sshtools
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"os/user"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// ansibleAll and ansibleUngrouped are the groups every Ansible inventory
+// has implicitly; they are not turned into tags.
+const (
+	ansibleAll       = "all"
+	ansibleUngrouped = "ungrouped"
+)
+
+// ansibleGroup is a group of an Ansible inventory.
+type ansibleGroup struct {
+	hosts    []string
+	vars     map[string]string
+	children []string
+}
+
+// ansibleInventory is an Ansible inventory read from an INI or YAML file.
+type ansibleInventory struct {
+	groups   map[string]*ansibleGroup
+	hostVars map[string]map[string]string
+}
+
+func (inv *ansibleInventory) group(name string) *ansibleGroup {
+	g, ok := inv.groups[name]
+	if !ok {
+		g = &ansibleGroup{vars: map[string]string{}}
+		inv.groups[name] = g
+	}
+	return g
+}
+
+// addHost adds the hosts of pattern, like web[01:03].example.com:2222, to
+// group with vars.
+func (inv *ansibleInventory) addHost(group, pattern string, vars map[string]string) (err error) {
+	// 主机名后可以带 :端口
+	if host, port, ok := strings.Cut(pattern, ":"); ok && !strings.Contains(port, ":") && !strings.Contains(port, "]") {
+		if _, errs := strconv.Atoi(port); errs == nil {
+			pattern = host
+			if _, set := vars["ansible_port"]; !set {
+				vars["ansible_port"] = port
+			}
+		}
+	}
+	hosts, err := expandAnsibleHost(pattern)
+	if err != nil {
+		return
+	}
+	g := inv.group(group)
+	for _, host := range hosts {
+		g.hosts = append(g.hosts, host)
+		if inv.hostVars[host] == nil {
+			inv.hostVars[host] = map[string]string{}
+		}
+		for name, value := range vars {
+			inv.hostVars[host][name] = value
+		}
+	}
+	return
+}
+
+// ImportAnsible returns a server for every host of the Ansible inventory
+// filename, in the INI or the YAML format. The alias is the inventory
+// name; ansible_host, ansible_user, ansible_port and
+// ansible_ssh_private_key_file, from the host or its groups, give the
+// address, user, port and key, and the groups become tags. Notes point out
+// hosts that were skipped and settings that were not imported.
+func ImportAnsible(filename string) (servers []Server, notes []string, err error) {
+	if filename, err = ExpandPath(filename); err != nil {
+		return
+	}
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return
+	}
+	inv := &ansibleInventory{groups: map[string]*ansibleGroup{}, hostVars: map[string]map[string]string{}}
+	switch ext := strings.ToLower(filepath.Ext(filename)); {
+	case ext == ".yml" || ext == ".yaml" || ext == ".json" || strings.HasPrefix(strings.TrimSpace(string(data)), "---"):
+		err = inv.parseYAML(data)
+	default:
+		err = inv.parseINI(string(data))
+	}
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to parse %s: %v", filename, err)
+	}
+
+	hosts := make([]string, 0, len(inv.hostVars))
+	for host := range inv.hostVars {
+		hosts = append(hosts, host)
+	}
+	sort.Strings(hosts)
+	for _, host := range hosts {
+		server, hostNotes := inv.server(host)
+		for _, note := range hostNotes {
+			notes = append(notes, fmt.Sprintf("%s: %s", host, note))
+		}
+		if server != nil {
+			servers = append(servers, *server)
+		}
+	}
+	return
+}
+
+// server converts one host; server is nil when it was skipped.
+func (inv *ansibleInventory) server(host string) (server *Server, notes []string) {
+	groups := inv.hostGroups(host)
+	// 变量优先级与 Ansible 相同：all < 父组 < 子组 < 主机
+	vars := map[string]string{}
+	for _, group := range groups {
+		for name, value := range inv.groups[group].vars {
+			vars[name] = value
+		}
+	}
+	for name, value := range inv.hostVars[host] {
+		vars[name] = value
+	}
+	get := func(names ...string) string {
+		for _, name := range names {
+			if value := vars[name]; value != "" {
+				return value
+			}
+		}
+		return ""
+	}
+
+	switch connection := get("ansible_connection"); connection {
+	case "", "ssh", "paramiko", "smart":
+	default:
+		return nil, []string{fmt.Sprintf("skipped, uses the %s connection rather than ssh", connection)}
+	}
+	server = &Server{Alias: host, Address: host, Port: defaultPort, User: get("ansible_user", "ansible_ssh_user")}
+	if address := get("ansible_host", "ansible_ssh_host"); address != "" {
+		server.Address = address
+	}
+	if port := get("ansible_port", "ansible_ssh_port"); port != "" {
+		n, err := strconv.Atoi(port)
+		if err != nil || n <= 0 {
+			return nil, []string{fmt.Sprintf("skipped, invalid port %q", port)}
+		}
+		server.Port = n
+	}
+	if key := get("ansible_ssh_private_key_file", "ansible_private_key_file"); key != "" {
+		server.PrivateKey, server.UseKey = key, true
+	}
+	for _, field := range []*string{&server.Address, &server.User, &server.PrivateKey} {
+		if strings.Contains(*field, "{{") {
+			return nil, []string{fmt.Sprintf("skipped, %s is a Jinja template", *field)}
+		}
+	}
+	if get("ansible_password", "ansible_ssh_pass") != "" {
+		notes = append(notes, "the password was not imported, set it with sshtools secret set")
+	}
+	if get("ansible_ssh_common_args", "ansible_ssh_extra_args") != "" {
+		notes = append(notes, "ansible_ssh_common_args and ansible_ssh_extra_args were not imported")
+	}
+	for _, group := range groups {
+		if group != ansibleAll && group != ansibleUngrouped {
+			server.Tags = append(server.Tags, group)
+		}
+	}
+	if server.User == "" {
+		// 与 Ansible 相同，没有用户名时使用本机用户名
+		local, err := user.Current()
+		if err != nil {
+			return nil, []string{fmt.Sprintf("skipped, no ansible_user: %v", err)}
+		}
+		server.User = local.Username
+		notes = append(notes, fmt.Sprintf("no ansible_user, using %s", server.User))
+	}
+	return
+}
+
+// hostGroups returns the groups host belongs to, directly or through their
+// children, parents before children and by name within the same depth.
+func (inv *ansibleInventory) hostGroups(host string) (groups []string) {
+	parents := map[string][]string{}
+	for name, g := range inv.groups {
+		for _, child := range g.children {
+			parents[child] = append(parents[child], name)
+		}
+	}
+	depths := map[string]int{}
+	var depth func(name string, seen map[string]bool) int
+	depth = func(name string, seen map[string]bool) int {
+		if d, ok := depths[name]; ok {
+			return d
+		}
+		if name == ansibleAll || seen[name] {
+			return 0
+		}
+		seen[name] = true
+		d := 1
+		for _, parent := range parents[name] {
+			d = max(d, depth(parent, seen)+1)
+		}
+		depths[name] = d
+		return d
+	}
+
+	member := map[string]bool{ansibleAll: true}
+	var add func(name string)
+	add = func(name string) {
+		if member[name] {
+			return
+		}
+		member[name] = true
+		for _, parent := range parents[name] {
+			add(parent)
+		}
+	}
+	for name, g := range inv.groups {
+		for _, h := range g.hosts {
+			if h == host {
+				add(name)
+			}
+		}
+	}
+	for name := range member {
+		if _, ok := inv.groups[name]; ok {
+			groups = append(groups, name)
+		}
+	}
+	sort.Slice(groups, func(i, j int) bool {
+		di, dj := depth(groups[i], map[string]bool{}), depth(groups[j], map[string]bool{})
+		if di != dj {
+			return di < dj
+		}
+		return groups[i] < groups[j]
+	})
+	return
+}
+
+// parseINI reads an inventory in the INI format: [group] sections of
+// "host var=value" lines, [group:vars] sections of "var=value" lines and
+// [group:children] sections of group names.
+func (inv *ansibleInventory) parseINI(text string) (err error) {
+	group, kind := ansibleUngrouped, ""
+	scanner := bufio.NewScanner(strings.NewReader(text))
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") || strings.HasPrefix(line, ";") {
+			continue
+		}
+		if strings.HasPrefix(line, "[") && strings.HasSuffix(line, "]") {
+			group, kind, _ = strings.Cut(line[1:len(line)-1], ":")
+			if kind != "" && kind != "vars" && kind != "children" {
+				return fmt.Errorf("line %d: unknown section type %q", n, kind)
+			}
+			inv.group(group)
+			continue
+		}
+
+		switch kind {
+		case "vars":
+			name, value, ok := strings.Cut(line, "=")
+			if !ok {
+				return fmt.Errorf("line %d: expected var=value", n)
+			}
+			inv.group(group).vars[strings.TrimSpace(name)] = unquoteAnsible(strings.TrimSpace(value))
+		case "children":
+			inv.group(group).children = append(inv.group(group).children, line)
+			inv.group(line)
+		default:
+			fields := splitAnsibleLine(line)
+			vars := map[string]string{}
+			for _, field := range fields[1:] {
+				name, value, ok := strings.Cut(field, "=")
+				if !ok {
+					return fmt.Errorf("line %d: expected var=value, got %q", n, field)
+				}
+				vars[name] = unquoteAnsible(value)
+			}
+			if err = inv.addHost(group, fields[0], vars); err != nil {
+				return fmt.Errorf("line %d: %v", n, err)
+			}
+		}
+	}
+	return scanner.Err()
+}
+
+// splitAnsibleLine splits an INI host line at spaces outside quotes.
+func splitAnsibleLine(line string) (fields []string) {
+	var b strings.Builder
+	var quote rune
+	for _, r := range line {
+		switch {
+		case quote != 0:
+			if r == quote {
+				quote = 0
+			}
+			b.WriteRune(r)
+		case r == '"' || r == '\'':
+			quote = r
+			b.WriteRune(r)
+		case r == ' ' || r == '\t':
+			if b.Len() > 0 {
+				fields = append(fields, b.String())
+				b.Reset()
+			}
+		default:
+			b.WriteRune(r)
+		}
+	}
+	if b.Len() > 0 {
+		fields = append(fields, b.String())
+	}
+	return
+}
+
+// unquoteAnsible removes the quotes around an INI value.
+func unquoteAnsible(value string) string {
+	if len(value) >= 2 && (value[0] == '"' || value[0] == '\'') && value[len(value)-1] == value[0] {
+		return value[1 : len(value)-1]
+	}
+	return value
+}
+
+// ansibleYAMLGroup is a group of a YAML inventory.
+type ansibleYAMLGroup struct {
+	Hosts    map[string]map[string]any    `yaml:"hosts"`
+	Vars     map[string]any               `yaml:"vars"`
+	Children map[string]*ansibleYAMLGroup `yaml:"children"`
+}
+
+// parseYAML reads an inventory in the YAML format, whose top level maps
+// group names, usually just all, to their hosts, vars and children.
+func (inv *ansibleInventory) parseYAML(data []byte) (err error) {
+	var top map[string]*ansibleYAMLGroup
+	if err = yaml.Unmarshal(data, &top); err != nil {
+		return
+	}
+	var add func(name string, g *ansibleYAMLGroup) error
+	add = func(name string, g *ansibleYAMLGroup) (errs error) {
+		group := inv.group(name)
+		if g == nil {
+			return
+		}
+		for pattern, vars := range g.Hosts {
+			if errs = inv.addHost(name, pattern, ansibleVars(vars)); errs != nil {
+				return
+			}
+		}
+		for key, value := range ansibleVars(g.Vars) {
+			group.vars[key] = value
+		}
+		for child, c := range g.Children {
+			group.children = append(group.children, child)
+			if errs = add(child, c); errs != nil {
+				return
+			}
+		}
+		return
+	}
+	for name, g := range top {
+		if err = add(name, g); err != nil {
+			return
+		}
+	}
+	return
+}
+
+// ansibleVars converts YAML values, which may be numbers or booleans, to
+// strings.
+func ansibleVars(vars map[string]any) map[string]string {
+	out := map[string]string{}
+	for name, value := range vars {
+		if value != nil {
+			out[name] = fmt.Sprint(value)
+		}
+	}
+	return out
+}
+
+// expandAnsibleHost expands the ranges of a host pattern: web[01:03] is
+// web01, web02 and web03, db-[a:c] is db-a, db-b and db-c, and [1:9:2]
+// takes every other number.
+func expandAnsibleHost(pattern string) (hosts []string, err error) {
+	start := strings.Index(pattern, "[")
+	if start < 0 {
+		return []string{pattern}, nil
+	}
+	end := strings.Index(pattern[start:], "]")
+	if end < 0 {
+		return nil, fmt.Errorf("host pattern %s: missing ]", pattern)
+	}
+	end += start
+	prefix, spec, suffix := pattern[:start], pattern[start+1:end], pattern[end+1:]
+	rest, err := expandAnsibleHost(suffix)
+	if err != nil {
+		return
+	}
+
+	parts := strings.Split(spec, ":")
+	if len(parts) < 2 || len(parts) > 3 {
+		return nil, fmt.Errorf("host pattern %s: range must be [start:end] or [start:end:step]", pattern)
+	}
+	step := 1
+	if len(parts) == 3 {
+		if step, err = strconv.Atoi(parts[2]); err != nil || step <= 0 {
+			return nil, fmt.Errorf("host pattern %s: invalid step %q", pattern, parts[2])
+		}
+	}
+	var values []string
+	from, errFrom := strconv.Atoi(parts[0])
+	to, errTo := strconv.Atoi(parts[1])
+	switch {
+	case errFrom == nil && errTo == nil:
+		// 起始值带前导零时按其宽度补零
+		width := 0
+		if len(parts[0]) > 1 && parts[0][0] == '0' {
+			width = len(parts[0])
+		}
+		for i := from; i <= to; i += step {
+			values = append(values, fmt.Sprintf("%0*d", width, i))
+		}
+	case len(parts[0]) == 1 && len(parts[1]) == 1:
+		for c := int(parts[0][0]); c <= int(parts[1][0]); c += step {
+			values = append(values, string(rune(c)))
+		}
+	default:
+		return nil, fmt.Errorf("host pattern %s: invalid range [%s]", pattern, spec)
+	}
+	if len(values) == 0 {
+		return nil, fmt.Errorf("host pattern %s: empty range [%s]", pattern, spec)
+	}
+	for _, value := range values {
+		for _, r := range rest {
+			hosts = append(hosts, prefix+value+r)
+		}
+	}
+	return
+}
@@ -0,0 +1,392 @@
+package sshtools
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// APIConnection is a connection driven through the control API.
+type APIConnection struct {
+	// Name identifies the connection in URLs: the tunnel name or the alias.
+	Name   string
+	Server *Server
+	// Client returns the current connection, nil while it is reconnecting.
+	Client   func() *Client
+	Forwards ForwardController
+}
+
+// APIConnectionInfo describes a connection in the replies of the control API.
+type APIConnectionInfo struct {
+	Name      string        `json:"name"`
+	Alias     string        `json:"alias"`
+	User      string        `json:"user"`
+	Address   string        `json:"address"`
+	Connected bool          `json:"connected"`
+	Forwards  []ForwardInfo `json:"forwards"`
+}
+
+// apiExecRequest is the body of POST /v1/connections/{name}/exec.
+type apiExecRequest struct {
+	Command string `json:"command"`
+	Stdin   string `json:"stdin,omitempty"`
+}
+
+// apiExecEvent is one line of the exec stream: a chunk of output, and
+// last the result.
+type apiExecEvent struct {
+	Stdout string      `json:"stdout,omitempty"`
+	Stderr string      `json:"stderr,omitempty"`
+	Result *ExecResult `json:"result,omitempty"`
+}
+
+// apiForwardRequest is the body of POST /v1/connections/{name}/forwards.
+type apiForwardRequest struct {
+	Kind string `json:"kind"`
+	Spec string `json:"spec"`
+}
+
+type apiError struct {
+	Error string `json:"error"`
+}
+
+// ControlAPIPath returns the socket the control API of the process known
+// as name listens on, e.g. "mux-web1" or "tunnels".
+func ControlAPIPath(name string) (path string, err error) {
+	dir, err := StateDir("sockets", "api")
+	if err != nil {
+		return
+	}
+	return filepath.Join(dir, name+".sock"), nil
+}
+
+// ControlAPI serves a REST API for local tools on a unix socket: listing
+// connections and their forwards, starting and stopping forwards,
+// running commands with their output streamed, and opening sessions.
+type ControlAPI struct {
+	path   string
+	server *http.Server
+	conns  []*APIConnection
+}
+
+// ListenControlAPI serves conns on the socket of name, readable and
+// writable only by the owner.
+func ListenControlAPI(name string, conns []*APIConnection) (api *ControlAPI, err error) {
+	path, err := ControlAPIPath(name)
+	if err != nil {
+		return
+	}
+	listener, inUse, err := listenPrivateSocket(path)
+	if inUse {
+		return nil, fmt.Errorf("%s is served by another process", path)
+	}
+	if err != nil {
+		return
+	}
+	api = &ControlAPI{path: path, conns: conns}
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /v1/connections", api.listConnections)
+	mux.HandleFunc("GET /v1/connections/{name}", api.connection)
+	mux.HandleFunc("GET /v1/connections/{name}/forwards", api.listForwards)
+	mux.HandleFunc("POST /v1/connections/{name}/forwards", api.addForward)
+	mux.HandleFunc("DELETE /v1/connections/{name}/forwards/{kind}/{listen}", api.cancelForward)
+	mux.HandleFunc("POST /v1/connections/{name}/exec", api.exec)
+	mux.HandleFunc("POST /v1/connections/{name}/session", api.session)
+	api.server = &http.Server{Handler: mux, ReadHeaderTimeout: 10 * time.Second}
+	go func() {
+		_ = api.server.Serve(listener)
+	}()
+	return
+}
+
+// Path returns the socket the API listens on.
+func (api *ControlAPI) Path() string {
+	return api.path
+}
+
+// Close stops serving and removes the socket. Running commands and
+// sessions are cut off.
+func (api *ControlAPI) Close() error {
+	err := api.server.Close()
+	_ = os.Remove(api.path)
+	return err
+}
+
+func (api *ControlAPI) lookup(w http.ResponseWriter, r *http.Request) *APIConnection {
+	name := r.PathValue("name")
+	for _, conn := range api.conns {
+		if conn.Name == name {
+			return conn
+		}
+	}
+	writeAPIError(w, http.StatusNotFound, fmt.Errorf("no connection %q", name))
+	return nil
+}
+
+// client returns the connection's client, replying 503 while there is none.
+func (api *ControlAPI) client(w http.ResponseWriter, conn *APIConnection) *Client {
+	client := conn.Client()
+	if client == nil {
+		writeAPIError(w, http.StatusServiceUnavailable, fmt.Errorf("%s is reconnecting", conn.Name))
+	}
+	return client
+}
+
+func (conn *APIConnection) info() APIConnectionInfo {
+	forwards := conn.Forwards.ListForwards()
+	if forwards == nil {
+		forwards = []ForwardInfo{}
+	}
+	return APIConnectionInfo{Name: conn.Name, Alias: conn.Server.Alias, User: conn.Server.User, Address: conn.Server.Addr(),
+		Connected: conn.Client() != nil, Forwards: forwards}
+}
+
+func (api *ControlAPI) listConnections(w http.ResponseWriter, r *http.Request) {
+	infos := make([]APIConnectionInfo, 0, len(api.conns))
+	for _, conn := range api.conns {
+		infos = append(infos, conn.info())
+	}
+	writeAPIJSON(w, http.StatusOK, infos)
+}
+
+func (api *ControlAPI) connection(w http.ResponseWriter, r *http.Request) {
+	if conn := api.lookup(w, r); conn != nil {
+		writeAPIJSON(w, http.StatusOK, conn.info())
+	}
+}
+
+func (api *ControlAPI) listForwards(w http.ResponseWriter, r *http.Request) {
+	if conn := api.lookup(w, r); conn != nil {
+		writeAPIJSON(w, http.StatusOK, conn.info().Forwards)
+	}
+}
+
+func (api *ControlAPI) addForward(w http.ResponseWriter, r *http.Request) {
+	conn := api.lookup(w, r)
+	if conn == nil {
+		return
+	}
+	var req apiForwardRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeAPIError(w, http.StatusBadRequest, fmt.Errorf("invalid request: %v", err))
+		return
+	}
+	if api.client(w, conn) == nil {
+		return
+	}
+	info, err := conn.Forwards.AddForward(req.Kind, req.Spec)
+	if err != nil {
+		writeAPIError(w, http.StatusBadRequest, err)
+		return
+	}
+	writeAPIJSON(w, http.StatusCreated, info)
+}
+
+func (api *ControlAPI) cancelForward(w http.ResponseWriter, r *http.Request) {
+	conn := api.lookup(w, r)
+	if conn == nil {
+		return
+	}
+	if err := conn.Forwards.CancelForward(r.PathValue("kind"), r.PathValue("listen")); err != nil {
+		writeAPIError(w, http.StatusNotFound, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// exec runs a command and streams its output as JSON lines, ending with
+// the result. The command is killed when the caller goes away.
+func (api *ControlAPI) exec(w http.ResponseWriter, r *http.Request) {
+	conn := api.lookup(w, r)
+	if conn == nil {
+		return
+	}
+	var req apiExecRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Command == "" {
+		if err == nil {
+			err = errors.New(`"command" is required`)
+		}
+		writeAPIError(w, http.StatusBadRequest, fmt.Errorf("invalid request: %v", err))
+		return
+	}
+	client := api.client(w, conn)
+	if client == nil {
+		return
+	}
+	session, command, err := client.NewUserSession(req.Command)
+	if err != nil {
+		writeAPIError(w, http.StatusBadGateway, err)
+		return
+	}
+	defer func() { _ = session.Close() }()
+
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	w.WriteHeader(http.StatusOK)
+	stream := &apiExecStream{w: w, enc: json.NewEncoder(w)}
+	session.Stdout = stream.writer(false)
+	session.Stderr = stream.writer(true)
+	session.Stdin = strings.NewReader(req.Stdin)
+
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-r.Context().Done():
+			// 调用方断开时结束远程命令
+			_ = session.Signal(ssh.SIGKILL)
+			_ = session.Close()
+		case <-done:
+		}
+	}()
+	res := &ExecResult{Alias: conn.Server.Alias, Address: conn.Server.Addr(), Command: req.Command}
+	start := time.Now()
+	err = session.Run(command)
+	res.DurationMs = time.Since(start).Milliseconds()
+	if res.ExitCode = ExitCode(err); err != nil && res.ExitCode < 0 {
+		res.Error = err.Error()
+	}
+	stream.send(apiExecEvent{Result: res})
+}
+
+// apiExecStream writes the output of a command as JSON lines, flushing
+// each so callers see it as it comes.
+type apiExecStream struct {
+	mu  sync.Mutex
+	w   http.ResponseWriter
+	enc *json.Encoder
+}
+
+func (s *apiExecStream) send(event apiExecEvent) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.enc.Encode(event); err != nil {
+		return err
+	}
+	if f, ok := s.w.(http.Flusher); ok {
+		f.Flush()
+	}
+	return nil
+}
+
+func (s *apiExecStream) writer(stderr bool) io.Writer {
+	return writerFunc(func(p []byte) (int, error) {
+		event := apiExecEvent{Stdout: string(p)}
+		if stderr {
+			event = apiExecEvent{Stderr: string(p)}
+		}
+		if err := s.send(event); err != nil {
+			return 0, err
+		}
+		return len(p), nil
+	})
+}
+
+type writerFunc func(p []byte) (int, error)
+
+func (f writerFunc) Write(p []byte) (int, error) {
+	return f(p)
+}
+
+// session opens a shell, or runs the command query parameter, on a PTY of
+// the given term, rows and cols. The request must ask to upgrade to
+// "tcp"; after 101 Switching Protocols the connection carries the raw
+// terminal in both directions until the session ends.
+func (api *ControlAPI) session(w http.ResponseWriter, r *http.Request) {
+	conn := api.lookup(w, r)
+	if conn == nil {
+		return
+	}
+	if !strings.EqualFold(r.Header.Get("Upgrade"), "tcp") {
+		writeAPIError(w, http.StatusUpgradeRequired, errors.New(`sessions need "Connection: Upgrade" and "Upgrade: tcp"`))
+		return
+	}
+	query := r.URL.Query()
+	termType := query.Get("term")
+	if termType == "" {
+		termType = conn.Server.Term
+	}
+	if termType == "" {
+		termType = defaultTermType
+	}
+	rows, cols := 24, 80
+	if v := query.Get("rows"); v != "" {
+		rows, _ = strconv.Atoi(v)
+	}
+	if v := query.Get("cols"); v != "" {
+		cols, _ = strconv.Atoi(v)
+	}
+	if rows <= 0 || cols <= 0 {
+		writeAPIError(w, http.StatusBadRequest, errors.New("rows and cols must be positive numbers"))
+		return
+	}
+	client := api.client(w, conn)
+	if client == nil {
+		return
+	}
+	session, command, err := client.NewUserSession(query.Get("command"))
+	if err != nil {
+		writeAPIError(w, http.StatusBadGateway, err)
+		return
+	}
+	defer func() { _ = session.Close() }()
+	if err = session.RequestPty(termType, rows, cols, conn.Server.PTYModes()); err != nil {
+		writeAPIError(w, http.StatusBadGateway, fmt.Errorf("failed to request a PTY: %v", err))
+		return
+	}
+	hijacker, ok := w.(http.Hijacker)
+	if !ok {
+		writeAPIError(w, http.StatusInternalServerError, errors.New("connection cannot be upgraded"))
+		return
+	}
+	netConn, rw, err := hijacker.Hijack()
+	if err != nil {
+		return
+	}
+	defer func() { _ = netConn.Close() }()
+	_, _ = rw.WriteString("HTTP/1.1 101 Switching Protocols\r\nConnection: Upgrade\r\nUpgrade: tcp\r\n\r\n")
+	if err = rw.Flush(); err != nil {
+		return
+	}
+
+	stdin, err := session.StdinPipe()
+	if err != nil {
+		return
+	}
+	go func() {
+		// 先读出缓冲中已收到的输入
+		_, _ = io.Copy(stdin, io.MultiReader(io.LimitReader(rw.Reader, int64(rw.Reader.Buffered())), netConn))
+		_ = stdin.Close()
+	}()
+	session.Stdout, session.Stderr = netConn, netConn
+	if command == "" {
+		err = session.Shell()
+	} else {
+		err = session.Start(command)
+	}
+	if err == nil {
+		_ = session.Wait()
+	}
+	if c, ok := netConn.(interface{ CloseWrite() error }); ok {
+		_ = c.CloseWrite()
+	}
+}
+
+func writeAPIJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(v)
+}
+
+func writeAPIError(w http.ResponseWriter, status int, err error) {
+	writeAPIJSON(w, status, apiError{Error: err.Error()})
+}
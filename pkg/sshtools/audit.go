@@ -0,0 +1,255 @@
+package sshtools
+
+import (
+	"bufio"
+	"crypto/rsa"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"path"
+	"slices"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// auditVersion is the version line Audit sends before reading the
+// server's KEXINIT.
+const auditVersion = "SSH-2.0-sshtools_audit"
+
+// msgKexInit is the SSH_MSG_KEXINIT message number (RFC 4253 7.1).
+const msgKexInit = 20
+
+// Finding levels of an audit.
+const (
+	AuditFail = "fail"
+	AuditWarn = "warn"
+)
+
+// AuditFinding is a weakness Audit found.
+type AuditFinding struct {
+	Level   string `json:"level"`
+	Message string `json:"message"`
+}
+
+// AuditHostKey is a host key the server presented.
+type AuditHostKey struct {
+	Type        string `json:"type"`
+	Fingerprint string `json:"fingerprint"`
+	// Bits is the size of RSA and DSA keys.
+	Bits int `json:"bits,omitempty"`
+}
+
+// AuditResult describes the algorithms an SSH server offers and what is
+// weak about them.
+type AuditResult struct {
+	Alias             string         `json:"alias"`
+	Address           string         `json:"address"`
+	Banner            string         `json:"banner,omitempty"`
+	ConnectMs         int64          `json:"connect_ms,omitempty"`
+	KeyExchanges      []string       `json:"kex_algorithms,omitempty"`
+	HostKeyAlgorithms []string       `json:"host_key_algorithms,omitempty"`
+	Ciphers           []string       `json:"ciphers,omitempty"`
+	MACs              []string       `json:"macs,omitempty"`
+	Compressions      []string       `json:"compression,omitempty"`
+	HostKeys          []AuditHostKey `json:"host_keys,omitempty"`
+	Findings          []AuditFinding `json:"findings,omitempty"`
+	Error             string         `json:"error,omitempty"`
+}
+
+// Failed reports whether the audit could not be done or found a weakness
+// at the fail level.
+func (r *AuditResult) Failed() bool {
+	return r.Error != "" || slices.ContainsFunc(r.Findings, func(f AuditFinding) bool { return f.Level == AuditFail })
+}
+
+// weakAlgorithms are matched in order against the offered algorithms; the
+// first pattern that matches a name decides.
+var weakAlgorithms = []struct {
+	pattern, level, why string
+}{
+	{"diffie-hellman-group1-sha1", AuditFail, "1024-bit group with SHA-1"},
+	{"diffie-hellman-group-exchange-sha1", AuditFail, "SHA-1 with a group the server chooses"},
+	{"diffie-hellman-group14-sha1", AuditWarn, "SHA-1"},
+	{"rsa1024-sha1", AuditFail, "1024-bit RSA with SHA-1"},
+	{"ssh-dss*", AuditFail, "DSA keys are limited to 1024 bits and removed from OpenSSH"},
+	{"ssh-rsa", AuditWarn, "RSA signatures with SHA-1"},
+	{"ssh-rsa-cert-v01@openssh.com", AuditWarn, "RSA signatures with SHA-1"},
+	{"none", AuditFail, "no encryption or integrity"},
+	{"arcfour*", AuditFail, "RC4 is broken"},
+	{"des-cbc*", AuditFail, "56-bit DES"},
+	{"3des-cbc", AuditFail, "64-bit block cipher (Sweet32)"},
+	{"blowfish-cbc", AuditFail, "64-bit block cipher (Sweet32)"},
+	{"cast128-cbc", AuditFail, "64-bit block cipher (Sweet32)"},
+	{"*-cbc", AuditWarn, "CBC mode, open to plaintext recovery attacks"},
+	{"*-cbc@*", AuditWarn, "CBC mode, open to plaintext recovery attacks"},
+	{"hmac-md5*", AuditFail, "MD5"},
+	{"hmac-sha1*", AuditWarn, "SHA-1"},
+	{"hmac-ripemd160*", AuditWarn, "RIPEMD-160 is deprecated"},
+	{"umac-64*", AuditWarn, "64-bit tag"},
+}
+
+// Audit reads the banner and the algorithms server offers from the start
+// of its key exchange, fetches its host keys and flags weak or deprecated
+// algorithms. It does not authenticate. Errors about reaching the server
+// are returned, later ones are recorded in the result.
+func (d *Dialer) Audit(server *Server) (res *AuditResult, err error) {
+	res = &AuditResult{Alias: server.Alias, Address: server.Addr()}
+	start := time.Now()
+	conn, err := d.dialTCP(server)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", server.Addr(), classifyDialError(err))
+	}
+	res.ConnectMs = time.Since(start).Milliseconds()
+	timer := time.AfterFunc(d.timeout(server), func() { _ = conn.Close() })
+	reader := bufio.NewReader(conn)
+	res.Banner, err = readVersion(reader)
+	var lists [10][]string
+	if err == nil {
+		// 服务器在收到客户端版本后即发送 KEXINIT，读取后直接断开
+		if _, err = io.WriteString(conn, auditVersion+"\r\n"); err == nil {
+			lists, err = readKexInit(reader)
+		}
+	}
+	timer.Stop()
+	_ = conn.Close()
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", server.Addr(), err)
+	}
+	// 列表依次为 kex、主机密钥，以及两个方向的加密、MAC 和压缩算法
+	res.KeyExchanges, res.HostKeyAlgorithms = lists[0], lists[1]
+	res.Ciphers = mergeLists(lists[2], lists[3])
+	res.MACs = mergeLists(lists[4], lists[5])
+	res.Compressions = mergeLists(lists[6], lists[7])
+
+	res.HostKeys = d.auditHostKeys(server, res)
+	res.Findings = append(auditVersionFindings(res.Banner), res.Findings...)
+	res.Findings = append(res.Findings, auditAlgorithms(res)...)
+	return
+}
+
+// readKexInit reads the server's unencrypted KEXINIT packet and returns
+// its ten name-lists (RFC 4253 7.1).
+func readKexInit(reader *bufio.Reader) (lists [10][]string, err error) {
+	var header [5]byte
+	if _, err = io.ReadFull(reader, header[:]); err != nil {
+		return lists, fmt.Errorf("failed to read the key exchange: %v", err)
+	}
+	length, padding := binary.BigEndian.Uint32(header[:4]), int(header[4])
+	if length < 1+17 || length > 256<<10 || padding >= int(length)-17 {
+		return lists, errors.New("invalid key exchange packet")
+	}
+	payload := make([]byte, length-1)
+	if _, err = io.ReadFull(reader, payload); err != nil {
+		return lists, fmt.Errorf("failed to read the key exchange: %v", err)
+	}
+	payload = payload[:len(payload)-padding]
+	if payload[0] != msgKexInit {
+		return lists, fmt.Errorf("expected KEXINIT, got message %d", payload[0])
+	}
+	// 跳过消息号与 16 字节的 cookie
+	rest := payload[17:]
+	for i := range lists {
+		if len(rest) < 4 {
+			return lists, errors.New("truncated KEXINIT")
+		}
+		n := binary.BigEndian.Uint32(rest)
+		rest = rest[4:]
+		if uint64(n) > uint64(len(rest)) {
+			return lists, errors.New("truncated KEXINIT")
+		}
+		if n > 0 {
+			lists[i] = strings.Split(string(rest[:n]), ",")
+		}
+		rest = rest[n:]
+	}
+	return
+}
+
+// mergeLists returns a followed by the names of b it lacks.
+func mergeLists(a, b []string) []string {
+	merged := slices.Clone(a)
+	for _, name := range b {
+		if !slices.Contains(merged, name) {
+			merged = append(merged, name)
+		}
+	}
+	return merged
+}
+
+// auditHostKeys fetches a key for each plain host key algorithm the
+// server offers and x/crypto/ssh implements, offering the legacy
+// algorithms too so that old servers can be audited.
+func (d *Dialer) auditHostKeys(server *Server, res *AuditResult) (keys []AuditHostKey) {
+	known := knownAlgorithms().HostKeys
+	var fetched []ssh.PublicKey
+	for _, algorithm := range res.HostKeyAlgorithms {
+		if strings.Contains(algorithm, "-cert-") || !slices.Contains(known, algorithm) {
+			continue
+		}
+		key, _, err := d.fetchHostKey(server, Algorithms{HostKeys: []string{algorithm}, Legacy: true})
+		if err != nil {
+			res.Findings = append(res.Findings, AuditFinding{AuditWarn, fmt.Sprintf("could not fetch the %s host key: %v", algorithm, err)})
+			continue
+		}
+		if containsKey(fetched, key) {
+			continue
+		}
+		fetched = append(fetched, key)
+		hostKey := AuditHostKey{Type: key.Type(), Fingerprint: ssh.FingerprintSHA256(key)}
+		if crypto, ok := key.(ssh.CryptoPublicKey); ok {
+			if rsaKey, ok := crypto.CryptoPublicKey().(*rsa.PublicKey); ok {
+				hostKey.Bits = rsaKey.N.BitLen()
+			}
+		}
+		if key.Type() == ssh.KeyAlgoDSA {
+			hostKey.Bits = 1024
+		}
+		keys = append(keys, hostKey)
+		if key.Type() == ssh.KeyAlgoRSA && hostKey.Bits < 2048 {
+			res.Findings = append(res.Findings, AuditFinding{AuditFail, fmt.Sprintf("host key %s: %d-bit RSA, at least 2048 bits are needed", hostKey.Fingerprint, hostKey.Bits)})
+		}
+	}
+	return
+}
+
+// auditVersionFindings flags servers that still speak SSH protocol 1.
+func auditVersionFindings(banner string) (findings []AuditFinding) {
+	if strings.HasPrefix(banner, "SSH-1.") {
+		findings = append(findings, AuditFinding{AuditFail, "the server supports SSH protocol 1"})
+	}
+	return
+}
+
+// auditAlgorithms flags the weak algorithms of res, and servers open to
+// the Terrapin attack.
+func auditAlgorithms(res *AuditResult) (findings []AuditFinding) {
+	for _, list := range []struct {
+		kind  string
+		names []string
+	}{
+		{"kex", res.KeyExchanges},
+		{"host key algorithm", res.HostKeyAlgorithms},
+		{"cipher", res.Ciphers},
+		{"MAC", res.MACs},
+	} {
+		for _, name := range list.names {
+			for _, weak := range weakAlgorithms {
+				if ok, _ := path.Match(weak.pattern, name); ok {
+					findings = append(findings, AuditFinding{weak.level, fmt.Sprintf("%s %s: %s", list.kind, name, weak.why)})
+					break
+				}
+			}
+		}
+	}
+	// Terrapin（CVE-2023-48795）影响 ChaCha20-Poly1305 以及 CBC 与 EtM MAC 的组合
+	cbc := slices.ContainsFunc(res.Ciphers, func(name string) bool { return strings.Contains(name, "-cbc") })
+	etm := slices.ContainsFunc(res.MACs, func(name string) bool { return strings.HasSuffix(name, "-etm@openssh.com") })
+	if (slices.Contains(res.Ciphers, "chacha20-poly1305@openssh.com") || cbc && etm) &&
+		!slices.Contains(res.KeyExchanges, "kex-strict-s-v00@openssh.com") {
+		findings = append(findings, AuditFinding{AuditWarn, "open to the Terrapin attack (CVE-2023-48795): no strict key exchange"})
+	}
+	return
+}
@@ -0,0 +1,158 @@
+package sshtools
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// authSteps are the steps an auth chain (auth_methods, -auth) may list,
+// like OpenSSH's PreferredAuthentications: the keys of ssh-agent,
+// private_key or else the default identity files, the server's prompts,
+// and the configured or a prompted password.
+var authSteps = []string{AuthAgent, AuthPublicKey, AuthKeyboardInteractive, AuthPassword}
+
+// ParseAuthMethods splits a comma-separated auth chain such as
+// "publickey,password".
+func ParseAuthMethods(value string) (methods []string, err error) {
+	for _, method := range strings.Split(value, ",") {
+		method = strings.TrimSpace(method)
+		if !slices.Contains(authSteps, method) {
+			err = fmt.Errorf("unknown auth method %q, expected %s", method, strings.Join(authSteps, ", "))
+			return
+		}
+		methods = append(methods, method)
+	}
+	return
+}
+
+// checkAuthMethods validates an auth chain.
+func checkAuthMethods(methods []string) (msgs []string) {
+	for i, method := range methods {
+		switch {
+		case !slices.Contains(authSteps, method):
+			msgs = append(msgs, fmt.Sprintf(`"auth_methods" %q must be one of %s`, method, strings.Join(authSteps, ", ")))
+		case slices.Contains(methods[:i], method):
+			msgs = append(msgs, fmt.Sprintf(`"auth_methods" lists %q twice`, method))
+		}
+	}
+	return
+}
+
+// authMethods returns the auth chain for server: the dialer's, else the
+// server's, else none for the default order.
+func (d *Dialer) authMethods(server *Server) []string {
+	if len(d.AuthMethods) > 0 {
+		return d.AuthMethods
+	}
+	return server.AuthMethods
+}
+
+// authChain builds the auth methods of server in the order of methods.
+// The server accepts each method name only once, so the agent and key
+// file steps are offered together, as one publickey attempt where the
+// first of them is listed. Steps without credentials are skipped.
+func (d *Dialer) authChain(server *Server, trace *authTrace, methods []string) (auth []ssh.AuthMethod, err error) {
+	d.Logf(1, "auth methods: %s", strings.Join(methods, ", "))
+	var identities []identity
+	keysAt := -1
+	for i, method := range methods {
+		switch method {
+		case AuthAgent, AuthPublicKey:
+			var keys []identity
+			if method == AuthAgent {
+				var errs error
+				if keys, errs = d.agentIdentities(trace); errs != nil {
+					d.Logf(1, "skipping agent: %v", errs)
+				}
+			} else if keys, err = d.keyFiles(server, trace); err != nil {
+				return
+			}
+			identities = append(identities, keys...)
+			if keysAt < 0 {
+				keysAt = len(auth)
+				// 占位，收集完所有密钥后替换
+				auth = append(auth, nil)
+			}
+		case AuthKeyboardInteractive:
+			auth = append(auth, d.keyboardInteractive(trace, server, i < len(methods)-1))
+		case AuthPassword:
+			switch {
+			case server.Password != "" && !d.AskPassword:
+				auth = append(auth, d.password(trace, server.Password))
+			case d.PromptPassword != nil:
+				auth = append(auth, d.promptedPassword(trace, server.User, server.Address))
+			default:
+				d.Logf(1, "skipping password: none configured and no terminal to prompt on")
+			}
+		}
+	}
+	if keysAt >= 0 {
+		var keys []ssh.AuthMethod
+		if len(identities) > 0 {
+			keys = append(keys, d.publicKeys(trace, identities...))
+		} else {
+			d.Logf(1, "skipping publickey: no keys found")
+		}
+		// PKCS#11 模块中的密钥在其余密钥之后提供
+		if server.PKCS11Module != "" {
+			module, errs := server.expandPath("pkcs11_module", server.PKCS11Module)
+			if errs != nil {
+				err = errs
+				return
+			}
+			keys = append(keys, d.pkcs11Keys(trace, module))
+		}
+		auth = slices.Replace(auth, keysAt, keysAt+1, keys...)
+	}
+	if len(auth) == 0 {
+		err = &Error{Kind: ErrorAuth, Err: fmt.Errorf("none of the auth methods %s has credentials for %s", strings.Join(methods, ", "), server.Alias)}
+	}
+	return
+}
+
+// keyFiles returns the keys from key_source, or loads private_key with its
+// certificate, or the default identity files when there is none.
+func (d *Dialer) keyFiles(server *Server, trace *authTrace) (identities []identity, err error) {
+	if server.vault.hasKeys() {
+		return server.vault.identities, nil
+	}
+	if server.PrivateKey == "" {
+		return d.defaultKeyFiles(), nil
+	}
+	return d.keyIdentities(server, trace)
+}
+
+// keyIdentities loads private_key, offering its user certificate first
+// when there is one.
+func (d *Dialer) keyIdentities(server *Server, trace *authTrace) (identities []identity, err error) {
+	keyPath, err := server.expandPath("private_key", server.PrivateKey)
+	if err != nil {
+		return
+	}
+	// FIDO2 安全密钥由 ssh-agent 签名，其余私钥从文件读取
+	privateKey, err := d.securityKey(trace, keyPath)
+	if privateKey == nil && err == nil {
+		privateKey, err = d.loadPrivateKey(server, keyPath)
+	}
+	if err != nil {
+		return
+	}
+	// 有用户证书时先提供证书，再提供密钥本身
+	certPath, err := server.certificatePath(keyPath)
+	if err != nil {
+		return
+	}
+	if certPath != "" {
+		signer, errs := certSigner(privateKey, certPath)
+		if errs != nil {
+			err = errs
+			return
+		}
+		identities = append(identities, identity{signer, certPath})
+	}
+	identities = append(identities, identity{privateKey, keyPath})
+	return
+}
@@ -0,0 +1,139 @@
+package sshtools
+
+import (
+	"encoding/json"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// authCacheFile holds, per alias, the auth method that last succeeded.
+const authCacheFile = "auth-cache.json"
+
+// cachedAuth identifies a successful auth method without any secret: the
+// method name and, for public keys, the key fingerprint.
+type cachedAuth struct {
+	Method      string `json:"method"`
+	Fingerprint string `json:"fingerprint,omitempty"`
+}
+
+// authCacheMu serializes read-modify-write of the cache within the process
+// (fleet operations dial in parallel).
+var authCacheMu sync.Mutex
+
+func authCachePath() (string, error) {
+	dir, err := StateDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, authCacheFile), nil
+}
+
+func readAuthCache() (cache map[string]cachedAuth) {
+	cache = map[string]cachedAuth{}
+	file, err := authCachePath()
+	if err != nil {
+		return
+	}
+	if data, err := os.ReadFile(file); err == nil {
+		_ = json.Unmarshal(data, &cache)
+	}
+	return
+}
+
+// cachedAuth returns the method that last worked for alias, if caching is on.
+func (d *Dialer) cachedAuth(alias string) (auth cachedAuth, ok bool) {
+	if !d.AuthCache {
+		return
+	}
+	authCacheMu.Lock()
+	defer authCacheMu.Unlock()
+	auth, ok = readAuthCache()[alias]
+	return
+}
+
+// rememberAuth records the method that just succeeded for alias.
+func (d *Dialer) rememberAuth(alias string, auth cachedAuth) {
+	if !d.AuthCache || auth.Method == "" {
+		return
+	}
+	authCacheMu.Lock()
+	defer authCacheMu.Unlock()
+	cache := readAuthCache()
+	if cache[alias] == auth {
+		return
+	}
+	cache[alias] = auth
+	file, err := authCachePath()
+	if err != nil {
+		return
+	}
+	data, err := json.MarshalIndent(cache, "", "  ")
+	if err != nil {
+		return
+	}
+	tmp := file + ".tmp"
+	if err = os.WriteFile(tmp, data, 0o600); err == nil {
+		err = os.Rename(tmp, file)
+	}
+	if err != nil {
+		d.Logf(1, "failed to update auth cache: %v", err)
+	}
+}
+
+// preferCached moves the cached key to the front of identities. It reports
+// whether the key was found.
+func preferCached(identities []identity, fingerprint string) bool {
+	for i, id := range identities {
+		if ssh.FingerprintSHA256(id.signer.PublicKey()) == fingerprint {
+			copy(identities[1:i+1], identities[:i])
+			identities[0] = id
+			return true
+		}
+	}
+	return false
+}
+
+// recordingSigner notes in the trace which key signed. Signing only happens
+// once the server has accepted the key, so after a successful publickey
+// authentication the last signer is the one that worked.
+type recordingSigner struct {
+	ssh.AlgorithmSigner
+	trace *authTrace
+}
+
+func (s *recordingSigner) Sign(rand io.Reader, data []byte) (*ssh.Signature, error) {
+	s.trace.key = ssh.FingerprintSHA256(s.PublicKey())
+	confirmPresence(s.PublicKey())
+	return s.AlgorithmSigner.Sign(rand, data)
+}
+
+func (s *recordingSigner) SignWithAlgorithm(rand io.Reader, data []byte, algorithm string) (*ssh.Signature, error) {
+	s.trace.key = ssh.FingerprintSHA256(s.PublicKey())
+	confirmPresence(s.PublicKey())
+	return s.AlgorithmSigner.SignWithAlgorithm(rand, data, algorithm)
+}
+
+type recordingMultiSigner struct {
+	recordingSigner
+	algorithms []string
+}
+
+func (s *recordingMultiSigner) Algorithms() []string {
+	return s.algorithms
+}
+
+// recordSigner wraps signer so the trace learns when it is used, keeping
+// the algorithm negotiation interfaces of the original.
+func recordSigner(signer ssh.Signer, trace *authTrace) ssh.Signer {
+	switch s := signer.(type) {
+	case ssh.MultiAlgorithmSigner:
+		return &recordingMultiSigner{recordingSigner{s, trace}, s.Algorithms()}
+	case ssh.AlgorithmSigner:
+		return &recordingSigner{s, trace}
+	}
+	return signer
+}
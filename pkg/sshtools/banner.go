@@ -0,0 +1,187 @@
+package sshtools
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+	"text/template"
+)
+
+// BannerData is what banner templates can refer to.
+type BannerData struct {
+	Alias   string
+	Address string
+	Port    int
+	User    string
+	// Tags is the server's tags joined with ", ".
+	Tags string
+}
+
+// bannerColors maps color names to ANSI SGR codes.
+var bannerColors = map[string]string{
+	"black":   "30",
+	"red":     "31",
+	"green":   "32",
+	"yellow":  "33",
+	"blue":    "34",
+	"magenta": "35",
+	"cyan":    "36",
+	"white":   "37",
+}
+
+// colorCode returns the SGR parameters for a color such as "red" or
+// "bold red".
+func colorCode(color string) (code string, err error) {
+	name, bold := strings.CutPrefix(strings.ToLower(strings.TrimSpace(color)), "bold ")
+	code, ok := bannerColors[strings.TrimSpace(name)]
+	if !ok {
+		return "", fmt.Errorf("unknown color %q", color)
+	}
+	if bold {
+		code = "1;" + code
+	}
+	return
+}
+
+// bannerColor returns the color of server's banner: its own banner_color,
+// else the tag_colors entry of its first tag that has one, else the
+// config's banner_color.
+func (c *Config) bannerColor(server *Server) string {
+	if server.BannerColor != "" {
+		return server.BannerColor
+	}
+	for _, tag := range server.Tags {
+		if color, ok := c.TagColors[tag]; ok {
+			return color
+		}
+	}
+	return c.BannerColor
+}
+
+// RenderBanner renders server's connection banner, its own template or the
+// config's default. It is empty when neither is set. With color, the text
+// is wrapped in the banner color's escape sequences.
+func (c *Config) RenderBanner(server *Server, color bool) (text string, err error) {
+	banner := server.Banner
+	if banner == "" {
+		banner = c.Banner
+	}
+	if banner == "" {
+		return
+	}
+	text, err = renderBanner(banner, BannerData{
+		Alias:   server.Alias,
+		Address: server.Address,
+		Port:    server.Port,
+		User:    server.User,
+		Tags:    strings.Join(server.Tags, ", "),
+	})
+	if err != nil {
+		return
+	}
+	if name := c.bannerColor(server); color && name != "" {
+		code, errs := colorCode(name)
+		if errs != nil {
+			return text, nil
+		}
+		// Color each line so a pager or split line doesn't leak the color.
+		lines := strings.Split(text, "\n")
+		for i, line := range lines {
+			if line != "" {
+				lines[i] = "\x1b[" + code + "m" + line + "\x1b[0m"
+			}
+		}
+		text = strings.Join(lines, "\n")
+	}
+	return
+}
+
+// Label returns server's environment label: its own label, else the
+// tag_labels entry of its first tag that has one.
+func (c *Config) Label(server *Server) string {
+	if server.Label != "" {
+		return server.Label
+	}
+	for _, tag := range server.Tags {
+		if label, ok := c.TagLabels[tag]; ok {
+			return label
+		}
+	}
+	return ""
+}
+
+// LabelColor returns the color server's label is shown in, that of its
+// banner.
+func (c *Config) LabelColor(server *Server) string {
+	return c.bannerColor(server)
+}
+
+// LabelText returns the text shown for server's label, empty without one,
+// e.g. " PROD  deploy@web1 (10.0.1.5) ".
+func (c *Config) LabelText(server *Server) string {
+	label := c.Label(server)
+	if label == "" {
+		return ""
+	}
+	return fmt.Sprintf(" %s  %s@%s (%s) ", label, server.User, server.Alias, server.Address)
+}
+
+// RenderLabel returns the label line shown when connecting to server,
+// empty without a label. With color, it is in reverse video of the label
+// color.
+func (c *Config) RenderLabel(server *Server, color bool) string {
+	text := c.LabelText(server)
+	if text == "" || !color {
+		return text
+	}
+	return "\x1b[" + labelStyle(c.LabelColor(server)) + "m" + text + "\x1b[0m"
+}
+
+// labelStyle returns the SGR parameters for reverse video in color, or
+// plain reverse video when color is empty or unknown.
+func labelStyle(color string) string {
+	if code, err := colorCode(color); err == nil {
+		return "7;" + code
+	}
+	return "7"
+}
+
+func renderBanner(banner string, data BannerData) (text string, err error) {
+	tmpl, err := template.New("banner").Parse(banner)
+	if err != nil {
+		return "", fmt.Errorf("invalid banner template: %v", err)
+	}
+	var b strings.Builder
+	if err = tmpl.Execute(&b, data); err != nil {
+		return "", fmt.Errorf("invalid banner template: %v", err)
+	}
+	return b.String(), nil
+}
+
+// ColorNames lists the colors banner_color accepts, without "bold ".
+func ColorNames() []string {
+	names := make([]string, 0, len(bannerColors))
+	for name := range bannerColors {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	return names
+}
+
+// SetTitle returns the escape sequences that save the terminal window title
+// and set it to title (OSC 2); RestoreTitle brings the saved one back.
+// Terminals without a title stack ignore the save and restore.
+func SetTitle(title string) string {
+	title = strings.Map(func(r rune) rune {
+		if r < 0x20 || r == 0x7f {
+			return -1
+		}
+		return r
+	}, title)
+	return "\x1b[22;0t\x1b]2;" + title + "\x07"
+}
+
+// RestoreTitle pops the title saved by SetTitle.
+func RestoreTitle() string {
+	return "\x1b[23;0t"
+}
@@ -0,0 +1,216 @@
+package sshtools
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+)
+
+// BecomeSudo is the only become method so far.
+const BecomeSudo = "sudo"
+
+// becomePrompt is the prompt sudo is told to use, so that its asking, and
+// asking again after a wrong password, can be recognized in the output.
+const becomePrompt = "[sshtools-become] password: "
+
+// becomeWindow is how long an interactive session is watched for the
+// prompt.
+const becomeWindow = 10 * time.Second
+
+// ErrBecomeFailed is wrapped by the errors of sudo not accepting the
+// password.
+var ErrBecomeFailed = errors.New("become failed")
+
+// Become configures privilege escalation for -b. The password is never
+// stored in the config: it comes from the PasswordEnv variable, the OS
+// keychain when PasswordSource is "keychain", or a prompt.
+type Become struct {
+	Method         string `json:"method,omitempty"`
+	PasswordEnv    string `json:"password_env,omitempty"`
+	PasswordSource string `json:"password_source,omitempty"`
+	// PTY runs commands through sudo on a PTY, for sudoers with requiretty.
+	// Stdout and stderr are then merged.
+	PTY bool `json:"pty,omitempty"`
+}
+
+// SudoCommand wraps command to run through sudo with the prompt sshtools
+// answers. An empty command starts a root login shell. With stdin, sudo
+// reads the password from standard input, for sessions without a PTY.
+func SudoCommand(command string, stdin bool) string {
+	sudo := "sudo -p " + ShellQuote(becomePrompt)
+	if stdin {
+		sudo = "sudo -S -p " + ShellQuote(becomePrompt)
+	}
+	if command == "" {
+		return sudo + " -i"
+	}
+	return sudo + " -- sh -c " + ShellQuote(command)
+}
+
+// becomeWatcher passes output through to w, typing the password into
+// stdin when sudo prompts. A second prompt means the password was wrong:
+// err is set and onFail called rather than leaving sudo waiting.
+type becomeWatcher struct {
+	w        io.Writer
+	stdin    io.Writer
+	password string
+	// strip removes the prompt from the output.
+	strip bool
+	// closeStdin closes stdin once the password is typed.
+	closeStdin bool
+	onFail     func()
+	// until stops the watching when set.
+	until time.Time
+
+	mu    sync.Mutex
+	tail  []byte
+	asked int
+	err   error
+}
+
+func (b *becomeWatcher) Write(p []byte) (n int, err error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	n = len(p)
+	if !b.until.IsZero() && time.Now().After(b.until) {
+		if len(b.tail) > 0 && b.strip {
+			_, err = b.w.Write(b.tail)
+		}
+		b.tail = nil
+		if err == nil {
+			_, err = b.w.Write(p)
+		}
+		return
+	}
+
+	// The prompt may be split across writes: tail keeps the end of the
+	// previous one. When stripping it was held back from w as well.
+	data := p
+	if len(b.tail) > 0 {
+		data = append(b.tail, p...)
+	}
+	if !b.strip {
+		if _, err = b.w.Write(p); err != nil {
+			return
+		}
+	}
+	for {
+		i := bytes.Index(data, []byte(becomePrompt))
+		if i < 0 {
+			break
+		}
+		if b.strip {
+			if _, err = b.w.Write(data[:i]); err != nil {
+				return
+			}
+		}
+		data = data[i+len(becomePrompt):]
+		b.prompted()
+	}
+	keep := partialPrefix(data, becomePrompt)
+	if b.strip {
+		if _, err = b.w.Write(data[:len(data)-keep]); err != nil {
+			return
+		}
+	}
+	b.tail = append([]byte(nil), data[len(data)-keep:]...)
+	return
+}
+
+// prompted answers the first prompt and gives up on the next.
+func (b *becomeWatcher) prompted() {
+	b.asked++
+	if b.asked == 1 && b.password != "" {
+		_, _ = io.WriteString(b.stdin, b.password+"\n")
+		if c, ok := b.stdin.(io.Closer); ok && b.closeStdin {
+			_ = c.Close()
+		}
+		return
+	}
+	if b.err != nil {
+		return
+	}
+	b.err = fmt.Errorf("%w: sudo did not accept the password", ErrBecomeFailed)
+	if b.password == "" {
+		b.err = fmt.Errorf("%w: sudo asked for a password and none was given", ErrBecomeFailed)
+	}
+	if b.onFail != nil {
+		go b.onFail()
+	}
+}
+
+// Err returns why sudo was given up on, if it was.
+func (b *becomeWatcher) Err() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.err
+}
+
+// partialPrefix returns the length of the longest end of data that is a
+// proper prefix of s.
+func partialPrefix(data []byte, s string) int {
+	for k := min(len(data), len(s)-1); k > 0; k-- {
+		if bytes.HasPrefix([]byte(s), data[len(data)-k:]) {
+			return k
+		}
+	}
+	return 0
+}
+
+// redactWriter passes output through to w with every occurrence of secret
+// replaced, e.g. a sudo password echoed back by a misbehaving command.
+type redactWriter struct {
+	w      io.Writer
+	secret string
+
+	mu   sync.Mutex
+	tail []byte
+}
+
+// newRedactWriter returns w itself when there is nothing to redact.
+func newRedactWriter(w io.Writer, secret string) io.Writer {
+	if secret == "" {
+		return w
+	}
+	return &redactWriter{w: w, secret: secret}
+}
+
+func (r *redactWriter) Write(p []byte) (n int, err error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	n = len(p)
+	// 密码可能跨越多次写入：tail 保留上次末尾可能是密码开头的部分
+	data := append(r.tail, p...)
+	data = bytes.ReplaceAll(data, []byte(r.secret), []byte("********"))
+	keep := partialPrefix(data, r.secret)
+	r.tail = append([]byte(nil), data[len(data)-keep:]...)
+	_, err = r.w.Write(data[:len(data)-keep])
+	return
+}
+
+// Flush writes what was held back as the possible start of the secret.
+func (r *redactWriter) Flush() (err error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if len(r.tail) > 0 {
+		_, err = r.w.Write(r.tail)
+		r.tail = nil
+	}
+	return
+}
+
+// check returns what is wrong with become settings, if anything.
+func (b *Become) check() error {
+	switch b.Method {
+	case "", BecomeSudo:
+	default:
+		return fmt.Errorf(`"become" method %q is not supported, use %q`, b.Method, BecomeSudo)
+	}
+	if b.PasswordSource != "" && b.PasswordSource != PasswordSourceKeychain {
+		return fmt.Errorf(`"become" password_source %q is not supported, use %q`, b.PasswordSource, PasswordSourceKeychain)
+	}
+	return nil
+}
@@ -0,0 +1,415 @@
+package sshtools
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"sync"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// BroadcastToggle (Ctrl-]) moves the input of a Broadcast from all hosts to
+// each single host in turn and back to all of them.
+const BroadcastToggle = 0x1d
+
+// maxBroadcastLine is how much of an unfinished line a host may hold back
+// before it is shown anyway.
+const maxBroadcastLine = 4096
+
+// minBroadcastWidth is the narrowest PTY given to a host, however little
+// room the name column leaves.
+const minBroadcastWidth = 20
+
+// BroadcastHost is one session of a Broadcast.
+type BroadcastHost struct {
+	Name    string
+	Session *ssh.Session
+	// Command is run on the PTY instead of the login shell when set.
+	Command string
+	// TermType and Modes are as for Terminal.
+	TermType string
+	Modes    ssh.TerminalModes
+
+	stdin   io.WriteCloser
+	partial []byte
+	done    bool
+}
+
+// Broadcast runs interactive shells on several hosts at once, like
+// clusterssh, and sends the local keystrokes to all of them or, after
+// BroadcastToggle, to a single one. Output is shown line by line behind
+// the host name; the unfinished line (the prompt and what is being typed)
+// is shown for the host that last had the input to itself, the first one
+// to begin with. Full-screen programs are not supported.
+type Broadcast struct {
+	Hosts  []*BroadcastHost
+	Stdin  io.Reader
+	Stdout io.Writer
+	Stderr io.Writer
+
+	mu     sync.Mutex
+	focus  int // host receiving the input alone, -1 for all of them
+	lead   int // host whose unfinished line is on screen
+	shown  bool
+	width  int // of the name column
+	live   int
+	closed bool
+	escape escapeState
+}
+
+// broadcastOutput is the Stdout and Stderr of one host's session.
+type broadcastOutput struct {
+	b    *Broadcast
+	host int
+}
+
+func (o *broadcastOutput) Write(p []byte) (int, error) {
+	o.b.output(o.host, p)
+	return len(p), nil
+}
+
+// lineText returns what remains visible of a line once its carriage
+// returns have been applied, e.g. the last state of a progress bar.
+func lineText(line []byte) []byte {
+	line = bytes.TrimSuffix(line, []byte{'\r'})
+	if i := bytes.LastIndexByte(line, '\r'); i >= 0 {
+		line = line[i+1:]
+	}
+	return line
+}
+
+// prefix returns the name column of host i.
+func (b *Broadcast) prefix(i int) string {
+	return fmt.Sprintf("%-*s | ", b.width, b.Hosts[i].Name)
+}
+
+// clearLead erases the lead host's unfinished line from the screen.
+func (b *Broadcast) clearLead() {
+	if b.shown {
+		_, _ = io.WriteString(b.Stdout, "\r\x1b[K")
+		b.shown = false
+	}
+}
+
+// drawLead shows the lead host's unfinished line.
+func (b *Broadcast) drawLead() {
+	if b.lead < 0 || b.Hosts[b.lead].done {
+		return
+	}
+	_, _ = io.WriteString(b.Stdout, b.prefix(b.lead))
+	_, _ = b.Stdout.Write(lineText(b.Hosts[b.lead].partial))
+	b.shown = true
+}
+
+// output shows what host i wrote: complete lines at once, unfinished ones
+// only for the lead host.
+func (b *Broadcast) output(i int, p []byte) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	h := b.Hosts[i]
+	h.partial = append(h.partial, p...)
+	end := bytes.LastIndexByte(h.partial, '\n')
+	if end < 0 && len(h.partial) < maxBroadcastLine {
+		if i != b.lead {
+			return
+		}
+		// 只是回显时直接追加，不必重画整行
+		if b.shown && bytes.IndexByte(p, '\r') < 0 {
+			_, _ = b.Stdout.Write(p)
+			return
+		}
+		b.clearLead()
+		b.drawLead()
+		return
+	}
+	if end < 0 {
+		end = len(h.partial) - 1
+		h.partial = append(h.partial, '\n')
+	}
+	b.clearLead()
+	var out []byte
+	for _, line := range bytes.SplitAfter(h.partial[:end+1], []byte{'\n'}) {
+		if len(line) > 0 {
+			out = append(append(append(out, b.prefix(i)...), lineText(bytes.TrimSuffix(line, []byte{'\n'}))...), "\x1b[0m\r\n"...)
+		}
+	}
+	_, _ = b.Stdout.Write(out)
+	h.partial = append(h.partial[:0], h.partial[end+1:]...)
+	b.drawLead()
+}
+
+// notice shows a message of our own between the hosts' output.
+func (b *Broadcast) notice(format string, args ...any) {
+	b.clearLead()
+	fmt.Fprintf(b.Stdout, "\x1b[7m"+format+"\x1b[0m\r\n", args...)
+	b.drawLead()
+}
+
+// showTarget tells where the input goes now.
+func (b *Broadcast) showTarget() {
+	if b.focus < 0 {
+		b.notice("[input: all %d hosts, Ctrl-] to switch]", b.live)
+	} else {
+		b.notice("[input: %s only, Ctrl-] to switch]", b.Hosts[b.focus].Name)
+	}
+}
+
+// toggle moves the input to the next host that is still connected, or
+// back to all hosts after the last one.
+func (b *Broadcast) toggle() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	next := -1
+	for i := b.focus + 1; i < len(b.Hosts); i++ {
+		if !b.Hosts[i].done {
+			next = i
+			break
+		}
+	}
+	b.focus = next
+	if next >= 0 && next != b.lead {
+		b.clearLead()
+		b.lead = next
+	}
+	b.showTarget()
+}
+
+// send writes p to the host in focus, or to every host.
+func (b *Broadcast) send(p []byte) {
+	if len(p) == 0 {
+		return
+	}
+	b.mu.Lock()
+	var targets []io.Writer
+	for i, h := range b.Hosts {
+		if !h.done && (b.focus < 0 || b.focus == i) {
+			targets = append(targets, h.stdin)
+		}
+	}
+	b.mu.Unlock()
+	for _, w := range targets {
+		// 已断开的主机由 finish 处理
+		_, _ = w.Write(p)
+	}
+}
+
+// hangUp closes every session, for ~. and termination signals.
+func (b *Broadcast) hangUp() {
+	b.mu.Lock()
+	b.closed = true
+	b.mu.Unlock()
+	for _, h := range b.Hosts {
+		_ = h.Session.Close()
+	}
+}
+
+// finish records that host i's session ended with err.
+func (b *Broadcast) finish(i int, err error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	h := b.Hosts[i]
+	if len(h.partial) > 0 {
+		b.clearLead()
+		_, _ = io.WriteString(b.Stdout, b.prefix(i))
+		_, _ = b.Stdout.Write(lineText(h.partial))
+		_, _ = io.WriteString(b.Stdout, "\x1b[0m\r\n")
+		h.partial = nil
+		b.drawLead()
+	}
+	h.done = true
+	b.live--
+	if b.lead == i {
+		b.clearLead()
+		b.lead = -1
+		for j, other := range b.Hosts {
+			if !other.done {
+				b.lead = j
+				break
+			}
+		}
+	}
+	var exitErr *ssh.ExitError
+	switch {
+	case b.closed:
+	case errors.As(err, &exitErr):
+		b.notice("[%s exited with status %d]", h.Name, exitErr.ExitStatus())
+	case err != nil && !errors.Is(err, io.EOF):
+		b.notice("[%s: %v]", h.Name, err)
+	default:
+		b.notice("[%s closed the connection]", h.Name)
+	}
+	if b.focus == i && b.live > 0 {
+		b.focus = -1
+		b.showTarget()
+	}
+}
+
+// ptySize returns the PTY size for the hosts: the local window less the
+// name column.
+func (b *Broadcast) ptySize(fd int, isTerm bool) (width, height int) {
+	width, height = defaultTermWidth, defaultTermHeight
+	if isTerm {
+		if w, h, err := terminalSize(fd); err == nil && w > 0 && h > 0 {
+			width, height = w, h
+		}
+	}
+	return max(width-b.width-3, minBroadcastWidth), height
+}
+
+// Run starts a shell on every host and copies data between them and the
+// local ends until all sessions are finished. Hosts whose shell cannot be
+// started are reported and left out.
+func (b *Broadcast) Run() (err error) {
+	b.focus, b.lead = -1, -1
+	for _, h := range b.Hosts {
+		b.width = max(b.width, len(h.Name))
+	}
+
+	fd, isTerm := terminalFd(b.Stdin)
+	if isTerm {
+		raw := newRawMode(fd, b.Stderr)
+		if err = raw.enter(); err != nil {
+			return
+		}
+		defer raw.Close()
+	}
+	width, height := b.ptySize(fd, isTerm)
+
+	fmt.Fprintf(b.Stderr, "Broadcasting to %d hosts: Ctrl-] switches the input between all hosts and each single one, ~. disconnects.\r\n", len(b.Hosts))
+	var sessions sync.WaitGroup
+	started := 0
+	for i, h := range b.Hosts {
+		errs := b.start(i, width, height)
+		b.mu.Lock()
+		if errs != nil {
+			h.done = true
+		} else {
+			b.live++
+			if b.lead < 0 {
+				b.lead = i
+			}
+		}
+		b.mu.Unlock()
+		if errs != nil {
+			fmt.Fprintf(b.Stderr, "%s: %v\r\n", h.Name, errs)
+			continue
+		}
+		started++
+		sessions.Go(func() {
+			b.finish(i, h.Session.Wait())
+		})
+	}
+	if started == 0 {
+		return errors.New("no session could be started")
+	}
+
+	stop := make(chan struct{})
+	defer close(stop)
+	if isTerm {
+		sigwinchCh := make(chan os.Signal, 1)
+		notifyResize(sigwinchCh, fd, stop)
+		defer signal.Stop(sigwinchCh)
+		go b.watchResize(fd, sigwinchCh, stop)
+	}
+	sigCh := make(chan os.Signal, 1)
+	signal.Notify(sigCh, terminateSignals...)
+	defer signal.Stop(sigCh)
+	go func() {
+		select {
+		case <-sigCh:
+			b.hangUp()
+		case <-stop:
+		}
+	}()
+
+	go b.readInput()
+	sessions.Wait()
+	return
+}
+
+// start requests a PTY on host i and starts its shell.
+func (b *Broadcast) start(i int, width, height int) (err error) {
+	h := b.Hosts[i]
+	termType, modes := h.TermType, h.Modes
+	if termType == "" {
+		termType = localTermType()
+	}
+	if modes == nil {
+		modes = ssh.TerminalModes{}
+	}
+	if err = h.Session.RequestPty(termType, height, width, modes); err != nil {
+		return
+	}
+	if h.stdin, err = h.Session.StdinPipe(); err != nil {
+		return
+	}
+	h.Session.Stdout = &broadcastOutput{b: b, host: i}
+	h.Session.Stderr = h.Session.Stdout
+	if h.Command != "" {
+		return h.Session.Start(h.Command)
+	}
+	return h.Session.Shell()
+}
+
+// readInput sends the local keystrokes on until ~. hangs up every session
+// or the input ends, which is passed on as EOF.
+func (b *Broadcast) readInput() {
+	buf := make([]byte, 32*1024)
+	for {
+		n, err := b.Stdin.Read(buf)
+		var out []byte
+		for _, c := range buf[:n] {
+			if c == BroadcastToggle {
+				b.send(out)
+				out = nil
+				b.toggle()
+				continue
+			}
+			p, cmd := b.escape.feed(c)
+			if cmd == '.' {
+				b.send(out)
+				b.hangUp()
+				return
+			}
+			out = append(out, p...)
+		}
+		b.send(out)
+		if err != nil {
+			for _, h := range b.Hosts {
+				if h.stdin != nil {
+					_ = h.stdin.Close()
+				}
+			}
+			return
+		}
+	}
+}
+
+// watchResize passes local window size changes on to every host.
+func (b *Broadcast) watchResize(fd int, sigwinchCh <-chan os.Signal, stop <-chan struct{}) {
+	for {
+		select {
+		case <-stop:
+			return
+		case <-sigwinchCh:
+			width, height := b.ptySize(fd, true)
+			b.mu.Lock()
+			b.clearLead()
+			b.drawLead()
+			var sessions []*ssh.Session
+			for _, h := range b.Hosts {
+				if !h.done {
+					sessions = append(sessions, h.Session)
+				}
+			}
+			b.mu.Unlock()
+			for _, session := range sessions {
+				_ = session.WindowChange(height, width)
+			}
+		}
+	}
+}
@@ -0,0 +1,136 @@
+package sshtools
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// certificatePath returns the user certificate to present with the key at
+// keyPath: the configured one, or <key>-cert.pub next to the key as
+// OpenSSH does. Empty when there is none.
+func (s *Server) certificatePath(keyPath string) (string, error) {
+	if s.Certificate != "" {
+		return s.expandPath("certificate", s.Certificate)
+	}
+	if _, err := os.Stat(keyPath + "-cert.pub"); err == nil {
+		return keyPath + "-cert.pub", nil
+	}
+	return "", nil
+}
+
+// loadCertificate reads the user certificate at certPath and checks it is
+// valid now, so an expired one fails before dialing.
+func loadCertificate(certPath string, now time.Time) (cert *ssh.Certificate, err error) {
+	data, err := os.ReadFile(certPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read certificate %s: %v", certPath, err)
+	}
+	return parseCertificate(data, certPath, now)
+}
+
+// parseCertificate parses the user certificate data, named name in errors,
+// and checks it is valid now.
+func parseCertificate(data []byte, name string, now time.Time) (cert *ssh.Certificate, err error) {
+	key, _, _, _, err := ssh.ParseAuthorizedKey(data)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse certificate %s: %v", name, err)
+	}
+	cert, ok := key.(*ssh.Certificate)
+	if !ok {
+		return nil, fmt.Errorf("%s is a %s public key, not a certificate", name, key.Type())
+	}
+	if cert.CertType != ssh.UserCert {
+		return nil, fmt.Errorf("%s is a host certificate, not a user certificate", name)
+	}
+	unix := uint64(now.Unix())
+	if cert.ValidBefore != ssh.CertTimeInfinity && unix >= cert.ValidBefore {
+		return nil, fmt.Errorf("certificate %s expired at %s", name, certTime(cert.ValidBefore))
+	}
+	if unix < cert.ValidAfter {
+		return nil, fmt.Errorf("certificate %s is not valid until %s", name, certTime(cert.ValidAfter))
+	}
+	return
+}
+
+// certTime formats a certificate validity bound.
+func certTime(t uint64) string {
+	return time.Unix(int64(t), 0).Format(time.RFC3339)
+}
+
+// certSigner pairs signer with the certificate at certPath.
+func certSigner(signer ssh.Signer, certPath string) (ssh.Signer, error) {
+	cert, err := loadCertificate(certPath, time.Now())
+	if err != nil {
+		return nil, err
+	}
+	return pairCertificate(signer, cert, certPath)
+}
+
+// pairCertificate pairs signer with cert, named name in errors.
+func pairCertificate(signer ssh.Signer, cert *ssh.Certificate, name string) (ssh.Signer, error) {
+	if !bytes.Equal(cert.Key.Marshal(), signer.PublicKey().Marshal()) {
+		return nil, fmt.Errorf("certificate %s does not belong to the private key", name)
+	}
+	return ssh.NewCertSigner(cert, signer)
+}
+
+// parseCAKey reads a host_ca_keys entry: a public key as in
+// authorized_keys, or the path of a file holding one.
+func parseCAKey(value string) (key ssh.PublicKey, err error) {
+	if key, _, _, _, err = ssh.ParseAuthorizedKey([]byte(value)); err == nil {
+		return
+	}
+	path, err := ExpandPath(value)
+	if err != nil {
+		return nil, fmt.Errorf(`"host_ca_keys" entry %q: %v`, value, err)
+	}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf(`"host_ca_keys" entry %q is neither a public key nor an existing file`, value)
+	}
+	if err != nil {
+		return
+	}
+	if key, _, _, _, err = ssh.ParseAuthorizedKey(data); err != nil {
+		err = fmt.Errorf("failed to parse CA key %s: %v", path, err)
+	}
+	return
+}
+
+// trustHostCAs accepts host certificates signed by one of the server's
+// host_ca_keys. Plain host keys still go through the existing callback.
+func trustHostCAs(sshConfig *ssh.ClientConfig, server *Server) (err error) {
+	var authorities [][]byte
+	for _, value := range server.HostCAKeys {
+		key, errs := parseCAKey(value)
+		if errs != nil {
+			return errs
+		}
+		authorities = append(authorities, key.Marshal())
+	}
+	checker := &ssh.CertChecker{
+		IsHostAuthority: func(auth ssh.PublicKey, address string) bool {
+			for _, authority := range authorities {
+				if bytes.Equal(auth.Marshal(), authority) {
+					return true
+				}
+			}
+			return false
+		},
+		HostKeyFallback: sshConfig.HostKeyCallback,
+	}
+	sshConfig.HostKeyCallback = func(hostname string, remote net.Addr, key ssh.PublicKey) error {
+		err := checker.CheckHostKey(hostname, remote, key)
+		if _, isCert := key.(*ssh.Certificate); isCert && err != nil {
+			return fmt.Errorf("host certificate of %s rejected: %v", server.Alias, err)
+		}
+		return err
+	}
+	return
+}
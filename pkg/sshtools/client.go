@@ -0,0 +1,390 @@
+package sshtools
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// Client is an authenticated connection to a configured server.
+type Client struct {
+	*ssh.Client
+	Server *Server
+	// Env is sent with user sessions (see NewUserSession).
+	Env []EnvVar
+	// HostKey is the key the server presented.
+	HostKey ssh.PublicKey
+	// Become runs Exec commands through sudo, answering its prompt with
+	// BecomePassword.
+	Become         bool
+	BecomePassword string
+	// Stdin is copied to the stdin of Exec commands, which get none when it
+	// is nil. It is not forwarded with Become: stdin carries the password.
+	Stdin io.Reader
+	// ForwardAgent forwards the local ssh-agent to user sessions, as does
+	// the server's forward_agent.
+	ForwardAgent bool
+	// SkipPreamble drops what the login shell prints before Exec commands
+	// start, such as a MOTD echoed by an rc file. It relies on a POSIX
+	// shell: with others, all output is dropped.
+	SkipPreamble bool
+	// ForwardSignals relays SIGINT, SIGTERM and SIGQUIT received while an
+	// Exec command runs to the remote command, which then decides whether
+	// to exit. KillAfter, when set, kills it if it is still running that
+	// long after the first signal. A third signal closes the session.
+	ForwardSignals bool
+	KillAfter      time.Duration
+	// CommandTimeout kills Exec commands that run longer and reports them
+	// as timed out; the server's command_timeout applies when it is 0.
+	CommandTimeout time.Duration
+
+	conn   *countingConn
+	dialer *Dialer
+	// via is the jump host connection this one goes through.
+	via *Client
+	// lost is set when keepalives went unanswered; see Lost.
+	lost atomic.Value
+	// agentConn answers forwarded agent channels once agentOnce ran.
+	agentOnce sync.Once
+	agentConn io.Closer
+	agentErr  error
+	// connected is when Dial succeeded; postOnce runs local_post on Close.
+	connected time.Time
+	postOnce  sync.Once
+}
+
+// Dialer holds the per-invocation options used to connect to servers. The
+// zero value connects quietly with the settings from the server entry.
+type Dialer struct {
+	// Verbose enables diagnostics on Log: 1 logs the handshake and
+	// authentication, 2 also prints periodic throughput and latency.
+	Verbose int
+	// Log receives diagnostics; os.Stderr when nil.
+	Log io.Writer
+	// Timeout bounds the TCP connect and the SSH handshake separately; the
+	// server's connect_timeout or DefaultTimeout when zero.
+	Timeout time.Duration
+	// Attempts is how often connecting is tried; the server's
+	// connection_attempts when zero.
+	Attempts int
+	// OnRetry is told about each failed attempt that will be retried after
+	// wait.
+	OnRetry func(server *Server, attempt, attempts int, err error, wait time.Duration)
+	// AuthCache remembers, per alias, which auth method and key succeeded
+	// (in ~/.sshtools/auth-cache.json) and tries it first next time.
+	AuthCache bool
+	// PromptPassword asks for a password for servers configured without
+	// credentials, and for the passphrase of encrypted keys; password
+	// authentication is skipped when nil.
+	PromptPassword func(prompt string) (string, error)
+	// AskPassword prompts through PromptPassword even for servers with a
+	// configured password, which is then not used.
+	AskPassword bool
+	// Challenge answers keyboard-interactive prompts, such as one-time
+	// codes; echos tells which answers may be shown as they are typed.
+	// Only a password prompt answered from the config works when nil.
+	Challenge func(name, instruction string, questions []string, echos []bool) ([]string, error)
+	// KnownHosts is the known_hosts file host keys are checked against;
+	// KnownHostsPath when empty.
+	KnownHosts string
+	// ConfirmHostKey asks whether to trust the key of a server not in
+	// known_hosts; unknown keys are refused when nil.
+	ConfirmHostKey func(server *Server, key ssh.PublicKey) bool
+	// AcceptChangedHostKey replaces a known_hosts entry whose key changed
+	// instead of refusing to connect.
+	AcceptChangedHostKey bool
+	// Legacy offers the insecure algorithms to every server, as if it had
+	// legacy_algorithms set.
+	Legacy bool
+	// AuthMethods is the ordered auth chain used for every server instead
+	// of its auth_methods, e.g. from -auth.
+	AuthMethods []string
+	// Family restricts connections to FamilyInet or FamilyInet6 addresses,
+	// where a server's address_family only sets which is tried first.
+	Family string
+	// Prewarmed supplies connections opened while the user chose a server.
+	Prewarmed *Prewarmer
+}
+
+// ClientConfig builds the ssh.ClientConfig for server, including its auth methods.
+func ClientConfig(server *Server) (*ssh.ClientConfig, error) {
+	return (&Dialer{}).ClientConfig(server)
+}
+
+// ClientConfig builds the ssh.ClientConfig for server, including its auth methods.
+func (d *Dialer) ClientConfig(server *Server) (*ssh.ClientConfig, error) {
+	return d.clientConfig(server, &authTrace{})
+}
+
+func (d *Dialer) clientConfig(server *Server, trace *authTrace) (sshConfig *ssh.ClientConfig, err error) {
+	sshConfig = &ssh.ClientConfig{
+		User: server.User,
+		Auth: []ssh.AuthMethod{},
+	}
+	// 按 strict_host_key_checking 对照 known_hosts 校验主机密钥
+	if sshConfig.HostKeyCallback, err = d.verifyHostKey(server); err != nil {
+		return
+	}
+	algorithms := server.Algorithms
+	algorithms.Legacy = algorithms.Legacy || d.Legacy
+	algorithms.apply(sshConfig)
+	// 配置了 host_key_fingerprint 时只接受该主机密钥，否则可先对照 DNS 中的 SSHFP 记录
+	if server.HostKeyFingerprint != "" {
+		if err = pinHostKey(sshConfig, server); err != nil {
+			return
+		}
+	} else if server.VerifyHostKeyDNS == DNSVerifyYes || server.VerifyHostKeyDNS == DNSVerifyRequire {
+		d.verifySSHFP(sshConfig, server)
+	}
+	// 接受 host_ca_keys 中的 CA 签发的主机证书
+	if len(server.HostCAKeys) > 0 {
+		if err = trustHostCAs(sshConfig, server); err != nil {
+			return
+		}
+	}
+	hostKeyCallback := sshConfig.HostKeyCallback
+	sshConfig.HostKeyCallback = func(hostname string, remote net.Addr, key ssh.PublicKey) error {
+		trace.hostKey = key
+		return hostKeyCallback(hostname, remote, key)
+	}
+
+	// 配置了 auth_methods 或 -auth 时只按其顺序认证
+	if methods := d.authMethods(server); len(methods) > 0 {
+		if sshConfig.Auth, err = d.authChain(server, trace, methods); err != nil {
+			return
+		}
+		d.instrument(sshConfig)
+		return
+	}
+
+	var identities []identity
+	var password ssh.AuthMethod
+	// askedAgent is set once the keys of ssh-agent are among identities.
+	askedAgent := server.UseAgent
+
+	// 先提供 ssh-agent 中的密钥，再按其余配置认证
+	if server.UseAgent {
+		if identities, err = d.agentIdentities(trace); err != nil {
+			if server.UseKey || server.Password != "" || d.PromptPassword != nil || d.Challenge != nil {
+				d.Logf(1, "ssh-agent unavailable: %v", err)
+				err = nil
+			} else {
+				err = fmt.Errorf(`"use_agent" is set but ssh-agent is unavailable: %v`, err)
+				return
+			}
+		}
+	}
+
+	// 使用密钥认证，key_source 提供的密钥优先
+	if server.vault.hasKeys() {
+		identities = append(identities, server.vault.identities...)
+	} else if server.UseKey {
+		keys, errs := d.keyIdentities(server, trace)
+		if errs != nil {
+			err = errs
+			return
+		}
+		identities = append(identities, keys...)
+	} else if server.Password != "" && d.AskPassword {
+		// 指定 -ask-pass 时忽略配置中的密码，改为交互式输入
+		if d.PromptPassword != nil {
+			password = d.promptedPassword(trace, server.User, server.Address)
+		}
+	} else if server.Password != "" {
+		password = d.password(trace, server.Password)
+	} else if server.UseAgent {
+		if d.PromptPassword != nil {
+			password = d.promptedPassword(trace, server.User, server.Address)
+		}
+	} else {
+		// 未配置凭据：依次尝试 ssh-agent、默认密钥、交互式输入密码
+		identities, askedAgent = d.defaultIdentities(trace), true
+		if d.PromptPassword != nil {
+			password = d.promptedPassword(trace, server.User, server.Address)
+		}
+	}
+
+	// 优先尝试上次成功的认证方式
+	passwordFirst := false
+	if cached, ok := d.cachedAuth(server.Alias); ok {
+		switch {
+		case cached.Method == "publickey" && preferCached(identities, cached.Fingerprint):
+			d.Logf(1, "trying cached method first (publickey %s)", cached.Fingerprint)
+		case cached.Method == "password" && password != nil:
+			d.Logf(1, "trying cached method first (password)")
+			passwordFirst = true
+		}
+	}
+	if password != nil && passwordFirst {
+		sshConfig.Auth = append(sshConfig.Auth, password)
+	}
+	if len(identities) > 0 {
+		sshConfig.Auth = append(sshConfig.Auth, d.publicKeys(trace, identities...))
+	}
+	// PKCS#11 模块中的密钥：先试 ssh-agent 中已有的，被拒绝时才加载模块并输入 PIN
+	if server.PKCS11Module != "" {
+		module, errs := server.expandPath("pkcs11_module", server.PKCS11Module)
+		if errs != nil {
+			err = errs
+			return
+		}
+		if !askedAgent {
+			if agentKeys, errs := d.agentIdentities(trace); errs == nil && len(agentKeys) > 0 {
+				sshConfig.Auth = append(sshConfig.Auth, d.publicKeys(trace, agentKeys...))
+			}
+		}
+		sshConfig.Auth = append(sshConfig.Auth, d.pkcs11Keys(trace, module))
+	}
+	if password != nil && !passwordFirst {
+		sshConfig.Auth = append(sshConfig.Auth, password)
+	}
+	// 最后尝试 keyboard-interactive，也用于密钥之后的二次验证
+	if server.Password != "" || d.Challenge != nil {
+		sshConfig.Auth = append(sshConfig.Auth, d.keyboardInteractive(trace, server, false))
+	}
+
+	d.instrument(sshConfig)
+	return
+}
+
+// Addr returns the host:port pair used to dial server.
+func (s *Server) Addr() string {
+	// 拼接地址和端口
+	return net.JoinHostPort(s.Address, strconv.Itoa(s.Port))
+}
+
+// Dial connects and authenticates to server.
+func Dial(server *Server) (*Client, error) {
+	return (&Dialer{}).Dial(server)
+}
+
+// dial makes a single attempt to connect and authenticate to server.
+// answered is set once the server presented its host key, so a failure
+// happened during authentication rather than on the way there.
+func (d *Dialer) dial(server *Server) (c *Client, answered bool, err error) {
+	trace := &authTrace{}
+	sshConfig, err := d.clientConfig(server, trace)
+	if err != nil {
+		return
+	}
+	defer func() {
+		answered = trace.hostKey != nil
+		for _, conn := range trace.agents {
+			_ = conn.Close()
+		}
+	}()
+
+	// 配置了 proxy_jump 时先连接跳板机，再通过它建立连接
+	var via *Client
+	if len(server.JumpHosts) > 0 {
+		if via, err = d.dialJumps(server); err != nil {
+			return
+		}
+		defer func() {
+			if err != nil {
+				_ = via.Close()
+			}
+		}()
+	}
+
+	cands, failures := d.candidates(server)
+	kinds := make([]ErrorKind, len(failures))
+	timeout := d.addressTimeout(server, len(cands))
+	single := len(cands) == 1 && len(failures) == 0
+	choices := len(cands)
+	// 各地址的 TCP 连接交错并行（happy eyeballs），握手只在先连上的地址上进行
+	for len(cands) > 0 {
+		conn, cand, rest, failed := d.connectFirst(server, cands, timeout, via)
+		for _, f := range failed {
+			if single {
+				err = &Error{Kind: KindOf(f.err), Err: fmt.Errorf("failed to connect to server %s: %v", server.Addr(), f.err)}
+				return
+			}
+			d.Logf(1, "%s: %v", f.cand, f.err)
+			failures = append(failures, fmt.Sprintf("%s: %v", f.cand, f.err))
+			kinds = append(kinds, KindOf(f.err))
+		}
+		if conn == nil {
+			break
+		}
+		c, err = d.handshake(server, cand, conn, timeout, sshConfig, trace)
+		if err == nil {
+			c.via = via
+			rememberAddress(server, cand, choices)
+			return
+		}
+		// Another address won't accept credentials this one rejected.
+		if single || isFinal(err) {
+			err = &Error{Kind: KindOf(err), Err: fmt.Errorf("failed to connect to server %s: %v", server.Addr(), err)}
+			return
+		}
+		d.Logf(1, "%s: %v", cand, err)
+		failures = append(failures, fmt.Sprintf("%s: %v", cand, err))
+		kinds = append(kinds, KindOf(err))
+		cands = rest
+	}
+	err = allFailed(server, failures, kinds)
+	return
+}
+
+// handshake authenticates to server over conn, connected to cand.
+func (d *Dialer) handshake(server *Server, cand dialCandidate, conn net.Conn, timeout time.Duration, sshConfig *ssh.ClientConfig, trace *authTrace) (c *Client, err error) {
+	d.Logf(1, "connection established from %s", conn.LocalAddr())
+	counted := &countingConn{Conn: conn}
+
+	// 握手超时：代理连接不支持 deadline，超时直接关闭连接
+	var timedOut atomic.Bool
+	trace.timer = time.AfterFunc(timeout, func() {
+		timedOut.Store(true)
+		_ = conn.Close()
+	})
+	address := net.JoinHostPort(cand.address, strconv.Itoa(server.Port))
+	sshConn, chans, reqs, err := ssh.NewClientConn(counted, address, sshConfig)
+	trace.timer.Stop()
+	if err != nil && timedOut.Load() {
+		err = &Error{Kind: ErrorTimeout, Err: fmt.Errorf("handshake timed out after %s", timeout)}
+	}
+	if err != nil {
+		if proxy, ok := conn.(*proxyConn); ok {
+			if errs := proxy.exitError(); errs != nil {
+				err = errs
+			}
+		}
+		_ = conn.Close()
+		return
+	}
+	d.logHandshake(sshConn, trace)
+	d.rememberAuth(server.Alias, trace.succeeded())
+
+	c = &Client{Client: ssh.NewClient(d.traceConn(sshConn, chans, reqs)), Server: server, HostKey: trace.hostKey, conn: counted, dialer: d}
+	if d.Verbose >= 2 {
+		go d.monitor(c)
+	}
+	if interval, countMax := server.Keepalive(); interval > 0 {
+		go c.keepalive(interval, countMax)
+	}
+	return
+}
+
+// Shell opens a new session and runs an interactive shell on it until the
+// remote side exits.
+func (c *Client) Shell(stdin io.Reader, stdout, stderr io.Writer) (err error) {
+	session, err := c.NewSession()
+	if err != nil {
+		err = fmt.Errorf("failed to create session on server %s: %v", c.Server.Addr(), err)
+		return
+	}
+	defer func(session *ssh.Session) {
+		if errs := session.Close(); errs != nil && errs != io.EOF {
+			fmt.Fprintln(stderr, errs.Error())
+		}
+	}(session)
+
+	return NewTerminal(session, stdin, stdout, stderr).Run()
+}
@@ -0,0 +1,172 @@
+package sshtools
+
+import (
+	"bytes"
+	"encoding/base64"
+	"io"
+	"os"
+	"os/exec"
+	"runtime"
+)
+
+// osc52Start begins the OSC 52 sequence with which remote programs such as
+// tmux, vim or neovim set the clipboard of the terminal.
+const osc52Start = "\x1b]52;"
+
+// osc52Max bounds a sequence; longer ones are dropped rather than held in
+// memory until they end.
+const osc52Max = 1 << 20
+
+// clipboardWriter passes output through to w without OSC 52 sequences.
+// When allowed, the text of each one is put on the local clipboard instead,
+// or, without a clipboard command, the sequence is passed on for the
+// terminal to handle. Requests to read the clipboard are always dropped.
+type clipboardWriter struct {
+	w     io.Writer
+	allow bool
+	copy  func(text []byte) error
+	log   func(level int, format string, args ...any)
+
+	// held is a partial start or an unfinished sequence; dropping skips an
+	// oversized one until its end.
+	held     []byte
+	dropping bool
+}
+
+// newClipboardWriter returns a clipboardWriter using the local clipboard
+// command, if there is one.
+func newClipboardWriter(w io.Writer, allow bool, log func(level int, format string, args ...any)) *clipboardWriter {
+	c := &clipboardWriter{w: w, allow: allow, log: log}
+	if allow {
+		c.copy = clipboardCommand()
+	}
+	return c
+}
+
+func (c *clipboardWriter) Write(p []byte) (n int, err error) {
+	n = len(p)
+	data := p
+	if len(c.held) > 0 {
+		data = append(c.held, p...)
+		c.held = nil
+	}
+	for len(data) > 0 {
+		if c.dropping {
+			end, size := osc52End(data)
+			if end < 0 {
+				return
+			}
+			c.dropping = false
+			data = data[end+size:]
+			continue
+		}
+		i := bytes.Index(data, []byte(osc52Start))
+		if i < 0 {
+			// 末尾可能是序列开头的一部分，留到下次写入
+			keep := partialPrefix(data, osc52Start)
+			if _, err = c.w.Write(data[:len(data)-keep]); err != nil {
+				return
+			}
+			c.held = append([]byte(nil), data[len(data)-keep:]...)
+			return
+		}
+		if _, err = c.w.Write(data[:i]); err != nil {
+			return
+		}
+		seq := data[i:]
+		end, size := osc52End(seq[len(osc52Start):])
+		if end < 0 {
+			if len(seq) > osc52Max {
+				c.logf("dropped an OSC 52 sequence longer than %d bytes", osc52Max)
+				c.dropping = true
+				return
+			}
+			c.held = append([]byte(nil), seq...)
+			return
+		}
+		end += len(osc52Start)
+		if err = c.handle(seq[len(osc52Start):end], seq[:end+size]); err != nil {
+			return
+		}
+		data = seq[end+size:]
+	}
+	return
+}
+
+// handle acts on the body "selection;base64" of a complete sequence.
+func (c *clipboardWriter) handle(body, seq []byte) (err error) {
+	_, payload, _ := bytes.Cut(body, []byte(";"))
+	switch {
+	case !c.allow:
+		c.logf("dropped an OSC 52 clipboard sequence, set \"clipboard\" to allow it")
+		return
+	case string(payload) == "?":
+		c.logf("dropped an OSC 52 request to read the clipboard")
+		return
+	case c.copy == nil:
+		_, err = c.w.Write(seq)
+		return
+	}
+	text, errs := base64.StdEncoding.DecodeString(string(payload))
+	if errs != nil {
+		c.logf("dropped an OSC 52 sequence: %v", errs)
+		return
+	}
+	if errs = c.copy(text); errs != nil {
+		c.logf("failed to set the clipboard: %v", errs)
+	}
+	return
+}
+
+func (c *clipboardWriter) logf(format string, args ...any) {
+	if c.log != nil {
+		c.log(1, format, args...)
+	}
+}
+
+// osc52End returns the index of the BEL or ESC \ that ends the sequence in
+// data and the size of that terminator, or -1 when it has not ended yet.
+func osc52End(data []byte) (end, size int) {
+	for i, b := range data {
+		switch {
+		case b == '\a':
+			return i, 1
+		case b == 0x1b && i+1 < len(data) && data[i+1] == '\\':
+			return i, 2
+		}
+	}
+	return -1, 0
+}
+
+// clipboardCommand returns a func that puts text on the local clipboard
+// with the platform's command, or nil when there is none, e.g. on a
+// machine without a display reached over ssh itself.
+func clipboardCommand() func(text []byte) error {
+	var candidates [][]string
+	switch runtime.GOOS {
+	case "darwin":
+		candidates = [][]string{{"pbcopy"}}
+	case "windows":
+		// clip.exe mangles UTF-8, Windows Terminal handles OSC 52 itself
+		return nil
+	default:
+		if os.Getenv("WAYLAND_DISPLAY") != "" {
+			candidates = append(candidates, []string{"wl-copy"})
+		}
+		if os.Getenv("DISPLAY") != "" {
+			candidates = append(candidates, []string{"xclip", "-selection", "clipboard"}, []string{"xsel", "--clipboard", "--input"})
+		}
+	}
+	for _, args := range candidates {
+		path, err := exec.LookPath(args[0])
+		if err != nil {
+			continue
+		}
+		return func(text []byte) error {
+			cmd := exec.Command(path, args[1:]...)
+			cmd.Stdin = bytes.NewReader(text)
+			return cmd.Run()
+		}
+	}
+	return nil
+}
@@ -0,0 +1,355 @@
+package sshtools
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+)
+
+type Server struct {
+	Alias string `json:"alias"`
+	// Address 可以是单个地址或地址列表，列表时这里是第一个，Addresses 是全部
+	Address    string   `json:"address"`
+	Addresses  []string `json:"-"`
+	Port       int      `json:"port"`
+	User       string   `json:"user"`
+	Password   string   `json:"password,omitempty"`
+	PrivateKey string   `json:"private_key,omitempty"`
+	UseKey     bool     `json:"use_key"`
+	// PKCS11Module 智能卡或硬件令牌的 PKCS#11 模块（如 /usr/lib/opensc-pkcs11.so），通过 ssh-agent 加载其中的密钥
+	PKCS11Module string `json:"pkcs11_module,omitempty"`
+	// PasswordSource 为 "keychain" 时从系统钥匙串读取密码（使用密钥时为私钥口令），由 sshtools secret set 写入
+	PasswordSource string `json:"password_source,omitempty"`
+	// KeySource 连接时从 HashiCorp Vault 读取凭据（VAULT_ADDR、VAULT_TOKEN 或 ~/.vault-token）："vault:secret/ssh/web1" 读取 KV 中的 private_key、certificate、passphrase 和 password，
+	// SSH 引擎的 "vault:ssh-client-signer/sign/role" 为 private_key（未设置 use_key 时为临时生成的密钥）签发证书
+	KeySource string `json:"key_source,omitempty"`
+	// PassphraseCommand 私钥加密时运行此命令，取其输出的第一行作为口令（如从密码管理器读取）；未设置时在终端输入
+	PassphraseCommand string `json:"passphrase_command,omitempty"`
+	// UseAgent 先用 ssh-agent（Windows 上为 OpenSSH agent 或 Pageant）中的密钥认证，配置文件中无需密钥路径或密码
+	UseAgent bool `json:"use_agent,omitempty"`
+	// AuthMethods 按顺序尝试的认证方式，取代默认的认证顺序："agent"、"publickey"（private_key，未设置时为 ~/.ssh 中的默认密钥）、"keyboard-interactive"、"password"（配置的密码，没有时交互输入）；未设置时用全局配置
+	AuthMethods []string `json:"auth_methods,omitempty"`
+	// ForwardAgent 把本机 ssh-agent 转发到远程会话，便于从远程主机继续跳转；远程主机的 root 可借用其中的密钥，按需开启
+	ForwardAgent bool `json:"forward_agent,omitempty"`
+	// Certificate 与私钥一起提供的用户证书（-cert.pub），未设置时自动查找 <private_key>-cert.pub
+	Certificate string `json:"certificate,omitempty"`
+	// HostKeyFingerprint 固定主机密钥："SHA256:..." 指纹（可带密钥类型前缀）或完整公钥
+	HostKeyFingerprint string `json:"host_key_fingerprint,omitempty"`
+	// HostCAKeys 信任这些 CA 公钥（或公钥文件路径）签发的主机证书，未设置时用全局配置
+	HostCAKeys []string `json:"host_ca_keys,omitempty"`
+	// StrictHostKeyChecking 对照 known_hosts 校验主机密钥：ask（默认，终端中确认新主机）、yes、accept-new 或 no，未设置时用全局配置
+	StrictHostKeyChecking string `json:"strict_host_key_checking,omitempty"`
+	// VerifyHostKeyDNS 对照地址的 SSHFP 记录校验主机密钥：no（默认）、yes（匹配经 DNSSEC 验证的记录即信任，否则用 known_hosts）或 require（只接受匹配的记录）；DNSResolver 为查询用的解析器，默认 /etc/resolv.conf 中的第一个；未设置时用全局配置
+	VerifyHostKeyDNS string `json:"verify_host_key_dns,omitempty"`
+	DNSResolver      string `json:"dns_resolver,omitempty"`
+	// 握手时提供的算法（host_key_algorithms、kex_algorithms、ciphers、macs），未设置时用全局配置或 x/crypto 默认值；legacy_algorithms 另外提供旧设备需要的不安全算法
+	Algorithms
+	// Compression 只用于导出，写为 Compression yes；Go 的 SSH 库只支持 none，sshtools 自己的连接不压缩
+	Compression bool `json:"compression,omitempty"`
+	// AddressFamily 域名解析出多个地址时优先的地址族：any（默认）、inet 或 inet6
+	AddressFamily string `json:"address_family,omitempty"`
+	// Tags 用于批量操作时按标签选择服务器
+	Tags []string `json:"tags,omitempty"`
+
+	// Proxy 经 HTTP CONNECT 或 SOCKS5 代理连接：http://[用户:密码@]host:port 或 socks5://...，"none" 表示直连；未设置时用全局配置
+	Proxy string `json:"proxy,omitempty"`
+	// ProxyCommand 通过本地命令的 stdin/stdout 建立连接，支持 %h %p %r
+	ProxyCommand string `json:"proxy_command,omitempty"`
+	// Transport 为 "ssm" 时经 AWS Systems Manager Session Manager（AWS-StartSSHSession 文档）连接 InstanceID 指定的 EC2 实例，实例无需开放 SSH 端口，本机需安装 session-manager-plugin；
+	// AWSRegion、AWSProfile 选择区域和凭据配置，未设置时用 AWS 的默认值。address 可省略，默认为实例 ID
+	Transport  string `json:"transport,omitempty"`
+	InstanceID string `json:"instance_id,omitempty"`
+	AWSRegion  string `json:"aws_region,omitempty"`
+	AWSProfile string `json:"aws_profile,omitempty"`
+	// WebSocket 经 WebSocket 网关（wss://host/path，支持 %h %p %r %n）传输 SSH 字节流，用于只放行 HTTPS 的网络；设置了 proxy 时经代理连接网关。
+	// WebSocketToken 作为 Bearer 令牌发送，WebSocketHeaders 为握手时附加的请求头，两者的值支持 ${env:NAME}
+	WebSocket        string            `json:"websocket,omitempty"`
+	WebSocketToken   string            `json:"websocket_token,omitempty"`
+	WebSocketHeaders map[string]string `json:"websocket_headers,omitempty"`
+	// ProxyJump 经跳板机连接：另一台服务器的别名，多级跳板用逗号分隔（如 "bastion1,bastion2"）
+	ProxyJump string `json:"proxy_jump,omitempty"`
+	// JumpHosts 是校验配置时由 ProxyJump 解析出的完整跳板链
+	JumpHosts []*Server `json:"-"`
+	// KeepaliveInterval 每隔多久发送一次 keepalive 请求（如 "30s"，"0" 关闭），连续 KeepaliveCountMax 次（默认 3）无回应时断开；未设置时用全局配置
+	KeepaliveInterval string `json:"keepalive_interval,omitempty"`
+	KeepaliveCountMax int    `json:"keepalive_count_max,omitempty"`
+	// ConnectTimeout 连接和握手各自的超时（如 "10s"，默认 15s），ConnectionAttempts 连接失败时最多尝试几次（默认 1），每次重试的间隔从 1s 起翻倍；未设置时用全局配置
+	ConnectTimeout     string `json:"connect_timeout,omitempty"`
+	ConnectionAttempts int    `json:"connection_attempts,omitempty"`
+	// ControlPersist 启用连接复用，空闲多久后主连接退出（如 "60s"，"yes" 表示一直保持）
+	ControlPersist string `json:"control_persist,omitempty"`
+	// MacAddress 使用 -wake 时连接前发送网络唤醒包并等待 SSH 端口可用；WakeBroadcast 为发送地址（默认 255.255.255.255:9），WakeRelay 为代为发送的同网段服务器别名
+	MacAddress        string `json:"mac_address,omitempty"`
+	WakeBroadcastAddr string `json:"wake_broadcast,omitempty"`
+	WakeRelay         string `json:"wake_relay,omitempty"`
+	// LocalPre 连接前在本机通过 shell 运行（如启动 VPN、发送审计日志），失败时不连接；LocalPost 连接关闭后运行。两者支持 %h %p %r %n
+	LocalPre  string `json:"local_pre,omitempty"`
+	LocalPost string `json:"local_post,omitempty"`
+	// StayConnected 交互会话结束后保持连接多久（如 "10m"），期间 exec、put 等命令经控制套接字复用它
+	StayConnected string `json:"stay_connected,omitempty"`
+
+	// RemoteCommand 在分配的 PTY 上代替登录 shell 运行（如 psql、htop）
+	RemoteCommand string `json:"remote_command,omitempty"`
+	// AttachSession 交互会话进入远程 tmux 或 screen 会话（"tmux"、"screen" 或 "tmux:名称"），不存在时创建，断线重连后回到同一会话；"none" 不使用全局默认值
+	AttachSession string `json:"attach_session,omitempty"`
+	// Banner 连接时在 shell 启动前显示的模板，如 "{{.User}}@{{.Alias}} ({{.Tags}})"
+	Banner      string `json:"banner,omitempty"`
+	BannerColor string `json:"banner_color,omitempty"`
+	// Label 环境标签（如 "PROD"），连接时以横幅颜色醒目显示，会话期间保留在本地终端最后一行；未设置时用 tag_labels
+	Label string `json:"label,omitempty"`
+	// SetEnv 通过 Setenv 发送给交互会话和 exec 命令的环境变量
+	SetEnv map[string]string `json:"set_env,omitempty"`
+	// OnConnect 在主会话之前通过单独的会话依次执行
+	OnConnect []string `json:"on_connect,omitempty"`
+	// LocalForwards 连接后自动建立的本地端口转发，端口 0 表示任意空闲端口
+	LocalForwards []Forward `json:"local_forwards,omitempty"`
+	// RemoteForwards 连接后请求服务器监听 remote，并把连接转发到本地的 local（反向转发）
+	RemoteForwards []Forward `json:"remote_forwards,omitempty"`
+	// DynamicForwards 连接后在这些 [bind:]port 上开启 SOCKS5 代理，流量经服务器转发
+	DynamicForwards []string `json:"dynamic_forwards,omitempty"`
+	// GatewayPorts 让只写端口、未指定绑定地址的转发监听所有网卡而不是 127.0.0.1，供局域网其他机器使用；远程转发还需要服务器 sshd_config 中 GatewayPorts clientspecified
+	GatewayPorts bool `json:"gateway_ports,omitempty"`
+	// VPN 由 sshtools vpn 使用：本机和服务器各建一个 tun 设备组成点对点链路，routes 中的网段经此链路转发
+	VPN *VPN `json:"vpn,omitempty"`
+	// ExpectScript 交互会话开始时依次等待输出匹配 expect 并发送 send，用于设备自动登录
+	ExpectScript []ExpectStep `json:"expect_script,omitempty"`
+	// Become 使用 -b 时通过 sudo 提权，密码只从环境变量或交互输入获取
+	Become *Become `json:"become,omitempty"`
+	// IdleTimeout 无键盘输入多久后（如 "15m"）断开，IdleAction 为 "lock" 时改为锁屏，需重新输入密码，为 "command" 时只发送 IdleCommand
+	IdleTimeout string `json:"idle_timeout,omitempty"`
+	IdleAction  string `json:"idle_action,omitempty"`
+	// IdleCommand 超时时先作为一行输入发送到远程会话，如 "clear" 或 "exit"，未设置时用全局配置
+	IdleCommand string `json:"idle_command,omitempty"`
+	// CommandTimeout exec 等命令在远程运行超过多久（如 "5m"）后杀掉并报告超时，SessionMaxDuration 交互会话最长持续多久（如 "8h"），到期前一分钟提示后断开；未设置时用全局配置
+	CommandTimeout     string `json:"command_timeout,omitempty"`
+	SessionMaxDuration string `json:"session_max_duration,omitempty"`
+	// PasteDelayMs 粘贴大段文本时每行之间的延迟（毫秒），用于串口等慢速目标
+	PasteDelayMs int `json:"paste_delay_ms,omitempty"`
+	// Clipboard 允许远程程序通过 OSC 52 设置本地剪贴板，未开启时从输出中去掉这些序列
+	Clipboard bool `json:"clipboard,omitempty"`
+	// Term 交互会话请求 PTY 时的 TERM，代替本地的 TERM，用于只认 "xterm" 或 "vt100" 的设备
+	Term string `json:"term,omitempty"`
+	// TerminalModes 请求 PTY 时发送的终端模式，键为 RFC 4254 中的名称，如 {"ECHO": 0, "TTY_OP_ISPEED": 9600}，BAUD 同时设置输入输出速率
+	TerminalModes map[string]uint32 `json:"terminal_modes,omitempty"`
+	// Triggers 交互会话输出的某一行匹配 pattern 时发送桌面通知（notify_command）或响铃
+	Triggers []OutputTrigger `json:"triggers,omitempty"`
+
+	// 已废弃的别名：提示、可重定向到新别名，过了 sunset 日期后拒绝连接
+	Deprecated bool   `json:"deprecated,omitempty"`
+	RedirectTo string `json:"redirect_to,omitempty"`
+	Sunset     string `json:"sunset,omitempty"`
+
+	// Default 不带参数运行 sshtools 时直接连接此服务器，最多一个；Favorite 在选择列表中排在最前并以 * 标出
+	Default  bool `json:"default,omitempty"`
+	Favorite bool `json:"favorite,omitempty"`
+
+	// Provider 由 sshtools discover 写入，记录此服务器从哪个 providers 条目发现，再次发现时更新其地址
+	Provider string `json:"provider,omitempty"`
+
+	// unresolved is why Substitute could not resolve a value; dialing the
+	// server fails with it.
+	unresolved error
+	// vault holds the keys fetched for KeySource.
+	vault *vaultCredentials
+}
+
+type Config struct {
+	Servers []Server `json:"servers"`
+
+	// 长时间操作完成后的桌面通知
+	NotifyCommand   string `json:"notify_command,omitempty"`
+	NotifyThreshold string `json:"notify_threshold,omitempty"`
+	NotifyBell      bool   `json:"notify_bell,omitempty"`
+	// 所有服务器的交互会话共用的输出触发器，排在服务器自己的之前
+	Triggers []OutputTrigger `json:"triggers,omitempty"`
+
+	DefaultControlPersist string `json:"control_persist,omitempty"`
+	DefaultStayConnected  string `json:"stay_connected,omitempty"`
+	// 所有服务器默认的 attach_session，服务器可用 "none" 关闭
+	DefaultAttachSession string `json:"attach_session,omitempty"`
+	// ZmodemDir 交互会话中 sz 发送的文件保存到此目录，默认当前目录，"none" 关闭检测
+	ZmodemDir string `json:"zmodem_dir,omitempty"`
+	// 所有服务器默认的出站代理（不用于设置了 proxy_command 或 proxy_jump 的服务器）
+	Proxy string `json:"proxy,omitempty"`
+	// 所有服务器默认的 keepalive 间隔和最多无回应次数
+	KeepaliveInterval string `json:"keepalive_interval,omitempty"`
+	KeepaliveCountMax int    `json:"keepalive_count_max,omitempty"`
+	// 所有服务器默认的连接超时和尝试次数
+	ConnectTimeout     string `json:"connect_timeout,omitempty"`
+	ConnectionAttempts int    `json:"connection_attempts,omitempty"`
+	// 所有服务器默认的 idle_timeout、idle_action 和 idle_command，便于统一满足合规要求
+	IdleTimeout string `json:"idle_timeout,omitempty"`
+	IdleAction  string `json:"idle_action,omitempty"`
+	IdleCommand string `json:"idle_command,omitempty"`
+	// 所有服务器默认的 command_timeout 和 session_max_duration，用于无人值守的自动化
+	CommandTimeout     string `json:"command_timeout,omitempty"`
+	SessionMaxDuration string `json:"session_max_duration,omitempty"`
+	// RedactPatterns 会话录制和日志中要遮盖的内容（正则表达式，有分组时只遮盖第一个分组），在内置的 AWS、GitHub 等密钥格式之外
+	RedactPatterns []string `json:"redact_patterns,omitempty"`
+
+	// 所有服务器默认的 auth_methods
+	AuthMethods []string `json:"auth_methods,omitempty"`
+	// 所有服务器默认的握手算法
+	Algorithms
+	// 所有服务器默认信任的主机证书 CA
+	HostCAKeys []string `json:"host_ca_keys,omitempty"`
+	// 所有服务器默认的 strict_host_key_checking
+	StrictHostKeyChecking string `json:"strict_host_key_checking,omitempty"`
+	// 所有服务器默认的 verify_host_key_dns 和 SSHFP 查询用的解析器（应为本机或内网中做 DNSSEC 验证的解析器）
+	VerifyHostKeyDNS string `json:"verify_host_key_dns,omitempty"`
+	DNSResolver      string `json:"dns_resolver,omitempty"`
+
+	// Providers 从云平台发现服务器（如 AWS 中运行的 EC2 实例），用 sshtools discover 写入配置或用 -provider 临时加入
+	Providers []ProviderConfig `json:"providers,omitempty"`
+	// Tunnels 命名的端口转发，由 sshtools tunnels up 在一个后台进程中全部建立，断开后自动重连
+	Tunnels []TunnelConfig `json:"tunnels,omitempty"`
+	// ControlAPI 控制主进程和 tunnels up 的后台进程在 ~/.sshtools/sockets/api 下的套接字上提供 REST 接口，供编辑器插件等本机工具调用
+	ControlAPI bool `json:"control_api,omitempty"`
+
+	// PreventSleep 在传输、批量执行和隧道期间阻止本机休眠
+	PreventSleep bool `json:"prevent_sleep,omitempty"`
+	// Include 加载时合并这些文件（可用通配符，相对路径以本文件所在目录为准）中的 servers，如 ["work/*.json"]
+	Include []string `json:"include,omitempty"`
+	// ImportSSHConfig 加载时合并此 OpenSSH 配置文件（如 ~/.ssh/config）中的 Host，同名时以本文件为准
+	ImportSSHConfig string `json:"import_ssh_config,omitempty"`
+	// DisableAuthCache 不记录每个别名上次成功的认证方式（仅方法名和密钥指纹）
+	DisableAuthCache bool `json:"disable_auth_cache,omitempty"`
+
+	// 默认的连接横幅和颜色；TagColors 按标签指定横幅颜色（如 production: red）
+	Banner      string            `json:"banner,omitempty"`
+	BannerColor string            `json:"banner_color,omitempty"`
+	TagColors   map[string]string `json:"tag_colors,omitempty"`
+	// TagLabels 按标签指定环境标签（如 prod: "PRODUCTION"），DisableStatusLine 只在连接时显示标签，不占用终端最后一行
+	TagLabels         map[string]string `json:"tag_labels,omitempty"`
+	DisableStatusLine bool              `json:"disable_status_line,omitempty"`
+	// PickerPrewarm 交互式选择服务器时在后台预先建立到选中和最常用服务器的 TCP 连接，并标出列表中各服务器是否可达
+	PickerPrewarm bool `json:"picker_prewarm,omitempty"`
+	// SetTitle 会话期间把本地终端窗口标题设为 user@alias
+	SetTitle bool `json:"set_title,omitempty"`
+
+	// Commands 命名的命令模板（如 "restart-svc": "sudo systemctl restart {{.service}}"），由 sshtools run 执行；TagVars 按标签给出模板变量的默认值
+	Commands map[string]string            `json:"commands,omitempty"`
+	TagVars  map[string]map[string]string `json:"tag_vars,omitempty"`
+
+	// Encryption 由 sshtools config encrypt 写入；服务器的 password 和 private_key 加密保存，用主密码解密
+	Encryption *Encryption `json:"encryption,omitempty"`
+
+	// Warnings found when the config was loaded, e.g. missing key files.
+	Warnings []Problem `json:"-"`
+	// secretKey decrypts and encrypts values once Unlock succeeded.
+	secretKey []byte
+}
+
+// LoadConfig reads the server list from filename, JSON, YAML or TOML by
+// its extension, together with the files it includes, and validates it.
+// All errors are reported together in a *ValidationError; warnings are
+// kept in the returned config's Warnings.
+func LoadConfig(filename string) (*Config, error) {
+	config, problems, err := ParseConfigFile(filename)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", filename, err)
+	}
+	var errs []Problem
+	for _, p := range problems {
+		if p.Warning {
+			config.Warnings = append(config.Warnings, p)
+		} else {
+			errs = append(errs, p)
+		}
+	}
+	if len(errs) > 0 {
+		return nil, &ValidationError{File: filename, Problems: errs}
+	}
+	return config, nil
+}
+
+// ServerByAlias returns the server whose alias matches case-insensitively
+// (see AliasEqual), or nil.
+func (c *Config) ServerByAlias(alias string) *Server {
+	for i := range c.Servers {
+		if AliasEqual(c.Servers[i].Alias, alias) {
+			return &c.Servers[i]
+		}
+	}
+	return nil
+}
+
+// DefaultServer returns the server marked default, or nil.
+func (c *Config) DefaultServer() *Server {
+	for i := range c.Servers {
+		if c.Servers[i].Default {
+			return &c.Servers[i]
+		}
+	}
+	return nil
+}
+
+// ServerByAddress returns the first server configured with address, or nil.
+func (c *Config) ServerByAddress(address string) *Server {
+	for i := range c.Servers {
+		if slices.Contains(c.Servers[i].AddressList(), address) {
+			return &c.Servers[i]
+		}
+	}
+	return nil
+}
+
+// redacted replaces secrets in reports and logs.
+const redacted = "[redacted]"
+
+// Redacted returns a copy of s with secrets replaced, safe to log or share.
+// Key paths and commands are kept; the password, set_env values and
+// secret expect_script responses are not.
+func (s Server) Redacted() Server {
+	if s.Password != "" {
+		s.Password = redacted
+	}
+	if len(s.SetEnv) > 0 {
+		env := make(map[string]string, len(s.SetEnv))
+		for name := range s.SetEnv {
+			env[name] = redacted
+		}
+		s.SetEnv = env
+	}
+	if len(s.ExpectScript) > 0 {
+		steps := slices.Clone(s.ExpectScript)
+		for i := range steps {
+			if steps[i].Secret {
+				steps[i].Send = redacted
+			}
+		}
+		s.ExpectScript = steps
+	}
+	return s
+}
+
+// Secrets returns the secrets of s that Redacted hides.
+func (s *Server) Secrets() (secrets []string) {
+	if s.Password != "" {
+		secrets = append(secrets, s.Password)
+	}
+	for _, value := range s.SetEnv {
+		if value != "" {
+			secrets = append(secrets, value)
+		}
+	}
+	for _, step := range s.ExpectScript {
+		if step.Secret && step.Send != "" {
+			secrets = append(secrets, step.Send)
+		}
+	}
+	return
+}
+
+// RedactText replaces the secrets of s that appear in text.
+func (s *Server) RedactText(text string) string {
+	for _, secret := range s.Secrets() {
+		text = strings.ReplaceAll(text, secret, redacted)
+	}
+	return text
+}
@@ -0,0 +1,137 @@
+package sshtools
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// PublicKey is a local key to install on servers.
+type PublicKey struct {
+	Key     ssh.PublicKey
+	Comment string
+	// Path is the .pub file, PrivateKey the key next to it or "" when there
+	// is none (e.g. it only lives in ssh-agent).
+	Path       string
+	PrivateKey string
+}
+
+// AuthorizedLine returns the key as an authorized_keys line.
+func (k *PublicKey) AuthorizedLine() string {
+	line := strings.TrimSpace(string(ssh.MarshalAuthorizedKey(k.Key)))
+	if k.Comment != "" {
+		line += " " + k.Comment
+	}
+	return line
+}
+
+// ReadPublicKey reads the public key at path, which may also name the
+// private key whose .pub file sits next to it. With an empty path, the
+// server's private_key or else the first default identity is used.
+func ReadPublicKey(server *Server, path string) (key *PublicKey, err error) {
+	if path == "" && server.PrivateKey != "" {
+		path = server.PrivateKey
+	}
+	if path == "" {
+		homeDir, errs := getHomeDir()
+		if errs != nil {
+			return nil, fmt.Errorf("failed to get home directory: %v", errs)
+		}
+		for _, name := range defaultIdentityFiles {
+			if _, errs = os.Stat(filepath.Join(homeDir, ".ssh", name+".pub")); errs == nil {
+				path = filepath.Join(homeDir, ".ssh", name+".pub")
+				break
+			}
+		}
+		if path == "" {
+			return nil, fmt.Errorf("no public key found in %s, create one with ssh-keygen", filepath.Join(homeDir, ".ssh"))
+		}
+	}
+	if path, err = ExpandPath(path); err != nil {
+		return
+	}
+	if !strings.HasSuffix(path, ".pub") {
+		path += ".pub"
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read public key: %v", err)
+	}
+	pub, comment, _, _, err := ssh.ParseAuthorizedKey(data)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse public key %s: %v", path, err)
+	}
+	key = &PublicKey{Key: pub, Comment: comment, Path: path}
+	if private := strings.TrimSuffix(path, ".pub"); private != path {
+		if _, errs := os.Stat(private); errs == nil {
+			key.PrivateKey = private
+		}
+	}
+	return
+}
+
+// InstallPublicKey appends key to ~/.ssh/authorized_keys on the server
+// unless it is there already, creating the directory and file with the
+// permissions sshd insists on. It reports whether the key was added.
+func (c *Client) InstallPublicKey(key *PublicKey) (added bool, err error) {
+	// 按 "类型 base64" 判断是否已存在，忽略注释
+	blob := strings.TrimSpace(string(ssh.MarshalAuthorizedKey(key.Key)))
+	out, err := c.runScript(fmt.Sprintf(`umask 077
+mkdir -p "$HOME/.ssh" && chmod 700 "$HOME/.ssh" || exit 1
+keys="$HOME/.ssh/authorized_keys"
+touch "$keys" && chmod 600 "$keys" || exit 1
+if grep -qF -- %s "$keys"; then echo present; exit 0; fi
+# 文件末尾没有换行时先补上
+if [ -s "$keys" ] && [ "$(tail -c 1 "$keys")" != "" ]; then echo >> "$keys"; fi
+echo %s >> "$keys" && echo added`, ShellQuote(blob), ShellQuote(key.AuthorizedLine())), false)
+	if err != nil {
+		return false, fmt.Errorf("failed to install the key on %s: %v", c.Server.Alias, err)
+	}
+	return out == "added", nil
+}
+
+// RemovePublicKey deletes every line with key from ~/.ssh/authorized_keys
+// on the server, keeping the file's permissions. It reports whether any
+// line was removed.
+func (c *Client) RemovePublicKey(key *PublicKey) (removed bool, err error) {
+	blob := ShellQuote(strings.TrimSpace(string(ssh.MarshalAuthorizedKey(key.Key))))
+	out, err := c.runScript(fmt.Sprintf(`keys="$HOME/.ssh/authorized_keys"
+[ -f "$keys" ] && grep -qF -- %s "$keys" || exit 0
+tmp=$(mktemp "$keys.XXXXXX") || exit 1
+# 删除后文件可能为空，grep 此时返回 1
+grep -vF -- %s "$keys" > "$tmp"
+chmod 600 "$tmp" && mv -f "$tmp" "$keys" && echo removed`, blob, blob), false)
+	if err != nil {
+		return false, fmt.Errorf("failed to remove the key on %s: %v", c.Server.Alias, err)
+	}
+	return out == "removed", nil
+}
+
+// VerifyPublicKey logs in to server with key alone, through its private
+// key file or else ssh-agent, to check that the server accepts it.
+func (d *Dialer) VerifyPublicKey(server *Server, key *PublicKey) (err error) {
+	check := *server
+	check.Password, check.UseKey, check.UseAgent, check.Certificate = "", false, false, ""
+	if key.PrivateKey != "" {
+		check.UseKey, check.PrivateKey = true, key.PrivateKey
+	} else {
+		check.UseAgent = true
+	}
+	verifier := *d
+	verifier.Challenge, verifier.AskPassword, verifier.AuthCache = nil, false, false
+	if !check.UseKey {
+		// 只通过 agent 验证时不能退回到密码认证
+		verifier.PromptPassword = nil
+	}
+	client, err := verifier.Dial(&check)
+	if err != nil {
+		if isAuthFailure(err) {
+			return fmt.Errorf("%s does not accept the key %s yet", server.Alias, ssh.FingerprintSHA256(key.Key))
+		}
+		return
+	}
+	return client.Close()
+}
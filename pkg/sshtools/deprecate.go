@@ -0,0 +1,70 @@
+package sshtools
+
+import (
+	"fmt"
+	"time"
+)
+
+// sunsetLayout is the date format of the sunset field.
+const sunsetLayout = "2006-01-02"
+
+// maxRedirects guards against redirect_to loops.
+const maxRedirects = 8
+
+// Resolve follows deprecation settings for server. Deprecated entries yield
+// a notice and, with redirect_to, the server to connect to instead. Once
+// the sunset date has passed the alias fails with instructions.
+func (c *Config) Resolve(server *Server, now time.Time) (target *Server, notice string, err error) {
+	target = server
+	for i := 0; target.Deprecated; i++ {
+		if i == maxRedirects {
+			err = fmt.Errorf("too many redirects starting at alias %q", server.Alias)
+			return
+		}
+		if target.Sunset != "" {
+			sunset, errs := time.ParseInLocation(sunsetLayout, target.Sunset, time.Local)
+			if errs != nil {
+				err = fmt.Errorf("server %q: invalid sunset date %q (want YYYY-MM-DD)", target.Alias, target.Sunset)
+				return
+			}
+			if !now.Before(sunset) {
+				err = fmt.Errorf("alias %q was retired on %s", target.Alias, target.Sunset)
+				if target.RedirectTo != "" {
+					err = fmt.Errorf("%v, use %q instead", err, target.RedirectTo)
+				}
+				return
+			}
+		}
+		if target.RedirectTo == "" {
+			notice = fmt.Sprintf("alias %q is deprecated", target.Alias)
+			if target.Sunset != "" {
+				notice += " and stops working on " + target.Sunset
+			}
+			return
+		}
+
+		next := c.ServerByAlias(target.RedirectTo)
+		if next == nil {
+			err = fmt.Errorf("deprecated alias %q redirects to unknown alias %q", target.Alias, target.RedirectTo)
+			return
+		}
+		notice = fmt.Sprintf("alias %q is deprecated, connecting to %q instead", server.Alias, next.Alias)
+		if target.Sunset != "" {
+			notice += fmt.Sprintf(" (the old name stops working on %s)", target.Sunset)
+		}
+		target = next
+	}
+	return
+}
+
+// ActiveServers returns the servers eligible for fleet-wide selection,
+// leaving out deprecated entries unless includeDeprecated is set.
+func (c *Config) ActiveServers(includeDeprecated bool) (servers []*Server) {
+	for i := range c.Servers {
+		if c.Servers[i].Deprecated && !includeDeprecated {
+			continue
+		}
+		servers = append(servers, &c.Servers[i])
+	}
+	return
+}
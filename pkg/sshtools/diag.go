@@ -0,0 +1,274 @@
+package sshtools
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"slices"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// monitorInterval is how often -vv prints throughput and latency.
+const monitorInterval = 5 * time.Second
+
+// Logf writes a diagnostic line when the dialer's verbosity is at least
+// level. Lines end in \r\n so they stay readable while the local terminal
+// is in raw mode.
+func (d *Dialer) Logf(level int, format string, args ...any) {
+	if d == nil || d.Verbose < level {
+		return
+	}
+	w := d.Log
+	if w == nil {
+		w = os.Stderr
+	}
+	_, _ = fmt.Fprintf(w, "debug%d: "+format+"\r\n", append([]any{level}, args...)...)
+}
+
+// countingConn counts the bytes read from and written to the transport.
+type countingConn struct {
+	net.Conn
+	in, out atomic.Int64
+}
+
+func (c *countingConn) Read(b []byte) (n int, err error) {
+	n, err = c.Conn.Read(b)
+	c.in.Add(int64(n))
+	return
+}
+
+func (c *countingConn) Write(b []byte) (n int, err error) {
+	n, err = c.Conn.Write(b)
+	c.out.Add(int64(n))
+	return
+}
+
+// BytesTransferred returns the raw transport bytes received and sent so far.
+func (c *Client) BytesTransferred() (in, out int64) {
+	if c.conn == nil {
+		return
+	}
+	return c.conn.in.Load(), c.conn.out.Load()
+}
+
+// Ping sends a keepalive request and returns the round-trip time.
+func (c *Client) Ping() (rtt time.Duration, err error) {
+	start := time.Now()
+	_, _, err = c.SendRequest("keepalive@openssh.com", true, nil)
+	return time.Since(start), err
+}
+
+// authTrace remembers the last auth method attempted during a handshake;
+// methods are tried in order and stop at the first success.
+type authTrace struct {
+	last   string
+	method string
+	// key is the fingerprint of the last key used to sign.
+	key string
+	// timer enforces the handshake timeout; it is stopped while waiting
+	// for the user to type.
+	timer *time.Timer
+	// agents are the ssh-agent connections used for signing; they are
+	// closed once the handshake is over.
+	agents []io.Closer
+	// hostKey is the key the server presented.
+	hostKey ssh.PublicKey
+}
+
+// identity is a signer with where it came from, for diagnostics.
+type identity struct {
+	signer ssh.Signer
+	source string
+}
+
+// publicKeys and password wrap the auth methods so verbose output shows
+// which one is being attempted.
+func (d *Dialer) publicKeys(trace *authTrace, identities ...identity) ssh.AuthMethod {
+	return ssh.PublicKeysCallback(func() (signers []ssh.Signer, err error) {
+		var sources []string
+		for _, id := range identities {
+			d.Logf(1, "offering public key: %s %s (%s)", id.signer.PublicKey().Type(), ssh.FingerprintSHA256(id.signer.PublicKey()), id.source)
+			signers = append(signers, recordSigner(id.signer, trace))
+			if !slices.Contains(sources, id.source) {
+				sources = append(sources, id.source)
+			}
+		}
+		trace.last = "publickey (" + strings.Join(sources, ", ") + ")"
+		trace.method = "publickey"
+		return
+	})
+}
+
+func (d *Dialer) password(trace *authTrace, password string) ssh.AuthMethod {
+	return ssh.PasswordCallback(func() (string, error) {
+		d.Logf(1, "trying password authentication")
+		trace.last, trace.method = "password", "password"
+		return password, nil
+	})
+}
+
+func (d *Dialer) promptedPassword(trace *authTrace, user, host string) ssh.AuthMethod {
+	return ssh.PasswordCallback(func() (string, error) {
+		d.Logf(1, "prompting for password")
+		if trace.timer != nil {
+			trace.timer.Stop()
+		}
+		trace.last, trace.method = "password", "password"
+		return d.PromptPassword(fmt.Sprintf("%s@%s's password: ", user, host))
+	})
+}
+
+// keyboardInteractive relays the server's prompts to d.Challenge. A lone
+// hidden password prompt is answered once with the configured password,
+// as PAM usually asks for it this way, unless AskPassword is set. Without
+// d.Challenge, other prompts fail the handshake, or are answered blank
+// when decline is set so that the methods after this one are tried.
+func (d *Dialer) keyboardInteractive(trace *authTrace, server *Server, decline bool) ssh.AuthMethod {
+	passwordSent := false
+	return ssh.KeyboardInteractive(func(name, instruction string, questions []string, echos []bool) ([]string, error) {
+		trace.last, trace.method = "keyboard-interactive", "keyboard-interactive"
+		if server.Password != "" && !d.AskPassword && !passwordSent && len(questions) == 1 && !echos[0] &&
+			strings.Contains(strings.ToLower(questions[0]), "password") {
+			d.Logf(1, "answering keyboard-interactive password prompt from the config")
+			passwordSent = true
+			return []string{server.Password}, nil
+		}
+		if d.Challenge == nil {
+			if len(questions) == 0 {
+				return nil, nil
+			}
+			if decline {
+				d.Logf(1, "declining keyboard-interactive prompt %q: no terminal", strings.TrimSpace(questions[0]))
+				return make([]string, len(questions)), nil
+			}
+			return nil, &Error{Kind: ErrorAuth, Err: fmt.Errorf("keyboard-interactive prompt %q needs a terminal", strings.TrimSpace(questions[0]))}
+		}
+		d.Logf(1, "keyboard-interactive: %d prompt(s)", len(questions))
+		if trace.timer != nil {
+			trace.timer.Stop()
+		}
+		return d.Challenge(name, instruction, questions, echos)
+	})
+}
+
+// instrument hooks the banner and host key callbacks for diagnostics.
+func (d *Dialer) instrument(sshConfig *ssh.ClientConfig) {
+	if d.Verbose < 1 {
+		return
+	}
+	for _, offer := range []struct {
+		what  string
+		names []string
+	}{
+		{"host key algorithms", sshConfig.HostKeyAlgorithms},
+		{"kex algorithms", sshConfig.KeyExchanges},
+		{"ciphers", sshConfig.Ciphers},
+		{"MACs", sshConfig.MACs},
+	} {
+		if len(offer.names) > 0 {
+			d.Logf(2, "kex: offering %s: %s", offer.what, strings.Join(offer.names, ","))
+		}
+	}
+	hostKeyCallback := sshConfig.HostKeyCallback
+	sshConfig.HostKeyCallback = func(hostname string, remote net.Addr, key ssh.PublicKey) error {
+		d.Logf(1, "server host key: %s %s", key.Type(), ssh.FingerprintSHA256(key))
+		return hostKeyCallback(hostname, remote, key)
+	}
+	bannerCallback := sshConfig.BannerCallback
+	sshConfig.BannerCallback = func(message string) error {
+		for _, line := range strings.Split(strings.TrimRight(message, "\r\n"), "\n") {
+			d.Logf(1, "banner: %s", strings.TrimRight(line, "\r"))
+		}
+		if bannerCallback != nil {
+			return bannerCallback(message)
+		}
+		return nil
+	}
+}
+
+// succeeded returns the cache entry for the method that authenticated.
+func (t *authTrace) succeeded() cachedAuth {
+	if t.method == "publickey" {
+		if t.key == "" {
+			return cachedAuth{}
+		}
+		return cachedAuth{Method: t.method, Fingerprint: t.key}
+	}
+	return cachedAuth{Method: t.method}
+}
+
+func (d *Dialer) logHandshake(conn ssh.Conn, trace *authTrace) {
+	if d.Verbose < 1 {
+		return
+	}
+	d.Logf(1, "remote software version %s", conn.ServerVersion())
+	if meta, ok := conn.(ssh.AlgorithmsConnMetadata); ok {
+		algs := meta.Algorithms()
+		d.Logf(1, "kex: algorithm: %s", algs.KeyExchange)
+		d.Logf(1, "kex: host key algorithm: %s", algs.HostKey)
+		d.Logf(1, "kex: server->client cipher: %s MAC: %s", algs.Read.Cipher, macName(algs.Read))
+		d.Logf(1, "kex: client->server cipher: %s MAC: %s", algs.Write.Cipher, macName(algs.Write))
+	}
+	if trace.last != "" {
+		d.Logf(1, "authenticated as %s using %s", conn.User(), trace.last)
+	} else {
+		d.Logf(1, "authenticated as %s", conn.User())
+	}
+}
+
+func macName(algs ssh.DirectionAlgorithms) string {
+	if algs.MAC == "" {
+		// AEAD ciphers authenticate on their own.
+		return "<implicit>"
+	}
+	return algs.MAC
+}
+
+// monitor prints transfer rates and keepalive round-trip time until the
+// connection closes.
+func (d *Dialer) monitor(c *Client) {
+	done := make(chan struct{})
+	go func() {
+		_ = c.Wait()
+		close(done)
+	}()
+
+	ticker := time.NewTicker(monitorInterval)
+	defer ticker.Stop()
+	lastIn, lastOut := c.BytesTransferred()
+	for {
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
+		}
+		in, out := c.BytesTransferred()
+		seconds := monitorInterval.Seconds()
+		rtt, err := c.Ping()
+		latency := rtt.Round(100 * time.Microsecond).String()
+		if err != nil {
+			latency = "n/a (" + err.Error() + ")"
+		}
+		d.Logf(2, "in %s/s out %s/s rtt %s", FormatBytes(float64(in-lastIn)/seconds), FormatBytes(float64(out-lastOut)/seconds), latency)
+		lastIn, lastOut = in, out
+	}
+}
+
+// FormatBytes formats a byte count or rate with binary units, e.g. 1.5MiB.
+func FormatBytes(n float64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%.0fB", n)
+	}
+	suffixes := "KMGTPE"
+	i := 0
+	for n /= unit; n >= unit && i < len(suffixes)-1; n /= unit {
+		i++
+	}
+	return fmt.Sprintf("%.1f%ciB", n, suffixes[i])
+}
@@ -0,0 +1,176 @@
+package sshtools
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"sort"
+	"strings"
+)
+
+// Address choices of ProviderConfig.Address.
+const (
+	AddressPublic  = "public"
+	AddressPrivate = "private"
+)
+
+// ProviderConfig is an entry of the config's providers section: a cloud
+// account whose machines are discovered as servers, e.g. the running EC2
+// instances of an AWS region.
+type ProviderConfig struct {
+	Name string `json:"name"`
+	// Type is the kind of provider, see ProviderTypes.
+	Type    string `json:"type"`
+	Region  string `json:"region,omitempty"`
+	Profile string `json:"profile,omitempty"`
+	// Filters keeps only machines with these tag values; * matches anything.
+	Filters map[string]string `json:"filters,omitempty"`
+	// Address picks the public or the private IP; the public one when the
+	// machine has one by default.
+	Address string `json:"address,omitempty"`
+	// AliasPrefix is put before every alias, to keep them apart from
+	// configured ones.
+	AliasPrefix string `json:"alias_prefix,omitempty"`
+
+	// Transport "ssm" reaches the machines through Session Manager by
+	// instance ID instead of over SSH to their address.
+	Transport string `json:"transport,omitempty"`
+
+	// The rest is given to every discovered server.
+	User       string   `json:"user,omitempty"`
+	Port       int      `json:"port,omitempty"`
+	PrivateKey string   `json:"private_key,omitempty"`
+	ProxyJump  string   `json:"proxy_jump,omitempty"`
+	Tags       []string `json:"tags,omitempty"`
+}
+
+// discoverers list the machines of each provider type as servers with an
+// alias and an address; Discover fills in the rest. Other clouds are added
+// here.
+var discoverers = map[string]func(ctx context.Context, p *ProviderConfig) ([]Server, error){
+	"aws": discoverAWS,
+}
+
+// ProviderTypes returns the supported provider types.
+func ProviderTypes() (types []string) {
+	for name := range discoverers {
+		types = append(types, name)
+	}
+	sort.Strings(types)
+	return
+}
+
+// ProviderByName returns the entry of the providers section called name,
+// or nil.
+func (c *Config) ProviderByName(name string) *ProviderConfig {
+	for i := range c.Providers {
+		if c.Providers[i].Name == name {
+			return &c.Providers[i]
+		}
+	}
+	return nil
+}
+
+// Discover lists the machines of provider p as servers, with its user, key,
+// port, proxy_jump and tags, and Provider set to its name.
+func Discover(ctx context.Context, p *ProviderConfig) (servers []Server, err error) {
+	discover, ok := discoverers[p.Type]
+	if !ok {
+		return nil, fmt.Errorf("provider %s: unknown type %q", p.Name, p.Type)
+	}
+	if servers, err = discover(ctx, p); err != nil {
+		return nil, fmt.Errorf("provider %s: %v", p.Name, err)
+	}
+	for i := range servers {
+		s := &servers[i]
+		s.Alias = p.AliasPrefix + s.Alias
+		s.Provider = p.Name
+		s.User, s.Port, s.ProxyJump = p.User, p.Port, p.ProxyJump
+		if s.Port == 0 {
+			s.Port = defaultPort
+		}
+		if p.PrivateKey != "" {
+			s.PrivateKey, s.UseKey = p.PrivateKey, true
+		}
+		s.Tags = append(slices.Clone(p.Tags), s.Tags...)
+	}
+	sort.Slice(servers, func(i, j int) bool { return foldAlias(servers[i].Alias) < foldAlias(servers[j].Alias) })
+	return
+}
+
+// uniqueAliases gives machines that share a name the alias name-id, so
+// each can still be told apart.
+func uniqueAliases(servers []Server, ids []string) {
+	count := map[string]int{}
+	for _, s := range servers {
+		count[foldAlias(s.Alias)]++
+	}
+	for i := range servers {
+		if count[foldAlias(servers[i].Alias)] > 1 {
+			servers[i].Alias += "-" + ids[i]
+		}
+	}
+}
+
+// AddDiscovered adds discovered servers to the config in memory and fills
+// in their defaults like those of configured servers. Servers whose alias
+// is already configured are left out, as the config wins.
+func (c *Config) AddDiscovered(servers []Server) (added int, err error) {
+	first := len(c.Servers)
+	for _, s := range servers {
+		if c.ServerByAlias(s.Alias) == nil {
+			c.Servers = append(c.Servers, s)
+			added++
+		}
+	}
+	var problems []Problem
+	for _, p := range c.validate() {
+		if p.Index >= first && !p.Warning {
+			problems = append(problems, p)
+		}
+	}
+	if len(problems) > 0 {
+		return added, &ValidationError{File: "discovered servers", Problems: problems}
+	}
+	return
+}
+
+// checkProviders validates the providers section.
+func (c *Config) checkProviders() (problems []Problem) {
+	names := map[string]int{}
+	for i := range c.Providers {
+		p := &c.Providers[i]
+		add := func(format string, args ...any) {
+			prefix := fmt.Sprintf("providers[%d]", i)
+			if p.Name != "" {
+				prefix += fmt.Sprintf(" (%s)", p.Name)
+			}
+			problems = append(problems, Problem{Index: -1, Message: prefix + ": " + fmt.Sprintf(format, args...)})
+		}
+		if j, ok := names[p.Name]; ok {
+			add("duplicate name, already used by providers[%d]", j)
+		} else if p.Name == "" {
+			add(`"name" is required`)
+		} else {
+			names[p.Name] = i
+		}
+		if _, ok := discoverers[p.Type]; !ok {
+			add(`"type" %q must be one of %s`, p.Type, strings.Join(ProviderTypes(), ", "))
+		}
+		switch p.Address {
+		case "", AddressPublic, AddressPrivate:
+		default:
+			add(`"address" %q must be %s or %s`, p.Address, AddressPublic, AddressPrivate)
+		}
+		if p.User == "" {
+			add(`"user" is required, the user to log in to the machines as`)
+		}
+		switch {
+		case p.Transport != "" && p.Transport != TransportTCP && p.Transport != TransportSSM:
+			add(`"transport" %q must be %s or %s`, p.Transport, TransportTCP, TransportSSM)
+		case p.Transport == TransportSSM && p.Type != "aws":
+			add(`"transport" %s needs "type" aws`, TransportSSM)
+		}
+	}
+	return
+}
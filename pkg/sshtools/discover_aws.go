@@ -0,0 +1,82 @@
+package sshtools
+
+import (
+	"context"
+	"sort"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	awsconfig "github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/service/ec2"
+	"github.com/aws/aws-sdk-go-v2/service/ec2/types"
+)
+
+// discoverAWS lists the running EC2 instances of the provider's region
+// that carry its filter tags. Credentials come from the usual places: the
+// environment, the shared config and credentials files (with Profile) and
+// the instance role. The alias is the Name tag, or the instance ID. With
+// Transport ssm the servers are reached through Session Manager by
+// instance ID, in the provider's region and profile.
+func discoverAWS(ctx context.Context, p *ProviderConfig) (servers []Server, err error) {
+	var options []func(*awsconfig.LoadOptions) error
+	if p.Region != "" {
+		options = append(options, awsconfig.WithRegion(p.Region))
+	}
+	if p.Profile != "" {
+		options = append(options, awsconfig.WithSharedConfigProfile(p.Profile))
+	}
+	cfg, err := awsconfig.LoadDefaultConfig(ctx, options...)
+	if err != nil {
+		return
+	}
+
+	filters := []types.Filter{{Name: aws.String("instance-state-name"), Values: []string{"running"}}}
+	keys := make([]string, 0, len(p.Filters))
+	for key := range p.Filters {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		filters = append(filters, types.Filter{Name: aws.String("tag:" + key), Values: []string{p.Filters[key]}})
+	}
+
+	var ids []string
+	pages := ec2.NewDescribeInstancesPaginator(ec2.NewFromConfig(cfg), &ec2.DescribeInstancesInput{Filters: filters})
+	for pages.HasMorePages() {
+		page, errs := pages.NextPage(ctx)
+		if errs != nil {
+			return nil, errs
+		}
+		for _, reservation := range page.Reservations {
+			for _, instance := range reservation.Instances {
+				id := aws.ToString(instance.InstanceId)
+				// 默认优先公网 IP，没有时用私网 IP
+				address := aws.ToString(instance.PublicIpAddress)
+				if p.Address == AddressPrivate || p.Address == "" && address == "" {
+					address = aws.ToString(instance.PrivateIpAddress)
+				}
+				if address == "" && p.Transport == TransportSSM {
+					address = id
+				} else if address == "" {
+					continue
+				}
+				alias := id
+				for _, tag := range instance.Tags {
+					// 别名不能含空格
+					if name := strings.Join(strings.Fields(aws.ToString(tag.Value)), "-"); aws.ToString(tag.Key) == "Name" && name != "" {
+						alias = name
+					}
+				}
+				server := Server{Alias: alias, Address: address}
+				if p.Transport == TransportSSM {
+					server.Transport, server.InstanceID = TransportSSM, id
+					server.AWSRegion, server.AWSProfile = cfg.Region, p.Profile
+				}
+				servers = append(servers, server)
+				ids = append(ids, id)
+			}
+		}
+	}
+	uniqueAliases(servers, ids)
+	return
+}
@@ -0,0 +1,68 @@
+package sshtools
+
+import (
+	"fmt"
+	"os"
+	"runtime"
+	"sort"
+	"strings"
+)
+
+// Diagnose validates the config file filename like ParseConfigFile and also
+// reports risky settings: private keys other users can read, plaintext
+// passwords, and a config file with such passwords that other users can
+// read.
+func Diagnose(filename string) (config *Config, problems []Problem, err error) {
+	config, problems, err = ParseConfigFile(filename)
+	if err != nil {
+		return
+	}
+	problems = append(problems, config.securityProblems(filename)...)
+	sort.SliceStable(problems, func(i, j int) bool { return problems[i].Index < problems[j].Index })
+	return
+}
+
+// securityProblems checks the permissions of the key files and the config
+// file, and looks for passwords stored in the clear.
+func (c *Config) securityProblems(filename string) (problems []Problem) {
+	// Windows 上的文件权限不是 Unix 权限位，不检查
+	checkPerm := runtime.GOOS != "windows"
+	keys := map[string]bool{}
+	plaintext := 0
+	for i := range c.Servers {
+		s := &c.Servers[i]
+		if checkPerm && s.UseKey && s.PrivateKey != "" && !IsEncrypted(s.PrivateKey) && !HasReference(s.PrivateKey) {
+			keyPath, err := s.expandPath("private_key", s.PrivateKey)
+			// 多个服务器共用的密钥只报告一次
+			if err == nil && !keys[keyPath] {
+				keys[keyPath] = true
+				if info, errs := os.Stat(keyPath); errs == nil && info.Mode().Perm()&0o077 != 0 {
+					problems = append(problems, Problem{Index: i, Alias: s.Alias,
+						Message: fmt.Sprintf("private key %s is accessible by other users (mode %04o), OpenSSH refuses such keys", keyPath, info.Mode().Perm()),
+						Fix:     "chmod 600 " + ShellQuote(keyPath)})
+				}
+			}
+		}
+		if s.Password != "" && !IsEncrypted(s.Password) && !HasReference(s.Password) {
+			plaintext++
+			problems = append(problems, Problem{Index: i, Alias: s.Alias, Warning: true,
+				Message: `"password" is stored in plaintext`,
+				Fix:     fmt.Sprintf(`replace "password" with "password_source": "keychain" and run: sshtools secret set %s; or encrypt all passwords with: sshtools config encrypt`, s.Alias)})
+		}
+		for j, step := range s.ExpectScript {
+			if step.Secret && step.Send != "" && !strings.Contains(step.Send, envReference) {
+				problems = append(problems, Problem{Index: i, Alias: s.Alias, Warning: true,
+					Message: fmt.Sprintf("expect_script[%d] sends a secret stored in plaintext", j),
+					Fix:     `read it from the environment instead, e.g. "send": "${env:FW_PASSWORD}"`})
+			}
+		}
+	}
+	if checkPerm && plaintext > 0 {
+		if info, err := os.Stat(filename); err == nil && info.Mode().Perm()&0o077 != 0 {
+			problems = append(problems, Problem{Index: -1,
+				Message: fmt.Sprintf("%s holds %d plaintext password(s) and is accessible by other users (mode %04o)", filename, plaintext, info.Mode().Perm()),
+				Fix:     "chmod 600 " + ShellQuote(filename)})
+		}
+	}
+	return
+}
@@ -0,0 +1,183 @@
+package sshtools
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// EnvVar is one variable sent to the remote side of a session.
+type EnvVar struct {
+	Name  string
+	Value string
+}
+
+var envName = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// ParseEnvFile reads KEY=VALUE lines from a .env file. Blank lines and
+// lines starting with # are skipped, a leading "export " is allowed, and
+// values may be single-quoted (literal) or double-quoted (with \n, \t, \"
+// and \\ escapes). Unquoted values end at " #". Errors carry the line
+// number but never the value.
+func ParseEnvFile(path string) (vars []EnvVar, err error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return
+	}
+	defer func() { _ = file.Close() }()
+
+	scanner := bufio.NewScanner(file)
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		v, errs := parseEnvLine(strings.TrimPrefix(line, "export "))
+		if errs != nil {
+			return nil, fmt.Errorf("%s:%d: %v", path, n, errs)
+		}
+		vars = append(vars, v)
+	}
+	return vars, scanner.Err()
+}
+
+func parseEnvLine(line string) (v EnvVar, err error) {
+	name, value, ok := strings.Cut(line, "=")
+	if !ok {
+		return v, fmt.Errorf("expected KEY=VALUE")
+	}
+	v.Name = strings.TrimSpace(name)
+	if !envName.MatchString(v.Name) {
+		return v, fmt.Errorf("invalid variable name %q", v.Name)
+	}
+	value = strings.TrimSpace(value)
+
+	switch {
+	case strings.HasPrefix(value, "'"):
+		end := strings.IndexByte(value[1:], '\'')
+		if end < 0 {
+			return v, fmt.Errorf("unterminated single quote in %s", v.Name)
+		}
+		v.Value, value = value[1:end+1], value[end+2:]
+	case strings.HasPrefix(value, `"`):
+		var b strings.Builder
+		i := 1
+		for ; i < len(value) && value[i] != '"'; i++ {
+			if value[i] == '\\' && i+1 < len(value) {
+				i++
+				switch value[i] {
+				case 'n':
+					b.WriteByte('\n')
+				case 't':
+					b.WriteByte('\t')
+				case '"', '\\', '$':
+					b.WriteByte(value[i])
+				default:
+					b.WriteByte('\\')
+					b.WriteByte(value[i])
+				}
+				continue
+			}
+			b.WriteByte(value[i])
+		}
+		if i == len(value) {
+			return v, fmt.Errorf("unterminated double quote in %s", v.Name)
+		}
+		v.Value, value = b.String(), value[i+1:]
+	default:
+		if i := strings.Index(value, " #"); i >= 0 {
+			value = value[:i]
+		}
+		v.Value, value = strings.TrimSpace(value), ""
+	}
+
+	if rest := strings.TrimSpace(value); rest != "" && !strings.HasPrefix(rest, "#") {
+		return v, fmt.Errorf("unexpected text after the quoted value of %s", v.Name)
+	}
+	return
+}
+
+// MergeEnv combines a server's set_env with variables from the command line,
+// which win. overridden lists the set_env names that were replaced.
+func MergeEnv(setEnv map[string]string, cli []EnvVar) (vars []EnvVar, overridden []string) {
+	fromCLI := map[string]bool{}
+	for _, v := range cli {
+		fromCLI[v.Name] = true
+	}
+	names := make([]string, 0, len(setEnv))
+	for name := range setEnv {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if fromCLI[name] {
+			if setEnv[name] != cliValue(cli, name) {
+				overridden = append(overridden, name)
+			}
+			continue
+		}
+		vars = append(vars, EnvVar{Name: name, Value: setEnv[name]})
+	}
+	return append(vars, cli...), overridden
+}
+
+func cliValue(vars []EnvVar, name string) (value string) {
+	for _, v := range vars {
+		if v.Name == name {
+			value = v.Value
+		}
+	}
+	return
+}
+
+// NewUserSession opens a session for command with c.Env applied. Variables
+// go through Setenv; servers that refuse them (AcceptEnv) get them exported
+// at the start of the returned command instead. An empty command starts
+// the login shell. Values are never logged.
+func (c *Client) NewUserSession(command string) (session *ssh.Session, cmd string, err error) {
+	if session, err = c.NewSession(); err != nil {
+		return
+	}
+	// 转发本机 ssh-agent 需要显式开启：远程主机的 root 可以借用其中的密钥
+	if c.ForwardAgent || c.Server.ForwardAgent {
+		if errs := c.requestAgentForwarding(session); errs != nil {
+			fmt.Fprintf(os.Stderr, "Warning: agent forwarding to %s failed: %v\n", c.Server.Alias, errs)
+		} else {
+			c.dialer.Logf(1, "forwarding the local ssh-agent")
+		}
+	}
+	if len(c.Env) == 0 {
+		return session, command, nil
+	}
+
+	names := make([]string, len(c.Env))
+	for i, v := range c.Env {
+		names[i] = v.Name
+	}
+	c.dialer.Logf(1, "sending environment: %s", strings.Join(names, " "))
+	for _, v := range c.Env {
+		if errs := session.Setenv(v.Name, v.Value); errs != nil {
+			c.dialer.Logf(1, "server refused setenv, exporting the variables in the command instead")
+			return session, ExportEnv(c.Env, command), nil
+		}
+	}
+	return session, command, nil
+}
+
+// ExportEnv prefixes command with shell exports of vars. An empty command
+// becomes the user's login shell.
+func ExportEnv(vars []EnvVar, command string) string {
+	var b strings.Builder
+	for _, v := range vars {
+		fmt.Fprintf(&b, "export %s=%s; ", v.Name, ShellQuote(v.Value))
+	}
+	if command == "" {
+		command = `exec "${SHELL:-/bin/sh}" -l`
+	}
+	return b.String() + command
+}
@@ -0,0 +1,92 @@
+package sshtools
+
+import (
+	"context"
+	"errors"
+	"net"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// ErrorKind is the cause of a failure, for callers that handle causes
+// differently, such as scripts telling a wrong password from a host that is
+// down.
+type ErrorKind int
+
+const (
+	// ErrorOther is any failure without a more specific kind.
+	ErrorOther ErrorKind = iota
+	// ErrorConfig is a config file that cannot be read, parsed or
+	// validated, or a value in it that cannot be resolved.
+	ErrorConfig
+	// ErrorAuth is the server rejecting every credential offered.
+	ErrorAuth
+	// ErrorTimeout is a connection or handshake that timed out.
+	ErrorTimeout
+	// ErrorHostKey is a host key that does not match the known or pinned
+	// one, or an unknown one that was not accepted.
+	ErrorHostKey
+	// ErrorRemote is a remote command that exited with a non-zero status.
+	ErrorRemote
+)
+
+func (k ErrorKind) String() string {
+	switch k {
+	case ErrorConfig:
+		return "config"
+	case ErrorAuth:
+		return "auth"
+	case ErrorTimeout:
+		return "timeout"
+	case ErrorHostKey:
+		return "host key"
+	case ErrorRemote:
+		return "remote"
+	}
+	return "other"
+}
+
+// Error is a failure of a known kind. Its message is that of Err.
+type Error struct {
+	Kind ErrorKind
+	Err  error
+}
+
+func (e *Error) Error() string {
+	return e.Err.Error()
+}
+
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+// KindOf returns the kind of err: that of the first *Error it wraps, or
+// else the one recognized from the errors of this package and of
+// x/crypto/ssh.
+func KindOf(err error) ErrorKind {
+	var kinded *Error
+	var invalid *ValidationError
+	var mismatch *HostKeyMismatchError
+	var changed *HostKeyChangedError
+	var unknown *HostKeyUnknownError
+	var sshfp *SSHFPMismatchError
+	var exit *ssh.ExitError
+	var netErr net.Error
+	switch {
+	case err == nil:
+		return ErrorOther
+	case errors.As(err, &kinded):
+		return kinded.Kind
+	case errors.As(err, &invalid):
+		return ErrorConfig
+	case errors.As(err, &mismatch), errors.As(err, &changed), errors.As(err, &unknown), errors.As(err, &sshfp):
+		return ErrorHostKey
+	case errors.As(err, &exit):
+		return ErrorRemote
+	case isAuthFailure(err):
+		return ErrorAuth
+	case errors.Is(err, ErrTimeout), errors.Is(err, context.DeadlineExceeded), errors.As(err, &netErr) && netErr.Timeout():
+		return ErrorTimeout
+	}
+	return ErrorOther
+}
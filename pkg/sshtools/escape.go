@@ -0,0 +1,59 @@
+package sshtools
+
+// escapeChar starts an OpenSSH style escape sequence at the beginning of a line.
+const escapeChar = '~'
+
+// Escape commands besides ~. that interactive sessions handle.
+const (
+	escapeHelp    = '?'
+	escapeCommand = 'C'
+	escapeSuspend = 0x1a // Ctrl-Z
+)
+
+// escapeHelpText is shown for ~?.
+const escapeHelpText = "\r\nSupported escape sequences:\r\n" +
+	" ~.   - terminate connection\r\n" +
+	" ~C   - open a command line to add port forwards\r\n" +
+	" ~^Z  - suspend sshtools\r\n" +
+	" ~?   - this message\r\n" +
+	" ~~   - send the escape character by typing it twice\r\n" +
+	"(Note that escapes are only recognized immediately after newline.)\r\n"
+
+// escapeState recognises escape sequences in the local input stream. An
+// escape is only honoured right after a newline (or at the very start), so
+// a ~ typed mid-line is passed through untouched.
+type escapeState struct {
+	midLine bool
+	pending bool
+	// extended also recognises ~?, ~C and ~^Z; otherwise only ~. is a
+	// command.
+	extended bool
+}
+
+// feed consumes one input byte. It returns the bytes that should be sent to
+// the remote side and, when a complete escape was typed, its command byte.
+func (e *escapeState) feed(b byte) (out []byte, cmd byte) {
+	if e.pending {
+		e.pending = false
+		switch b {
+		case '.':
+			return nil, b
+		case escapeHelp, escapeCommand, escapeSuspend:
+			if e.extended {
+				return nil, b
+			}
+		case escapeChar:
+			// ~~ sends a single literal ~
+			e.midLine = true
+			return []byte{escapeChar}, 0
+		}
+		e.midLine = b != '\r' && b != '\n'
+		return []byte{escapeChar, b}, 0
+	}
+	if !e.midLine && b == escapeChar {
+		e.pending = true
+		return nil, 0
+	}
+	e.midLine = b != '\r' && b != '\n'
+	return []byte{b}, 0
+}
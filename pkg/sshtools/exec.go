@@ -0,0 +1,435 @@
+package sshtools
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// Output runs command in a new session and returns its standard output.
+func (c *Client) Output(command string) (out []byte, err error) {
+	session, err := c.NewSession()
+	if err != nil {
+		return
+	}
+	defer func() { _ = session.Close() }()
+	return session.Output(command)
+}
+
+// Run runs command in a new session without a PTY, streaming its output.
+func (c *Client) Run(command string, stdout, stderr io.Writer) (err error) {
+	session, err := c.NewSession()
+	if err != nil {
+		return
+	}
+	defer func() { _ = session.Close() }()
+	session.Stdout = stdout
+	session.Stderr = stderr
+	return session.Run(command)
+}
+
+// RunOnConnect runs the server's on_connect commands one after another,
+// stopping at the first failure.
+func (c *Client) RunOnConnect(stdout, stderr io.Writer) (err error) {
+	for _, command := range c.Server.OnConnect {
+		if err = c.runUser(command, stdout, stderr); err != nil {
+			return fmt.Errorf("on_connect command %q failed: %v", command, err)
+		}
+	}
+	return
+}
+
+// runUser is Run for commands the user gave, with c.Env applied.
+func (c *Client) runUser(command string, stdout, stderr io.Writer) (err error) {
+	session, command, err := c.NewUserSession(command)
+	if err != nil {
+		return
+	}
+	defer func() { _ = session.Close() }()
+	session.Stdout = stdout
+	session.Stderr = stderr
+	return session.Run(command)
+}
+
+// DefaultMaxCapture bounds how much output is kept in memory per command
+// when results are captured (JSON mode) and no explicit limit is given.
+const DefaultMaxCapture = 10 << 20
+
+// ExecResult is the outcome of a captured remote command.
+type ExecResult struct {
+	Alias      string `json:"alias"`
+	Address    string `json:"address"`
+	Command    string `json:"command"`
+	ExitCode   int    `json:"exit_code"`
+	DurationMs int64  `json:"duration_ms"`
+	Stdout     string `json:"stdout"`
+	Stderr     string `json:"stderr"`
+	// Truncated is set when output went over the limit. OriginalSize is the
+	// total the command produced, or 0 if it was killed before finishing.
+	Truncated    bool   `json:"truncated,omitempty"`
+	OriginalSize int64  `json:"original_size,omitempty"`
+	Killed       bool   `json:"killed,omitempty"`
+	Error        string `json:"error,omitempty"`
+	// TimedOut is set when the command was killed for running longer than
+	// the command timeout.
+	TimedOut bool `json:"timed_out,omitempty"`
+}
+
+// OutputLimit caps the combined output written through its writers. Bytes
+// over the limit are counted but dropped, so the remote command is never
+// blocked by a full pipe.
+type OutputLimit struct {
+	Max int64
+	// OnTruncate is called once, the first time output is dropped.
+	OnTruncate func()
+
+	mu      sync.Mutex
+	written int64
+	total   int64
+	once    sync.Once
+}
+
+// Writer returns a writer that forwards to w while the budget lasts.
+func (l *OutputLimit) Writer(w io.Writer) io.Writer {
+	return &limitedWriter{limit: l, w: w}
+}
+
+// Truncated reports whether any output was dropped.
+func (l *OutputLimit) Truncated() bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.total > l.written
+}
+
+// Total returns how many bytes were produced, including dropped ones.
+func (l *OutputLimit) Total() int64 {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.total
+}
+
+type limitedWriter struct {
+	limit *OutputLimit
+	w     io.Writer
+}
+
+func (lw *limitedWriter) Write(p []byte) (int, error) {
+	l := lw.limit
+	l.mu.Lock()
+	l.total += int64(len(p))
+	keep := int64(len(p))
+	if l.Max > 0 && l.written+keep > l.Max {
+		keep = l.Max - l.written
+	}
+	l.written += keep
+	l.mu.Unlock()
+
+	if keep < int64(len(p)) && l.OnTruncate != nil {
+		l.once.Do(l.OnTruncate)
+	}
+	if keep > 0 {
+		if _, err := lw.w.Write(p[:keep]); err != nil {
+			return 0, err
+		}
+	}
+	return len(p), nil
+}
+
+// prefixLineMax is the longest partial line a PrefixWriter keeps; longer
+// lines are written in pieces of this size, each on a line of its own.
+const prefixLineMax = 64 << 10
+
+// PrefixWriter writes whole lines to w, each starting with prefix. Writers
+// sharing mu never interleave within a line.
+type PrefixWriter struct {
+	w      io.Writer
+	mu     *sync.Mutex
+	prefix []byte
+	line   []byte
+}
+
+// NewPrefixWriter returns a PrefixWriter; mu is shared by the writers of
+// one output.
+func NewPrefixWriter(w io.Writer, mu *sync.Mutex, prefix string) *PrefixWriter {
+	return &PrefixWriter{w: w, mu: mu, prefix: []byte(prefix)}
+}
+
+func (p *PrefixWriter) Write(b []byte) (int, error) {
+	p.line = append(p.line, b...)
+	if i := bytes.LastIndexByte(p.line, '\n'); i >= 0 {
+		if err := p.write(p.line[:i+1]); err != nil {
+			return 0, err
+		}
+		p.line = append(p.line[:0], p.line[i+1:]...)
+	}
+	// 没有换行的输出按上限分段写出，不再无限缓存
+	for len(p.line) >= prefixLineMax {
+		if err := p.write(append(p.line[:prefixLineMax:prefixLineMax], '\n')); err != nil {
+			return 0, err
+		}
+		p.line = append(p.line[:0], p.line[prefixLineMax:]...)
+	}
+	return len(b), nil
+}
+
+// Flush writes a last line that has no newline.
+func (p *PrefixWriter) Flush() error {
+	if len(p.line) == 0 {
+		return nil
+	}
+	err := p.write(append(p.line, '\n'))
+	p.line = p.line[:0]
+	return err
+}
+
+// write prefixes every line of lines, which ends with a newline.
+func (p *PrefixWriter) write(lines []byte) error {
+	var out []byte
+	for len(lines) > 0 {
+		i := bytes.IndexByte(lines, '\n')
+		out = append(append(out, p.prefix...), lines[:i+1]...)
+		lines = lines[i+1:]
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	_, err := p.w.Write(out)
+	return err
+}
+
+// Capture runs command and collects its output into an ExecResult, keeping
+// at most max bytes (0 for no limit). With killOnTruncate the remote
+// command is killed as soon as the limit is hit.
+func (c *Client) Capture(command string, max int64, killOnTruncate bool) *ExecResult {
+	var stdout, stderr bytes.Buffer
+	res := c.Exec(command, &stdout, &stderr, max, killOnTruncate)
+	res.Stdout, res.Stderr = stdout.String(), stderr.String()
+	return res
+}
+
+// Exec runs command without a PTY, streaming at most max bytes of output
+// (0 for no limit) to stdout and stderr. The returned result carries the
+// exit code, timing and truncation details but not the output itself.
+func (c *Client) Exec(command string, stdout, stderr io.Writer, max int64, killOnTruncate bool) (res *ExecResult) {
+	return c.exec(command, c.Stdin, stdout, stderr, max, killOnTruncate)
+}
+
+// exec is Exec with the command's stdin given.
+func (c *Client) exec(command string, stdin io.Reader, stdout, stderr io.Writer, max int64, killOnTruncate bool) (res *ExecResult) {
+	res = &ExecResult{Alias: c.Server.Alias, Address: c.Server.Addr(), Command: command}
+	start := time.Now()
+	defer func() {
+		res.DurationMs = time.Since(start).Milliseconds()
+	}()
+
+	remote := command
+	pty := c.Become && c.Server.Become != nil && c.Server.Become.PTY
+	if c.Become {
+		// Without this the command would read from the pipe the password
+		// is typed into.
+		remote = SudoCommand("exec </dev/null; "+command, !pty)
+	}
+	if c.SkipPreamble {
+		marker := newPreambleMarker()
+		remote = markPreamble(remote, marker)
+		stdout, stderr = newPreambleFilter(stdout, marker), newPreambleFilter(stderr, marker)
+	}
+	session, remote, err := c.NewUserSession(remote)
+	if err != nil {
+		res.ExitCode = -1
+		res.Error = err.Error()
+		return
+	}
+	defer func() { _ = session.Close() }()
+
+	limit := &OutputLimit{Max: max}
+	if killOnTruncate {
+		limit.OnTruncate = func() {
+			res.Killed = true
+			_ = session.Signal(ssh.SIGKILL)
+			_ = session.Close()
+		}
+	}
+	session.Stdout = limit.Writer(stdout)
+	session.Stderr = limit.Writer(stderr)
+	var become *becomeWatcher
+	var redacted []io.Writer
+	if c.Become {
+		// 输出中出现的密码一律替换，以免写入日志
+		redacted = []io.Writer{newRedactWriter(session.Stdout, c.BecomePassword), newRedactWriter(session.Stderr, c.BecomePassword)}
+		session.Stdout, session.Stderr = redacted[0], redacted[1]
+		if pty {
+			// 关闭回显，密码不会出现在输出中；关闭 ONLCR 以保留原始换行
+			modes := ssh.TerminalModes{ssh.ECHO: 0, ssh.ONLCR: 0}
+			if errs := session.RequestPty("dumb", 24, 80, modes); errs != nil {
+				res.ExitCode = -1
+				res.Error = fmt.Sprintf("failed to request a PTY for sudo: %v", errs)
+				return
+			}
+		}
+		stdin, errs := session.StdinPipe()
+		if errs != nil {
+			res.ExitCode = -1
+			res.Error = errs.Error()
+			return
+		}
+		// 有 PTY 时 sudo 的提示出现在 stdout 上
+		become = &becomeWatcher{w: session.Stderr, stdin: stdin, password: c.BecomePassword, strip: true, closeStdin: !pty,
+			onFail: func() { _ = session.Close() }}
+		if pty {
+			become.w = session.Stdout
+			session.Stdout = become
+		} else {
+			session.Stderr = become
+		}
+	} else if stdin != nil {
+		// 不设 session.Stdin：远程命令提前退出时 Run 会一直等到本地输入结束
+		pipe, errs := session.StdinPipe()
+		if errs != nil {
+			res.ExitCode = -1
+			res.Error = errs.Error()
+			return
+		}
+		go func() {
+			_, _ = io.Copy(pipe, stdin)
+			// 本地输入结束时向远程命令发送 EOF
+			_ = pipe.Close()
+		}()
+	}
+	stopSignals := func() {}
+	if c.ForwardSignals {
+		stopSignals = c.forwardSignals(session, res)
+	}
+	timeout := c.CommandTimeout
+	if timeout == 0 {
+		timeout, _ = time.ParseDuration(c.Server.CommandTimeout)
+	}
+	var timedOut atomic.Bool
+	if timeout > 0 {
+		timer := time.AfterFunc(timeout, func() {
+			timedOut.Store(true)
+			// 关闭会话，不支持 signal 请求的服务器上也不再等待
+			_ = session.Signal(ssh.SIGKILL)
+			_ = session.Close()
+		})
+		defer timer.Stop()
+	}
+
+	err = session.Run(remote)
+	stopSignals()
+	for _, w := range redacted {
+		if r, ok := w.(*redactWriter); ok {
+			_ = r.Flush()
+		}
+	}
+	res.ExitCode = ExitCode(err)
+	if become != nil && become.Err() != nil {
+		res.ExitCode = -1
+		res.Error = become.Err().Error()
+	} else if timedOut.Load() {
+		res.ExitCode = -1
+		res.TimedOut = true
+		res.Error = fmt.Sprintf("command timed out after %s and was killed", timeout)
+	} else if err != nil && res.ExitCode < 0 && !res.Killed {
+		res.Error = err.Error()
+	}
+	if limit.Truncated() {
+		res.Truncated = true
+		if !res.Killed {
+			res.OriginalSize = limit.Total()
+		}
+	}
+	return
+}
+
+// forwardSignals relays local terminating signals to the command running in
+// session until the returned func is called. Interrupting the local client
+// would otherwise leave the command running on the server.
+func (c *Client) forwardSignals(session *ssh.Session, res *ExecResult) (stop func()) {
+	sigCh := make(chan os.Signal, 1)
+	for sig := range remoteSignals {
+		signal.Notify(sigCh, sig)
+	}
+	done := make(chan struct{})
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		var kill <-chan time.Time
+		received := 0
+		for {
+			select {
+			case <-done:
+				return
+			case sig := <-sigCh:
+				received++
+				// 服务器不支持 signal 请求（OpenSSH 7.9 之前）时命令不会退出，第三次放弃等待
+				if received >= 3 {
+					_ = session.Close()
+					continue
+				}
+				_ = session.Signal(remoteSignals[sig])
+				if kill == nil && c.KillAfter > 0 {
+					kill = time.After(c.KillAfter)
+				}
+			case <-kill:
+				res.Killed = true
+				_ = session.Signal(ssh.SIGKILL)
+			}
+		}
+	}()
+	return func() {
+		signal.Stop(sigCh)
+		close(done)
+		wg.Wait()
+	}
+}
+
+// ExitCode maps the error from Session.Run/Wait to a process exit code: the
+// remote status when there is one, -1 otherwise.
+func ExitCode(err error) int {
+	if err == nil {
+		return 0
+	}
+	var exitErr *ssh.ExitError
+	if errors.As(err, &exitErr) {
+		return exitErr.ExitStatus()
+	}
+	return -1
+}
+
+// ParseSize parses sizes like 512, 64K, 10M or 1G (powers of 1024). An empty
+// string, "0" or "unlimited" mean no limit.
+func ParseSize(s string) (n int64, err error) {
+	s = strings.TrimSpace(strings.ToUpper(s))
+	if s == "" || s == "0" || s == "UNLIMITED" {
+		return 0, nil
+	}
+	s = strings.TrimSuffix(strings.TrimSuffix(s, "B"), "I")
+	multiplier := int64(1)
+	switch {
+	case strings.HasSuffix(s, "K"):
+		multiplier = 1 << 10
+	case strings.HasSuffix(s, "M"):
+		multiplier = 1 << 20
+	case strings.HasSuffix(s, "G"):
+		multiplier = 1 << 30
+	}
+	if multiplier > 1 {
+		s = s[:len(s)-1]
+	}
+	v, err := strconv.ParseFloat(s, 64)
+	if err != nil || v < 0 {
+		return 0, fmt.Errorf("invalid size %q", s)
+	}
+	return int64(v * float64(multiplier)), nil
+}
@@ -0,0 +1,176 @@
+package sshtools
+
+import (
+	"bytes"
+	"io"
+	"runtime"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestOutputLimit(t *testing.T) {
+	var out bytes.Buffer
+	truncations := 0
+	limit := &OutputLimit{Max: 1000, OnTruncate: func() { truncations++ }}
+	stdout, stderr := limit.Writer(&out), limit.Writer(io.Discard)
+
+	// 两个 writer 共用上限
+	_, _ = stdout.Write(bytes.Repeat([]byte("o"), 600))
+	_, _ = stderr.Write(bytes.Repeat([]byte("e"), 300))
+	if limit.Truncated() {
+		t.Fatal("truncated below the limit")
+	}
+	n, err := stdout.Write(bytes.Repeat([]byte("o"), 300))
+	// 超出的部分也算作写入，远程命令不会被阻塞
+	if n != 300 || err != nil {
+		t.Errorf("Write = %d, %v, want all of it taken", n, err)
+	}
+	if out.Len() != 700 {
+		t.Errorf("%d bytes written, want 700", out.Len())
+	}
+	if !limit.Truncated() || limit.Total() != 1200 || truncations != 1 {
+		t.Errorf("Truncated = %v, Total = %d, OnTruncate called %d times; want true, 1200, 1",
+			limit.Truncated(), limit.Total(), truncations)
+	}
+}
+
+func TestOutputLimitUnbounded(t *testing.T) {
+	const max, total = 64 << 10, 1 << 30
+	var out bytes.Buffer
+	truncations := 0
+	limit := &OutputLimit{Max: max, OnTruncate: func() { truncations++ }}
+	w := limit.Writer(&out)
+
+	var before, after runtime.MemStats
+	runtime.GC()
+	runtime.ReadMemStats(&before)
+	chunk := bytes.Repeat([]byte("y\n"), 16<<10)
+	for written := 0; written < total; written += len(chunk) {
+		_, _ = w.Write(chunk)
+	}
+	runtime.ReadMemStats(&after)
+
+	if out.Len() != max {
+		t.Errorf("%d bytes kept, want %d", out.Len(), max)
+	}
+	if !limit.Truncated() || limit.Total() != total || truncations != 1 {
+		t.Errorf("Truncated = %v, Total = %d, OnTruncate called %d times; want true, %d, 1",
+			limit.Truncated(), limit.Total(), truncations, total)
+	}
+	// 丢弃的输出不占用内存
+	if grown := after.TotalAlloc - before.TotalAlloc; grown > 4<<20 {
+		t.Errorf("%d bytes allocated for %d bytes of output", grown, total)
+	}
+}
+
+func TestPrefixWriterLongLine(t *testing.T) {
+	var out bytes.Buffer
+	var mu sync.Mutex
+	w := NewPrefixWriter(&out, &mu, "web1: ")
+
+	// 没有换行的输出不会一直缓存
+	chunk := bytes.Repeat([]byte("x"), 1000)
+	for range 200 {
+		_, _ = w.Write(chunk)
+	}
+	if len(w.line) >= prefixLineMax {
+		t.Errorf("%d bytes kept of a line without a newline, want less than %d", len(w.line), prefixLineMax)
+	}
+	_, _ = w.Write([]byte("end\n"))
+
+	// 三段完整的 64 KiB 和剩下的部分，每段都有前缀
+	lines := strings.Split(strings.TrimSuffix(out.String(), "\n"), "\n")
+	if len(lines) != 4 {
+		t.Fatalf("%d lines written, want 4", len(lines))
+	}
+	total := 0
+	for _, line := range lines {
+		rest, ok := strings.CutPrefix(line, "web1: ")
+		if !ok {
+			t.Fatalf("line %.20q... lacks the prefix", line)
+		}
+		total += len(rest)
+	}
+	if total != 200*1000+len("end") || !strings.HasSuffix(lines[3], "xend") {
+		t.Errorf("%d bytes written ending in %q, want all of them", total, lines[3][len(lines[3])-4:])
+	}
+}
+
+func TestCaptureTruncates(t *testing.T) {
+	s := newTestServer(t)
+	c := &Client{Client: s.client, Server: &Server{Alias: "test"}}
+
+	res := c.Capture("output 100000", 1000, false)
+	if res.ExitCode != 0 || res.Error != "" {
+		t.Fatalf("exit code %d, error %q", res.ExitCode, res.Error)
+	}
+	if len(res.Stdout) != 1000 || !res.Truncated || res.OriginalSize != 100000 || res.Killed {
+		t.Errorf("kept %d bytes, Truncated = %v, OriginalSize = %d, Killed = %v; want 1000, true, 100000, false",
+			len(res.Stdout), res.Truncated, res.OriginalSize, res.Killed)
+	}
+
+	res = c.Capture("output 1000", 1000, false)
+	if len(res.Stdout) != 1000 || res.Truncated || res.OriginalSize != 0 {
+		t.Errorf("kept %d bytes, Truncated = %v, OriginalSize = %d; want 1000 and no truncation",
+			len(res.Stdout), res.Truncated, res.OriginalSize)
+	}
+}
+
+func TestCaptureKillsUnboundedOutput(t *testing.T) {
+	s := newTestServer(t)
+	c := &Client{Client: s.client, Server: &Server{Alias: "test"}}
+
+	done := make(chan *ExecResult, 1)
+	go func() { done <- c.Capture("output -1", 1000, true) }()
+	var res *ExecResult
+	if !waitFor(func() bool {
+		select {
+		case res = <-done:
+			return true
+		default:
+			return false
+		}
+	}) {
+		t.Fatal("Capture did not return for a command that never ends")
+	}
+	// 被终止的命令没有完整的输出大小
+	if len(res.Stdout) != 1000 || !res.Truncated || !res.Killed || res.OriginalSize != 0 {
+		t.Errorf("kept %d bytes, Truncated = %v, Killed = %v, OriginalSize = %d; want 1000, true, true, 0",
+			len(res.Stdout), res.Truncated, res.Killed, res.OriginalSize)
+	}
+}
+
+func TestParseSize(t *testing.T) {
+	tests := []struct {
+		in   string
+		want int64
+	}{
+		{"", 0},
+		{"0", 0},
+		{"unlimited", 0},
+		{" Unlimited ", 0},
+		{"512", 512},
+		{"512b", 512},
+		{"64K", 64 << 10},
+		{"64kb", 64 << 10},
+		{"64KiB", 64 << 10},
+		{"10M", 10 << 20},
+		{"10mib", 10 << 20},
+		{"1G", 1 << 30},
+		{"1.5K", 1536},
+		{"0.5m", 512 << 10},
+	}
+	for _, tt := range tests {
+		got, err := ParseSize(tt.in)
+		if err != nil || got != tt.want {
+			t.Errorf("ParseSize(%q) = %d, %v, want %d", tt.in, got, err, tt.want)
+		}
+	}
+
+	for _, in := range []string{"-1", "-1K", "ten", "K", "10T", "1e", "1 0M"} {
+		if got, err := ParseSize(in); err == nil {
+			t.Errorf("ParseSize(%q) = %d, want an error", in, got)
+		}
+	}
+}
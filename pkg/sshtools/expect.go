@@ -0,0 +1,171 @@
+package sshtools
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"sync"
+	"time"
+)
+
+// DefaultExpectTimeout is how long an expect_script step waits for its
+// pattern when it has no timeout of its own.
+const DefaultExpectTimeout = 10 * time.Second
+
+// expectBufferSize bounds the output kept for matching.
+const expectBufferSize = 64 << 10
+
+// ExpectStep is one step of a server's expect_script: wait for session
+// output matching the Expect regexp, then type Send followed by Enter.
+// ${env:NAME} references in Send are resolved when it is sent.
+type ExpectStep struct {
+	Expect string `json:"expect"`
+	Send   string `json:"send"`
+	// Secret keeps Send out of logs and diagnostics.
+	Secret  bool   `json:"secret,omitempty"`
+	Timeout string `json:"timeout,omitempty"`
+	// Optional skips the step when nothing matches within the timeout,
+	// for prompts that only appear sometimes, such as a notice to
+	// acknowledge.
+	Optional bool `json:"optional,omitempty"`
+	// NoEnter types Send without Enter, for menus that act on a single key.
+	NoEnter bool `json:"no_enter,omitempty"`
+}
+
+// timeout returns the step's timeout, or the default.
+func (s ExpectStep) timeout() time.Duration {
+	if d, err := time.ParseDuration(s.Timeout); err == nil && d > 0 {
+		return d
+	}
+	return DefaultExpectTimeout
+}
+
+// check returns what is wrong with the step, if anything.
+func (s ExpectStep) check() error {
+	if s.Expect == "" {
+		return fmt.Errorf(`needs an "expect" pattern`)
+	}
+	if _, err := regexp.Compile(s.Expect); err != nil {
+		return fmt.Errorf("invalid pattern: %v", err)
+	}
+	if s.Timeout != "" {
+		if d, err := time.ParseDuration(s.Timeout); err != nil || d <= 0 {
+			return fmt.Errorf(`"timeout" %q is not a duration such as "5s"`, s.Timeout)
+		}
+	}
+	if _, err := expandReferences(s.Send, func(string) (string, bool) { return "", true }); err != nil {
+		return fmt.Errorf(`"send": %v`, err)
+	}
+	return nil
+}
+
+// expecter collects session output while an expect script runs, so
+// patterns match across read boundaries.
+type expecter struct {
+	mu      sync.Mutex
+	buf     []byte
+	stopped bool
+	update  chan struct{}
+}
+
+func newExpecter() *expecter {
+	return &expecter{update: make(chan struct{}, 1)}
+}
+
+func (e *expecter) Write(p []byte) (int, error) {
+	e.mu.Lock()
+	if !e.stopped {
+		e.buf = append(e.buf, p...)
+		if len(e.buf) > expectBufferSize {
+			e.buf = e.buf[len(e.buf)-expectBufferSize:]
+		}
+	}
+	e.mu.Unlock()
+	select {
+	case e.update <- struct{}{}:
+	default:
+	}
+	return len(p), nil
+}
+
+// stop drops the buffer; output is no longer collected.
+func (e *expecter) stop() {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.stopped, e.buf = true, nil
+}
+
+// wait blocks until re matches the output since the previous match, and
+// consumes the output up to the end of the match. It gives up after
+// timeout or when done is closed.
+func (e *expecter) wait(re *regexp.Regexp, timeout time.Duration, done <-chan struct{}) bool {
+	deadline := time.After(timeout)
+	for {
+		e.mu.Lock()
+		if loc := re.FindIndex(e.buf); loc != nil {
+			e.buf = e.buf[loc[1]:]
+			e.mu.Unlock()
+			return true
+		}
+		e.mu.Unlock()
+		select {
+		case <-e.update:
+		case <-deadline:
+			return false
+		case <-done:
+			return false
+		}
+	}
+}
+
+// runExpect plays the expect script against the session until it is done,
+// a step times out or the output ends (done is closed).
+func (t *Terminal) runExpect(e *expecter, done <-chan struct{}) {
+	defer e.stop()
+	for i, step := range t.Expect {
+		re, err := regexp.Compile(step.Expect)
+		if err != nil {
+			fmt.Fprintf(t.Stderr, "expect_script step %d: invalid pattern: %v\r\n", i+1, err)
+			return
+		}
+		if !e.wait(re, step.timeout(), done) {
+			select {
+			case <-done:
+				return
+			default:
+			}
+			// 可选步骤没有出现时继续下一步，之前的输出仍可被下一步匹配
+			if step.Optional {
+				t.logf(1, "expect_script step %d: nothing matched %q within %s, skipped", i+1, step.Expect, step.timeout())
+				continue
+			}
+			fmt.Fprintf(t.Stderr, "\r\nexpect_script step %d stalled: nothing matched %q within %s, handing over control\r\n",
+				i+1, step.Expect, step.timeout())
+			return
+		}
+		text, err := expandReferences(step.Send, os.LookupEnv)
+		if err != nil {
+			fmt.Fprintf(t.Stderr, "\r\nexpect_script step %d: %v, handing over control\r\n", i+1, err)
+			return
+		}
+		send := fmt.Sprintf("%q", text)
+		if step.Secret {
+			send = redacted
+		}
+		t.logf(1, "expect_script step %d matched %q, sending %s", i+1, step.Expect, send)
+		if !step.NoEnter {
+			text += "\r"
+		}
+		if _, err = io.WriteString(t.stdin, text); err != nil {
+			return
+		}
+	}
+}
+
+// logf logs through t.Log when it is set.
+func (t *Terminal) logf(level int, format string, args ...any) {
+	if t.Log != nil {
+		t.Log(level, format, args...)
+	}
+}
@@ -0,0 +1,149 @@
+package sshtools
+
+import (
+	"strconv"
+	"strings"
+)
+
+// factsScript prints one key=value line per fact. It sticks to POSIX sh
+// and tools found on a minimal Linux install; what a server lacks is
+// simply left out. Everything is gathered in one session so a fleet audit
+// costs one round trip per server.
+const factsScript = `exec 2>/dev/null
+echo "kernel=$(uname -sr)"
+echo "arch=$(uname -m)"
+if [ -r /etc/os-release ]; then
+	(. /etc/os-release; echo "os=${PRETTY_NAME:-$NAME $VERSION}")
+elif command -v sw_vers >/dev/null; then
+	echo "os=macOS $(sw_vers -productVersion)"
+else
+	echo "os=$(uname -s)"
+fi
+echo "cpus=$(getconf _NPROCESSORS_ONLN || nproc || sysctl -n hw.ncpu)"
+if [ -r /proc/cpuinfo ]; then
+	sed -n 's/^model name[^:]*: */cpu_model=/p' /proc/cpuinfo | head -n 1
+else
+	echo "cpu_model=$(sysctl -n machdep.cpu.brand_string)"
+fi
+if [ -r /proc/meminfo ]; then
+	awk '/^(MemTotal|MemAvailable):/ { sub(":", "", $1); print $1 "=" $2 }' /proc/meminfo
+else
+	echo "memsize=$(sysctl -n hw.memsize)"
+fi
+[ -r /proc/uptime ] && awk '{ print "uptime=" $1 }' /proc/uptime
+df -P -k | awk 'NR > 1 { m = $6; for (i = 7; i <= NF; i++) m = m " " $i; print "disk=" $1 "\t" $2 "\t" $3 "\t" $4 "\t" m }'
+true
+`
+
+// virtualFilesystems are df devices that are not disks worth auditing.
+var virtualFilesystems = map[string]bool{
+	"tmpfs": true, "devtmpfs": true, "udev": true, "shm": true, "none": true,
+	"squashfs": true, "devfs": true, "map": true,
+}
+
+// Facts describes a server's system, as gathered by GatherFacts. Sizes are
+// in bytes; zero values mean the server did not report them.
+type Facts struct {
+	Alias           string      `json:"alias"`
+	Address         string      `json:"address"`
+	OS              string      `json:"os,omitempty"`
+	Kernel          string      `json:"kernel,omitempty"`
+	Arch            string      `json:"arch,omitempty"`
+	CPUs            int         `json:"cpus,omitempty"`
+	CPUModel        string      `json:"cpu_model,omitempty"`
+	MemoryTotal     int64       `json:"memory_total,omitempty"`
+	MemoryAvailable int64       `json:"memory_available,omitempty"`
+	UptimeSeconds   float64     `json:"uptime_seconds,omitempty"`
+	Disks           []DiskUsage `json:"disks,omitempty"`
+	Error           string      `json:"error,omitempty"`
+}
+
+// DiskUsage is one mounted filesystem.
+type DiskUsage struct {
+	Mount     string `json:"mount"`
+	Device    string `json:"device"`
+	Size      int64  `json:"size"`
+	Used      int64  `json:"used"`
+	Available int64  `json:"available"`
+}
+
+// Percent returns how full the filesystem is, as df reports it.
+func (d DiskUsage) Percent() float64 {
+	if d.Used+d.Available == 0 {
+		return 0
+	}
+	return float64(d.Used) * 100 / float64(d.Used+d.Available)
+}
+
+// Disk returns the filesystem mounted at mount, or nil.
+func (f *Facts) Disk(mount string) *DiskUsage {
+	for i := range f.Disks {
+		if f.Disks[i].Mount == mount {
+			return &f.Disks[i]
+		}
+	}
+	return nil
+}
+
+// GatherFacts runs the facts commands on the server.
+func (c *Client) GatherFacts() (facts Facts, err error) {
+	facts = Facts{Alias: c.Server.Alias, Address: c.Server.Addr()}
+	out, err := c.Output(factsScript)
+	if err != nil {
+		return
+	}
+	facts.parse(string(out))
+	return
+}
+
+// parse fills f from the output of factsScript.
+func (f *Facts) parse(out string) {
+	for _, line := range strings.Split(out, "\n") {
+		key, value, ok := strings.Cut(strings.TrimRight(line, "\r"), "=")
+		if !ok || strings.TrimSpace(value) == "" {
+			continue
+		}
+		value = strings.TrimSpace(value)
+		switch key {
+		case "kernel":
+			f.Kernel = value
+		case "arch":
+			f.Arch = value
+		case "os":
+			f.OS = strings.Trim(value, `"`)
+		case "cpus":
+			f.CPUs, _ = strconv.Atoi(value)
+		case "cpu_model":
+			f.CPUModel = strings.Join(strings.Fields(value), " ")
+		case "MemTotal":
+			f.MemoryTotal = parseKiB(value)
+		case "MemAvailable":
+			f.MemoryAvailable = parseKiB(value)
+		case "memsize":
+			f.MemoryTotal, _ = strconv.ParseInt(value, 10, 64)
+		case "uptime":
+			f.UptimeSeconds, _ = strconv.ParseFloat(value, 64)
+		case "disk":
+			if d, ok := parseDisk(value); ok {
+				f.Disks = append(f.Disks, d)
+			}
+		}
+	}
+}
+
+// parseDisk parses "device size used available mount" from df -P -k,
+// separated by tabs, skipping virtual and empty filesystems.
+func parseDisk(value string) (d DiskUsage, ok bool) {
+	fields := strings.SplitN(value, "\t", 5)
+	if len(fields) < 5 || virtualFilesystems[fields[0]] {
+		return
+	}
+	d = DiskUsage{Device: fields[0], Mount: fields[4],
+		Size: parseKiB(fields[1]), Used: parseKiB(fields[2]), Available: parseKiB(fields[3])}
+	return d, d.Size > 0
+}
+
+func parseKiB(value string) int64 {
+	n, _ := strconv.ParseInt(strings.TrimSpace(value), 10, 64)
+	return n * 1024
+}
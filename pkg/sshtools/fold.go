@@ -0,0 +1,31 @@
+package sshtools
+
+import (
+	"strings"
+
+	"golang.org/x/text/cases"
+	"golang.org/x/text/unicode/norm"
+)
+
+// turkishI maps the Turkish dotted capital and dotless small i to plain i so
+// aliases typed under a Turkish keyboard layout or locale still match.
+var turkishI = strings.NewReplacer("İ", "i", "ı", "i")
+
+// foldAlias returns the comparison key for an alias: NFC normalized, so
+// composed and decomposed input (as produced by macOS terminals) compare
+// equal, then fully case folded, so e.g. "ß" matches "SS".
+func foldAlias(s string) string {
+	s = turkishI.Replace(norm.NFC.String(s))
+	return norm.NFC.String(cases.Fold().String(s))
+}
+
+// AliasEqual reports whether two aliases are the same under Unicode
+// normalization and case folding.
+func AliasEqual(a, b string) bool {
+	return foldAlias(a) == foldAlias(b)
+}
+
+// AliasKey returns the key aliases are compared by, for maps keyed by alias.
+func AliasKey(alias string) string {
+	return foldAlias(alias)
+}
@@ -0,0 +1,41 @@
+package sshtools
+
+import "testing"
+
+func TestAliasEqual(t *testing.T) {
+	tests := []struct {
+		a, b string
+		want bool
+	}{
+		{"web1", "WEB1", true},
+		// macOS 终端输入的分解形式
+		{"café", "cafe\u0301", true},
+		{"CAFE\u0301", "café", true},
+		{"Straße", "STRASSE", true},
+		// 土耳其语的 İ 和 ı 都当作 i
+		{"İstanbul", "istanbul", true},
+		{"ıstanbul", "ISTANBUL", true},
+		{"dıyarbakır", "DIYARBAKIR", true},
+		{"cafe", "café", false},
+		{"web1", "web2", false},
+	}
+	for _, tt := range tests {
+		if got := AliasEqual(tt.a, tt.b); got != tt.want {
+			t.Errorf("AliasEqual(%q, %q) = %v, want %v", tt.a, tt.b, got, tt.want)
+		}
+	}
+}
+
+func TestAliasKey(t *testing.T) {
+	// 同一别名的各种写法在 map 中是同一个键
+	found := map[string]bool{AliasKey("Café-İzmir"): true}
+	for _, alias := range []string{"cafe\u0301-izmir", "CAFÉ-ıZMİR", "CAFE\u0301-IZMIR"} {
+		if !found[AliasKey(alias)] {
+			t.Errorf("AliasKey(%q) = %q, want %q", alias, AliasKey(alias), AliasKey("Café-İzmir"))
+		}
+	}
+	// 结果是 NFC 形式
+	if got := AliasKey("CAFE\u0301"); got != "café" {
+		t.Errorf("AliasKey = %q, want the composed form", got)
+	}
+}
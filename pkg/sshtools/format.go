@@ -0,0 +1,214 @@
+package sshtools
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+	"gopkg.in/yaml.v3"
+)
+
+// Config file formats, chosen by the file's extension.
+const (
+	FormatJSON = "json"
+	FormatYAML = "yaml"
+	FormatTOML = "toml"
+)
+
+// ConfigFormat returns the format of the config file filename: YAML for
+// .yaml and .yml, TOML for .toml and JSON otherwise.
+func ConfigFormat(filename string) string {
+	switch strings.ToLower(filepath.Ext(filename)) {
+	case ".yaml", ".yml":
+		return FormatYAML
+	case ".toml":
+		return FormatTOML
+	}
+	return FormatJSON
+}
+
+// ReadConfigFile reads the config file filename as JSON, converting YAML
+// and TOML files, so they share the schema and validation of config.json.
+func ReadConfigFile(filename string) (data []byte, err error) {
+	if data, err = os.ReadFile(filename); err != nil {
+		return
+	}
+	return ConvertConfig(data, ConfigFormat(filename), FormatJSON)
+}
+
+// ConvertConfig converts a config file between formats. YAML keeps the
+// order of keys; TOML sorts them.
+func ConvertConfig(data []byte, from, to string) (out []byte, err error) {
+	if from == to {
+		return data, nil
+	}
+	if from != FormatJSON {
+		if data, err = toJSON(data, from); err != nil {
+			return
+		}
+	}
+	switch to {
+	case FormatYAML:
+		return jsonToYAML(data)
+	case FormatTOML:
+		return jsonToTOML(data)
+	}
+	var indented bytes.Buffer
+	if err = json.Indent(&indented, data, "", "  "); err != nil {
+		return
+	}
+	return append(indented.Bytes(), '\n'), nil
+}
+
+// toJSON decodes a YAML or TOML document into JSON.
+func toJSON(data []byte, from string) (out []byte, err error) {
+	if from == FormatTOML {
+		var doc map[string]any
+		if _, err = toml.Decode(string(data), &doc); err != nil {
+			return nil, fmt.Errorf("invalid TOML: %v", err)
+		}
+		return json.Marshal(doc)
+	}
+	var doc yaml.Node
+	if err = yaml.Unmarshal(data, &doc); err != nil {
+		return nil, fmt.Errorf("invalid YAML: %v", err)
+	}
+	if len(doc.Content) == 0 {
+		return []byte("{}"), nil
+	}
+	var b bytes.Buffer
+	if err = writeYAMLNode(&b, doc.Content[0]); err != nil {
+		return
+	}
+	return b.Bytes(), nil
+}
+
+// writeYAMLNode writes node as JSON in the order it is written.
+func writeYAMLNode(b *bytes.Buffer, node *yaml.Node) (err error) {
+	switch node.Kind {
+	case yaml.AliasNode:
+		return writeYAMLNode(b, node.Alias)
+	case yaml.SequenceNode:
+		b.WriteByte('[')
+		for i, item := range node.Content {
+			if i > 0 {
+				b.WriteByte(',')
+			}
+			if err = writeYAMLNode(b, item); err != nil {
+				return
+			}
+		}
+		b.WriteByte(']')
+		return
+	case yaml.MappingNode:
+		// 合并键（<<）需要 yaml 自己展开，此时不保留顺序
+		for i := 0; i < len(node.Content); i += 2 {
+			if node.Content[i].Tag == "!!merge" {
+				var v map[string]any
+				if err = node.Decode(&v); err != nil {
+					return
+				}
+				return writeJSON(b, v)
+			}
+		}
+		b.WriteByte('{')
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			if i > 0 {
+				b.WriteByte(',')
+			}
+			if err = writeJSON(b, node.Content[i].Value); err != nil {
+				return
+			}
+			b.WriteByte(':')
+			if err = writeYAMLNode(b, node.Content[i+1]); err != nil {
+				return
+			}
+		}
+		b.WriteByte('}')
+		return
+	}
+	// 日期（如 sunset）保持原文，不转换为时间戳
+	if node.Tag == "!!timestamp" {
+		return writeJSON(b, node.Value)
+	}
+	var v any
+	if err = node.Decode(&v); err != nil {
+		return
+	}
+	return writeJSON(b, v)
+}
+
+func writeJSON(b *bytes.Buffer, v any) error {
+	data, err := json.Marshal(v)
+	b.Write(data)
+	return err
+}
+
+// jsonToYAML writes a JSON document as block-style YAML in the same order.
+func jsonToYAML(data []byte) (out []byte, err error) {
+	var doc yaml.Node
+	if err = yaml.Unmarshal(data, &doc); err != nil {
+		return
+	}
+	blockStyle(&doc)
+	var b bytes.Buffer
+	encoder := yaml.NewEncoder(&b)
+	encoder.SetIndent(2)
+	if err = encoder.Encode(&doc); err != nil {
+		return
+	}
+	err = encoder.Close()
+	return b.Bytes(), err
+}
+
+// blockStyle drops the flow style and quoting JSON came with; strings that
+// would read as another type stay quoted.
+func blockStyle(node *yaml.Node) {
+	node.Style = 0
+	for _, child := range node.Content {
+		blockStyle(child)
+	}
+}
+
+// jsonToTOML writes a JSON document as TOML. TOML has no null, so null
+// values are left out.
+func jsonToTOML(data []byte) (out []byte, err error) {
+	var doc map[string]any
+	if err = json.Unmarshal(data, &doc); err != nil {
+		return
+	}
+	var b bytes.Buffer
+	encoder := toml.NewEncoder(&b)
+	encoder.Indent = ""
+	err = encoder.Encode(tomlValue(doc))
+	return b.Bytes(), err
+}
+
+// tomlValue prepares a decoded JSON value for the TOML encoder: whole
+// numbers become integers rather than floats such as 22.0.
+func tomlValue(v any) any {
+	switch v := v.(type) {
+	case map[string]any:
+		for key, value := range v {
+			if value == nil {
+				delete(v, key)
+				continue
+			}
+			v[key] = tomlValue(value)
+		}
+	case []any:
+		for i, value := range v {
+			v[i] = tomlValue(value)
+		}
+	case float64:
+		if v == math.Trunc(v) && math.Abs(v) < 1<<53 {
+			return int64(v)
+		}
+	}
+	return v
+}
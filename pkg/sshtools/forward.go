@@ -0,0 +1,171 @@
+package sshtools
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"sync"
+	"syscall"
+)
+
+// Forward is a port forward configured on a server. For local forwards,
+// port 0 in Local picks any free port; for remote forwards, port 0 in
+// Remote lets the server pick. The listening side may be a bare port,
+// bound according to the server's gateway_ports, or bind:port, where a
+// bind address of "*" or "" means every interface.
+type Forward struct {
+	Local  string `json:"local"`
+	Remote string `json:"remote"`
+}
+
+// ErrPortInUse is returned (wrapped) by LocalForward when the local port is
+// already bound.
+var ErrPortInUse = errors.New("address already in use")
+
+// AnyPort returns addr with its port replaced by 0, asking the OS for a free
+// port on the same interface.
+func AnyPort(addr string) string {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		host = "127.0.0.1"
+	}
+	return net.JoinHostPort(host, "0")
+}
+
+// bindAddress turns the listening side of a forward into an address: a
+// bare port goes on the loopback interface, or on every interface (all)
+// with gatewayPorts.
+func bindAddress(addr string, gatewayPorts bool, all string) string {
+	host, port, err := net.SplitHostPort(addr)
+	switch {
+	case err != nil:
+		host, port = "127.0.0.1", addr
+		if gatewayPorts {
+			host = all
+		}
+	case host == "" || host == "*":
+		host = all
+	}
+	return net.JoinHostPort(host, port)
+}
+
+// LocalBindAddress returns the address a local or dynamic forward of s
+// listens on for addr.
+func (s *Server) LocalBindAddress(addr string) string {
+	return bindAddress(addr, s.GatewayPorts, "")
+}
+
+// RemoteBindAddress returns the address a remote forward of s asks the
+// server to listen on for addr. Whether the server honours an address
+// other than loopback depends on GatewayPorts in its sshd_config.
+func (s *Server) RemoteBindAddress(addr string) string {
+	// x/crypto/ssh 无法发送空地址，用 0.0.0.0 表示所有接口
+	return bindAddress(addr, s.GatewayPorts, "0.0.0.0")
+}
+
+// LocalForward listens on localAddr and forwards every accepted connection
+// to remoteAddr through the SSH connection, like ssh -L. Closing the
+// returned listener stops accepting new connections.
+func (c *Client) LocalForward(localAddr, remoteAddr string) (listener net.Listener, err error) {
+	localAddr = c.Server.LocalBindAddress(localAddr)
+	listener, err = net.Listen("tcp", localAddr)
+	if errors.Is(err, syscall.EADDRINUSE) {
+		err = fmt.Errorf("local port %s: %w", localAddr, ErrPortInUse)
+		return
+	}
+	if err != nil {
+		err = fmt.Errorf("failed to listen on %s: %v", localAddr, err)
+		return
+	}
+	go func() {
+		for {
+			conn, errs := listener.Accept()
+			if errs != nil {
+				return
+			}
+			go func(local net.Conn) {
+				remote, errs := c.Dial("tcp", remoteAddr)
+				if errs != nil {
+					fmt.Fprintf(os.Stderr, "forward %s -> %s: %v\n", localAddr, remoteAddr, errs)
+					_ = local.Close()
+					return
+				}
+				pipe(local, remote)
+			}(conn)
+		}
+	}()
+	return
+}
+
+// RemoteForward asks the server to listen on remoteAddr and forwards every
+// connection it accepts to localAddr, like ssh -R. The listener lives as
+// long as the SSH connection; closing it stops the forward.
+func (c *Client) RemoteForward(remoteAddr, localAddr string) (listener net.Listener, err error) {
+	remoteAddr = c.Server.RemoteBindAddress(remoteAddr)
+	listener, err = c.Listen("tcp", remoteAddr)
+	if err != nil {
+		err = fmt.Errorf("server refused to listen on %s: %v", remoteAddr, err)
+		return
+	}
+	go func() {
+		for {
+			conn, errs := listener.Accept()
+			if errs != nil {
+				return
+			}
+			go func(remote net.Conn) {
+				local, errs := net.Dial("tcp", localAddr)
+				if errs != nil {
+					fmt.Fprintf(os.Stderr, "forward %s <- %s: %v\n", localAddr, remoteAddr, errs)
+					_ = remote.Close()
+					return
+				}
+				pipe(local, remote)
+			}(conn)
+		}
+	}()
+	return
+}
+
+// pipe copies data in both directions until either side is done, then
+// closes both.
+func pipe(a, b io.ReadWriteCloser) {
+	var once sync.Once
+	closeBoth := func() {
+		_ = a.Close()
+		_ = b.Close()
+	}
+	var wg sync.WaitGroup
+	wg.Go(func() {
+		_, _ = io.Copy(a, b)
+		once.Do(closeBoth)
+	})
+	wg.Go(func() {
+		_, _ = io.Copy(b, a)
+		once.Do(closeBoth)
+	})
+	wg.Wait()
+}
+
+// ForwardStdio connects to address through the SSH connection and copies
+// stdin to it and what it sends to stdout, like ssh -W, until address
+// closes the connection. The end of stdin is passed on as a half-close.
+func (c *Client) ForwardStdio(address string, stdin io.Reader, stdout io.Writer) (err error) {
+	remote, err := c.Dial("tcp", address)
+	if err != nil {
+		return fmt.Errorf("%s could not connect to %s: %v", c.Server.Alias, address, err)
+	}
+	defer func() {
+		_ = remote.Close()
+	}()
+	go func() {
+		_, _ = io.Copy(remote, stdin)
+		if cw, ok := remote.(interface{ CloseWrite() error }); ok {
+			_ = cw.CloseWrite()
+		}
+	}()
+	_, err = io.Copy(stdout, remote)
+	return
+}
@@ -0,0 +1,89 @@
+package sshtools
+
+import (
+	"slices"
+	"strings"
+)
+
+// fuzzyScore matches the characters of query in order anywhere in text and
+// scores the match: runs of adjacent characters and matches at the start
+// of text or of a word (after - _ . @ / :) score higher. ok is false when
+// text does not contain the characters of query.
+func fuzzyScore(query, text string) (score int, ok bool) {
+	q, t := []rune(foldAlias(query)), []rune(foldAlias(text))
+	if len(q) == 0 {
+		return 0, true
+	}
+	qi, last := 0, -2
+	for ti := 0; ti < len(t) && qi < len(q); ti++ {
+		if t[ti] != q[qi] {
+			continue
+		}
+		score++
+		switch {
+		case ti == last+1:
+			score += 4
+		case ti == 0 || strings.ContainsRune("-_.@/: ", t[ti-1]):
+			score += 3
+		}
+		last = ti
+		qi++
+	}
+	if qi < len(q) {
+		return 0, false
+	}
+	if len(q) == len(t) {
+		score += 10
+	} else if slices.Equal(q, t[:len(q)]) {
+		score += 5
+	}
+	return score, true
+}
+
+// FuzzyScore matches the server against a search query of space-separated
+// words. Every word must match the alias, an address, the user or a tag;
+// the alias counts double. A word #tag only matches servers with that tag.
+func (s *Server) FuzzyScore(query string) (score int, ok bool) {
+	for _, word := range strings.Fields(query) {
+		if tag, isTag := strings.CutPrefix(word, "#"); isTag {
+			if tag != "" && !s.HasTag(tag) {
+				return 0, false
+			}
+			continue
+		}
+		best, found := fuzzyScore(word, s.Alias)
+		best *= 2
+		fields := append([]string{s.User}, s.Tags...)
+		for _, field := range append(fields, s.AddressList()...) {
+			if n, matched := fuzzyScore(word, field); matched {
+				best, found = max(best, n), true
+			}
+		}
+		if !found {
+			return 0, false
+		}
+		score += best
+	}
+	return score, true
+}
+
+// FuzzyFilter returns the servers matching query, best match first; servers
+// that score the same keep their order.
+func FuzzyFilter(servers []*Server, query string) []*Server {
+	type scored struct {
+		server *Server
+		score  int
+	}
+	var matches []scored
+	for _, server := range servers {
+		if score, ok := server.FuzzyScore(query); ok {
+			matches = append(matches, scored{server, score})
+		}
+	}
+	slices.SortStableFunc(matches, func(a, b scored) int { return b.score - a.score })
+	filtered := make([]*Server, len(matches))
+	for i, m := range matches {
+		filtered[i] = m.server
+	}
+	return filtered
+}
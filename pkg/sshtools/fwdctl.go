@@ -0,0 +1,388 @@
+package sshtools
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// forwardControlTimeout bounds one request on a forward control socket.
+const forwardControlTimeout = 30 * time.Second
+
+// ForwardInfo describes a forward that a live session or tunnel has open.
+type ForwardInfo struct {
+	// Kind is "L", "R" or "D", the ssh option that opens the forward.
+	Kind string `json:"kind"`
+	// Listen is where the forward listens: on this machine for L and D, on
+	// the server for R.
+	Listen string `json:"listen"`
+	// Target is where connections go; empty for a SOCKS proxy.
+	Target string `json:"target,omitempty"`
+}
+
+func (f ForwardInfo) String() string {
+	switch f.Kind {
+	case "R":
+		return fmt.Sprintf("-R %s (on the server) -> %s", f.Listen, f.Target)
+	case "D":
+		return fmt.Sprintf("-D %s (SOCKS5 proxy)", f.Listen)
+	}
+	return fmt.Sprintf("-L %s -> %s", f.Listen, f.Target)
+}
+
+// ForwardController manages the forwards of a live connection, for ~C and
+// sshtools fwd. spec is an ssh style forward spec for kind; listen is the
+// [bind:]port the forward to cancel listens on.
+type ForwardController interface {
+	ListForwards() []ForwardInfo
+	AddForward(kind, spec string) (ForwardInfo, error)
+	CancelForward(kind, listen string) error
+}
+
+// ParseForwardCommand parses a ~C command line such as "-L 8080:db:80"
+// or "-KL 8080" into whether it cancels a forward, its kind and the spec.
+func ParseForwardCommand(line string) (cancel bool, kind, spec string, err error) {
+	line = strings.TrimSpace(line)
+	if !strings.HasPrefix(line, "-") {
+		return false, "", "", fmt.Errorf("invalid command %q", line)
+	}
+	option := line[1:]
+	if strings.HasPrefix(option, "K") {
+		cancel, option = true, option[1:]
+	}
+	if option == "" || !strings.ContainsRune("LRD", rune(option[0])) {
+		return false, "", "", fmt.Errorf("invalid command %q", line)
+	}
+	kind, spec = option[:1], strings.TrimSpace(option[1:])
+	if spec == "" {
+		return false, "", "", fmt.Errorf("missing forward spec in %q", line)
+	}
+	return
+}
+
+// matchListen reports whether the forward listening on have is the one
+// the user means by want, a [bind:]port. A bare port matches any
+// interface; "*", "" and unspecified addresses all mean every interface.
+func matchListen(have, want string) bool {
+	haveHost, havePort, err := net.SplitHostPort(have)
+	if err != nil {
+		return false
+	}
+	wantHost, wantPort, err := net.SplitHostPort(want)
+	if err != nil {
+		return want == havePort
+	}
+	if wantPort != havePort {
+		return false
+	}
+	all := func(host string) bool {
+		ip := net.ParseIP(host)
+		return host == "" || host == "*" || ip != nil && ip.IsUnspecified()
+	}
+	return haveHost == wantHost || all(haveHost) && all(wantHost) ||
+		wantHost == "localhost" && net.ParseIP(haveHost).IsLoopback()
+}
+
+// ForwardSet tracks the forwards opened on a connection, so that they can
+// be listed and cancelled while it is up. Closing the connection ends
+// them all; Close stops their listeners.
+type ForwardSet struct {
+	mu      sync.Mutex
+	client  *Client
+	entries []forwardEntry
+}
+
+// NewForwardSet returns an empty set for forwards on client.
+func NewForwardSet(client *Client) *ForwardSet {
+	return &ForwardSet{client: client}
+}
+
+// Reset stops the forwards and moves the set to client, after a reconnect.
+func (s *ForwardSet) Reset(client *Client) {
+	s.Close()
+	s.mu.Lock()
+	s.client = client
+	s.mu.Unlock()
+}
+
+type forwardEntry struct {
+	info     ForwardInfo
+	listener net.Listener
+}
+
+// Track adds a forward opened on the set's client, e.g. with LocalForward.
+func (s *ForwardSet) Track(kind string, listener net.Listener, target string) ForwardInfo {
+	info := ForwardInfo{Kind: kind, Listen: listener.Addr().String(), Target: target}
+	s.mu.Lock()
+	s.entries = append(s.entries, forwardEntry{info: info, listener: listener})
+	s.mu.Unlock()
+	return info
+}
+
+// ListForwards lists the forwards in the order they were opened.
+func (s *ForwardSet) ListForwards() (forwards []ForwardInfo) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, e := range s.entries {
+		forwards = append(forwards, e.info)
+	}
+	return
+}
+
+// AddForward opens a forward on the set's client.
+func (s *ForwardSet) AddForward(kind, spec string) (info ForwardInfo, err error) {
+	s.mu.Lock()
+	client := s.client
+	s.mu.Unlock()
+	var listener net.Listener
+	var target string
+	switch kind {
+	case "L":
+		f, errs := ParseForwardSpec(spec)
+		if errs != nil {
+			return info, errs
+		}
+		listener, err = client.LocalForward(f.Local, f.Remote)
+		target = f.Remote
+	case "R":
+		f, errs := ParseRemoteForwardSpec(spec)
+		if errs != nil {
+			return info, errs
+		}
+		listener, err = client.RemoteForward(f.Remote, f.Local)
+		target = f.Local
+	case "D":
+		addr, errs := ParseDynamicSpec(spec)
+		if errs != nil {
+			return info, errs
+		}
+		listener, err = client.DynamicForward(addr)
+	default:
+		return info, fmt.Errorf("unknown forward kind %q, want L, R or D", kind)
+	}
+	if err != nil {
+		return
+	}
+	return s.Track(kind, listener, target), nil
+}
+
+// CancelForward stops the forward of kind listening on listen.
+func (s *ForwardSet) CancelForward(kind, listen string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for i, e := range s.entries {
+		if e.info.Kind == kind && matchListen(e.info.Listen, listen) {
+			s.entries = slices.Delete(s.entries, i, i+1)
+			return e.listener.Close()
+		}
+	}
+	return fmt.Errorf("no -%s forward listens on %s", kind, listen)
+}
+
+// Close stops all the forwards.
+func (s *ForwardSet) Close() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, e := range s.entries {
+		_ = e.listener.Close()
+	}
+	s.entries = nil
+}
+
+// forwardControlRequest is what sshtools fwd sends: Op is "list", "add"
+// or "cancel".
+type forwardControlRequest struct {
+	Op   string `json:"op"`
+	Kind string `json:"kind,omitempty"`
+	Spec string `json:"spec,omitempty"`
+}
+
+type forwardControlResponse struct {
+	Forwards []ForwardInfo `json:"forwards,omitempty"`
+	Error    string        `json:"error,omitempty"`
+}
+
+// ForwardControlDir returns the directory of the forward control sockets.
+func ForwardControlDir() (string, error) {
+	return StateDir("sockets", "fwd")
+}
+
+// ForwardControlPath returns the socket through which sshtools fwd reaches
+// the session or tunnel published as key.
+func ForwardControlPath(key string) (path string, err error) {
+	dir, err := ForwardControlDir()
+	if err != nil {
+		return
+	}
+	return filepath.Join(dir, key+".sock"), nil
+}
+
+// ForwardControls returns the keys of the sessions and tunnels that accept
+// sshtools fwd, removing sockets left behind by crashed processes.
+func ForwardControls() (keys []string, err error) {
+	dir, err := ForwardControlDir()
+	if err != nil {
+		return
+	}
+	paths, err := filepath.Glob(filepath.Join(dir, "*.sock"))
+	if err != nil {
+		return
+	}
+	for _, path := range paths {
+		conn, errs := net.Dial("unix", path)
+		if errs != nil {
+			_ = os.Remove(path)
+			continue
+		}
+		_ = conn.Close()
+		keys = append(keys, strings.TrimSuffix(filepath.Base(path), ".sock"))
+	}
+	return
+}
+
+// ErrForwardControlInUse is returned by ListenForwardControl when another
+// process already serves the key.
+var ErrForwardControlInUse = errors.New("forward control socket already in use")
+
+// ForwardControl serves a ForwardController on a unix socket.
+type ForwardControl struct {
+	path     string
+	listener net.Listener
+}
+
+// ListenForwardControl serves ctl on the socket of key, readable and
+// writable only by the owner.
+func ListenForwardControl(key string, ctl ForwardController) (fc *ForwardControl, err error) {
+	path, err := ForwardControlPath(key)
+	if err != nil {
+		return
+	}
+	listener, inUse, err := listenPrivateSocket(path)
+	if inUse {
+		return nil, fmt.Errorf("%s: %w", path, ErrForwardControlInUse)
+	}
+	if err != nil {
+		return
+	}
+	fc = &ForwardControl{path: path, listener: listener}
+	go fc.serve(ctl)
+	return
+}
+
+// listenPrivateSocket listens on the unix socket path, readable and
+// writable only by the owner. inUse is set when another process serves it.
+func listenPrivateSocket(path string) (listener net.Listener, inUse bool, err error) {
+	if _, errs := os.Stat(path); errs == nil {
+		// 只清理崩溃进程留下的套接字，不抢占仍在使用的
+		if conn, errs := net.Dial("unix", path); errs == nil {
+			_ = conn.Close()
+			return nil, true, nil
+		}
+		_ = os.Remove(path)
+	}
+	listener, err = net.Listen("unix", path)
+	if err != nil {
+		return nil, false, fmt.Errorf("failed to listen on %s: %v", path, err)
+	}
+	if err = os.Chmod(path, 0o600); err != nil {
+		_ = listener.Close()
+		return nil, false, err
+	}
+	return
+}
+
+// Close stops serving and removes the socket.
+func (fc *ForwardControl) Close() error {
+	err := fc.listener.Close()
+	_ = os.Remove(fc.path)
+	return err
+}
+
+func (fc *ForwardControl) serve(ctl ForwardController) {
+	for {
+		conn, err := fc.listener.Accept()
+		if err != nil {
+			return
+		}
+		go func(conn net.Conn) {
+			defer func() { _ = conn.Close() }()
+			_ = conn.SetDeadline(time.Now().Add(forwardControlTimeout))
+			var req forwardControlRequest
+			if err := json.NewDecoder(conn).Decode(&req); err != nil {
+				return
+			}
+			var res forwardControlResponse
+			switch req.Op {
+			case "list":
+				res.Forwards = ctl.ListForwards()
+			case "add":
+				info, errs := ctl.AddForward(req.Kind, req.Spec)
+				if errs != nil {
+					res.Error = errs.Error()
+				} else {
+					res.Forwards = []ForwardInfo{info}
+				}
+			case "cancel":
+				if errs := ctl.CancelForward(req.Kind, req.Spec); errs != nil {
+					res.Error = errs.Error()
+				}
+			default:
+				res.Error = fmt.Sprintf("unknown request %q", req.Op)
+			}
+			_ = json.NewEncoder(conn).Encode(res)
+		}(conn)
+	}
+}
+
+// forwardControlCall sends one request to the session or tunnel of key.
+func forwardControlCall(key string, req forwardControlRequest) (forwards []ForwardInfo, err error) {
+	path, err := ForwardControlPath(key)
+	if err != nil {
+		return
+	}
+	conn, err := net.Dial("unix", path)
+	if err != nil {
+		return nil, fmt.Errorf("no session or tunnel %q accepts forwards (see sshtools fwd list)", key)
+	}
+	defer func() { _ = conn.Close() }()
+	_ = conn.SetDeadline(time.Now().Add(forwardControlTimeout))
+	if err = json.NewEncoder(conn).Encode(req); err != nil {
+		return
+	}
+	var res forwardControlResponse
+	if err = json.NewDecoder(conn).Decode(&res); err != nil {
+		return nil, fmt.Errorf("failed to read the reply of %s: %v", key, err)
+	}
+	if res.Error != "" {
+		return nil, errors.New(res.Error)
+	}
+	return res.Forwards, nil
+}
+
+// ListForwards returns the forwards open in the session or tunnel of key.
+func ListForwards(key string) ([]ForwardInfo, error) {
+	return forwardControlCall(key, forwardControlRequest{Op: "list"})
+}
+
+// AddForward opens a forward in the session or tunnel of key.
+func AddForward(key, kind, spec string) (info ForwardInfo, err error) {
+	forwards, err := forwardControlCall(key, forwardControlRequest{Op: "add", Kind: kind, Spec: spec})
+	if err == nil && len(forwards) == 1 {
+		info = forwards[0]
+	}
+	return
+}
+
+// CancelForward stops the forward of kind listening on listen in the
+// session or tunnel of key.
+func CancelForward(key, kind, listen string) (err error) {
+	_, err = forwardControlCall(key, forwardControlRequest{Op: "cancel", Kind: kind, Spec: listen})
+	return
+}
@@ -0,0 +1,245 @@
+package sshtools
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// historyFile records past sessions and exec commands, oldest first.
+const historyFile = "history.json"
+
+// maxHistory bounds the number of entries kept.
+const maxHistory = 5000
+
+// HistoryEntry is one interactive session, or one command run with
+// sshtools exec or run on one server.
+type HistoryEntry struct {
+	Alias   string    `json:"alias"`
+	Address string    `json:"address"`
+	Time    time.Time `json:"time"`
+	Success bool      `json:"success"`
+	Seconds float64   `json:"duration_seconds"`
+	Error   string    `json:"error,omitempty"`
+	// Command is the command that was run; empty for a session.
+	Command  string `json:"command,omitempty"`
+	ExitCode int    `json:"exit_code,omitempty"`
+	// ReadOnly is set for a session watched with -read-only, which sent
+	// no input.
+	ReadOnly bool `json:"read_only,omitempty"`
+}
+
+// IsSession reports whether e is an interactive session rather than a
+// command.
+func (e HistoryEntry) IsSession() bool {
+	return e.Command == ""
+}
+
+// Duration returns how long the session or command lasted.
+func (e HistoryEntry) Duration() time.Duration {
+	return time.Duration(e.Seconds * float64(time.Second))
+}
+
+// HistoryPath returns the path of the history file.
+func HistoryPath() (string, error) {
+	dir, err := StateDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, historyFile), nil
+}
+
+// LoadHistory reads the recorded sessions and commands, oldest first. A missing file is
+// an empty history.
+func LoadHistory() (entries []HistoryEntry, err error) {
+	file, err := HistoryPath()
+	if err != nil {
+		return
+	}
+	return loadHistory(file)
+}
+
+func loadHistory(file string) (entries []HistoryEntry, err error) {
+	data, err := os.ReadFile(file)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return
+	}
+	if err = json.Unmarshal(data, &entries); err != nil {
+		entries, err = nil, fmt.Errorf("failed to parse %s: %v", file, err)
+	}
+	return
+}
+
+// RecordHistory appends entries to the history. Other sshtools processes
+// may be doing the same, so the file is locked while it is rewritten.
+func RecordHistory(entries ...HistoryEntry) (err error) {
+	file, err := HistoryPath()
+	if err != nil {
+		return
+	}
+	return recordHistory(file, entries...)
+}
+
+func recordHistory(file string, entries ...HistoryEntry) (err error) {
+	unlock, err := lockState(file)
+	if err != nil {
+		return
+	}
+	defer unlock()
+
+	// An unreadable history is kept as history.json.bad and started afresh
+	// rather than blocking new records forever.
+	history, errs := loadHistory(file)
+	if errs != nil {
+		if err = os.Rename(file, file+".bad"); err != nil {
+			err = fmt.Errorf("%v, and failed to move it aside: %v", errs, err)
+			return
+		}
+	}
+	history = append(history, entries...)
+	if len(history) > maxHistory {
+		history = history[len(history)-maxHistory:]
+	}
+	data, err := json.MarshalIndent(history, "", "  ")
+	if err != nil {
+		return
+	}
+	tmp := file + ".tmp"
+	if err = os.WriteFile(tmp, data, 0o600); err == nil {
+		err = os.Rename(tmp, file)
+	}
+	return
+}
+
+// ClearHistory deletes the history.
+func ClearHistory() (err error) {
+	file, err := HistoryPath()
+	if err != nil {
+		return
+	}
+	unlock, err := lockState(file)
+	if err != nil {
+		return
+	}
+	defer unlock()
+	if err = os.Remove(file); errors.Is(err, os.ErrNotExist) {
+		err = nil
+	}
+	return
+}
+
+// HistoryFilter selects history entries. Zero fields match everything.
+type HistoryFilter struct {
+	// Alias is a glob or prefix of the alias, as for sshtools exec -alias.
+	Alias string
+	// Since and Until bound the start time of the entries.
+	Since, Until time.Time
+	// Sessions and Commands keep only sessions or only commands.
+	Sessions, Commands bool
+}
+
+// Match reports whether e passes the filter.
+func (f HistoryFilter) Match(e HistoryEntry) bool {
+	if f.Sessions && !e.IsSession() || f.Commands && e.IsSession() {
+		return false
+	}
+	if !f.Since.IsZero() && e.Time.Before(f.Since) || !f.Until.IsZero() && !e.Time.Before(f.Until) {
+		return false
+	}
+	if f.Alias == "" {
+		return true
+	}
+	pattern, alias := foldAlias(f.Alias), foldAlias(e.Alias)
+	if isGlob(pattern) {
+		ok, _ := path.Match(pattern, alias)
+		return ok
+	}
+	return strings.HasPrefix(alias, pattern)
+}
+
+// ParseHistoryTime parses a -since or -until value: a date, a date and
+// time, or a duration back from now such as 2h or 7d.
+func ParseHistoryTime(value string, now time.Time) (t time.Time, err error) {
+	for _, layout := range []string{"2006-01-02", "2006-01-02 15:04", "2006-01-02T15:04:05"} {
+		if t, err = time.ParseInLocation(layout, value, time.Local); err == nil {
+			return
+		}
+	}
+	// time.ParseDuration 不认识天
+	if days, ok := strings.CutSuffix(value, "d"); ok {
+		if n, errs := strconv.Atoi(days); errs == nil && n >= 0 {
+			return now.AddDate(0, 0, -n), nil
+		}
+	}
+	if d, errs := time.ParseDuration(value); errs == nil && d >= 0 {
+		return now.Add(-d), nil
+	}
+	return t, fmt.Errorf("invalid time %q, want YYYY-MM-DD, \"YYYY-MM-DD HH:MM\" or a duration such as 2h or 7d", value)
+}
+
+// Recent returns the latest session of each of the last n distinct aliases
+// in entries, most recent first. n <= 0 means all of them.
+func Recent(entries []HistoryEntry, n int) (recent []HistoryEntry) {
+	seen := map[string]bool{}
+	for i := len(entries) - 1; i >= 0 && (n <= 0 || len(recent) < n); i-- {
+		if e := entries[i]; e.IsSession() && !seen[e.Alias] {
+			seen[e.Alias] = true
+			recent = append(recent, e)
+		}
+	}
+	return
+}
+
+// frecencyWeights weigh a session by its age: recent sessions count the
+// most, old ones still a little.
+var frecencyWeights = []struct {
+	age    time.Duration
+	weight float64
+}{
+	{4 * 24 * time.Hour, 100},
+	{14 * 24 * time.Hour, 70},
+	{31 * 24 * time.Hour, 50},
+	{90 * 24 * time.Hour, 30},
+}
+
+// Frecency scores the aliases in entries by how often and how recently
+// they were connected to, as of now. Aliases are folded like AliasEqual.
+func Frecency(entries []HistoryEntry, now time.Time) map[string]float64 {
+	scores := map[string]float64{}
+	for _, e := range entries {
+		if !e.IsSession() {
+			continue
+		}
+		weight := 10.0
+		for _, w := range frecencyWeights {
+			if now.Sub(e.Time) <= w.age {
+				weight = w.weight
+				break
+			}
+		}
+		scores[foldAlias(e.Alias)] += weight
+	}
+	return scores
+}
+
+// SortByUse orders servers for picking: favorites first, then by Frecency,
+// keeping the config's order among equals.
+func SortByUse(servers []*Server, entries []HistoryEntry, now time.Time) {
+	scores := Frecency(entries, now)
+	sort.SliceStable(servers, func(i, j int) bool {
+		if servers[i].Favorite != servers[j].Favorite {
+			return servers[i].Favorite
+		}
+		return scores[foldAlias(servers[i].Alias)] > scores[foldAlias(servers[j].Alias)]
+	})
+}
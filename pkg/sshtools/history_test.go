@@ -0,0 +1,29 @@
+package sshtools
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestRecordHistoryKeepsUnreadableFile(t *testing.T) {
+	file := filepath.Join(t.TempDir(), historyFile)
+	if err := os.WriteFile(file, []byte(`[{"alias": "web1",`), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	// 无法解析的历史移到 .bad，新记录照常写入
+	if err := recordHistory(file, HistoryEntry{Alias: "web2"}); err != nil {
+		t.Fatalf("recordHistory: %v", err)
+	}
+	if data, err := os.ReadFile(file + ".bad"); err != nil || string(data) != `[{"alias": "web1",` {
+		t.Errorf("history.json.bad = %q, %v, want the unreadable history", data, err)
+	}
+	if err := recordHistory(file, HistoryEntry{Alias: "web3"}); err != nil {
+		t.Fatalf("recordHistory: %v", err)
+	}
+	history, err := loadHistory(file)
+	if err != nil || len(history) != 2 || history[0].Alias != "web2" || history[1].Alias != "web3" {
+		t.Errorf("history = %+v, %v, want web2 and web3", history, err)
+	}
+}
@@ -0,0 +1,53 @@
+package sshtools
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"runtime"
+	"strconv"
+	"time"
+)
+
+// runLocalHook runs a local_pre or local_post command of server through the
+// shell, with the %h, %p, %r and %n tokens of proxy_command expanded and
+// the server in SSHTOOLS_* variables. Its output goes to stderr so that it
+// does not mix with the output of exec.
+func runLocalHook(name, command string, server *Server, extra ...string) error {
+	command = expandProxyCommand(command, server)
+	var cmd *exec.Cmd
+	if runtime.GOOS == "windows" {
+		cmd = exec.Command("cmd", "/C", command)
+	} else {
+		cmd = exec.Command("sh", "-c", command)
+	}
+	cmd.Stdout = os.Stderr
+	cmd.Stderr = os.Stderr
+	cmd.Env = append(os.Environ(),
+		"SSHTOOLS_ALIAS="+server.Alias,
+		"SSHTOOLS_HOST="+server.Address,
+		"SSHTOOLS_PORT="+strconv.Itoa(server.Port),
+		"SSHTOOLS_USER="+server.User,
+	)
+	cmd.Env = append(cmd.Env, extra...)
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("%s for %s failed: %v", name, server.Alias, err)
+	}
+	return nil
+}
+
+// runLocalPost runs the local_post hook of the connection once, when it is
+// closed, with how long it lasted in SSHTOOLS_DURATION (whole seconds).
+// A failing hook is only logged: the session is over either way.
+func (c *Client) runLocalPost() {
+	if c.Server == nil || c.Server.LocalPost == "" {
+		return
+	}
+	c.postOnce.Do(func() {
+		duration := int(time.Since(c.connected).Seconds())
+		c.dialer.Logf(1, "running local_post %q", c.Server.LocalPost)
+		if err := runLocalHook("local_post", c.Server.LocalPost, c.Server, "SSHTOOLS_DURATION="+strconv.Itoa(duration)); err != nil {
+			fmt.Fprintln(os.Stderr, "Warning:", err)
+		}
+	})
+}
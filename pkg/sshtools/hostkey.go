@@ -0,0 +1,168 @@
+package sshtools
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"net"
+	"slices"
+	"strings"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// HostKeyMismatchError is returned when a server presents a host key other
+// than the one pinned in its host_key_fingerprint.
+type HostKeyMismatchError struct {
+	Alias    string
+	Expected string
+	Actual   string
+}
+
+func (e *HostKeyMismatchError) Error() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "WARNING: the host key of %s does not match its host_key_fingerprint, refusing to connect.\n", e.Alias)
+	b.WriteString("Someone could be intercepting the connection, or the server's key was replaced.\n")
+	fmt.Fprintf(&b, "  - expected: %s\n", e.Expected)
+	fmt.Fprintf(&b, "  + offered:  %s\n", e.Actual)
+	b.WriteString("If the change is expected, update host_key_fingerprint (see sshtools fingerprint).")
+	return b.String()
+}
+
+// hostKeyPin is a parsed host_key_fingerprint: a SHA256 fingerprint,
+// optionally preceded by the key type, or a full public key.
+type hostKeyPin struct {
+	keyType     string
+	fingerprint string
+}
+
+// parseHostKeyPin accepts "SHA256:...", "ssh-ed25519 SHA256:...", the bare
+// base64 of a SHA256 fingerprint, a base64 public key, or
+// "ssh-ed25519 AAAA..." as in known_hosts.
+func parseHostKeyPin(pin string) (p hostKeyPin, err error) {
+	fields := strings.Fields(pin)
+	switch len(fields) {
+	case 1:
+	case 2, 3: // a trailing comment is allowed after a full key
+		p.keyType, fields = fields[0], fields[1:]
+	default:
+		return p, fmt.Errorf(`"host_key_fingerprint" %q is not a SHA256 fingerprint or a public key`, pin)
+	}
+	if strings.HasPrefix(fields[0], "SHA256:") {
+		// ssh-keygen 的指纹不带 base64 填充
+		p.fingerprint = strings.TrimRight(fields[0], "=")
+		return
+	}
+	// 不带 "SHA256:" 前缀的指纹（如从控制台复制）是 32 字节摘要的无填充 base64
+	if sum, errs := base64.RawStdEncoding.DecodeString(strings.TrimRight(fields[0], "=")); errs == nil && len(sum) == sha256.Size {
+		p.fingerprint = "SHA256:" + base64.RawStdEncoding.EncodeToString(sum)
+		return
+	}
+	raw, err := base64.StdEncoding.DecodeString(fields[0])
+	if err != nil {
+		return p, fmt.Errorf(`"host_key_fingerprint" %q is not a SHA256 fingerprint or a public key`, pin)
+	}
+	key, err := ssh.ParsePublicKey(raw)
+	if err != nil {
+		return p, fmt.Errorf(`"host_key_fingerprint" has an invalid public key: %v`, err)
+	}
+	if p.keyType != "" && p.keyType != key.Type() {
+		return p, fmt.Errorf(`"host_key_fingerprint" says %s but the key is %s`, p.keyType, key.Type())
+	}
+	return hostKeyPin{keyType: key.Type(), fingerprint: ssh.FingerprintSHA256(key)}, nil
+}
+
+func (p hostKeyPin) String() string {
+	if p.keyType == "" {
+		return p.fingerprint
+	}
+	return p.keyType + " " + p.fingerprint
+}
+
+// hostKeyAlgorithms returns the host key algorithms that use keys of
+// keyType, or nil if the type is unknown.
+func hostKeyAlgorithms(keyType string) []string {
+	switch keyType {
+	case "":
+		return nil
+	case ssh.KeyAlgoRSA:
+		return []string{ssh.KeyAlgoRSASHA512, ssh.KeyAlgoRSASHA256, ssh.KeyAlgoRSA}
+	}
+	return []string{keyType}
+}
+
+// pinHostKey makes sshConfig accept only server's pinned host key. With a
+// known key type, only algorithms for that type are negotiated so servers
+// with several keys present the pinned one.
+func pinHostKey(sshConfig *ssh.ClientConfig, server *Server) (err error) {
+	pin, err := parseHostKeyPin(server.HostKeyFingerprint)
+	if err != nil {
+		return
+	}
+	if algorithms := hostKeyAlgorithms(pin.keyType); len(sshConfig.HostKeyAlgorithms) == 0 {
+		sshConfig.HostKeyAlgorithms = algorithms
+	} else if algorithms != nil {
+		sshConfig.HostKeyAlgorithms = slices.DeleteFunc(slices.Clone(sshConfig.HostKeyAlgorithms), func(a string) bool {
+			return !slices.Contains(algorithms, a)
+		})
+	}
+	sshConfig.HostKeyCallback = func(hostname string, remote net.Addr, key ssh.PublicKey) error {
+		// 服务器出示主机证书时，比对证书所签的密钥
+		if cert, ok := key.(*ssh.Certificate); ok && ssh.FingerprintSHA256(cert.Key) == pin.fingerprint {
+			return nil
+		}
+		if ssh.FingerprintSHA256(key) != pin.fingerprint {
+			return &HostKeyMismatchError{
+				Alias:    server.Alias,
+				Expected: pin.String(),
+				Actual:   key.Type() + " " + ssh.FingerprintSHA256(key),
+			}
+		}
+		return nil
+	}
+	return
+}
+
+// hostKeyProbeAlgorithms are negotiated one at a time by FetchHostKeys.
+var hostKeyProbeAlgorithms = []string{
+	ssh.KeyAlgoED25519,
+	ssh.KeyAlgoECDSA256,
+	ssh.KeyAlgoECDSA384,
+	ssh.KeyAlgoECDSA521,
+	ssh.KeyAlgoRSASHA512,
+	ssh.KeyAlgoRSASHA256,
+	ssh.KeyAlgoRSA,
+}
+
+// FetchHostKeys returns every host key server offers, one handshake per
+// algorithm, without authenticating.
+func (d *Dialer) FetchHostKeys(server *Server) (keys []ssh.PublicKey, err error) {
+	var failures []string
+	for _, algorithm := range hostKeyProbeAlgorithms {
+		key, handshake, errs := d.fetchHostKey(server, Algorithms{HostKeys: []string{algorithm}})
+		if errs != nil && !handshake {
+			return nil, errs
+		}
+		if errs != nil {
+			failures = append(failures, errs.Error())
+			continue
+		}
+		if !containsKey(keys, key) {
+			keys = append(keys, key)
+		}
+	}
+	if len(keys) == 0 {
+		err = fmt.Errorf("%s: no host key could be fetched: %s", server.Addr(), strings.Join(failures, "; "))
+	}
+	return
+}
+
+func containsKey(keys []ssh.PublicKey, key ssh.PublicKey) bool {
+	for _, k := range keys {
+		if bytes.Equal(k.Marshal(), key.Marshal()) {
+			return true
+		}
+	}
+	return false
+}
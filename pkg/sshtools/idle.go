@@ -0,0 +1,196 @@
+package sshtools
+
+import (
+	"crypto/subtle"
+	"fmt"
+	"io"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Idle actions for idle_action.
+const (
+	IdleDisconnect = "disconnect"
+	IdleLock       = "lock"
+	// IdleCommand only sends idle_command and keeps the session.
+	IdleCommand = "command"
+)
+
+// idleCommandGrace is how long idle_command gets to reach the server
+// before the session is closed.
+const idleCommandGrace = time.Second
+
+// idleWarning is how long before the idle timeout a warning is shown.
+const idleWarning = 30 * time.Second
+
+// idleReplaySize is how much recent output is kept to redraw the screen
+// after unlocking.
+const idleReplaySize = 64 << 10
+
+// checkIdle validates idle_timeout and idle_action.
+func checkIdle(timeout, action string) (msgs []string) {
+	if timeout != "" {
+		if d, err := time.ParseDuration(timeout); err != nil || d <= 0 {
+			msgs = append(msgs, fmt.Sprintf(`"idle_timeout" %q is not a duration such as "15m"`, timeout))
+		}
+	}
+	switch action {
+	case "", IdleDisconnect, IdleLock, IdleCommand:
+	default:
+		msgs = append(msgs, fmt.Sprintf(`"idle_action" %q must be %s, %s or %s`, action, IdleDisconnect, IdleLock, IdleCommand))
+	}
+	return
+}
+
+// idleGuard ends or locks a session when no key has been typed for a
+// while, sending command to it first if set. Only local input counts:
+// remote output such as tail -f does not keep the session alive. While
+// locked, output is kept from the screen but recorded, and input goes to
+// the unlock prompt.
+type idleGuard struct {
+	timeout  time.Duration
+	action   string
+	command  string
+	password string
+	screen   io.Writer
+	// stdin is the session's input, which command is typed into.
+	stdin io.Writer
+
+	last atomic.Int64
+
+	mu     sync.Mutex
+	locked bool
+	warned bool
+	recent []byte
+	typed  []byte
+}
+
+func newIdleGuard(timeout time.Duration, action, command, password string, screen, stdin io.Writer) *idleGuard {
+	g := &idleGuard{timeout: timeout, action: action, command: command, password: password, screen: screen, stdin: stdin}
+	g.touch()
+	return g
+}
+
+// touch records local input.
+func (g *idleGuard) touch() {
+	g.last.Store(time.Now().UnixNano())
+	g.mu.Lock()
+	g.warned = false
+	g.mu.Unlock()
+}
+
+// isLocked reports whether the session is locked.
+func (g *idleGuard) isLocked() bool {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return g.locked
+}
+
+// writer returns w gated by the lock. Everything written is also kept
+// for redrawing the screen.
+func (g *idleGuard) writer(w io.Writer) io.Writer {
+	return &idleWriter{g: g, w: w}
+}
+
+type idleWriter struct {
+	g *idleGuard
+	w io.Writer
+}
+
+func (iw *idleWriter) Write(p []byte) (int, error) {
+	g := iw.g
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	// Trimmed only once it is twice the size, to copy less often.
+	g.recent = append(g.recent, p...)
+	if len(g.recent) > 2*idleReplaySize {
+		g.recent = append(g.recent[:0], g.recent[len(g.recent)-idleReplaySize:]...)
+	}
+	if g.locked {
+		return len(p), nil
+	}
+	return iw.w.Write(p)
+}
+
+// watch checks for idleness every second until stop is closed, warning
+// first and then sending the command and calling expire, or locking the
+// screen.
+func (g *idleGuard) watch(stop <-chan struct{}, expire func()) {
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+	warning := "the session will be closed"
+	switch g.action {
+	case IdleLock:
+		warning = "the session will be locked"
+	case IdleCommand:
+		warning = fmt.Sprintf("%q will be sent", g.command)
+	}
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+		}
+		idle := time.Since(time.Unix(0, g.last.Load()))
+		g.mu.Lock()
+		if !g.locked && idle >= g.timeout && g.command != "" {
+			// 像用户输入一样发送，如 clear 清屏或 exit 退出登录
+			_, _ = io.WriteString(g.stdin, g.command+"\r")
+		}
+		switch {
+		case g.locked:
+		case idle >= g.timeout && g.action == IdleLock:
+			g.locked, g.typed = true, g.typed[:0]
+			fmt.Fprintf(g.screen, "\x1b[2J\x1b[H\x1b[7m[idle] session locked after %s without input\x1b[0m\r\nPassword: ", g.timeout)
+		case idle >= g.timeout && g.action == IdleCommand:
+			// 发送后重新计时
+			g.last.Store(time.Now().UnixNano())
+			g.warned = false
+		case idle >= g.timeout:
+			g.mu.Unlock()
+			if g.command != "" {
+				time.Sleep(idleCommandGrace)
+			}
+			expire()
+			return
+		case idle >= g.timeout-idleWarning && g.timeout > idleWarning && !g.warned:
+			g.warned = true
+			fmt.Fprintf(g.screen, "\r\n\x1b[7m[idle] no input for %s, %s in %s\x1b[0m\r\n",
+				idle.Round(time.Second), warning, (g.timeout - idle).Round(time.Second))
+		}
+		g.mu.Unlock()
+	}
+}
+
+// input handles keystrokes while locked: the password is read without
+// echo, raw mode being on, and the screen is redrawn once it matches.
+func (g *idleGuard) input(p []byte) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	for _, b := range p {
+		switch b {
+		case '\r', '\n':
+			ok := subtle.ConstantTimeCompare(g.typed, []byte(g.password)) == 1
+			g.typed = g.typed[:0]
+			if !ok {
+				fmt.Fprint(g.screen, "\r\nWrong password.\r\nPassword: ")
+				continue
+			}
+			g.locked = false
+			g.last.Store(time.Now().UnixNano())
+			g.warned = false
+			_, _ = fmt.Fprint(g.screen, "\x1b[2J\x1b[H")
+			_, _ = g.screen.Write(g.recent[max(0, len(g.recent)-idleReplaySize):])
+			return
+		case 0x7f, 0x08: // backspace
+			if len(g.typed) > 0 {
+				g.typed = g.typed[:len(g.typed)-1]
+			}
+		case 0x03, 0x15: // ^C, ^U
+			g.typed = g.typed[:0]
+		default:
+			g.typed = append(g.typed, b)
+		}
+	}
+}
@@ -0,0 +1,122 @@
+package sshtools
+
+import (
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+	"sort"
+)
+
+// ParseConfigFile reads the config file filename in its format, merges the
+// servers of the files it includes and validates the result like
+// ParseConfig.
+func ParseConfigFile(filename string) (config *Config, problems []Problem, err error) {
+	data, err := ReadConfigFile(filename)
+	if err != nil {
+		return
+	}
+	data, problems = mergeIncludes(filename, data)
+	config, more, err := ParseConfig(data)
+	return config, append(problems, more...), err
+}
+
+// includeFiles returns the files matched by the include patterns of the
+// config file filename. Relative patterns start from its directory.
+func includeFiles(filename string, patterns []string) (files []string, problems []Problem) {
+	seen := map[string]bool{filepath.Clean(filename): true}
+	for _, pattern := range patterns {
+		expanded, err := ExpandPath(pattern)
+		if err != nil {
+			problems = append(problems, Problem{Index: -1, Message: fmt.Sprintf(`"include" %q: %v`, pattern, err)})
+			continue
+		}
+		if !filepath.IsAbs(expanded) {
+			expanded = filepath.Join(filepath.Dir(filename), expanded)
+		}
+		matches, err := filepath.Glob(expanded)
+		if err != nil {
+			problems = append(problems, Problem{Index: -1, Message: fmt.Sprintf(`"include" %q: %v`, pattern, err)})
+			continue
+		}
+		// 不含通配符的路径必须存在，通配符没有匹配时不算错误
+		if len(matches) == 0 && !hasGlobMeta(pattern) {
+			problems = append(problems, Problem{Index: -1, Message: fmt.Sprintf(`"include" %q: no such file`, pattern)})
+		}
+		sort.Strings(matches)
+		for _, match := range matches {
+			if !seen[filepath.Clean(match)] {
+				seen[filepath.Clean(match)] = true
+				files = append(files, match)
+			}
+		}
+	}
+	return
+}
+
+// hasGlobMeta reports whether pattern has characters special to
+// filepath.Match.
+func hasGlobMeta(pattern string) bool {
+	for _, c := range pattern {
+		switch c {
+		case '*', '?', '[':
+			return true
+		}
+	}
+	return false
+}
+
+// mergeIncludes appends the servers of the files included by the config
+// in data, read from filename, to its servers list. Only the servers of an
+// included file are used; its other settings are reported and ignored.
+// data is returned as it is when there is nothing to include or it does
+// not parse, which ParseConfig reports.
+func mergeIncludes(filename string, data []byte) (merged []byte, problems []Problem) {
+	var doc map[string]json.RawMessage
+	var patterns []string
+	var servers []json.RawMessage
+	if json.Unmarshal(data, &doc) != nil || json.Unmarshal(doc["include"], &patterns) != nil || len(patterns) == 0 {
+		return data, nil
+	}
+	if raw, ok := doc["servers"]; ok && json.Unmarshal(raw, &servers) != nil {
+		return data, nil
+	}
+
+	files, problems := includeFiles(filename, patterns)
+	for _, file := range files {
+		included, err := ReadConfigFile(file)
+		var part map[string]json.RawMessage
+		if err == nil {
+			if err = json.Unmarshal(included, &part); err != nil {
+				err = jsonError(included, err)
+			}
+		}
+		var more []json.RawMessage
+		if raw, ok := part["servers"]; ok && err == nil {
+			err = json.Unmarshal(raw, &more)
+		}
+		if err != nil {
+			problems = append(problems, Problem{Index: -1, Message: fmt.Sprintf(`"include": %s: %v`, file, err)})
+			continue
+		}
+		keys := make([]string, 0, len(part))
+		for key := range part {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			if key != "servers" {
+				problems = append(problems, Problem{Index: -1, Message: fmt.Sprintf(`"include": %s: %q is ignored, only "servers" are merged`, file, key), Warning: true})
+			}
+		}
+		servers = append(servers, more...)
+	}
+
+	var err error
+	if doc["servers"], err = json.Marshal(servers); err != nil {
+		return data, problems
+	}
+	if merged, err = json.Marshal(doc); err != nil {
+		return data, problems
+	}
+	return
+}
@@ -0,0 +1,43 @@
+package sshtools
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"sync"
+)
+
+// SleepInhibitor keeps the local machine awake until Release is called. It
+// is tied to the life of this process, so it never outlives it even when
+// the process exits without releasing it.
+type SleepInhibitor struct {
+	release func()
+	once    sync.Once
+}
+
+var unsupportedOnce sync.Once
+
+// InhibitSleep asks the OS not to sleep while why is in progress. On
+// platforms where that is not implemented, or when it fails, a warning is
+// written to warn (once per process) and a no-op inhibitor is returned.
+func InhibitSleep(why string, warn io.Writer) *SleepInhibitor {
+	if warn == nil {
+		warn = os.Stderr
+	}
+	release, err := inhibitSleep(why)
+	if err != nil {
+		unsupportedOnce.Do(func() {
+			fmt.Fprintf(warn, "warning: cannot prevent sleep: %v\n", err)
+		})
+		return &SleepInhibitor{}
+	}
+	return &SleepInhibitor{release: release}
+}
+
+// Release lets the machine sleep again. It is safe to call more than once.
+func (s *SleepInhibitor) Release() {
+	if s == nil || s.release == nil {
+		return
+	}
+	s.once.Do(s.release)
+}
@@ -0,0 +1,20 @@
+package sshtools
+
+import (
+	"os"
+	"os/exec"
+	"strconv"
+)
+
+// inhibitSleep runs caffeinate, which holds an IOKit power assertion and
+// exits by itself once our pid is gone.
+func inhibitSleep(why string) (release func(), err error) {
+	cmd := exec.Command("caffeinate", "-i", "-w", strconv.Itoa(os.Getpid()))
+	if err = cmd.Start(); err != nil {
+		return
+	}
+	return func() {
+		_ = cmd.Process.Kill()
+		_ = cmd.Wait()
+	}, nil
+}
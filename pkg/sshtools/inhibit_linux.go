@@ -0,0 +1,44 @@
+package sshtools
+
+import (
+	"bytes"
+	"fmt"
+	"os/exec"
+	"strings"
+	"time"
+)
+
+// inhibitStartup is how long systemd-inhibit gets to fail (e.g. no session
+// bus) before the lock is assumed to be held.
+const inhibitStartup = 200 * time.Millisecond
+
+// inhibitSleep holds a systemd-inhibit lock around a cat reading from a
+// pipe we own: when this process exits for any reason the pipe closes, cat
+// exits and the lock is dropped.
+func inhibitSleep(why string) (release func(), err error) {
+	cmd := exec.Command("systemd-inhibit", "--what=sleep:idle", "--who=sshtools", "--why="+why, "--mode=block", "cat")
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return
+	}
+	if err = cmd.Start(); err != nil {
+		return
+	}
+
+	exited := make(chan error, 1)
+	go func() {
+		exited <- cmd.Wait()
+	}()
+	select {
+	case errs := <-exited:
+		err = fmt.Errorf("systemd-inhibit: %v %s", errs, strings.TrimSpace(stderr.String()))
+		return
+	case <-time.After(inhibitStartup):
+	}
+	return func() {
+		_ = stdin.Close()
+		<-exited
+	}, nil
+}
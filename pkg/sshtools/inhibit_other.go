@@ -0,0 +1,12 @@
+//go:build !linux && !darwin && !windows
+
+package sshtools
+
+import (
+	"errors"
+	"runtime"
+)
+
+func inhibitSleep(why string) (release func(), err error) {
+	return nil, errors.New("not implemented on " + runtime.GOOS)
+}
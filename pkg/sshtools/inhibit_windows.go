@@ -0,0 +1,45 @@
+package sshtools
+
+import (
+	"fmt"
+	"runtime"
+
+	"golang.org/x/sys/windows"
+)
+
+const (
+	esContinuous     = 0x80000000
+	esSystemRequired = 0x00000001
+)
+
+var setThreadExecutionState = windows.NewLazySystemDLL("kernel32.dll").NewProc("SetThreadExecutionState")
+
+// inhibitSleep sets the execution state on a dedicated OS thread. The
+// state belongs to that thread, so Windows drops it when the process exits.
+func inhibitSleep(why string) (release func(), err error) {
+	if err = setThreadExecutionState.Find(); err != nil {
+		return
+	}
+	started := make(chan error)
+	done := make(chan struct{})
+	stopped := make(chan struct{})
+	go func() {
+		runtime.LockOSThread()
+		defer runtime.UnlockOSThread()
+		defer close(stopped)
+		if r, _, errs := setThreadExecutionState.Call(esContinuous | esSystemRequired); r == 0 {
+			started <- fmt.Errorf("SetThreadExecutionState: %v", errs)
+			return
+		}
+		started <- nil
+		<-done
+		_, _, _ = setThreadExecutionState.Call(esContinuous)
+	}()
+	if err = <-started; err != nil {
+		return
+	}
+	return func() {
+		close(done)
+		<-stopped
+	}, nil
+}
@@ -0,0 +1,80 @@
+package sshtools
+
+import (
+	"context"
+	"errors"
+	"io"
+	"os"
+)
+
+// errInputDone is returned by reads of a session's input after the
+// session ended.
+var errInputDone = errors.New("session ended")
+
+// newSessionInput returns a reader of r for one session that stops when
+// ctx is done. A session that ended must not keep a read of stdin
+// pending, which would swallow the first keystrokes meant for whatever
+// reads next: files are only read once select reports input, where the
+// platform allows; other readers are read by a goroutine whose last read
+// may outlive the session.
+func newSessionInput(ctx context.Context, r io.Reader) io.ReadCloser {
+	if f, ok := r.(*os.File); ok {
+		if in := newPolledInput(ctx, f); in != nil {
+			return in
+		}
+	}
+	in := &chanInput{ctx: ctx, chunks: make(chan []byte)}
+	go in.pump(r)
+	return in
+}
+
+// chanInput hands the reads of a goroutine over to Read until ctx is done.
+type chanInput struct {
+	ctx     context.Context
+	chunks  chan []byte
+	err     error // set before chunks is closed
+	pending []byte
+}
+
+func (in *chanInput) pump(r io.Reader) {
+	defer close(in.chunks)
+	for {
+		buf := make([]byte, 32<<10)
+		n, err := r.Read(buf)
+		if n > 0 {
+			select {
+			case in.chunks <- buf[:n]:
+			case <-in.ctx.Done():
+				return
+			}
+		}
+		if err != nil {
+			in.err = err
+			return
+		}
+	}
+}
+
+func (in *chanInput) Read(p []byte) (int, error) {
+	if len(in.pending) == 0 {
+		select {
+		case chunk, ok := <-in.chunks:
+			if !ok {
+				if in.err == nil {
+					return 0, errInputDone
+				}
+				return 0, in.err
+			}
+			in.pending = chunk
+		case <-in.ctx.Done():
+			return 0, errInputDone
+		}
+	}
+	n := copy(p, in.pending)
+	in.pending = in.pending[n:]
+	return n, nil
+}
+
+func (in *chanInput) Close() error {
+	return nil
+}
@@ -0,0 +1,88 @@
+//go:build !windows
+
+package sshtools
+
+import (
+	"context"
+	"errors"
+	"io"
+	"os"
+	"sync"
+
+	"golang.org/x/sys/unix"
+)
+
+// polledInput reads a file only after select reports input on it. A pipe
+// wakes select up when the context is done.
+type polledInput struct {
+	ctx  context.Context
+	f    *os.File
+	fd   int
+	wake [2]int
+	stop func() bool
+
+	mu     sync.Mutex
+	closed bool
+}
+
+// newPolledInput returns nil when f cannot be selected on.
+func newPolledInput(ctx context.Context, f *os.File) io.ReadCloser {
+	fd := int(f.Fd())
+	if fd >= unix.FD_SETSIZE {
+		return nil
+	}
+	in := &polledInput{ctx: ctx, f: f, fd: fd}
+	if err := unix.Pipe(in.wake[:]); err != nil || in.wake[0] >= unix.FD_SETSIZE {
+		if err == nil {
+			_ = unix.Close(in.wake[0])
+			_ = unix.Close(in.wake[1])
+		}
+		return nil
+	}
+	in.stop = context.AfterFunc(ctx, func() {
+		in.mu.Lock()
+		defer in.mu.Unlock()
+		if !in.closed {
+			_, _ = unix.Write(in.wake[1], []byte{0})
+		}
+	})
+	return in
+}
+
+func (in *polledInput) Read(p []byte) (int, error) {
+	for {
+		if in.ctx.Err() != nil {
+			return 0, errInputDone
+		}
+		var set unix.FdSet
+		set.Set(in.fd)
+		set.Set(in.wake[0])
+		_, err := unix.Select(max(in.fd, in.wake[0])+1, &set, nil, nil, nil)
+		if errors.Is(err, unix.EINTR) {
+			continue
+		}
+		if err != nil {
+			return 0, err
+		}
+		if set.IsSet(in.wake[0]) {
+			return 0, errInputDone
+		}
+		if set.IsSet(in.fd) {
+			return in.f.Read(p)
+		}
+	}
+}
+
+// Close releases the wake-up pipe; it must not be called while a Read is
+// running.
+func (in *polledInput) Close() error {
+	in.stop()
+	in.mu.Lock()
+	defer in.mu.Unlock()
+	if in.closed {
+		return nil
+	}
+	in.closed = true
+	_ = unix.Close(in.wake[0])
+	return unix.Close(in.wake[1])
+}
@@ -0,0 +1,13 @@
+package sshtools
+
+import (
+	"context"
+	"io"
+	"os"
+)
+
+// newPolledInput returns nil: console input cannot be waited on together
+// with a cancellation, so it is read by a goroutine.
+func newPolledInput(ctx context.Context, f *os.File) io.ReadCloser {
+	return nil
+}
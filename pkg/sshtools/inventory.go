@@ -0,0 +1,82 @@
+package sshtools
+
+import (
+	"slices"
+	"sort"
+	"strings"
+)
+
+// Auth methods reported in the inventory, and the steps of auth_methods.
+const (
+	AuthPublicKey           = "publickey"
+	AuthPassword            = "password"
+	AuthAgent               = "agent"
+	AuthKeyboardInteractive = "keyboard-interactive"
+	// AuthAuto is ssh-agent, the default keys, then a password prompt.
+	AuthAuto = "auto"
+)
+
+// InventoryEntry describes a server for other tools. It never carries
+// secrets.
+type InventoryEntry struct {
+	Alias      string   `json:"alias"`
+	Addresses  []string `json:"addresses"`
+	Port       int      `json:"port"`
+	User       string   `json:"user"`
+	Tags       []string `json:"tags"`
+	Auth       string   `json:"auth"`
+	Deprecated bool     `json:"deprecated,omitempty"`
+	RedirectTo string   `json:"redirect_to,omitempty"`
+	Default    bool     `json:"default,omitempty"`
+	Favorite   bool     `json:"favorite,omitempty"`
+}
+
+// AuthMethod names how sshtools authenticates to s.
+func (s *Server) AuthMethod() string {
+	switch {
+	case len(s.AuthMethods) > 0:
+		return strings.Join(s.AuthMethods, ",")
+	case s.UseAgent:
+		return AuthAgent
+	case s.UseKey:
+		return AuthPublicKey
+	case s.Password != "":
+		return AuthPassword
+	}
+	return AuthAuto
+}
+
+// Inventory returns the servers sorted by alias.
+func (c *Config) Inventory() (entries []InventoryEntry) {
+	entries = make([]InventoryEntry, 0, len(c.Servers))
+	for i := range c.Servers {
+		s := &c.Servers[i]
+		entries = append(entries, InventoryEntry{
+			Alias:      s.Alias,
+			Addresses:  s.AddressList(),
+			Port:       s.Port,
+			User:       s.User,
+			Tags:       append([]string{}, s.Tags...),
+			Auth:       s.AuthMethod(),
+			Deprecated: s.Deprecated,
+			RedirectTo: s.RedirectTo,
+			Default:    s.Default,
+			Favorite:   s.Favorite,
+		})
+	}
+	sort.SliceStable(entries, func(i, j int) bool { return foldAlias(entries[i].Alias) < foldAlias(entries[j].Alias) })
+	return
+}
+
+// TagNames returns every tag in use, sorted.
+func (c *Config) TagNames() (tags []string) {
+	for _, s := range c.Servers {
+		for _, tag := range s.Tags {
+			if !slices.Contains(tags, tag) {
+				tags = append(tags, tag)
+			}
+		}
+	}
+	sort.Strings(tags)
+	return
+}
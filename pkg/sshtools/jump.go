@@ -0,0 +1,124 @@
+package sshtools
+
+import (
+	"fmt"
+	"net"
+	"strings"
+	"time"
+)
+
+// jumpAliases splits a proxy_jump value into aliases.
+func jumpAliases(proxyJump string) (aliases []string) {
+	for _, alias := range strings.Split(proxyJump, ",") {
+		if alias = strings.TrimSpace(alias); alias != "" {
+			aliases = append(aliases, alias)
+		}
+	}
+	return
+}
+
+// jumpChain resolves the proxy_jump of s into every server a connection
+// passes through, in order. The first jump host is reached by its own
+// route; the others through the one before them. visiting holds the
+// aliases being resolved, to catch loops.
+func (c *Config) jumpChain(s *Server, visiting map[string]bool) (chain []*Server, err error) {
+	visiting[foldAlias(s.Alias)] = true
+	defer delete(visiting, foldAlias(s.Alias))
+	for i, alias := range jumpAliases(s.ProxyJump) {
+		hop := c.ServerByAlias(alias)
+		if hop == nil {
+			return nil, fmt.Errorf(`"proxy_jump" names unknown alias %q`, alias)
+		}
+		if visiting[foldAlias(hop.Alias)] {
+			return nil, fmt.Errorf(`"proxy_jump" loops back to %q`, hop.Alias)
+		}
+		if i == 0 {
+			before, errs := c.jumpChain(hop, visiting)
+			if errs != nil {
+				return nil, fmt.Errorf("via %s: %v", hop.Alias, errs)
+			}
+			chain = append(chain, before...)
+		}
+		chain = append(chain, hop)
+	}
+	return
+}
+
+// hops returns the servers a connection to s passes through, ending with
+// s. Each is a copy whose JumpHosts lead up to it, so it can be dialed on
+// its own.
+func (s *Server) hops() []*Server {
+	hops := make([]*Server, 0, len(s.JumpHosts)+1)
+	for i, hop := range s.JumpHosts {
+		hop := *hop
+		hop.JumpHosts = s.JumpHosts[:i]
+		hops = append(hops, &hop)
+	}
+	return append(hops, s)
+}
+
+// dialJumps connects to the last jump host of server, through the ones
+// before it.
+func (d *Dialer) dialJumps(server *Server) (via *Client, err error) {
+	hops := server.hops()
+	jump := hops[len(hops)-2]
+	if via, err = d.Dial(jump); err != nil {
+		err = fmt.Errorf("jump host %s: %v", jump.Alias, err)
+	}
+	return
+}
+
+// dialVia opens a connection to address through the jump host via. The
+// jump host resolves the name and connects.
+func dialVia(via *Client, address string, timeout time.Duration) (net.Conn, error) {
+	type result struct {
+		conn net.Conn
+		err  error
+	}
+	done := make(chan result, 1)
+	go func() {
+		conn, err := via.Dial("tcp", address)
+		done <- result{conn, err}
+	}()
+	select {
+	case r := <-done:
+		if r.err != nil {
+			return nil, fmt.Errorf("%s could not connect: %v", via.Server.Alias, r.err)
+		}
+		return r.conn, nil
+	case <-time.After(timeout):
+		go func() {
+			if r := <-done; r.conn != nil {
+				_ = r.conn.Close()
+			}
+		}()
+		return nil, fmt.Errorf("connect through %s timed out after %s", via.Server.Alias, timeout)
+	}
+}
+
+// jumpConn is a connection through a jump host that only that connection
+// uses; closing it disconnects from the jump host too.
+type jumpConn struct {
+	net.Conn
+	via *Client
+}
+
+func (c *jumpConn) Close() error {
+	err := c.Conn.Close()
+	_ = c.via.Close()
+	return err
+}
+
+// Close closes the connection, then those to the jump hosts it went
+// through.
+func (c *Client) Close() error {
+	err := c.Client.Close()
+	if c.agentConn != nil {
+		_ = c.agentConn.Close()
+	}
+	if c.via != nil {
+		_ = c.via.Close()
+	}
+	c.runLocalPost()
+	return err
+}
@@ -0,0 +1,84 @@
+package sshtools
+
+import (
+	"fmt"
+	"sync/atomic"
+	"time"
+)
+
+// defaultKeepaliveCountMax is how many keepalives may go unanswered before
+// the connection is given up when keepalive_count_max is not set.
+const defaultKeepaliveCountMax = 3
+
+// Keepalive returns how often keepalive requests are sent to s, or 0 when
+// they are disabled, and how many may go unanswered.
+func (s *Server) Keepalive() (interval time.Duration, countMax int) {
+	if s.KeepaliveInterval != "" {
+		interval, _ = time.ParseDuration(s.KeepaliveInterval)
+	}
+	countMax = s.KeepaliveCountMax
+	if countMax <= 0 {
+		countMax = defaultKeepaliveCountMax
+	}
+	return
+}
+
+// checkKeepalive validates keepalive_interval and keepalive_count_max.
+func checkKeepalive(interval string, countMax int) (msgs []string) {
+	if interval != "" {
+		if d, err := time.ParseDuration(interval); err != nil || d < 0 {
+			msgs = append(msgs, fmt.Sprintf(`"keepalive_interval" %q is not a duration such as "30s" ("0" disables keepalives)`, interval))
+		} else if d > 0 && d < time.Second {
+			msgs = append(msgs, fmt.Sprintf(`"keepalive_interval" %q is shorter than one second`, interval))
+		}
+	}
+	if countMax < 0 {
+		msgs = append(msgs, fmt.Sprintf(`"keepalive_count_max" %d cannot be negative`, countMax))
+	}
+	return
+}
+
+// Lost returns why the connection was given up after unanswered
+// keepalives, or nil.
+func (c *Client) Lost() error {
+	if err, ok := c.lost.Load().(error); ok {
+		return err
+	}
+	return nil
+}
+
+// keepalive sends a keepalive@openssh.com request every interval until the
+// connection ends, and closes the connection once countMax requests in a
+// row went unanswered.
+func (c *Client) keepalive(interval time.Duration, countMax int) {
+	done := make(chan struct{})
+	go func() {
+		_ = c.Wait()
+		close(done)
+	}()
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	var missed atomic.Int32
+	for {
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
+		}
+		if int(missed.Load()) >= countMax {
+			err := fmt.Errorf("connection to %s lost: no reply to %d keepalives in %s", c.Server.Alias, countMax,
+				time.Duration(countMax)*interval)
+			c.lost.Store(err)
+			c.dialer.Logf(1, "%v", err)
+			_ = c.Close()
+			return
+		}
+		missed.Add(1)
+		go func() {
+			// 服务器拒绝请求也算作回应
+			if _, _, err := c.SendRequest("keepalive@openssh.com", true, nil); err == nil {
+				missed.Store(0)
+			}
+		}()
+	}
+}
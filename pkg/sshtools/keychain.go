@@ -0,0 +1,112 @@
+package sshtools
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/zalando/go-keyring"
+)
+
+// PasswordSourceKeychain is the password_source that reads the password,
+// or the passphrase of the private key, from the OS keychain.
+const PasswordSourceKeychain = "keychain"
+
+// keychainService names the entries of sshtools in the keychain.
+const keychainService = "sshtools"
+
+// ErrNoKeychainSecret is returned when the keychain has no entry for a
+// server.
+var ErrNoKeychainSecret = errors.New("not in the keychain")
+
+// KeychainEntry is what a keychain entry of a server holds.
+type KeychainEntry int
+
+const (
+	// KeychainPassword is the login password of the server.
+	KeychainPassword KeychainEntry = iota
+	// KeychainPassphrase is the passphrase of its private key.
+	KeychainPassphrase
+	// KeychainSudo is the sudo password used by -b.
+	KeychainSudo
+)
+
+func (e KeychainEntry) String() string {
+	switch e {
+	case KeychainPassphrase:
+		return "passphrase"
+	case KeychainSudo:
+		return "sudo password"
+	}
+	return "password"
+}
+
+// keychainAccount returns the keychain account of an entry of alias.
+// Aliases are folded as when matching them, so "Web1" and "web1" share
+// their entries.
+func keychainAccount(alias string, entry KeychainEntry) string {
+	switch entry {
+	case KeychainPassphrase:
+		return foldAlias(alias) + " passphrase"
+	case KeychainSudo:
+		return foldAlias(alias) + " sudo"
+	}
+	return foldAlias(alias)
+}
+
+// KeychainSecret returns an entry of alias from the OS keychain: the macOS
+// Keychain, the Secret Service on Linux (GNOME Keyring, KWallet) or the
+// Windows Credential Manager.
+func KeychainSecret(alias string, entry KeychainEntry) (secret string, err error) {
+	secret, err = keyring.Get(keychainService, keychainAccount(alias, entry))
+	if errors.Is(err, keyring.ErrNotFound) {
+		return "", ErrNoKeychainSecret
+	}
+	if err != nil {
+		err = fmt.Errorf("failed to read the keychain: %v", err)
+	}
+	return
+}
+
+// SetKeychainSecret stores an entry of alias in the OS keychain, replacing
+// an earlier one.
+func SetKeychainSecret(alias string, entry KeychainEntry, secret string) (err error) {
+	if err = keyring.Set(keychainService, keychainAccount(alias, entry), secret); err != nil {
+		err = fmt.Errorf("failed to write the keychain: %v", err)
+	}
+	return
+}
+
+// DeleteKeychainSecret removes an entry of alias from the OS keychain.
+func DeleteKeychainSecret(alias string, entry KeychainEntry) (err error) {
+	err = keyring.Delete(keychainService, keychainAccount(alias, entry))
+	if errors.Is(err, keyring.ErrNotFound) {
+		return ErrNoKeychainSecret
+	}
+	if err != nil {
+		err = fmt.Errorf("failed to write the keychain: %v", err)
+	}
+	return
+}
+
+// keychainPassword fills in the password of a server whose password_source
+// is the keychain, once per process. Without an entry the password is
+// asked for on a terminal as usual, or the dial fails.
+func (d *Dialer) keychainPassword(server *Server) (err error) {
+	// 使用密钥时钥匙串中保存的是私钥口令，由 loadPrivateKey 读取
+	if server.PasswordSource != PasswordSourceKeychain || server.UseKey || server.Password != "" {
+		return
+	}
+	password, err := KeychainSecret(server.Alias, KeychainPassword)
+	if err == nil {
+		server.Password = password
+		return
+	}
+	if d.PromptPassword != nil {
+		d.Logf(1, "password of %s: %v", server.Alias, err)
+		return nil
+	}
+	if errors.Is(err, ErrNoKeychainSecret) {
+		return fmt.Errorf("password of %s: %v, store it with sshtools secret set %s", server.Alias, err, server.Alias)
+	}
+	return fmt.Errorf("password of %s: %v", server.Alias, err)
+}
@@ -0,0 +1,134 @@
+package sshtools
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/pem"
+	"fmt"
+	"os"
+	"os/user"
+	"path/filepath"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// DefaultKeyComment is the comment ssh-keygen gives new keys: user@host.
+func DefaultKeyComment() string {
+	name := "sshtools"
+	if local, err := user.Current(); err == nil {
+		name = local.Username
+	}
+	host, err := os.Hostname()
+	if err != nil {
+		return name
+	}
+	return name + "@" + host
+}
+
+// Key types GenerateKey creates.
+const (
+	KeyTypeEd25519 = "ed25519"
+	KeyTypeECDSA   = "ecdsa"
+	KeyTypeRSA     = "rsa"
+)
+
+// KeyOptions describe a key to generate.
+type KeyOptions struct {
+	// Type is ed25519 (the default), ecdsa or rsa.
+	Type string
+	// Bits is the curve size for ecdsa (256, 384 or 521, default 256) or
+	// the modulus size for rsa (at least 2048, default 3072).
+	Bits    int
+	Comment string
+	// Passphrase encrypts the private key when not empty.
+	Passphrase []byte
+}
+
+// newPrivateKey generates the private key described by opts.
+func newPrivateKey(opts KeyOptions) (priv crypto.Signer, err error) {
+	switch opts.Type {
+	case "", KeyTypeEd25519:
+		if opts.Bits != 0 {
+			return nil, fmt.Errorf("ed25519 keys have a fixed size, drop the bits")
+		}
+		_, priv, err = ed25519.GenerateKey(rand.Reader)
+	case KeyTypeECDSA:
+		var curve elliptic.Curve
+		switch opts.Bits {
+		case 0, 256:
+			curve = elliptic.P256()
+		case 384:
+			curve = elliptic.P384()
+		case 521:
+			curve = elliptic.P521()
+		default:
+			return nil, fmt.Errorf("ecdsa keys are 256, 384 or 521 bits, not %d", opts.Bits)
+		}
+		priv, err = ecdsa.GenerateKey(curve, rand.Reader)
+	case KeyTypeRSA:
+		bits := opts.Bits
+		if bits == 0 {
+			bits = 3072
+		}
+		if bits < 2048 || bits > 16384 {
+			return nil, fmt.Errorf("rsa keys must be 2048 to 16384 bits, not %d", bits)
+		}
+		priv, err = rsa.GenerateKey(rand.Reader, bits)
+	default:
+		return nil, fmt.Errorf("unknown key type %q, want ed25519, ecdsa or rsa", opts.Type)
+	}
+	return
+}
+
+// GenerateKey writes a new key pair to path and path.pub in the OpenSSH
+// format, the private key readable only by the owner. An existing key at
+// path is never overwritten.
+func GenerateKey(path string, opts KeyOptions) (key *PublicKey, err error) {
+	if path, err = ExpandPath(path); err != nil {
+		return
+	}
+	priv, err := newPrivateKey(opts)
+	if err != nil {
+		return
+	}
+	if err = os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		return
+	}
+	var block *pem.Block
+	if len(opts.Passphrase) > 0 {
+		block, err = ssh.MarshalPrivateKeyWithPassphrase(priv, opts.Comment, opts.Passphrase)
+	} else {
+		block, err = ssh.MarshalPrivateKey(priv, opts.Comment)
+	}
+	if err != nil {
+		return
+	}
+	sshPub, err := ssh.NewPublicKey(priv.Public())
+	if err != nil {
+		return
+	}
+	key = &PublicKey{Key: sshPub, Comment: opts.Comment, Path: path + ".pub", PrivateKey: path}
+
+	// O_EXCL 保证不会覆盖已有的密钥
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o600)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create %s: %v", path, err)
+	}
+	if err = pem.Encode(file, block); err == nil {
+		err = file.Close()
+	} else {
+		_ = file.Close()
+	}
+	if err == nil {
+		err = os.WriteFile(key.Path, []byte(key.AuthorizedLine()+"\n"), 0o644)
+	}
+	if err != nil {
+		_ = os.Remove(path)
+		return nil, fmt.Errorf("failed to write the key %s: %v", path, err)
+	}
+	return
+}
@@ -0,0 +1,290 @@
+package sshtools
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+// Host key states reported by CheckKnownHost.
+const (
+	HostKnown    = "known"
+	HostUnknown  = "unknown"
+	HostConflict = "conflict"
+)
+
+// Values of strict_host_key_checking, as in OpenSSH.
+const (
+	// HostKeyAsk confirms unknown keys on a terminal and refuses them
+	// otherwise. It is the default.
+	HostKeyAsk       = "ask"
+	HostKeyYes       = "yes"
+	HostKeyAcceptNew = "accept-new"
+	HostKeyNo        = "no"
+)
+
+// plainKey returns the key a host certificate certifies. Without a trusted
+// CA the certificate stands for that key in known_hosts.
+func plainKey(key ssh.PublicKey) ssh.PublicKey {
+	if cert, ok := key.(*ssh.Certificate); ok {
+		return cert.Key
+	}
+	return key
+}
+
+// validHostKeyChecking reports whether mode is a strict_host_key_checking
+// value; empty means the default.
+func validHostKeyChecking(mode string) bool {
+	switch mode {
+	case "", HostKeyAsk, HostKeyYes, HostKeyAcceptNew, HostKeyNo:
+		return true
+	}
+	return false
+}
+
+// knownHostsMu serializes checking and recording host keys, so parallel
+// dials ask one question at a time and don't interleave file writes.
+var knownHostsMu sync.Mutex
+
+// HostKeyChangedError is returned when a server presents a key other than
+// the one recorded for it in known_hosts.
+type HostKeyChangedError struct {
+	Alias string
+	Host  string
+	Key   ssh.PublicKey
+	Known []knownhosts.KnownKey
+}
+
+func (e *HostKeyChangedError) Error() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "WARNING: the host key of %s (%s) has changed, refusing to connect.\n", e.Alias, e.Host)
+	b.WriteString("Someone could be intercepting the connection, or the server's key was replaced.\n")
+	fmt.Fprintf(&b, "  offered:   %s %s\n", e.Key.Type(), ssh.FingerprintSHA256(e.Key))
+	for _, k := range e.Known {
+		fmt.Fprintf(&b, "  on record: %s %s (%s:%d)\n", k.Key.Type(), ssh.FingerprintSHA256(k.Key), k.Filename, k.Line)
+	}
+	b.WriteString("If the change is expected, connect with -accept-changed-host-key to replace the old key.")
+	return b.String()
+}
+
+// HostKeyUnknownError is returned for a server not in known_hosts when
+// its key cannot be confirmed.
+type HostKeyUnknownError struct {
+	Alias string
+	Host  string
+	Key   ssh.PublicKey
+}
+
+func (e *HostKeyUnknownError) Error() string {
+	return fmt.Sprintf("host key of %s (%s) is not in known_hosts: %s %s; connect from a terminal to confirm it,"+
+		" or add it with sshtools known-hosts prefetch", e.Alias, e.Host, e.Key.Type(), ssh.FingerprintSHA256(e.Key))
+}
+
+// errHostKeyFetched aborts a handshake once the host key has been seen.
+var errHostKeyFetched = errors.New("host key fetched")
+
+// KnownHostsPath returns the user's OpenSSH known_hosts file.
+func KnownHostsPath() (path string, err error) {
+	homeDir, err := getHomeDir()
+	if err != nil {
+		err = fmt.Errorf("failed to get home directory: %v", err)
+		return
+	}
+	return filepath.Join(homeDir, ".ssh", "known_hosts"), nil
+}
+
+// Hops returns the servers a connection to server passes through, ending
+// with server itself. Each needs its host key verified.
+func (c *Config) Hops(server *Server) []*Server {
+	return server.hops()
+}
+
+// FetchHostKey runs the key exchange with server and returns its host key
+// without authenticating.
+func (d *Dialer) FetchHostKey(server *Server) (key ssh.PublicKey, err error) {
+	key, _, err = d.fetchHostKey(server, Algorithms{})
+	return
+}
+
+// fetchHostKey is FetchHostKey offering only the given algorithms (empty
+// lists for the defaults). handshake is set when the server answered but the
+// key exchange failed, e.g. because it has no key of those types.
+func (d *Dialer) fetchHostKey(server *Server, algorithms Algorithms) (key ssh.PublicKey, handshake bool, err error) {
+	conn, err := d.dialTCP(server)
+	if err != nil {
+		err = fmt.Errorf("%s: %w", server.Addr(), classifyDialError(err))
+		return
+	}
+	defer func() { _ = conn.Close() }()
+	timer := time.AfterFunc(d.timeout(server), func() { _ = conn.Close() })
+	defer timer.Stop()
+
+	sshConfig := &ssh.ClientConfig{
+		User: server.User,
+		HostKeyCallback: func(hostname string, remote net.Addr, k ssh.PublicKey) error {
+			key = k
+			return errHostKeyFetched
+		},
+	}
+	algorithms.apply(sshConfig)
+	_, _, _, err = ssh.NewClientConn(conn, server.Addr(), sshConfig)
+	if key != nil {
+		return key, false, nil
+	}
+	err = fmt.Errorf("%s: handshake failed: %v", server.Addr(), err)
+	return nil, true, err
+}
+
+// CheckKnownHost looks server's key up in the known_hosts file at path. For
+// a conflict, known lists the keys on record.
+func CheckKnownHost(path string, server *Server, key ssh.PublicKey) (state string, known []knownhosts.KnownKey, err error) {
+	remote := &net.TCPAddr{IP: net.ParseIP(server.Address), Port: server.Port}
+	return checkKnownHost(path, server.Addr(), remote, key)
+}
+
+// checkKnownHost is CheckKnownHost for the host:port a connection used.
+func checkKnownHost(path, hostname string, remote net.Addr, key ssh.PublicKey) (state string, known []knownhosts.KnownKey, err error) {
+	key = plainKey(key)
+	if _, errs := os.Stat(path); errors.Is(errs, os.ErrNotExist) {
+		return HostUnknown, nil, nil
+	}
+	callback, err := knownhosts.New(path)
+	if err != nil {
+		return
+	}
+	// knownhosts wants a host:port remote address even though it matches
+	// on hostname; connections through a proxy command have none.
+	if _, _, errs := net.SplitHostPort(remote.String()); errs != nil {
+		remote = &net.TCPAddr{IP: net.IPv4zero}
+	}
+	err = callback(hostname, remote, key)
+	var keyErr *knownhosts.KeyError
+	switch {
+	case err == nil:
+		return HostKnown, nil, nil
+	case errors.As(err, &keyErr) && len(keyErr.Want) == 0:
+		return HostUnknown, nil, nil
+	case errors.As(err, &keyErr):
+		return HostConflict, keyErr.Want, nil
+	}
+	return
+}
+
+// AppendKnownHosts adds an entry for each server's key to the known_hosts
+// file at path, creating it if needed.
+func AppendKnownHosts(path string, servers []*Server, keys []ssh.PublicKey) (err error) {
+	var b strings.Builder
+	for i, server := range servers {
+		b.WriteString(knownhosts.Line([]string{knownhosts.Normalize(server.Addr())}, plainKey(keys[i])))
+		b.WriteByte('\n')
+	}
+	return appendKnownHosts(path, b.String())
+}
+
+// appendKnownHosts appends lines to the known_hosts file at path.
+func appendKnownHosts(path, lines string) (err error) {
+	if err = os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		return
+	}
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o600)
+	if err != nil {
+		return
+	}
+	defer func(file *os.File) {
+		if errs := file.Close(); errs != nil && err == nil {
+			err = errs
+		}
+	}(file)
+	_, err = file.WriteString(lines)
+	return
+}
+
+// replaceKnownHost records key for hostname in the known_hosts file at
+// path, removing the lines of the keys in old. Lines naming several hosts
+// go as a whole, as with ssh-keygen -R.
+func replaceKnownHost(path, hostname string, key ssh.PublicKey, old []knownhosts.KnownKey) (err error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return
+	}
+	drop := map[int]bool{}
+	for _, k := range old {
+		if k.Filename == path {
+			drop[k.Line] = true
+		}
+	}
+	var b strings.Builder
+	for i, line := range strings.SplitAfter(string(data), "\n") {
+		if !drop[i+1] {
+			b.WriteString(line)
+		}
+	}
+	if b.Len() > 0 && !strings.HasSuffix(b.String(), "\n") {
+		b.WriteByte('\n')
+	}
+	b.WriteString(knownhosts.Line([]string{knownhosts.Normalize(hostname)}, key) + "\n")
+	tmp := path + ".tmp"
+	if err = os.WriteFile(tmp, []byte(b.String()), 0o600); err == nil {
+		err = os.Rename(tmp, path)
+	}
+	return
+}
+
+// knownHostsPath returns the known_hosts file host keys are checked
+// against.
+func (d *Dialer) knownHostsPath() (string, error) {
+	if d.KnownHosts != "" {
+		return ExpandPath(d.KnownHosts)
+	}
+	return KnownHostsPath()
+}
+
+// verifyHostKey checks host keys against known_hosts according to the
+// server's strict_host_key_checking. New keys are recorded when accepted,
+// and with AcceptChangedHostKey a changed key replaces the old one.
+func (d *Dialer) verifyHostKey(server *Server) (callback ssh.HostKeyCallback, err error) {
+	mode := server.StrictHostKeyChecking
+	if mode == HostKeyNo {
+		return ssh.InsecureIgnoreHostKey(), nil
+	}
+	path, err := d.knownHostsPath()
+	if err != nil {
+		return
+	}
+	return func(hostname string, remote net.Addr, key ssh.PublicKey) error {
+		key = plainKey(key)
+		knownHostsMu.Lock()
+		defer knownHostsMu.Unlock()
+		state, known, err := checkKnownHost(path, hostname, remote, key)
+		if err != nil {
+			return fmt.Errorf("failed to check %s: %v", path, err)
+		}
+		line := knownhosts.Line([]string{knownhosts.Normalize(hostname)}, key) + "\n"
+		switch state {
+		case HostKnown:
+			return nil
+		case HostConflict:
+			if !d.AcceptChangedHostKey {
+				return &HostKeyChangedError{Alias: server.Alias, Host: hostname, Key: key, Known: known}
+			}
+			d.Logf(1, "replacing the changed host key of %s in %s", hostname, path)
+			return replaceKnownHost(path, hostname, key, known)
+		}
+		switch {
+		case mode == HostKeyAcceptNew:
+		case mode != HostKeyYes && d.ConfirmHostKey != nil && d.ConfirmHostKey(server, key):
+		default:
+			return &HostKeyUnknownError{Alias: server.Alias, Host: hostname, Key: key}
+		}
+		d.Logf(1, "adding the host key of %s to %s", hostname, path)
+		return appendKnownHosts(path, line)
+	}, nil
+}
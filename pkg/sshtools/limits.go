@@ -0,0 +1,46 @@
+package sshtools
+
+import (
+	"fmt"
+	"time"
+)
+
+// sessionLimitWarning is how long before session_max_duration is reached a
+// warning is shown.
+const sessionLimitWarning = time.Minute
+
+// checkLimits validates command_timeout and session_max_duration.
+func checkLimits(commandTimeout, sessionMax string) (msgs []string) {
+	if commandTimeout != "" {
+		if d, err := time.ParseDuration(commandTimeout); err != nil || d <= 0 {
+			msgs = append(msgs, fmt.Sprintf(`"command_timeout" %q is not a duration such as "5m"`, commandTimeout))
+		}
+	}
+	if sessionMax != "" {
+		if d, err := time.ParseDuration(sessionMax); err != nil || d <= 0 {
+			msgs = append(msgs, fmt.Sprintf(`"session_max_duration" %q is not a duration such as "8h"`, sessionMax))
+		}
+	}
+	return
+}
+
+// limitDuration closes the session once it has run for t.MaxDuration,
+// warning a minute before, until the returned func is called.
+func (t *Terminal) limitDuration() (stop func()) {
+	var warn *time.Timer
+	if t.MaxDuration > sessionLimitWarning {
+		warn = time.AfterFunc(t.MaxDuration-sessionLimitWarning, func() {
+			fmt.Fprintf(t.Stderr, "\r\n\x1b[7m[limit] the session will be closed in %s (maximum duration %s)\x1b[0m\r\n",
+				sessionLimitWarning, t.MaxDuration)
+		})
+	}
+	expire := time.AfterFunc(t.MaxDuration, func() {
+		t.hangUp(fmt.Sprintf("Connection closed: the session reached its maximum duration of %s.", t.MaxDuration))
+	})
+	return func() {
+		if warn != nil {
+			warn.Stop()
+		}
+		expire.Stop()
+	}
+}
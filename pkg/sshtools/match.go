@@ -0,0 +1,150 @@
+package sshtools
+
+import (
+	"net"
+	"path"
+	"regexp"
+	"slices"
+	"strings"
+)
+
+// isGlob reports whether pattern contains glob metacharacters.
+func isGlob(pattern string) bool {
+	return strings.ContainsAny(pattern, "*?[")
+}
+
+// MatchAlias returns the servers selected by pattern: an exact
+// case-insensitive alias, a glob such as "web-*", or otherwise every alias
+// starting with pattern.
+func (c *Config) MatchAlias(pattern string) (servers []*Server) {
+	if server := c.ServerByAlias(pattern); server != nil {
+		return []*Server{server}
+	}
+	pattern = foldAlias(pattern)
+	for i := range c.Servers {
+		alias := foldAlias(c.Servers[i].Alias)
+		var ok bool
+		if isGlob(pattern) {
+			ok, _ = path.Match(pattern, alias)
+		} else {
+			ok = strings.HasPrefix(alias, pattern)
+		}
+		if ok {
+			servers = append(servers, &c.Servers[i])
+		}
+	}
+	return
+}
+
+// MatchAddress returns the servers selected by pattern: an exact address, a
+// glob, a CIDR range such as "10.0.1.0/24", or a host name or IP that
+// resolves to the same addresses as the server's. Server host names are
+// resolved only when needed.
+func (c *Config) MatchAddress(pattern string) (servers []*Server) {
+	if server := c.ServerByAddress(pattern); server != nil {
+		return []*Server{server}
+	}
+
+	_, network, cidrErr := net.ParseCIDR(pattern)
+	var wanted []net.IP
+	if cidrErr != nil && !isGlob(pattern) {
+		wanted = resolve(pattern)
+	}
+	for i := range c.Servers {
+		for _, address := range c.Servers[i].AddressList() {
+			if matchesAddress(pattern, network, wanted, address) {
+				servers = append(servers, &c.Servers[i])
+				break
+			}
+		}
+	}
+	return
+}
+
+// IsPattern reports whether target is a glob, which selects several
+// servers rather than one.
+func IsPattern(target string) bool {
+	return isGlob(target)
+}
+
+// MatchPattern returns the servers whose alias matches the glob pattern,
+// or when none does, those with a matching address.
+func (c *Config) MatchPattern(pattern string) (servers []*Server) {
+	if servers = c.MatchAlias(pattern); len(servers) == 0 {
+		servers = c.MatchAddress(pattern)
+	}
+	return
+}
+
+// MatchRegexp returns the servers whose alias or one of whose addresses
+// matches re as a whole.
+func (c *Config) MatchRegexp(re *regexp.Regexp) (servers []*Server) {
+	whole := regexp.MustCompile(`^(?:` + re.String() + `)$`)
+	for i := range c.Servers {
+		s := &c.Servers[i]
+		if whole.MatchString(s.Alias) || slices.ContainsFunc(s.AddressList(), whole.MatchString) {
+			servers = append(servers, s)
+		}
+	}
+	return
+}
+
+// matchesAddress reports whether one server address is selected by pattern;
+// network and wanted are pattern parsed as a CIDR or resolved.
+func matchesAddress(pattern string, network *net.IPNet, wanted []net.IP, address string) bool {
+	switch {
+	case network != nil:
+		for _, ip := range resolve(address) {
+			if network.Contains(ip) {
+				return true
+			}
+		}
+		return false
+	case isGlob(pattern):
+		ok, _ := path.Match(strings.ToLower(pattern), strings.ToLower(address))
+		return ok
+	}
+	return overlaps(wanted, resolve(address))
+}
+
+// resolve returns the IPs of host, which may already be a literal address.
+// Lookup failures yield nil so unreachable names simply don't match.
+func resolve(host string) []net.IP {
+	if ip := net.ParseIP(host); ip != nil {
+		return []net.IP{ip}
+	}
+	addrs, err := net.LookupIP(host)
+	if err != nil {
+		return nil
+	}
+	return addrs
+}
+
+func overlaps(a, b []net.IP) bool {
+	for _, x := range a {
+		for _, y := range b {
+			if x.Equal(y) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// HasTag reports whether the server carries tag; every server has the tag
+// "all".
+func (s *Server) HasTag(tag string) bool {
+	return tag == "all" || slices.Contains(s.Tags, tag)
+}
+
+// ServersByTag returns the active servers carrying tag; the tag "all"
+// selects every active server. Deprecated entries are left out unless
+// includeDeprecated is set.
+func (c *Config) ServersByTag(tag string, includeDeprecated bool) (servers []*Server) {
+	for _, server := range c.ActiveServers(includeDeprecated) {
+		if server.HasTag(tag) {
+			servers = append(servers, server)
+		}
+	}
+	return
+}
@@ -0,0 +1,86 @@
+package sshtools
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+)
+
+// tunnelMetrics are the metrics WriteMetrics reports for every tunnel, in
+// order.
+var tunnelMetrics = []struct {
+	name, kind, help string
+	value            func(s *TunnelState) float64
+}{
+	{"sshtools_tunnel_up", "gauge", "Whether the tunnel's SSH connection is up.", func(s *TunnelState) float64 {
+		if s.State == TunnelConnected {
+			return 1
+		}
+		return 0
+	}},
+	{"sshtools_tunnel_start_time_seconds", "gauge", "When the tunnel was started, in seconds since the epoch.", func(s *TunnelState) float64 {
+		return float64(s.Started.Unix())
+	}},
+	{"sshtools_tunnel_connections_total", "counter", "Connections forwarded through the tunnel.", func(s *TunnelState) float64 {
+		return float64(s.Connections)
+	}},
+	{"sshtools_tunnel_received_bytes_total", "counter", "Bytes received through the tunnel and passed to its clients.", func(s *TunnelState) float64 {
+		return float64(s.BytesIn)
+	}},
+	{"sshtools_tunnel_sent_bytes_total", "counter", "Bytes sent through the tunnel by its clients.", func(s *TunnelState) float64 {
+		return float64(s.BytesOut)
+	}},
+	{"sshtools_tunnel_reconnects_total", "counter", "Times the tunnel's SSH connection was re-established.", func(s *TunnelState) float64 {
+		return float64(s.Reconnects)
+	}},
+	{"sshtools_tunnel_auth_failures_total", "counter", "Connection attempts the server rejected at authentication.", func(s *TunnelState) float64 {
+		return float64(s.AuthFailures)
+	}},
+}
+
+// WriteMetrics writes the counters of tunnels in the Prometheus text
+// format, labelled with the tunnel's name (its alias when unnamed) and
+// server.
+func WriteMetrics(w io.Writer, tunnels []TunnelState) (err error) {
+	var out strings.Builder
+	for _, m := range tunnelMetrics {
+		fmt.Fprintf(&out, "# HELP %s %s\n# TYPE %s %s\n", m.name, m.help, m.name, m.kind)
+		for i := range tunnels {
+			s := &tunnels[i]
+			name := s.Name
+			if name == "" {
+				name = s.Alias
+			}
+			fmt.Fprintf(&out, "%s{tunnel=\"%s\",server=\"%s\"} %g\n", m.name, labelValue(name), labelValue(s.Alias), m.value(s))
+		}
+	}
+	_, err = io.WriteString(w, out.String())
+	return
+}
+
+// labelValue escapes a Prometheus label value.
+func labelValue(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(s)
+}
+
+// ServeMetrics serves the metrics of the tunnels returned by tunnels on
+// http://addr/metrics in the background. The address is bound before it
+// returns, so a port in use is reported at once.
+func ServeMetrics(addr string, tunnels func() []TunnelState) (server *http.Server, err error) {
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, fmt.Errorf("failed to listen for metrics on %s: %v", addr, err)
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/metrics", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		_ = WriteMetrics(w, tunnels())
+	})
+	server = &http.Server{Handler: mux}
+	go func() {
+		_ = server.Serve(listener)
+	}()
+	return
+}
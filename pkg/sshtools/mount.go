@@ -0,0 +1,31 @@
+package sshtools
+
+import "time"
+
+// MountOptions control how MountSFTP exposes a remote directory.
+type MountOptions struct {
+	// Name identifies the mount in mount and df output, e.g. web1:/var/www.
+	Name string
+	// CacheTTL is how long file attributes and directory entries are
+	// cached, by us and by the kernel; 0 asks the server every time.
+	CacheTTL   time.Duration
+	ReadOnly   bool
+	AllowOther bool
+}
+
+// Mount is a remote directory mounted by MountSFTP.
+type Mount struct {
+	unmount func() error
+	wait    func()
+}
+
+// Unmount unmounts the directory; it fails while files in it are in use.
+func (m *Mount) Unmount() error {
+	return m.unmount()
+}
+
+// Wait returns once the directory is unmounted, by Unmount or from
+// outside.
+func (m *Mount) Wait() {
+	m.wait()
+}
@@ -0,0 +1,430 @@
+//go:build linux || darwin
+
+package sshtools
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/hanwen/go-fuse/v2/fs"
+	"github.com/hanwen/go-fuse/v2/fuse"
+	"github.com/pkg/sftp"
+	"golang.org/x/sys/unix"
+)
+
+// MountSFTP mounts the remote directory root at the local directory dir
+// through FUSE, reading and writing it over client. Files appear owned by
+// the local user.
+func MountSFTP(client *sftp.Client, root, dir string, o MountOptions) (m *Mount, err error) {
+	if root, err = client.RealPath(root); err != nil {
+		return nil, fmt.Errorf("failed to resolve %s: %v", root, err)
+	}
+	info, err := client.Stat(root)
+	if err != nil {
+		return nil, fmt.Errorf("failed to stat %s: %v", root, err)
+	}
+	if !info.IsDir() {
+		return nil, fmt.Errorf("%s is not a directory", root)
+	}
+
+	fsys := &sftpFS{client: client, root: root, ttl: o.CacheTTL, uid: uint32(os.Getuid()), gid: uint32(os.Getgid()),
+		attrs: map[string]cachedAttr{}}
+	ttl := o.CacheTTL
+	options := &fs.Options{
+		EntryTimeout: &ttl,
+		AttrTimeout:  &ttl,
+		UID:          fsys.uid,
+		GID:          fsys.gid,
+		MountOptions: fuse.MountOptions{
+			FsName:     o.Name,
+			Name:       "sshtools",
+			AllowOther: o.AllowOther,
+			// 以 root 运行时直接 mount，不需要 fusermount
+			DirectMount: true,
+		},
+	}
+	if o.ReadOnly {
+		options.MountOptions.Options = append(options.MountOptions.Options, "ro")
+	}
+	server, err := fs.Mount(dir, &sftpNode{fsys: fsys}, options)
+	if err != nil {
+		return nil, fmt.Errorf("failed to mount %s: %v", dir, err)
+	}
+	return &Mount{unmount: server.Unmount, wait: server.Wait}, nil
+}
+
+// UnmountDir unmounts a directory mounted by sshtools mount in another
+// process.
+func UnmountDir(dir string) error {
+	// 普通用户只能通过 fusermount 卸载
+	for _, name := range []string{"fusermount3", "fusermount"} {
+		if program, err := exec.LookPath(name); err == nil {
+			if out, errs := exec.Command(program, "-u", dir).CombinedOutput(); errs != nil {
+				return fmt.Errorf("failed to unmount %s: %s", dir, bytes.TrimSpace(out))
+			}
+			return nil
+		}
+	}
+	if err := unix.Unmount(dir, 0); err != nil {
+		return fmt.Errorf("failed to unmount %s: %v", dir, err)
+	}
+	return nil
+}
+
+// sftpFS is the state shared by the nodes of a mount.
+type sftpFS struct {
+	client   *sftp.Client
+	root     string
+	ttl      time.Duration
+	uid, gid uint32
+
+	mu    sync.Mutex
+	attrs map[string]cachedAttr
+}
+
+type cachedAttr struct {
+	info    os.FileInfo
+	expires time.Time
+}
+
+// stat returns the attributes of the remote path p, from the cache while
+// they are fresh. Symbolic links are not followed.
+func (f *sftpFS) stat(p string) (info os.FileInfo, err error) {
+	f.mu.Lock()
+	cached, ok := f.attrs[p]
+	f.mu.Unlock()
+	if ok && time.Now().Before(cached.expires) {
+		return cached.info, nil
+	}
+	if info, err = f.client.Lstat(p); err != nil {
+		f.forget(p)
+		return
+	}
+	f.remember(p, info)
+	return
+}
+
+func (f *sftpFS) remember(p string, info os.FileInfo) {
+	if f.ttl <= 0 {
+		return
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.attrs[p] = cachedAttr{info: info, expires: time.Now().Add(f.ttl)}
+}
+
+// forget drops the cached attributes of p and of everything below it.
+func (f *sftpFS) forget(p string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for name := range f.attrs {
+		if name == p || strings.HasPrefix(name, p+"/") {
+			delete(f.attrs, name)
+		}
+	}
+}
+
+// fill sets out from the remote attributes info.
+func (f *sftpFS) fill(info os.FileInfo, out *fuse.Attr) {
+	mtime := info.ModTime()
+	atime := mtime
+	if st, ok := info.Sys().(*sftp.FileStat); ok {
+		out.Mode = st.Mode
+		atime = time.Unix(int64(st.Atime), 0)
+	} else {
+		out.Mode = unixMode(info.Mode())
+	}
+	out.Size = uint64(info.Size())
+	out.Blocks = (out.Size + 511) / 512
+	out.Nlink = 1
+	out.Owner = fuse.Owner{Uid: f.uid, Gid: f.gid}
+	out.SetTimes(&atime, &mtime, &mtime)
+}
+
+// unixMode converts a Go file mode to st_mode bits.
+func unixMode(mode os.FileMode) uint32 {
+	bits := uint32(mode.Perm())
+	switch {
+	case mode.IsDir():
+		bits |= syscall.S_IFDIR
+	case mode&os.ModeSymlink != 0:
+		bits |= syscall.S_IFLNK
+	case mode&os.ModeNamedPipe != 0:
+		bits |= syscall.S_IFIFO
+	case mode&os.ModeSocket != 0:
+		bits |= syscall.S_IFSOCK
+	default:
+		bits |= syscall.S_IFREG
+	}
+	return bits
+}
+
+// sftpErrno maps an SFTP error to the errno the kernel passes on.
+func sftpErrno(err error) syscall.Errno {
+	switch {
+	case err == nil:
+		return 0
+	case errors.Is(err, os.ErrNotExist):
+		return syscall.ENOENT
+	case errors.Is(err, os.ErrPermission):
+		return syscall.EACCES
+	case errors.Is(err, os.ErrExist):
+		return syscall.EEXIST
+	}
+	var status *sftp.StatusError
+	if errors.As(err, &status) && status.FxCode() == sftp.ErrSSHFxOpUnsupported {
+		return syscall.ENOTSUP
+	}
+	return syscall.EIO
+}
+
+// sftpNode is a file or directory of the mount.
+type sftpNode struct {
+	fs.Inode
+	fsys *sftpFS
+}
+
+var (
+	_ fs.NodeGetattrer  = (*sftpNode)(nil)
+	_ fs.NodeSetattrer  = (*sftpNode)(nil)
+	_ fs.NodeLookuper   = (*sftpNode)(nil)
+	_ fs.NodeReaddirer  = (*sftpNode)(nil)
+	_ fs.NodeOpener     = (*sftpNode)(nil)
+	_ fs.NodeCreater    = (*sftpNode)(nil)
+	_ fs.NodeMkdirer    = (*sftpNode)(nil)
+	_ fs.NodeUnlinker   = (*sftpNode)(nil)
+	_ fs.NodeRmdirer    = (*sftpNode)(nil)
+	_ fs.NodeRenamer    = (*sftpNode)(nil)
+	_ fs.NodeReadlinker = (*sftpNode)(nil)
+	_ fs.NodeSymlinker  = (*sftpNode)(nil)
+	_ fs.NodeStatfser   = (*sftpNode)(nil)
+)
+
+// remote returns the remote path of n, or of its child name when given.
+func (n *sftpNode) remote(name ...string) string {
+	return path.Join(append([]string{n.fsys.root, n.Path(nil)}, name...)...)
+}
+
+// child stats the new or looked up child name and returns its inode.
+func (n *sftpNode) child(ctx context.Context, name string, out *fuse.EntryOut) (*fs.Inode, syscall.Errno) {
+	info, err := n.fsys.stat(n.remote(name))
+	if err != nil {
+		return nil, sftpErrno(err)
+	}
+	n.fsys.fill(info, &out.Attr)
+	return n.NewInode(ctx, &sftpNode{fsys: n.fsys}, fs.StableAttr{Mode: out.Mode & syscall.S_IFMT}), 0
+}
+
+func (n *sftpNode) Getattr(ctx context.Context, f fs.FileHandle, out *fuse.AttrOut) syscall.Errno {
+	info, err := n.fsys.stat(n.remote())
+	if err != nil {
+		return sftpErrno(err)
+	}
+	n.fsys.fill(info, &out.Attr)
+	return 0
+}
+
+func (n *sftpNode) Setattr(ctx context.Context, f fs.FileHandle, in *fuse.SetAttrIn, out *fuse.AttrOut) syscall.Errno {
+	p := n.remote()
+	n.fsys.forget(p)
+	client := n.fsys.client
+	if size, ok := in.GetSize(); ok {
+		if err := client.Truncate(p, int64(size)); err != nil {
+			return sftpErrno(err)
+		}
+	}
+	if mode, ok := in.GetMode(); ok {
+		if err := client.Chmod(p, os.FileMode(mode&0o7777)); err != nil {
+			return sftpErrno(err)
+		}
+	}
+	mtime, setM := in.GetMTime()
+	atime, setA := in.GetATime()
+	if setM || setA {
+		// SFTP 只能同时设置两个时间，未给出的保持原值
+		info, err := client.Lstat(p)
+		if err != nil {
+			return sftpErrno(err)
+		}
+		if !setM {
+			mtime = info.ModTime()
+		}
+		if !setA {
+			atime = mtime
+			if st, ok := info.Sys().(*sftp.FileStat); ok {
+				atime = time.Unix(int64(st.Atime), 0)
+			}
+		}
+		if err = client.Chtimes(p, atime, mtime); err != nil {
+			return sftpErrno(err)
+		}
+	}
+	n.fsys.forget(p)
+	return n.Getattr(ctx, f, out)
+}
+
+func (n *sftpNode) Lookup(ctx context.Context, name string, out *fuse.EntryOut) (*fs.Inode, syscall.Errno) {
+	return n.child(ctx, name, out)
+}
+
+func (n *sftpNode) Readdir(ctx context.Context) (fs.DirStream, syscall.Errno) {
+	p := n.remote()
+	infos, err := n.fsys.client.ReadDir(p)
+	if err != nil {
+		return nil, sftpErrno(err)
+	}
+	// 列目录时一并缓存属性，ls -l 不必逐个 stat
+	entries := make([]fuse.DirEntry, 0, len(infos))
+	for _, info := range infos {
+		n.fsys.remember(path.Join(p, info.Name()), info)
+		var attr fuse.Attr
+		n.fsys.fill(info, &attr)
+		entries = append(entries, fuse.DirEntry{Name: info.Name(), Mode: attr.Mode})
+	}
+	return fs.NewListDirStream(entries), 0
+}
+
+func (n *sftpNode) Open(ctx context.Context, flags uint32) (fs.FileHandle, uint32, syscall.Errno) {
+	p := n.remote()
+	file, err := n.fsys.client.OpenFile(p, int(flags)&(os.O_RDONLY|os.O_WRONLY|os.O_RDWR|os.O_APPEND|os.O_TRUNC))
+	if err != nil {
+		return nil, 0, sftpErrno(err)
+	}
+	if flags&syscall.O_TRUNC != 0 {
+		n.fsys.forget(p)
+	}
+	return &sftpHandle{file: file, fsys: n.fsys, path: p}, 0, 0
+}
+
+func (n *sftpNode) Create(ctx context.Context, name string, flags uint32, mode uint32, out *fuse.EntryOut) (*fs.Inode, fs.FileHandle, uint32, syscall.Errno) {
+	p := n.remote(name)
+	file, err := n.fsys.client.OpenFile(p, int(flags)&(os.O_WRONLY|os.O_RDWR|os.O_APPEND|os.O_TRUNC|os.O_EXCL)|os.O_CREATE)
+	if err != nil {
+		return nil, nil, 0, sftpErrno(err)
+	}
+	_ = n.fsys.client.Chmod(p, os.FileMode(mode&0o7777))
+	n.fsys.forget(p)
+	child, e := n.child(ctx, name, out)
+	if e != 0 {
+		_ = file.Close()
+		return nil, nil, 0, e
+	}
+	return child, &sftpHandle{file: file, fsys: n.fsys, path: p}, 0, 0
+}
+
+func (n *sftpNode) Mkdir(ctx context.Context, name string, mode uint32, out *fuse.EntryOut) (*fs.Inode, syscall.Errno) {
+	p := n.remote(name)
+	if err := n.fsys.client.Mkdir(p); err != nil {
+		return nil, sftpErrno(err)
+	}
+	_ = n.fsys.client.Chmod(p, os.FileMode(mode&0o7777))
+	n.fsys.forget(p)
+	return n.child(ctx, name, out)
+}
+
+func (n *sftpNode) Unlink(ctx context.Context, name string) syscall.Errno {
+	p := n.remote(name)
+	n.fsys.forget(p)
+	return sftpErrno(n.fsys.client.Remove(p))
+}
+
+func (n *sftpNode) Rmdir(ctx context.Context, name string) syscall.Errno {
+	p := n.remote(name)
+	n.fsys.forget(p)
+	return sftpErrno(n.fsys.client.RemoveDirectory(p))
+}
+
+func (n *sftpNode) Rename(ctx context.Context, name string, newParent fs.InodeEmbedder, newName string, flags uint32) syscall.Errno {
+	parent, ok := newParent.(*sftpNode)
+	if !ok || flags != 0 {
+		return syscall.ENOTSUP
+	}
+	from, to := n.remote(name), parent.remote(newName)
+	n.fsys.forget(from)
+	n.fsys.forget(to)
+	// 覆盖已有文件需要 posix-rename 扩展，服务器不支持时退回普通的 rename
+	if err := n.fsys.client.PosixRename(from, to); err == nil {
+		return 0
+	}
+	return sftpErrno(n.fsys.client.Rename(from, to))
+}
+
+func (n *sftpNode) Readlink(ctx context.Context) ([]byte, syscall.Errno) {
+	target, err := n.fsys.client.ReadLink(n.remote())
+	if err != nil {
+		return nil, sftpErrno(err)
+	}
+	return []byte(target), 0
+}
+
+func (n *sftpNode) Symlink(ctx context.Context, target, name string, out *fuse.EntryOut) (*fs.Inode, syscall.Errno) {
+	p := n.remote(name)
+	if err := n.fsys.client.Symlink(target, p); err != nil {
+		return nil, sftpErrno(err)
+	}
+	n.fsys.forget(p)
+	return n.child(ctx, name, out)
+}
+
+func (n *sftpNode) Statfs(ctx context.Context, out *fuse.StatfsOut) syscall.Errno {
+	// 服务器不支持 statvfs 扩展时报告为空，df 等仍可运行
+	vfs, err := n.fsys.client.StatVFS(n.fsys.root)
+	if err != nil {
+		return 0
+	}
+	out.Blocks, out.Bfree, out.Bavail = vfs.Blocks, vfs.Bfree, vfs.Bavail
+	out.Files, out.Ffree = vfs.Files, vfs.Ffree
+	out.Bsize, out.Frsize, out.NameLen = uint32(vfs.Bsize), uint32(vfs.Frsize), uint32(vfs.Namemax)
+	return 0
+}
+
+// sftpHandle is an open remote file.
+type sftpHandle struct {
+	file *sftp.File
+	fsys *sftpFS
+	path string
+}
+
+var (
+	_ fs.FileReader   = (*sftpHandle)(nil)
+	_ fs.FileWriter   = (*sftpHandle)(nil)
+	_ fs.FileFsyncer  = (*sftpHandle)(nil)
+	_ fs.FileReleaser = (*sftpHandle)(nil)
+)
+
+func (h *sftpHandle) Read(ctx context.Context, dest []byte, off int64) (fuse.ReadResult, syscall.Errno) {
+	n, err := h.file.ReadAt(dest, off)
+	if err != nil && !errors.Is(err, io.EOF) {
+		return nil, sftpErrno(err)
+	}
+	return fuse.ReadResultData(dest[:n]), 0
+}
+
+func (h *sftpHandle) Write(ctx context.Context, data []byte, off int64) (uint32, syscall.Errno) {
+	n, err := h.file.WriteAt(data, off)
+	h.fsys.forget(h.path)
+	return uint32(n), sftpErrno(err)
+}
+
+func (h *sftpHandle) Fsync(ctx context.Context, flags uint32) syscall.Errno {
+	// fsync 扩展不是必需的，服务器不支持时视为成功
+	if e := sftpErrno(h.file.Sync()); e != syscall.ENOTSUP {
+		return e
+	}
+	return 0
+}
+
+func (h *sftpHandle) Release(ctx context.Context) syscall.Errno {
+	h.fsys.forget(h.path)
+	return sftpErrno(h.file.Close())
+}
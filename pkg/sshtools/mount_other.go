@@ -0,0 +1,18 @@
+//go:build !linux && !darwin
+
+package sshtools
+
+import (
+	"errors"
+	"runtime"
+
+	"github.com/pkg/sftp"
+)
+
+func MountSFTP(client *sftp.Client, root, dir string, o MountOptions) (*Mount, error) {
+	return nil, errors.New("sshtools mount is not implemented on " + runtime.GOOS)
+}
+
+func UnmountDir(dir string) error {
+	return errors.New("sshtools unmount is not implemented on " + runtime.GOOS)
+}
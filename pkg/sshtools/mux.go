@@ -0,0 +1,354 @@
+package sshtools
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// Global requests understood by a control master.
+const (
+	muxRequestExit  = "exit@sshtools"
+	muxRequestCheck = "check@sshtools"
+)
+
+// ErrNoMaster is returned by DialControl when no master is listening.
+var ErrNoMaster = errors.New("no control master running")
+
+// ControlPath returns the control socket path for alias.
+func ControlPath(alias string) (path string, err error) {
+	dir, err := StateDir("sockets")
+	if err != nil {
+		return
+	}
+	return filepath.Join(dir, alias+".sock"), nil
+}
+
+// ControlPersist returns how long a control master for server stays alive
+// after its last client disconnects, and whether multiplexing is enabled at
+// all. "yes" or "0" keep the master until it is told to exit.
+func (c *Config) ControlPersist(server *Server) (persist time.Duration, enabled bool, err error) {
+	value := server.ControlPersist
+	if value == "" {
+		value = c.DefaultControlPersist
+	}
+	switch strings.ToLower(value) {
+	case "", "no", "false":
+		return 0, false, nil
+	case "yes", "true", "0":
+		return 0, true, nil
+	}
+	persist, err = time.ParseDuration(value)
+	if err != nil {
+		err = fmt.Errorf("invalid control_persist %q for %s: %v", value, server.Alias, err)
+		return
+	}
+	return persist, true, nil
+}
+
+// StayConnected returns how long the connection of an interactive session
+// to server is kept open after the session ends, for later commands to
+// share through its control socket; 0 when it is closed at once.
+func (c *Config) StayConnected(server *Server) (d time.Duration, err error) {
+	value := server.StayConnected
+	if value == "" {
+		value = c.DefaultStayConnected
+	}
+	if value == "" {
+		return 0, nil
+	}
+	if d, err = time.ParseDuration(value); err != nil || d < 0 {
+		return 0, fmt.Errorf("invalid stay_connected %q for %s, want a duration such as \"10m\"", value, server.Alias)
+	}
+	return
+}
+
+// DialControl connects to the control master listening on path. A socket
+// left behind by a crashed master is removed and ErrNoMaster is returned.
+func DialControl(path string, server *Server) (c *Client, err error) {
+	conn, err := net.Dial("unix", path)
+	if err != nil {
+		if errors.Is(err, syscall.ECONNREFUSED) {
+			_ = os.Remove(path)
+			_ = os.Remove(path + ".pub")
+		}
+		err = ErrNoMaster
+		return
+	}
+
+	hostKey, err := os.ReadFile(path + ".pub")
+	if err != nil {
+		_ = conn.Close()
+		err = ErrNoMaster
+		return
+	}
+	key, _, _, _, err := ssh.ParseAuthorizedKey(hostKey)
+	if err != nil {
+		_ = conn.Close()
+		err = fmt.Errorf("invalid control master key %s: %v", path+".pub", err)
+		return
+	}
+
+	sshConn, chans, reqs, err := ssh.NewClientConn(conn, "control:"+server.Alias, &ssh.ClientConfig{
+		User:            server.User,
+		HostKeyCallback: ssh.FixedHostKey(key),
+		Timeout:         5 * time.Second,
+	})
+	if err != nil {
+		_ = conn.Close()
+		err = fmt.Errorf("failed to connect to control master %s: %v", path, err)
+		return
+	}
+	return &Client{Client: ssh.NewClient(sshConn, chans, reqs), Server: server}, nil
+}
+
+// ControlExit asks the master on path to shut down.
+func ControlExit(path string, server *Server) (err error) {
+	client, err := DialControl(path, server)
+	if err != nil {
+		return
+	}
+	defer func() { _ = client.Close() }()
+	ok, _, err := client.SendRequest(muxRequestExit, true, nil)
+	if err == nil && !ok {
+		err = fmt.Errorf("control master refused to exit")
+	}
+	return
+}
+
+// ControlCheck returns the pid of the master listening on path.
+func ControlCheck(path string, server *Server) (pid int, err error) {
+	client, err := DialControl(path, server)
+	if err != nil {
+		return
+	}
+	defer func() { _ = client.Close() }()
+	ok, payload, err := client.SendRequest(muxRequestCheck, true, nil)
+	if err != nil {
+		return
+	}
+	if !ok || len(payload) < 4 {
+		err = fmt.Errorf("unexpected reply from control master")
+		return
+	}
+	return int(binary.BigEndian.Uint32(payload)), nil
+}
+
+// ControlMaster shares an authenticated Client with other processes over a
+// unix socket. Each connecting process performs a local SSH handshake with
+// the master and its channels are proxied to the upstream connection.
+type ControlMaster struct {
+	Client  *Client
+	Path    string
+	Persist time.Duration
+
+	listener net.Listener
+	config   *ssh.ServerConfig
+
+	mu     sync.Mutex
+	active int
+	idle   *time.Timer
+	done   chan struct{}
+	once   sync.Once
+}
+
+// Listen creates the control socket. Only the current user can connect.
+func (m *ControlMaster) Listen() (err error) {
+	_, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return
+	}
+	signer, err := ssh.NewSignerFromKey(priv)
+	if err != nil {
+		return
+	}
+	m.config = &ssh.ServerConfig{NoClientAuth: true}
+	m.config.AddHostKey(signer)
+
+	_ = os.Remove(m.Path)
+	m.listener, err = net.Listen("unix", m.Path)
+	if err != nil {
+		err = fmt.Errorf("failed to listen on control socket %s: %v", m.Path, err)
+		return
+	}
+	_ = os.Chmod(m.Path, 0o600)
+	err = os.WriteFile(m.Path+".pub", ssh.MarshalAuthorizedKey(signer.PublicKey()), 0o600)
+	if err != nil {
+		_ = m.listener.Close()
+		return
+	}
+	m.done = make(chan struct{})
+	return
+}
+
+// Serve accepts clients until the master is told to exit, the upstream
+// connection drops, or it has been idle for Persist.
+func (m *ControlMaster) Serve() error {
+	defer func() {
+		_ = os.Remove(m.Path)
+		_ = os.Remove(m.Path + ".pub")
+	}()
+
+	go func() {
+		_ = m.Client.Wait()
+		m.shutdown()
+	}()
+	m.mu.Lock()
+	m.armIdle()
+	m.mu.Unlock()
+
+	for {
+		conn, err := m.listener.Accept()
+		if err != nil {
+			select {
+			case <-m.done:
+				return nil
+			default:
+				return err
+			}
+		}
+		go m.handle(conn)
+	}
+}
+
+func (m *ControlMaster) shutdown() {
+	m.once.Do(func() {
+		close(m.done)
+		_ = m.listener.Close()
+	})
+}
+
+// armIdle starts the persist timer when no clients are connected. The
+// caller holds m.mu.
+func (m *ControlMaster) armIdle() {
+	if m.active > 0 || m.Persist <= 0 {
+		return
+	}
+	m.idle = time.AfterFunc(m.Persist, m.shutdown)
+}
+
+func (m *ControlMaster) handle(conn net.Conn) {
+	sshConn, chans, reqs, err := ssh.NewServerConn(conn, m.config)
+	if err != nil {
+		_ = conn.Close()
+		return
+	}
+	m.mu.Lock()
+	m.active++
+	if m.idle != nil {
+		m.idle.Stop()
+		m.idle = nil
+	}
+	m.mu.Unlock()
+	defer func() {
+		m.mu.Lock()
+		m.active--
+		m.armIdle()
+		m.mu.Unlock()
+	}()
+
+	go func() {
+		for req := range reqs {
+			switch req.Type {
+			case muxRequestExit:
+				_ = req.Reply(true, nil)
+				m.shutdown()
+			case muxRequestCheck:
+				payload := make([]byte, 4)
+				binary.BigEndian.PutUint32(payload, uint32(os.Getpid()))
+				_ = req.Reply(true, payload)
+			case "tcpip-forward", "cancel-tcpip-forward":
+				// Remote forwards would deliver channels to the master rather
+				// than to the requesting client.
+				_ = req.Reply(false, nil)
+			default:
+				ok, payload, errs := m.Client.SendRequest(req.Type, req.WantReply, req.Payload)
+				if req.WantReply {
+					_ = req.Reply(ok && errs == nil, payload)
+				}
+			}
+		}
+	}()
+
+	closed := make(chan struct{})
+	defer close(closed)
+	go func() {
+		select {
+		case <-m.done:
+			_ = sshConn.Close()
+		case <-closed:
+		}
+	}()
+
+	for newChannel := range chans {
+		go m.proxyChannel(newChannel)
+	}
+}
+
+func (m *ControlMaster) proxyChannel(newChannel ssh.NewChannel) {
+	upstream, upstreamReqs, err := m.Client.OpenChannel(newChannel.ChannelType(), newChannel.ExtraData())
+	if err != nil {
+		var openErr *ssh.OpenChannelError
+		if errors.As(err, &openErr) {
+			_ = newChannel.Reject(openErr.Reason, openErr.Message)
+		} else {
+			_ = newChannel.Reject(ssh.ConnectionFailed, err.Error())
+		}
+		return
+	}
+	downstream, downstreamReqs, err := newChannel.Accept()
+	if err != nil {
+		_ = upstream.Close()
+		return
+	}
+
+	go func() {
+		proxyRequests(downstreamReqs, upstream)
+		_ = upstream.Close()
+	}()
+	go func() {
+		_, _ = io.Copy(upstream, downstream)
+		_ = upstream.CloseWrite()
+	}()
+	go func() {
+		_, _ = io.Copy(upstream.Stderr(), downstream.Stderr())
+	}()
+
+	// Everything coming back from the server, including exit-status, has
+	// to be delivered before the client's side of the channel is closed.
+	var wg sync.WaitGroup
+	wg.Go(func() {
+		_, _ = io.Copy(downstream, upstream)
+	})
+	wg.Go(func() {
+		_, _ = io.Copy(downstream.Stderr(), upstream.Stderr())
+	})
+	wg.Go(func() {
+		proxyRequests(upstreamReqs, downstream)
+	})
+	wg.Wait()
+	_ = downstream.CloseWrite()
+	_ = downstream.Close()
+	_ = upstream.Close()
+}
+
+func proxyRequests(in <-chan *ssh.Request, out ssh.Channel) {
+	for req := range in {
+		ok, err := out.SendRequest(req.Type, req.WantReply, req.Payload)
+		if req.WantReply {
+			_ = req.Reply(ok && err == nil, nil)
+		}
+	}
+}
@@ -0,0 +1,238 @@
+package sshtools
+
+import (
+	"bufio"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+// ProxyNone in "proxy" connects directly even when a global proxy is set.
+const ProxyNone = "none"
+
+// SOCKS5 username/password authentication (RFC 1929).
+const (
+	socksUserPass        = 2
+	socksUserPassVersion = 1
+)
+
+// proxyDefaultPorts are used when a proxy URL has no port.
+var proxyDefaultPorts = map[string]string{"http": "8080", "socks5": "1080", "socks5h": "1080"}
+
+// UsesProxy reports whether s is reached through an HTTP or SOCKS5 proxy.
+func (s *Server) UsesProxy() bool {
+	return s.Proxy != "" && s.Proxy != ProxyNone
+}
+
+// parseProxy parses a "proxy" value: http://[user:password@]host[:port] for
+// an HTTP CONNECT proxy, or socks5:// (or socks5h://) for a SOCKS5 proxy.
+func parseProxy(value string) (proxy *url.URL, err error) {
+	proxy, err = url.Parse(value)
+	if err != nil || proxy.Host == "" || proxy.Path != "" && proxy.Path != "/" {
+		return nil, fmt.Errorf(`"proxy" %q is not a URL such as http://proxy:8080 or socks5://proxy:1080`, value)
+	}
+	port, ok := proxyDefaultPorts[proxy.Scheme]
+	if !ok {
+		return nil, fmt.Errorf(`"proxy" %q must use http, socks5 or socks5h`, value)
+	}
+	if proxy.Port() == "" {
+		proxy.Host = net.JoinHostPort(proxy.Hostname(), port)
+	}
+	return
+}
+
+// dialProxy connects to addr through the proxy at proxyURL, spending at
+// most timeout on the proxy connection and its handshake. Host names in
+// addr are resolved by the proxy.
+func dialProxy(proxyURL, addr string, timeout time.Duration) (conn net.Conn, err error) {
+	proxy, err := parseProxy(proxyURL)
+	if err != nil {
+		return
+	}
+	conn, err = net.DialTimeout("tcp", proxy.Host, timeout)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to proxy %s: %v", proxy.Host, err)
+	}
+	_ = conn.SetDeadline(time.Now().Add(timeout))
+	if proxy.Scheme == "http" {
+		conn, err = httpConnect(conn, proxy, addr)
+	} else {
+		err = socksClientConnect(conn, proxy, addr)
+	}
+	if err != nil {
+		_ = conn.Close()
+		return nil, fmt.Errorf("proxy %s: %v", proxy.Host, err)
+	}
+	_ = conn.SetDeadline(time.Time{})
+	return
+}
+
+// bufferedConn returns what the reader buffered past the proxy's response
+// before reading the connection again.
+type bufferedConn struct {
+	net.Conn
+	r *bufio.Reader
+}
+
+func (c *bufferedConn) Read(b []byte) (int, error) {
+	return c.r.Read(b)
+}
+
+// httpConnect asks an HTTP proxy to open a tunnel to addr.
+func httpConnect(conn net.Conn, proxy *url.URL, addr string) (net.Conn, error) {
+	req := &http.Request{
+		Method: http.MethodConnect,
+		URL:    &url.URL{Opaque: addr},
+		Host:   addr,
+		Header: http.Header{},
+	}
+	if proxy.User != nil {
+		password, _ := proxy.User.Password()
+		credentials := base64.StdEncoding.EncodeToString([]byte(proxy.User.Username() + ":" + password))
+		req.Header.Set("Proxy-Authorization", "Basic "+credentials)
+	}
+	if err := req.Write(conn); err != nil {
+		return conn, err
+	}
+	// 服务器的 SSH 版本行可能紧跟在响应之后，已读入缓冲的部分要交给握手
+	r := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(r, req)
+	if err != nil {
+		return conn, fmt.Errorf("invalid CONNECT response: %v", err)
+	}
+	_ = resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return conn, fmt.Errorf("CONNECT %s refused: %s", addr, resp.Status)
+	}
+	return &bufferedConn{Conn: conn, r: r}, nil
+}
+
+// socksReplyErrors describe the SOCKS5 reply codes.
+var socksReplyErrors = map[byte]string{
+	1: "general failure",
+	2: "connection not allowed by ruleset",
+	3: "network unreachable",
+	4: "host unreachable",
+	5: "connection refused",
+	6: "TTL expired",
+	7: "command not supported",
+	8: "address type not supported",
+}
+
+// socksClientConnect asks a SOCKS5 proxy to connect to addr.
+func socksClientConnect(conn net.Conn, proxy *url.URL, addr string) (err error) {
+	host, portStr, err := net.SplitHostPort(addr)
+	if err != nil {
+		return
+	}
+	port, err := strconv.Atoi(portStr)
+	if err != nil {
+		return
+	}
+
+	methods := []byte{socksNoAuth}
+	if proxy.User != nil {
+		methods = append(methods, socksUserPass)
+	}
+	if _, err = conn.Write(append([]byte{socksVersion, byte(len(methods))}, methods...)); err != nil {
+		return
+	}
+	reply := make([]byte, 2)
+	if _, err = io.ReadFull(conn, reply); err != nil {
+		return
+	}
+	switch {
+	case reply[0] != socksVersion:
+		return errors.New("not a SOCKS5 proxy")
+	case reply[1] == socksUserPass && proxy.User != nil:
+		if err = socksLogin(conn, proxy.User); err != nil {
+			return
+		}
+	case reply[1] != socksNoAuth:
+		return errors.New("no acceptable authentication method")
+	}
+
+	// 域名交给代理解析
+	req := []byte{socksVersion, socksCmdConnect, 0}
+	if ip := net.ParseIP(host); ip == nil {
+		if len(host) > 255 {
+			return fmt.Errorf("host name %s is too long", host)
+		}
+		req = append(append(req, socksDomain, byte(len(host))), host...)
+	} else if ip4 := ip.To4(); ip4 != nil {
+		req = append(append(req, socksIPv4), ip4...)
+	} else {
+		req = append(append(req, socksIPv6), ip...)
+	}
+	req = binary.BigEndian.AppendUint16(req, uint16(port))
+	if _, err = conn.Write(req); err != nil {
+		return
+	}
+
+	header := make([]byte, 4)
+	if _, err = io.ReadFull(conn, header); err != nil {
+		return
+	}
+	if header[1] != socksSucceeded {
+		msg, ok := socksReplyErrors[header[1]]
+		if !ok {
+			msg = fmt.Sprintf("error %d", header[1])
+		}
+		return fmt.Errorf("connect to %s failed: %s", addr, msg)
+	}
+	// 跳过代理绑定的地址和端口
+	var skip int
+	switch header[3] {
+	case socksIPv4:
+		skip = net.IPv4len + 2
+	case socksIPv6:
+		skip = net.IPv6len + 2
+	case socksDomain:
+		n := make([]byte, 1)
+		if _, err = io.ReadFull(conn, n); err != nil {
+			return
+		}
+		skip = int(n[0]) + 2
+	default:
+		return fmt.Errorf("invalid reply address type %d", header[3])
+	}
+	_, err = io.ReadFull(conn, make([]byte, skip))
+	return
+}
+
+// socksLogin authenticates to a SOCKS5 proxy with a username and password.
+func socksLogin(conn net.Conn, user *url.Userinfo) (err error) {
+	password, _ := user.Password()
+	name := user.Username()
+	if len(name) > 255 || len(password) > 255 {
+		return errors.New("username or password longer than 255 bytes")
+	}
+	req := append([]byte{socksUserPassVersion, byte(len(name))}, name...)
+	req = append(append(req, byte(len(password))), password...)
+	if _, err = conn.Write(req); err != nil {
+		return
+	}
+	reply := make([]byte, 2)
+	if _, err = io.ReadFull(conn, reply); err != nil {
+		return
+	}
+	if reply[1] != 0 {
+		return errors.New("username or password rejected")
+	}
+	return
+}
+
+// RedactedProxy returns the proxy of s without its password, for display.
+func (s *Server) RedactedProxy() string {
+	if proxy, err := url.Parse(s.Proxy); err == nil {
+		return proxy.Redacted()
+	}
+	return s.Proxy
+}
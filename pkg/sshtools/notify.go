@@ -0,0 +1,114 @@
+package sshtools
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"runtime"
+	"strings"
+	"time"
+
+	"golang.org/x/term"
+)
+
+// DefaultNotifyThreshold is how long an operation has to run before a
+// completion notification is sent.
+const DefaultNotifyThreshold = 30 * time.Second
+
+// Notifier fires a local desktop notification (and optionally a terminal
+// bell) when a long-running operation such as a fleet exec or a large
+// transfer finishes. Delivery is best-effort: failures are only logged.
+type Notifier struct {
+	// Command is run through the shell with the title and message as $1 and
+	// $2, e.g. `notify-send` or `osascript -e 'display notification "$2"'`.
+	// When it does not reference them they are appended as arguments. They
+	// are in SSHTOOLS_TITLE and SSHTOOLS_MESSAGE as well; cmd on Windows has
+	// no positional parameters, so $1 and $2 stand for those variables there.
+	Command   string
+	Threshold time.Duration
+	Bell      bool
+	// Force notifies regardless of Threshold.
+	Force bool
+	// Batch disables notifications entirely (CI, piped output).
+	Batch bool
+
+	Bellout io.Writer
+	// Log receives the failures of Command.
+	Log func(level int, format string, args ...any)
+}
+
+// NewNotifier builds a Notifier from the notification settings in config.
+func NewNotifier(config *Config) *Notifier {
+	n := &Notifier{
+		Command:   config.NotifyCommand,
+		Threshold: DefaultNotifyThreshold,
+		Bell:      config.NotifyBell,
+		Batch:     IsBatch(),
+		Bellout:   os.Stderr,
+	}
+	if config.NotifyThreshold != "" {
+		if d, err := time.ParseDuration(config.NotifyThreshold); err == nil {
+			n.Threshold = d
+		}
+	}
+	return n
+}
+
+// IsBatch reports whether we are running unattended: in CI or without a
+// terminal on stdout.
+func IsBatch() bool {
+	if os.Getenv("CI") != "" {
+		return true
+	}
+	return !term.IsTerminal(int(os.Stdout.Fd()))
+}
+
+// Done reports the completion of operation, which ran against hosts servers
+// for elapsed and failed on failures of them.
+func (n *Notifier) Done(operation string, hosts int, elapsed time.Duration, failures int) {
+	if n == nil || n.Batch {
+		return
+	}
+	if !n.Force && elapsed < n.Threshold {
+		return
+	}
+
+	title := fmt.Sprintf("sshtools: %s finished", operation)
+	msg := fmt.Sprintf("%s on %d host(s) took %s", operation, hosts, elapsed.Round(time.Second))
+	if failures > 0 {
+		msg += fmt.Sprintf(", %d failed", failures)
+	}
+	n.Notify(title, msg, false)
+}
+
+// Notify sends a notification with title and msg right away, ringing the
+// bell when bell or the Bell setting asks for it.
+func (n *Notifier) Notify(title, msg string, bell bool) {
+	if n == nil || n.Batch {
+		return
+	}
+	if (bell || n.Bell) && n.Bellout != nil {
+		_, _ = fmt.Fprint(n.Bellout, "\a")
+	}
+	if n.Command == "" {
+		return
+	}
+
+	command := n.Command
+	if !strings.Contains(command, "$") {
+		command += ` "$1" "$2"`
+	}
+	var cmd *exec.Cmd
+	if runtime.GOOS == "windows" {
+		// 延迟展开在解析命令之后进行，标题中的 & 或 | 不会被当作命令
+		command = strings.NewReplacer("$1", "!SSHTOOLS_TITLE!", "$2", "!SSHTOOLS_MESSAGE!").Replace(command)
+		cmd = exec.Command("cmd", "/V:ON", "/C", command)
+	} else {
+		cmd = exec.Command("sh", "-c", command, "sshtools", title, msg)
+	}
+	cmd.Env = append(os.Environ(), "SSHTOOLS_TITLE="+title, "SSHTOOLS_MESSAGE="+msg)
+	if out, err := cmd.CombinedOutput(); err != nil && n.Log != nil {
+		n.Log(1, "notify command failed: %v: %s", err, strings.TrimSpace(string(out)))
+	}
+}
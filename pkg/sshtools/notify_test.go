@@ -0,0 +1,50 @@
+package sshtools
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+func TestNotifyCommand(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("uses sh")
+	}
+	out := filepath.Join(t.TempDir(), "out")
+	tests := map[string]string{
+		// 未引用 $1 和 $2 时作为参数追加
+		`printf '%s,' > ` + out:                                         "a & b,it's done,",
+		`printf '%s|%s' "$2" "$1" > ` + out:                             "it's done|a & b",
+		`printf '%s|%s' "$SSHTOOLS_TITLE" "$SSHTOOLS_MESSAGE" > ` + out: "a & b|it's done",
+	}
+	for command, want := range tests {
+		n := &Notifier{Command: command}
+		n.Notify("a & b", "it's done", false)
+		got, err := os.ReadFile(out)
+		if err != nil || string(got) != want {
+			t.Errorf("%s wrote %q, %v, want %q", command, got, err, want)
+		}
+	}
+}
+
+func TestNotifyLogsFailure(t *testing.T) {
+	var logged []string
+	n := &Notifier{Command: "echo no notifier here && exit 3", Log: func(level int, format string, args ...any) {
+		logged = append(logged, fmt.Sprintf("%d %s", level, fmt.Sprintf(format, args...)))
+	}}
+	n.Notify("title", "msg", false)
+	if len(logged) != 1 || !strings.HasPrefix(logged[0], "1 notify command failed") || !strings.Contains(logged[0], "no notifier here") {
+		t.Errorf("logged %q, want the failure at level 1", logged)
+	}
+
+	// 批处理模式下不运行命令
+	logged = nil
+	n.Batch = true
+	n.Notify("title", "msg", false)
+	if len(logged) != 0 {
+		t.Errorf("logged %q in batch mode", logged)
+	}
+}
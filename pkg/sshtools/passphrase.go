@@ -0,0 +1,108 @@
+package sshtools
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"runtime"
+	"strings"
+	"sync"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// passphraseAttempts is how often a typed passphrase may be wrong.
+const passphraseAttempts = 3
+
+// decryptedKeys holds the signers of encrypted keys by path, so the
+// passphrase is asked for once per process.
+var (
+	decryptedKeysMu sync.Mutex
+	decryptedKeys   = map[string]ssh.Signer{}
+)
+
+// loadPrivateKey reads and parses the private key at keyPath. Encrypted
+// keys are decrypted with the output of the server's passphrase_command, or
+// else a passphrase read through PromptPassword.
+func (d *Dialer) loadPrivateKey(server *Server, keyPath string) (signer ssh.Signer, err error) {
+	decryptedKeysMu.Lock()
+	defer decryptedKeysMu.Unlock()
+	if cached, ok := decryptedKeys[keyPath]; ok {
+		return cached, nil
+	}
+
+	key, err := os.ReadFile(keyPath)
+	if err != nil {
+		err = fmt.Errorf("failed to read private key %s: %v", keyPath, err)
+		return
+	}
+	signer, err = ssh.ParsePrivateKey(key)
+	var missing *ssh.PassphraseMissingError
+	if !errors.As(err, &missing) {
+		if err != nil {
+			err = fmt.Errorf("failed to parse private key %s: %v", keyPath, err)
+		}
+		return
+	}
+
+	// 私钥已加密：优先使用 passphrase_command，其次钥匙串，否则交互式输入
+	if server.PassphraseCommand == "" && server.PasswordSource == PasswordSourceKeychain {
+		passphrase, errs := KeychainSecret(server.Alias, KeychainPassphrase)
+		if errs == nil {
+			if signer, err = ssh.ParsePrivateKeyWithPassphrase(key, []byte(passphrase)); err != nil {
+				return nil, fmt.Errorf("failed to decrypt private key %s with the passphrase from the keychain: %v", keyPath, err)
+			}
+			decryptedKeys[keyPath] = signer
+			return
+		}
+		d.Logf(1, "passphrase of %s: %v", server.Alias, errs)
+	}
+	if server.PassphraseCommand != "" {
+		passphrase, errs := runSecretCommand(server.PassphraseCommand)
+		if errs != nil {
+			return nil, fmt.Errorf("passphrase_command: %v", errs)
+		}
+		if signer, err = ssh.ParsePrivateKeyWithPassphrase(key, []byte(passphrase)); err != nil {
+			return nil, fmt.Errorf("failed to decrypt private key %s with the passphrase_command output: %v", keyPath, err)
+		}
+	} else {
+		if d.PromptPassword == nil {
+			return nil, fmt.Errorf(`private key %s is encrypted: set "passphrase_command", store the passphrase with sshtools secret set -passphrase or run on a terminal`, keyPath)
+		}
+		for range passphraseAttempts {
+			passphrase, errs := d.PromptPassword(fmt.Sprintf("Enter passphrase for key %s: ", keyPath))
+			if errs != nil {
+				return nil, errs
+			}
+			if signer, err = ssh.ParsePrivateKeyWithPassphrase(key, []byte(passphrase)); err == nil {
+				break
+			}
+			d.Logf(1, "decrypting %s: %v", keyPath, err)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to decrypt private key %s: %v", keyPath, err)
+		}
+	}
+	decryptedKeys[keyPath] = signer
+	return
+}
+
+// runSecretCommand returns the first line command prints, as for
+// passphrase_command and $(...) values.
+func runSecretCommand(command string) (secret string, err error) {
+	var cmd *exec.Cmd
+	if runtime.GOOS == "windows" {
+		cmd = exec.Command("cmd", "/C", command)
+	} else {
+		cmd = exec.Command("sh", "-c", command)
+	}
+	cmd.Stderr = os.Stderr
+	out, err := cmd.Output()
+	if err != nil {
+		err = fmt.Errorf("command %q failed: %v", command, err)
+		return
+	}
+	secret, _, _ = strings.Cut(string(out), "\n")
+	return strings.TrimSuffix(secret, "\r"), nil
+}
@@ -0,0 +1,91 @@
+package sshtools
+
+import (
+	"bytes"
+	"io"
+	"time"
+)
+
+// Bracketed paste markers sent by the local terminal around pasted text.
+const (
+	pasteStart = "\x1b[200~"
+	pasteEnd   = "\x1b[201~"
+)
+
+// pasteChunk is the largest piece written at once when throttling.
+const pasteChunk = 256
+
+// pasteWriter forwards local input to the remote stdin. Bracketed paste
+// markers are never split across writes, and with a delay set the input is
+// sent a line (or pasteChunk bytes) at a time with a pause in between, for
+// slow targets such as serial consoles.
+type pasteWriter struct {
+	w     io.Writer
+	delay time.Duration
+	held  []byte
+}
+
+func (p *pasteWriter) Write(b []byte) (n int, err error) {
+	n = len(b)
+	// Only copy when something was held back; the common case writes b as is.
+	data := b
+	if len(p.held) > 0 {
+		data = append(p.held, b...)
+		p.held = nil
+	}
+
+	// A read that ends part way through a marker is held back until the
+	// rest arrives. Keys never produce such a prefix on their own, so only
+	// large pastes crossing a read boundary are delayed.
+	for i := len(data) - 1; i >= 0 && i >= len(data)-len(pasteStart)+1; i-- {
+		tail := data[i:]
+		if len(tail) >= 3 && (bytes.HasPrefix([]byte(pasteStart), tail) || bytes.HasPrefix([]byte(pasteEnd), tail)) {
+			p.held = append([]byte(nil), tail...)
+			data = data[:i]
+			break
+		}
+	}
+
+	if p.delay <= 0 {
+		if len(data) > 0 {
+			_, err = p.w.Write(data)
+		}
+		return
+	}
+	for len(data) > 0 {
+		end := pasteChunkEnd(data)
+		if _, err = p.w.Write(data[:end]); err != nil {
+			return
+		}
+		data = data[end:]
+		if len(data) > 0 {
+			time.Sleep(p.delay)
+		}
+	}
+	return
+}
+
+// pasteChunkEnd returns the length of the next throttled chunk: up to and
+// including a newline, at most pasteChunk bytes, extended so it doesn't
+// end inside a paste marker.
+func pasteChunkEnd(data []byte) int {
+	end := min(len(data), pasteChunk)
+	if i := bytes.IndexAny(data[:end], "\r\n"); i >= 0 {
+		end = i + 1
+	}
+	for i := max(0, end-len(pasteStart)+1); i < end; i++ {
+		if bytes.HasPrefix(data[i:], []byte(pasteStart)) || bytes.HasPrefix(data[i:], []byte(pasteEnd)) {
+			return max(end, i+len(pasteStart))
+		}
+	}
+	return end
+}
+
+// flush writes any input held back waiting for the rest of a marker.
+func (p *pasteWriter) flush() (err error) {
+	if len(p.held) > 0 {
+		_, err = p.w.Write(p.held)
+		p.held = nil
+	}
+	return
+}
@@ -0,0 +1,149 @@
+package sshtools
+
+import (
+	"fmt"
+	"os"
+	"os/user"
+	"path/filepath"
+	"runtime"
+	"strings"
+)
+
+// ExpandPath expands a leading ~ or ~user and $VAR / ${VAR} references in
+// path. Every path-valued config field goes through here so they all behave
+// the same way. Unset variables are an error rather than silently empty;
+// $$ stands for a literal $, and a $ not followed by a name is kept as is.
+func ExpandPath(path string) (expanded string, err error) {
+	expanded, err = expandTilde(path)
+	if err != nil {
+		return
+	}
+	return expandEnv(expanded)
+}
+
+// expandEnv replaces $NAME and ${NAME} in s with the environment variable.
+func expandEnv(s string) (string, error) {
+	var b strings.Builder
+	var missing []string
+	for i := 0; i < len(s); i++ {
+		if s[i] != '$' {
+			b.WriteByte(s[i])
+			continue
+		}
+		rest := s[i+1:]
+		var name string
+		switch {
+		case strings.HasPrefix(rest, "$"):
+			b.WriteByte('$')
+			i++
+			continue
+		case strings.HasPrefix(rest, "{"):
+			// 只有 ${NAME} 形式才展开，其他原样保留
+			if end := strings.IndexByte(rest, '}'); end > 1 && envNameLen(rest[1:end]) == end-1 {
+				name = rest[1:end]
+				i += end + 1
+			}
+		default:
+			name = rest[:envNameLen(rest)]
+			i += len(name)
+		}
+		if name == "" {
+			b.WriteByte('$')
+			continue
+		}
+		value, ok := os.LookupEnv(name)
+		if !ok {
+			missing = append(missing, name)
+		}
+		b.WriteString(value)
+	}
+	if len(missing) > 0 {
+		return "", fmt.Errorf("environment variable %s is not set", strings.Join(missing, ", "))
+	}
+	return b.String(), nil
+}
+
+// envNameLen returns the length of the variable name s starts with: a
+// letter or underscore followed by letters, digits and underscores.
+func envNameLen(s string) (n int) {
+	for n < len(s) {
+		c := s[n]
+		if c == '_' || 'a' <= c && c <= 'z' || 'A' <= c && c <= 'Z' || n > 0 && '0' <= c && c <= '9' {
+			n++
+			continue
+		}
+		break
+	}
+	return
+}
+
+func isPathSeparator(c byte) bool {
+	return c == '/' || runtime.GOOS == "windows" && c == '\\'
+}
+
+func expandTilde(path string) (string, error) {
+	if !strings.HasPrefix(path, "~") {
+		return path, nil
+	}
+
+	end := 1
+	for end < len(path) && !isPathSeparator(path[end]) {
+		end++
+	}
+	name, rest := path[1:end], path[end:]
+
+	var homeDir string
+	if name == "" {
+		dir, err := getHomeDir()
+		if err != nil {
+			return "", fmt.Errorf("failed to get home directory: %v", err)
+		}
+		homeDir = dir
+	} else {
+		usr, err := user.Lookup(name)
+		if err != nil {
+			return "", fmt.Errorf("cannot expand ~%s: %v", name, err)
+		}
+		homeDir = usr.HomeDir
+	}
+	if rest == "" {
+		return homeDir, nil
+	}
+	return filepath.Join(homeDir, rest[1:]), nil
+}
+
+// expandPath expands a path field of s, naming the field and server in errors.
+func (s *Server) expandPath(field, path string) (string, error) {
+	expanded, err := ExpandPath(path)
+	if err != nil {
+		return "", fmt.Errorf("server %q: %s: %v", s.Alias, field, err)
+	}
+	return expanded, nil
+}
+
+// DefaultConfigFile returns the config file used without -config: the
+// first of config.json, config.yaml, config.yml and config.toml in
+// $XDG_CONFIG_HOME/sshtools (~/.config/sshtools when unset) that exists,
+// else ./config.json if it exists, as before, else the XDG config.json to
+// be created.
+func DefaultConfigFile() string {
+	dir := os.Getenv("XDG_CONFIG_HOME")
+	if !filepath.IsAbs(dir) {
+		// XDG 规范要求绝对路径，相对路径视为未设置
+		home, err := getHomeDir()
+		if err != nil {
+			return "config.json"
+		}
+		dir = filepath.Join(home, ".config")
+	}
+	dir = filepath.Join(dir, "sshtools")
+	for _, name := range []string{"config.json", "config.yaml", "config.yml", "config.toml"} {
+		if _, err := os.Stat(filepath.Join(dir, name)); err == nil {
+			return filepath.Join(dir, name)
+		}
+	}
+	if _, err := os.Stat("config.json"); err == nil {
+		return "config.json"
+	}
+	return filepath.Join(dir, "config.json")
+}
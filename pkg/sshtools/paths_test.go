@@ -0,0 +1,81 @@
+package sshtools
+
+import (
+	"os/user"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+func TestExpandPathTilde(t *testing.T) {
+	home, err := getHomeDir()
+	if err != nil {
+		t.Skipf("no home directory: %v", err)
+	}
+	tests := map[string]string{
+		"~":                 home,
+		"~/.ssh/id_ed25519": filepath.Join(home, ".ssh", "id_ed25519"),
+		"/etc/~/x":          "/etc/~/x",
+		"a~":                "a~",
+	}
+	if runtime.GOOS == "windows" {
+		tests[`~\.ssh\id_ed25519`] = filepath.Join(home, ".ssh", "id_ed25519")
+	}
+	for path, want := range tests {
+		if got, err := ExpandPath(path); err != nil || got != want {
+			t.Errorf("ExpandPath(%q) = %q, %v, want %q", path, got, err, want)
+		}
+	}
+}
+
+func TestExpandPathUser(t *testing.T) {
+	usr, err := user.Current()
+	if err != nil || strings.ContainsAny(usr.Username, `/\`) {
+		t.Skip("no user name usable after ~")
+	}
+	want := filepath.Join(usr.HomeDir, "keys")
+	if got, err := ExpandPath("~" + usr.Username + "/keys"); err != nil || got != want {
+		t.Errorf("ExpandPath(~%s/keys) = %q, %v, want %q", usr.Username, got, err, want)
+	}
+	if _, err = ExpandPath("~no-such-user-here/keys"); err == nil {
+		t.Error("expanded the home directory of a user that does not exist")
+	}
+	// Windows 上反斜杠也分隔用户名
+	if runtime.GOOS == "windows" {
+		if got, err := ExpandPath("~" + usr.Username + `\keys`); err != nil || got != want {
+			t.Errorf(`ExpandPath(~%s\keys) = %q, %v, want %q`, usr.Username, got, err, want)
+		}
+	}
+}
+
+func TestExpandPathEnv(t *testing.T) {
+	t.Setenv("SSHTOOLS_DIR", "/srv/keys")
+	t.Setenv("SSHTOOLS_EMPTY", "")
+	tests := map[string]string{
+		"$SSHTOOLS_DIR/id":         "/srv/keys/id",
+		"${SSHTOOLS_DIR}_old/id":   "/srv/keys_old/id",
+		"$SSHTOOLS_EMPTY/id":       "/id",
+		"/keys/$SSHTOOLS_DIR":      "/keys//srv/keys",
+		"/keys/no-variables":       "/keys/no-variables",
+		"/keys/id$1":               "/keys/id$1",
+		"/keys/$$SSHTOOLS_DIR":     "/keys/$SSHTOOLS_DIR",
+		"/keys/a$$b":               "/keys/a$b",
+		"/keys/cost$":              "/keys/cost$",
+		"/keys/$-x/${}/${a b}/${x": "/keys/$-x/${}/${a b}/${x",
+	}
+	for path, want := range tests {
+		if got, err := ExpandPath(path); err != nil || got != want {
+			t.Errorf("ExpandPath(%q) = %q, %v, want %q", path, got, err, want)
+		}
+	}
+}
+
+func TestExpandPathUnsetEnv(t *testing.T) {
+	for _, path := range []string{"$SSHTOOLS_UNSET/id", "${SSHTOOLS_UNSET}/id", "/keys/$SSHTOOLS_UNSET"} {
+		_, err := ExpandPath(path)
+		if err == nil || !strings.Contains(err.Error(), "SSHTOOLS_UNSET is not set") {
+			t.Errorf("ExpandPath(%q) error = %v, want the unset variable named", path, err)
+		}
+	}
+}
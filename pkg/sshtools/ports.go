@@ -0,0 +1,152 @@
+package sshtools
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/hex"
+	"fmt"
+	"net"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Socket is a listening TCP or UDP socket on the remote host.
+type Socket struct {
+	Proto   string
+	Address string
+	Port    int
+	Process string
+}
+
+// ForwardTarget returns the address to dial through the tunnel to reach the
+// socket; wildcard listeners are reached via loopback.
+func (s Socket) ForwardTarget() string {
+	host := s.Address
+	switch host {
+	case "", "*", "0.0.0.0":
+		host = "127.0.0.1"
+	case "::", "[::]":
+		host = "::1"
+	}
+	return net.JoinHostPort(strings.Trim(host, "[]"), strconv.Itoa(s.Port))
+}
+
+// ListeningSockets lists listening sockets on the remote host, trying ss,
+// then netstat, then parsing /proc/net directly.
+func (c *Client) ListeningSockets() (sockets []Socket, err error) {
+	if out, errs := c.Output("ss -tulnp 2>/dev/null"); errs == nil {
+		return parseSS(out), nil
+	}
+	if out, errs := c.Output("netstat -tulnp 2>/dev/null"); errs == nil {
+		return parseNetstat(out), nil
+	}
+	var found bool
+	for _, proto := range []string{"tcp", "tcp6", "udp", "udp6"} {
+		out, errs := c.Output("cat /proc/net/" + proto)
+		if errs != nil {
+			continue
+		}
+		found = true
+		sockets = append(sockets, parseProcNet(proto, out)...)
+	}
+	if !found {
+		err = fmt.Errorf("could not list listening sockets on %s: ss, netstat and /proc/net are all unavailable to user %q (restricted shell or hardened host?)",
+			c.Server.Alias, c.Server.User)
+	}
+	return
+}
+
+var processNameRe = regexp.MustCompile(`"([^"]+)"`)
+
+func splitHostPort(addr string) (host string, port int, ok bool) {
+	i := strings.LastIndex(addr, ":")
+	if i < 0 {
+		return
+	}
+	port, err := strconv.Atoi(addr[i+1:])
+	if err != nil {
+		return
+	}
+	host = addr[:i]
+	// ss prints interface scoped addresses as 127.0.0.53%lo
+	if j := strings.Index(host, "%"); j >= 0 {
+		host = host[:j]
+	}
+	return strings.Trim(host, "[]"), port, true
+}
+
+func parseSS(out []byte) (sockets []Socket) {
+	scanner := bufio.NewScanner(bytes.NewReader(out))
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 5 || fields[0] == "Netid" {
+			continue
+		}
+		host, port, ok := splitHostPort(fields[4])
+		if !ok {
+			continue
+		}
+		s := Socket{Proto: fields[0], Address: host, Port: port}
+		if len(fields) > 6 {
+			if m := processNameRe.FindStringSubmatch(strings.Join(fields[6:], " ")); m != nil {
+				s.Process = m[1]
+			}
+		}
+		sockets = append(sockets, s)
+	}
+	return
+}
+
+func parseNetstat(out []byte) (sockets []Socket) {
+	scanner := bufio.NewScanner(bytes.NewReader(out))
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 4 || !strings.HasPrefix(fields[0], "tcp") && !strings.HasPrefix(fields[0], "udp") {
+			continue
+		}
+		host, port, ok := splitHostPort(fields[3])
+		if !ok {
+			continue
+		}
+		s := Socket{Proto: strings.TrimSuffix(fields[0], "6"), Address: host, Port: port}
+		if last := fields[len(fields)-1]; strings.Contains(last, "/") {
+			s.Process = last[strings.Index(last, "/")+1:]
+		}
+		sockets = append(sockets, s)
+	}
+	return
+}
+
+// parseProcNet parses /proc/net/{tcp,udp}[6]. Addresses are hex encoded in
+// host byte order per 32-bit word.
+func parseProcNet(proto string, out []byte) (sockets []Socket) {
+	scanner := bufio.NewScanner(bytes.NewReader(out))
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 4 || fields[0] == "sl" {
+			continue
+		}
+		// 0A is TCP_LISTEN; unconnected UDP sockets are in state 07.
+		if strings.HasPrefix(proto, "tcp") && fields[3] != "0A" || strings.HasPrefix(proto, "udp") && fields[3] != "07" {
+			continue
+		}
+		hexAddr, hexPort, ok := strings.Cut(fields[1], ":")
+		if !ok {
+			continue
+		}
+		port, err := strconv.ParseUint(hexPort, 16, 16)
+		if err != nil {
+			continue
+		}
+		raw, err := hex.DecodeString(hexAddr)
+		if err != nil || len(raw)%4 != 0 {
+			continue
+		}
+		for i := 0; i < len(raw); i += 4 {
+			raw[i], raw[i+1], raw[i+2], raw[i+3] = raw[i+3], raw[i+2], raw[i+1], raw[i]
+		}
+		sockets = append(sockets, Socket{Proto: strings.TrimSuffix(proto, "6"), Address: net.IP(raw).String(), Port: int(port)})
+	}
+	return
+}
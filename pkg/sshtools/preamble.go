@@ -0,0 +1,60 @@
+package sshtools
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"io"
+)
+
+// newPreambleMarker returns a line the remote shell prints right before the
+// command runs, unlikely to appear in anything else.
+func newPreambleMarker() string {
+	suffix := make([]byte, 8)
+	_, _ = rand.Read(suffix)
+	return "sshtools-output-" + hex.EncodeToString(suffix)
+}
+
+// markPreamble prefixes command with printing marker on stdout and stderr,
+// so everything the login shell printed before (a MOTD from an rc file,
+// stty complaints) can be told apart from the command's output.
+func markPreamble(command, marker string) string {
+	return "printf '%s\\n' " + marker + "; printf '%s\\n' " + marker + " >&2; " + command
+}
+
+// preambleFilter drops what is written to it up to and including the first
+// marker line, and passes the rest on to w.
+type preambleFilter struct {
+	w      io.Writer
+	marker []byte
+	buf    []byte
+	passed bool
+}
+
+func newPreambleFilter(w io.Writer, marker string) *preambleFilter {
+	return &preambleFilter{w: w, marker: []byte(marker + "\n")}
+}
+
+func (f *preambleFilter) Write(p []byte) (int, error) {
+	if f.passed {
+		return f.w.Write(p)
+	}
+	f.buf = append(f.buf, p...)
+	i := bytes.Index(f.buf, f.marker)
+	if i < 0 {
+		// 只保留可能是标记开头的部分
+		if keep := len(f.marker) - 1; len(f.buf) > keep {
+			f.buf = append(f.buf[:0], f.buf[len(f.buf)-keep:]...)
+		}
+		return len(p), nil
+	}
+	f.passed = true
+	rest := f.buf[i+len(f.marker):]
+	f.buf = nil
+	if len(rest) > 0 {
+		if _, err := f.w.Write(rest); err != nil {
+			return 0, err
+		}
+	}
+	return len(p), nil
+}
@@ -0,0 +1,212 @@
+package sshtools
+
+import (
+	"fmt"
+	"net"
+	"sync"
+	"time"
+)
+
+const (
+	// prewarmTTL is how long a reachability result, and an idle pre-dialed
+	// connection, stays fresh. sshd drops connections that don't log in
+	// within its LoginGraceTime, two minutes by default.
+	prewarmTTL = 30 * time.Second
+	// prewarmParallel bounds the dials running at once.
+	prewarmParallel = 8
+)
+
+// Reachability is what a Prewarmer knows about whether a server accepts
+// TCP connections.
+type Reachability int
+
+const (
+	// ReachUnknown is a server that was not checked, or that cannot be
+	// checked directly, such as one behind a jump host or proxy.
+	ReachUnknown Reachability = iota
+	// ReachPending is a check in progress.
+	ReachPending
+	// ReachUp and ReachDown are the result of the last check.
+	ReachUp
+	ReachDown
+)
+
+// Prewarmer dials servers in the background while the user is still
+// choosing one: Check only finds out whether a server is reachable, Warm
+// also keeps the connection, which the Dialer it is set on as Prewarmed
+// then uses instead of dialing. Only servers reached over plain TCP are
+// dialed. A nil Prewarmer does nothing.
+type Prewarmer struct {
+	// OnChange is called from background goroutines when the reachability
+	// of a server changed.
+	OnChange func()
+
+	dialer *Dialer
+	slots  chan struct{}
+
+	mu      sync.Mutex
+	entries map[*Server]*prewarmEntry
+	stopped bool
+}
+
+type prewarmEntry struct {
+	state   Reachability
+	checked time.Time
+	dialing bool
+	// conn is kept for Warm; key is the address it is connected to.
+	conn net.Conn
+	key  string
+}
+
+// NewPrewarmer returns a Prewarmer dialing like d, without its logging,
+// which would garble the screen the user is choosing on.
+func NewPrewarmer(d *Dialer) *Prewarmer {
+	quiet := *d
+	quiet.Verbose, quiet.Prewarmed = 0, nil
+	return &Prewarmer{dialer: &quiet, slots: make(chan struct{}, prewarmParallel), entries: map[*Server]*prewarmEntry{}}
+}
+
+// prewarmable reports whether server is reached over plain TCP, which is
+// all a Prewarmer dials; proxy commands and Session Manager start
+// processes.
+func prewarmable(server *Server) bool {
+	return len(server.JumpHosts) == 0 && server.ProxyCommand == "" && server.WebSocket == "" && !server.UsesSSM() &&
+		!server.UsesProxy() && server.unresolved == nil
+}
+
+// State returns what is known about the reachability of server.
+func (p *Prewarmer) State(server *Server) Reachability {
+	if p == nil {
+		return ReachUnknown
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if e, ok := p.entries[server]; ok {
+		return e.state
+	}
+	return ReachUnknown
+}
+
+// Check finds out in the background whether server is reachable, unless
+// that is known from less than prewarmTTL ago.
+func (p *Prewarmer) Check(server *Server) {
+	p.start(server, false)
+}
+
+// Warm is Check that also keeps the connection for the dialer.
+func (p *Prewarmer) Warm(server *Server) {
+	p.start(server, true)
+}
+
+func (p *Prewarmer) start(server *Server, keep bool) {
+	if p == nil || !prewarmable(server) {
+		return
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.stopped {
+		return
+	}
+	e, ok := p.entries[server]
+	if !ok {
+		e = &prewarmEntry{}
+		p.entries[server] = e
+	}
+	fresh := time.Since(e.checked) < prewarmTTL
+	if e.dialing || fresh && (!keep || e.conn != nil || e.state == ReachDown) {
+		return
+	}
+	if e.conn != nil {
+		_ = e.conn.Close()
+		e.conn = nil
+	}
+	e.dialing = true
+	if e.state == ReachUnknown {
+		e.state = ReachPending
+	}
+	go p.dial(server, keep)
+}
+
+// dial connects to server and records the result.
+func (p *Prewarmer) dial(server *Server, keep bool) {
+	p.slots <- struct{}{}
+	defer func() { <-p.slots }()
+
+	d := p.dialer
+	var conn net.Conn
+	var key string
+	cands, _ := d.candidates(server)
+	if len(cands) > 0 {
+		var winner dialCandidate
+		conn, winner, _, _ = d.connectFirst(server, cands, d.addressTimeout(server, len(cands)), nil)
+		key = prewarmKey(winner, server.Port)
+	}
+
+	p.mu.Lock()
+	e := p.entries[server]
+	e.dialing, e.checked = false, time.Now()
+	before := e.state
+	if conn != nil {
+		e.state = ReachUp
+	} else {
+		e.state = ReachDown
+	}
+	if conn != nil && keep && !p.stopped {
+		e.conn, e.key = conn, key
+	} else if conn != nil {
+		_ = conn.Close()
+	}
+	changed := e.state != before && !p.stopped
+	p.mu.Unlock()
+	if changed && p.OnChange != nil {
+		p.OnChange()
+	}
+}
+
+// prewarmKey is the address dialAt connects to for cand.
+func prewarmKey(cand dialCandidate, port int) string {
+	host := cand.address
+	if cand.ip != "" {
+		host = cand.ip
+	}
+	return net.JoinHostPort(host, fmt.Sprint(port))
+}
+
+// Keep stops checking and closes the connections of all servers but
+// server, which stays ready for the dialer.
+func (p *Prewarmer) Keep(server *Server) {
+	if p == nil {
+		return
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.stopped = true
+	for s, e := range p.entries {
+		if s != server && e.conn != nil {
+			_ = e.conn.Close()
+			e.conn = nil
+		}
+	}
+}
+
+// take hands over a connection kept to key, the host:port dialed, if it
+// is still fresh.
+func (p *Prewarmer) take(key string) net.Conn {
+	if p == nil {
+		return nil
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for _, e := range p.entries {
+		if e.conn == nil || e.key != key {
+			continue
+		}
+		conn := e.conn
+		e.conn = nil
+		if time.Since(e.checked) < prewarmTTL {
+			return conn
+		}
+		_ = conn.Close()
+	}
+	return nil
+}
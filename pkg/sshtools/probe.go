@@ -0,0 +1,132 @@
+package sshtools
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// DefaultTimeout bounds the TCP connect and the SSH handshake when neither
+// the dialer nor the server has a timeout of its own.
+const DefaultTimeout = 15 * time.Second
+
+// timeout returns the connect/handshake timeout for server: the dialer's,
+// its connect_timeout or DefaultTimeout.
+func (d *Dialer) timeout(server *Server) time.Duration {
+	if d.Timeout > 0 {
+		return d.Timeout
+	}
+	if server.ConnectTimeout != "" {
+		if timeout, err := time.ParseDuration(server.ConnectTimeout); err == nil && timeout > 0 {
+			return timeout
+		}
+	}
+	return DefaultTimeout
+}
+
+// dialTCP opens the transport to server: its ProxyCommand or a TCP
+// connection bounded by the dialer's timeout. Servers with several
+// addresses use the first that connects (see connectFirst).
+func (d *Dialer) dialTCP(server *Server) (conn net.Conn, err error) {
+	var via *Client
+	if len(server.JumpHosts) > 0 {
+		if via, err = d.dialJumps(server); err != nil {
+			return
+		}
+		defer func() {
+			if err != nil {
+				_ = via.Close()
+			} else {
+				conn = &jumpConn{Conn: conn, via: via}
+			}
+		}()
+	}
+	cands, failures := d.candidates(server)
+	if len(cands) == 1 && len(failures) == 0 {
+		return d.dialAt(server, cands[0], d.timeout(server), via)
+	}
+	kinds := make([]ErrorKind, len(failures))
+	conn, _, _, failed := d.connectFirst(server, cands, d.addressTimeout(server, len(cands)), via)
+	if conn != nil {
+		return
+	}
+	for _, f := range failed {
+		failures = append(failures, fmt.Sprintf("%s: %v", f.cand, f.err))
+		kinds = append(kinds, KindOf(f.err))
+	}
+	return nil, allFailed(server, failures, kinds)
+}
+
+// Probe results that tell network problems apart from sshd problems.
+var (
+	ErrRefused     = errors.New("connection refused (host is up, nothing listening)")
+	ErrUnreachable = errors.New("no route to host (network problem or host down)")
+	ErrTimeout     = errors.New("connect timed out (host down or filtered)")
+	ErrNoBanner    = errors.New("banner never received (sshd hung or not an SSH server)")
+)
+
+// ProbeResult describes an SSH server that answered a Probe.
+type ProbeResult struct {
+	Connect time.Duration
+	Banner  time.Duration
+	Version string
+}
+
+// Probe connects to server and reads its SSH version banner without
+// authenticating. Failures wrap one of ErrRefused, ErrUnreachable,
+// ErrTimeout or ErrNoBanner where the cause is known.
+func (d *Dialer) Probe(server *Server) (res *ProbeResult, err error) {
+	start := time.Now()
+	conn, err := d.dialTCP(server)
+	if err != nil {
+		err = fmt.Errorf("%s: %w", server.Addr(), classifyDialError(err))
+		return
+	}
+	defer func() { _ = conn.Close() }()
+	res = &ProbeResult{Connect: time.Since(start)}
+
+	// Bound the banner read; proxy connections ignore deadlines, so close
+	// the connection instead.
+	timer := time.AfterFunc(d.timeout(server), func() { _ = conn.Close() })
+	defer timer.Stop()
+	version, err := readVersion(bufio.NewReader(conn))
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", server.Addr(), err)
+	}
+	res.Banner = time.Since(start)
+	res.Version = version
+	return
+}
+
+// readVersion reads the version line of an SSH server. Servers may send
+// other lines before it (RFC 4253 4.2).
+func readVersion(reader *bufio.Reader) (version string, err error) {
+	for {
+		line, errs := reader.ReadString('\n')
+		if errs != nil {
+			return "", ErrNoBanner
+		}
+		if strings.HasPrefix(line, "SSH-") {
+			return strings.TrimRight(line, "\r\n"), nil
+		}
+	}
+}
+
+// classifyDialError maps connect errors to the probe errors.
+func classifyDialError(err error) error {
+	var netErr net.Error
+	switch {
+	case errors.Is(err, syscall.ECONNREFUSED):
+		return ErrRefused
+	case errors.Is(err, syscall.EHOSTUNREACH), errors.Is(err, syscall.ENETUNREACH):
+		return ErrUnreachable
+	case errors.Is(err, os.ErrDeadlineExceeded), errors.As(err, &netErr) && netErr.Timeout():
+		return ErrTimeout
+	}
+	return err
+}
@@ -0,0 +1,147 @@
+package sshtools
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"os/exec"
+	"runtime"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// proxyConn is a net.Conn backed by the stdin/stdout of a ProxyCommand child
+// process. Closing it kills and reaps the child.
+type proxyConn struct {
+	command string
+	cmd     *exec.Cmd
+	stdin   io.WriteCloser
+	stdout  io.ReadCloser
+
+	done    chan struct{}
+	waitErr error
+}
+
+type proxyAddr string
+
+func (a proxyAddr) Network() string { return "proxy" }
+func (a proxyAddr) String() string  { return string(a) }
+
+// expandProxyCommand substitutes the OpenSSH style %h, %p, %r, %n and %%
+// tokens; %n is the alias.
+func expandProxyCommand(command string, server *Server) string {
+	var b strings.Builder
+	for i := 0; i < len(command); i++ {
+		if command[i] != '%' || i+1 == len(command) {
+			b.WriteByte(command[i])
+			continue
+		}
+		i++
+		switch command[i] {
+		case 'h':
+			b.WriteString(server.Address)
+		case 'p':
+			b.WriteString(strconv.Itoa(server.Port))
+		case 'r':
+			b.WriteString(server.User)
+		case 'n':
+			b.WriteString(server.Alias)
+		case '%':
+			b.WriteByte('%')
+		default:
+			b.WriteByte('%')
+			b.WriteByte(command[i])
+		}
+	}
+	return b.String()
+}
+
+// dialProxyCommand starts the server's proxy_command and returns a
+// connection speaking over its stdio. The child's stderr is passed through
+// to ours so connection problems can be debugged.
+func dialProxyCommand(server *Server) (conn *proxyConn, err error) {
+	command := expandProxyCommand(server.ProxyCommand, server)
+
+	var cmd *exec.Cmd
+	if runtime.GOOS == "windows" {
+		cmd = exec.Command("cmd", "/C", command)
+	} else {
+		cmd = exec.Command("sh", "-c", "exec "+command)
+	}
+	return startProxy(command, cmd)
+}
+
+// startProxy starts cmd and returns a connection speaking over its stdio,
+// named command in errors.
+func startProxy(command string, cmd *exec.Cmd) (conn *proxyConn, err error) {
+	// 自己创建管道：StdoutPipe 的读端会在 Wait 时关闭，而 Wait 在传输层
+	// 读完代理的输出之前就已开始，最后的数据可能丢失
+	stdinR, stdinW, err := os.Pipe()
+	if err != nil {
+		return nil, fmt.Errorf("failed to create pipe for proxy command %q: %v", command, err)
+	}
+	stdoutR, stdoutW, err := os.Pipe()
+	if err != nil {
+		_ = stdinR.Close()
+		_ = stdinW.Close()
+		return nil, fmt.Errorf("failed to create pipe for proxy command %q: %v", command, err)
+	}
+	cmd.Stdin, cmd.Stdout, cmd.Stderr = stdinR, stdoutW, os.Stderr
+	err = cmd.Start()
+	// 子进程持有自己的一端，父进程关闭后 EOF 才能传到另一端
+	_ = stdinR.Close()
+	_ = stdoutW.Close()
+	if err != nil {
+		_ = stdinW.Close()
+		_ = stdoutR.Close()
+		return nil, fmt.Errorf("failed to start proxy command %q: %v", command, err)
+	}
+	conn = &proxyConn{command: command, cmd: cmd, stdin: stdinW, stdout: stdoutR, done: make(chan struct{})}
+	go func() {
+		conn.waitErr = cmd.Wait()
+		close(conn.done)
+	}()
+	return
+}
+
+// exitError returns an error naming the proxy command if it has exited (or
+// exits within a short grace period) with a failure status.
+func (c *proxyConn) exitError() error {
+	select {
+	case <-c.done:
+	case <-time.After(500 * time.Millisecond):
+		return nil
+	}
+	if c.waitErr == nil {
+		return fmt.Errorf("proxy command %q exited before the SSH handshake completed", c.command)
+	}
+	return fmt.Errorf("proxy command %q failed: %v", c.command, c.waitErr)
+}
+
+func (c *proxyConn) Read(b []byte) (int, error)  { return c.stdout.Read(b) }
+func (c *proxyConn) Write(b []byte) (int, error) { return c.stdin.Write(b) }
+
+func (c *proxyConn) Close() error {
+	// 读端由我们创建，Wait 不会关闭它
+	defer func() { _ = c.stdout.Close() }()
+	_ = c.stdin.Close()
+	select {
+	case <-c.done:
+		return nil
+	case <-time.After(time.Second):
+	}
+	if c.cmd.Process != nil {
+		_ = c.cmd.Process.Kill()
+	}
+	<-c.done
+	return nil
+}
+
+func (c *proxyConn) LocalAddr() net.Addr  { return proxyAddr("local") }
+func (c *proxyConn) RemoteAddr() net.Addr { return proxyAddr(c.command) }
+
+func (c *proxyConn) SetDeadline(t time.Time) error      { return nil }
+func (c *proxyConn) SetReadDeadline(t time.Time) error  { return nil }
+func (c *proxyConn) SetWriteDeadline(t time.Time) error { return nil }
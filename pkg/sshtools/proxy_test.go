@@ -0,0 +1,36 @@
+package sshtools
+
+import (
+	"io"
+	"os/exec"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+// The whole output of a proxy command that exits at once must arrive,
+// however soon Wait reaps it. The output fits in the pipe buffer, so the
+// command is done before anything is read.
+func TestProxyReadsOutputAfterExit(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("needs sh")
+	}
+	want := strings.Repeat("SSH-2.0-test\n", 1000)
+	for range 20 {
+		conn, err := startProxy("test", exec.Command("sh", "-c", "yes SSH-2.0-test | head -n 1000"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		<-conn.done
+		got, err := io.ReadAll(conn)
+		if err != nil {
+			t.Fatalf("reading after the proxy exited: %v", err)
+		}
+		if string(got) != want {
+			t.Fatalf("read %d bytes, want %d", len(got), len(want))
+		}
+		if err = conn.Close(); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
@@ -0,0 +1,82 @@
+package sshtools
+
+import (
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// defaultTermType is sent when neither the server nor the local
+// environment sets TERM.
+const defaultTermType = "xterm-256color"
+
+// terminalModeNames maps the names of terminal_modes to their opcodes
+// (RFC 4254 8). BAUD sets both speeds.
+var terminalModeNames = map[string]uint8{
+	"VINTR": ssh.VINTR, "VQUIT": ssh.VQUIT, "VERASE": ssh.VERASE, "VKILL": ssh.VKILL, "VEOF": ssh.VEOF,
+	"VEOL": ssh.VEOL, "VEOL2": ssh.VEOL2, "VSTART": ssh.VSTART, "VSTOP": ssh.VSTOP, "VSUSP": ssh.VSUSP,
+	"VDSUSP": ssh.VDSUSP, "VREPRINT": ssh.VREPRINT, "VWERASE": ssh.VWERASE, "VLNEXT": ssh.VLNEXT,
+	"VFLUSH": ssh.VFLUSH, "VSWTCH": ssh.VSWTCH, "VSTATUS": ssh.VSTATUS, "VDISCARD": ssh.VDISCARD,
+	"IGNPAR": ssh.IGNPAR, "PARMRK": ssh.PARMRK, "INPCK": ssh.INPCK, "ISTRIP": ssh.ISTRIP, "INLCR": ssh.INLCR,
+	"IGNCR": ssh.IGNCR, "ICRNL": ssh.ICRNL, "IUCLC": ssh.IUCLC, "IXON": ssh.IXON, "IXANY": ssh.IXANY,
+	"IXOFF": ssh.IXOFF, "IMAXBEL": ssh.IMAXBEL, "IUTF8": ssh.IUTF8, "ISIG": ssh.ISIG, "ICANON": ssh.ICANON,
+	"XCASE": ssh.XCASE, "ECHO": ssh.ECHO, "ECHOE": ssh.ECHOE, "ECHOK": ssh.ECHOK, "ECHONL": ssh.ECHONL,
+	"NOFLSH": ssh.NOFLSH, "TOSTOP": ssh.TOSTOP, "IEXTEN": ssh.IEXTEN, "ECHOCTL": ssh.ECHOCTL,
+	"ECHOKE": ssh.ECHOKE, "PENDIN": ssh.PENDIN, "OPOST": ssh.OPOST, "OLCUC": ssh.OLCUC, "ONLCR": ssh.ONLCR,
+	"OCRNL": ssh.OCRNL, "ONOCR": ssh.ONOCR, "ONLRET": ssh.ONLRET, "CS7": ssh.CS7, "CS8": ssh.CS8,
+	"PARENB": ssh.PARENB, "PARODD": ssh.PARODD, "TTY_OP_ISPEED": ssh.TTY_OP_ISPEED,
+	"TTY_OP_OSPEED": ssh.TTY_OP_OSPEED,
+}
+
+// checkTerminalModes validates terminal_modes.
+func checkTerminalModes(modes map[string]uint32) (msgs []string) {
+	for name := range modes {
+		upper := strings.ToUpper(name)
+		if _, ok := terminalModeNames[upper]; !ok && upper != "BAUD" {
+			names := []string{"BAUD"}
+			for known := range terminalModeNames {
+				names = append(names, known)
+			}
+			slices.Sort(names)
+			msgs = append(msgs, fmt.Sprintf(`"terminal_modes" has unknown mode %q, known: %s`, name, strings.Join(names, ", ")))
+		}
+	}
+	return
+}
+
+// PTYModes returns the terminal modes to request a PTY with for server, as
+// set by its terminal_modes. Speeds given on their own win over BAUD.
+func (s *Server) PTYModes() ssh.TerminalModes {
+	modes := ssh.TerminalModes{}
+	for name, value := range s.TerminalModes {
+		if strings.EqualFold(name, "BAUD") {
+			modes[ssh.TTY_OP_ISPEED], modes[ssh.TTY_OP_OSPEED] = value, value
+		}
+	}
+	for name, value := range s.TerminalModes {
+		if opcode, ok := terminalModeNames[strings.ToUpper(name)]; ok {
+			modes[opcode] = value
+		}
+	}
+	return modes
+}
+
+// TermType returns the TERM to request a PTY with for server: its term,
+// the local TERM or xterm-256color.
+func (s *Server) TermType() string {
+	if s.Term != "" {
+		return s.Term
+	}
+	return localTermType()
+}
+
+// localTermType is the local TERM, or xterm-256color when it is not set.
+func localTermType() string {
+	if termType := os.Getenv("TERM"); termType != "" {
+		return termType
+	}
+	return defaultTermType
+}
@@ -0,0 +1,317 @@
+package sshtools
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// Push statuses recorded per host.
+const (
+	PushOK         = "ok"
+	PushFailed     = "failed"
+	PushRolledBack = "rolled_back"
+)
+
+// PushOptions describes a file distribution run.
+type PushOptions struct {
+	// Dest is the absolute remote path to install the file at.
+	Dest string
+	// Backup is the remote path the original is moved to; it must be in
+	// the same directory as Dest.
+	Backup string
+	// Sudo runs the install steps through sudo -n.
+	Sudo bool
+	// ValidateCmd gates the install. When it contains {} it is run against
+	// the staged file before the move, otherwise after the move with the
+	// original restored if it fails.
+	ValidateCmd string
+	// Relay, when set, copies the file to the server from a staging server
+	// instead of uploading it from here.
+	Relay *PushRelay
+}
+
+// PushHost is the outcome of a push on one server.
+type PushHost struct {
+	Alias   string `json:"alias"`
+	Address string `json:"address"`
+	Status  string `json:"status"`
+	// Existed is set when Dest existed and was backed up to Backup.
+	Existed bool   `json:"existed"`
+	Backup  string `json:"backup,omitempty"`
+	Error   string `json:"error,omitempty"`
+}
+
+// PushRun is the locally persisted record of a push, used for rollback.
+type PushRun struct {
+	ID          string     `json:"id"`
+	Source      string     `json:"source"`
+	Dest        string     `json:"dest"`
+	SHA256      string     `json:"sha256"`
+	Sudo        bool       `json:"sudo"`
+	ValidateCmd string     `json:"validate_cmd,omitempty"`
+	Via         string     `json:"via,omitempty"`
+	Started     time.Time  `json:"started"`
+	Hosts       []PushHost `json:"hosts"`
+}
+
+// NewRunID returns an identifier for a run started at t. A random suffix
+// keeps runs started within the same second apart.
+func NewRunID(t time.Time) string {
+	suffix := make([]byte, 2)
+	_, _ = rand.Read(suffix)
+	return t.Format("20060102-150405") + "-" + hex.EncodeToString(suffix)
+}
+
+// BackupPath returns where the original of dest is kept for run id.
+func BackupPath(dest, id string) string {
+	return dest + ".sshtools-" + id
+}
+
+// ShellQuote quotes s for a POSIX shell.
+func ShellQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
+
+func runPath(id string) (string, error) {
+	dir, err := StateDir("runs")
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, id+".json"), nil
+}
+
+// Save writes the run record to ~/.sshtools/runs/<id>.json.
+func (r *PushRun) Save() (err error) {
+	file, err := runPath(r.ID)
+	if err != nil {
+		return
+	}
+	data, err := json.MarshalIndent(r, "", "  ")
+	if err != nil {
+		return
+	}
+	if err = os.WriteFile(file, data, 0o600); err != nil {
+		err = fmt.Errorf("failed to save run %s: %v", r.ID, err)
+	}
+	return
+}
+
+// LoadRun reads a run record saved by Save.
+func LoadRun(id string) (run *PushRun, err error) {
+	file, err := runPath(id)
+	if err != nil {
+		return
+	}
+	data, err := os.ReadFile(file)
+	if err != nil {
+		err = fmt.Errorf("unknown run %s: %v", id, err)
+		return
+	}
+	run = &PushRun{}
+	err = json.Unmarshal(data, run)
+	return
+}
+
+// SHA256 returns the hex encoded checksum of data.
+func SHA256(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// privileged wraps script to run through sudo when requested.
+func privileged(script string, sudo bool) string {
+	if sudo {
+		return "sudo -n sh -c " + ShellQuote(script)
+	}
+	return "sh -c " + ShellQuote(script)
+}
+
+// runScript runs script and folds its stderr into the error.
+func (c *Client) runScript(script string, sudo bool) (out string, err error) {
+	var stdout, stderr bytes.Buffer
+	err = c.Run(privileged(script, sudo), &stdout, &stderr)
+	if err != nil {
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			err = fmt.Errorf("%v: %s", err, msg)
+		}
+	}
+	return strings.TrimSpace(stdout.String()), err
+}
+
+// uploadTemp uploads data to a new file in /tmp readable only by the login
+// user and returns its path.
+func (c *Client) uploadTemp(data []byte) (tmp string, err error) {
+	session, err := c.NewSession()
+	if err != nil {
+		return
+	}
+	var stdout, stderr bytes.Buffer
+	session.Stdin = bytes.NewReader(data)
+	session.Stdout, session.Stderr = &stdout, &stderr
+	err = session.Run(`umask 077; tmp=$(mktemp /tmp/.sshtools-push.XXXXXX) && cat > "$tmp" && echo "$tmp"`)
+	_ = session.Close()
+	if err != nil {
+		return "", fmt.Errorf("upload failed: %v %s", err, strings.TrimSpace(stderr.String()))
+	}
+	return strings.TrimSpace(stdout.String()), nil
+}
+
+// verifyTemp checks that the file at tmp has the checksum sum.
+func (c *Client) verifyTemp(tmp, sum string) error {
+	out, err := c.runScript("sha256sum "+ShellQuote(tmp)+" 2>/dev/null || shasum -a 256 "+ShellQuote(tmp), false)
+	if err != nil {
+		return fmt.Errorf("checksum failed: %v", err)
+	}
+	if got := strings.Fields(out + " "); got[0] != sum {
+		return fmt.Errorf("checksum mismatch after upload: got %s", got[0])
+	}
+	return nil
+}
+
+// PushRelay holds a file uploaded once to a staging server, which copies
+// it on to the targets of a push over its own ssh connections, so the
+// file crosses the local uplink only once. The staging server's ssh must
+// be able to log in to the targets without prompting, e.g. through the
+// local ssh-agent forwarded with the client's ForwardAgent.
+type PushRelay struct {
+	client *Client
+	tmp    string
+}
+
+// NewPushRelay uploads data to the server of c and verifies it there.
+func (c *Client) NewPushRelay(data []byte) (relay *PushRelay, err error) {
+	tmp, err := c.uploadTemp(data)
+	if err != nil {
+		return
+	}
+	if err = c.verifyTemp(tmp, SHA256(data)); err != nil {
+		_, _ = c.runScript("rm -f "+ShellQuote(tmp), false)
+		return
+	}
+	return &PushRelay{client: c, tmp: tmp}, nil
+}
+
+// send copies the staged file to a new temporary file on target and
+// returns its path there.
+func (r *PushRelay) send(target *Server) (tmp string, err error) {
+	remote := `umask 077; tmp=$(mktemp /tmp/.sshtools-push.XXXXXX) && cat > "$tmp" && echo "$tmp"`
+	command := fmt.Sprintf("ssh -o BatchMode=yes -o StrictHostKeyChecking=accept-new -p %d %s %s < %s",
+		target.Port, ShellQuote(target.User+"@"+target.Address), ShellQuote(remote), ShellQuote(r.tmp))
+	session, command, err := r.client.NewUserSession(command)
+	if err != nil {
+		return
+	}
+	defer func() { _ = session.Close() }()
+	var stdout, stderr bytes.Buffer
+	session.Stdout, session.Stderr = &stdout, &stderr
+	if err = session.Run(command); err != nil {
+		return "", fmt.Errorf("relay from %s failed: %v %s", r.client.Server.Alias, err, strings.TrimSpace(stderr.String()))
+	}
+	return strings.TrimSpace(stdout.String()), nil
+}
+
+// Close removes the staged file.
+func (r *PushRelay) Close() (err error) {
+	_, err = r.client.runScript("rm -f "+ShellQuote(r.tmp), false)
+	return
+}
+
+// PushFile installs data at opts.Dest. The file is uploaded (or relayed)
+// to a temporary path, its checksum verified, staged next to the destination and moved
+// into place with a rename, so the host ends up either fully updated or
+// untouched.
+func (c *Client) PushFile(data []byte, opts PushOptions) (host PushHost) {
+	host = PushHost{Alias: c.Server.Alias, Address: c.Server.Address, Status: PushFailed}
+	if err := c.pushFile(data, opts, &host); err != nil {
+		host.Error = err.Error()
+		return
+	}
+	host.Status = PushOK
+	return
+}
+
+func (c *Client) pushFile(data []byte, opts PushOptions, host *PushHost) (err error) {
+	// 上传到临时文件，或由中转服务器复制过去
+	var tmp string
+	if opts.Relay != nil {
+		tmp, err = opts.Relay.send(c.Server)
+	} else {
+		tmp, err = c.uploadTemp(data)
+	}
+	if err != nil {
+		return
+	}
+	defer func() {
+		_, _ = c.runScript("rm -f "+ShellQuote(tmp), false)
+	}()
+
+	// 校验
+	if err = c.verifyTemp(tmp, SHA256(data)); err != nil {
+		return
+	}
+	var out string
+
+	dest, backup := ShellQuote(opts.Dest), ShellQuote(opts.Backup)
+	stage, err := c.runScript(fmt.Sprintf(`set -e
+stage=$(mktemp %s)
+cp %s "$stage"
+if [ -e %s ]; then chmod --reference=%s "$stage" 2>/dev/null || true; chown --reference=%s "$stage" 2>/dev/null || true; else chmod 644 "$stage"; fi
+echo "$stage"`, ShellQuote(path.Join(path.Dir(opts.Dest), "."+path.Base(opts.Dest)+".sshtools.XXXXXX")), ShellQuote(tmp), dest, dest, dest), opts.Sudo)
+	if err != nil {
+		return fmt.Errorf("staging failed: %v", err)
+	}
+	cleanup := func() {
+		_, _ = c.runScript("rm -f "+ShellQuote(stage), opts.Sudo)
+	}
+
+	validate := opts.ValidateCmd
+	if strings.Contains(validate, "{}") {
+		if _, err = c.runScript(strings.ReplaceAll(validate, "{}", ShellQuote(stage)), opts.Sudo); err != nil {
+			cleanup()
+			return fmt.Errorf("validation failed: %v", err)
+		}
+		validate = ""
+	}
+
+	out, err = c.runScript(fmt.Sprintf(`set -e
+if [ -e %s ]; then cp -p %s %s; echo existed; fi
+mv -f %s %s`, dest, dest, backup, ShellQuote(stage), dest), opts.Sudo)
+	if err != nil {
+		cleanup()
+		return fmt.Errorf("install failed: %v", err)
+	}
+	host.Existed = out == "existed"
+	if host.Existed {
+		host.Backup = opts.Backup
+	}
+
+	if validate != "" {
+		if _, err = c.runScript(validate, opts.Sudo); err != nil {
+			if errs := c.RollbackFile(*host, opts.Dest, opts.Sudo); errs != nil {
+				return fmt.Errorf("validation failed: %v; restoring the original also failed: %v", err, errs)
+			}
+			return fmt.Errorf("validation failed, original restored: %v", err)
+		}
+	}
+	return
+}
+
+// RollbackFile restores dest from the backup recorded in host, or removes
+// it if it did not exist before the push.
+func (c *Client) RollbackFile(host PushHost, dest string, sudo bool) (err error) {
+	script := "rm -f " + ShellQuote(dest)
+	if host.Existed {
+		script = "mv -f " + ShellQuote(host.Backup) + " " + ShellQuote(dest)
+	}
+	_, err = c.runScript(script, sudo)
+	return
+}
@@ -0,0 +1,258 @@
+package sshtools
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"net/url"
+	"os"
+	"os/user"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode/utf16"
+)
+
+// PuTTYSessionsDir is where PuTTY on Linux and macOS saves its sessions,
+// one file per session.
+const PuTTYSessionsDir = "~/.putty/sessions"
+
+// puttyRegistryKey is the registry key holding PuTTY's saved sessions, one
+// subkey per session.
+const puttyRegistryKey = `Software\SimonTatham\PuTTY\Sessions`
+
+// puttySessions maps session names to their settings, like "HostName".
+type puttySessions map[string]map[string]string
+
+// ImportPuTTY returns a server for every saved SSH session of PuTTY, read
+// from source: a .reg file exported from the registry, a directory like
+// ~/.putty/sessions, or, when empty, where the local PuTTY keeps them (the
+// registry on Windows). Each server takes the session's HostName,
+// PortNumber, UserName and PublicKeyFile. Sessions for other protocols or
+// without a host are skipped, with a note saying so; notes also point out
+// keys in PuTTY's own format.
+func ImportPuTTY(source string) (servers []Server, notes []string, err error) {
+	var sessions puttySessions
+	switch {
+	case source == "":
+		sessions, err = localPuTTYSessions()
+	default:
+		if source, err = ExpandPath(source); err != nil {
+			return
+		}
+		info, errs := os.Stat(source)
+		if errs != nil {
+			return nil, nil, errs
+		}
+		if info.IsDir() {
+			sessions, err = readPuTTYDir(source)
+		} else {
+			sessions, err = readPuTTYReg(source)
+		}
+	}
+	if err != nil {
+		return
+	}
+
+	names := make([]string, 0, len(sessions))
+	for name := range sessions {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		server, sessionNotes := puttyServer(name, sessions[name])
+		for _, note := range sessionNotes {
+			notes = append(notes, fmt.Sprintf("%s: %s", name, note))
+		}
+		if server != nil {
+			servers = append(servers, *server)
+		}
+	}
+	return
+}
+
+// puttyServer converts one session; server is nil when it was skipped.
+func puttyServer(name string, settings map[string]string) (server *Server, notes []string) {
+	if protocol := settings["Protocol"]; protocol != "" && protocol != "ssh" {
+		return nil, []string{fmt.Sprintf("skipped, uses %s rather than ssh", protocol)}
+	}
+	host := strings.TrimSpace(settings["HostName"])
+	if host == "" {
+		if name == "Default Settings" {
+			return nil, nil
+		}
+		return nil, []string{"skipped, no host name"}
+	}
+
+	// 别名不能含空格，会话名中的空格替换为 -
+	alias := strings.Join(strings.Fields(name), "-")
+	server = &Server{Alias: alias, Port: defaultPort, User: settings["UserName"]}
+	// HostName 可以写成 user@host
+	if name, address, ok := strings.Cut(host, "@"); ok {
+		if server.User == "" {
+			server.User = name
+		}
+		host = address
+	}
+	server.Address = host
+	if port, err := strconv.Atoi(settings["PortNumber"]); err == nil && port > 0 {
+		server.Port = port
+	}
+	if key := settings["PublicKeyFile"]; key != "" {
+		server.PrivateKey, server.UseKey = key, true
+		if strings.EqualFold(filepath.Ext(key), ".ppk") {
+			notes = append(notes, fmt.Sprintf("%s is a PuTTY key, convert it with puttygen %s -O private-openssh -o <file> and point private_key at that file",
+				key, key))
+		}
+	}
+	if server.User == "" {
+		// 与 PuTTY 相同，没有用户名时使用本机用户名
+		local, err := user.Current()
+		if err != nil {
+			return nil, []string{fmt.Sprintf("skipped, no user name in the session: %v", err)}
+		}
+		server.User = local.Username
+		notes = append(notes, fmt.Sprintf("no user name in the session, using %s", server.User))
+	}
+	return
+}
+
+// readPuTTYReg reads the sessions of a registry export, as written by
+// regedit in UTF-16 or in the older ANSI format.
+func readPuTTYReg(filename string) (sessions puttySessions, err error) {
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return
+	}
+	text := decodeRegFile(data)
+	if !strings.HasPrefix(text, "Windows Registry Editor") && !strings.HasPrefix(text, "REGEDIT4") {
+		return nil, fmt.Errorf("%s is not a registry export", filename)
+	}
+
+	sessions = puttySessions{}
+	var current map[string]string
+	var line string
+	scanner := bufio.NewScanner(strings.NewReader(text))
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		// 十六进制值可以用行尾的 \ 续行
+		part := strings.TrimSpace(scanner.Text())
+		if strings.HasSuffix(part, `\`) && !strings.HasPrefix(part, "[") {
+			line += strings.TrimSuffix(part, `\`)
+			continue
+		}
+		line, part = "", line+part
+
+		switch {
+		case strings.HasPrefix(part, "[") && strings.HasSuffix(part, "]"):
+			current = nil
+			key := strings.TrimSuffix(strings.TrimPrefix(part, "["), "]")
+			// 只看 ...\PuTTY\Sessions\<会话> 这一层
+			_, rest, ok := strings.Cut(strings.ToLower(key), strings.ToLower(puttyRegistryKey)+`\`)
+			if !ok || rest == "" || strings.Contains(rest, `\`) {
+				continue
+			}
+			name := puttySessionName(key[len(key)-len(rest):])
+			current = map[string]string{}
+			sessions[name] = current
+		case current != nil && strings.HasPrefix(part, `"`):
+			if name, value, ok := parseRegValue(part); ok {
+				current[name] = value
+			}
+		}
+	}
+	return sessions, scanner.Err()
+}
+
+// decodeRegFile returns the text of a .reg file, which regedit writes in
+// UTF-16LE with a byte order mark.
+func decodeRegFile(data []byte) string {
+	if !bytes.HasPrefix(data, []byte{0xff, 0xfe}) {
+		return strings.TrimPrefix(string(data), "\uFEFF")
+	}
+	data = data[2:]
+	units := make([]uint16, len(data)/2)
+	for i := range units {
+		units[i] = uint16(data[2*i]) | uint16(data[2*i+1])<<8
+	}
+	return string(utf16.Decode(units))
+}
+
+// parseRegValue parses a "name"="string" or "name"=dword:hex line; dwords
+// are returned in decimal. Other types are not needed and not parsed.
+func parseRegValue(line string) (name, value string, ok bool) {
+	name, rest, ok := cutRegString(line)
+	if !ok || !strings.HasPrefix(rest, "=") {
+		return "", "", false
+	}
+	rest = strings.TrimPrefix(rest, "=")
+	switch {
+	case strings.HasPrefix(rest, `"`):
+		value, _, ok = cutRegString(rest)
+		return name, value, ok
+	case strings.HasPrefix(strings.ToLower(rest), "dword:"):
+		n, err := strconv.ParseUint(rest[len("dword:"):], 16, 32)
+		if err != nil {
+			return "", "", false
+		}
+		return name, strconv.FormatUint(n, 10), true
+	}
+	return "", "", false
+}
+
+// cutRegString unquotes the string at the start of s, which escapes \ and
+// " with a backslash, and returns what follows it.
+func cutRegString(s string) (value, rest string, ok bool) {
+	var b strings.Builder
+	for i := 1; i < len(s); i++ {
+		switch s[i] {
+		case '\\':
+			if i+1 < len(s) {
+				i++
+				b.WriteByte(s[i])
+			}
+		case '"':
+			return b.String(), s[i+1:], true
+		default:
+			b.WriteByte(s[i])
+		}
+	}
+	return "", "", false
+}
+
+// readPuTTYDir reads the session files of Unix PuTTY, whose lines are
+// Name=value.
+func readPuTTYDir(dir string) (sessions puttySessions, err error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return
+	}
+	sessions = puttySessions{}
+	for _, entry := range entries {
+		if entry.IsDir() {
+			continue
+		}
+		data, errs := os.ReadFile(filepath.Join(dir, entry.Name()))
+		if errs != nil {
+			return nil, errs
+		}
+		settings := map[string]string{}
+		for _, line := range strings.Split(string(data), "\n") {
+			if name, value, ok := strings.Cut(strings.TrimRight(line, "\r"), "="); ok {
+				settings[name] = value
+			}
+		}
+		sessions[puttySessionName(entry.Name())] = settings
+	}
+	return
+}
+
+// puttySessionName undoes the %XX escaping PuTTY applies to session names
+// when using them as registry keys and file names.
+func puttySessionName(escaped string) string {
+	if name, err := url.PathUnescape(escaped); err == nil {
+		return name
+	}
+	return escaped
+}
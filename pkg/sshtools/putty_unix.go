@@ -0,0 +1,12 @@
+//go:build !windows
+
+package sshtools
+
+// localPuTTYSessions reads the sessions saved by PuTTY on this machine.
+func localPuTTYSessions() (puttySessions, error) {
+	dir, err := ExpandPath(PuTTYSessionsDir)
+	if err != nil {
+		return nil, err
+	}
+	return readPuTTYDir(dir)
+}
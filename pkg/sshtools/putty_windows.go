@@ -0,0 +1,42 @@
+package sshtools
+
+import (
+	"fmt"
+	"strconv"
+
+	"golang.org/x/sys/windows/registry"
+)
+
+// localPuTTYSessions reads the sessions PuTTY saved in the registry of the
+// current user.
+func localPuTTYSessions() (sessions puttySessions, err error) {
+	root, err := registry.OpenKey(registry.CURRENT_USER, puttyRegistryKey, registry.ENUMERATE_SUB_KEYS)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open HKEY_CURRENT_USER\\%s: %v", puttyRegistryKey, err)
+	}
+	defer func() { _ = root.Close() }()
+	names, err := root.ReadSubKeyNames(-1)
+	if err != nil {
+		return
+	}
+
+	sessions = puttySessions{}
+	for _, escaped := range names {
+		key, errs := registry.OpenKey(root, escaped, registry.QUERY_VALUE)
+		if errs != nil {
+			return nil, errs
+		}
+		settings := map[string]string{}
+		for _, name := range []string{"HostName", "UserName", "PublicKeyFile", "Protocol"} {
+			if value, _, errs := key.GetStringValue(name); errs == nil {
+				settings[name] = value
+			}
+		}
+		if port, _, errs := key.GetIntegerValue("PortNumber"); errs == nil {
+			settings["PortNumber"] = strconv.FormatUint(port, 10)
+		}
+		_ = key.Close()
+		sessions[puttySessionName(escaped)] = settings
+	}
+	return
+}
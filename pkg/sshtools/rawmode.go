@@ -0,0 +1,66 @@
+package sshtools
+
+import (
+	"fmt"
+	"io"
+	"sync"
+
+	"golang.org/x/term"
+)
+
+// rawMode keeps a local terminal in raw mode for the life of a session and
+// makes sure it is restored on every way out: a normal return, a panic in
+// any session goroutine, or a signal. Each entry into raw mode is undone
+// exactly once; after Close the terminal stays restored.
+type rawMode struct {
+	fd     int
+	stderr io.Writer
+	// makeRaw and restore are MakeRaw and Restore.
+	makeRaw func(fd int) (*term.State, error)
+	restore func(fd int, state *term.State) error
+
+	mu     sync.Mutex
+	saved  *term.State // set while in raw mode
+	closed bool
+}
+
+func newRawMode(fd int, stderr io.Writer) *rawMode {
+	return &rawMode{fd: fd, stderr: stderr, makeRaw: MakeRaw, restore: Restore}
+}
+
+// enter puts the terminal into raw mode unless it already is or the
+// terminal was closed.
+func (r *rawMode) enter() (err error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.closed || r.saved != nil {
+		return
+	}
+	r.saved, err = r.makeRaw(r.fd)
+	return
+}
+
+// leave restores the terminal if it is in raw mode.
+func (r *rawMode) leave() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.leaveLocked()
+}
+
+func (r *rawMode) leaveLocked() {
+	if r.saved == nil {
+		return
+	}
+	if err := r.restore(r.fd, r.saved); err != nil {
+		fmt.Fprintln(r.stderr, err.Error())
+	}
+	r.saved = nil
+}
+
+// Close restores the terminal for good; later calls to enter do nothing.
+func (r *rawMode) Close() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.leaveLocked()
+	r.closed = true
+}
@@ -0,0 +1,106 @@
+package sshtools
+
+import (
+	"bytes"
+	"errors"
+	"slices"
+	"sync"
+	"testing"
+
+	"golang.org/x/term"
+)
+
+// fakeTerminal stands in for MakeRaw and Restore, recording the calls in
+// order together with whatever else a test records.
+type fakeTerminal struct {
+	mu         sync.Mutex
+	calls      []string
+	restoreErr error
+}
+
+func (f *fakeTerminal) record(call string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.calls = append(f.calls, call)
+}
+
+// Calls returns the calls recorded so far.
+func (f *fakeTerminal) Calls() []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return slices.Clone(f.calls)
+}
+
+// rawMode returns a rawMode using f in place of the terminal.
+func (f *fakeTerminal) rawMode(stderr *bytes.Buffer) *rawMode {
+	r := newRawMode(-1, stderr)
+	r.makeRaw = func(int) (*term.State, error) {
+		f.record("raw")
+		return &term.State{}, nil
+	}
+	r.restore = func(int, *term.State) error {
+		f.record("restore")
+		return f.restoreErr
+	}
+	return r
+}
+
+func TestRawModeRestoresOnce(t *testing.T) {
+	var f fakeTerminal
+	var stderr bytes.Buffer
+	r := f.rawMode(&stderr)
+
+	// 重复进入和离开时每次进入只恢复一次
+	steps := []func(){
+		func() { _ = r.enter() },
+		func() { _ = r.enter() },
+		r.leave,
+		r.leave,
+		func() { _ = r.enter() },
+		r.Close,
+		r.Close,
+		func() { _ = r.enter() },
+		r.leave,
+	}
+	for _, step := range steps {
+		step()
+	}
+	want := []string{"raw", "restore", "raw", "restore"}
+	if got := f.Calls(); !slices.Equal(got, want) {
+		t.Errorf("calls = %q, want %q", got, want)
+	}
+}
+
+func TestRawModeEnterFails(t *testing.T) {
+	var f fakeTerminal
+	r := f.rawMode(&bytes.Buffer{})
+	r.makeRaw = func(int) (*term.State, error) {
+		return nil, errors.New("not a terminal")
+	}
+
+	if err := r.enter(); err == nil {
+		t.Fatal("enter succeeded")
+	}
+	// 没有进入原始模式时不恢复
+	r.Close()
+	if got := f.Calls(); len(got) != 0 {
+		t.Errorf("calls = %q, want none", got)
+	}
+}
+
+func TestRawModeRestoreError(t *testing.T) {
+	f := fakeTerminal{restoreErr: errors.New("bad file descriptor")}
+	var stderr bytes.Buffer
+	r := f.rawMode(&stderr)
+
+	_ = r.enter()
+	r.Close()
+	if !bytes.Contains(stderr.Bytes(), []byte("bad file descriptor")) {
+		t.Errorf("stderr = %q, want the restore error", stderr.String())
+	}
+	// 恢复失败后也不再尝试
+	r.Close()
+	if got := f.Calls(); !slices.Equal(got, []string{"raw", "restore"}) {
+		t.Errorf("calls = %q, want a single restore", got)
+	}
+}
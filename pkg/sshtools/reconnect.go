@@ -0,0 +1,68 @@
+package sshtools
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// defaultAttachName is the remote tmux or screen session attach_session
+// uses when it names none.
+const defaultAttachName = "sshtools"
+
+// AttachNone turns attach_session off for a server when the config sets a
+// default for all of them, or for one session with -attach.
+const AttachNone = "none"
+
+// AttachSession returns the attach_session of server, falling back to the
+// config's default, or "" for none.
+func (c *Config) AttachSession(server *Server) string {
+	attach := server.AttachSession
+	if attach == "" {
+		attach = c.DefaultAttachSession
+	}
+	if attach == AttachNone {
+		return ""
+	}
+	return attach
+}
+
+// AttachCommand returns the command that attaches to the remote tmux or
+// screen session of attach_session, "tmux" or "screen" optionally followed
+// by ":name", creating it if it does not exist yet. An existing attachment,
+// e.g. from a connection that was lost, is taken over.
+func AttachCommand(attach string) (command string, err error) {
+	program, name, _ := strings.Cut(attach, ":")
+	if name == "" {
+		name = defaultAttachName
+	}
+	if strings.ContainsAny(name, " \t'\"$`\\;&|<>") {
+		return "", fmt.Errorf(`"attach_session" name %q must not contain spaces or shell characters`, name)
+	}
+	switch program {
+	case "tmux":
+		return "tmux new-session -A -D -s " + name, nil
+	case "screen":
+		return "screen -D -RR " + name, nil
+	}
+	return "", fmt.Errorf(`"attach_session" %q must be tmux or screen, optionally followed by :name`, attach)
+}
+
+// Dropped reports whether the connection ended by itself, e.g. because the
+// network went away, waiting up to wait for it to notice.
+func (c *Client) Dropped(wait time.Duration) bool {
+	if c.Lost() != nil {
+		return true
+	}
+	done := make(chan struct{})
+	go func() {
+		_ = c.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return true
+	case <-time.After(wait):
+		return false
+	}
+}
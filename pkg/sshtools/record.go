@@ -0,0 +1,170 @@
+package sshtools
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+// castHeader is the first line of an asciicast v2 recording.
+type castHeader struct {
+	Version   int               `json:"version"`
+	Width     int               `json:"width"`
+	Height    int               `json:"height"`
+	Timestamp int64             `json:"timestamp,omitempty"`
+	Title     string            `json:"title,omitempty"`
+	Env       map[string]string `json:"env,omitempty"`
+}
+
+// Recorder writes the output of a session to a file in the asciicast v2
+// format of asciinema: a JSON header line, then one [seconds, "o", data]
+// line per write. Write never fails, so a full disk cannot end the session;
+// the first error is returned by Close instead.
+type Recorder struct {
+	mu      sync.Mutex
+	f       *os.File
+	w       *bufio.Writer
+	start   time.Time
+	partial []byte // an incomplete UTF-8 sequence at the end of the last write
+	err     error
+}
+
+// NewRecorder creates the recording at path for a terminal of width x
+// height.
+func NewRecorder(path string, width, height int, title string) (r *Recorder, err error) {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0o600)
+	if err != nil {
+		return
+	}
+	r = &Recorder{f: f, w: bufio.NewWriter(f), start: time.Now()}
+	header, _ := json.Marshal(castHeader{
+		Version:   2,
+		Width:     width,
+		Height:    height,
+		Timestamp: r.start.Unix(),
+		Title:     title,
+		Env:       map[string]string{"TERM": os.Getenv("TERM"), "SHELL": os.Getenv("SHELL")},
+	})
+	if _, err = r.w.Write(append(header, '\n')); err != nil {
+		_ = f.Close()
+		return nil, err
+	}
+	return
+}
+
+func (r *Recorder) Write(p []byte) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.err != nil {
+		return len(p), nil
+	}
+	// 事件数据必须是合法的 UTF-8，被截断的多字节字符留到下次写入
+	data := append(r.partial, p...)
+	cut := len(data)
+	for i := len(data) - 1; i >= 0 && i >= len(data)-utf8.UTFMax; i-- {
+		if utf8.RuneStart(data[i]) {
+			if !utf8.FullRune(data[i:]) {
+				cut = i
+			}
+			break
+		}
+	}
+	r.partial = append([]byte(nil), data[cut:]...)
+	if cut > 0 {
+		r.event(data[:cut])
+	}
+	return len(p), nil
+}
+
+// event appends an output event.
+func (r *Recorder) event(data []byte) {
+	line, _ := json.Marshal([]any{time.Since(r.start).Seconds(), "o", string(data)})
+	if _, err := r.w.Write(append(line, '\n')); err != nil {
+		r.err = err
+	}
+}
+
+// Close flushes and closes the recording.
+func (r *Recorder) Close() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if len(r.partial) > 0 && r.err == nil {
+		r.event(r.partial)
+	}
+	err := r.err
+	if errs := r.w.Flush(); err == nil {
+		err = errs
+	}
+	if errs := r.f.Close(); err == nil {
+		err = errs
+	}
+	if err != nil {
+		return fmt.Errorf("failed to write recording %s: %v", r.f.Name(), err)
+	}
+	return nil
+}
+
+// ReplayOptions control Replay.
+type ReplayOptions struct {
+	// Speed multiplies the playback speed; 0 means 1.
+	Speed float64
+	// MaxIdle caps the pauses between events when set.
+	MaxIdle time.Duration
+}
+
+// Replay plays the output of an asciicast v2 recording to w with its
+// original timing.
+func Replay(r io.Reader, w io.Writer, opts ReplayOptions) (err error) {
+	speed := opts.Speed
+	if speed <= 0 {
+		speed = 1
+	}
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64<<10), 16<<20)
+	if !scanner.Scan() {
+		if err = scanner.Err(); err == nil {
+			err = errors.New("empty recording")
+		}
+		return
+	}
+	var header castHeader
+	if err = json.Unmarshal(scanner.Bytes(), &header); err != nil || header.Version != 2 {
+		return errors.New("not an asciicast v2 recording")
+	}
+
+	var last float64
+	for line := 2; scanner.Scan(); line++ {
+		var event []any
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+		if err = json.Unmarshal(scanner.Bytes(), &event); err != nil || len(event) != 3 {
+			return fmt.Errorf("line %d: invalid event", line)
+		}
+		at, ok1 := event[0].(float64)
+		kind, ok2 := event[1].(string)
+		data, ok3 := event[2].(string)
+		if !ok1 || !ok2 || !ok3 {
+			return fmt.Errorf("line %d: invalid event", line)
+		}
+		if kind != "o" {
+			continue
+		}
+		pause := time.Duration((at - last) / speed * float64(time.Second))
+		if opts.MaxIdle > 0 {
+			pause = min(pause, opts.MaxIdle)
+		}
+		time.Sleep(pause)
+		last = at
+		if _, err = io.WriteString(w, data); err != nil {
+			return
+		}
+	}
+	return scanner.Err()
+}
@@ -0,0 +1,227 @@
+package sshtools
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"regexp"
+	"sort"
+	"sync"
+)
+
+// builtinRedactPatterns match well-known credentials. When a pattern has a
+// group, only the group is redacted.
+var builtinRedactPatterns = []*regexp.Regexp{
+	// AWS access key IDs and secret access keys
+	regexp.MustCompile(`\b((?:AKIA|ASIA)[0-9A-Z]{16})\b`),
+	regexp.MustCompile(`(?i)aws_secret_access_key["']?\s*[=:]\s*["']?([A-Za-z0-9/+=]{40})`),
+	// GitHub, GitLab and Slack tokens
+	regexp.MustCompile(`\b(gh[pousr]_[A-Za-z0-9]{36,})\b`),
+	regexp.MustCompile(`\b(glpat-[A-Za-z0-9_-]{20,})\b`),
+	regexp.MustCompile(`\b(xox[abprs]-[A-Za-z0-9-]{10,})\b`),
+}
+
+// passwordPrompt matches the end of output asking for a password, after
+// which typed input is taken for one.
+var passwordPrompt = regexp.MustCompile(`(?i)(password|passphrase|passcode|pin)[^\n]*[:：]\s*$`)
+
+const (
+	// redactHold bounds how much of an unfinished word is held back.
+	redactHold = 256
+	// redactContext is how much of the current line is kept, so patterns
+	// can match across writes.
+	redactContext = 1024
+	// minTypedSecret is the shortest input taken for a typed password.
+	minTypedSecret = 4
+)
+
+// checkRedactPatterns validates redact_patterns.
+func checkRedactPatterns(patterns []string) (msgs []string) {
+	for _, pattern := range patterns {
+		if _, err := regexp.Compile(pattern); err != nil {
+			msgs = append(msgs, fmt.Sprintf(`"redact_patterns" %q is not a valid regular expression: %v`, pattern, err))
+		}
+	}
+	return
+}
+
+// Redactor passes session output through to w with secrets replaced, for
+// recordings and logs: the given secrets, credentials matching the built-in
+// and configured patterns, and passwords typed at a password prompt that
+// the remote side did not echo (see Input). Patterns are matched within a
+// line; the end of an unfinished word is held back until it is complete,
+// or until Flush.
+type Redactor struct {
+	w        io.Writer
+	patterns []*regexp.Regexp
+
+	mu      sync.Mutex
+	secrets [][]byte
+	// line is the part of the current line already written, unredacted;
+	// tail is what is held back.
+	line []byte
+	tail []byte
+	// prompted is set while the output ends with a password prompt, and
+	// typed collects the input since.
+	prompted bool
+	typed    []byte
+}
+
+// NewRedactor returns a Redactor writing to w that also redacts secrets
+// and the matches of patterns.
+func NewRedactor(w io.Writer, secrets []string, patterns []string) (r *Redactor, err error) {
+	r = &Redactor{w: w, patterns: append([]*regexp.Regexp{}, builtinRedactPatterns...)}
+	for _, pattern := range patterns {
+		re, errs := regexp.Compile(pattern)
+		if errs != nil {
+			return nil, fmt.Errorf("invalid redact pattern %q: %v", pattern, errs)
+		}
+		r.patterns = append(r.patterns, re)
+	}
+	for _, secret := range secrets {
+		r.addSecret([]byte(secret))
+	}
+	return
+}
+
+func (r *Redactor) addSecret(secret []byte) {
+	if len(secret) == 0 {
+		return
+	}
+	for _, s := range r.secrets {
+		if bytes.Equal(s, secret) {
+			return
+		}
+	}
+	r.secrets = append(r.secrets, secret)
+}
+
+func (r *Redactor) Write(p []byte) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.watchPrompt(p)
+
+	// 末尾未完的词可能是机密的开头，留到下次写入
+	data := append(r.tail, p...)
+	cut := bytes.LastIndexAny(data, " \t\r\n") + 1
+	if len(data)-cut > redactHold {
+		cut = len(data)
+	}
+	for _, secret := range r.secrets {
+		cut = min(cut, len(data)-partialPrefix(data, string(secret)))
+	}
+	out := r.redact(data[:cut])
+	r.tail = append([]byte(nil), data[cut:]...)
+	if _, err := r.w.Write(out); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// Flush writes what was held back.
+func (r *Redactor) Flush() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	out := r.redact(r.tail)
+	r.tail = nil
+	_, err := r.w.Write(out)
+	return err
+}
+
+// redact returns chunk with secrets replaced, matching patterns against
+// the current line so far and chunk.
+func (r *Redactor) redact(chunk []byte) []byte {
+	if len(chunk) == 0 {
+		return nil
+	}
+	text := append(append([]byte(nil), r.line...), chunk...)
+	start := len(r.line)
+	var spans [][2]int
+	for _, secret := range r.secrets {
+		for i := 0; ; {
+			j := bytes.Index(text[i:], secret)
+			if j < 0 {
+				break
+			}
+			spans = append(spans, [2]int{i + j, i + j + len(secret)})
+			i += j + len(secret)
+		}
+	}
+	for _, re := range r.patterns {
+		for _, m := range re.FindAllSubmatchIndex(text, -1) {
+			if len(m) >= 4 && m[2] >= 0 {
+				spans = append(spans, [2]int{m[2], m[3]})
+			} else {
+				spans = append(spans, [2]int{m[0], m[1]})
+			}
+		}
+	}
+	if i := bytes.LastIndexByte(text, '\n'); i >= 0 {
+		r.line = append(r.line[:0], text[i+1:]...)
+	} else {
+		r.line = text
+	}
+	if len(r.line) > redactContext {
+		r.line = append([]byte(nil), r.line[len(r.line)-redactContext:]...)
+	}
+	if len(spans) == 0 {
+		return chunk
+	}
+
+	// 已写出的部分无法再改，只替换本次的内容
+	sort.Slice(spans, func(i, j int) bool { return spans[i][0] < spans[j][0] })
+	var out []byte
+	pos := start
+	for _, span := range spans {
+		from, to := max(span[0], pos), span[1]
+		if to <= from {
+			continue
+		}
+		out = append(append(out, text[pos:from]...), redacted...)
+		pos = to
+	}
+	return append(out, text[pos:]...)
+}
+
+// watchPrompt notes whether output p leaves a password prompt on screen.
+// Output arriving while a password is typed means the input is echoed, so
+// it is not one.
+func (r *Redactor) watchPrompt(p []byte) {
+	visible := StripANSI(append([]byte(nil), p...))
+	if len(r.typed) > 0 && len(bytes.Trim(visible, "* \n")) > 0 {
+		r.prompted, r.typed = false, nil
+	}
+	if len(visible) > 0 {
+		line := append(append([]byte(nil), StripANSI(append([]byte(nil), r.line...))...), visible...)
+		r.prompted = passwordPrompt.Match(line)
+	}
+}
+
+// Input takes keystrokes typed into the session. A line typed at a
+// password prompt without being echoed is redacted from then on.
+func (r *Redactor) Input(p []byte) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, b := range p {
+		if !r.prompted {
+			return
+		}
+		switch b {
+		case '\r', '\n':
+			if len(r.typed) >= minTypedSecret {
+				r.addSecret(r.typed)
+			}
+			r.prompted, r.typed = false, nil
+		case 0x7f, 0x08: // backspace
+			if len(r.typed) > 0 {
+				r.typed = r.typed[:len(r.typed)-1]
+			}
+		case 0x03, 0x15: // ^C, ^U
+			r.typed = nil
+		default:
+			if b >= ' ' {
+				r.typed = append(r.typed, b)
+			}
+		}
+	}
+}
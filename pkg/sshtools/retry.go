@@ -0,0 +1,74 @@
+package sshtools
+
+import (
+	"fmt"
+	"time"
+)
+
+// Backoff between connection attempts: it starts at retryBackoff and
+// doubles after every failure up to maxRetryBackoff.
+const (
+	retryBackoff    = time.Second
+	maxRetryBackoff = 30 * time.Second
+)
+
+// attempts returns how often connecting to server is tried: the dialer's
+// Attempts, its connection_attempts or once.
+func (d *Dialer) attempts(server *Server) int {
+	if d.Attempts > 0 {
+		return d.Attempts
+	}
+	return max(server.ConnectionAttempts, 1)
+}
+
+// checkConnect validates connect_timeout and connection_attempts.
+func checkConnect(timeout string, attempts int) (msgs []string) {
+	if timeout != "" {
+		if d, err := time.ParseDuration(timeout); err != nil || d <= 0 {
+			msgs = append(msgs, fmt.Sprintf(`"connect_timeout" %q is not a duration such as "10s"`, timeout))
+		}
+	}
+	if attempts < 0 {
+		msgs = append(msgs, fmt.Sprintf(`"connection_attempts" %d cannot be negative`, attempts))
+	}
+	return
+}
+
+// Dial connects and authenticates to server. Failed attempts are retried
+// with exponential backoff as often as connection_attempts (or Attempts)
+// allows, unless the server was reached and turned down our host key check
+// or credentials, which trying again won't change.
+func (d *Dialer) Dial(server *Server) (c *Client, err error) {
+	if server.unresolved != nil {
+		return nil, server.unresolved
+	}
+	if err = d.vaultSecrets(server); err != nil {
+		return
+	}
+	if err = d.keychainPassword(server); err != nil {
+		return
+	}
+	// local_pre 在所有重试之前运行一次，失败时不连接
+	if server.LocalPre != "" {
+		d.Logf(1, "running local_pre %q", server.LocalPre)
+		if err = runLocalHook("local_pre", server.LocalPre, server); err != nil {
+			return
+		}
+	}
+	attempts := d.attempts(server)
+	backoff := retryBackoff
+	for attempt := 1; ; attempt++ {
+		var answered bool
+		if c, answered, err = d.dial(server); err == nil || answered || attempt >= attempts || isFinal(err) {
+			if c != nil {
+				c.connected = time.Now()
+			}
+			return
+		}
+		if d.OnRetry != nil {
+			d.OnRetry(server, attempt, attempts, err, backoff)
+		}
+		time.Sleep(backoff)
+		backoff = min(backoff*2, maxRetryBackoff)
+	}
+}
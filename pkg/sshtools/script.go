@@ -0,0 +1,136 @@
+package sshtools
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// shellInterpreters read a script from stdin with -s; other interpreters
+// (python, perl, ruby, node...) take "-" for stdin instead.
+var shellInterpreters = map[string]bool{"sh": true, "bash": true, "dash": true, "ksh": true, "zsh": true, "ash": true}
+
+// Script is a local script to run on servers with RunScript.
+type Script struct {
+	// Name is shown as the command in results.
+	Name string
+	Data []byte
+	// Args are shell words passed to the script, e.g. "v1.2 --force".
+	Args string
+	// Interpreter runs the script; the #! line's by default, else sh.
+	Interpreter string
+	// Pipe feeds the script to the interpreter's stdin instead of
+	// uploading it to a temporary file. The script then has no stdin of
+	// its own.
+	Pipe bool
+}
+
+// LoadScript reads the script at path.
+func LoadScript(path string) (script *Script, err error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read script: %v", err)
+	}
+	return &Script{Name: filepath.Base(path), Data: data}, nil
+}
+
+// interpreter returns the command line that runs the script: Interpreter,
+// the #! line or sh.
+func (s *Script) interpreter() string {
+	if s.Interpreter != "" {
+		return s.Interpreter
+	}
+	if line, ok := bytes.CutPrefix(s.Data, []byte("#!")); ok {
+		if i := bytes.IndexByte(line, '\n'); i >= 0 {
+			line = line[:i]
+		}
+		if interpreter := strings.TrimSpace(string(line)); interpreter != "" {
+			return interpreter
+		}
+	}
+	return "sh"
+}
+
+// command returns what runs the script uploaded to path, or with an
+// empty path the script piped to stdin.
+func (s *Script) command(path string) string {
+	interpreter := s.interpreter()
+	command := interpreter + " " + ShellQuote(path)
+	if path == "" {
+		// "/usr/bin/env bash" 也按 bash 处理
+		fields := strings.Fields(interpreter)
+		if shellInterpreters[filepath.Base(fields[len(fields)-1])] {
+			command = interpreter + " -s --"
+		} else {
+			command = interpreter + " -"
+		}
+	}
+	if s.Args != "" {
+		command += " " + s.Args
+	}
+	return command
+}
+
+// String returns the script with its arguments, as shown in results.
+func (s *Script) String() string {
+	if s.Args == "" {
+		return s.Name
+	}
+	return s.Name + " " + s.Args
+}
+
+// RunScript runs script like Exec: it is uploaded to a private temporary
+// file, run there (through sudo with Become) and removed afterwards, or
+// piped to the interpreter with Pipe.
+func (c *Client) RunScript(script *Script, stdout, stderr io.Writer, max int64, killOnTruncate bool) (res *ExecResult) {
+	if script.Pipe {
+		if c.Become {
+			return &ExecResult{Alias: c.Server.Alias, Address: c.Server.Addr(), Command: script.String(), ExitCode: -1,
+				Error: "a piped script cannot run through sudo, whose password is typed into stdin"}
+		}
+		res = c.exec(script.command(""), bytes.NewReader(script.Data), stdout, stderr, max, killOnTruncate)
+		res.Command = script.String()
+		return
+	}
+
+	path, err := c.uploadScript(script.Data)
+	if err != nil {
+		return &ExecResult{Alias: c.Server.Alias, Address: c.Server.Addr(), Command: script.String(), ExitCode: -1, Error: err.Error()}
+	}
+	defer func() {
+		_, _ = c.runScript("rm -f "+ShellQuote(path), false)
+	}()
+	res = c.Exec(script.command(path), stdout, stderr, max, killOnTruncate)
+	res.Command = script.String()
+	return
+}
+
+// CaptureScript is RunScript collecting the output into the result, like
+// Capture.
+func (c *Client) CaptureScript(script *Script, max int64, killOnTruncate bool) *ExecResult {
+	var stdout, stderr bytes.Buffer
+	res := c.RunScript(script, &stdout, &stderr, max, killOnTruncate)
+	res.Stdout, res.Stderr = stdout.String(), stderr.String()
+	return res
+}
+
+// uploadScript writes data to a new temporary file that only the login
+// user can read and returns its path.
+func (c *Client) uploadScript(data []byte) (path string, err error) {
+	session, err := c.NewSession()
+	if err != nil {
+		return
+	}
+	defer func() { _ = session.Close() }()
+	var stdout, stderr bytes.Buffer
+	session.Stdin = bytes.NewReader(data)
+	session.Stdout, session.Stderr = &stdout, &stderr
+	err = session.Run(`umask 077; tmp=$(mktemp "${TMPDIR:-/tmp}/.sshtools-script.XXXXXX") && cat > "$tmp" && chmod 700 "$tmp" && echo "$tmp"`)
+	if err != nil {
+		return "", fmt.Errorf("failed to upload the script: %v %s", err, strings.TrimSpace(stderr.String()))
+	}
+	return strings.TrimSpace(stdout.String()), nil
+}
@@ -0,0 +1,239 @@
+package sshtools
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	"golang.org/x/crypto/scrypt"
+)
+
+// encryptedPrefix marks a config value encrypted with the master password.
+const encryptedPrefix = "enc:"
+
+// encryptionCheck is encrypted into Encryption.Check to tell a wrong master
+// password from a damaged value.
+const encryptionCheck = "sshtools"
+
+// secretFields are the server fields stored encrypted.
+var secretFields = []string{"password", "private_key"}
+
+// ErrWrongMasterPassword is returned when the master password does not
+// decrypt the config.
+var ErrWrongMasterPassword = errors.New("wrong master password")
+
+// Encryption describes how the secrets of a config file are encrypted:
+// AES-256-GCM with a key derived from the master password by scrypt.
+type Encryption struct {
+	KDF   string `json:"kdf"`
+	Salt  string `json:"salt"`
+	Check string `json:"check"`
+}
+
+// IsEncrypted reports whether a config value is encrypted.
+func IsEncrypted(value string) bool {
+	return strings.HasPrefix(value, encryptedPrefix)
+}
+
+// Locked reports whether the config has encrypted values that Unlock has
+// not decrypted yet.
+func (c *Config) Locked() bool {
+	return c.Encryption != nil && c.secretKey == nil
+}
+
+// Unlock decrypts the password and private_key of every server in memory
+// with the master password, and keeps the key for EncryptValue.
+func (c *Config) Unlock(masterPassword string) (err error) {
+	key, err := c.Encryption.key(masterPassword)
+	if err != nil {
+		return
+	}
+	for i := range c.Servers {
+		s := &c.Servers[i]
+		for _, value := range []*string{&s.Password, &s.PrivateKey} {
+			if !IsEncrypted(*value) {
+				continue
+			}
+			if *value, err = decryptValue(key, *value); err != nil {
+				return fmt.Errorf("server %q: %v", s.Alias, err)
+			}
+		}
+	}
+	c.secretKey = key
+	return
+}
+
+// EncryptValue encrypts value for storing in an unlocked encrypted config.
+// Values of configs without encryption are returned as they are.
+func (c *Config) EncryptValue(value string) (string, error) {
+	if c.Encryption == nil || value == "" {
+		return value, nil
+	}
+	if c.secretKey == nil {
+		return "", fmt.Errorf("the config is locked")
+	}
+	return encryptValue(c.secretKey, value)
+}
+
+// key derives the key from the master password and checks it.
+func (e *Encryption) key(masterPassword string) (key []byte, err error) {
+	if e.KDF != "scrypt" {
+		return nil, fmt.Errorf("unsupported key derivation %q", e.KDF)
+	}
+	salt, err := base64.StdEncoding.DecodeString(e.Salt)
+	if err != nil {
+		return nil, fmt.Errorf("invalid encryption salt: %v", err)
+	}
+	if key, err = deriveKey(masterPassword, salt); err != nil {
+		return
+	}
+	if check, errs := decryptValue(key, e.Check); errs != nil || check != encryptionCheck {
+		return nil, ErrWrongMasterPassword
+	}
+	return
+}
+
+// deriveKey turns the master password into an AES-256 key.
+func deriveKey(masterPassword string, salt []byte) ([]byte, error) {
+	return scrypt.Key([]byte(masterPassword), salt, 1<<15, 8, 1, 32)
+}
+
+func encryptValue(key []byte, value string) (string, error) {
+	aead, err := newAEAD(key)
+	if err != nil {
+		return "", err
+	}
+	nonce := make([]byte, aead.NonceSize())
+	if _, err = rand.Read(nonce); err != nil {
+		return "", err
+	}
+	sealed := aead.Seal(nonce, nonce, []byte(value), nil)
+	return encryptedPrefix + base64.StdEncoding.EncodeToString(sealed), nil
+}
+
+func decryptValue(key []byte, value string) (string, error) {
+	aead, err := newAEAD(key)
+	if err != nil {
+		return "", err
+	}
+	sealed, err := base64.StdEncoding.DecodeString(strings.TrimPrefix(value, encryptedPrefix))
+	if err != nil || len(sealed) < aead.NonceSize() {
+		return "", fmt.Errorf("damaged encrypted value")
+	}
+	plain, err := aead.Open(nil, sealed[:aead.NonceSize()], sealed[aead.NonceSize():], nil)
+	if err != nil {
+		return "", fmt.Errorf("damaged encrypted value")
+	}
+	return string(plain), nil
+}
+
+func newAEAD(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// EncryptConfigFile encrypts the password and private_key of every server
+// in filename with masterPassword. The backup of the plaintext version is
+// removed.
+func EncryptConfigFile(filename, masterPassword string) (count int, err error) {
+	doc, servers, err := readConfigDoc(filename)
+	if err != nil {
+		return
+	}
+	if doc.get("encryption") != nil {
+		return 0, fmt.Errorf("%s is already encrypted", filename)
+	}
+	salt := make([]byte, 16)
+	if _, err = rand.Read(salt); err != nil {
+		return
+	}
+	e := &Encryption{KDF: "scrypt", Salt: base64.StdEncoding.EncodeToString(salt)}
+	key, err := deriveKey(masterPassword, salt)
+	if err != nil {
+		return
+	}
+	if e.Check, err = encryptValue(key, encryptionCheck); err != nil {
+		return
+	}
+	count, err = mapSecrets(servers, func(value string) (string, error) {
+		if IsEncrypted(value) {
+			return value, nil
+		}
+		return encryptValue(key, value)
+	})
+	if err != nil {
+		return
+	}
+	if err = doc.set("encryption", e); err != nil {
+		return
+	}
+	if err = writeServers(filename, doc, servers); err != nil {
+		return
+	}
+	_ = os.Remove(filename + ".bak")
+	return
+}
+
+// DecryptConfigFile stores the secrets of filename in plaintext again.
+func DecryptConfigFile(filename, masterPassword string) (count int, err error) {
+	doc, servers, err := readConfigDoc(filename)
+	if err != nil {
+		return
+	}
+	var e Encryption
+	if raw := doc.get("encryption"); raw == nil {
+		return 0, fmt.Errorf("%s is not encrypted", filename)
+	} else if err = json.Unmarshal(raw, &e); err != nil {
+		return
+	}
+	key, err := e.key(masterPassword)
+	if err != nil {
+		return
+	}
+	count, err = mapSecrets(servers, func(value string) (string, error) {
+		if !IsEncrypted(value) {
+			return value, nil
+		}
+		return decryptValue(key, value)
+	})
+	if err != nil {
+		return
+	}
+	doc.delete("encryption")
+	err = writeServers(filename, doc, servers)
+	return
+}
+
+// mapSecrets replaces the secret fields of servers with fn of their value,
+// and counts those that changed.
+func mapSecrets(servers []*jsonObject, fn func(string) (string, error)) (count int, err error) {
+	for _, entry := range servers {
+		for _, field := range secretFields {
+			var value string
+			if json.Unmarshal(entry.get(field), &value) != nil || value == "" {
+				continue
+			}
+			mapped, errs := fn(value)
+			if errs != nil {
+				return count, errs
+			}
+			if mapped == value {
+				continue
+			}
+			if err = entry.set(field, mapped); err != nil {
+				return
+			}
+			count++
+		}
+	}
+	return
+}
@@ -0,0 +1,130 @@
+package sshtools
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// loadedModules records the PKCS#11 modules this process loaded into
+// ssh-agent, so the PIN is asked for once.
+var (
+	loadedModulesMu sync.Mutex
+	loadedModules   = map[string]error{}
+)
+
+// isSecurityKey reports whether key lives on a FIDO2 security key
+// (sk-ssh-ed25519, sk-ecdsa-sha2-nistp256 and their certificates).
+func isSecurityKey(key ssh.PublicKey) bool {
+	return strings.HasPrefix(key.Type(), "sk-")
+}
+
+// confirmPresence asks for a touch before a security key signs, as ssh
+// does, since the key waits silently otherwise.
+func confirmPresence(key ssh.PublicKey) {
+	if isSecurityKey(key) {
+		fmt.Fprintf(os.Stderr, "Confirm user presence for key %s %s\r\n", key.Type(), ssh.FingerprintSHA256(key))
+	}
+}
+
+// sshAdd runs ssh-add with args on the terminal, which may ask for a
+// passphrase or PIN.
+func sshAdd(args ...string) error {
+	cmd := exec.Command("ssh-add", args...)
+	cmd.Stdin, cmd.Stdout, cmd.Stderr = os.Stdin, os.Stderr, os.Stderr
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("ssh-add %s failed: %v", strings.Join(args, " "), err)
+	}
+	return nil
+}
+
+// securityKey returns the ssh-agent signer of the security key whose
+// handle is at keyPath, recognised by its .pub file, adding it to the
+// agent first if needed. Go cannot talk to the key itself, the agent
+// does that through ssh-sk-helper. It returns nil for other keys.
+func (d *Dialer) securityKey(trace *authTrace, keyPath string) (signer ssh.Signer, err error) {
+	data, err := os.ReadFile(keyPath + ".pub")
+	if err != nil {
+		return nil, nil
+	}
+	key, _, _, _, err := ssh.ParseAuthorizedKey(data)
+	if err != nil || !isSecurityKey(key) {
+		return nil, nil
+	}
+	for _, retry := range []bool{false, true} {
+		if retry {
+			d.Logf(1, "adding security key %s to ssh-agent", keyPath)
+			if err = sshAdd(keyPath); err != nil {
+				return
+			}
+		}
+		signers, errs := agentSigners(trace)
+		if errs != nil {
+			return nil, fmt.Errorf("%s is a security key, which needs ssh-agent: %v", keyPath, errs)
+		}
+		for _, s := range signers {
+			if bytes.Equal(s.PublicKey().Marshal(), key.Marshal()) {
+				return s, nil
+			}
+		}
+	}
+	return nil, fmt.Errorf("security key %s is not in ssh-agent after ssh-add", keyPath)
+}
+
+// pkcs11Keys returns an auth method that loads the PKCS#11 module into
+// ssh-agent, asking for the PIN, and offers the keys it added. It comes
+// after the other keys, so a module already loaded by an earlier run is
+// used through the agent without asking again.
+func (d *Dialer) pkcs11Keys(trace *authTrace, module string) ssh.AuthMethod {
+	return ssh.PublicKeysCallback(func() (signers []ssh.Signer, err error) {
+		before, err := agentSigners(trace)
+		if err != nil {
+			return nil, fmt.Errorf("pkcs11_module needs ssh-agent: %v", err)
+		}
+		loadedModulesMu.Lock()
+		errs, loaded := loadedModules[module]
+		if !loaded {
+			if trace.timer != nil {
+				trace.timer.Stop()
+			}
+			d.Logf(1, "loading PKCS#11 module %s into ssh-agent", module)
+			errs = sshAdd("-s", module)
+			loadedModules[module] = errs
+		}
+		loadedModulesMu.Unlock()
+		if errs != nil {
+			return nil, errs
+		}
+		after, err := agentSigners(trace)
+		if err != nil {
+			return
+		}
+		for _, s := range after {
+			if !hasKey(before, s.PublicKey()) {
+				d.Logf(1, "offering public key: %s %s (%s)", s.PublicKey().Type(), ssh.FingerprintSHA256(s.PublicKey()), module)
+				signers = append(signers, recordSigner(s, trace))
+			}
+		}
+		if len(signers) == 0 {
+			return nil, errors.New("the PKCS#11 module added no new keys to ssh-agent")
+		}
+		trace.last, trace.method = "publickey ("+module+")", "publickey"
+		return
+	})
+}
+
+// hasKey reports whether one of signers holds key.
+func hasKey(signers []ssh.Signer, key ssh.PublicKey) bool {
+	for _, s := range signers {
+		if bytes.Equal(s.PublicKey().Marshal(), key.Marshal()) {
+			return true
+		}
+	}
+	return false
+}
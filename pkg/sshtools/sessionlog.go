@@ -0,0 +1,116 @@
+package sshtools
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"regexp"
+	"sync"
+	"time"
+)
+
+// ansiEscape matches terminal escape sequences: CSI (colors, cursor
+// movement), OSC (window titles) and the two-byte ESC sequences.
+var ansiEscape = regexp.MustCompile(`\x1b\[[0-?]*[ -/]*[@-~]|\x1b\][^\x07\x1b]*(\x07|\x1b\\)|\x1b[@-Z\\-_]`)
+
+// StripANSI removes terminal escape sequences and control characters other
+// than tabs and newlines from p, leaving the text as it reads on screen.
+func StripANSI(p []byte) []byte {
+	p = ansiEscape.ReplaceAll(p, nil)
+	out := p[:0]
+	for _, c := range p {
+		if c >= ' ' || c == '\t' || c == '\n' {
+			out = append(out, c)
+		}
+	}
+	return out
+}
+
+// SessionLog appends the output of sessions to a text file, for an audit
+// trail of what was run and seen. Output is written a line at a time,
+// optionally stamped with the time it arrived and stripped of escape
+// sequences. Like a Recorder, Write never fails; the first error is
+// returned by Close.
+type SessionLog struct {
+	// Timestamps prefixes each line with the time it was completed.
+	Timestamps bool
+	// StripANSI removes colors and other escape sequences.
+	StripANSI bool
+
+	mu      sync.Mutex
+	f       *os.File
+	partial []byte // output after the last newline
+	err     error
+}
+
+// OpenSessionLog opens the session log at path for appending and writes a
+// line marking the start of a session to title.
+func OpenSessionLog(path, title string, timestamps, stripANSI bool) (l *SessionLog, err error) {
+	path, err = ExpandPath(path)
+	if err != nil {
+		return
+	}
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0o600)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open the session log: %v", err)
+	}
+	l = &SessionLog{Timestamps: timestamps, StripANSI: stripANSI, f: f}
+	if _, err = fmt.Fprintf(f, "=== session to %s started %s ===\n", title, time.Now().Format(time.RFC3339)); err != nil {
+		_ = f.Close()
+		return nil, fmt.Errorf("failed to write the session log: %v", err)
+	}
+	return
+}
+
+func (l *SessionLog) Write(p []byte) (int, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.err != nil {
+		return len(p), nil
+	}
+	// 按整行写入，时间戳对应行结束的时刻，转义序列不会被拆开
+	data := append(l.partial, p...)
+	end := bytes.LastIndexByte(data, '\n') + 1
+	l.partial = append([]byte(nil), data[end:]...)
+	for _, line := range bytes.SplitAfter(data[:end], []byte{'\n'}) {
+		if len(line) > 0 {
+			l.line(line)
+		}
+	}
+	return len(p), nil
+}
+
+// line writes one line of output.
+func (l *SessionLog) line(line []byte) {
+	// 终端输出以 \r\n 结尾，日志中统一为 \n
+	line = bytes.TrimRight(line, "\r\n")
+	if l.StripANSI {
+		line = StripANSI(line)
+	}
+	var buf bytes.Buffer
+	if l.Timestamps {
+		buf.WriteString(time.Now().Format("2006-01-02T15:04:05.000Z07:00 "))
+	}
+	buf.Write(line)
+	buf.WriteByte('\n')
+	if _, err := l.f.Write(buf.Bytes()); err != nil {
+		l.err = err
+	}
+}
+
+// Close writes the last incomplete line and closes the log.
+func (l *SessionLog) Close() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if len(l.partial) > 0 && l.err == nil {
+		l.line(l.partial)
+	}
+	err := l.err
+	if errs := l.f.Close(); err == nil {
+		err = errs
+	}
+	if err != nil {
+		return fmt.Errorf("failed to write session log %s: %v", l.f.Name(), err)
+	}
+	return nil
+}
@@ -0,0 +1,207 @@
+package sshtools
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/pkg/sftp"
+)
+
+// ErrRemoteChanged is returned by PutFile when the remote file no longer
+// matches the snapshot taken when it was read.
+var ErrRemoteChanged = errors.New("remote file changed since it was downloaded")
+
+// RemoteFile is a remote file's content and metadata at download time.
+type RemoteFile struct {
+	Path    string
+	Data    []byte
+	Exists  bool
+	Mode    os.FileMode
+	Size    int64
+	ModTime time.Time
+	SHA256  string
+	uid     int
+	gid     int
+	hasID   bool
+}
+
+// SFTP opens an SFTP session on the connection.
+func (c *Client) SFTP() (client *sftp.Client, err error) {
+	client, err = sftp.NewClient(c.Client)
+	if err != nil {
+		err = fmt.Errorf("failed to start sftp on server %s: %v", c.Server.Addr(), err)
+	}
+	return
+}
+
+// GetFile downloads name. A missing file is not an error: Exists is false.
+func GetFile(client *sftp.Client, name string) (file *RemoteFile, err error) {
+	file = &RemoteFile{Path: name, Mode: 0o644}
+	info, err := client.Stat(name)
+	if errors.Is(err, os.ErrNotExist) {
+		return file, nil
+	}
+	if err != nil {
+		return
+	}
+	if info.IsDir() {
+		err = fmt.Errorf("%s is a directory", name)
+		return
+	}
+	f, err := client.Open(name)
+	if err != nil {
+		err = fmt.Errorf("failed to read %s: %v", name, err)
+		return
+	}
+	defer func() { _ = f.Close() }()
+	if file.Data, err = io.ReadAll(f); err != nil {
+		return
+	}
+	file.Exists = true
+	file.Mode, file.Size, file.ModTime = info.Mode().Perm(), info.Size(), info.ModTime()
+	file.SHA256 = SHA256(file.Data)
+	if stat, ok := info.Sys().(*sftp.FileStat); ok {
+		file.uid, file.gid, file.hasID = int(stat.UID), int(stat.GID), true
+	}
+	return
+}
+
+// CheckWritable fails with a clear message when file cannot be replaced,
+// e.g. because it is owned by root, before any edit is made.
+func CheckWritable(client *sftp.Client, file *RemoteFile) (err error) {
+	probe := path.Join(path.Dir(file.Path), fmt.Sprintf(".%s.sshtools-%d", path.Base(file.Path), time.Now().UnixNano()))
+	f, err := client.OpenFile(probe, os.O_WRONLY|os.O_CREATE|os.O_EXCL)
+	if err != nil {
+		return fmt.Errorf("cannot write to %s (%v); files owned by another user (e.g. root) cannot be edited through sftp", path.Dir(file.Path), err)
+	}
+	_ = f.Close()
+	_ = client.Remove(probe)
+	return
+}
+
+// PutFile replaces file with data atomically: it writes a temporary file in
+// the same directory, copies the original mode and (where permitted)
+// ownership, then renames it into place. It refuses with ErrRemoteChanged
+// if the remote file was modified since file was downloaded.
+func PutFile(client *sftp.Client, file *RemoteFile, data []byte) (err error) {
+	current, err := GetFile(client, file.Path)
+	if err != nil {
+		return
+	}
+	if current.Exists != file.Exists || current.SHA256 != file.SHA256 {
+		return ErrRemoteChanged
+	}
+
+	tmp := path.Join(path.Dir(file.Path), fmt.Sprintf(".%s.sshtools-%d", path.Base(file.Path), time.Now().UnixNano()))
+	f, err := client.OpenFile(tmp, os.O_WRONLY|os.O_CREATE|os.O_EXCL)
+	if err != nil {
+		return fmt.Errorf("cannot write to %s: %v", path.Dir(file.Path), err)
+	}
+	committed := false
+	defer func() {
+		if !committed {
+			_ = client.Remove(tmp)
+		}
+	}()
+	if _, err = io.Copy(f, bytes.NewReader(data)); err != nil {
+		_ = f.Close()
+		return fmt.Errorf("failed to upload %s: %v", tmp, err)
+	}
+	if err = f.Close(); err != nil {
+		return
+	}
+	if err = client.Chmod(tmp, file.Mode); err != nil {
+		return fmt.Errorf("failed to set mode on %s: %v", tmp, err)
+	}
+	if file.hasID {
+		// Only root can give files away; keeping our own ownership is fine.
+		_ = client.Chown(tmp, file.uid, file.gid)
+	}
+	if err = client.PosixRename(tmp, file.Path); err != nil {
+		return fmt.Errorf("failed to replace %s: %v", file.Path, err)
+	}
+	committed = true
+	return
+}
+
+// sudoScript runs script through sudo, answering its prompt with
+// BecomePassword, and returns its output. Become must be set.
+func (c *Client) sudoScript(script string) (out []byte, err error) {
+	var stdout, stderr bytes.Buffer
+	res := c.Exec("sh -c "+ShellQuote(script), &stdout, &stderr, 0, false)
+	if res.Error != "" {
+		return nil, errors.New(res.Error)
+	}
+	if res.ExitCode != 0 {
+		return nil, fmt.Errorf("exit status %d: %s", res.ExitCode, strings.TrimSpace(stderr.String()))
+	}
+	return stdout.Bytes(), nil
+}
+
+// SudoGetFile is GetFile for files only root can read, reading name
+// through sudo with the client's Become set.
+func (c *Client) SudoGetFile(name string) (file *RemoteFile, err error) {
+	file = &RemoteFile{Path: name, Mode: 0o644}
+	// 第一行是权限和属主，其余是文件内容；文件不存在时没有输出
+	out, err := c.sudoScript(fmt.Sprintf(`f=%s
+if [ -d "$f" ]; then echo "$f is a directory" >&2; exit 1; fi
+if [ -e "$f" ]; then { stat -c '%%a %%u %%g' "$f" 2>/dev/null || stat -f '%%Lp %%u %%g' "$f"; } && cat "$f"; fi`, ShellQuote(name)))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s through sudo: %v", name, err)
+	}
+	if len(out) == 0 {
+		return file, nil
+	}
+	header, data, _ := bytes.Cut(out, []byte("\n"))
+	var mode uint32
+	if _, err = fmt.Sscanf(string(header), "%o %d %d", &mode, &file.uid, &file.gid); err != nil {
+		return nil, fmt.Errorf("failed to read %s through sudo: unexpected stat output %q", name, header)
+	}
+	file.Exists, file.hasID = true, true
+	file.Mode, file.Data, file.Size = os.FileMode(mode).Perm(), data, int64(len(data))
+	file.SHA256 = SHA256(data)
+	return
+}
+
+// SudoPutFile is PutFile through sudo, for files the login user cannot
+// write: data is uploaded to /tmp, then copied next to file by root and
+// renamed into place with the original mode and ownership.
+func (c *Client) SudoPutFile(file *RemoteFile, data []byte) (err error) {
+	current, err := c.SudoGetFile(file.Path)
+	if err != nil {
+		return
+	}
+	if current.Exists != file.Exists || current.SHA256 != file.SHA256 {
+		return ErrRemoteChanged
+	}
+	tmp, err := c.uploadTemp(data)
+	if err != nil {
+		return
+	}
+	defer func() {
+		_, _ = c.runScript("rm -f "+ShellQuote(tmp), false)
+	}()
+
+	chown := ""
+	if file.hasID {
+		chown = fmt.Sprintf(`chown %d:%d "$stage"`, file.uid, file.gid)
+	}
+	_, err = c.sudoScript(fmt.Sprintf(`set -e
+stage=
+trap 'rm -f "$stage"' EXIT
+stage=$(mktemp %s)
+cat %s > "$stage"
+chmod %o "$stage"
+%s
+mv -f "$stage" %s`, ShellQuote(path.Join(path.Dir(file.Path), "."+path.Base(file.Path)+".sshtools.XXXXXX")), ShellQuote(tmp), file.Mode, chown, ShellQuote(file.Path)))
+	if err != nil {
+		return fmt.Errorf("failed to replace %s through sudo: %v", file.Path, err)
+	}
+	return
+}
@@ -0,0 +1,653 @@
+package sshtools
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/pkg/sftp"
+	"golang.org/x/term"
+)
+
+// sftpHelp lists the commands of the sftp shell.
+const sftpHelp = `cd [path]                      Change the remote directory (default: home)
+chmod <mode> <path>...         Change the permissions of remote files, e.g. chmod 644 *.conf
+get [-r] [-p] <remote>... [local]
+                               Download files (-r directories, -p keep times)
+help                           Show this help
+lcd [path]                     Change the local directory
+lpwd                           Print the local directory
+ls [-l] [-a] [path]...         List remote files (-l details, -a dot files)
+mkdir [-p] <path>...           Create remote directories (-p with parents)
+put [-r] [-p] <local>... [remote]
+                               Upload files (-r directories, -p keep times)
+pwd                            Print the remote directory
+rename <old> <new>             Rename a remote file
+rm <path>...                   Remove remote files
+rmdir <path>...                Remove empty remote directories
+exit, quit                     Leave the shell
+Remote and local paths may contain * ? and [...] wildcards. Tab completes
+commands and paths.
+`
+
+// sftpCommands are completed as the first word.
+var sftpCommands = []string{"bye", "cd", "chmod", "exit", "get", "help", "lcd", "lpwd", "ls", "mkdir", "put", "pwd", "quit", "rename", "rm", "rmdir"}
+
+// SFTPShell is an interactive prompt on an SFTP session, like the sftp
+// command. On a terminal it offers line editing, history and tab
+// completion of remote paths; otherwise it runs the commands read from
+// stdin and stops at the first that fails, as sftp -b does.
+type SFTPShell struct {
+	Client *sftp.Client
+	Stdin  io.Reader
+	Stdout io.Writer
+	Stderr io.Writer
+	// Prompt is shown before each command; "sftp> " when empty.
+	Prompt string
+	// Progress is called when a transfer starts to show p, when set; the
+	// transfer calls the returned func when it ends.
+	Progress func(p *TransferProgress) (stop func())
+
+	home, cwd string
+	term      *term.Terminal // nil when stdin is not a terminal
+	width     int
+}
+
+// NewSFTPShell returns a shell on client starting in the remote home
+// directory.
+func NewSFTPShell(client *sftp.Client, stdin io.Reader, stdout, stderr io.Writer) (s *SFTPShell, err error) {
+	home, err := client.Getwd()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get the remote directory: %v", err)
+	}
+	return &SFTPShell{Client: client, Stdin: stdin, Stdout: stdout, Stderr: stderr, home: home, cwd: home, width: 80}, nil
+}
+
+// Run reads and runs commands until exit or the end of the input.
+func (s *SFTPShell) Run() (err error) {
+	prompt := s.Prompt
+	if prompt == "" {
+		prompt = "sftp> "
+	}
+	f, ok := s.Stdin.(*os.File)
+	if !ok || !term.IsTerminal(int(f.Fd())) {
+		scanner := bufio.NewScanner(s.Stdin)
+		for scanner.Scan() {
+			line := strings.TrimSpace(scanner.Text())
+			if line == "" || strings.HasPrefix(line, "#") {
+				continue
+			}
+			fmt.Fprintf(s.Stdout, "%s%s\n", prompt, line)
+			quit, errs := s.Execute(line)
+			if errs != nil || quit {
+				return errs
+			}
+		}
+		return scanner.Err()
+	}
+
+	fd := int(f.Fd())
+	s.term = term.NewTerminal(struct {
+		io.Reader
+		io.Writer
+	}{s.Stdin, s.Stdout}, prompt)
+	s.term.AutoCompleteCallback = s.complete
+	for {
+		// 只在读取命令行时进入 raw 模式，命令的输出和 Ctrl-C 照常工作
+		state, errs := MakeRaw(fd)
+		if errs != nil {
+			return errs
+		}
+		if width, height, errs := terminalSize(fd); errs == nil && width > 0 {
+			s.width = width
+			_ = s.term.SetSize(width, height)
+		}
+		line, errs := s.term.ReadLine()
+		_ = Restore(fd, state)
+		if errors.Is(errs, io.EOF) {
+			fmt.Fprintln(s.Stdout)
+			return nil
+		}
+		if errs != nil {
+			return errs
+		}
+		quit, errs := s.Execute(line)
+		if errs != nil {
+			fmt.Fprintln(s.Stderr, errs)
+		}
+		if quit {
+			return nil
+		}
+	}
+}
+
+// Execute runs one command line and reports whether it asks to quit.
+func (s *SFTPShell) Execute(line string) (quit bool, err error) {
+	words, err := splitWords(line)
+	if err != nil || len(words) == 0 {
+		return
+	}
+	args := words[1:]
+	switch words[0] {
+	case "exit", "quit", "bye":
+		return true, nil
+	case "help", "?":
+		_, err = io.WriteString(s.Stdout, sftpHelp)
+	case "pwd":
+		fmt.Fprintf(s.Stdout, "Remote working directory: %s\n", s.cwd)
+	case "lpwd":
+		var dir string
+		if dir, err = os.Getwd(); err == nil {
+			fmt.Fprintf(s.Stdout, "Local working directory: %s\n", dir)
+		}
+	case "cd":
+		err = s.cd(args)
+	case "lcd":
+		err = s.lcd(args)
+	case "ls":
+		err = s.ls(args)
+	case "get":
+		err = s.transfer(false, args)
+	case "put":
+		err = s.transfer(true, args)
+	case "mkdir":
+		err = s.mkdir(args)
+	case "rm":
+		err = s.remove(args, false)
+	case "rmdir":
+		err = s.remove(args, true)
+	case "chmod":
+		err = s.chmod(args)
+	case "rename":
+		if len(args) != 2 {
+			return false, errors.New("usage: rename <old> <new>")
+		}
+		err = s.Client.PosixRename(s.remote(args[0]), s.remote(args[1]))
+		if err != nil {
+			err = fmt.Errorf("rename %s: %v", args[0], err)
+		}
+	default:
+		err = fmt.Errorf("unknown command %q, type help for a list", words[0])
+	}
+	return
+}
+
+// splitWords splits a command line into words at blanks. Quotes group
+// words with blanks and a backslash escapes the next character.
+func splitWords(line string) (words []string, err error) {
+	var word strings.Builder
+	inWord := false
+	var quote rune
+	escaped := false
+	for _, c := range line {
+		switch {
+		case escaped:
+			word.WriteRune(c)
+			escaped = false
+		case c == '\\' && quote != '\'':
+			escaped, inWord = true, true
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			} else {
+				word.WriteRune(c)
+			}
+		case c == '"' || c == '\'':
+			quote, inWord = c, true
+		case c == ' ' || c == '\t':
+			if inWord {
+				words = append(words, word.String())
+				word.Reset()
+				inWord = false
+			}
+		default:
+			word.WriteRune(c)
+			inWord = true
+		}
+	}
+	if quote != 0 {
+		return nil, errors.New("unterminated quote")
+	}
+	if inWord {
+		words = append(words, word.String())
+	}
+	return
+}
+
+// shellOptions takes the leading -x options in allowed off args.
+func shellOptions(args []string, allowed string) (set map[byte]bool, rest []string, err error) {
+	set = map[byte]bool{}
+	for len(args) > 0 && len(args[0]) > 1 && args[0][0] == '-' {
+		if args[0] == "--" {
+			args = args[1:]
+			break
+		}
+		for i := 1; i < len(args[0]); i++ {
+			if !strings.ContainsRune(allowed, rune(args[0][i])) {
+				return nil, nil, fmt.Errorf("unknown option -%c", args[0][i])
+			}
+			set[args[0][i]] = true
+		}
+		args = args[1:]
+	}
+	return set, args, nil
+}
+
+// remote resolves p against the remote directory; ~ is the home directory.
+func (s *SFTPShell) remote(p string) string {
+	switch {
+	case p == "~":
+		return s.home
+	case strings.HasPrefix(p, "~/"):
+		p = path.Join(s.home, p[2:])
+	case !path.IsAbs(p):
+		p = path.Join(s.cwd, p)
+	}
+	return path.Clean(p)
+}
+
+// remoteGlob expands the remote pattern p. A pattern that matches nothing
+// is an error.
+func (s *SFTPShell) remoteGlob(p string) (matches []string, err error) {
+	name := s.remote(p)
+	if !hasGlobMeta(p) {
+		return []string{name}, nil
+	}
+	if matches, err = s.Client.Glob(name); err != nil {
+		return nil, fmt.Errorf("%s: %v", p, err)
+	}
+	if len(matches) == 0 {
+		return nil, fmt.Errorf("%s: no match", p)
+	}
+	return
+}
+
+// localGlob expands the local pattern p like remoteGlob.
+func localGlob(p string) (matches []string, err error) {
+	name, err := ExpandPath(p)
+	if err != nil || !hasGlobMeta(p) {
+		return []string{name}, err
+	}
+	if matches, err = filepath.Glob(name); err != nil {
+		return nil, fmt.Errorf("%s: %v", p, err)
+	}
+	if len(matches) == 0 {
+		return nil, fmt.Errorf("%s: no match", p)
+	}
+	return
+}
+
+func (s *SFTPShell) cd(args []string) (err error) {
+	if len(args) > 1 {
+		return errors.New("usage: cd [path]")
+	}
+	dir := s.home
+	if len(args) == 1 {
+		dir = s.remote(args[0])
+	}
+	info, err := s.Client.Stat(dir)
+	if err != nil {
+		return fmt.Errorf("cd %s: %v", dir, err)
+	}
+	if !info.IsDir() {
+		return fmt.Errorf("cd %s: not a directory", dir)
+	}
+	s.cwd = dir
+	return
+}
+
+func (s *SFTPShell) lcd(args []string) (err error) {
+	if len(args) > 1 {
+		return errors.New("usage: lcd [path]")
+	}
+	dir := "~"
+	if len(args) == 1 {
+		dir = args[0]
+	}
+	if dir, err = ExpandPath(dir); err != nil {
+		return
+	}
+	return os.Chdir(dir)
+}
+
+func (s *SFTPShell) ls(args []string) (err error) {
+	opts, args, err := shellOptions(args, "la")
+	if err != nil {
+		return
+	}
+	if len(args) == 0 {
+		args = []string{"."}
+	}
+	var files []os.FileInfo
+	var names []string
+	for _, arg := range args {
+		matches, errs := s.remoteGlob(arg)
+		if errs != nil {
+			return errs
+		}
+		for _, match := range matches {
+			info, errs := s.Client.Stat(match)
+			if errs != nil {
+				return fmt.Errorf("ls %s: %v", match, errs)
+			}
+			// 目录列出其内容，通配符匹配到的目录只列出自身
+			if !info.IsDir() || hasGlobMeta(arg) {
+				files = append(files, info)
+				names = append(names, strings.TrimPrefix(match, s.cwd+"/"))
+				continue
+			}
+			entries, errs := s.Client.ReadDir(match)
+			if errs != nil {
+				return fmt.Errorf("ls %s: %v", match, errs)
+			}
+			sort.Slice(entries, func(i, j int) bool { return entries[i].Name() < entries[j].Name() })
+			for _, entry := range entries {
+				if opts['a'] || !strings.HasPrefix(entry.Name(), ".") {
+					files = append(files, entry)
+					names = append(names, entry.Name())
+				}
+			}
+		}
+	}
+
+	if opts['l'] {
+		for i, info := range files {
+			fmt.Fprintf(s.Stdout, "%s %10d %s %s\n", info.Mode(), info.Size(), info.ModTime().Format("2006-01-02 15:04"), names[i])
+		}
+		return
+	}
+	for i, info := range files {
+		if info.IsDir() {
+			names[i] += "/"
+		}
+	}
+	printColumns(s.Stdout, names, s.width)
+	return
+}
+
+// printColumns prints names in as many columns as fit in width.
+func printColumns(w io.Writer, names []string, width int) {
+	longest := 0
+	for _, name := range names {
+		longest = max(longest, len(name))
+	}
+	columns := max(width/(longest+2), 1)
+	rows := (len(names) + columns - 1) / columns
+	for row := 0; row < rows; row++ {
+		var line strings.Builder
+		for i := row; i < len(names); i += rows {
+			fmt.Fprintf(&line, "%-*s", longest+2, names[i])
+		}
+		fmt.Fprintln(w, strings.TrimRight(line.String(), " "))
+	}
+}
+
+// transfer runs get (from the server) or put (to it). With more than one
+// argument the last is the destination; otherwise it is the current
+// directory on the other side.
+func (s *SFTPShell) transfer(put bool, args []string) (err error) {
+	opts, args, err := shellOptions(args, "rp")
+	if err != nil {
+		return
+	}
+	if len(args) == 0 {
+		if put {
+			return errors.New("usage: put [-r] [-p] <local>... [remote]")
+		}
+		return errors.New("usage: get [-r] [-p] <remote>... [local]")
+	}
+	patterns, dst := args, ""
+	if len(args) > 1 {
+		patterns, dst = args[:len(args)-1], args[len(args)-1]
+	}
+	var sources []string
+	for _, pattern := range patterns {
+		var matches []string
+		if put {
+			matches, err = localGlob(pattern)
+		} else {
+			matches, err = s.remoteGlob(pattern)
+		}
+		if err != nil {
+			return
+		}
+		sources = append(sources, matches...)
+	}
+	switch {
+	case put && dst == "":
+		dst = s.cwd + "/"
+	case put:
+		dst = s.remote(dst)
+		if len(sources) > 1 || strings.HasSuffix(args[len(args)-1], "/") {
+			dst += "/"
+		}
+	case dst == "":
+		dst = "." + string(filepath.Separator)
+	default:
+		if dst, err = ExpandPath(dst); err != nil {
+			return
+		}
+		if len(sources) > 1 {
+			dst += string(filepath.Separator)
+		}
+	}
+
+	for _, src := range sources {
+		transfer := TransferOptions{
+			Recursive:     opts['r'],
+			PreserveTimes: opts['p'],
+			Progress:      &TransferProgress{},
+			Log: func(format string, args ...any) {
+				fmt.Fprintf(s.Stderr, format+"\n", args...)
+			},
+		}
+		stop := func() {}
+		if s.Progress != nil {
+			stop = s.Progress(transfer.Progress)
+		}
+		start := time.Now()
+		if put {
+			fmt.Fprintf(s.Stdout, "Uploading %s to %s\n", src, dst)
+			err = Upload(s.Client, src, dst, transfer)
+		} else {
+			fmt.Fprintf(s.Stdout, "Fetching %s to %s\n", src, dst)
+			err = Download(s.Client, src, dst, transfer)
+		}
+		stop()
+		if err != nil {
+			return
+		}
+		done, elapsed := float64(transfer.Progress.Done()), time.Since(start)
+		fmt.Fprintf(s.Stdout, "%s in %s (%s/s)\n", FormatBytes(done), elapsed.Round(time.Millisecond), FormatBytes(done/max(elapsed.Seconds(), 0.001)))
+	}
+	return
+}
+
+func (s *SFTPShell) mkdir(args []string) (err error) {
+	opts, args, err := shellOptions(args, "p")
+	if err != nil {
+		return
+	}
+	if len(args) == 0 {
+		return errors.New("usage: mkdir [-p] <path>...")
+	}
+	for _, arg := range args {
+		if opts['p'] {
+			err = s.Client.MkdirAll(s.remote(arg))
+		} else {
+			err = s.Client.Mkdir(s.remote(arg))
+		}
+		if err != nil {
+			return fmt.Errorf("mkdir %s: %v", arg, err)
+		}
+	}
+	return
+}
+
+// remove runs rm, or rmdir when dirs is set.
+func (s *SFTPShell) remove(args []string, dirs bool) (err error) {
+	if len(args) == 0 {
+		if dirs {
+			return errors.New("usage: rmdir <path>...")
+		}
+		return errors.New("usage: rm <path>...")
+	}
+	for _, arg := range args {
+		matches, errs := s.remoteGlob(arg)
+		if errs != nil {
+			return errs
+		}
+		for _, match := range matches {
+			if dirs {
+				err = s.Client.RemoveDirectory(match)
+			} else if info, errs := s.Client.Lstat(match); errs == nil && info.IsDir() {
+				err = errors.New("is a directory, use rmdir")
+			} else {
+				err = s.Client.Remove(match)
+			}
+			if err != nil {
+				return fmt.Errorf("remove %s: %v", match, err)
+			}
+			fmt.Fprintf(s.Stdout, "Removed %s\n", match)
+		}
+	}
+	return
+}
+
+func (s *SFTPShell) chmod(args []string) (err error) {
+	if len(args) < 2 {
+		return errors.New("usage: chmod <mode> <path>...")
+	}
+	mode, err := strconv.ParseUint(args[0], 8, 32)
+	if err != nil || mode > 0o7777 {
+		return fmt.Errorf("invalid mode %q, use octal like 644", args[0])
+	}
+	for _, arg := range args[1:] {
+		matches, errs := s.remoteGlob(arg)
+		if errs != nil {
+			return errs
+		}
+		for _, match := range matches {
+			if err = s.Client.Chmod(match, os.FileMode(mode)); err != nil {
+				return fmt.Errorf("chmod %s: %v", match, err)
+			}
+		}
+	}
+	return
+}
+
+// complete is the tab completion of the terminal: commands as the first
+// word, local paths for lcd and the first argument of put, remote paths
+// otherwise. Ambiguous completions are extended to their common prefix,
+// and listed when there is nothing to add.
+func (s *SFTPShell) complete(line string, pos int, key rune) (newLine string, newPos int, ok bool) {
+	if key != '\t' {
+		return
+	}
+	head := line[:pos]
+	start := strings.LastIndexAny(head, " \t") + 1
+	word := head[start:]
+	before := strings.Fields(head[:start])
+
+	var candidates []string
+	if len(before) == 0 {
+		for _, command := range sftpCommands {
+			if strings.HasPrefix(command, word) {
+				candidates = append(candidates, command+" ")
+			}
+		}
+	} else {
+		args := 0
+		for _, arg := range before[1:] {
+			if !strings.HasPrefix(arg, "-") {
+				args++
+			}
+		}
+		local := before[0] == "lcd" || before[0] == "put" && args == 0 || before[0] == "get" && args > 0
+		candidates = s.completePath(word, local)
+	}
+	if len(candidates) == 0 {
+		return line, pos, true
+	}
+
+	completion := candidates[0]
+	for _, c := range candidates[1:] {
+		completion = commonPrefix(completion, c)
+	}
+	if len(completion) <= len(word) && len(candidates) > 1 {
+		names := make([]string, len(candidates))
+		for i, c := range candidates {
+			names[i] = strings.TrimSpace(c[strings.LastIndex(strings.TrimSuffix(c, "/"), "/")+1:])
+		}
+		printColumns(s.term, names, s.width)
+		return line, pos, true
+	}
+	return head[:start] + completion + line[pos:], start + len(completion), true
+}
+
+// completePath returns the paths that complete word: directories end in
+// "/" and files in a space, so the next word can follow.
+func (s *SFTPShell) completePath(word string, local bool) (candidates []string) {
+	dir, prefix := "", word
+	if i := strings.LastIndex(word, "/"); i >= 0 {
+		dir, prefix = word[:i+1], word[i+1:]
+	}
+	var entries []os.FileInfo
+	if local {
+		name, err := ".", error(nil)
+		if dir != "" {
+			if name, err = ExpandPath(dir); err != nil {
+				return
+			}
+		}
+		files, err := os.ReadDir(name)
+		if err != nil {
+			return
+		}
+		for _, file := range files {
+			if info, err := os.Stat(filepath.Join(name, file.Name())); err == nil {
+				entries = append(entries, info)
+			}
+		}
+	} else {
+		var err error
+		if entries, err = s.Client.ReadDir(s.remote(dir + ".")); err != nil {
+			return
+		}
+	}
+	for _, entry := range entries {
+		name := entry.Name()
+		if !strings.HasPrefix(name, prefix) || strings.HasPrefix(name, ".") && !strings.HasPrefix(prefix, ".") {
+			continue
+		}
+		if entry.IsDir() {
+			candidates = append(candidates, dir+name+"/")
+		} else {
+			candidates = append(candidates, dir+name+" ")
+		}
+	}
+	sort.Strings(candidates)
+	return
+}
+
+// commonPrefix returns the longest common prefix of a and b.
+func commonPrefix(a, b string) string {
+	i := 0
+	for i < len(a) && i < len(b) && a[i] == b[i] {
+		i++
+	}
+	// 不截断多字节字符
+	for i > 0 && i < len(a) && !utf8.RuneStart(a[i]) {
+		i--
+	}
+	return a[:i]
+}
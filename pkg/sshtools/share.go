@@ -0,0 +1,250 @@
+package sshtools
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"sync"
+	"time"
+)
+
+// DefaultScrollback is how much recent output a newly connected observer
+// receives for context.
+const DefaultScrollback = 64 << 10
+
+const (
+	// observerQueue bounds the writes buffered per observer; one that falls
+	// further behind is disconnected rather than slowing the session.
+	observerQueue = 256
+	// observerWriteTimeout drops observers that stop reading.
+	observerWriteTimeout = 5 * time.Second
+)
+
+// Share mirrors a terminal session to observers connecting on a unix
+// socket. Writes never block: each observer has a bounded queue and is
+// dropped when it overflows.
+type Share struct {
+	// ReadWrite forwards observer keystrokes to the session.
+	ReadWrite bool
+	// Scrollback is the size of the replay buffer sent to new observers.
+	Scrollback int
+	// Title is shown to observers when they connect.
+	Title string
+
+	path      string
+	listener  net.Listener
+	mu        sync.Mutex
+	ring      []byte
+	observers map[*observer]struct{}
+	input     chan []byte
+	done      chan struct{}
+}
+
+type observer struct {
+	conn  net.Conn
+	queue chan []byte
+}
+
+// ListenShare creates the socket at path, readable and writable by the
+// owner and group so a colleague in the same group can connect.
+func ListenShare(path string) (s *Share, err error) {
+	if _, errs := os.Stat(path); errs == nil {
+		// Remove a socket left behind by a crashed session, but never
+		// steal one that is live.
+		if conn, errs := net.Dial("unix", path); errs == nil {
+			_ = conn.Close()
+			err = fmt.Errorf("share socket %s is already in use", path)
+			return
+		}
+		_ = os.Remove(path)
+	}
+	listener, err := net.Listen("unix", path)
+	if err != nil {
+		err = fmt.Errorf("failed to listen on %s: %v", path, err)
+		return
+	}
+	if err = os.Chmod(path, 0o660); err != nil {
+		_ = listener.Close()
+		return
+	}
+	s = &Share{
+		Scrollback: DefaultScrollback,
+		path:       path,
+		listener:   listener,
+		observers:  map[*observer]struct{}{},
+		input:      make(chan []byte, observerQueue),
+		done:       make(chan struct{}),
+	}
+	go s.accept()
+	return
+}
+
+// Path returns the socket path observers connect to.
+func (s *Share) Path() string {
+	return s.path
+}
+
+// Input delivers keystrokes from observers in read-write mode.
+func (s *Share) Input() <-chan []byte {
+	return s.input
+}
+
+// Write copies p to the scrollback and to every observer's queue.
+func (s *Share) Write(p []byte) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.ring = append(s.ring, p...)
+	if over := len(s.ring) - s.Scrollback; over > 0 {
+		s.ring = append(s.ring[:0], s.ring[over:]...)
+	}
+	for o := range s.observers {
+		select {
+		case o.queue <- append([]byte(nil), p...):
+		default:
+			s.drop(o)
+		}
+	}
+	return len(p), nil
+}
+
+// Close disconnects all observers and removes the socket.
+func (s *Share) Close() error {
+	s.mu.Lock()
+	select {
+	case <-s.done:
+		s.mu.Unlock()
+		return nil
+	default:
+	}
+	close(s.done)
+	for o := range s.observers {
+		select {
+		case o.queue <- []byte("\r\n[sshtools] shared session ended\r\n"):
+		default:
+		}
+		delete(s.observers, o)
+		close(o.queue)
+	}
+	s.mu.Unlock()
+	err := s.listener.Close()
+	_ = os.Remove(s.path)
+	return err
+}
+
+// drop disconnects o; s.mu must be held.
+func (s *Share) drop(o *observer) {
+	delete(s.observers, o)
+	close(o.queue)
+	_ = o.conn.Close()
+}
+
+func (s *Share) accept() {
+	for {
+		conn, err := s.listener.Accept()
+		if err != nil {
+			return
+		}
+		mode := "read-only"
+		if s.ReadWrite {
+			mode = "read-write"
+		}
+		o := &observer{conn: conn, queue: make(chan []byte, observerQueue)}
+		s.mu.Lock()
+		o.queue <- []byte(fmt.Sprintf("[sshtools] watching %s (%s), type ~. to detach\r\n", s.Title, mode))
+		o.queue <- append([]byte(nil), s.ring...)
+		s.observers[o] = struct{}{}
+		s.mu.Unlock()
+
+		go s.send(o)
+		go s.receive(o)
+	}
+}
+
+// send drains o's queue onto its connection.
+func (s *Share) send(o *observer) {
+	defer func() { _ = o.conn.Close() }()
+	for p := range o.queue {
+		_ = o.conn.SetWriteDeadline(time.Now().Add(observerWriteTimeout))
+		if _, err := o.conn.Write(p); err != nil {
+			s.mu.Lock()
+			if _, ok := s.observers[o]; ok {
+				s.drop(o)
+			}
+			s.mu.Unlock()
+			return
+		}
+	}
+}
+
+// receive forwards observer input in read-write mode and discards it
+// otherwise, until the observer disconnects.
+func (s *Share) receive(o *observer) {
+	buf := make([]byte, 1024)
+	for {
+		n, err := o.conn.Read(buf)
+		if n > 0 && s.ReadWrite {
+			select {
+			case s.input <- append([]byte(nil), buf[:n]...):
+			case <-s.done:
+				return
+			}
+		}
+		if err != nil {
+			s.mu.Lock()
+			if _, ok := s.observers[o]; ok {
+				s.drop(o)
+			}
+			s.mu.Unlock()
+			return
+		}
+	}
+}
+
+// WatchShare connects to a shared session at path, copying it to stdout
+// and, when stdin is a terminal, sending keystrokes (which the sharer only
+// honours in read-write mode). Typing ~. at the start of a line detaches.
+func WatchShare(path string, stdin io.Reader, stdout io.Writer) (err error) {
+	conn, err := net.Dial("unix", path)
+	if err != nil {
+		err = fmt.Errorf("failed to connect to shared session %s: %v", path, err)
+		return
+	}
+	defer func() { _ = conn.Close() }()
+
+	if fd, ok := terminalFd(stdin); ok {
+		state, errs := MakeRaw(fd)
+		if errs != nil {
+			err = errs
+			return
+		}
+		defer func() { _ = Restore(fd, state) }()
+
+		go func() {
+			var escape escapeState
+			buf := make([]byte, 1024)
+			for {
+				n, errs := stdin.Read(buf)
+				var out []byte
+				for _, b := range buf[:n] {
+					p, cmd := escape.feed(b)
+					if cmd == '.' {
+						_ = conn.Close()
+						return
+					}
+					out = append(out, p...)
+				}
+				if _, errw := conn.Write(out); errs != nil || errw != nil {
+					return
+				}
+			}
+		}()
+	}
+
+	_, err = io.Copy(stdout, conn)
+	if errors.Is(err, net.ErrClosed) {
+		err = nil
+	}
+	return
+}
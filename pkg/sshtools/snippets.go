@@ -0,0 +1,63 @@
+package sshtools
+
+import (
+	"fmt"
+	"maps"
+	"slices"
+	"strings"
+	"text/template"
+)
+
+// CommandNames returns the names of the configured command templates,
+// sorted.
+func (c *Config) CommandNames() []string {
+	return slices.Sorted(maps.Keys(c.Commands))
+}
+
+// CommandVars returns the values a command template sees for server: the
+// built-in alias, user and host, then the tag_vars of its tags (the first
+// tag that sets a name wins), then vars given on the command line.
+func (c *Config) CommandVars(server *Server, vars map[string]string) map[string]string {
+	values := map[string]string{
+		"alias": server.Alias,
+		"user":  server.User,
+		"host":  server.Address,
+	}
+	for i := len(server.Tags) - 1; i >= 0; i-- {
+		maps.Copy(values, c.TagVars[server.Tags[i]])
+	}
+	maps.Copy(values, vars)
+	return values
+}
+
+// RenderCommand expands the command template name for server with vars.
+// A name the template uses without a value is an error, rather than an
+// empty word in a command that then does something else.
+func (c *Config) RenderCommand(name string, server *Server, vars map[string]string) (command string, err error) {
+	tmpl, err := parseCommand(name, c.Commands[name])
+	if err != nil {
+		return
+	}
+	var b strings.Builder
+	if err = tmpl.Execute(&b, c.CommandVars(server, vars)); err != nil {
+		// 只保留缺少的变量名，不带模板内部的位置信息
+		if _, missing, ok := strings.Cut(err.Error(), "map has no entry for key "); ok {
+			return "", fmt.Errorf("command %q on %s needs a value for %s, pass %s=... or set it in tag_vars", name, server.Alias, missing, strings.Trim(missing, `"`))
+		}
+		return "", fmt.Errorf("command %q on %s: %v", name, server.Alias, err)
+	}
+	return b.String(), nil
+}
+
+// parseCommand parses a command template. {{quote .name}} quotes a value
+// as one shell word.
+func parseCommand(name, text string) (*template.Template, error) {
+	if text == "" {
+		return nil, fmt.Errorf("no command %q in the config", name)
+	}
+	tmpl, err := template.New(name).Option("missingkey=error").Funcs(template.FuncMap{"quote": ShellQuote}).Parse(text)
+	if err != nil {
+		return nil, fmt.Errorf("command %q: %v", name, err)
+	}
+	return tmpl, nil
+}
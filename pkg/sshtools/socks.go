@@ -0,0 +1,186 @@
+package sshtools
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// SOCKS5 protocol values (RFC 1928).
+const (
+	socksVersion    = 5
+	socksNoAuth     = 0
+	socksNoMethods  = 0xff
+	socksCmdConnect = 1
+
+	socksIPv4   = 1
+	socksDomain = 3
+	socksIPv6   = 4
+
+	socksSucceeded           = 0
+	socksHostUnreachable     = 4
+	socksCommandNotSupported = 7
+	socksAddressNotSupported = 8
+)
+
+// socksHandshakeTimeout bounds how long a client may take to say where it
+// wants to go.
+const socksHandshakeTimeout = 30 * time.Second
+
+// ParseDynamicSpec parses an ssh -D style spec, [bind:]port, into the
+// address to listen on. Without a bind address it is the bare port, which
+// LocalBindAddress resolves.
+func ParseDynamicSpec(spec string) (addr string, err error) {
+	port := spec
+	if i := strings.LastIndex(spec, ":"); i >= 0 {
+		port = spec[i+1:]
+	}
+	if _, errs := net.LookupPort("tcp", port); errs != nil {
+		return "", fmt.Errorf("invalid dynamic forward %q, want [bind:]port", spec)
+	}
+	if port == spec {
+		return port, nil
+	}
+	return net.JoinHostPort(strings.TrimSuffix(strings.TrimPrefix(spec[:len(spec)-len(port)-1], "["), "]"), port), nil
+}
+
+// DynamicForward runs a SOCKS5 proxy on localAddr that opens every
+// requested connection through the SSH connection, like ssh -D. Closing
+// the returned listener stops accepting new connections.
+func (c *Client) DynamicForward(localAddr string) (listener net.Listener, err error) {
+	localAddr = c.Server.LocalBindAddress(localAddr)
+	listener, err = net.Listen("tcp", localAddr)
+	if errors.Is(err, syscall.EADDRINUSE) {
+		err = fmt.Errorf("local port %s: %w", localAddr, ErrPortInUse)
+		return
+	}
+	if err != nil {
+		err = fmt.Errorf("failed to listen on %s: %v", localAddr, err)
+		return
+	}
+	go func() {
+		for {
+			conn, errs := listener.Accept()
+			if errs != nil {
+				return
+			}
+			go func(local net.Conn) {
+				remote, target, errs := socksConnect(local, c.Dial)
+				if errs != nil {
+					fmt.Fprintf(os.Stderr, "socks %s -> %s: %v\n", localAddr, target, errs)
+					_ = local.Close()
+					return
+				}
+				pipe(local, remote)
+			}(conn)
+		}
+	}()
+	return
+}
+
+// socksConnect reads a client's SOCKS5 greeting and CONNECT request from
+// conn, opens the connection with dial and tells the client how it went.
+// target is the address asked for, when the request got that far.
+func socksConnect(conn net.Conn, dial func(network, addr string) (net.Conn, error)) (remote net.Conn, target string, err error) {
+	_ = conn.SetDeadline(time.Now().Add(socksHandshakeTimeout))
+	target, code, err := socksRequest(conn)
+	if err != nil {
+		if code != socksSucceeded {
+			_ = socksReply(conn, code)
+		}
+		return
+	}
+	if remote, err = dial("tcp", target); err != nil {
+		_ = socksReply(conn, socksHostUnreachable)
+		return
+	}
+	if err = socksReply(conn, socksSucceeded); err != nil {
+		_ = remote.Close()
+		return
+	}
+	_ = conn.SetDeadline(time.Time{})
+	return
+}
+
+// socksRequest reads the greeting and request. code is the reply to send
+// when err is set, or socksSucceeded when the client should just be hung
+// up on.
+func socksRequest(conn net.Conn) (target string, code byte, err error) {
+	// 问候：版本、方法数量、方法列表，只支持无认证
+	header := make([]byte, 2)
+	if _, err = io.ReadFull(conn, header); err != nil {
+		return
+	}
+	if header[0] != socksVersion {
+		return "", socksSucceeded, fmt.Errorf("not a SOCKS5 client (version %d)", header[0])
+	}
+	methods := make([]byte, header[1])
+	if _, err = io.ReadFull(conn, methods); err != nil {
+		return
+	}
+	method := byte(socksNoMethods)
+	for _, m := range methods {
+		if m == socksNoAuth {
+			method = socksNoAuth
+		}
+	}
+	if _, err = conn.Write([]byte{socksVersion, method}); err != nil {
+		return
+	}
+	if method == socksNoMethods {
+		return "", socksSucceeded, fmt.Errorf("client requires authentication, which is not supported")
+	}
+
+	// 请求：版本、命令、保留字节、地址类型
+	request := make([]byte, 4)
+	if _, err = io.ReadFull(conn, request); err != nil {
+		return
+	}
+	var host string
+	switch request[3] {
+	case socksIPv4, socksIPv6:
+		ip := make(net.IP, net.IPv4len)
+		if request[3] == socksIPv6 {
+			ip = make(net.IP, net.IPv6len)
+		}
+		if _, err = io.ReadFull(conn, ip); err != nil {
+			return
+		}
+		host = ip.String()
+	case socksDomain:
+		length := make([]byte, 1)
+		if _, err = io.ReadFull(conn, length); err != nil {
+			return
+		}
+		name := make([]byte, length[0])
+		if _, err = io.ReadFull(conn, name); err != nil {
+			return
+		}
+		host = string(name)
+	default:
+		return "", socksAddressNotSupported, fmt.Errorf("unsupported address type %d", request[3])
+	}
+	port := make([]byte, 2)
+	if _, err = io.ReadFull(conn, port); err != nil {
+		return
+	}
+	target = net.JoinHostPort(host, strconv.Itoa(int(binary.BigEndian.Uint16(port))))
+	if request[1] != socksCmdConnect {
+		return target, socksCommandNotSupported, fmt.Errorf("unsupported command %d, only CONNECT is", request[1])
+	}
+	return target, socksSucceeded, nil
+}
+
+// socksReply answers a request. The bound address is not meaningful for
+// connections made by the server, so it is always 0.0.0.0:0.
+func socksReply(conn net.Conn, code byte) error {
+	_, err := conn.Write([]byte{socksVersion, code, 0, socksIPv4, 0, 0, 0, 0, 0, 0})
+	return err
+}
@@ -0,0 +1,326 @@
+package sshtools
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"os/user"
+	"path"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// DefaultSSHConfig is the OpenSSH client config imported when no other
+// file is given.
+const DefaultSSHConfig = "~/.ssh/config"
+
+// sshConfigBlock is a Host section of an OpenSSH client config. Settings
+// before the first Host apply to every host; Match sections never do, as
+// their conditions cannot be evaluated here.
+type sshConfigBlock struct {
+	patterns []string
+	match    bool
+	settings [][2]string
+}
+
+// matches reports whether the block applies to alias: one of its patterns
+// matches and none of its negated ones does.
+func (b *sshConfigBlock) matches(alias string) bool {
+	if b.match {
+		return false
+	}
+	if b.patterns == nil {
+		return true
+	}
+	matched := false
+	for _, pattern := range b.patterns {
+		negated := strings.HasPrefix(pattern, "!")
+		ok, _ := path.Match(strings.TrimPrefix(pattern, "!"), alias)
+		if ok && negated {
+			return false
+		}
+		matched = matched || ok && !negated
+	}
+	return matched
+}
+
+// ImportSSHConfig reads an OpenSSH client config and returns a server for
+// every Host name without wildcards, from its HostName, User, Port,
+// IdentityFile, ProxyJump, Compression and PreferredAuthentications. As in ssh, the first value found for each
+// setting wins. Jump hosts given as [user@]host[:port] rather than a Host
+// name are returned as servers of their own, named by that spec.
+func ImportSSHConfig(filename string) (servers []Server, err error) {
+	filename, err = ExpandPath(filename)
+	if err != nil {
+		return
+	}
+	blocks, err := parseSSHConfig(filename, []*sshConfigBlock{{}}, 0)
+	if err != nil {
+		return
+	}
+
+	var aliases []string
+	seen := map[string]bool{}
+	for _, b := range blocks {
+		for _, pattern := range b.patterns {
+			if !isGlob(pattern) && !strings.HasPrefix(pattern, "!") && !seen[foldAlias(pattern)] {
+				seen[foldAlias(pattern)] = true
+				aliases = append(aliases, pattern)
+			}
+		}
+	}
+
+	var jumps []string
+	for _, alias := range aliases {
+		server, errs := sshConfigServer(blocks, alias)
+		if errs != nil {
+			return nil, fmt.Errorf("%s: Host %s: %v", filename, alias, errs)
+		}
+		for _, jump := range jumpAliases(server.ProxyJump) {
+			if !seen[foldAlias(jump)] {
+				seen[foldAlias(jump)] = true
+				jumps = append(jumps, jump)
+			}
+		}
+		servers = append(servers, *server)
+	}
+	for _, jump := range jumps {
+		server, errs := ParseTarget(jump)
+		if errs != nil {
+			return nil, fmt.Errorf("%s: ProxyJump %s: %v", filename, jump, errs)
+		}
+		servers = append(servers, *server)
+	}
+	return
+}
+
+// sshConfigServer builds the server for alias from the blocks that apply.
+func sshConfigServer(blocks []*sshConfigBlock, alias string) (server *Server, err error) {
+	values := map[string]string{}
+	for _, b := range blocks {
+		if !b.matches(alias) {
+			continue
+		}
+		for _, kv := range b.settings {
+			if _, ok := values[kv[0]]; !ok {
+				values[kv[0]] = kv[1]
+			}
+		}
+	}
+
+	server = &Server{Alias: alias, Address: alias}
+	if hostname := values["hostname"]; hostname != "" {
+		server.Address = strings.ReplaceAll(hostname, "%h", alias)
+	}
+	if server.User = values["user"]; server.User == "" {
+		local, errs := user.Current()
+		if errs != nil {
+			return nil, fmt.Errorf("failed to get local username: %v", errs)
+		}
+		server.User = local.Username
+	}
+	server.Port = defaultPort
+	if port := values["port"]; port != "" {
+		if server.Port, err = strconv.Atoi(port); err != nil {
+			return nil, fmt.Errorf("invalid Port %q", port)
+		}
+	}
+	if identity := values["identityfile"]; identity != "" && !strings.EqualFold(identity, "none") {
+		server.PrivateKey = strings.NewReplacer("%d", "~", "%u", server.User, "%r", server.User,
+			"%h", server.Address, "%%", "%").Replace(identity)
+		server.UseKey = true
+	}
+	// PreferredAuthentications 的 publickey 包括 ssh-agent 中的密钥，不支持的方式忽略
+	if preferred := values["preferredauthentications"]; preferred != "" {
+		for _, method := range strings.Split(preferred, ",") {
+			switch method = strings.TrimSpace(method); method {
+			case AuthPublicKey:
+				server.AuthMethods = append(server.AuthMethods, AuthAgent, AuthPublicKey)
+			case AuthKeyboardInteractive, AuthPassword:
+				server.AuthMethods = append(server.AuthMethods, method)
+			}
+		}
+	}
+	server.Compression = strings.EqualFold(values["compression"], "yes")
+	if jump := values["proxyjump"]; jump != "" && !strings.EqualFold(jump, "none") {
+		server.ProxyJump = jump
+	} else if command := values["proxycommand"]; command != "" && !strings.EqualFold(command, "none") {
+		server.ProxyCommand = command
+	}
+	return
+}
+
+// parseSSHConfig appends the blocks of filename to blocks, following
+// Include directives.
+func parseSSHConfig(filename string, blocks []*sshConfigBlock, depth int) ([]*sshConfigBlock, error) {
+	if depth > 16 {
+		return nil, fmt.Errorf("%s: too many nested Include directives", filename)
+	}
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer func(f *os.File) {
+		_ = f.Close()
+	}(f)
+
+	scanner := bufio.NewScanner(f)
+	for line := 1; scanner.Scan(); line++ {
+		keyword, args, rest := splitSSHConfigLine(scanner.Text())
+		if keyword == "" {
+			continue
+		}
+		if len(args) == 0 {
+			return nil, fmt.Errorf("%s:%d: %s has no value", filename, line, keyword)
+		}
+		switch keyword {
+		case "host":
+			blocks = append(blocks, &sshConfigBlock{patterns: args})
+		case "match":
+			blocks = append(blocks, &sshConfigBlock{match: true})
+		case "include":
+			// 相对路径相对于所在文件的目录，即 ~/.ssh
+			for _, arg := range args {
+				pattern, errs := ExpandPath(arg)
+				if errs != nil {
+					return nil, fmt.Errorf("%s:%d: %v", filename, line, errs)
+				}
+				if !filepath.IsAbs(pattern) {
+					pattern = filepath.Join(filepath.Dir(filename), pattern)
+				}
+				files, _ := filepath.Glob(pattern)
+				for _, file := range files {
+					if blocks, err = parseSSHConfig(file, blocks, depth+1); err != nil {
+						return nil, err
+					}
+				}
+			}
+		default:
+			value := strings.Join(args, " ")
+			if keyword == "proxycommand" {
+				// ssh 把 ProxyCommand 原样交给 shell，引号要保留
+				value = rest
+			}
+			b := blocks[len(blocks)-1]
+			b.settings = append(b.settings, [2]string{keyword, value})
+		}
+	}
+	if err = scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read %s: %v", filename, err)
+	}
+	return blocks, nil
+}
+
+// splitSSHConfigLine returns the lowercased keyword and the arguments of a
+// config line, which may separate them with "=" and quote arguments, and the
+// arguments as written.
+func splitSSHConfigLine(line string) (keyword string, args []string, rest string) {
+	line = strings.TrimSpace(line)
+	if line == "" || strings.HasPrefix(line, "#") {
+		return
+	}
+	end := strings.IndexAny(line, " \t=")
+	if end < 0 {
+		return strings.ToLower(line), nil, ""
+	}
+	keyword, line = strings.ToLower(line[:end]), strings.TrimSpace(line[end:])
+	line = strings.TrimSpace(strings.TrimPrefix(line, "="))
+	rest = line
+
+	var arg strings.Builder
+	quoted, inArg := false, false
+	for _, r := range line {
+		switch {
+		case r == '"':
+			quoted, inArg = !quoted, true
+		case !quoted && (r == ' ' || r == '\t'):
+			if inArg {
+				args = append(args, arg.String())
+				arg.Reset()
+				inArg = false
+			}
+		default:
+			arg.WriteRune(r)
+			inArg = true
+		}
+	}
+	if inArg {
+		args = append(args, arg.String())
+	}
+	return
+}
+
+// ExportSSHConfig writes servers as Host blocks of an OpenSSH client config
+// with their HostName, User, Port, IdentityFile, CertificateFile, ProxyJump
+// and ProxyCommand (and PreferredAuthentications, ForwardAgent and Compression when set), so ssh, scp, rsync and editors reach them by alias.
+// Servers reached through Session Manager get the aws ssm start-session
+// ProxyCommand.
+// Secrets are never written; ssh asks for passwords itself.
+func ExportSSHConfig(w io.Writer, servers []*Server) (err error) {
+	var out strings.Builder
+	for i, s := range servers {
+		if i > 0 {
+			out.WriteString("\n")
+		}
+		fmt.Fprintf(&out, "Host %s\n", sshConfigValue(s.Alias))
+		// ssh 只能连接一个地址，其余地址作为注释保留
+		addresses := s.AddressList()
+		fmt.Fprintf(&out, "    HostName %s\n", sshConfigValue(addresses[0]))
+		if len(addresses) > 1 {
+			fmt.Fprintf(&out, "    # also at %s\n", strings.Join(addresses[1:], ", "))
+		}
+		fmt.Fprintf(&out, "    User %s\n", sshConfigValue(s.User))
+		fmt.Fprintf(&out, "    Port %d\n", s.Port)
+		if s.UseKey && s.PrivateKey != "" {
+			// IdentityFile 会展开 %，字面的 % 需要写成 %%
+			fmt.Fprintf(&out, "    IdentityFile %s\n", sshConfigValue(strings.ReplaceAll(s.PrivateKey, "%", "%%")))
+		}
+		if s.Certificate != "" {
+			fmt.Fprintf(&out, "    CertificateFile %s\n", sshConfigValue(strings.ReplaceAll(s.Certificate, "%", "%%")))
+		}
+		if s.ProxyJump != "" {
+			fmt.Fprintf(&out, "    ProxyJump %s\n", s.ProxyJump)
+		} else if s.ProxyCommand != "" {
+			fmt.Fprintf(&out, "    ProxyCommand %s\n", s.ProxyCommand)
+		} else if s.UsesSSM() {
+			fmt.Fprintf(&out, "    ProxyCommand %s\n", ssmProxyCommand(s))
+		}
+		if len(s.AuthMethods) > 0 {
+			fmt.Fprintf(&out, "    PreferredAuthentications %s\n", preferredAuthentications(s.AuthMethods))
+		}
+		if s.ForwardAgent {
+			out.WriteString("    ForwardAgent yes\n")
+		}
+		if s.Compression {
+			out.WriteString("    Compression yes\n")
+		}
+	}
+	_, err = io.WriteString(w, out.String())
+	return
+}
+
+// preferredAuthentications returns methods as OpenSSH names them, where
+// publickey covers the agent.
+func preferredAuthentications(methods []string) string {
+	var names []string
+	for _, method := range methods {
+		if method == AuthAgent {
+			method = AuthPublicKey
+		}
+		if !slices.Contains(names, method) {
+			names = append(names, method)
+		}
+	}
+	return strings.Join(names, ",")
+}
+
+// sshConfigValue quotes value for an OpenSSH config when it has spaces.
+func sshConfigValue(value string) string {
+	if strings.ContainsAny(value, " \t") {
+		return `"` + value + `"`
+	}
+	return value
+}
@@ -0,0 +1,372 @@
+package sshtools
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// verify_host_key_dns modes.
+const (
+	// DNSVerifyNo does not look up SSHFP records (default).
+	DNSVerifyNo = "no"
+	// DNSVerifyYes trusts a host key that matches a DNSSEC-authenticated
+	// SSHFP record and refuses one that contradicts them; without usable
+	// records known_hosts decides.
+	DNSVerifyYes = "yes"
+	// DNSVerifyRequire accepts only a host key that matches an
+	// authenticated SSHFP record, in place of known_hosts.
+	DNSVerifyRequire = "require"
+)
+
+// sshfpTimeout bounds one DNS exchange.
+const sshfpTimeout = 5 * time.Second
+
+// DNS wire format constants used by the SSHFP lookup.
+const (
+	dnsTypeSSHFP = 44
+	dnsTypeOPT   = 41
+	dnsClassIN   = 1
+	// 标志位：RD 请求递归，TC 截断，AD 已通过 DNSSEC 验证
+	dnsFlagRD = 1 << 8
+	dnsFlagTC = 1 << 9
+	dnsFlagAD = 1 << 5
+	// EDNS0 的 DO 位请求 DNSSEC 数据
+	dnsFlagDO      = 1 << 15
+	dnsUDPSize     = 1232
+	dnsRcodeNXName = 3
+)
+
+// SSHFP algorithm numbers (RFC 4255, 6594, 7479) and fingerprint types.
+var sshfpAlgorithms = map[string]byte{
+	ssh.KeyAlgoRSA:      1,
+	ssh.KeyAlgoDSA:      2,
+	ssh.KeyAlgoECDSA256: 3,
+	ssh.KeyAlgoECDSA384: 3,
+	ssh.KeyAlgoECDSA521: 3,
+	ssh.KeyAlgoED25519:  4,
+}
+
+const (
+	sshfpSHA1   = 1
+	sshfpSHA256 = 2
+)
+
+func validDNSVerify(mode string) bool {
+	switch mode {
+	case "", DNSVerifyNo, DNSVerifyYes, DNSVerifyRequire:
+		return true
+	}
+	return false
+}
+
+// SSHFPRecord is one SSHFP resource record.
+type SSHFPRecord struct {
+	Algorithm   byte
+	Type        byte
+	Fingerprint []byte
+}
+
+func (r SSHFPRecord) String() string {
+	return fmt.Sprintf("%d %d %x", r.Algorithm, r.Type, r.Fingerprint)
+}
+
+// SSHFPRecords returns the SSHFP lines of key for a zone file, as
+// ssh-keygen -r prints them.
+func SSHFPRecords(name string, key ssh.PublicKey) (lines []string) {
+	algorithm, ok := sshfpAlgorithms[key.Type()]
+	if !ok {
+		return
+	}
+	sum1 := sha1.Sum(key.Marshal())
+	sum256 := sha256.Sum256(key.Marshal())
+	for _, r := range []SSHFPRecord{{algorithm, sshfpSHA1, sum1[:]}, {algorithm, sshfpSHA256, sum256[:]}} {
+		lines = append(lines, fmt.Sprintf("%s IN SSHFP %s", name, r))
+	}
+	return
+}
+
+// matches reports whether the record is for key's algorithm and, if so,
+// whether its fingerprint is that of key.
+func (r SSHFPRecord) matches(key ssh.PublicKey) (sameAlgorithm, match bool) {
+	if algorithm, ok := sshfpAlgorithms[key.Type()]; !ok || algorithm != r.Algorithm {
+		return false, false
+	}
+	switch r.Type {
+	case sshfpSHA1:
+		sum := sha1.Sum(key.Marshal())
+		return true, bytes.Equal(sum[:], r.Fingerprint)
+	case sshfpSHA256:
+		sum := sha256.Sum256(key.Marshal())
+		return true, bytes.Equal(sum[:], r.Fingerprint)
+	}
+	// 未知的指纹类型不参与比较
+	return false, false
+}
+
+// SSHFPMismatchError is returned when a server presents a host key that
+// contradicts the authenticated SSHFP records of its name.
+type SSHFPMismatchError struct {
+	Alias   string
+	Name    string
+	Key     ssh.PublicKey
+	Records []SSHFPRecord
+}
+
+func (e *SSHFPMismatchError) Error() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "WARNING: the host key of %s does not match the SSHFP records of %s, refusing to connect.\n", e.Alias, e.Name)
+	b.WriteString("Someone could be intercepting the connection, or the DNS records are out of date.\n")
+	fmt.Fprintf(&b, "  offered: %s %s\n", e.Key.Type(), ssh.FingerprintSHA256(e.Key))
+	for _, r := range e.Records {
+		fmt.Fprintf(&b, "  in DNS:  SSHFP %s\n", r)
+	}
+	return strings.TrimSuffix(b.String(), "\n")
+}
+
+// LookupSSHFP asks resolver (host:port, or the first nameserver of
+// /etc/resolv.conf when empty) for the SSHFP records of name. secure tells
+// whether the resolver vouched for the answer with the DNSSEC AD flag; the
+// flag is only worth as much as the path to the resolver, so it should be
+// a validating resolver on this machine or the local network.
+func LookupSSHFP(resolver, name string) (records []SSHFPRecord, secure bool, err error) {
+	if resolver == "" {
+		if resolver, err = systemResolver(); err != nil {
+			return
+		}
+	}
+	if _, _, errs := net.SplitHostPort(resolver); errs != nil {
+		resolver = net.JoinHostPort(resolver, "53")
+	}
+	query, id, err := dnsQuery(name, dnsTypeSSHFP)
+	if err != nil {
+		return
+	}
+	response, err := dnsExchange("udp", resolver, query)
+	if err != nil {
+		return
+	}
+	// 应答被截断时改用 TCP 重新查询
+	if len(response) >= 4 && binary.BigEndian.Uint16(response[2:])&dnsFlagTC != 0 {
+		if response, err = dnsExchange("tcp", resolver, query); err != nil {
+			return
+		}
+	}
+	return parseSSHFPResponse(response, id)
+}
+
+// systemResolver returns the first nameserver in /etc/resolv.conf.
+func systemResolver() (string, error) {
+	f, err := os.Open("/etc/resolv.conf")
+	if err != nil {
+		return "", fmt.Errorf(`no DNS resolver for SSHFP lookups, set "dns_resolver": %v`, err)
+	}
+	defer func() { _ = f.Close() }()
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) >= 2 && fields[0] == "nameserver" {
+			return net.JoinHostPort(fields[1], "53"), nil
+		}
+	}
+	return "", fmt.Errorf(`no nameserver in /etc/resolv.conf for SSHFP lookups, set "dns_resolver"`)
+}
+
+// dnsQuery builds a recursive query for name and qtype with an EDNS0 OPT
+// record that sets the DO bit, and returns it with its ID.
+func dnsQuery(name string, qtype uint16) (query []byte, id uint16, err error) {
+	var idBytes [2]byte
+	if _, err = rand.Read(idBytes[:]); err != nil {
+		return
+	}
+	id = binary.BigEndian.Uint16(idBytes[:])
+	query = binary.BigEndian.AppendUint16(query, id)
+	// 设置 AD 位表示希望得到验证结果（RFC 6840）
+	query = binary.BigEndian.AppendUint16(query, dnsFlagRD|dnsFlagAD)
+	query = binary.BigEndian.AppendUint16(query, 1) // QDCOUNT
+	query = binary.BigEndian.AppendUint16(query, 0) // ANCOUNT
+	query = binary.BigEndian.AppendUint16(query, 0) // NSCOUNT
+	query = binary.BigEndian.AppendUint16(query, 1) // ARCOUNT
+	for _, label := range strings.Split(strings.TrimSuffix(name, "."), ".") {
+		if label == "" || len(label) > 63 {
+			return nil, 0, fmt.Errorf("invalid DNS name %q", name)
+		}
+		query = append(query, byte(len(label)))
+		query = append(query, label...)
+	}
+	query = append(query, 0)
+	query = binary.BigEndian.AppendUint16(query, qtype)
+	query = binary.BigEndian.AppendUint16(query, dnsClassIN)
+	// OPT：根域名、类型 41、CLASS 为 UDP 大小、TTL 中带 DO 位
+	query = append(query, 0)
+	query = binary.BigEndian.AppendUint16(query, dnsTypeOPT)
+	query = binary.BigEndian.AppendUint16(query, dnsUDPSize)
+	query = binary.BigEndian.AppendUint32(query, dnsFlagDO)
+	query = binary.BigEndian.AppendUint16(query, 0)
+	return
+}
+
+// dnsExchange sends query to server over network ("udp" or "tcp") and
+// returns the response.
+func dnsExchange(network, server string, query []byte) (response []byte, err error) {
+	conn, err := net.DialTimeout(network, server, sshfpTimeout)
+	if err != nil {
+		return nil, fmt.Errorf("failed to reach DNS resolver %s: %v", server, err)
+	}
+	defer func() { _ = conn.Close() }()
+	_ = conn.SetDeadline(time.Now().Add(sshfpTimeout))
+	if network == "tcp" {
+		query = append(binary.BigEndian.AppendUint16(nil, uint16(len(query))), query...)
+	}
+	if _, err = conn.Write(query); err != nil {
+		return nil, fmt.Errorf("failed to query DNS resolver %s: %v", server, err)
+	}
+	if network == "tcp" {
+		var size [2]byte
+		if _, err = io.ReadFull(conn, size[:]); err != nil {
+			return nil, fmt.Errorf("failed to read from DNS resolver %s: %v", server, err)
+		}
+		response = make([]byte, binary.BigEndian.Uint16(size[:]))
+		if _, err = io.ReadFull(conn, response); err != nil {
+			return nil, fmt.Errorf("failed to read from DNS resolver %s: %v", server, err)
+		}
+		return
+	}
+	response = make([]byte, 64<<10)
+	n, err := conn.Read(response)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read from DNS resolver %s: %v", server, err)
+	}
+	return response[:n], nil
+}
+
+var errDNSShort = errors.New("truncated DNS response")
+
+// parseSSHFPResponse returns the SSHFP answers of a response to the query
+// with id.
+func parseSSHFPResponse(msg []byte, id uint16) (records []SSHFPRecord, secure bool, err error) {
+	if len(msg) < 12 {
+		return nil, false, errDNSShort
+	}
+	if binary.BigEndian.Uint16(msg) != id {
+		return nil, false, errors.New("DNS response does not answer our query")
+	}
+	flags := binary.BigEndian.Uint16(msg[2:])
+	secure = flags&dnsFlagAD != 0
+	switch rcode := flags & 0xf; rcode {
+	case 0:
+	case dnsRcodeNXName:
+		return
+	default:
+		return nil, false, fmt.Errorf("DNS lookup failed with rcode %d", rcode)
+	}
+	questions := int(binary.BigEndian.Uint16(msg[4:]))
+	answers := int(binary.BigEndian.Uint16(msg[6:]))
+	off := 12
+	for range questions {
+		if off, err = skipDNSName(msg, off); err != nil {
+			return
+		}
+		off += 4
+	}
+	for range answers {
+		if off, err = skipDNSName(msg, off); err != nil {
+			return
+		}
+		if off+10 > len(msg) {
+			return nil, false, errDNSShort
+		}
+		rtype := binary.BigEndian.Uint16(msg[off:])
+		length := int(binary.BigEndian.Uint16(msg[off+8:]))
+		off += 10
+		if off+length > len(msg) {
+			return nil, false, errDNSShort
+		}
+		// CNAME 和 RRSIG 等其他类型的记录直接跳过
+		if rtype == dnsTypeSSHFP && length > 2 {
+			rdata := msg[off : off+length]
+			records = append(records, SSHFPRecord{Algorithm: rdata[0], Type: rdata[1], Fingerprint: append([]byte(nil), rdata[2:]...)})
+		}
+		off += length
+	}
+	return
+}
+
+// skipDNSName returns the offset after the possibly compressed name at off.
+func skipDNSName(msg []byte, off int) (int, error) {
+	for {
+		if off >= len(msg) {
+			return 0, errDNSShort
+		}
+		n := int(msg[off])
+		switch {
+		case n == 0:
+			return off + 1, nil
+		case n&0xc0 == 0xc0:
+			// 压缩指针占两个字节，名字到此结束
+			return off + 2, nil
+		}
+		off += 1 + n
+	}
+}
+
+// verifySSHFP makes sshConfig check the host key of server against the
+// SSHFP records of its address, before the known_hosts check it wraps.
+func (d *Dialer) verifySSHFP(sshConfig *ssh.ClientConfig, server *Server) {
+	fallback := sshConfig.HostKeyCallback
+	require := server.VerifyHostKeyDNS == DNSVerifyRequire
+	sshConfig.HostKeyCallback = func(hostname string, remote net.Addr, key ssh.PublicKey) error {
+		plain := plainKey(key)
+		name := server.Address
+		if net.ParseIP(name) != nil {
+			if require {
+				return fmt.Errorf("verify_host_key_dns is %q but %s has an IP address, which has no SSHFP records", DNSVerifyRequire, server.Alias)
+			}
+			return fallback(hostname, remote, key)
+		}
+		records, secure, err := LookupSSHFP(server.DNSResolver, name)
+		switch {
+		case err != nil && require:
+			return fmt.Errorf("failed to look up the SSHFP records of %s: %v", name, err)
+		case err != nil:
+			d.Logf(1, "SSHFP lookup for %s failed, checking known_hosts: %v", name, err)
+			return fallback(hostname, remote, key)
+		case !secure && require:
+			return fmt.Errorf("the SSHFP records of %s are not DNSSEC-authenticated (no AD flag from the resolver)", name)
+		case !secure:
+			d.Logf(1, "ignoring %d SSHFP record(s) of %s that are not DNSSEC-authenticated", len(records), name)
+			return fallback(hostname, remote, key)
+		}
+		var relevant []SSHFPRecord
+		for _, r := range records {
+			same, match := r.matches(plain)
+			if match {
+				d.Logf(1, "host key %s matches a DNSSEC-authenticated SSHFP record of %s", ssh.FingerprintSHA256(plain), name)
+				return nil
+			}
+			if same {
+				relevant = append(relevant, r)
+			}
+		}
+		if len(relevant) > 0 {
+			return &SSHFPMismatchError{Alias: server.Alias, Name: name, Key: plain, Records: relevant}
+		}
+		if require {
+			return fmt.Errorf("%s has no SSHFP record for %s host keys", name, plain.Type())
+		}
+		d.Logf(1, "%s has no SSHFP record for %s host keys, checking known_hosts", name, plain.Type())
+		return fallback(hostname, remote, key)
+	}
+}
@@ -0,0 +1,116 @@
+package sshtools
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os/exec"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	awsconfig "github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/service/ssm"
+)
+
+// Transports in "transport": plain TCP (the default, optionally through a
+// proxy, proxy command, WebSocket gateway or jump host) or AWS Systems
+// Manager Session Manager.
+const (
+	TransportTCP = "tcp"
+	TransportSSM = "ssm"
+)
+
+// ssmDocument is the Session Manager document that connects a session to
+// the instance's SSH port.
+const ssmDocument = "AWS-StartSSHSession"
+
+// ssmPlugin is the AWS program that speaks the Session Manager data
+// channel; the AWS CLI runs it the same way.
+const ssmPlugin = "session-manager-plugin"
+
+// UsesSSM reports whether s is reached through Session Manager.
+func (s *Server) UsesSSM() bool {
+	return s.Transport == TransportSSM
+}
+
+// awsConfig loads the AWS credentials and region for s.
+func (s *Server) awsConfig(ctx context.Context) (cfg aws.Config, err error) {
+	var options []func(*awsconfig.LoadOptions) error
+	if s.AWSRegion != "" {
+		options = append(options, awsconfig.WithRegion(s.AWSRegion))
+	}
+	if s.AWSProfile != "" {
+		options = append(options, awsconfig.WithSharedConfigProfile(s.AWSProfile))
+	}
+	if cfg, err = awsconfig.LoadDefaultConfig(ctx, options...); err == nil && cfg.Region == "" {
+		err = errors.New(`no AWS region, set "aws_region" or AWS_REGION`)
+	}
+	return
+}
+
+// ssmEndpoint is the Systems Manager endpoint of region, which the plugin
+// needs to renew the session.
+func ssmEndpoint(region string) string {
+	if strings.HasPrefix(region, "cn-") {
+		return "https://ssm." + region + ".amazonaws.com.cn"
+	}
+	return "https://ssm." + region + ".amazonaws.com"
+}
+
+// ssmProxyCommand is the OpenSSH ProxyCommand that reaches s through
+// Session Manager with the AWS CLI.
+func ssmProxyCommand(s *Server) string {
+	command := fmt.Sprintf("aws ssm start-session --target %s --document-name %s --parameters portNumber=%%p", s.InstanceID, ssmDocument)
+	if s.AWSRegion != "" {
+		command += " --region " + s.AWSRegion
+	}
+	if s.AWSProfile != "" {
+		command += " --profile " + s.AWSProfile
+	}
+	return command
+}
+
+// dialSSM starts a Session Manager session to the SSH port of the server's
+// instance and returns a connection over session-manager-plugin's stdio.
+// The instance needs the SSM agent and a role that allows Session Manager;
+// its SSH port does not have to be reachable.
+func dialSSM(server *Server, timeout time.Duration) (conn *proxyConn, err error) {
+	plugin, err := exec.LookPath(ssmPlugin)
+	if err != nil {
+		return nil, fmt.Errorf("%s not found, install the Session Manager plugin: https://docs.aws.amazon.com/systems-manager/latest/userguide/session-manager-working-with-install-plugin.html", ssmPlugin)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	cfg, err := server.awsConfig(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("ssm: %v", err)
+	}
+	input := &ssm.StartSessionInput{
+		Target:       aws.String(server.InstanceID),
+		DocumentName: aws.String(ssmDocument),
+		Parameters:   map[string][]string{"portNumber": {strconv.Itoa(server.Port)}},
+	}
+	output, err := ssm.NewFromConfig(cfg).StartSession(ctx, input)
+	if err != nil {
+		return nil, fmt.Errorf("ssm: failed to start a session to %s: %v", server.InstanceID, err)
+	}
+
+	// 参数与 aws ssm start-session 传给插件的相同：会话、区域、操作、配置名、请求和端点
+	session, err := json.Marshal(map[string]string{
+		"SessionId":  aws.ToString(output.SessionId),
+		"TokenValue": aws.ToString(output.TokenValue),
+		"StreamUrl":  aws.ToString(output.StreamUrl),
+	})
+	if err != nil {
+		return
+	}
+	request, err := json.Marshal(map[string]any{"Target": server.InstanceID, "DocumentName": ssmDocument, "Parameters": input.Parameters})
+	if err != nil {
+		return
+	}
+	cmd := exec.Command(plugin, string(session), cfg.Region, "StartSession", server.AWSProfile, string(request), ssmEndpoint(cfg.Region))
+	return startProxy(fmt.Sprintf("%s %s", ssmPlugin, server.InstanceID), cmd)
+}
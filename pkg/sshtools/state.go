@@ -0,0 +1,54 @@
+package sshtools
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// staleLock is the age after which a lock file is assumed to be left over
+// from a crashed process.
+const staleLock = 10 * time.Second
+
+// StateDir returns ~/.sshtools/<sub...>, creating it with owner-only
+// permissions. Sockets, pid files and other local state live here.
+func StateDir(sub ...string) (dir string, err error) {
+	homeDir, err := getHomeDir()
+	if err != nil {
+		err = fmt.Errorf("failed to get home directory: %v", err)
+		return
+	}
+	dir = filepath.Join(append([]string{homeDir, ".sshtools"}, sub...)...)
+	if err = os.MkdirAll(dir, 0o700); err != nil {
+		err = fmt.Errorf("failed to create state directory %s: %v", dir, err)
+	}
+	return
+}
+
+// lockState locks file against other processes by creating file.lock
+// exclusively, waiting a few seconds for a current holder. The returned
+// func releases the lock.
+func lockState(file string) (unlock func(), err error) {
+	lock := file + ".lock"
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		f, errs := os.OpenFile(lock, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0o600)
+		if errs == nil {
+			_ = f.Close()
+			return func() { _ = os.Remove(lock) }, nil
+		}
+		if !errors.Is(errs, os.ErrExist) {
+			return nil, fmt.Errorf("failed to lock %s: %v", file, errs)
+		}
+		if info, errs := os.Stat(lock); errs == nil && time.Since(info.ModTime()) > staleLock {
+			_ = os.Remove(lock)
+			continue
+		}
+		if time.Now().After(deadline) {
+			return nil, fmt.Errorf("timed out waiting for %s", lock)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
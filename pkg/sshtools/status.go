@@ -0,0 +1,172 @@
+package sshtools
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// ServerStatus is whether a server's SSH port answered at one point in time.
+type ServerStatus struct {
+	Address   string    `json:"address"`
+	Reachable bool      `json:"reachable"`
+	Error     string    `json:"error,omitempty"`
+	Checked   time.Time `json:"checked"`
+	// LatencyMs is how long the banner took to arrive and Version what it
+	// said.
+	LatencyMs float64 `json:"latency_ms,omitempty"`
+	Version   string  `json:"version,omitempty"`
+	// LoginError is set when CheckServer was asked to log in and could
+	// not; Output is what the command it ran printed.
+	LoginError string `json:"login_error,omitempty"`
+	Output     string `json:"output,omitempty"`
+}
+
+// CheckServer probes server like Probe. With login it also logs in and
+// runs command, when not empty, recording its trimmed output.
+func (d *Dialer) CheckServer(server *Server, login bool, command string) (status ServerStatus) {
+	status = ServerStatus{Address: server.Addr(), Checked: time.Now()}
+	res, err := d.Probe(server)
+	if err != nil {
+		status.Error = err.Error()
+		return
+	}
+	status.Reachable = true
+	status.LatencyMs = float64(res.Banner.Microseconds()) / 1000
+	status.Version = res.Version
+	if !login {
+		return
+	}
+
+	client, err := d.Dial(server)
+	if err != nil {
+		status.LoginError = err.Error()
+		return
+	}
+	defer func() { _ = client.Close() }()
+	if command != "" {
+		out, errs := client.Output(command)
+		if errs != nil {
+			status.LoginError = fmt.Sprintf("%s failed: %v", command, errs)
+		}
+		status.Output = strings.TrimSpace(string(out))
+	}
+	return
+}
+
+// StatusSnapshot holds the last known status of every server of a config,
+// by alias.
+type StatusSnapshot struct {
+	Config  string                  `json:"config"`
+	Servers map[string]ServerStatus `json:"servers"`
+}
+
+// Kinds of StatusChange.
+const (
+	StatusDown    = "down"
+	StatusUp      = "up"
+	StatusAdded   = "added"
+	StatusRemoved = "removed"
+)
+
+// StatusChange is a transition between two snapshots.
+type StatusChange struct {
+	Alias string
+	Kind  string
+	// Now is the new status; the zero value for removed servers.
+	Now ServerStatus
+}
+
+// Regressed reports whether the change is a server that stopped answering.
+func (c StatusChange) Regressed() bool {
+	return c.Kind == StatusDown
+}
+
+// statusPath returns the cache file for a config file: one per profile, so
+// separate configs don't see each other's servers as removed.
+func statusPath(configFile string) (string, error) {
+	abs, err := filepath.Abs(configFile)
+	if err != nil {
+		return "", err
+	}
+	dir, err := StateDir("status")
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, SHA256([]byte(abs))[:16]+".json"), nil
+}
+
+// LoadStatus reads the snapshot saved for configFile; a first run gets an
+// empty one.
+func LoadStatus(configFile string) (snap *StatusSnapshot, err error) {
+	snap = &StatusSnapshot{Config: configFile, Servers: map[string]ServerStatus{}}
+	file, err := statusPath(configFile)
+	if err != nil {
+		return
+	}
+	data, err := os.ReadFile(file)
+	if errors.Is(err, os.ErrNotExist) {
+		return snap, nil
+	}
+	if err != nil {
+		return
+	}
+	if err = json.Unmarshal(data, snap); err != nil {
+		err = fmt.Errorf("corrupt status cache %s: %v", file, err)
+	}
+	if snap.Servers == nil {
+		snap.Servers = map[string]ServerStatus{}
+	}
+	return
+}
+
+// Save writes the snapshot for its config file.
+func (s *StatusSnapshot) Save() (err error) {
+	file, err := statusPath(s.Config)
+	if err != nil {
+		return
+	}
+	data, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return
+	}
+	tmp := file + ".tmp"
+	if err = os.WriteFile(tmp, data, 0o600); err == nil {
+		err = os.Rename(tmp, file)
+	}
+	if err != nil {
+		err = fmt.Errorf("failed to save status cache: %v", err)
+	}
+	return
+}
+
+// Update records the statuses just checked and drops servers that are no
+// longer in config. It returns the transitions, sorted by alias. Servers
+// that were not checked this time keep their previous status.
+func (s *StatusSnapshot) Update(config *Config, checked map[string]ServerStatus) (changes []StatusChange) {
+	for alias, now := range checked {
+		was, known := s.Servers[alias]
+		switch {
+		case !known:
+			changes = append(changes, StatusChange{Alias: alias, Kind: StatusAdded, Now: now})
+		case was.Reachable && !now.Reachable:
+			changes = append(changes, StatusChange{Alias: alias, Kind: StatusDown, Now: now})
+		case !was.Reachable && now.Reachable:
+			changes = append(changes, StatusChange{Alias: alias, Kind: StatusUp, Now: now})
+		}
+		s.Servers[alias] = now
+	}
+	for alias := range s.Servers {
+		if config.ServerByAlias(alias) == nil {
+			changes = append(changes, StatusChange{Alias: alias, Kind: StatusRemoved})
+			delete(s.Servers, alias)
+		}
+	}
+	sort.Slice(changes, func(i, j int) bool { return changes[i].Alias < changes[j].Alias })
+	return
+}
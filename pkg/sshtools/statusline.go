@@ -0,0 +1,112 @@
+package sshtools
+
+import (
+	"fmt"
+	"io"
+	"regexp"
+	"strings"
+	"sync"
+	"unicode/utf8"
+)
+
+// statusReset matches output that undoes the status line: scroll region
+// changes, full resets, clearing the screen and switching screens.
+var statusReset = regexp.MustCompile(`\x1b\[[0-9;]*r|\x1bc|\x1b\[[23]?J|\x1b\[\?(1049|1047|47)[hl]`)
+
+// statusLine keeps a line of text on the last row of the local terminal.
+// The rows above form a scroll region, which is what the remote PTY is
+// told it has, so remote output never reaches the last row.
+type statusLine struct {
+	w    io.Writer
+	text string
+	// style is the SGR parameters the line is drawn with.
+	style string
+
+	mu            sync.Mutex
+	width, height int
+}
+
+// newStatusLine returns a status line showing text in reverse video of
+// color, or nil when the terminal is too small to spare a row.
+func newStatusLine(w io.Writer, text, color string, width, height int) *statusLine {
+	if height < 3 {
+		return nil
+	}
+	s := &statusLine{w: w, text: text, style: labelStyle(color), width: width, height: height}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	// 光标在最后一行时先上滚一行，否则原地不动
+	_, _ = io.WriteString(w, "\n\x1b[A")
+	s.draw()
+	return s
+}
+
+// rows returns the height the remote PTY is given for a local terminal of
+// height rows.
+func (s *statusLine) rows(height int) int {
+	if s == nil {
+		return height
+	}
+	return height - 1
+}
+
+// draw sets the scroll region and paints the last row, leaving the cursor
+// where it was. The caller holds s.mu.
+func (s *statusLine) draw() {
+	text := s.text
+	if n := utf8.RuneCountInString(text); n > s.width {
+		text = string([]rune(text)[:s.width])
+	} else {
+		text += strings.Repeat(" ", s.width-n)
+	}
+	_, _ = fmt.Fprintf(s.w, "\x1b7\x1b[1;%dr\x1b[%d;1H\x1b[2K\x1b[%sm%s\x1b[0m\x1b8", s.height-1, s.height, s.style, text)
+}
+
+// resize redraws the line for a new terminal size.
+func (s *statusLine) resize(width, height int) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	// 缩小时旧的状态行可能留在屏幕中间，先清除
+	_, _ = fmt.Fprintf(s.w, "\x1b7\x1b[%d;1H\x1b[2K\x1b8", min(s.height, height))
+	s.width, s.height = width, height
+	s.draw()
+}
+
+// clear gives the whole terminal back: the scroll region is reset and the
+// last row blanked.
+func (s *statusLine) clear() {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	_, _ = fmt.Fprintf(s.w, "\x1b7\x1b[r\x1b[%d;1H\x1b[2K\x1b8", s.height)
+}
+
+// writer returns w drawing the status line again after output that undid
+// it. Writes are serialized with drawing, so escape sequences don't
+// interleave.
+func (s *statusLine) writer(w io.Writer) io.Writer {
+	if s == nil {
+		return w
+	}
+	return &statusWriter{s: s, w: w}
+}
+
+type statusWriter struct {
+	s *statusLine
+	w io.Writer
+}
+
+func (sw *statusWriter) Write(p []byte) (n int, err error) {
+	sw.s.mu.Lock()
+	defer sw.s.mu.Unlock()
+	n, err = sw.w.Write(p)
+	if statusReset.Match(p) {
+		sw.s.draw()
+	}
+	return
+}
@@ -0,0 +1,127 @@
+package sshtools
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+)
+
+// streamBufferSize is the read and write buffer size for session output.
+const streamBufferSize = 64 << 10
+
+// flushInterval bounds how long output may sit in a buffer.
+const flushInterval = 5 * time.Millisecond
+
+// streamBuffers are reused between copies.
+var streamBuffers = sync.Pool{
+	New: func() any {
+		buf := make([]byte, streamBufferSize)
+		return &buf
+	},
+}
+
+// flushWriter buffers writes to w and flushes them within flushInterval.
+type flushWriter struct {
+	mu    sync.Mutex
+	w     *bufio.Writer
+	timer *time.Timer
+}
+
+func newFlushWriter(w io.Writer) *flushWriter {
+	return &flushWriter{w: bufio.NewWriterSize(w, streamBufferSize)}
+}
+
+func (f *flushWriter) Write(p []byte) (n int, err error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	n, err = f.w.Write(p)
+	if f.w.Buffered() > 0 && f.timer == nil {
+		f.timer = time.AfterFunc(flushInterval, func() {
+			_ = f.Flush()
+		})
+	}
+	return
+}
+
+// Flush writes out whatever is buffered.
+func (f *flushWriter) Flush() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.timer != nil {
+		f.timer.Stop()
+		f.timer = nil
+	}
+	return f.w.Flush()
+}
+
+// streamCopy copies src to dst like io.Copy, but batches the writes to
+// dst. A short read means src has nothing more ready, so the output is
+// flushed at once and interactive use sees no delay; bulk output such as
+// cat of a large file goes out in large writes instead of one per read.
+func streamCopy(dst io.Writer, src io.Reader) (written int64, err error) {
+	bufp := streamBuffers.Get().(*[]byte)
+	defer streamBuffers.Put(bufp)
+	buf := *bufp
+
+	out := newFlushWriter(dst)
+	defer func() {
+		if errs := out.Flush(); err == nil {
+			err = errs
+		}
+	}()
+	for {
+		n, errs := src.Read(buf)
+		if n > 0 {
+			if _, err = out.Write(buf[:n]); err != nil {
+				return
+			}
+			written += int64(n)
+			if n < len(buf) {
+				if err = out.Flush(); err != nil {
+					return
+				}
+			}
+		}
+		if errs == io.EOF {
+			return
+		}
+		if errs != nil {
+			return written, errs
+		}
+	}
+}
+
+// Benchmark streams size bytes of zeros from the server through a session
+// and returns how long it took, to measure session throughput.
+func (c *Client) Benchmark(size int64) (elapsed time.Duration, err error) {
+	session, err := c.NewSession()
+	if err != nil {
+		return
+	}
+	defer func() {
+		_ = session.Close()
+	}()
+	stdout, err := session.StdoutPipe()
+	if err != nil {
+		return
+	}
+	blocks := (size + streamBufferSize - 1) / streamBufferSize
+	start := time.Now()
+	if err = session.Start(fmt.Sprintf("dd if=/dev/zero bs=%d count=%d 2>/dev/null", streamBufferSize, blocks)); err != nil {
+		return
+	}
+	written, err := streamCopy(io.Discard, stdout)
+	if err != nil {
+		return
+	}
+	if err = session.Wait(); err != nil {
+		return
+	}
+	elapsed = time.Since(start)
+	if written != blocks*streamBufferSize {
+		err = fmt.Errorf("received %d bytes, expected %d", written, blocks*streamBufferSize)
+	}
+	return
+}
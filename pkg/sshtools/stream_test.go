@@ -0,0 +1,87 @@
+package sshtools
+
+import (
+	"fmt"
+	"io"
+	"testing"
+)
+
+// streamTotal is how much each benchmark iteration copies.
+const streamTotal = 8 << 20
+
+// chunkReader returns total bytes in reads of at most chunk bytes, as a
+// session channel hands out whatever has arrived.
+type chunkReader struct {
+	chunk, remaining int
+}
+
+func (r *chunkReader) Read(p []byte) (int, error) {
+	if r.remaining == 0 {
+		return 0, io.EOF
+	}
+	n := min(len(p), r.chunk, r.remaining)
+	r.remaining -= n
+	return n, nil
+}
+
+// countingWriter counts the writes that reach the local output.
+type countingWriter struct {
+	writes int
+}
+
+func (w *countingWriter) Write(p []byte) (int, error) {
+	w.writes++
+	return len(p), nil
+}
+
+// readSizes are the read sizes benchmarked: typed input and prompts, small
+// and full SSH packets, and reads that fill the buffer.
+var readSizes = []int{64, 4 << 10, 32 << 10, streamBufferSize}
+
+// benchmarkCopy runs copy over each read size, reporting the writes it
+// made to the output per iteration.
+func benchmarkCopy(b *testing.B, copy func(io.Writer, io.Reader) (int64, error)) {
+	for _, size := range readSizes {
+		b.Run(fmt.Sprintf("read=%d", size), func(b *testing.B) {
+			b.ReportAllocs()
+			b.SetBytes(streamTotal)
+			var dst countingWriter
+			for b.Loop() {
+				written, err := copy(&dst, &chunkReader{chunk: size, remaining: streamTotal})
+				if err != nil || written != streamTotal {
+					b.Fatalf("copied %d bytes, %v", written, err)
+				}
+			}
+			b.ReportMetric(float64(dst.writes)/float64(b.N), "writes/op")
+		})
+	}
+}
+
+func BenchmarkStreamCopy(b *testing.B) {
+	benchmarkCopy(b, streamCopy)
+}
+
+// BenchmarkStreamCopyIOCopy is the unbuffered io.Copy streamCopy replaced,
+// for comparison.
+func BenchmarkStreamCopyIOCopy(b *testing.B) {
+	benchmarkCopy(b, func(dst io.Writer, src io.Reader) (int64, error) {
+		// 隐藏 ReaderFrom/WriterTo，与会话的复制方式相同
+		return io.Copy(struct{ io.Writer }{dst}, struct{ io.Reader }{src})
+	})
+}
+
+// BenchmarkStreamCopyParallel copies from many sessions at once, as a
+// dashboard or broadcast does, sharing the buffer pool.
+func BenchmarkStreamCopyParallel(b *testing.B) {
+	b.ReportAllocs()
+	b.SetBytes(streamTotal)
+	b.RunParallel(func(pb *testing.PB) {
+		var dst countingWriter
+		for pb.Next() {
+			if _, err := streamCopy(&dst, &chunkReader{chunk: 32 << 10, remaining: streamTotal}); err != nil {
+				b.Error(err)
+				return
+			}
+		}
+	})
+}
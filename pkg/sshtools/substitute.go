@@ -0,0 +1,94 @@
+package sshtools
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// envReference starts a reference to an environment variable in a config
+// value, e.g. "${env:PROD_PASS}".
+const envReference = "${env:"
+
+// HasReference reports whether a config value is resolved by Substitute:
+// it refers to environment variables or is a $(command).
+func HasReference(value string) bool {
+	return strings.Contains(value, envReference) || isCommandReference(value)
+}
+
+// isCommandReference reports whether value is a whole $(command).
+func isCommandReference(value string) bool {
+	return strings.HasPrefix(value, "$(") && strings.HasSuffix(value, ")")
+}
+
+// checkReference returns what is wrong with the references in value.
+func checkReference(value string) error {
+	if isCommandReference(value) {
+		if strings.TrimSpace(value[2:len(value)-1]) == "" {
+			return errors.New("empty $() command")
+		}
+		return nil
+	}
+	_, err := expandReferences(value, func(string) (string, bool) { return "", true })
+	return err
+}
+
+// expandReferences replaces every ${env:NAME} in value with lookup(NAME).
+func expandReferences(value string, lookup func(string) (string, bool)) (string, error) {
+	var b strings.Builder
+	for {
+		i := strings.Index(value, envReference)
+		if i < 0 {
+			b.WriteString(value)
+			return b.String(), nil
+		}
+		b.WriteString(value[:i])
+		value = value[i+len(envReference):]
+		end := strings.IndexByte(value, '}')
+		if end < 0 {
+			return "", fmt.Errorf("unterminated %s", envReference)
+		}
+		name := value[:end]
+		if name == "" {
+			return "", fmt.Errorf("empty %s}", envReference)
+		}
+		resolved, ok := lookup(name)
+		if !ok {
+			return "", fmt.Errorf("environment variable %s is not set", name)
+		}
+		b.WriteString(resolved)
+		value = value[end+1:]
+	}
+}
+
+// Substitute resolves the password and private_key of every server in
+// memory: ${env:NAME} is replaced with the environment variable and a
+// whole $(command) value with the first line the command prints. A command
+// used by several servers runs once. A server whose value cannot be
+// resolved fails when it is dialed, so the others remain usable.
+func (c *Config) Substitute() {
+	outputs, failures := map[string]string{}, map[string]error{}
+	for i := range c.Servers {
+		s := &c.Servers[i]
+		for j, value := range []*string{&s.Password, &s.PrivateKey} {
+			if !HasReference(*value) {
+				continue
+			}
+			var err error
+			if isCommandReference(*value) {
+				command := strings.TrimSpace((*value)[2 : len(*value)-1])
+				if _, ran := outputs[command]; !ran {
+					// 同一个命令只执行一次，如多台服务器共用的 pass show
+					outputs[command], failures[command] = runSecretCommand(command)
+				}
+				*value, err = outputs[command], failures[command]
+			} else {
+				*value, err = expandReferences(*value, os.LookupEnv)
+			}
+			if err != nil && s.unresolved == nil {
+				s.unresolved = &Error{Kind: ErrorConfig, Err: fmt.Errorf("cannot resolve %q of %s: %v", secretFields[j], s.Alias, err)}
+			}
+		}
+	}
+}
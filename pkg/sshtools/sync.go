@@ -0,0 +1,316 @@
+package sshtools
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/pkg/sftp"
+)
+
+// SyncOptions controls Sync.
+type SyncOptions struct {
+	// Delete removes remote files and directories that are not in the
+	// source. Excluded paths are never deleted.
+	Delete bool
+	// Exclude skips paths matching these patterns: a pattern without a
+	// slash matches any file or directory name, one with a slash the path
+	// from the top of the tree, and a trailing slash only directories.
+	Exclude []string
+	// Checksum compares the content of files of the same size instead of
+	// trusting a differing modification time.
+	Checksum bool
+	// DryRun reports the changes without making them.
+	DryRun bool
+	// Progress counts the bytes uploaded, when set.
+	Progress *TransferProgress
+	// Log reports each change and each skipped file.
+	Log func(format string, args ...any)
+}
+
+func (o *SyncOptions) logf(format string, args ...any) {
+	if o.Log != nil {
+		o.Log(format, args...)
+	}
+}
+
+// excluded reports whether the entry at rel, relative to the top of the
+// tree with slashes, matches an exclude pattern.
+func (o *SyncOptions) excluded(rel string, dir bool) bool {
+	for _, pattern := range o.Exclude {
+		if strings.HasSuffix(pattern, "/") {
+			if !dir {
+				continue
+			}
+			pattern = strings.TrimSuffix(pattern, "/")
+		}
+		name := path.Base(rel)
+		if strings.Contains(pattern, "/") {
+			name, pattern = rel, strings.TrimPrefix(pattern, "/")
+		}
+		if ok, _ := path.Match(pattern, name); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// SyncResult counts what Sync did.
+type SyncResult struct {
+	Uploaded  int
+	Deleted   int
+	Unchanged int
+	Bytes     int64
+}
+
+// Sync makes the remote directory dst a copy of the local directory src,
+// rsync-like: files are compared by size and modification time (and
+// content with Checksum) and only new or changed ones are uploaded, with
+// their times kept so the next run recognises them.
+func Sync(client *sftp.Client, src, dst string, opts SyncOptions) (result SyncResult, err error) {
+	dst = path.Clean(dst)
+	info, err := os.Stat(src)
+	if err != nil {
+		return
+	}
+	if !info.IsDir() {
+		return result, fmt.Errorf("%s is not a directory", src)
+	}
+	local, err := localTree(src, &opts)
+	if err != nil {
+		return
+	}
+	remote, err := remoteTree(client, dst, &opts)
+	if err != nil {
+		return
+	}
+
+	paths := make([]string, 0, len(local))
+	for rel := range local {
+		paths = append(paths, rel)
+	}
+	sort.Strings(paths)
+	changes := map[string]bool{}
+	var total int64
+	for _, rel := range paths {
+		if !local[rel].IsDir() && changed(client, src, dst, rel, local[rel], remote[rel], &opts) {
+			changes[rel] = true
+			total += local[rel].Size()
+		}
+	}
+	if opts.Progress != nil {
+		opts.Progress.total.Store(total)
+	}
+
+	// 先建目录再传文件，父目录总在子项之前
+	transfer := &TransferOptions{PreserveTimes: true, Progress: opts.Progress, Log: opts.Log}
+	for _, rel := range paths {
+		l, r := local[rel], remote[rel]
+		name := path.Join(dst, rel)
+		switch {
+		case r != nil && r.IsDir() != l.IsDir():
+			return result, fmt.Errorf("%s: a %s on the server but a %s locally, remove it first", name, kind(r), kind(l))
+		case l.IsDir() && r == nil:
+			opts.logf("create %s/", name)
+			if !opts.DryRun {
+				if err = client.MkdirAll(name); err != nil {
+					return result, fmt.Errorf("failed to create %s: %v", name, err)
+				}
+			}
+		case l.IsDir():
+		case !changes[rel]:
+			result.Unchanged++
+		default:
+			opts.logf("upload %s", name)
+			if !opts.DryRun {
+				if err = upload(client, filepath.Join(src, filepath.FromSlash(rel)), name, l, transfer); err != nil {
+					return
+				}
+			}
+			result.Uploaded++
+			result.Bytes += l.Size()
+		}
+	}
+
+	if opts.Delete {
+		var extra []string
+		for rel := range remote {
+			if local[rel] == nil {
+				extra = append(extra, rel)
+			}
+		}
+		// 逆序删除，目录中的内容先于目录本身
+		sort.Sort(sort.Reverse(sort.StringSlice(extra)))
+		for _, rel := range extra {
+			name := path.Join(dst, rel)
+			opts.logf("delete %s", name)
+			if !opts.DryRun {
+				if remote[rel].IsDir() {
+					err = client.RemoveDirectory(name)
+				} else {
+					err = client.Remove(name)
+				}
+				if err != nil {
+					return result, fmt.Errorf("failed to delete %s: %v", name, err)
+				}
+			}
+			result.Deleted++
+		}
+	}
+	// 目录的时间在写入内容之后才能保持
+	if !opts.DryRun {
+		for _, rel := range paths {
+			if l := local[rel]; l.IsDir() && rel != "." {
+				_ = setRemoteAttrs(client, path.Join(dst, rel), l, transfer)
+			}
+		}
+	}
+	return
+}
+
+// kind names what info is, for errors.
+func kind(info os.FileInfo) string {
+	if info.IsDir() {
+		return "directory"
+	}
+	return "file"
+}
+
+// changed reports whether the local file l differs from its remote copy r.
+// Same size and time means unchanged; with Checksum, files of the same
+// size are compared by content and only get their time fixed when equal.
+func changed(client *sftp.Client, src, dst, rel string, l, r os.FileInfo, opts *SyncOptions) bool {
+	if r == nil || r.Size() != l.Size() {
+		return true
+	}
+	if r.ModTime().Unix() == l.ModTime().Unix() && !opts.Checksum {
+		return false
+	}
+	if !opts.Checksum {
+		return true
+	}
+	localSum, err := fileSum(os.Open(filepath.Join(src, filepath.FromSlash(rel))))
+	if err != nil {
+		return true
+	}
+	remoteSum, err := fileSum(client.Open(path.Join(dst, rel)))
+	if err != nil || !bytes.Equal(localSum, remoteSum) {
+		return true
+	}
+	if r.ModTime().Unix() != l.ModTime().Unix() && !opts.DryRun {
+		_ = client.Chtimes(path.Join(dst, rel), l.ModTime(), l.ModTime())
+	}
+	return false
+}
+
+// fileSum returns the SHA-256 of an opened file and closes it.
+func fileSum(f io.ReadCloser, err error) (sum []byte, errs error) {
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = f.Close() }()
+	h := sha256.New()
+	if _, err = io.Copy(h, f); err != nil {
+		return nil, err
+	}
+	return h.Sum(nil), nil
+}
+
+// localTree lists the directories and regular files under src by their
+// slash-separated path relative to it; src itself is ".". Symlinks to
+// files are followed, other special files are skipped.
+func localTree(src string, opts *SyncOptions) (tree map[string]os.FileInfo, err error) {
+	tree = map[string]os.FileInfo{}
+	err = filepath.WalkDir(src, func(name string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(src, name)
+		if err != nil {
+			return err
+		}
+		rel = filepath.ToSlash(rel)
+		if rel != "." && opts.excluded(rel, entry.IsDir()) {
+			if entry.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		info, err := os.Stat(name)
+		switch {
+		case err != nil:
+			opts.logf("skipping %s: %v", name, err)
+			return nil
+		case info.IsDir() && entry.Type()&os.ModeSymlink != 0:
+			opts.logf("skipping %s: symlink to a directory", name)
+			return nil
+		case !info.IsDir() && !info.Mode().IsRegular():
+			opts.logf("skipping %s: not a regular file", name)
+			return nil
+		}
+		tree[rel] = info
+		return nil
+	})
+	return
+}
+
+// remoteTree lists dst like localTree; it is empty when dst does not exist
+// yet. Symlinks are listed as they are, so Delete removes the link only.
+func remoteTree(client *sftp.Client, dst string, opts *SyncOptions) (tree map[string]os.FileInfo, err error) {
+	tree = map[string]os.FileInfo{}
+	info, err := client.Stat(dst)
+	if errors.Is(err, os.ErrNotExist) {
+		return tree, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", dst, err)
+	}
+	if !info.IsDir() {
+		return nil, fmt.Errorf("%s is not a directory on the server", dst)
+	}
+	walker := client.Walk(dst)
+	for walker.Step() {
+		if err = walker.Err(); err != nil {
+			return nil, fmt.Errorf("failed to list %s: %v", walker.Path(), err)
+		}
+		rel := strings.TrimPrefix(strings.TrimPrefix(walker.Path(), dst), "/")
+		if rel == "" {
+			rel = "."
+		}
+		entry := walker.Stat()
+		if rel != "." && opts.excluded(rel, entry.IsDir()) {
+			if entry.IsDir() {
+				walker.SkipDir()
+			}
+			continue
+		}
+		tree[rel] = entry
+	}
+	return
+}
+
+// TreeState summarises the names, sizes and modification times under the
+// local directory src, skipping excluded paths, so that polling it shows
+// when anything changed.
+func TreeState(src string, opts SyncOptions) (state string, err error) {
+	opts.Log = nil
+	tree, err := localTree(src, &opts)
+	if err != nil {
+		return
+	}
+	paths := make([]string, 0, len(tree))
+	for rel, info := range tree {
+		paths = append(paths, fmt.Sprintf("%s %d %d", rel, info.Size(), info.ModTime().UnixNano()))
+	}
+	sort.Strings(paths)
+	sum := sha256.Sum256([]byte(strings.Join(paths, "\n")))
+	return fmt.Sprintf("%x", sum), nil
+}
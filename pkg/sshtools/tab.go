@@ -0,0 +1,170 @@
+package sshtools
+
+import (
+	"bytes"
+	"io"
+	"sync"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// tabBacklog is how much of its latest output a Tab keeps to repaint the
+// screen when it is shown again.
+const tabBacklog = 256 << 10
+
+// Tab is an interactive session that keeps running while it is not on
+// screen, for a dashboard switching between several. While attached its
+// output goes straight to the screen; the latest output is kept as well,
+// up to tabBacklog, and replayed when it is attached again. Full-screen
+// programs are made to redraw by a window-change.
+type Tab struct {
+	Name    string
+	Session *ssh.Session
+	// Command is run on the PTY instead of the login shell when set.
+	Command string
+	// TermType and Modes are as for Terminal.
+	TermType string
+	Modes    ssh.TerminalModes
+	// OnExit is called from a background goroutine once the session
+	// ended, with what Session.Wait returned, or nil after Close.
+	OnExit func(err error)
+
+	stdin io.WriteCloser
+	// escape is only used from Send, by the goroutine reading input.
+	escape escapeState
+
+	mu            sync.Mutex
+	screen        io.Writer // nil while detached
+	backlog       []byte
+	width, height int
+	closed        bool
+}
+
+// tabOutput is the Stdout and Stderr of a Tab's session.
+type tabOutput struct {
+	t *Tab
+}
+
+func (o *tabOutput) Write(p []byte) (int, error) {
+	t := o.t
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.backlog = append(t.backlog, p...)
+	if len(t.backlog) > tabBacklog {
+		// 从换行处截断，尽量不从转义序列中间开始重放
+		cut := len(t.backlog) - tabBacklog/2
+		if i := bytes.IndexByte(t.backlog[cut:], '\n'); i >= 0 {
+			cut += i + 1
+		}
+		t.backlog = append(t.backlog[:0], t.backlog[cut:]...)
+	}
+	if t.screen != nil {
+		// 屏幕写入失败时仍保留输出，再次显示时重放
+		_, _ = t.screen.Write(p)
+	}
+	return len(p), nil
+}
+
+// Start requests a PTY of the given size and starts the shell, or Command.
+func (t *Tab) Start(width, height int) (err error) {
+	termType, modes := t.TermType, t.Modes
+	if termType == "" {
+		termType = localTermType()
+	}
+	if modes == nil {
+		modes = ssh.TerminalModes{}
+	}
+	if err = t.Session.RequestPty(termType, height, width, modes); err != nil {
+		return
+	}
+	t.width, t.height = width, height
+	if t.stdin, err = t.Session.StdinPipe(); err != nil {
+		return
+	}
+	t.Session.Stdout = &tabOutput{t: t}
+	t.Session.Stderr = t.Session.Stdout
+	if t.Command != "" {
+		err = t.Session.Start(t.Command)
+	} else {
+		err = t.Session.Shell()
+	}
+	if err != nil {
+		return
+	}
+	go func() {
+		errs := t.Session.Wait()
+		t.mu.Lock()
+		if t.closed {
+			// 我们自己关闭的会话没有退出状态
+			errs = nil
+		}
+		t.mu.Unlock()
+		t.Detach()
+		if t.OnExit != nil {
+			t.OnExit(errs)
+		}
+	}()
+	return
+}
+
+// Attach shows the tab on screen, a terminal of the given size: it clears
+// it, replays the backlog and passes the output on from then on.
+func (t *Tab) Attach(screen io.Writer, width, height int) {
+	t.mu.Lock()
+	t.screen = screen
+	_, _ = io.WriteString(screen, "\x1b[H\x1b[2J")
+	_, _ = screen.Write(t.backlog)
+	resized := width != t.width || height != t.height
+	t.width, t.height = width, height
+	t.mu.Unlock()
+	if !resized && width > 1 {
+		// 尺寸未变时先发送一个不同的尺寸，全屏程序才会重画
+		_ = t.Session.WindowChange(height, width-1)
+	}
+	_ = t.Session.WindowChange(height, width)
+}
+
+// Detach stops showing the tab; its output is only kept from then on.
+func (t *Tab) Detach() {
+	t.mu.Lock()
+	t.screen = nil
+	t.mu.Unlock()
+}
+
+// Resize passes a new size of the local terminal on to the PTY.
+func (t *Tab) Resize(width, height int) {
+	t.mu.Lock()
+	changed := width != t.width || height != t.height
+	t.width, t.height = width, height
+	t.mu.Unlock()
+	if changed {
+		_ = t.Session.WindowChange(height, width)
+	}
+}
+
+// Send passes local input on to the session. ~. at the start of a line
+// closes it instead, and reports so.
+func (t *Tab) Send(p []byte) (closed bool) {
+	var out []byte
+	for _, b := range p {
+		q, cmd := t.escape.feed(b)
+		if cmd == '.' {
+			t.Close()
+			return true
+		}
+		out = append(out, q...)
+	}
+	if len(out) > 0 {
+		// 会话已结束时由 OnExit 处理
+		_, _ = t.stdin.Write(out)
+	}
+	return false
+}
+
+// Close ends the session.
+func (t *Tab) Close() {
+	t.mu.Lock()
+	t.closed = true
+	t.mu.Unlock()
+	_ = t.Session.Close()
+}
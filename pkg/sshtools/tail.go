@@ -0,0 +1,36 @@
+package sshtools
+
+import (
+	"fmt"
+	"strings"
+)
+
+// TailCommand returns the remote command that prints the last lines of
+// files and follows them across rotation. * and ? in the paths are left
+// for the remote shell to expand, once, when tail starts.
+func TailCommand(files []string, lines int) string {
+	quoted := make([]string, len(files))
+	for i, file := range files {
+		quoted[i] = quoteGlob(file)
+	}
+	return fmt.Sprintf("tail -n %d -F -- %s", lines, strings.Join(quoted, " "))
+}
+
+// quoteGlob shell-quotes s except for its * and ? wildcards.
+func quoteGlob(s string) string {
+	var b strings.Builder
+	for s != "" {
+		i := strings.IndexAny(s, "*?")
+		switch {
+		case i < 0:
+			i = len(s)
+		case i == 0:
+			b.WriteByte(s[0])
+			s = s[1:]
+			continue
+		}
+		b.WriteString(ShellQuote(s[:i]))
+		s = s[i:]
+	}
+	return b.String()
+}
@@ -0,0 +1,687 @@
+package sshtools
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/term"
+)
+
+// Default PTY size used when the local side is not a terminal.
+const (
+	defaultTermWidth  = 80
+	defaultTermHeight = 24
+)
+
+// resizeDebounce is how long window size changes must settle before the
+// new size is sent to the remote PTY.
+const resizeDebounce = 50 * time.Millisecond
+
+// exitProcess and stopProcess are os.Exit and stopSelf, replaced in tests.
+var (
+	exitProcess = os.Exit
+	stopProcess = stopSelf
+)
+
+// Terminal runs an interactive shell on an ssh.Session. Stdin, Stdout and
+// Stderr are the local ends; when Stdin is a terminal it is put into raw
+// mode and window size changes are propagated to the remote PTY.
+type Terminal struct {
+	Session *ssh.Session
+	Stdin   io.Reader
+	Stdout  io.Writer
+	Stderr  io.Writer
+
+	// Command is run on the PTY instead of the login shell when set.
+	Command string
+	// TermType is the TERM of the PTY, the local TERM when empty. Modes are
+	// the terminal modes it is requested with.
+	TermType string
+	Modes    ssh.TerminalModes
+	// ReadOnly discards all local keystrokes except the ~. disconnect escape.
+	ReadOnly bool
+	// PasteDelay pauses between lines of large input (pastes) when set.
+	PasteDelay time.Duration
+	// Share mirrors the session to observers when set.
+	Share *Share
+	// Record receives a copy of the output when set, e.g. a Recorder. A
+	// Redactor also sees what is typed, to hide passwords.
+	Record io.Writer
+	// Expect is played against the start of the session; keystrokes are
+	// held back until it finishes.
+	Expect []ExpectStep
+	// Become answers the sudo prompt of a command from SudoCommand with
+	// BecomePassword, once, early in the session.
+	Become         bool
+	BecomePassword string
+	// IdleTimeout closes the session after that long without local input,
+	// or with IdleAction IdleLock locks it until UnlockPassword is typed.
+	// IdleCommand is typed into the session first; with the action
+	// "command", that is all that happens.
+	IdleTimeout    time.Duration
+	IdleAction     string
+	IdleCommand    string
+	UnlockPassword string
+	// MaxDuration closes the session after it has run that long, with a
+	// warning shortly before.
+	MaxDuration time.Duration
+	// ZmodemDir receives the files sent with sz in the session when set.
+	ZmodemDir string
+	// Clipboard lets remote programs set the local clipboard with OSC 52;
+	// the sequences are removed from the output otherwise.
+	Clipboard bool
+	// Triggers call OnTrigger for lines of output that match them.
+	Triggers  []OutputTrigger
+	OnTrigger func(trigger OutputTrigger, line string)
+	// Log receives diagnostics when set.
+	Log func(level int, format string, args ...any)
+	// Quiet leaves out the message printed when the session ends.
+	Quiet bool
+	// StatusLine is kept on the last row of a local terminal for the
+	// session, in reverse video of StatusColor; the remote PTY is one row
+	// shorter.
+	StatusLine  string
+	StatusColor string
+	// CommandLine runs a line typed after the ~C escape, such as
+	// "-L 8080:localhost:80", and returns what to show; ~C is refused when
+	// nil.
+	CommandLine func(line string) (string, error)
+
+	raw    *rawMode
+	status *statusLine
+	signal os.Signal
+	escape escapeState
+	notice time.Time
+	// input reads Stdin until the session ends.
+	input  io.ReadCloser
+	stdout io.Reader
+	stdin  io.WriteCloser
+	stderr io.Reader
+
+	// mu guards how the session ended: closed is set when we hung up, so
+	// the missing exit status is expected; exitMsg is shown afterwards and
+	// failure returned by Run.
+	mu      sync.Mutex
+	closed  bool
+	exitMsg string
+	failure error
+}
+
+// NewTerminal wraps session with the given local streams.
+func NewTerminal(session *ssh.Session, stdin io.Reader, stdout, stderr io.Writer) *Terminal {
+	return &Terminal{Session: session, Stdin: stdin, Stdout: stdout, Stderr: stderr}
+}
+
+// terminalFd returns the file descriptor behind r if it is a terminal.
+func terminalFd(r io.Reader) (fd int, ok bool) {
+	f, ok := r.(interface{ Fd() uintptr })
+	if !ok {
+		return
+	}
+	fd = int(f.Fd())
+	return fd, term.IsTerminal(fd)
+}
+
+// NotifyResize sends to c when the size of the local terminal fd changes,
+// until stop is closed. On Unix it relays SIGWINCH, so signal.Stop(c)
+// is needed as well.
+func NotifyResize(c chan<- os.Signal, fd int, stop <-chan struct{}) {
+	notifyResize(c, fd, stop)
+}
+
+// termSize returns the size of the local terminal, falling back to the
+// default size rather than a 0x0 PTY when it cannot be determined.
+func (t *Terminal) termSize(fd int) (width, height int) {
+	width, height, err := terminalSize(fd)
+	if err == nil && (width <= 0 || height <= 0) {
+		err = fmt.Errorf("terminal reports %dx%d", width, height)
+	}
+	if err != nil {
+		fmt.Fprintf(t.Stderr, "unable to get terminal size (%v), using %dx%d\r\n", err, defaultTermWidth, defaultTermHeight)
+		return defaultTermWidth, defaultTermHeight
+	}
+	return
+}
+
+// sendSize sends the local window size to the remote PTY. Failures are
+// shown, except io.EOF: the session is gone and there is nothing left to
+// resize.
+func (t *Terminal) sendSize(width, height int) (err error) {
+	t.status.resize(width, height)
+	err = t.Session.WindowChange(t.status.rows(height), width)
+	if err != nil && !errors.Is(err, io.EOF) {
+		fmt.Fprintf(t.Stderr, "Unable to send window-change request: %s.\r\n", err)
+	}
+	return
+}
+
+// watchResize propagates local window size changes to the remote PTY until
+// stop is closed or the session ends. Bursts of SIGWINCH (e.g. dragging a
+// tmux pane border) are coalesced into a single window-change request.
+func (t *Terminal) watchResize(fd int, sigwinchCh <-chan os.Signal, stop <-chan struct{}, width, height int) {
+	defer t.recoverPanic()
+	var debounce <-chan time.Time
+	for {
+		select {
+		case <-stop:
+			return
+		case <-sigwinchCh:
+			debounce = time.After(resizeDebounce)
+		case <-debounce:
+			debounce = nil
+			currWidth, currHeight, err := terminalSize(fd)
+			// Terminal size has not changed or is unknown, don't do anything.
+			if err != nil || currWidth == width && currHeight == height {
+				continue
+			}
+			switch err = t.sendSize(currWidth, currHeight); {
+			case err == nil:
+				width, height = currWidth, currHeight
+			case errors.Is(err, io.EOF):
+				return
+			}
+		}
+	}
+}
+
+// handledSignals returns the signals handleSignals acts on.
+func handledSignals() []os.Signal {
+	signals := append([]os.Signal{}, terminateSignals...)
+	if suspendSignal != nil {
+		signals = append(signals, suspendSignal, continueSignal)
+	}
+	return signals
+}
+
+// handleSignals keeps the local terminal usable across signals until stop
+// is closed. Suspending (SIGTSTP) restores it before stopping the process,
+// and continuing re-enters raw mode and resends the window size.
+// Terminating signals restore it and hang up, so Run returns normally and
+// its cleanup runs; a second one exits at once.
+func (t *Terminal) handleSignals(fd int, sigCh <-chan os.Signal, stop <-chan struct{}) {
+	defer t.recoverPanic()
+	for {
+		select {
+		case <-stop:
+			return
+		case sig := <-sigCh:
+			switch sig {
+			case suspendSignal:
+				t.suspend()
+			case continueSignal:
+				if err := t.raw.enter(); err != nil {
+					fmt.Fprintf(t.Stderr, "unable to re-enter raw mode: %v\r\n", err)
+				}
+				width, height := t.termSize(fd)
+				_ = t.sendSize(width, height)
+			default:
+				t.raw.Close()
+				if t.signal != nil {
+					exitProcess(1)
+				}
+				t.signal = sig
+				t.hangUp(fmt.Sprintf("Connection closed: received %v.", sig))
+			}
+		}
+	}
+}
+
+// suspend restores the local terminal and stops the process; continuing
+// is handled by handleSignals.
+func (t *Terminal) suspend() {
+	t.raw.leave()
+	if err := stopProcess(); err != nil {
+		fmt.Fprintf(t.Stderr, "unable to suspend: %v\r\n", err)
+		_ = t.raw.enter()
+	}
+}
+
+// recoverPanic restores the local terminal before a panic in a session
+// goroutine takes the process down, so the trace is readable and the shell
+// usable afterwards.
+func (t *Terminal) recoverPanic() {
+	if p := recover(); p != nil {
+		t.status.clear()
+		if t.raw != nil {
+			t.raw.Close()
+		}
+		panic(p)
+	}
+}
+
+// hangUp closes the session from our side, with msg to show once it
+// ended. The first reason given is the one shown.
+func (t *Terminal) hangUp(msg string) {
+	t.mu.Lock()
+	t.closed = true
+	if t.exitMsg == "" {
+		t.exitMsg = msg
+	}
+	t.mu.Unlock()
+	_ = t.Session.Close()
+}
+
+// abort is hangUp for a failure, which Run returns.
+func (t *Terminal) abort(err error) {
+	t.mu.Lock()
+	if t.failure == nil {
+		t.failure = err
+	}
+	t.mu.Unlock()
+	t.hangUp(fmt.Sprintf("Connection closed: %v.", err))
+}
+
+// copyOutput copies the remote output from src to dst until it ends. A
+// failure, such as a local stdout that was closed, ends the session
+// instead of leaving it running unseen.
+func (t *Terminal) copyOutput(ctx context.Context, dst io.Writer, src io.Reader, name string) {
+	if _, err := streamCopy(dst, src); err != nil && ctx.Err() == nil {
+		t.abort(fmt.Errorf("failed to copy the session %s: %v", name, err))
+	}
+}
+
+// Run requests a PTY, starts the remote shell and copies data between the
+// local and remote ends until the session finishes. Every goroutine it
+// starts is done when it returns, and the local terminal is restored.
+func (t *Terminal) Run() (err error) {
+	defer func() {
+		t.mu.Lock()
+		exitMsg := t.exitMsg
+		if err == nil {
+			err = t.failure
+		}
+		t.mu.Unlock()
+		if t.Quiet {
+			return
+		}
+		if exitMsg == "" {
+			_, errs := fmt.Fprintln(t.Stdout, "the connection was closed on the remote side on ", time.Now().Format(time.RFC822))
+			if errs != nil {
+				fmt.Fprintln(t.Stderr, errs.Error())
+			}
+		} else {
+			_, errs := fmt.Fprintln(t.Stdout, exitMsg)
+			if errs != nil {
+				fmt.Fprintln(t.Stderr, errs.Error())
+			}
+		}
+	}()
+
+	termWidth, termHeight := defaultTermWidth, defaultTermHeight
+	fd, isTerm := terminalFd(t.Stdin)
+	if isTerm {
+		t.raw = newRawMode(fd, t.Stderr)
+		if err = t.raw.enter(); err != nil {
+			return
+		}
+		defer t.raw.Close()
+	}
+
+	termType := t.TermType
+	if termType == "" {
+		termType = localTermType()
+	}
+
+	// 会话的所有 goroutine 都在 ctx 结束时退出，Run 返回前等待它们，
+	// 之后不会再读取 stdin 或向已关闭的会话发送请求
+	ctx, cancel := context.WithCancel(context.Background())
+	var goroutines sync.WaitGroup
+	defer func() {
+		cancel()
+		// 提前返回时输出仍在复制，关闭会话使其结束
+		_ = t.Session.Close()
+		goroutines.Wait()
+		// 没有 goroutine 再读取时才能释放
+		_ = t.input.Close()
+	}()
+	t.input = newSessionInput(ctx, t.Stdin)
+
+	// Watch for resizes before the PTY exists so a SIGWINCH during
+	// connection setup is not lost, and query the size as late as possible.
+	sigwinchCh := make(chan os.Signal, 1)
+	if isTerm {
+		defer signal.Stop(sigwinchCh)
+		notifyResize(sigwinchCh, fd, ctx.Done())
+		termWidth, termHeight = t.termSize(fd)
+		if t.StatusLine != "" {
+			t.status = newStatusLine(t.Stdout, t.StatusLine, t.StatusColor, termWidth, termHeight)
+			defer t.status.clear()
+		}
+
+		sigCh := make(chan os.Signal, 1)
+		signal.Notify(sigCh, handledSignals()...)
+		defer signal.Stop(sigCh)
+		goroutines.Go(func() {
+			t.handleSignals(fd, sigCh, ctx.Done())
+		})
+	}
+
+	modes := t.Modes
+	if modes == nil {
+		modes = ssh.TerminalModes{}
+	}
+	err = t.Session.RequestPty(termType, t.status.rows(termHeight), termWidth, modes)
+	if err != nil {
+		return
+	}
+
+	t.stdin, err = t.Session.StdinPipe()
+	if err != nil {
+		return
+	}
+	t.stdout, err = t.Session.StdoutPipe()
+	if err != nil {
+		return
+	}
+	t.stderr, err = t.Session.StderrPipe()
+	if err != nil {
+		return
+	}
+
+	stdout, stderr := t.status.writer(t.Stdout), t.status.writer(t.Stderr)
+	var idle *idleGuard
+	if t.IdleTimeout > 0 && isTerm {
+		idle = newIdleGuard(t.IdleTimeout, t.IdleAction, t.IdleCommand, t.UnlockPassword, t.Stdout, t.stdin)
+		stdout, stderr = idle.writer(stdout), idle.writer(stderr)
+	}
+	if t.Share != nil {
+		stdout, stderr = io.MultiWriter(stdout, t.Share), io.MultiWriter(stderr, t.Share)
+		if t.Share.ReadWrite {
+			goroutines.Go(func() {
+				defer t.recoverPanic()
+				for {
+					select {
+					case <-ctx.Done():
+						return
+					case p, ok := <-t.Share.Input():
+						if !ok {
+							return
+						}
+						if _, errs := t.stdin.Write(p); errs != nil {
+							return
+						}
+					}
+				}
+			})
+		}
+	}
+
+	if t.Record != nil {
+		stdout, stderr = io.MultiWriter(stdout, t.Record), io.MultiWriter(stderr, t.Record)
+	}
+
+	// The script sees the output as the user does and reads from a
+	// rolling buffer, so patterns match across reads.
+	scriptDone, outputDone := make(chan struct{}), make(chan struct{})
+	var script *expecter
+	if len(t.Expect) > 0 {
+		script = newExpecter()
+		stdout = io.MultiWriter(stdout, script)
+	} else {
+		close(scriptDone)
+	}
+
+	if len(t.Triggers) > 0 && t.OnTrigger != nil {
+		stdout = io.MultiWriter(stdout, newTriggerWatcher(t.Triggers, t.OnTrigger))
+	}
+
+	var become *becomeWatcher
+	if t.Become {
+		become = &becomeWatcher{w: stdout, stdin: t.stdin, password: t.BecomePassword, until: time.Now().Add(becomeWindow),
+			onFail: func() {
+				t.hangUp("Connection closed.")
+			}}
+		stdout = become
+	}
+	// 最先经过剪贴板过滤，OSC 52 序列不会进入录制、共享或脚本
+	stdout = newClipboardWriter(stdout, t.Clipboard, t.Log)
+
+	// sz 发送的文件在读取远程输出时接收，不经过上面的输出链
+	output := t.stdout
+	var zmodem *zmodemReader
+	if t.ZmodemDir != "" {
+		zmodem = &zmodemReader{r: t.stdout, w: t.stdin, status: t.Stderr, dir: t.ZmodemDir, log: t.Log}
+		output = zmodem
+	}
+
+	var copying sync.WaitGroup
+	copying.Go(func() {
+		defer t.recoverPanic()
+		t.copyOutput(ctx, stderr, t.stderr, "stderr")
+	})
+	copying.Go(func() {
+		defer t.recoverPanic()
+		defer close(outputDone)
+		t.copyOutput(ctx, stdout, output, "output")
+	})
+	if script != nil {
+		goroutines.Go(func() {
+			defer t.recoverPanic()
+			defer close(scriptDone)
+			t.runExpect(script, outputDone)
+		})
+	}
+
+	if t.ReadOnly {
+		fmt.Fprint(t.Stderr, "\x1b[7m[read-only] keyboard input is not sent to the remote host, type ~. to disconnect\x1b[0m\r\n")
+	}
+
+	// Handle user input
+	goroutines.Go(func() {
+		defer t.recoverPanic()
+		// Closing the pipe sends EOF to the remote side; errors are left
+		// off the raw-mode display.
+		defer func(stdin io.WriteCloser) {
+			_ = stdin.Close()
+		}(t.stdin)
+
+		// Typing is buffered by the local terminal until the script hands
+		// over control.
+		select {
+		case <-scriptDone:
+		case <-ctx.Done():
+			return
+		}
+		input := &pasteWriter{w: t.stdin, delay: t.PasteDelay}
+		bufp := streamBuffers.Get().(*[]byte)
+		defer streamBuffers.Put(bufp)
+		buf := *bufp
+		for {
+			n, errs := t.input.Read(buf)
+			if n > 0 && zmodem != nil && zmodem.Active() {
+				// 传输期间按键不发送到远程，Ctrl-C 取消传输
+				if bytes.IndexByte(buf[:n], 3) >= 0 {
+					zmodem.Cancel()
+				}
+				n = 0
+			}
+			if n > 0 && idle != nil {
+				if idle.isLocked() {
+					idle.input(buf[:n])
+					n = 0
+				} else {
+					idle.touch()
+				}
+			}
+			if n > 0 && t.ReadOnly {
+				t.readOnlyInput(buf[:n])
+			} else if n > 0 {
+				p, done := t.escapeInput(buf[:n])
+				if done {
+					return
+				}
+				if redactor, ok := t.Record.(*Redactor); ok {
+					redactor.Input(p)
+				}
+				if _, errs := input.Write(p); errs != nil {
+					if ctx.Err() == nil {
+						t.abort(fmt.Errorf("failed to send input: %v", errs))
+					}
+					return
+				}
+			}
+			if errs != nil {
+				_ = input.flush()
+				return
+			}
+		}
+	})
+
+	if t.Command != "" {
+		err = t.Session.Start(t.Command)
+	} else {
+		err = t.Session.Shell()
+	}
+	if err != nil {
+		t.mu.Lock()
+		if t.closed {
+			// 在 shell 启动前就挂断了
+			err = nil
+		}
+		t.mu.Unlock()
+		return
+	}
+
+	if t.MaxDuration > 0 {
+		defer t.limitDuration()()
+	}
+	if isTerm {
+		// Resend the size now the shell is running in case the window
+		// changed while the PTY was being set up.
+		termWidth, termHeight = t.termSize(fd)
+		_ = t.sendSize(termWidth, termHeight)
+		goroutines.Go(func() {
+			t.watchResize(fd, sigwinchCh, ctx.Done(), termWidth, termHeight)
+		})
+		if idle != nil {
+			goroutines.Go(func() {
+				defer t.recoverPanic()
+				idle.watch(ctx.Done(), func() {
+					t.hangUp(fmt.Sprintf("Connection closed: no input for %s.", t.IdleTimeout))
+				})
+			})
+		}
+	}
+
+	copying.Wait()
+	err = t.Session.Wait()
+	t.mu.Lock()
+	if t.closed {
+		// We hung up ourselves, the missing exit status is expected.
+		err = nil
+	}
+	t.mu.Unlock()
+	if become != nil && become.Err() != nil {
+		err = become.Err()
+	}
+	return
+}
+
+// escapeInput runs the escape sequences in p and returns the input to send
+// on. done is set when ~. hung up.
+func (t *Terminal) escapeInput(p []byte) (out []byte, done bool) {
+	t.escape.extended = true
+	// 没有 ~ 时原样发送，只记录是否停在行首
+	if !t.escape.pending && bytes.IndexByte(p, escapeChar) < 0 {
+		last := p[len(p)-1]
+		t.escape.midLine = last != '\r' && last != '\n'
+		return p, false
+	}
+	for i := 0; i < len(p); i++ {
+		o, cmd := t.escape.feed(p[i])
+		out = append(out, o...)
+		switch cmd {
+		case '.':
+			t.hangUp("Connection closed.")
+			return nil, true
+		case escapeHelp:
+			fmt.Fprint(t.Stderr, escapeHelpText)
+		case escapeSuspend:
+			if t.raw == nil {
+				fmt.Fprint(t.Stderr, "\r\nunable to suspend: not a terminal\r\n")
+				continue
+			}
+			fmt.Fprint(t.Stderr, "\r\n")
+			t.suspend()
+		case escapeCommand:
+			// 命令行从本次读取剩余的字节开始读
+			p, i = t.commandLine(p[i+1:]), -1
+		}
+	}
+	return
+}
+
+// commandLine reads and runs a ~C command line, starting with the input in
+// pending. It returns the input that followed the line.
+func (t *Terminal) commandLine(pending []byte) (rest []byte) {
+	if t.CommandLine == nil {
+		fmt.Fprint(t.Stderr, "\r\ncommands are not supported in this session\r\n")
+		return pending
+	}
+	fmt.Fprint(t.Stderr, "\r\nssh> ")
+	var line []byte
+	buf := make([]byte, 256)
+	for {
+		if len(pending) == 0 {
+			n, err := t.input.Read(buf)
+			if err != nil && n == 0 {
+				fmt.Fprint(t.Stderr, "\r\n")
+				return nil
+			}
+			pending = buf[:n]
+		}
+		b := pending[0]
+		pending = pending[1:]
+		switch b {
+		case '\r', '\n':
+			fmt.Fprint(t.Stderr, "\r\n")
+			msg, err := t.CommandLine(strings.TrimSpace(string(line)))
+			if err != nil {
+				msg = err.Error()
+			}
+			if msg != "" {
+				fmt.Fprint(t.Stderr, strings.ReplaceAll(strings.TrimRight(msg, "\n"), "\n", "\r\n")+"\r\n")
+			}
+			return append([]byte(nil), pending...)
+		case 3: // Ctrl-C
+			fmt.Fprint(t.Stderr, "\r\n")
+			return append([]byte(nil), pending...)
+		case 0x7f, '\b':
+			if len(line) > 0 {
+				line = line[:len(line)-1]
+				fmt.Fprint(t.Stderr, "\b \b")
+			}
+		default:
+			if b >= 0x20 && b < 0x7f {
+				line = append(line, b)
+				fmt.Fprintf(t.Stderr, "%c", b)
+			}
+		}
+	}
+}
+
+// readOnlyInput drops keystrokes in read-only mode, watching only for the
+// disconnect escape. Attempts to type ring the bell with a short notice.
+func (t *Terminal) readOnlyInput(p []byte) {
+	for _, b := range p {
+		if _, cmd := t.escape.feed(b); cmd == '.' {
+			t.hangUp("Connection closed.")
+			return
+		}
+	}
+	if time.Since(t.notice) < 3*time.Second {
+		fmt.Fprint(t.Stderr, "\a")
+		return
+	}
+	t.notice = time.Now()
+	fmt.Fprint(t.Stderr, "\a\r\n\x1b[7m[read-only] input ignored, type ~. to disconnect\x1b[0m\r\n")
+}
@@ -0,0 +1,116 @@
+package sshtools
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"testing"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+// openPty opens a pseudo-terminal of the given size, returning its master
+// and slave ends; both are closed when the test ends.
+func openPty(t *testing.T, width, height int) (master, slave *os.File) {
+	t.Helper()
+	master, err := os.OpenFile("/dev/ptmx", os.O_RDWR|unix.O_NOCTTY, 0)
+	if err != nil {
+		t.Skipf("no pseudo-terminals: %v", err)
+	}
+	t.Cleanup(func() { _ = master.Close() })
+	if err = unix.IoctlSetPointerInt(int(master.Fd()), unix.TIOCSPTLCK, 0); err != nil {
+		t.Fatal(err)
+	}
+	n, err := unix.IoctlGetInt(int(master.Fd()), unix.TIOCGPTN)
+	if err != nil {
+		t.Fatal(err)
+	}
+	slave, err = os.OpenFile(fmt.Sprintf("/dev/pts/%d", n), os.O_RDWR|unix.O_NOCTTY, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = slave.Close() })
+	setPtySize(t, slave, width, height)
+	return
+}
+
+// setPtySize changes the size of a pseudo-terminal, as resizing the window
+// would.
+func setPtySize(t *testing.T, pty *os.File, width, height int) {
+	t.Helper()
+	ws := &unix.Winsize{Col: uint16(width), Row: uint16(height)}
+	if err := unix.IoctlSetWinsize(int(pty.Fd()), unix.TIOCSWINSZ, ws); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestTerminalRunSendsSize(t *testing.T) {
+	s := newTestServer(t)
+	master, slave := openPty(t, 120, 40)
+	go func() {
+		s.waitEvent(t, "shell", 1)
+		// 原始模式下回车不会转换
+		_, _ = io.WriteString(master, "exit 0\r")
+	}()
+	// 读取回显，避免填满 PTY
+	go func() { _, _ = io.Copy(io.Discard, master) }()
+	term := NewTerminal(s.session(t), slave, slave, io.Discard)
+	term.TermType = "vt100"
+	term.Quiet = true
+
+	if err := runTerminal(t, term); err != nil {
+		t.Fatalf("Run: %v", err)
+	}
+	// shell 启动后再发送一次尺寸
+	events := s.Events()
+	want := []string{"pty-req vt100 120x40", "shell", "window-change 120x40"}
+	if len(events) < len(want) {
+		t.Fatalf("events = %q, want %q first", events, want)
+	}
+	for i := range want {
+		if events[i] != want[i] {
+			t.Fatalf("events = %q, want %q first", events, want)
+		}
+	}
+}
+
+func TestTerminalWatchResize(t *testing.T) {
+	s := newTestServer(t)
+	_, slave := openPty(t, 80, 24)
+	term := NewTerminal(s.session(t), slave, io.Discard, io.Discard)
+	sigwinchCh := make(chan os.Signal, 1)
+	stop := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		term.watchResize(int(slave.Fd()), sigwinchCh, stop, 80, 24)
+	}()
+	defer func() {
+		close(stop)
+		<-done
+	}()
+
+	// 拖动窗口边缘时连续收到多个信号，只发送最后的尺寸
+	for _, width := range []int{90, 100, 110} {
+		setPtySize(t, slave, width, 30)
+		sigwinchCh <- unix.SIGWINCH
+		time.Sleep(resizeDebounce / 5)
+	}
+	s.waitEvent(t, "window-change 110x30", 1)
+	time.Sleep(2 * resizeDebounce)
+	if events := s.Events(); len(events) != 1 {
+		t.Errorf("events = %q, want a single window-change", events)
+	}
+
+	// 尺寸未变时不发送
+	sigwinchCh <- unix.SIGWINCH
+	time.Sleep(2 * resizeDebounce)
+	if events := s.Events(); len(events) != 1 {
+		t.Errorf("events = %q, want nothing sent for an unchanged size", events)
+	}
+
+	setPtySize(t, slave, 132, 43)
+	sigwinchCh <- unix.SIGWINCH
+	s.waitEvent(t, "window-change 132x43", 1)
+}
@@ -0,0 +1,391 @@
+package sshtools
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// testServer is an SSH server running in the test process. Its sessions
+// record the requests they get as events and run a shell that echoes its
+// input; a line "exit N" ends it with status N, and so does EOF, with 0.
+// Commands are run by command.
+type testServer struct {
+	client *ssh.Client
+
+	mu     sync.Mutex
+	events []string
+	input  bytes.Buffer
+}
+
+// newTestServer starts a testServer on a loopback port and connects a
+// client to it; both are closed when the test ends.
+func newTestServer(t *testing.T) *testServer {
+	t.Helper()
+	_, key, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signer, err := ssh.NewSignerFromKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	config := &ssh.ServerConfig{NoClientAuth: true}
+	config.AddHostKey(signer)
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = listener.Close() })
+	s := &testServer{}
+	go func() {
+		conn, errs := listener.Accept()
+		if errs != nil {
+			return
+		}
+		_, chans, reqs, errs := ssh.NewServerConn(conn, config)
+		if errs != nil {
+			return
+		}
+		go ssh.DiscardRequests(reqs)
+		for newChannel := range chans {
+			if newChannel.ChannelType() != "session" {
+				_ = newChannel.Reject(ssh.UnknownChannelType, "sessions only")
+				continue
+			}
+			channel, requests, errs := newChannel.Accept()
+			if errs != nil {
+				continue
+			}
+			go s.serve(channel, requests)
+		}
+	}()
+
+	s.client, err = ssh.Dial("tcp", listener.Addr().String(), &ssh.ClientConfig{
+		User:            "me",
+		HostKeyCallback: ssh.InsecureIgnoreHostKey(),
+		Timeout:         5 * time.Second,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = s.client.Close() })
+	return s
+}
+
+// session opens a new session on the test server.
+func (s *testServer) session(t *testing.T) *ssh.Session {
+	t.Helper()
+	session, err := s.client.NewSession()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = session.Close() })
+	return session
+}
+
+func (s *testServer) record(format string, args ...any) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.events = append(s.events, fmt.Sprintf(format, args...))
+}
+
+// Events returns the requests recorded so far.
+func (s *testServer) Events() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]string(nil), s.events...)
+}
+
+// Input returns what the shells were sent.
+func (s *testServer) Input() string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.input.String()
+}
+
+// waitFor reports whether cond held within five seconds.
+func waitFor(cond func() bool) bool {
+	deadline := time.Now().Add(5 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			return false
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	return true
+}
+
+// count returns how often event was recorded.
+func (s *testServer) count(event string) (n int) {
+	for _, e := range s.Events() {
+		if e == event {
+			n++
+		}
+	}
+	return
+}
+
+// waitEvent waits until event was recorded count times.
+func (s *testServer) waitEvent(t *testing.T, event string, count int) {
+	t.Helper()
+	if !waitFor(func() bool { return s.count(event) >= count }) {
+		t.Errorf("%q was not recorded %d times, events: %q", event, count, s.Events())
+	}
+}
+
+func (s *testServer) serve(channel ssh.Channel, requests <-chan *ssh.Request) {
+	for req := range requests {
+		ok := true
+		switch req.Type {
+		case "pty-req":
+			var pty struct {
+				Term                  string
+				Columns, Rows, PW, PH uint32
+				Modes                 string
+			}
+			ok = ssh.Unmarshal(req.Payload, &pty) == nil
+			s.record("pty-req %s %dx%d", pty.Term, pty.Columns, pty.Rows)
+		case "window-change":
+			var size struct{ Columns, Rows, PW, PH uint32 }
+			ok = ssh.Unmarshal(req.Payload, &size) == nil
+			s.record("window-change %dx%d", size.Columns, size.Rows)
+		case "shell":
+			s.record("shell")
+			go s.shell(channel)
+		case "exec":
+			var exec struct{ Command string }
+			ok = ssh.Unmarshal(req.Payload, &exec) == nil
+			s.record("exec %s", exec.Command)
+			go command(channel, exec.Command)
+		default:
+			ok = false
+		}
+		if req.WantReply {
+			_ = req.Reply(ok, nil)
+		}
+	}
+}
+
+// shell echoes its input until a line "exit N" or EOF.
+func (s *testServer) shell(channel ssh.Channel) {
+	var line []byte
+	buf := make([]byte, 1024)
+	for {
+		n, err := channel.Read(buf)
+		s.mu.Lock()
+		s.input.Write(buf[:n])
+		s.mu.Unlock()
+		if _, errs := channel.Write(buf[:n]); errs != nil {
+			return
+		}
+		for _, b := range buf[:n] {
+			if b != '\n' && b != '\r' {
+				line = append(line, b)
+				continue
+			}
+			var status uint32
+			if _, errs := fmt.Sscanf(string(line), "exit %d", &status); errs == nil {
+				exit(channel, status)
+				return
+			}
+			line = line[:0]
+		}
+		if err != nil {
+			exit(channel, 0)
+			return
+		}
+	}
+}
+
+// command runs an exec request: "output N" writes N bytes of output, or
+// writes until the session is closed for -1. Anything else exits at once.
+func command(channel ssh.Channel, cmd string) {
+	var size int64
+	if _, err := fmt.Sscanf(cmd, "output %d", &size); err == nil {
+		line := []byte("0123456789abcdef")
+		for written := int64(0); size < 0 || written < size; written += int64(len(line)) {
+			if size >= 0 {
+				line = line[:min(int64(len(line)), size-written)]
+			}
+			if _, errs := channel.Write(line); errs != nil {
+				return
+			}
+		}
+	}
+	exit(channel, 0)
+}
+
+// exit ends a session with status.
+func exit(channel ssh.Channel, status uint32) {
+	_, _ = channel.SendRequest("exit-status", false, ssh.Marshal(struct{ Status uint32 }{status}))
+	_ = channel.Close()
+}
+
+// runTerminal runs t, failing the test if it does not return in time.
+func runTerminal(t *testing.T, term *Terminal) error {
+	t.Helper()
+	done := make(chan error, 1)
+	go func() { done <- term.Run() }()
+	select {
+	case err := <-done:
+		return err
+	case <-time.After(10 * time.Second):
+		t.Fatal("Run did not return")
+		return nil
+	}
+}
+
+func TestTerminalRunEchoesInput(t *testing.T) {
+	s := newTestServer(t)
+	var stdout, stderr bytes.Buffer
+	term := NewTerminal(s.session(t), strings.NewReader("hello\n"), &stdout, &stderr)
+	term.TermType = "vt100"
+
+	if err := runTerminal(t, term); err != nil {
+		t.Fatalf("Run: %v", err)
+	}
+	if !strings.Contains(stdout.String(), "hello") {
+		t.Errorf("output %q lacks the echoed input", stdout.String())
+	}
+	if !strings.Contains(stdout.String(), "the connection was closed on the remote side") {
+		t.Errorf("output %q lacks the exit message", stdout.String())
+	}
+	// 本地不是终端时使用默认尺寸，也不发送 window-change
+	if events := s.Events(); len(events) < 2 || events[0] != "pty-req vt100 80x24" || events[1] != "shell" {
+		t.Errorf("events = %q, want the PTY request and the shell", events)
+	}
+}
+
+func TestTerminalRunExitStatus(t *testing.T) {
+	s := newTestServer(t)
+	// 输入一直没有结束，会话结束后 Run 也不能等待它
+	stdin, input := io.Pipe()
+	defer func() { _ = input.Close() }()
+	go func() { _, _ = io.WriteString(input, "exit 3\n") }()
+	var stdout bytes.Buffer
+	term := NewTerminal(s.session(t), stdin, &stdout, io.Discard)
+	term.Quiet = true
+
+	err := runTerminal(t, term)
+	var exitErr *ssh.ExitError
+	if !errors.As(err, &exitErr) || exitErr.ExitStatus() != 3 {
+		t.Fatalf("Run = %v, want exit status 3", err)
+	}
+	if stdout.String() != "exit 3\n" {
+		t.Errorf("output = %q, want only the echo", stdout.String())
+	}
+}
+
+func TestTerminalEscapeHangsUp(t *testing.T) {
+	s := newTestServer(t)
+	stdin, input := io.Pipe()
+	defer func() { _ = input.Close() }()
+	go func() {
+		// 分两次输入，像逐个键入一样
+		s.waitEvent(t, "shell", 1)
+		_, _ = io.WriteString(input, "ls\r")
+		waitFor(func() bool { return s.Input() == "ls\r" })
+		_, _ = io.WriteString(input, "~.")
+	}()
+	var stdout bytes.Buffer
+	term := NewTerminal(s.session(t), stdin, &stdout, io.Discard)
+
+	if err := runTerminal(t, term); err != nil {
+		t.Fatalf("Run = %v, want nil after ~.", err)
+	}
+	if !strings.HasSuffix(stdout.String(), "Connection closed.\n") {
+		t.Errorf("output = %q, want the hang-up message", stdout.String())
+	}
+	if got := s.Input(); got != "ls\r" {
+		t.Errorf("the shell got %q, want the input before ~.", got)
+	}
+}
+
+func TestTerminalReadOnly(t *testing.T) {
+	s := newTestServer(t)
+	stdin, input := io.Pipe()
+	defer func() { _ = input.Close() }()
+	go func() {
+		s.waitEvent(t, "shell", 1)
+		_, _ = io.WriteString(input, "rm -rf /\r")
+		_, _ = io.WriteString(input, "~.")
+	}()
+	var stderr bytes.Buffer
+	term := NewTerminal(s.session(t), stdin, io.Discard, &stderr)
+	term.ReadOnly = true
+	term.Quiet = true
+
+	if err := runTerminal(t, term); err != nil {
+		t.Fatalf("Run: %v", err)
+	}
+	if got := s.Input(); got != "" {
+		t.Errorf("read-only session sent %q", got)
+	}
+	if !strings.Contains(stderr.String(), "[read-only]") {
+		t.Errorf("stderr %q lacks the read-only notice", stderr.String())
+	}
+}
+
+func TestTerminalCommand(t *testing.T) {
+	s := newTestServer(t)
+	term := NewTerminal(s.session(t), strings.NewReader(""), io.Discard, io.Discard)
+	term.Command = "top"
+	term.Quiet = true
+
+	if err := runTerminal(t, term); err != nil {
+		t.Fatalf("Run: %v", err)
+	}
+	s.waitEvent(t, "exec top", 1)
+}
+
+// Sessions reading a file must release what they used to wait for input
+// on it.
+func TestTerminalReleasesInput(t *testing.T) {
+	if _, err := os.Stat("/proc/self/fd"); err != nil {
+		t.Skip("needs /proc/self/fd")
+	}
+	s := newTestServer(t)
+	openFiles := func() int {
+		entries, err := os.ReadDir("/proc/self/fd")
+		if err != nil {
+			t.Fatal(err)
+		}
+		return len(entries)
+	}
+	stdin, input, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		_ = stdin.Close()
+		_ = input.Close()
+	}()
+
+	before := openFiles()
+	for range 5 {
+		if _, err = io.WriteString(input, "exit 0\n"); err != nil {
+			t.Fatal(err)
+		}
+		term := NewTerminal(s.session(t), stdin, io.Discard, io.Discard)
+		term.Quiet = true
+		if err = runTerminal(t, term); err != nil {
+			t.Fatalf("Run: %v", err)
+		}
+	}
+	if after := openFiles(); after > before {
+		t.Errorf("%d files open after five sessions, %d before", after, before)
+	}
+}
@@ -0,0 +1,60 @@
+//go:build !windows
+
+package sshtools
+
+import (
+	"os"
+	"os/signal"
+	"os/user"
+	"syscall"
+
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/term"
+)
+
+// Signals handled while a terminal session runs.
+var (
+	terminateSignals           = []os.Signal{syscall.SIGINT, syscall.SIGTERM, syscall.SIGHUP}
+	suspendSignal    os.Signal = syscall.SIGTSTP
+	continueSignal   os.Signal = syscall.SIGCONT
+)
+
+// remoteSignals are the local signals Exec forwards, with their SSH names.
+var remoteSignals = map[os.Signal]ssh.Signal{
+	syscall.SIGINT:  ssh.SIGINT,
+	syscall.SIGTERM: ssh.SIGTERM,
+	syscall.SIGQUIT: ssh.SIGQUIT,
+}
+
+// stopSelf stops the process the way the default SIGTSTP action would.
+func stopSelf() error {
+	return syscall.Kill(os.Getpid(), syscall.SIGSTOP)
+}
+
+// notifyResize delivers SIGWINCH to c.
+func notifyResize(c chan<- os.Signal, _ int, _ <-chan struct{}) {
+	signal.Notify(c, syscall.SIGWINCH)
+}
+
+// terminalSize returns the size of the terminal fd.
+func terminalSize(fd int) (width, height int, err error) {
+	return term.GetSize(fd)
+}
+
+// MakeRaw puts the terminal fd into raw mode and returns its previous state.
+func MakeRaw(fd int) (*term.State, error) {
+	return term.MakeRaw(fd)
+}
+
+// Restore returns the terminal fd to a state saved by MakeRaw.
+func Restore(fd int, state *term.State) error {
+	return term.Restore(fd, state)
+}
+
+func getHomeDir() (homeDir string, err error) {
+	usr, err := user.Current()
+	if err != nil {
+		return
+	}
+	return usr.HomeDir, nil
+}
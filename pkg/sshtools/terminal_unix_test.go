@@ -0,0 +1,111 @@
+//go:build !windows
+
+package sshtools
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+	"syscall"
+	"testing"
+)
+
+// signalTerminal is a Terminal in raw mode on a fakeTerminal, with
+// handleSignals running; exiting and stopping the process are recorded
+// instead. The signals sent are handled in order; stop waits until all
+// were.
+func signalTerminal(t *testing.T, f *fakeTerminal) (term *Terminal, sigCh chan<- os.Signal, stop func()) {
+	s := newTestServer(t)
+	term = NewTerminal(s.session(t), nil, &bytes.Buffer{}, &bytes.Buffer{})
+	term.raw = f.rawMode(&bytes.Buffer{})
+	if err := term.raw.enter(); err != nil {
+		t.Fatal(err)
+	}
+
+	exit, suspend := exitProcess, stopProcess
+	t.Cleanup(func() { exitProcess, stopProcess = exit, suspend })
+	exitProcess = func(code int) { f.record("exit") }
+	stopProcess = func() error {
+		f.record("stop")
+		return nil
+	}
+
+	// 无缓冲，发送返回时之前的信号都已处理完毕
+	signals := make(chan os.Signal)
+	stopCh, done := make(chan struct{}), make(chan struct{})
+	go func() {
+		defer close(done)
+		term.handleSignals(-1, signals, stopCh)
+	}()
+	var once sync.Once
+	stop = func() {
+		once.Do(func() { close(stopCh) })
+		<-done
+	}
+	t.Cleanup(stop)
+	return term, signals, stop
+}
+
+func TestHandleSignalsSuspend(t *testing.T) {
+	var f fakeTerminal
+	term, sigCh, stop := signalTerminal(t, &f)
+
+	sigCh <- syscall.SIGTSTP
+	sigCh <- syscall.SIGCONT
+	stop()
+	// 先恢复终端再停止进程，继续后重新进入原始模式
+	want := []string{"raw", "restore", "stop", "raw"}
+	if got := f.Calls(); !slices.Equal(got, want) {
+		t.Errorf("calls = %q, want %q", got, want)
+	}
+	if term.signal != nil {
+		t.Errorf("signal = %v after suspending", term.signal)
+	}
+}
+
+func TestHandleSignalsSuspendFails(t *testing.T) {
+	var f fakeTerminal
+	_, sigCh, stop := signalTerminal(t, &f)
+	stopProcess = func() error {
+		f.record("stop")
+		return errors.New("not supported")
+	}
+
+	sigCh <- syscall.SIGTSTP
+	stop()
+	// 无法停止时回到原始模式
+	want := []string{"raw", "restore", "stop", "raw"}
+	if got := f.Calls(); !slices.Equal(got, want) {
+		t.Errorf("calls = %q, want %q", got, want)
+	}
+}
+
+func TestHandleSignalsTerminate(t *testing.T) {
+	var f fakeTerminal
+	term, sigCh, stop := signalTerminal(t, &f)
+
+	// 第一次恢复终端并挂断，Run 正常返回
+	sigCh <- syscall.SIGTERM
+	hungUp := waitFor(func() bool {
+		term.mu.Lock()
+		defer term.mu.Unlock()
+		return term.closed
+	})
+	if !hungUp || !strings.Contains(term.exitMsg, "terminated") {
+		t.Errorf("closed = %v, exitMsg = %q, want a hang-up", hungUp, term.exitMsg)
+	}
+	if got := f.Calls(); !slices.Equal(got, []string{"raw", "restore"}) {
+		t.Errorf("calls = %q, want the terminal restored", got)
+	}
+
+	// 第二次立即退出，终端已恢复，之后也不再进入原始模式
+	sigCh <- syscall.SIGINT
+	sigCh <- syscall.SIGCONT
+	stop()
+	if got := f.Calls(); !slices.Equal(got, []string{"raw", "restore", "exit"}) {
+		t.Errorf("calls = %q, want an exit after the restore", got)
+	}
+}
@@ -0,0 +1,134 @@
+package sshtools
+
+import (
+	"errors"
+	"os"
+	"sync"
+	"syscall"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/sys/windows"
+	"golang.org/x/term"
+)
+
+// Signals handled while a terminal session runs. Windows has no window size
+// or job control signals.
+var (
+	terminateSignals = []os.Signal{os.Interrupt, syscall.SIGTERM}
+	suspendSignal    os.Signal
+	continueSignal   os.Signal
+)
+
+// remoteSignals are the local signals Exec forwards, with their SSH names.
+// Ctrl+Break arrives as SIGTERM.
+var remoteSignals = map[os.Signal]ssh.Signal{
+	os.Interrupt:    ssh.SIGINT,
+	syscall.SIGTERM: ssh.SIGTERM,
+}
+
+// resizePoll is how often the console size is checked, as the console
+// reports resizes only as input events.
+const resizePoll = 250 * time.Millisecond
+
+// consoleResized is sent by notifyResize in place of SIGWINCH.
+type consoleResized struct{}
+
+func (consoleResized) String() string { return "console resized" }
+func (consoleResized) Signal()        {}
+
+func stopSelf() error {
+	return errors.New("suspending is not supported on windows")
+}
+
+// notifyResize polls the console size and sends to c when it changed, until
+// stop is closed.
+func notifyResize(c chan<- os.Signal, fd int, stop <-chan struct{}) {
+	width, height, _ := terminalSize(fd)
+	go func() {
+		ticker := time.NewTicker(resizePoll)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-stop:
+				return
+			case <-ticker.C:
+			}
+			w, h, err := terminalSize(fd)
+			if err != nil || w == width && h == height {
+				continue
+			}
+			width, height = w, h
+			select {
+			case c <- consoleResized{}:
+			default:
+			}
+		}
+	}()
+}
+
+// terminalSize returns the size of the console window. The size belongs to
+// the screen buffer of the output, so it is read from stdout or stderr when
+// fd is the console input.
+func terminalSize(fd int) (width, height int, err error) {
+	for _, f := range []int{fd, int(os.Stdout.Fd()), int(os.Stderr.Fd())} {
+		if width, height, err = term.GetSize(f); err == nil {
+			return
+		}
+	}
+	return
+}
+
+// outputMode is the mode of the console output before MakeRaw enabled
+// escape sequence processing on it.
+var outputMode struct {
+	sync.Mutex
+	handle windows.Handle
+	mode   uint32
+	saved  bool
+}
+
+// MakeRaw puts the console fd into raw mode and returns its previous state.
+// Keys arrive as VT escape sequences, and escape sequences written to the
+// console output are interpreted, as a remote shell expects.
+func MakeRaw(fd int) (state *term.State, err error) {
+	if state, err = term.MakeRaw(fd); err != nil {
+		return
+	}
+	outputMode.Lock()
+	defer outputMode.Unlock()
+	if outputMode.saved {
+		return
+	}
+	for _, f := range []*os.File{os.Stdout, os.Stderr} {
+		h := windows.Handle(f.Fd())
+		var mode uint32
+		if windows.GetConsoleMode(h, &mode) != nil {
+			continue
+		}
+		if errs := windows.SetConsoleMode(h, mode|windows.ENABLE_VIRTUAL_TERMINAL_PROCESSING); errs == nil {
+			outputMode.handle, outputMode.mode, outputMode.saved = h, mode, true
+		}
+		break
+	}
+	return
+}
+
+// Restore returns the console fd, and its output, to the state saved by
+// MakeRaw.
+func Restore(fd int, state *term.State) error {
+	outputMode.Lock()
+	if outputMode.saved {
+		_ = windows.SetConsoleMode(outputMode.handle, outputMode.mode)
+		outputMode.saved = false
+	}
+	outputMode.Unlock()
+	return term.Restore(fd, state)
+}
+
+// getHomeDir returns the profile directory of the user running us. Unlike
+// user.Current it does not look the account up, which can take seconds on a
+// machine joined to an unreachable domain.
+func getHomeDir() (string, error) {
+	return windows.GetCurrentProcessToken().GetUserProfileDirectory()
+}
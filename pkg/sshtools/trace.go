@@ -0,0 +1,140 @@
+package sshtools
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// traceLevel is the verbosity (-vvv) at which channel and request activity
+// is logged.
+const traceLevel = 3
+
+// tracedConn logs the channels and global requests of a connection, as
+// ssh -vvv does.
+type tracedConn struct {
+	ssh.Conn
+	d    *Dialer
+	next atomic.Int32
+}
+
+// traceConn wraps a new connection for logging at traceLevel. Channels the
+// server opens and requests it sends are logged as they arrive.
+func (d *Dialer) traceConn(conn ssh.Conn, chans <-chan ssh.NewChannel, reqs <-chan *ssh.Request) (ssh.Conn, <-chan ssh.NewChannel, <-chan *ssh.Request) {
+	if d.Verbose < traceLevel {
+		return conn, chans, reqs
+	}
+	traced := make(chan ssh.NewChannel)
+	go func() {
+		defer close(traced)
+		for newChannel := range chans {
+			d.Logf(traceLevel, "server opened channel [%s]", newChannel.ChannelType())
+			traced <- newChannel
+		}
+	}()
+	return &tracedConn{Conn: conn, d: d}, traced, d.traceRequests("global", reqs)
+}
+
+// traceRequests logs the requests received on in as they are passed on.
+func (d *Dialer) traceRequests(from string, in <-chan *ssh.Request) <-chan *ssh.Request {
+	out := make(chan *ssh.Request)
+	go func() {
+		defer close(out)
+		for req := range in {
+			d.Logf(traceLevel, "%s: rcvd %s want_reply %v", from, req.Type, req.WantReply)
+			out <- req
+		}
+	}()
+	return out
+}
+
+func (c *tracedConn) OpenChannel(name string, data []byte) (ssh.Channel, <-chan *ssh.Request, error) {
+	id := c.next.Add(1) - 1
+	c.d.Logf(traceLevel, "channel %d: new [%s]", id, name)
+	channel, reqs, err := c.Conn.OpenChannel(name, data)
+	if err != nil {
+		c.d.Logf(traceLevel, "channel %d: open failed: %v", id, err)
+		return nil, nil, err
+	}
+	c.d.Logf(traceLevel, "channel %d: open confirmed", id)
+	from := fmt.Sprintf("channel %d", id)
+	return &tracedChannel{Channel: channel, d: c.d, from: from}, c.d.traceRequests(from, reqs), nil
+}
+
+func (c *tracedConn) SendRequest(name string, wantReply bool, payload []byte) (bool, []byte, error) {
+	ok, reply, err := c.Conn.SendRequest(name, wantReply, payload)
+	c.d.Logf(traceLevel, "global request %s want_reply %v: %s", name, wantReply, requestResult(wantReply, ok, err))
+	return ok, reply, err
+}
+
+// tracedChannel logs the requests sent on a channel and its end.
+type tracedChannel struct {
+	ssh.Channel
+	d    *Dialer
+	from string
+}
+
+func (c *tracedChannel) SendRequest(name string, wantReply bool, payload []byte) (bool, error) {
+	ok, err := c.Channel.SendRequest(name, wantReply, payload)
+	c.d.Logf(traceLevel, "%s: request %s want_reply %v: %s", c.from, name, wantReply, requestResult(wantReply, ok, err))
+	return ok, err
+}
+
+func (c *tracedChannel) CloseWrite() error {
+	c.d.Logf(traceLevel, "%s: send eof", c.from)
+	return c.Channel.CloseWrite()
+}
+
+func (c *tracedChannel) Close() error {
+	c.d.Logf(traceLevel, "%s: close", c.from)
+	return c.Channel.Close()
+}
+
+func requestResult(wantReply, ok bool, err error) string {
+	switch {
+	case err != nil:
+		return err.Error()
+	case !wantReply:
+		return "sent"
+	case ok:
+		return "success"
+	}
+	return "failure"
+}
+
+// LogFile writes diagnostics to a file, each line stamped with the time.
+type LogFile struct {
+	mu   sync.Mutex
+	file *os.File
+}
+
+// OpenLogFile opens path for appending diagnostics, as for -debug-file.
+func OpenLogFile(path string) (*LogFile, error) {
+	path, err := ExpandPath(path)
+	if err != nil {
+		return nil, err
+	}
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0o600)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open the log file: %v", err)
+	}
+	return &LogFile{file: file}, nil
+}
+
+func (l *LogFile) Write(p []byte) (int, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	line := strings.TrimRight(string(p), "\r\n")
+	// 同一文件可能由多个进程（如控制主进程）写入，带上进程号
+	_, err := fmt.Fprintf(l.file, "%s [%d] %s\n", time.Now().Format("2006-01-02T15:04:05.000Z07:00"), os.Getpid(), line)
+	return len(p), err
+}
+
+func (l *LogFile) Close() error {
+	return l.file.Close()
+}
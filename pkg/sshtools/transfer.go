@@ -0,0 +1,487 @@
+package sshtools
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/pkg/sftp"
+)
+
+// TransferOptions controls Upload and Download.
+type TransferOptions struct {
+	// Recursive copies directories with their contents.
+	Recursive bool
+	// PreserveTimes keeps modification times. Permissions are always kept.
+	PreserveTimes bool
+	// Progress counts the bytes copied, when set.
+	Progress *TransferProgress
+	// Log reports entries that were skipped, such as symlinks to
+	// directories.
+	Log func(format string, args ...any)
+	// Limit caps the transfer rate in bytes per second; 0 for no limit.
+	Limit int64
+	// Resume continues a partial destination file from its end when its
+	// last resumeBlock bytes match the source, instead of starting over.
+	Resume bool
+
+	limiter *rateLimiter
+}
+
+func (o *TransferOptions) logf(format string, args ...any) {
+	if o.Log != nil {
+		o.Log(format, args...)
+	}
+}
+
+// TransferProgress counts the bytes of a running transfer. It is safe to
+// read while the transfer runs.
+type TransferProgress struct {
+	total, done atomic.Int64
+
+	mu      sync.Mutex
+	current string
+}
+
+// Total returns the size of everything being transferred.
+func (p *TransferProgress) Total() int64 {
+	return p.total.Load()
+}
+
+// Done returns the bytes copied so far.
+func (p *TransferProgress) Done() int64 {
+	return p.done.Load()
+}
+
+// Current returns the file being copied.
+func (p *TransferProgress) Current() string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.current
+}
+
+func (p *TransferProgress) start(name string) {
+	if p == nil {
+		return
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.current = name
+}
+
+func (p *TransferProgress) reader(r io.Reader) io.Reader {
+	if p == nil {
+		return r
+	}
+	return &progressReader{r, p}
+}
+
+func (p *TransferProgress) writer(w io.Writer) io.Writer {
+	if p == nil {
+		return w
+	}
+	return &progressWriter{w, p}
+}
+
+// resumeBlock is how much of a partial file, up to its end, is compared
+// with the source before a transfer continues it.
+const resumeBlock = 1 << 20
+
+// rateLimiter holds a transfer to a number of bytes per second. Pauses in
+// the transfer do not build up credit beyond a second's worth.
+type rateLimiter struct {
+	limit int64
+
+	mu    sync.Mutex
+	start time.Time
+	sent  int64
+}
+
+// wait blocks until n more bytes may pass.
+func (l *rateLimiter) wait(n int) {
+	if l == nil || n == 0 {
+		return
+	}
+	l.mu.Lock()
+	now := time.Now()
+	due := l.start.Add(time.Duration(float64(l.sent) / float64(l.limit) * float64(time.Second)))
+	if l.start.IsZero() || now.Sub(due) > time.Second {
+		l.start, l.sent = now, 0
+	}
+	l.sent += int64(n)
+	ahead := time.Until(l.start.Add(time.Duration(float64(l.sent) / float64(l.limit) * float64(time.Second))))
+	l.mu.Unlock()
+	if ahead > 0 {
+		time.Sleep(ahead)
+	}
+}
+
+func (l *rateLimiter) reader(r io.Reader) io.Reader {
+	if l == nil {
+		return r
+	}
+	return &throttledReader{r, l}
+}
+
+func (l *rateLimiter) writer(w io.Writer) io.Writer {
+	if l == nil {
+		return w
+	}
+	return &throttledWriter{w, l}
+}
+
+type throttledReader struct {
+	r io.Reader
+	l *rateLimiter
+}
+
+func (r *throttledReader) Read(b []byte) (n int, err error) {
+	// 低速限制下缩小每次读取，避免一次读入数秒的数据后长时间停顿
+	if chunk := int(r.l.limit/10) + 1; len(b) > chunk {
+		b = b[:chunk]
+	}
+	n, err = r.r.Read(b)
+	r.l.wait(n)
+	return
+}
+
+type throttledWriter struct {
+	w io.Writer
+	l *rateLimiter
+}
+
+func (w *throttledWriter) Write(b []byte) (n int, err error) {
+	n, err = w.w.Write(b)
+	w.l.wait(n)
+	return
+}
+
+// ParseRate parses a transfer rate for -limit, such as 500K, 2MB/s or
+// 1.5M/s, in bytes per second (powers of 1024). An empty string or 0 mean
+// no limit.
+func ParseRate(s string) (rate int64, err error) {
+	rate, err = ParseSize(strings.TrimSuffix(strings.ToLower(strings.TrimSpace(s)), "/s"))
+	if err != nil {
+		return 0, fmt.Errorf("invalid rate %q, use e.g. 2MB/s", s)
+	}
+	return
+}
+
+// resumeOffset returns where to continue a partial copy dst of src: the
+// end of dst when its last resumeBlock bytes match src at the same offset,
+// or 0 to start over.
+func resumeOffset(src, dst io.ReaderAt, srcSize, dstSize int64) int64 {
+	if dstSize <= 0 || dstSize > srcSize {
+		return 0
+	}
+	n := min(dstSize, resumeBlock)
+	want, got := make([]byte, n), make([]byte, n)
+	if read, err := src.ReadAt(want, dstSize-n); int64(read) != n && err != nil {
+		return 0
+	}
+	if read, err := dst.ReadAt(got, dstSize-n); int64(read) != n && err != nil {
+		return 0
+	}
+	if !bytes.Equal(want, got) {
+		return 0
+	}
+	return dstSize
+}
+
+type progressReader struct {
+	r io.Reader
+	p *TransferProgress
+}
+
+func (r *progressReader) Read(b []byte) (n int, err error) {
+	n, err = r.r.Read(b)
+	r.p.done.Add(int64(n))
+	return
+}
+
+type progressWriter struct {
+	w io.Writer
+	p *TransferProgress
+}
+
+func (w *progressWriter) Write(b []byte) (n int, err error) {
+	n, err = w.w.Write(b)
+	w.p.done.Add(int64(n))
+	return
+}
+
+// Upload copies the local file or directory src to dst on the server.
+// When dst is an existing directory, or ends in "/", src is copied into it.
+func Upload(client *sftp.Client, src, dst string, opts TransferOptions) (err error) {
+	info, err := os.Stat(src)
+	if err != nil {
+		return
+	}
+	if info.IsDir() && !opts.Recursive {
+		return fmt.Errorf("%s is a directory (use -r)", src)
+	}
+	if opts.Limit > 0 {
+		opts.limiter = &rateLimiter{limit: opts.Limit}
+	}
+	if remote, errs := client.Stat(dst); strings.HasSuffix(dst, "/") || errs == nil && remote.IsDir() {
+		dst = path.Join(dst, filepath.Base(src))
+	}
+	if opts.Progress != nil {
+		opts.Progress.total.Store(info.Size())
+		if info.IsDir() {
+			opts.Progress.total.Store(localSize(src))
+		}
+	}
+	return upload(client, src, dst, info, &opts)
+}
+
+func upload(client *sftp.Client, src, dst string, info os.FileInfo, opts *TransferOptions) (err error) {
+	if !info.IsDir() {
+		if err = uploadFile(client, src, dst, opts); err != nil {
+			return
+		}
+		return setRemoteAttrs(client, dst, info, opts)
+	}
+	if err = client.MkdirAll(dst); err != nil {
+		return fmt.Errorf("failed to create %s: %v", dst, err)
+	}
+	entries, err := os.ReadDir(src)
+	if err != nil {
+		return
+	}
+	for _, entry := range entries {
+		name := filepath.Join(src, entry.Name())
+		child, errs := os.Stat(name)
+		switch {
+		case errs != nil:
+			opts.logf("skipping %s: %v", name, errs)
+			continue
+		case child.IsDir() && entry.Type()&os.ModeSymlink != 0:
+			opts.logf("skipping %s: symlink to a directory", name)
+			continue
+		case !child.IsDir() && !child.Mode().IsRegular():
+			opts.logf("skipping %s: not a regular file", name)
+			continue
+		}
+		if err = upload(client, name, path.Join(dst, entry.Name()), child, opts); err != nil {
+			return
+		}
+	}
+	// 目录权限最后设置，只读目录也能先写入内容
+	return setRemoteAttrs(client, dst, info, opts)
+}
+
+func uploadFile(client *sftp.Client, src, dst string, opts *TransferOptions) (err error) {
+	in, err := os.Open(src)
+	if err != nil {
+		return
+	}
+	defer func() {
+		_ = in.Close()
+	}()
+	flags, offset := os.O_WRONLY|os.O_CREATE|os.O_TRUNC, int64(0)
+	if opts.Resume {
+		if partial, errs := client.Open(dst); errs == nil {
+			offset = resumeOffset(in, partial, fileSize(in), fileSize(partial))
+			_ = partial.Close()
+		}
+	}
+	if offset > 0 {
+		flags &^= os.O_TRUNC
+	}
+	out, err := client.OpenFile(dst, flags)
+	if err != nil {
+		return fmt.Errorf("failed to create %s: %v", dst, err)
+	}
+	if err = resumeAt(in, out, offset, dst, opts); err != nil {
+		_ = out.Close()
+		return
+	}
+	opts.Progress.start(src)
+	if _, err = io.Copy(out, opts.limiter.reader(opts.Progress.reader(in))); err != nil {
+		_ = out.Close()
+		return fmt.Errorf("failed to upload %s: %v", dst, err)
+	}
+	return out.Close()
+}
+
+func setRemoteAttrs(client *sftp.Client, name string, info os.FileInfo, opts *TransferOptions) (err error) {
+	if err = client.Chmod(name, info.Mode().Perm()); err != nil {
+		return fmt.Errorf("failed to set mode on %s: %v", name, err)
+	}
+	if opts.PreserveTimes {
+		if err = client.Chtimes(name, info.ModTime(), info.ModTime()); err != nil {
+			return fmt.Errorf("failed to set times on %s: %v", name, err)
+		}
+	}
+	return
+}
+
+// Download copies the remote file or directory src to dst. When dst is an
+// existing directory, or ends in a path separator, src is copied into it.
+func Download(client *sftp.Client, src, dst string, opts TransferOptions) (err error) {
+	info, err := client.Stat(src)
+	if err != nil {
+		return fmt.Errorf("%s: %v", src, err)
+	}
+	if info.IsDir() && !opts.Recursive {
+		return fmt.Errorf("%s is a directory (use -r)", src)
+	}
+	if opts.Limit > 0 {
+		opts.limiter = &rateLimiter{limit: opts.Limit}
+	}
+	if local, errs := os.Stat(dst); strings.HasSuffix(dst, string(filepath.Separator)) || strings.HasSuffix(dst, "/") || errs == nil && local.IsDir() {
+		dst = filepath.Join(dst, path.Base(src))
+	}
+	if opts.Progress != nil {
+		opts.Progress.total.Store(info.Size())
+		if info.IsDir() {
+			opts.Progress.total.Store(remoteSize(client, src))
+		}
+	}
+	return download(client, src, dst, info, &opts)
+}
+
+func download(client *sftp.Client, src, dst string, info os.FileInfo, opts *TransferOptions) (err error) {
+	if !info.IsDir() {
+		if err = downloadFile(client, src, dst, info, opts); err != nil {
+			return
+		}
+		return setLocalAttrs(dst, info, opts)
+	}
+	if err = os.MkdirAll(dst, 0o755); err != nil {
+		return
+	}
+	entries, err := client.ReadDir(src)
+	if err != nil {
+		return fmt.Errorf("failed to list %s: %v", src, err)
+	}
+	for _, entry := range entries {
+		name := path.Join(src, entry.Name())
+		child := entry
+		if entry.Mode()&os.ModeSymlink != 0 {
+			if child, err = client.Stat(name); err != nil {
+				opts.logf("skipping %s: %v", name, err)
+				err = nil
+				continue
+			}
+			if child.IsDir() {
+				opts.logf("skipping %s: symlink to a directory", name)
+				continue
+			}
+		}
+		if !child.IsDir() && !child.Mode().IsRegular() {
+			opts.logf("skipping %s: not a regular file", name)
+			continue
+		}
+		if err = download(client, name, filepath.Join(dst, entry.Name()), child, opts); err != nil {
+			return
+		}
+	}
+	return setLocalAttrs(dst, info, opts)
+}
+
+func downloadFile(client *sftp.Client, src, dst string, info os.FileInfo, opts *TransferOptions) (err error) {
+	in, err := client.Open(src)
+	if err != nil {
+		return fmt.Errorf("failed to read %s: %v", src, err)
+	}
+	defer func() {
+		_ = in.Close()
+	}()
+	flags, offset := os.O_WRONLY|os.O_CREATE|os.O_TRUNC, int64(0)
+	if opts.Resume {
+		if partial, errs := os.Open(dst); errs == nil {
+			offset = resumeOffset(in, partial, info.Size(), fileSize(partial))
+			_ = partial.Close()
+		}
+	}
+	if offset > 0 {
+		flags &^= os.O_TRUNC
+	}
+	out, err := os.OpenFile(dst, flags, info.Mode().Perm())
+	if err != nil {
+		return
+	}
+	if err = resumeAt(in, out, offset, dst, opts); err != nil {
+		_ = out.Close()
+		return
+	}
+	opts.Progress.start(src)
+	if _, err = io.Copy(opts.limiter.writer(opts.Progress.writer(out)), in); err != nil {
+		_ = out.Close()
+		return fmt.Errorf("failed to download %s: %v", src, err)
+	}
+	return out.Close()
+}
+
+// fileSize returns the size of an open local or remote file, or -1.
+func fileSize(f interface{ Stat() (os.FileInfo, error) }) int64 {
+	info, err := f.Stat()
+	if err != nil {
+		return -1
+	}
+	return info.Size()
+}
+
+// resumeAt moves in and out to offset to continue a partial copy, and
+// takes the part already there out of the progress total.
+func resumeAt(in, out io.Seeker, offset int64, dst string, opts *TransferOptions) (err error) {
+	if offset == 0 {
+		return
+	}
+	if _, err = in.Seek(offset, io.SeekStart); err == nil {
+		_, err = out.Seek(offset, io.SeekStart)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to resume %s: %v", dst, err)
+	}
+	opts.logf("resuming %s after %s", dst, FormatBytes(float64(offset)))
+	if opts.Progress != nil {
+		opts.Progress.total.Add(-offset)
+	}
+	return
+}
+
+func setLocalAttrs(name string, info os.FileInfo, opts *TransferOptions) (err error) {
+	// The umask may have narrowed the mode the file was created with.
+	if err = os.Chmod(name, info.Mode().Perm()); err != nil {
+		return
+	}
+	if opts.PreserveTimes {
+		err = os.Chtimes(name, info.ModTime(), info.ModTime())
+	}
+	return
+}
+
+// localSize returns the size of the files under name that Upload copies.
+func localSize(name string) (size int64) {
+	_ = filepath.WalkDir(name, func(file string, d os.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return nil
+		}
+		if info, errs := os.Stat(file); errs == nil && info.Mode().IsRegular() {
+			size += info.Size()
+		}
+		return nil
+	})
+	return
+}
+
+// remoteSize returns the size of the regular files under name.
+func remoteSize(client *sftp.Client, name string) (size int64) {
+	walker := client.Walk(name)
+	for walker.Step() {
+		if walker.Err() == nil && walker.Stat().Mode().IsRegular() {
+			size += walker.Stat().Size()
+		}
+	}
+	return
+}
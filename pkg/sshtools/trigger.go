@@ -0,0 +1,105 @@
+package sshtools
+
+import (
+	"bytes"
+	"fmt"
+	"regexp"
+	"slices"
+	"sync"
+	"time"
+)
+
+// triggerCooldown is how long a trigger stays quiet after firing, so that a
+// burst of matching lines sends one notification.
+const triggerCooldown = 10 * time.Second
+
+// triggerLineMax bounds the unfinished line kept for matching.
+const triggerLineMax = 4 << 10
+
+// OutputTrigger sends a local notification when a line of interactive
+// session output matches Pattern, e.g. a long build finishing while you are
+// in another window.
+type OutputTrigger struct {
+	Pattern string `json:"pattern"`
+	// Message is the text of the notification, the matching line when
+	// empty.
+	Message string `json:"message,omitempty"`
+	// Bell also rings the terminal bell.
+	Bell bool `json:"bell,omitempty"`
+}
+
+// check returns what is wrong with the trigger, if anything.
+func (t OutputTrigger) check() error {
+	if t.Pattern == "" {
+		return fmt.Errorf(`needs a "pattern"`)
+	}
+	if _, err := regexp.Compile(t.Pattern); err != nil {
+		return fmt.Errorf("invalid pattern: %v", err)
+	}
+	return nil
+}
+
+// OutputTriggers returns the triggers of server: the top-level ones
+// followed by its own.
+func (c *Config) OutputTriggers(server *Server) []OutputTrigger {
+	return append(slices.Clip(c.Triggers), server.Triggers...)
+}
+
+// triggerWatcher matches the output, line by line and without escape
+// sequences, against triggers and calls fire for each match.
+type triggerWatcher struct {
+	triggers []OutputTrigger
+	patterns []*regexp.Regexp
+	fire     func(trigger OutputTrigger, line string)
+
+	mu    sync.Mutex
+	line  []byte
+	fired []time.Time
+}
+
+// newTriggerWatcher compiles triggers; ones that do not compile were
+// reported when the config was checked and are left out.
+func newTriggerWatcher(triggers []OutputTrigger, fire func(trigger OutputTrigger, line string)) *triggerWatcher {
+	w := &triggerWatcher{fire: fire}
+	for _, t := range triggers {
+		if re, err := regexp.Compile(t.Pattern); err == nil {
+			w.triggers = append(w.triggers, t)
+			w.patterns = append(w.patterns, re)
+		}
+	}
+	w.fired = make([]time.Time, len(w.triggers))
+	return w
+}
+
+func (w *triggerWatcher) Write(p []byte) (n int, err error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	n = len(p)
+	for len(p) > 0 {
+		i := bytes.IndexByte(p, '\n')
+		if i < 0 {
+			w.line = append(w.line, p...)
+			if len(w.line) > triggerLineMax {
+				w.line = w.line[len(w.line)-triggerLineMax:]
+			}
+			return
+		}
+		w.line = append(w.line, p[:i]...)
+		w.match(string(StripANSI(w.line)))
+		w.line = w.line[:0]
+		p = p[i+1:]
+	}
+	return
+}
+
+// match fires the triggers line matches that are not cooling down.
+func (w *triggerWatcher) match(line string) {
+	now := time.Now()
+	for i, re := range w.patterns {
+		if !re.MatchString(line) || now.Sub(w.fired[i]) < triggerCooldown {
+			continue
+		}
+		w.fired[i] = now
+		go w.fire(w.triggers[i], line)
+	}
+}
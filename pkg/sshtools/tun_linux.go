@@ -0,0 +1,50 @@
+package sshtools
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+
+	"golang.org/x/sys/unix"
+)
+
+// OpenTunDevice creates a tun device without packet information headers,
+// named by the kernel (tun0, tun1, ...). It goes away when closed.
+func OpenTunDevice() (dev *os.File, name string, err error) {
+	fd, err := unix.Open("/dev/net/tun", unix.O_RDWR|unix.O_CLOEXEC, 0)
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to open /dev/net/tun (sshtools vpn needs root): %v", err)
+	}
+	ifr, err := unix.NewIfreq("")
+	if err != nil {
+		_ = unix.Close(fd)
+		return
+	}
+	ifr.SetUint16(unix.IFF_TUN | unix.IFF_NO_PI)
+	if err = unix.IoctlIfreq(fd, unix.TUNSETIFF, ifr); err != nil {
+		_ = unix.Close(fd)
+		return nil, "", fmt.Errorf("failed to create a tun device: %v", err)
+	}
+	name = ifr.Name()
+	return os.NewFile(uintptr(fd), "/dev/net/tun"), name, nil
+}
+
+// ConfigureTunDevice gives the local device name its end of the link and
+// routes v's subnets through it.
+func ConfigureTunDevice(name string, v *VPN) error {
+	commands := [][]string{
+		{"addr", "add", v.LocalAddress, "peer", v.RemoteAddress, "dev", name},
+		{"link", "set", name, "mtu", strconv.Itoa(v.LinkMTU()), "up"},
+	}
+	for _, route := range v.Routes {
+		commands = append(commands, []string{"route", "add", route, "via", v.RemoteAddress, "dev", name})
+	}
+	for _, args := range commands {
+		if out, err := exec.Command("ip", args...).CombinedOutput(); err != nil {
+			return fmt.Errorf("ip %s failed: %v: %s", strings.Join(args, " "), err, strings.TrimSpace(string(out)))
+		}
+	}
+	return nil
+}
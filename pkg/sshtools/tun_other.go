@@ -0,0 +1,17 @@
+//go:build !linux
+
+package sshtools
+
+import (
+	"errors"
+	"os"
+	"runtime"
+)
+
+func OpenTunDevice() (dev *os.File, name string, err error) {
+	return nil, "", errors.New("sshtools vpn is not implemented on " + runtime.GOOS)
+}
+
+func ConfigureTunDevice(name string, v *VPN) error {
+	return errors.New("sshtools vpn is not implemented on " + runtime.GOOS)
+}
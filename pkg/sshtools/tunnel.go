@@ -0,0 +1,802 @@
+package sshtools
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Tunnel states recorded in TunnelState.
+const (
+	TunnelConnected    = "connected"
+	TunnelReconnecting = "reconnecting"
+)
+
+// tunnelKeepalive is how often a tunnel checks its SSH connection is alive
+// when the server has no keepalive_interval.
+const tunnelKeepalive = 15 * time.Second
+
+// tunnelMaxBackoff caps the wait between attempts to reconnect a tunnel.
+const tunnelMaxBackoff = 30 * time.Second
+
+// TunnelConfig is an entry of the config's tunnels section: named forwards
+// through a server that sshtools tunnels up keeps open in the background.
+type TunnelConfig struct {
+	Name string `json:"name"`
+	// Server is the alias of the server to tunnel through.
+	Server          string    `json:"server"`
+	LocalForwards   []Forward `json:"local_forwards,omitempty"`
+	RemoteForwards  []Forward `json:"remote_forwards,omitempty"`
+	DynamicForwards []string  `json:"dynamic_forwards,omitempty"`
+}
+
+// TunnelByName returns the entry of the tunnels section called name, or nil.
+func (c *Config) TunnelByName(name string) *TunnelConfig {
+	for i := range c.Tunnels {
+		if c.Tunnels[i].Name == name {
+			return &c.Tunnels[i]
+		}
+	}
+	return nil
+}
+
+// ParseForwardSpec parses an ssh -L style spec, [bind:]port:host:hostport,
+// into a Forward. Without a bind address Local is the bare port, which
+// LocalBindAddress resolves according to gateway_ports.
+func ParseForwardSpec(spec string) (f Forward, err error) {
+	parts := strings.Split(spec, ":")
+	// IPv6 hosts are written in brackets, which Split cuts apart.
+	if strings.Contains(spec, "[") {
+		parts = splitBracketed(spec)
+	}
+	bind := len(parts) == 4
+	switch len(parts) {
+	case 3:
+		parts = append([]string{""}, parts...)
+	case 4:
+	default:
+		return f, fmt.Errorf("invalid forward %q, want [bind:]port:host:hostport", spec)
+	}
+	for _, i := range []int{1, 3} {
+		if _, errs := net.LookupPort("tcp", parts[i]); errs != nil {
+			return f, fmt.Errorf("invalid forward %q: bad port %q", spec, parts[i])
+		}
+	}
+	trim := func(host string) string { return strings.TrimSuffix(strings.TrimPrefix(host, "["), "]") }
+	f = Forward{Local: parts[1], Remote: net.JoinHostPort(trim(parts[2]), parts[3])}
+	if bind {
+		f.Local = net.JoinHostPort(trim(parts[0]), parts[1])
+	}
+	return
+}
+
+// ParseRemoteForwardSpec parses an ssh -R style spec,
+// [bind:]port:host:hostport, into a Forward whose Remote is the address the
+// server listens on and Local the address connections go to. Without a
+// bind address Remote is the bare port, which RemoteBindAddress resolves.
+func ParseRemoteForwardSpec(spec string) (f Forward, err error) {
+	f, err = ParseForwardSpec(spec)
+	return Forward{Local: f.Remote, Remote: f.Local}, err
+}
+
+// splitBracketed splits spec at colons outside of [...].
+func splitBracketed(spec string) (parts []string) {
+	depth, start := 0, 0
+	for i, r := range spec {
+		switch r {
+		case '[':
+			depth++
+		case ']':
+			depth--
+		case ':':
+			if depth == 0 {
+				parts = append(parts, spec[start:i])
+				start = i + 1
+			}
+		}
+	}
+	return append(parts, spec[start:])
+}
+
+// TunnelState is what a tunnel daemon publishes about itself in
+// ~/.sshtools/tunnels/<alias>.json, or <name>.json for a named tunnel.
+type TunnelState struct {
+	Alias string `json:"alias"`
+	// Name is set for the tunnels of the config's tunnels section.
+	Name     string    `json:"name,omitempty"`
+	PID      int       `json:"pid"`
+	Started  time.Time `json:"started"`
+	Forwards []Forward `json:"forwards"`
+	// RemoteForwards listen on the server and connect back to Local.
+	RemoteForwards []Forward `json:"remote_forwards,omitempty"`
+	// DynamicForwards are the addresses of SOCKS5 proxies.
+	DynamicForwards []string  `json:"dynamic_forwards,omitempty"`
+	State           string    `json:"state"`
+	Reconnects      int       `json:"reconnects"`
+	AuthFailures    int       `json:"auth_failures,omitempty"`
+	LastError       string    `json:"last_error,omitempty"`
+	Connections     int64     `json:"connections"`
+	BytesIn         int64     `json:"bytes_in"`
+	BytesOut        int64     `json:"bytes_out"`
+	Updated         time.Time `json:"updated"`
+}
+
+// Running reports whether the daemon that wrote the state is still alive.
+func (s *TunnelState) Running() bool {
+	return processAlive(s.PID)
+}
+
+// TunnelDir returns the directory holding tunnel state, pid and log files.
+func TunnelDir() (string, error) {
+	return StateDir("tunnels")
+}
+
+// TunnelFile returns the path of a tunnel's file with the given extension
+// (".json", ".pid" or ".log").
+func TunnelFile(alias, ext string) (string, error) {
+	dir, err := TunnelDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, alias+ext), nil
+}
+
+// LoadTunnel reads the state of the tunnel to alias, or of the named tunnel.
+func LoadTunnel(alias string) (state *TunnelState, err error) {
+	file, err := TunnelFile(alias, ".json")
+	if err != nil {
+		return
+	}
+	data, err := os.ReadFile(file)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("no tunnel to %s", alias)
+	}
+	if err != nil {
+		return
+	}
+	state = &TunnelState{}
+	err = json.Unmarshal(data, state)
+	return
+}
+
+// ListTunnels reads the state of every tunnel, sorted by alias.
+func ListTunnels() (states []*TunnelState, err error) {
+	dir, err := TunnelDir()
+	if err != nil {
+		return
+	}
+	files, err := filepath.Glob(filepath.Join(dir, "*.json"))
+	if err != nil {
+		return
+	}
+	for _, file := range files {
+		state, errs := LoadTunnel(strings.TrimSuffix(filepath.Base(file), ".json"))
+		if errs != nil {
+			continue
+		}
+		states = append(states, state)
+	}
+	sort.Slice(states, func(i, j int) bool { return states[i].Alias < states[j].Alias })
+	return
+}
+
+// RemoveTunnel deletes the state and pid files of the tunnel to alias, or
+// of the named tunnel.
+func RemoveTunnel(alias string) {
+	for _, ext := range []string{".json", ".pid"} {
+		if file, err := TunnelFile(alias, ext); err == nil {
+			_ = os.Remove(file)
+		}
+	}
+}
+
+// Tunnel keeps local forwards listening while the SSH connection behind
+// them comes and goes. Connections accepted while there is no connection
+// are closed at once, so clients see a brief failure rather than the port
+// disappearing.
+type Tunnel struct {
+	Dialer *Dialer
+	// Dial opens the connection, e.g. through a control master; Dialer.Dial
+	// when nil.
+	Dial     func(server *Server) (*Client, error)
+	Server   *Server
+	Forwards []Forward
+	// Name is the name of a tunnel from the config's tunnels section; its
+	// state is published under the name rather than the server's alias.
+	Name string
+	// RemoteForwards are requested again on every reconnect, as the
+	// server drops them with the connection.
+	RemoteForwards []Forward
+	// DynamicForwards are addresses to run SOCKS5 proxies on.
+	DynamicForwards []string
+
+	mu     sync.Mutex
+	client *Client
+	// listeners, socks and remote are the listeners of Forwards,
+	// DynamicForwards and RemoteForwards, index by index; remote belong to
+	// the current connection.
+	listeners []net.Listener
+	socks     []net.Listener
+	remote    []net.Listener
+	state     TunnelState
+	stop      chan struct{}
+	initOnce  sync.Once
+	stopOnce  sync.Once
+
+	connections       atomic.Int64
+	bytesIn, bytesOut atomic.Int64
+}
+
+func (t *Tunnel) dial() (client *Client, err error) {
+	if t.Dial != nil {
+		client, err = t.Dial(t.Server)
+	} else {
+		client, err = t.Dialer.Dial(t.Server)
+	}
+	if err != nil && isAuthFailure(err) {
+		t.mu.Lock()
+		t.state.AuthFailures++
+		t.mu.Unlock()
+	}
+	return
+}
+
+func (t *Tunnel) init() {
+	t.initOnce.Do(func() {
+		t.stop = make(chan struct{})
+		t.resolveBindAddresses()
+		t.state = TunnelState{Alias: t.Server.Alias, Name: t.Name, PID: os.Getpid(), Started: time.Now(), Forwards: t.Forwards,
+			RemoteForwards: t.RemoteForwards, DynamicForwards: t.DynamicForwards, State: TunnelConnected}
+	})
+}
+
+// resolveBindAddresses replaces bare ports in the forwards with the
+// addresses they listen on, copying the slices, which may be the server's.
+func (t *Tunnel) resolveBindAddresses() {
+	forwards := make([]Forward, len(t.Forwards))
+	for i, f := range t.Forwards {
+		forwards[i] = Forward{Local: t.Server.LocalBindAddress(f.Local), Remote: f.Remote}
+	}
+	remote := make([]Forward, len(t.RemoteForwards))
+	for i, f := range t.RemoteForwards {
+		remote[i] = Forward{Local: f.Local, Remote: t.Server.RemoteBindAddress(f.Remote)}
+	}
+	dynamic := make([]string, len(t.DynamicForwards))
+	for i, addr := range t.DynamicForwards {
+		dynamic[i] = t.Server.LocalBindAddress(addr)
+	}
+	t.Forwards, t.RemoteForwards, t.DynamicForwards = forwards, remote, dynamic
+}
+
+// Listen connects and binds the local ends of the forwards. It fails if
+// either fails, so problems show up before the daemon detaches.
+func (t *Tunnel) Listen() (err error) {
+	t.init()
+	if err = t.open(); err != nil {
+		t.Close()
+		return
+	}
+	return t.save()
+}
+
+// Open makes one attempt to connect and bind the forwards like Listen, but
+// a failure only marks the tunnel as reconnecting, for Supervise to retry.
+func (t *Tunnel) Open() (err error) {
+	t.init()
+	if err = t.open(); err != nil {
+		t.setClient(nil, err.Error())
+		return
+	}
+	return t.save()
+}
+
+// Supervise keeps a tunnel up until Close is called, whether or not it was
+// opened: it retries Open with backoff until it succeeds and then serves.
+func (t *Tunnel) Supervise() {
+	t.init()
+	for backoff := time.Second; t.currentClient() == nil; backoff = min(backoff*2, tunnelMaxBackoff) {
+		select {
+		case <-t.stop:
+			return
+		case <-time.After(backoff):
+		}
+		if err := t.Open(); err != nil {
+			fmt.Fprintf(os.Stderr, "%s tunnel %s: %v\n", time.Now().Format(time.RFC3339), t.label(), err)
+			continue
+		}
+		fmt.Fprintf(os.Stderr, "%s tunnel %s is up\n", time.Now().Format(time.RFC3339), t.label())
+	}
+	t.Serve()
+}
+
+// key is the name the tunnel's state is published under.
+func (t *Tunnel) key() string {
+	if t.Name != "" {
+		return t.Name
+	}
+	return t.Server.Alias
+}
+
+// label names the tunnel in logs.
+func (t *Tunnel) label() string {
+	if t.Name != "" {
+		return t.Name + " (" + t.Server.Alias + ")"
+	}
+	return t.Server.Alias
+}
+
+// open dials and binds the listeners, undoing everything when one step
+// fails.
+func (t *Tunnel) open() (err error) {
+	client, err := t.dial()
+	if err != nil {
+		return
+	}
+	if err = t.listenRemote(client); err != nil {
+		_ = client.Close()
+		return
+	}
+	var listeners, socks []net.Listener
+	closeAll := func() {
+		for _, l := range append(listeners, socks...) {
+			_ = l.Close()
+		}
+		_ = client.Close()
+	}
+	for _, f := range t.Forwards {
+		listener, errs := net.Listen("tcp", f.Local)
+		if errs != nil {
+			closeAll()
+			return fmt.Errorf("failed to listen on %s: %v", f.Local, errs)
+		}
+		listeners = append(listeners, listener)
+	}
+	for _, addr := range t.DynamicForwards {
+		listener, errs := net.Listen("tcp", addr)
+		if errs != nil {
+			closeAll()
+			return fmt.Errorf("failed to listen on %s: %v", addr, errs)
+		}
+		socks = append(socks, listener)
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	select {
+	case <-t.stop:
+		// Close 已先行，不再启动
+		closeAll()
+		return fmt.Errorf("tunnel %s was closed", t.label())
+	default:
+	}
+	t.client, t.listeners, t.socks = client, listeners, socks
+	t.state.State = TunnelConnected
+	for i, f := range t.Forwards {
+		go t.accept(listeners[i], f.Remote)
+	}
+	for _, listener := range socks {
+		go t.acceptSOCKS(listener)
+	}
+	return
+}
+
+// Serve keeps the SSH connection up, reconnecting with backoff when it
+// drops, until Close is called.
+func (t *Tunnel) Serve() {
+	go t.publish()
+	for {
+		t.watch(t.currentClient())
+		select {
+		case <-t.stop:
+			return
+		default:
+		}
+
+		t.setClient(nil, "connection lost")
+		backoff := time.Second
+		for {
+			select {
+			case <-t.stop:
+				return
+			case <-time.After(backoff):
+			}
+			client, err := t.dial()
+			if err == nil {
+				if err = t.listenRemote(client); err != nil {
+					_ = client.Close()
+				}
+			}
+			if err == nil {
+				t.setClient(client, "")
+				fmt.Fprintf(os.Stderr, "%s reconnected to %s\n", time.Now().Format(time.RFC3339), t.label())
+				break
+			}
+			t.setClient(nil, err.Error())
+			fmt.Fprintf(os.Stderr, "%s reconnect to %s failed: %v (retrying in %s)\n", time.Now().Format(time.RFC3339), t.label(), err, backoff)
+			backoff = min(backoff*2, tunnelMaxBackoff)
+		}
+	}
+}
+
+// watch returns when client's connection ends, closing it when keepalives
+// go unanswered.
+func (t *Tunnel) watch(client *Client) {
+	done := make(chan struct{})
+	go func() {
+		_ = client.Wait()
+		close(done)
+	}()
+	ticker := time.NewTicker(tunnelKeepalive)
+	defer ticker.Stop()
+	if interval, _ := t.Server.Keepalive(); interval > 0 {
+		// 已配置 keepalive_interval 时由连接自身检查，超时后关闭连接
+		ticker.Stop()
+	}
+	for {
+		select {
+		case <-done:
+			return
+		case <-t.stop:
+			return
+		case <-ticker.C:
+			reply := make(chan error, 1)
+			go func() {
+				_, _, err := client.SendRequest("keepalive@openssh.com", true, nil)
+				reply <- err
+			}()
+			select {
+			case err := <-reply:
+				if err == nil {
+					continue
+				}
+			case <-time.After(tunnelKeepalive):
+			}
+			fmt.Fprintf(os.Stderr, "%s keepalive to %s failed, reconnecting\n", time.Now().Format(time.RFC3339), t.label())
+			_ = client.Close()
+		}
+	}
+}
+
+// Client returns the current connection, nil while the tunnel reconnects.
+func (t *Tunnel) Client() *Client {
+	return t.currentClient()
+}
+
+func (t *Tunnel) currentClient() *Client {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.client
+}
+
+func (t *Tunnel) setClient(client *Client, lastError string) {
+	t.mu.Lock()
+	if t.client != nil && t.client != client {
+		_ = t.client.Close()
+	}
+	t.client = client
+	if client != nil {
+		t.state.State = TunnelConnected
+		t.state.Reconnects++
+	} else {
+		t.state.State = TunnelReconnecting
+	}
+	if lastError != "" {
+		t.state.LastError = lastError
+	}
+	t.mu.Unlock()
+	_ = t.save()
+}
+
+// accept forwards connections to listener through whichever SSH connection
+// is current.
+func (t *Tunnel) accept(listener net.Listener, remoteAddr string) {
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		go func(local net.Conn) {
+			client := t.currentClient()
+			if client == nil {
+				_ = local.Close()
+				return
+			}
+			remote, errs := client.Dial("tcp", remoteAddr)
+			if errs != nil {
+				fmt.Fprintf(os.Stderr, "forward %s -> %s: %v\n", listener.Addr(), remoteAddr, errs)
+				_ = local.Close()
+				return
+			}
+			t.connections.Add(1)
+			pipe(&countedConn{Conn: local, read: &t.bytesOut, written: &t.bytesIn}, remote)
+		}(conn)
+	}
+}
+
+// acceptSOCKS serves SOCKS5 clients of listener through whichever SSH
+// connection is current.
+func (t *Tunnel) acceptSOCKS(listener net.Listener) {
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		go func(local net.Conn) {
+			client := t.currentClient()
+			if client == nil {
+				_ = local.Close()
+				return
+			}
+			remote, target, errs := socksConnect(local, client.Dial)
+			if errs != nil {
+				fmt.Fprintf(os.Stderr, "socks %s -> %s: %v\n", listener.Addr(), target, errs)
+				_ = local.Close()
+				return
+			}
+			t.connections.Add(1)
+			pipe(&countedConn{Conn: local, read: &t.bytesOut, written: &t.bytesIn}, remote)
+		}(conn)
+	}
+}
+
+// listenRemote asks the server for the remote forwards on client.
+func (t *Tunnel) listenRemote(client *Client) error {
+	t.mu.Lock()
+	forwards := slices.Clone(t.RemoteForwards)
+	t.mu.Unlock()
+	remote := make([]net.Listener, 0, len(forwards))
+	for _, f := range forwards {
+		listener, err := client.Listen("tcp", f.Remote)
+		if err != nil {
+			return fmt.Errorf("server refused to listen on %s: %v", f.Remote, err)
+		}
+		remote = append(remote, listener)
+		go t.acceptRemote(listener, f.Local)
+	}
+	t.mu.Lock()
+	t.remote = remote
+	t.mu.Unlock()
+	return nil
+}
+
+// acceptRemote forwards connections the server accepted on listener to
+// localAddr. It returns when the SSH connection goes away.
+func (t *Tunnel) acceptRemote(listener net.Listener, localAddr string) {
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		go func(remote net.Conn) {
+			local, errs := net.Dial("tcp", localAddr)
+			if errs != nil {
+				fmt.Fprintf(os.Stderr, "forward %s <- %s: %v\n", localAddr, listener.Addr(), errs)
+				_ = remote.Close()
+				return
+			}
+			t.connections.Add(1)
+			pipe(&countedConn{Conn: local, read: &t.bytesOut, written: &t.bytesIn}, remote)
+		}(conn)
+	}
+}
+
+// ListForwards lists the forwards of the tunnel, for sshtools fwd.
+func (t *Tunnel) ListForwards() (forwards []ForwardInfo) {
+	t.init()
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for _, f := range t.Forwards {
+		forwards = append(forwards, ForwardInfo{Kind: "L", Listen: f.Local, Target: f.Remote})
+	}
+	for _, f := range t.RemoteForwards {
+		forwards = append(forwards, ForwardInfo{Kind: "R", Listen: f.Remote, Target: f.Local})
+	}
+	for _, addr := range t.DynamicForwards {
+		forwards = append(forwards, ForwardInfo{Kind: "D", Listen: addr})
+	}
+	return
+}
+
+// AddForward opens another forward while the tunnel is connected. Like
+// the others, it is kept across reconnects.
+func (t *Tunnel) AddForward(kind, spec string) (info ForwardInfo, err error) {
+	t.init()
+	client := t.currentClient()
+	if client == nil {
+		return info, fmt.Errorf("tunnel %s is not connected, try again once it is", t.label())
+	}
+	var listener net.Listener
+	switch kind {
+	case "L":
+		f, errs := ParseForwardSpec(spec)
+		if errs != nil {
+			return info, errs
+		}
+		f.Local = t.Server.LocalBindAddress(f.Local)
+		if listener, err = net.Listen("tcp", f.Local); err != nil {
+			return info, fmt.Errorf("failed to listen on %s: %v", f.Local, err)
+		}
+		t.mu.Lock()
+		t.Forwards, t.listeners = append(slices.Clip(t.Forwards), f), append(t.listeners, listener)
+		t.mu.Unlock()
+		go t.accept(listener, f.Remote)
+		info = ForwardInfo{Kind: kind, Listen: f.Local, Target: f.Remote}
+	case "R":
+		f, errs := ParseRemoteForwardSpec(spec)
+		if errs != nil {
+			return info, errs
+		}
+		f.Remote = t.Server.RemoteBindAddress(f.Remote)
+		if listener, err = client.Listen("tcp", f.Remote); err != nil {
+			return info, fmt.Errorf("server refused to listen on %s: %v", f.Remote, err)
+		}
+		t.mu.Lock()
+		t.RemoteForwards, t.remote = append(slices.Clip(t.RemoteForwards), f), append(t.remote, listener)
+		t.mu.Unlock()
+		go t.acceptRemote(listener, f.Local)
+		info = ForwardInfo{Kind: kind, Listen: f.Remote, Target: f.Local}
+	case "D":
+		addr, errs := ParseDynamicSpec(spec)
+		if errs != nil {
+			return info, errs
+		}
+		addr = t.Server.LocalBindAddress(addr)
+		if listener, err = net.Listen("tcp", addr); err != nil {
+			return info, fmt.Errorf("failed to listen on %s: %v", addr, err)
+		}
+		t.mu.Lock()
+		t.DynamicForwards, t.socks = append(slices.Clip(t.DynamicForwards), addr), append(t.socks, listener)
+		t.mu.Unlock()
+		go t.acceptSOCKS(listener)
+		info = ForwardInfo{Kind: kind, Listen: addr}
+	default:
+		return info, fmt.Errorf("unknown forward kind %q, want L, R or D", kind)
+	}
+	t.publishForwards()
+	return
+}
+
+// CancelForward stops the forward of kind listening on listen for good.
+func (t *Tunnel) CancelForward(kind, listen string) (err error) {
+	t.init()
+	t.mu.Lock()
+	found := false
+	switch kind {
+	case "L":
+		for i, f := range t.Forwards {
+			if matchListen(f.Local, listen) || i < len(t.listeners) && matchListen(t.listeners[i].Addr().String(), listen) {
+				if i < len(t.listeners) {
+					_ = t.listeners[i].Close()
+					t.listeners = slices.Delete(t.listeners, i, i+1)
+				}
+				t.Forwards, found = slices.Delete(slices.Clone(t.Forwards), i, i+1), true
+				break
+			}
+		}
+	case "R":
+		for i, f := range t.RemoteForwards {
+			if matchListen(f.Remote, listen) {
+				// 关闭监听即向服务器发送 cancel-tcpip-forward
+				if i < len(t.remote) {
+					_ = t.remote[i].Close()
+					t.remote = slices.Delete(t.remote, i, i+1)
+				}
+				t.RemoteForwards, found = slices.Delete(slices.Clone(t.RemoteForwards), i, i+1), true
+				break
+			}
+		}
+	case "D":
+		for i, addr := range t.DynamicForwards {
+			if matchListen(addr, listen) || i < len(t.socks) && matchListen(t.socks[i].Addr().String(), listen) {
+				if i < len(t.socks) {
+					_ = t.socks[i].Close()
+					t.socks = slices.Delete(t.socks, i, i+1)
+				}
+				t.DynamicForwards, found = slices.Delete(slices.Clone(t.DynamicForwards), i, i+1), true
+				break
+			}
+		}
+	}
+	t.mu.Unlock()
+	if !found {
+		return fmt.Errorf("no -%s forward listens on %s", kind, listen)
+	}
+	t.publishForwards()
+	return
+}
+
+// publishForwards updates the forwards of the published state after
+// sshtools fwd changed them.
+func (t *Tunnel) publishForwards() {
+	t.mu.Lock()
+	t.state.Forwards, t.state.RemoteForwards, t.state.DynamicForwards = t.Forwards, t.RemoteForwards, t.DynamicForwards
+	t.mu.Unlock()
+	_ = t.save()
+}
+
+// publish writes the state file every few seconds so status can show
+// current counters.
+func (t *Tunnel) publish() {
+	ticker := time.NewTicker(5 * time.Second)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-t.stop:
+			return
+		case <-ticker.C:
+			_ = t.save()
+		}
+	}
+}
+
+// State returns the tunnel's current state and counters.
+func (t *Tunnel) State() (state TunnelState) {
+	t.mu.Lock()
+	state = t.state
+	t.mu.Unlock()
+	state.Connections = t.connections.Load()
+	state.BytesIn, state.BytesOut = t.bytesIn.Load(), t.bytesOut.Load()
+	state.Updated = time.Now()
+	return
+}
+
+func (t *Tunnel) save() (err error) {
+	state := t.State()
+	file, err := TunnelFile(t.key(), ".json")
+	if err != nil {
+		return
+	}
+	data, err := json.MarshalIndent(state, "", "  ")
+	if err != nil {
+		return
+	}
+	tmp := file + ".tmp"
+	if err = os.WriteFile(tmp, data, 0o600); err == nil {
+		err = os.Rename(tmp, file)
+	}
+	return
+}
+
+// Close stops the listeners and the SSH connection; Serve returns.
+func (t *Tunnel) Close() {
+	t.init()
+	t.stopOnce.Do(func() {
+		close(t.stop)
+		t.mu.Lock()
+		defer t.mu.Unlock()
+		for _, l := range append(t.listeners, t.socks...) {
+			_ = l.Close()
+		}
+		if t.client != nil {
+			_ = t.client.Close()
+		}
+	})
+}
+
+// countedConn adds the bytes read from and written to a connection to
+// shared counters.
+type countedConn struct {
+	net.Conn
+	read, written *atomic.Int64
+}
+
+func (c *countedConn) Read(b []byte) (n int, err error) {
+	n, err = c.Conn.Read(b)
+	c.read.Add(int64(n))
+	return
+}
+
+func (c *countedConn) Write(b []byte) (n int, err error) {
+	n, err = c.Conn.Write(b)
+	c.written.Add(int64(n))
+	return
+}
@@ -0,0 +1,669 @@
+package sshtools
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+)
+
+// defaultPort is used for servers configured without a port.
+const defaultPort = 22
+
+// Problem is one issue found while validating a config file. Index is the
+// position in the servers list, or -1 for top-level settings. Fix, when
+// set, suggests how to resolve it.
+type Problem struct {
+	Index   int
+	Alias   string
+	Message string
+	Warning bool
+	Fix     string
+}
+
+func (p Problem) String() string {
+	prefix := "config"
+	if p.Index >= 0 {
+		prefix = fmt.Sprintf("servers[%d]", p.Index)
+		if p.Alias != "" {
+			prefix += fmt.Sprintf(" (%s)", p.Alias)
+		}
+	}
+	if p.Warning {
+		return prefix + ": warning: " + p.Message
+	}
+	return prefix + ": " + p.Message
+}
+
+// ValidationError lists every error found in a config file.
+type ValidationError struct {
+	File     string
+	Problems []Problem
+}
+
+func (e *ValidationError) Error() string {
+	lines := make([]string, 0, len(e.Problems)+1)
+	lines = append(lines, fmt.Sprintf("%s has %d problem(s):", e.File, len(e.Problems)))
+	for _, p := range e.Problems {
+		lines = append(lines, "  "+p.String())
+	}
+	return strings.Join(lines, "\n")
+}
+
+// errorsOnly drops the warnings from problems.
+func errorsOnly(problems []Problem) (errs []Problem) {
+	for _, p := range problems {
+		if !p.Warning {
+			errs = append(errs, p)
+		}
+	}
+	return
+}
+
+// ParseConfig decodes and validates a config file. err is only set when the
+// JSON itself cannot be parsed; everything else is reported as problems.
+func ParseConfig(data []byte) (config *Config, problems []Problem, err error) {
+	config = &Config{}
+	if err = json.Unmarshal(data, config); err != nil {
+		err = jsonError(data, err)
+		return
+	}
+
+	var raw struct {
+		Servers   []map[string]json.RawMessage `json:"servers"`
+		Tunnels   []map[string]json.RawMessage `json:"tunnels"`
+		Providers []map[string]json.RawMessage `json:"providers"`
+	}
+	var top map[string]json.RawMessage
+	_ = json.Unmarshal(data, &raw)
+	_ = json.Unmarshal(data, &top)
+	for _, name := range unknownFields(top, reflect.TypeFor[Config]()) {
+		problems = append(problems, Problem{Index: -1, Message: name})
+	}
+	for i := range raw.Servers {
+		for _, name := range unknownFields(raw.Servers[i], reflect.TypeFor[Server]()) {
+			problems = append(problems, Problem{Index: i, Alias: config.Servers[i].Alias, Message: name})
+		}
+	}
+	for i := range raw.Tunnels {
+		for _, name := range unknownFields(raw.Tunnels[i], reflect.TypeFor[TunnelConfig]()) {
+			problems = append(problems, Problem{Index: -1, Message: fmt.Sprintf("tunnels[%d]: %s", i, name)})
+		}
+	}
+	for i := range raw.Providers {
+		for _, name := range unknownFields(raw.Providers[i], reflect.TypeFor[ProviderConfig]()) {
+			problems = append(problems, Problem{Index: -1, Message: fmt.Sprintf("providers[%d]: %s", i, name)})
+		}
+	}
+
+	if config.ImportSSHConfig != "" {
+		problems = append(problems, config.importSSHConfig()...)
+	}
+	problems = append(problems, config.validate()...)
+	sort.SliceStable(problems, func(i, j int) bool { return problems[i].Index < problems[j].Index })
+	return
+}
+
+// validate checks the decoded servers and fills in defaults.
+func (c *Config) validate() (problems []Problem) {
+	aliases := map[string]int{}
+	addresses := map[string]int{}
+	for i := range c.Servers {
+		s := &c.Servers[i]
+		add := func(warning bool, format string, args ...any) {
+			problems = append(problems, Problem{Index: i, Alias: s.Alias, Message: fmt.Sprintf(format, args...), Warning: warning})
+		}
+		fix := func(format string, args ...any) {
+			problems[len(problems)-1].Fix = fmt.Sprintf(format, args...)
+		}
+
+		if s.Alias == "" {
+			add(false, `"alias" is required`)
+		} else if j, ok := aliases[foldAlias(s.Alias)]; ok {
+			add(false, "duplicate alias, already used by servers[%d]; this entry is unreachable", j)
+			fix(`rename one of the two "alias" values, or remove this entry`)
+		} else {
+			aliases[foldAlias(s.Alias)] = i
+		}
+		if s.Address == "" && s.UsesSSM() && s.InstanceID != "" {
+			// 经 Session Manager 连接时地址只用于 known_hosts
+			s.Address = s.InstanceID
+		}
+		if s.Address == "" {
+			add(false, `"address" is required`)
+		}
+		for j, address := range s.Addresses {
+			if address == "" {
+				add(false, `address[%d] is empty`, j)
+			}
+		}
+		switch s.AddressFamily {
+		case "", FamilyAny, FamilyInet, FamilyInet6:
+		default:
+			add(false, `"address_family" %q must be any, inet or inet6`, s.AddressFamily)
+		}
+		if s.User == "" {
+			add(false, `"user" is required`)
+		}
+		if s.Port == 0 {
+			s.Port = defaultPort
+		} else if s.Port < 0 || s.Port > 65535 {
+			add(false, `"port" %d is out of range`, s.Port)
+		}
+		if s.Address != "" {
+			if j, ok := addresses[s.Addr()]; ok {
+				add(true, "same address as servers[%d] (%s)", j, s.Addr())
+			} else {
+				addresses[s.Addr()] = i
+			}
+		}
+
+		if s.UseKey {
+			if s.PrivateKey == "" {
+				add(false, `"use_key" is set but "private_key" is empty`)
+				fix(`set "private_key" to a key file, or create one with: sshtools keygen -server %s`, s.Alias)
+			} else if IsEncrypted(s.PrivateKey) || HasReference(s.PrivateKey) {
+				// 加密或引用的路径解密、替换前无法检查
+			} else if keyPath, err := s.expandPath("private_key", s.PrivateKey); err != nil {
+				add(true, "%v", err)
+			} else if _, err = os.Stat(keyPath); err != nil {
+				add(true, "private key %s not found on this machine", keyPath)
+				fix(`correct "private_key", or create the key with: sshtools keygen -out %s`, keyPath)
+			} else if certPath, err := s.certificatePath(keyPath); err != nil {
+				add(true, "%v", err)
+			} else if certPath != "" {
+				if _, err = loadCertificate(certPath, time.Now()); err != nil {
+					add(true, "%v", err)
+				}
+			}
+		} else if s.Certificate != "" {
+			add(true, `"certificate" is ignored without "use_key"`)
+		}
+		if s.PKCS11Module != "" {
+			if module, err := s.expandPath("pkcs11_module", s.PKCS11Module); err != nil {
+				add(true, "%v", err)
+			} else if _, err = os.Stat(module); err != nil {
+				add(true, "PKCS#11 module %s not found on this machine", module)
+			}
+		}
+		switch {
+		case s.PasswordSource != "" && s.PasswordSource != PasswordSourceKeychain:
+			add(false, `"password_source" %q must be %s`, s.PasswordSource, PasswordSourceKeychain)
+		case s.PasswordSource != "" && s.Password != "":
+			add(false, `"password" and "password_source" cannot both be set`)
+		case s.PasswordSource != "" && s.PassphraseCommand != "" && s.UseKey:
+			add(true, `"password_source" is ignored, "passphrase_command" supplies the passphrase`)
+		}
+		for _, msg := range checkKeySource(s.KeySource) {
+			add(false, "%s", msg)
+		}
+		for _, msg := range checkTerminalModes(s.TerminalModes) {
+			add(false, "%s", msg)
+		}
+		for j, value := range []string{s.Password, s.PrivateKey} {
+			if !HasReference(value) {
+				continue
+			}
+			if err := checkReference(value); err != nil {
+				add(false, `"%s": %v`, secretFields[j], err)
+			}
+		}
+		for _, value := range s.HostCAKeys {
+			if _, err := parseCAKey(value); err != nil {
+				add(false, "%v", err)
+			}
+		}
+		if len(s.HostCAKeys) == 0 {
+			s.HostCAKeys = c.HostCAKeys
+		}
+		if !validHostKeyChecking(s.StrictHostKeyChecking) {
+			add(false, `"strict_host_key_checking" %q must be %s, %s, %s or %s`, s.StrictHostKeyChecking, HostKeyAsk, HostKeyYes, HostKeyAcceptNew, HostKeyNo)
+		}
+		if s.StrictHostKeyChecking == "" {
+			s.StrictHostKeyChecking = c.StrictHostKeyChecking
+		}
+		if !validDNSVerify(s.VerifyHostKeyDNS) {
+			add(false, `"verify_host_key_dns" %q must be %s, %s or %s`, s.VerifyHostKeyDNS, DNSVerifyNo, DNSVerifyYes, DNSVerifyRequire)
+		}
+		if s.VerifyHostKeyDNS == "" {
+			s.VerifyHostKeyDNS = c.VerifyHostKeyDNS
+		}
+		if s.DNSResolver == "" {
+			s.DNSResolver = c.DNSResolver
+		}
+		for _, msg := range checkKeepalive(s.KeepaliveInterval, s.KeepaliveCountMax) {
+			add(false, "%s", msg)
+		}
+		if s.KeepaliveInterval == "" {
+			s.KeepaliveInterval = c.KeepaliveInterval
+		}
+		if s.KeepaliveCountMax == 0 {
+			s.KeepaliveCountMax = c.KeepaliveCountMax
+		}
+		for _, msg := range checkConnect(s.ConnectTimeout, s.ConnectionAttempts) {
+			add(false, "%s", msg)
+		}
+		if s.ConnectTimeout == "" {
+			s.ConnectTimeout = c.ConnectTimeout
+		}
+		if s.ConnectionAttempts == 0 {
+			s.ConnectionAttempts = c.ConnectionAttempts
+		}
+		if s.Proxy != "" && s.Proxy != ProxyNone {
+			if _, err := parseProxy(s.Proxy); err != nil {
+				add(false, "%v", err)
+			} else if s.ProxyCommand != "" {
+				add(false, `"proxy" and "proxy_command" cannot both be set`)
+			} else if s.ProxyJump != "" {
+				add(false, `"proxy" and "proxy_jump" cannot both be set, set "proxy" on the jump host instead`)
+			}
+		}
+		switch {
+		case s.Transport != "" && s.Transport != TransportTCP && s.Transport != TransportSSM:
+			add(false, `"transport" %q must be %s or %s`, s.Transport, TransportTCP, TransportSSM)
+		case s.UsesSSM() && s.InstanceID == "":
+			add(false, `"transport" %s needs the EC2 "instance_id"`, TransportSSM)
+		case s.UsesSSM() && (s.ProxyCommand != "" || s.ProxyJump != "" || s.WebSocket != "" || s.UsesProxy()):
+			add(false, `"transport" %s cannot be combined with "proxy", "proxy_command", "proxy_jump" or "websocket"`, TransportSSM)
+		case !s.UsesSSM() && (s.AWSRegion != "" || s.AWSProfile != ""):
+			add(true, `"aws_region" and "aws_profile" are ignored without "transport": %q`, TransportSSM)
+		}
+		if s.WebSocket != "" {
+			if _, err := s.webSocketURL(); err != nil {
+				add(false, "%v", err)
+			} else if s.ProxyCommand != "" {
+				add(false, `"websocket" and "proxy_command" cannot both be set`)
+			} else if s.ProxyJump != "" {
+				add(false, `"websocket" and "proxy_jump" cannot both be set, set "websocket" on the jump host instead`)
+			}
+		} else if s.WebSocketToken != "" || len(s.WebSocketHeaders) > 0 {
+			add(true, `"websocket_token" and "websocket_headers" are ignored without "websocket"`)
+		}
+		// 环境变量在连接时才读取，这里只检查引用的写法
+		if _, err := s.webSocketHeader(func(string) (string, bool) { return "", true }); err != nil {
+			add(false, "%v", err)
+		}
+		if s.Proxy == "" && s.ProxyCommand == "" && s.ProxyJump == "" && !s.UsesSSM() {
+			s.Proxy = c.Proxy
+		}
+		for _, msg := range checkWake(s) {
+			add(false, "%s", msg)
+		}
+		if s.Sunset != "" {
+			if _, err := time.Parse(sunsetLayout, s.Sunset); err != nil {
+				add(false, `"sunset" %q is not a YYYY-MM-DD date`, s.Sunset)
+			}
+		}
+		if s.HostKeyFingerprint != "" {
+			if _, err := parseHostKeyPin(s.HostKeyFingerprint); err != nil {
+				add(false, "%v", err)
+			}
+		}
+		for _, msg := range s.Algorithms.check() {
+			add(false, "%s", msg)
+		}
+		s.Algorithms = s.Algorithms.withDefaults(c.Algorithms)
+		for name := range s.SetEnv {
+			if !envName.MatchString(name) {
+				add(false, `"set_env" has invalid variable name %q`, name)
+			}
+		}
+		if s.AttachSession != "" && s.AttachSession != AttachNone {
+			if _, err := AttachCommand(s.AttachSession); err != nil {
+				add(false, "%v", err)
+			}
+		}
+		for _, msg := range checkIdle(s.IdleTimeout, s.IdleAction) {
+			add(false, "%s", msg)
+		}
+		for _, msg := range checkLimits(s.CommandTimeout, s.SessionMaxDuration) {
+			add(false, "%s", msg)
+		}
+		for _, msg := range checkAuthMethods(s.AuthMethods) {
+			add(false, "%s", msg)
+		}
+		if len(s.AuthMethods) == 0 {
+			s.AuthMethods = c.AuthMethods
+		}
+		if s.CommandTimeout == "" {
+			s.CommandTimeout = c.CommandTimeout
+		}
+		if s.SessionMaxDuration == "" {
+			s.SessionMaxDuration = c.SessionMaxDuration
+		}
+		if s.IdleTimeout == "" {
+			s.IdleTimeout = c.IdleTimeout
+		}
+		if s.IdleAction == "" {
+			s.IdleAction = c.IdleAction
+		}
+		if s.IdleCommand == "" {
+			s.IdleCommand = c.IdleCommand
+		}
+		if s.IdleAction == IdleCommand && s.IdleCommand == "" {
+			add(false, `"idle_action" %s needs an "idle_command"`, IdleCommand)
+		}
+		if s.VPN != nil {
+			if err := s.VPN.Check(); err != nil {
+				add(false, "%v", err)
+			}
+		}
+		if s.Become != nil {
+			if err := s.Become.check(); err != nil {
+				add(false, "%v", err)
+			}
+		}
+		for j, step := range s.ExpectScript {
+			if err := step.check(); err != nil {
+				add(false, "expect_script[%d] %v", j, err)
+			}
+		}
+		for j, t := range s.Triggers {
+			if err := t.check(); err != nil {
+				add(false, "triggers[%d] %v", j, err)
+			}
+		}
+		for j, f := range s.LocalForwards {
+			if f.Local == "" || f.Remote == "" {
+				add(false, `local_forwards[%d] needs both "local" and "remote"`, j)
+			}
+		}
+		for j, f := range s.RemoteForwards {
+			if f.Local == "" || f.Remote == "" {
+				add(false, `remote_forwards[%d] needs both "local" and "remote"`, j)
+			}
+		}
+		for j, spec := range s.DynamicForwards {
+			if addr, err := ParseDynamicSpec(spec); err != nil {
+				add(false, "dynamic_forwards[%d]: %v", j, err)
+			} else {
+				s.DynamicForwards[j] = addr
+			}
+		}
+	}
+
+	checkBanner := func(index int, alias, banner, color string) {
+		if banner != "" {
+			if _, err := renderBanner(banner, BannerData{}); err != nil {
+				problems = append(problems, Problem{Index: index, Alias: alias, Message: err.Error()})
+			}
+		}
+		if color != "" {
+			if _, err := colorCode(color); err != nil {
+				problems = append(problems, Problem{Index: index, Alias: alias, Message: fmt.Sprintf(`%v, use one of %s (optionally "bold ...")`, err, strings.Join(ColorNames(), ", "))})
+			}
+		}
+	}
+	for _, msg := range c.Algorithms.check() {
+		problems = append(problems, Problem{Index: -1, Message: msg})
+	}
+	for _, name := range c.CommandNames() {
+		if _, err := parseCommand(name, c.Commands[name]); err != nil {
+			problems = append(problems, Problem{Index: -1, Message: err.Error()})
+		}
+	}
+	for j, t := range c.Triggers {
+		if err := t.check(); err != nil {
+			problems = append(problems, Problem{Index: -1, Message: fmt.Sprintf("triggers[%d] %v", j, err)})
+		}
+	}
+	if c.DefaultAttachSession != "" && c.DefaultAttachSession != AttachNone {
+		if _, err := AttachCommand(c.DefaultAttachSession); err != nil {
+			problems = append(problems, Problem{Index: -1, Message: err.Error()})
+		}
+	}
+	for _, value := range c.HostCAKeys {
+		if _, err := parseCAKey(value); err != nil {
+			problems = append(problems, Problem{Index: -1, Message: err.Error()})
+		}
+	}
+	if c.Encryption == nil {
+		for i := range c.Servers {
+			if IsEncrypted(c.Servers[i].Password) || IsEncrypted(c.Servers[i].PrivateKey) {
+				problems = append(problems, Problem{Index: i, Alias: c.Servers[i].Alias, Message: `has encrypted values but the config has no "encryption" settings`})
+			}
+		}
+	}
+	if !validHostKeyChecking(c.StrictHostKeyChecking) {
+		problems = append(problems, Problem{Index: -1, Message: fmt.Sprintf(`"strict_host_key_checking" %q must be %s, %s, %s or %s`,
+			c.StrictHostKeyChecking, HostKeyAsk, HostKeyYes, HostKeyAcceptNew, HostKeyNo)})
+	}
+	if !validDNSVerify(c.VerifyHostKeyDNS) {
+		problems = append(problems, Problem{Index: -1, Message: fmt.Sprintf(`"verify_host_key_dns" %q must be %s, %s or %s`,
+			c.VerifyHostKeyDNS, DNSVerifyNo, DNSVerifyYes, DNSVerifyRequire)})
+	}
+	for _, msg := range checkKeepalive(c.KeepaliveInterval, c.KeepaliveCountMax) {
+		problems = append(problems, Problem{Index: -1, Message: msg})
+	}
+	for _, msg := range checkConnect(c.ConnectTimeout, c.ConnectionAttempts) {
+		problems = append(problems, Problem{Index: -1, Message: msg})
+	}
+	for _, msg := range checkIdle(c.IdleTimeout, c.IdleAction) {
+		problems = append(problems, Problem{Index: -1, Message: msg})
+	}
+	for _, msg := range checkLimits(c.CommandTimeout, c.SessionMaxDuration) {
+		problems = append(problems, Problem{Index: -1, Message: msg})
+	}
+	for _, msg := range checkAuthMethods(c.AuthMethods) {
+		problems = append(problems, Problem{Index: -1, Message: msg})
+	}
+	for _, msg := range checkRedactPatterns(c.RedactPatterns) {
+		problems = append(problems, Problem{Index: -1, Message: msg})
+	}
+	if c.Proxy != "" {
+		if _, err := parseProxy(c.Proxy); err != nil {
+			problems = append(problems, Problem{Index: -1, Message: err.Error()})
+		}
+	}
+	checkBanner(-1, "", c.Banner, c.BannerColor)
+	for _, color := range c.TagColors {
+		checkBanner(-1, "", "", color)
+	}
+	for i := range c.Servers {
+		checkBanner(i, c.Servers[i].Alias, c.Servers[i].Banner, c.Servers[i].BannerColor)
+	}
+
+	defaultServer := -1
+	for i := range c.Servers {
+		if !c.Servers[i].Default {
+			continue
+		}
+		if defaultServer >= 0 {
+			problems = append(problems, Problem{Index: i, Alias: c.Servers[i].Alias,
+				Message: fmt.Sprintf(`"default" is already set on %s, only one server can be the default`, c.Servers[defaultServer].Alias)})
+			continue
+		}
+		defaultServer = i
+	}
+
+	// Redirect targets and jump hosts can come later in the list, so check
+	// them last.
+	for i := range c.Servers {
+		s := &c.Servers[i]
+		if s.RedirectTo != "" && c.ServerByAlias(s.RedirectTo) == nil {
+			problems = append(problems, Problem{Index: i, Alias: s.Alias, Message: fmt.Sprintf(`"redirect_to" names unknown alias %q`, s.RedirectTo),
+				Fix: "add a server with that alias, or correct the name"})
+		}
+		if s.WakeRelay != "" {
+			if relay := c.ServerByAlias(s.WakeRelay); relay == nil {
+				problems = append(problems, Problem{Index: i, Alias: s.Alias, Message: fmt.Sprintf(`"wake_relay" names unknown alias %q`, s.WakeRelay)})
+			} else if relay == s {
+				problems = append(problems, Problem{Index: i, Alias: s.Alias, Message: `"wake_relay" cannot be the server itself`})
+			}
+		}
+		if s.ProxyJump == "" {
+			continue
+		}
+		if s.ProxyCommand != "" {
+			problems = append(problems, Problem{Index: i, Alias: s.Alias, Message: `"proxy_jump" and "proxy_command" cannot both be set`})
+		}
+		chain, err := c.jumpChain(s, map[string]bool{})
+		if err != nil {
+			problems = append(problems, Problem{Index: i, Alias: s.Alias, Message: err.Error(),
+				Fix: `make "proxy_jump" name servers of this config, without going in a circle`})
+		}
+		s.JumpHosts = chain
+	}
+	problems = append(problems, c.validateTunnels()...)
+	problems = append(problems, c.checkProviders()...)
+	return
+}
+
+// validateTunnels checks the tunnels section against the servers.
+func (c *Config) validateTunnels() (problems []Problem) {
+	names := map[string]int{}
+	for i := range c.Tunnels {
+		t := &c.Tunnels[i]
+		add := func(format string, args ...any) {
+			prefix := fmt.Sprintf("tunnels[%d]", i)
+			if t.Name != "" {
+				prefix += fmt.Sprintf(" (%s)", t.Name)
+			}
+			problems = append(problems, Problem{Index: -1, Message: prefix + ": " + fmt.Sprintf(format, args...)})
+		}
+
+		// 名称用作状态文件名
+		switch j, ok := names[t.Name]; {
+		case t.Name == "":
+			add(`"name" is required`)
+		case strings.ContainsAny(t.Name, `/\`) || strings.HasPrefix(t.Name, "."):
+			add(`"name" cannot contain slashes or start with a dot`)
+		case ok:
+			add("duplicate name, already used by tunnels[%d]", j)
+		default:
+			names[t.Name] = i
+		}
+		if t.Server == "" {
+			add(`"server" is required`)
+		} else if c.ServerByAlias(t.Server) == nil {
+			add(`"server" names unknown alias %q`, t.Server)
+		}
+		if len(t.LocalForwards) == 0 && len(t.RemoteForwards) == 0 && len(t.DynamicForwards) == 0 {
+			add("needs local_forwards, remote_forwards or dynamic_forwards")
+		}
+		for j, f := range t.LocalForwards {
+			if f.Local == "" || f.Remote == "" {
+				add(`local_forwards[%d] needs both "local" and "remote"`, j)
+			}
+		}
+		for j, f := range t.RemoteForwards {
+			if f.Local == "" || f.Remote == "" {
+				add(`remote_forwards[%d] needs both "local" and "remote"`, j)
+			}
+		}
+		for j, spec := range t.DynamicForwards {
+			if addr, err := ParseDynamicSpec(spec); err != nil {
+				add("dynamic_forwards[%d]: %v", j, err)
+			} else {
+				t.DynamicForwards[j] = addr
+			}
+		}
+	}
+	return
+}
+
+// importSSHConfig adds the hosts of import_ssh_config that are not in the
+// servers list. A missing or broken file only warns.
+func (c *Config) importSSHConfig() (problems []Problem) {
+	servers, err := ImportSSHConfig(c.ImportSSHConfig)
+	if err != nil {
+		return []Problem{{Index: -1, Message: fmt.Sprintf(`"import_ssh_config": %v`, err), Warning: true}}
+	}
+	for _, s := range servers {
+		if c.ServerByAlias(s.Alias) == nil {
+			c.Servers = append(c.Servers, s)
+		}
+	}
+	return
+}
+
+// fieldSynonyms maps names other tools use to the field meaning the same,
+// for suggestions that edit distance would not find.
+var fieldSynonyms = map[string]string{
+	"cert_path":        "certificate",
+	"cert_file":        "certificate",
+	"certificate_file": "certificate",
+	"identity_file":    "private_key",
+	"host_ca_key":      "host_ca_keys",
+	"ca_keys":          "host_ca_keys",
+}
+
+// unknownFields returns a message for every key of obj that is not a JSON
+// field of t, suggesting the closest known field.
+func unknownFields(obj map[string]json.RawMessage, t reflect.Type) (messages []string) {
+	known := map[string]bool{}
+	for _, field := range reflect.VisibleFields(t) {
+		name, _, _ := strings.Cut(field.Tag.Get("json"), ",")
+		if !field.Anonymous && name != "" && name != "-" {
+			known[name] = true
+		}
+	}
+	var names []string
+	for name := range obj {
+		if !known[name] {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		msg := fmt.Sprintf("unknown field %q", name)
+		best, bestDist := "", 3
+		if synonym := fieldSynonyms[name]; known[synonym] {
+			best, bestDist = synonym, 0
+		}
+		for k := range known {
+			if d := editDistance(name, k); d < bestDist || d == bestDist && k < best {
+				best, bestDist = k, d
+			}
+		}
+		if best != "" {
+			msg += fmt.Sprintf(" (did you mean %q?)", best)
+		}
+		messages = append(messages, msg)
+	}
+	return
+}
+
+func editDistance(a, b string) int {
+	prev := make([]int, len(b)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		cur := make([]int, len(b)+1)
+		cur[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			cur[j] = min(prev[j]+1, cur[j-1]+1, prev[j-1]+cost)
+		}
+		prev = cur
+	}
+	return prev[len(b)]
+}
+
+// jsonError adds the line and column to JSON syntax and type errors.
+func jsonError(data []byte, err error) error {
+	var offset int64
+	var syntaxErr *json.SyntaxError
+	var typeErr *json.UnmarshalTypeError
+	switch {
+	case errors.As(err, &syntaxErr):
+		offset = syntaxErr.Offset
+	case errors.As(err, &typeErr):
+		offset = typeErr.Offset
+	default:
+		return err
+	}
+	before := data[:min(int(offset), len(data))]
+	line := bytes.Count(before, []byte("\n")) + 1
+	col := len(before) - bytes.LastIndexByte(before, '\n')
+	return fmt.Errorf("line %d, column %d: %v", line, col, err)
+}
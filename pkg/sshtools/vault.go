@@ -0,0 +1,311 @@
+package sshtools
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// keySourceVault prefixes a key_source read from HashiCorp Vault, e.g.
+// "vault:secret/ssh/web1".
+const keySourceVault = "vault:"
+
+// vaultTimeout bounds each request to Vault.
+const vaultTimeout = 15 * time.Second
+
+// vaultRenewBefore is how long before it expires a certificate from Vault
+// is replaced when reconnecting.
+const vaultRenewBefore = time.Minute
+
+// vaultCredentials are the keys a key_source provided. They are kept on
+// the server, so reconnects only ask Vault again once a signed
+// certificate is about to expire.
+type vaultCredentials struct {
+	identities []identity
+	// expires is when the certificate among identities expires; zero when
+	// there is none or it does not.
+	expires time.Time
+}
+
+func (c *vaultCredentials) hasKeys() bool {
+	return c != nil && len(c.identities) > 0
+}
+
+// checkKeySource validates key_source.
+func checkKeySource(source string) (msgs []string) {
+	if source == "" {
+		return
+	}
+	if !strings.HasPrefix(source, keySourceVault) || strings.Trim(strings.TrimPrefix(source, keySourceVault), "/") == "" {
+		msgs = append(msgs, fmt.Sprintf(`"key_source" %q must be "vault:" followed by a Vault path, e.g. "vault:secret/ssh/web1"`, source))
+	}
+	return
+}
+
+// vaultClient talks to the Vault server set by VAULT_ADDR, with the token
+// of VAULT_TOKEN or the one vault login saved in ~/.vault-token.
+type vaultClient struct {
+	addr      string
+	token     string
+	namespace string
+	client    *http.Client
+}
+
+func newVaultClient() (v *vaultClient, err error) {
+	v = &vaultClient{addr: strings.TrimRight(os.Getenv("VAULT_ADDR"), "/"), token: os.Getenv("VAULT_TOKEN"),
+		namespace: os.Getenv("VAULT_NAMESPACE")}
+	if v.addr == "" {
+		return nil, &Error{Kind: ErrorConfig, Err: errors.New("VAULT_ADDR is not set")}
+	}
+	if v.token == "" {
+		if homeDir, errs := getHomeDir(); errs == nil {
+			data, _ := os.ReadFile(filepath.Join(homeDir, ".vault-token"))
+			v.token = strings.TrimSpace(string(data))
+		}
+	}
+	if v.token == "" {
+		return nil, &Error{Kind: ErrorConfig, Err: errors.New("VAULT_TOKEN is not set and there is no ~/.vault-token, log in with: vault login")}
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	// 与 vault 命令一样，VAULT_CACERT 指定签发 Vault 服务器证书的 CA
+	if caFile := os.Getenv("VAULT_CACERT"); caFile != "" {
+		pem, errs := os.ReadFile(caFile)
+		if errs != nil {
+			return nil, fmt.Errorf("failed to read VAULT_CACERT: %v", errs)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates in VAULT_CACERT %s", caFile)
+		}
+		transport.TLSClientConfig = &tls.Config{RootCAs: pool}
+	}
+	v.client = &http.Client{Transport: transport, Timeout: vaultTimeout}
+	return
+}
+
+// do sends a request to the Vault API and returns the data of the reply.
+func (v *vaultClient) do(method, path string, body any) (data map[string]any, err error) {
+	var reader io.Reader
+	if body != nil {
+		payload, errs := json.Marshal(body)
+		if errs != nil {
+			return nil, errs
+		}
+		reader = bytes.NewReader(payload)
+	}
+	req, err := http.NewRequest(method, v.addr+"/v1/"+path, reader)
+	if err != nil {
+		return
+	}
+	req.Header.Set("X-Vault-Token", v.token)
+	req.Header.Set("X-Vault-Request", "true")
+	if v.namespace != "" {
+		req.Header.Set("X-Vault-Namespace", v.namespace)
+	}
+	res, err := v.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to reach Vault: %v", err)
+	}
+	defer func() { _ = res.Body.Close() }()
+
+	var reply struct {
+		Data   map[string]any `json:"data"`
+		Errors []string       `json:"errors"`
+	}
+	errs := json.NewDecoder(io.LimitReader(res.Body, 1<<20)).Decode(&reply)
+	switch {
+	case res.StatusCode >= 300 && len(reply.Errors) > 0:
+		return nil, fmt.Errorf("Vault refused %s: %s", path, strings.Join(reply.Errors, "; "))
+	case res.StatusCode == http.StatusNotFound:
+		return nil, fmt.Errorf("Vault has nothing at %s", path)
+	case res.StatusCode >= 300:
+		return nil, fmt.Errorf("Vault answered %s for %s", res.Status, path)
+	case errs != nil:
+		return nil, fmt.Errorf("invalid reply from Vault for %s: %v", path, errs)
+	}
+	return reply.Data, nil
+}
+
+// resolve returns the API path of path and whether it is the sign
+// endpoint of an SSH secrets engine. Like the vault command, it asks Vault
+// which engine path is mounted on, so that KV version 2 paths can be
+// written without their data/ segment.
+func (v *vaultClient) resolve(path string) (apiPath string, sign bool) {
+	path = strings.Trim(path, "/")
+	mount, err := v.do(http.MethodGet, "sys/internal/ui/mounts/"+path, nil)
+	if err != nil {
+		// 令牌无权查询挂载点时按路径猜测
+		return path, strings.Contains(path, "/sign/")
+	}
+	prefix, _ := mount["path"].(string)
+	engine, _ := mount["type"].(string)
+	options, _ := mount["options"].(map[string]any)
+	rest, _ := strings.CutPrefix(path, prefix)
+	switch {
+	case engine == "ssh":
+		return path, true
+	case engine == "kv" && options["version"] == "2" && !strings.HasPrefix(rest, "data/"):
+		return prefix + "data/" + rest, false
+	}
+	return path, false
+}
+
+// vaultSecrets fetches what the key_source of server provides: a private
+// key with its certificate and a password from a KV secret, or a
+// certificate from the SSH secrets engine. Keys are offered by
+// keyIdentities; a password is used when none is configured.
+func (d *Dialer) vaultSecrets(server *Server) (err error) {
+	if server.KeySource == "" {
+		return
+	}
+	if c := server.vault; c != nil && (c.expires.IsZero() || time.Now().Add(vaultRenewBefore).Before(c.expires)) {
+		return
+	}
+	v, err := newVaultClient()
+	if err != nil {
+		return fmt.Errorf("key_source of %s: %w", server.Alias, err)
+	}
+	path, sign := v.resolve(strings.TrimPrefix(server.KeySource, keySourceVault))
+	var creds *vaultCredentials
+	if sign {
+		d.Logf(1, "signing a certificate with Vault at %s", path)
+		creds, err = d.vaultSign(server, v, path)
+	} else {
+		d.Logf(1, "reading credentials from Vault at %s", path)
+		creds, err = d.vaultKV(server, v, path)
+	}
+	if err != nil {
+		return fmt.Errorf("key_source of %s: %w", server.Alias, err)
+	}
+	server.vault = creds
+	return
+}
+
+// vaultKV reads a KV secret with the fields private_key, certificate,
+// passphrase (of an encrypted private_key) and password.
+func (d *Dialer) vaultKV(server *Server, v *vaultClient, path string) (creds *vaultCredentials, err error) {
+	data, err := v.do(http.MethodGet, path, nil)
+	if err != nil {
+		return
+	}
+	// KV 第 2 版的值在 data.data 中
+	if inner, ok := data["data"].(map[string]any); ok {
+		if _, ok = data["metadata"]; ok {
+			data = inner
+		}
+	}
+	field := func(name string) string {
+		value, _ := data[name].(string)
+		return value
+	}
+	creds = &vaultCredentials{}
+	if password := field("password"); password != "" && server.Password == "" {
+		server.Password = password
+	}
+	key := field("private_key")
+	if key == "" {
+		if field("certificate") != "" {
+			return nil, fmt.Errorf("the secret at %s has a certificate but no private_key", path)
+		}
+		if server.Password == "" {
+			return nil, fmt.Errorf("the secret at %s has neither private_key nor password", path)
+		}
+		return
+	}
+	source := "vault:" + path
+	signer, err := ssh.ParsePrivateKey([]byte(key))
+	var missing *ssh.PassphraseMissingError
+	if errors.As(err, &missing) {
+		if field("passphrase") == "" {
+			return nil, fmt.Errorf("the private_key at %s is encrypted and the secret has no passphrase", path)
+		}
+		signer, err = ssh.ParsePrivateKeyWithPassphrase([]byte(key), []byte(field("passphrase")))
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse the private_key at %s: %v", path, err)
+	}
+	// 有用户证书时先提供证书，再提供密钥本身
+	if certificate := field("certificate"); certificate != "" {
+		cert, errs := parseCertificate([]byte(certificate), source, time.Now())
+		if errs != nil {
+			return nil, errs
+		}
+		certSigner, errs := pairCertificate(signer, cert, source)
+		if errs != nil {
+			return nil, errs
+		}
+		creds.identities = append(creds.identities, identity{certSigner, source + " (certificate)"})
+		creds.expires = certExpiry(cert)
+	}
+	creds.identities = append(creds.identities, identity{signer, source})
+	return
+}
+
+// vaultSign has the SSH secrets engine sign a certificate for the server's
+// user: for its private_key when use_key is set, otherwise for a key made
+// for this process that never leaves memory.
+func (d *Dialer) vaultSign(server *Server, v *vaultClient, path string) (creds *vaultCredentials, err error) {
+	var signer ssh.Signer
+	var keys []identity
+	if server.UseKey && server.PrivateKey != "" {
+		if keys, err = d.keyIdentities(server, &authTrace{}); err != nil {
+			return
+		}
+		// 只签发私钥本身，不用其已有的证书
+		signer = keys[len(keys)-1].signer
+		keys = keys[len(keys)-1:]
+	} else {
+		_, priv, errs := ed25519.GenerateKey(rand.Reader)
+		if errs != nil {
+			return nil, errs
+		}
+		if signer, err = ssh.NewSignerFromKey(priv); err != nil {
+			return
+		}
+	}
+	data, err := v.do(http.MethodPost, path, map[string]string{
+		"public_key":       string(ssh.MarshalAuthorizedKey(signer.PublicKey())),
+		"valid_principals": server.User,
+		"cert_type":        "user",
+	})
+	if err != nil {
+		return
+	}
+	signed, _ := data["signed_key"].(string)
+	if signed == "" {
+		return nil, fmt.Errorf("Vault returned no signed_key from %s", path)
+	}
+	source := "vault:" + path
+	cert, err := parseCertificate([]byte(signed), source, time.Now())
+	if err != nil {
+		return
+	}
+	certSigner, err := pairCertificate(signer, cert, source)
+	if err != nil {
+		return
+	}
+	d.Logf(1, "Vault signed a certificate valid until %s", certTime(cert.ValidBefore))
+	creds = &vaultCredentials{identities: append([]identity{{certSigner, source}}, keys...), expires: certExpiry(cert)}
+	return
+}
+
+// certExpiry returns when cert expires, zero when it does not.
+func certExpiry(cert *ssh.Certificate) time.Time {
+	if cert.ValidBefore == ssh.CertTimeInfinity {
+		return time.Time{}
+	}
+	return time.Unix(int64(cert.ValidBefore), 0)
+}
@@ -0,0 +1,315 @@
+package sshtools
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/pkg/sftp"
+)
+
+// verifyMarker starts the output of verifyScript, so that output from a
+// server without a POSIX shell is recognised and SFTP used instead.
+const verifyMarker = "sshtools-verify"
+
+// verifyScript prints the kind of the path in $1, then the SHA-256 of each
+// regular file under it, in the format of sha256sum.
+const verifyScript = `p=$1
+sum=sha256sum
+command -v sha256sum >/dev/null 2>&1 || sum="shasum -a 256"
+if [ -d "$p" ]; then
+	echo ` + verifyMarker + ` dir
+	cd -- "$p" && find . -type f -exec $sum {} +
+elif [ -f "$p" ]; then
+	echo ` + verifyMarker + ` file
+	$sum < "$p"
+else
+	echo ` + verifyMarker + ` missing
+fi`
+
+// VerifyOptions controls Verify.
+type VerifyOptions struct {
+	// Exclude skips paths like SyncOptions.Exclude.
+	Exclude []string
+	// SFTP reads the remote files over SFTP instead of running sha256sum
+	// on the server.
+	SFTP bool
+	// Log reports skipped files and the method used.
+	Log func(format string, args ...any)
+}
+
+// VerifyResult lists how the files of the two sides compare, by their
+// slash-separated path relative to the top of the tree.
+type VerifyResult struct {
+	Matched    int
+	Mismatched []string
+	// OnlyLocal are files missing on the server, OnlyRemote files missing
+	// locally.
+	OnlyLocal  []string
+	OnlyRemote []string
+	// Bytes is the size of the local files compared.
+	Bytes int64
+}
+
+// OK reports whether both sides have the same files with the same content.
+func (r *VerifyResult) OK() bool {
+	return len(r.Mismatched) == 0 && len(r.OnlyLocal) == 0 && len(r.OnlyRemote) == 0
+}
+
+// Verify compares the SHA-256 of the remote file or directory tree remote
+// with its local copy local. The server hashes its files with sha256sum
+// (or shasum) in one streamed command; servers without a POSIX shell, or
+// all of them with SFTP set, have their files read over SFTP instead. The
+// local side is hashed at the same time.
+func (c *Client) Verify(remote, local string, opts VerifyOptions) (result VerifyResult, err error) {
+	syncOpts := &SyncOptions{Exclude: opts.Exclude, Log: opts.Log}
+	info, err := os.Stat(local)
+	if err != nil {
+		return
+	}
+
+	// 本地与远程同时计算
+	type sums struct {
+		files map[string]string
+		bytes int64
+		err   error
+	}
+	done := make(chan sums, 1)
+	go func() {
+		var s sums
+		s.files, s.bytes, s.err = localSums(local, info.IsDir(), syncOpts)
+		done <- s
+	}()
+	remoteFiles, remoteDir, err := c.remoteSums(remote, opts.SFTP, syncOpts)
+	localSide := <-done
+	if err != nil {
+		return
+	}
+	if localSide.err != nil {
+		return result, localSide.err
+	}
+	if remoteDir != info.IsDir() {
+		return result, fmt.Errorf("%s is a %s on the server but %s is a %s locally", remote, kindName(remoteDir), local, kind(info))
+	}
+
+	result.Bytes = localSide.bytes
+	for rel, sum := range localSide.files {
+		switch remoteSum, ok := remoteFiles[rel]; {
+		case !ok:
+			result.OnlyLocal = append(result.OnlyLocal, rel)
+		case remoteSum != sum:
+			result.Mismatched = append(result.Mismatched, rel)
+		default:
+			result.Matched++
+		}
+	}
+	for rel := range remoteFiles {
+		if _, ok := localSide.files[rel]; !ok {
+			result.OnlyRemote = append(result.OnlyRemote, rel)
+		}
+	}
+	sort.Strings(result.Mismatched)
+	sort.Strings(result.OnlyLocal)
+	sort.Strings(result.OnlyRemote)
+	return
+}
+
+// kindName is kind for a directory flag.
+func kindName(dir bool) string {
+	if dir {
+		return "directory"
+	}
+	return "file"
+}
+
+// localSums returns the hex SHA-256 of the regular files under local, or
+// of local itself under "." when it is a file, and their total size.
+func localSums(local string, dir bool, opts *SyncOptions) (files map[string]string, total int64, err error) {
+	files = map[string]string{}
+	if !dir {
+		sum, errs := fileSum(os.Open(local))
+		if errs != nil {
+			return nil, 0, errs
+		}
+		info, errs := os.Stat(local)
+		if errs != nil {
+			return nil, 0, errs
+		}
+		files["."] = hex.EncodeToString(sum)
+		return files, info.Size(), nil
+	}
+	tree, err := localTree(local, opts)
+	if err != nil {
+		return
+	}
+	for rel, info := range tree {
+		if info.IsDir() {
+			continue
+		}
+		sum, errs := fileSum(os.Open(filepath.Join(local, filepath.FromSlash(rel))))
+		if errs != nil {
+			return nil, 0, fmt.Errorf("failed to read %s: %v", rel, errs)
+		}
+		files[rel] = hex.EncodeToString(sum)
+		total += info.Size()
+	}
+	return
+}
+
+// remoteSums returns the hex SHA-256 of the regular files under remote, or
+// of remote itself under "." when it is a file, and whether it is a
+// directory.
+func (c *Client) remoteSums(remote string, useSFTP bool, opts *SyncOptions) (files map[string]string, dir bool, err error) {
+	if !useSFTP {
+		files, dir, err = c.remoteSumsShell(remote, opts)
+		if !errors.Is(err, errNoShell) {
+			return
+		}
+		opts.logf("no POSIX shell on the server, reading the files over SFTP")
+	}
+	client, err := c.SFTP()
+	if err != nil {
+		return
+	}
+	defer func() { _ = client.Close() }()
+	return remoteSumsSFTP(client, remote, opts)
+}
+
+// errNoShell is returned by remoteSumsShell when the server did not run
+// verifyScript.
+var errNoShell = errors.New("no POSIX shell")
+
+// remoteSumsShell runs verifyScript and reads its output as it comes.
+func (c *Client) remoteSumsShell(remote string, opts *SyncOptions) (files map[string]string, dir bool, err error) {
+	session, err := c.NewSession()
+	if err != nil {
+		return
+	}
+	defer func() { _ = session.Close() }()
+	var stderr bytes.Buffer
+	session.Stderr = &stderr
+	stdout, err := session.StdoutPipe()
+	if err != nil {
+		return
+	}
+	if err = session.Start("sh -c " + ShellQuote(verifyScript) + " sh " + ShellQuote(remote)); err != nil {
+		return
+	}
+
+	files = map[string]string{}
+	scanner := bufio.NewScanner(stdout)
+	scanner.Buffer(make([]byte, 64*1024), 1<<20)
+	header := ""
+	if scanner.Scan() {
+		header = scanner.Text()
+	}
+	kind, ok := strings.CutPrefix(header, verifyMarker+" ")
+	if !ok {
+		_, _ = io.Copy(io.Discard, stdout)
+		_ = session.Wait()
+		return nil, false, errNoShell
+	}
+	for scanner.Scan() {
+		sum, name, ok := parseSumLine(scanner.Text())
+		if !ok {
+			continue
+		}
+		rel := "."
+		if kind == "dir" {
+			rel = strings.TrimPrefix(name, "./")
+			if excludedPath(opts, rel) {
+				continue
+			}
+		}
+		files[rel] = sum
+	}
+	if err = scanner.Err(); err != nil {
+		return nil, false, fmt.Errorf("failed to read the checksums of %s: %v", remote, err)
+	}
+	if err = session.Wait(); err != nil {
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			err = fmt.Errorf("%v: %s", err, msg)
+		}
+		return nil, false, fmt.Errorf("failed to compute the checksums of %s on the server: %v", remote, err)
+	}
+	if kind == "missing" {
+		return nil, false, fmt.Errorf("%s does not exist on the server", remote)
+	}
+	return files, kind == "dir", nil
+}
+
+// parseSumLine splits a line of sha256sum output. Names with a newline or
+// backslash are escaped, and the line then starts with a backslash.
+func parseSumLine(line string) (sum, name string, ok bool) {
+	escaped := strings.HasPrefix(line, `\`)
+	line = strings.TrimPrefix(line, `\`)
+	sum, name, ok = strings.Cut(line, " ")
+	if !ok || len(sum) != 64 {
+		return "", "", false
+	}
+	// 文本模式为两个空格，二进制模式为空格加 *
+	name = name[1:]
+	if escaped {
+		name = strings.NewReplacer(`\\`, `\`, `\n`, "\n", `\r`, "\r").Replace(name)
+	}
+	return sum, name, true
+}
+
+// excludedPath reports whether the file at rel, or a directory it is in,
+// is excluded.
+func excludedPath(opts *SyncOptions, rel string) bool {
+	if opts.excluded(rel, false) {
+		return true
+	}
+	for dir := path.Dir(rel); dir != "."; dir = path.Dir(dir) {
+		if opts.excluded(dir, true) {
+			return true
+		}
+	}
+	return false
+}
+
+// remoteSumsSFTP is remoteSums reading every file over SFTP.
+func remoteSumsSFTP(client *sftp.Client, remote string, opts *SyncOptions) (files map[string]string, dir bool, err error) {
+	remote = path.Clean(remote)
+	info, err := client.Stat(remote)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, false, fmt.Errorf("%s does not exist on the server", remote)
+	}
+	if err != nil {
+		return nil, false, fmt.Errorf("%s: %v", remote, err)
+	}
+	files = map[string]string{}
+	if !info.IsDir() {
+		sum, errs := fileSum(client.Open(remote))
+		if errs != nil {
+			return nil, false, fmt.Errorf("failed to read %s: %v", remote, errs)
+		}
+		files["."] = hex.EncodeToString(sum)
+		return files, false, nil
+	}
+	tree, err := remoteTree(client, remote, opts)
+	if err != nil {
+		return
+	}
+	for rel, entry := range tree {
+		if !entry.Mode().IsRegular() {
+			continue
+		}
+		name := path.Join(remote, rel)
+		sum, errs := fileSum(client.Open(name))
+		if errs != nil {
+			return nil, false, fmt.Errorf("failed to read %s: %v", name, errs)
+		}
+		files[rel] = hex.EncodeToString(sum)
+	}
+	return files, true, nil
+}
@@ -0,0 +1,182 @@
+package sshtools
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// tunModePointToPoint asks the tun@openssh.com channel for layer 3
+// packets rather than ethernet frames.
+const tunModePointToPoint = 1
+
+// Address families OpenSSH puts in front of each packet, the OpenBSD
+// values whatever the platform.
+const (
+	tunAFInet  = 2
+	tunAFInet6 = 24
+)
+
+// tunMaxPacket bounds a packet read from the channel.
+const tunMaxPacket = 64 << 10
+
+// VPN configures sshtools vpn for a server: a point-to-point link between
+// a local tun device and one on the server, over a tun@openssh.com
+// channel, which needs "PermitTunnel yes" in the server's sshd_config and
+// root on both ends.
+type VPN struct {
+	// LocalAddress and RemoteAddress are the two ends of the link, e.g.
+	// 10.99.0.1 and 10.99.0.2.
+	LocalAddress  string `json:"local_address"`
+	RemoteAddress string `json:"remote_address"`
+	// Routes are the subnets sent through the link, e.g. 10.0.0.0/16.
+	Routes []string `json:"routes,omitempty"`
+	// RemoteUnit is the number of the server's tun device, tun0 by default.
+	RemoteUnit int `json:"remote_unit,omitempty"`
+	// SkipRemoteSetup leaves configuring the server's device to the user;
+	// by default it is given RemoteAddress and brought up with ip.
+	SkipRemoteSetup bool `json:"skip_remote_setup,omitempty"`
+	// MTU of both devices, 1400 by default so that packets with the SSH
+	// overhead still fit in one of the underlying connection.
+	MTU int `json:"mtu,omitempty"`
+}
+
+// DefaultVPNMTU is the MTU of the tun devices when none is configured.
+const DefaultVPNMTU = 1400
+
+// Check returns what is wrong with the settings, if anything.
+func (v *VPN) Check() error {
+	local, remote := net.ParseIP(v.LocalAddress), net.ParseIP(v.RemoteAddress)
+	switch {
+	case local == nil:
+		return fmt.Errorf(`vpn "local_address" %q is not an IP address`, v.LocalAddress)
+	case remote == nil:
+		return fmt.Errorf(`vpn "remote_address" %q is not an IP address`, v.RemoteAddress)
+	case (local.To4() == nil) != (remote.To4() == nil):
+		return errors.New(`vpn "local_address" and "remote_address" must both be IPv4 or both IPv6`)
+	case v.RemoteUnit < 0:
+		return fmt.Errorf(`vpn "remote_unit" %d cannot be negative`, v.RemoteUnit)
+	case v.MTU != 0 && (v.MTU < 576 || v.MTU > 65535):
+		return fmt.Errorf(`vpn "mtu" %d must be between 576 and 65535`, v.MTU)
+	}
+	for _, route := range v.Routes {
+		if _, _, err := net.ParseCIDR(route); err != nil {
+			return fmt.Errorf(`vpn route %q is not a subnet such as 10.0.0.0/16`, route)
+		}
+	}
+	return nil
+}
+
+// LinkMTU returns the MTU of the devices.
+func (v *VPN) LinkMTU() int {
+	if v.MTU > 0 {
+		return v.MTU
+	}
+	return DefaultVPNMTU
+}
+
+// RemoteDevice returns the name of the server's tun device.
+func (v *VPN) RemoteDevice() string {
+	return fmt.Sprintf("tun%d", v.RemoteUnit)
+}
+
+// RemoteSetupCommand returns the command that configures the server's end
+// of the link once the channel created the device.
+func (v *VPN) RemoteSetupCommand() string {
+	dev := ShellQuote(v.RemoteDevice())
+	return fmt.Sprintf("ip addr add %s peer %s dev %s && ip link set %s mtu %d up",
+		ShellQuote(v.RemoteAddress), ShellQuote(v.LocalAddress), dev, dev, v.LinkMTU())
+}
+
+// OpenTun opens a tun@openssh.com channel in point-to-point mode to the
+// server's tun device with unit number unit.
+func (c *Client) OpenTun(unit int) (ch ssh.Channel, err error) {
+	payload := ssh.Marshal(struct {
+		Mode uint32
+		Unit uint32
+	}{tunModePointToPoint, uint32(unit)})
+	ch, reqs, err := c.OpenChannel("tun@openssh.com", payload)
+	if err != nil {
+		var open *ssh.OpenChannelError
+		if errors.As(err, &open) && open.Reason == ssh.Prohibited {
+			return nil, fmt.Errorf("%s refused the tunnel: set \"PermitTunnel yes\" in its sshd_config and log in as root (%v)", c.Server.Alias, err)
+		}
+		return nil, fmt.Errorf("failed to open a tunnel to %s: %v", c.Server.Alias, err)
+	}
+	go ssh.DiscardRequests(reqs)
+	return
+}
+
+// SetupRemoteTun configures the server's end of the link with ip.
+func (c *Client) SetupRemoteTun(v *VPN) error {
+	res := c.Capture(v.RemoteSetupCommand(), DefaultMaxCapture, false)
+	if res.Error != "" || res.ExitCode != 0 {
+		msg := strings.TrimSpace(res.Stderr)
+		if msg == "" {
+			msg = res.Error
+		}
+		return fmt.Errorf("failed to set up %s on %s: %s", v.RemoteDevice(), c.Server.Alias, msg)
+	}
+	return nil
+}
+
+// PumpTun copies packets between the local tun device dev, which reads
+// and writes one packet at a time without a header, and ch until either
+// side fails or closes. On the channel every packet is an SSH string
+// holding the address family and the packet, as OpenSSH sends them.
+func PumpTun(dev io.ReadWriter, ch ssh.Channel) error {
+	errc := make(chan error, 2)
+	go func() {
+		buf := make([]byte, tunMaxPacket)
+		for {
+			n, err := dev.Read(buf[8:])
+			if err != nil {
+				errc <- fmt.Errorf("failed to read from the tun device: %v", err)
+				return
+			}
+			if n == 0 {
+				continue
+			}
+			af := uint32(tunAFInet)
+			if buf[8]>>4 == 6 {
+				af = tunAFInet6
+			}
+			binary.BigEndian.PutUint32(buf, uint32(n+4))
+			binary.BigEndian.PutUint32(buf[4:], af)
+			if _, err = ch.Write(buf[:n+8]); err != nil {
+				errc <- err
+				return
+			}
+		}
+	}()
+	go func() {
+		var header [8]byte
+		buf := make([]byte, tunMaxPacket)
+		for {
+			if _, err := io.ReadFull(ch, header[:]); err != nil {
+				if err == io.EOF {
+					err = errors.New("the server closed the tunnel")
+				}
+				errc <- err
+				return
+			}
+			size := int(binary.BigEndian.Uint32(header[:])) - 4
+			if size < 0 || size > len(buf) {
+				errc <- fmt.Errorf("invalid packet size %d from the server", size)
+				return
+			}
+			if _, err := io.ReadFull(ch, buf[:size]); err != nil {
+				errc <- err
+				return
+			}
+			// 写入失败的单个包（如地址族不支持）直接丢弃
+			_, _ = dev.Write(buf[:size])
+		}
+	}()
+	return <-errc
+}
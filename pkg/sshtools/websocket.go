@@ -0,0 +1,307 @@
+package sshtools
+
+import (
+	"bufio"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/tls"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"sync"
+	"time"
+)
+
+// webSocketGUID is appended to the handshake key to compute
+// Sec-WebSocket-Accept (RFC 6455 section 4.2.2).
+const webSocketGUID = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+
+// WebSocket frame opcodes.
+const (
+	wsContinuation = 0x0
+	wsText         = 0x1
+	wsBinary       = 0x2
+	wsClose        = 0x8
+	wsPing         = 0x9
+	wsPong         = 0xa
+)
+
+// webSocketDefaultPorts are used when the gateway URL has no port.
+var webSocketDefaultPorts = map[string]string{"ws": "80", "wss": "443"}
+
+// webSocketURL expands the %h, %p, %r and %n tokens of the server's
+// "websocket" value and parses the result.
+func (s *Server) webSocketURL() (gateway *url.URL, err error) {
+	value := expandProxyCommand(s.WebSocket, s)
+	gateway, err = url.Parse(value)
+	if err != nil || gateway.Host == "" {
+		return nil, fmt.Errorf(`"websocket" %q is not a URL such as wss://gateway.example.com/ssh`, s.WebSocket)
+	}
+	if _, ok := webSocketDefaultPorts[gateway.Scheme]; !ok {
+		return nil, fmt.Errorf(`"websocket" %q must use wss or ws`, s.WebSocket)
+	}
+	return
+}
+
+// RedactedWebSocket returns the WebSocket gateway of s without a password,
+// for display.
+func (s *Server) RedactedWebSocket() string {
+	if gateway, err := url.Parse(s.WebSocket); err == nil {
+		return gateway.Redacted()
+	}
+	return s.WebSocket
+}
+
+// webSocketHeader returns the extra handshake headers of s, with their
+// ${env:NAME} references resolved by lookup.
+func (s *Server) webSocketHeader(lookup func(string) (string, bool)) (header http.Header, err error) {
+	header = http.Header{}
+	for name, value := range s.WebSocketHeaders {
+		if value, err = expandReferences(value, lookup); err != nil {
+			return nil, fmt.Errorf(`"websocket_headers" %s: %v`, name, err)
+		}
+		header.Set(name, value)
+	}
+	if s.WebSocketToken != "" {
+		token, errs := expandReferences(s.WebSocketToken, lookup)
+		if errs != nil {
+			return nil, fmt.Errorf(`"websocket_token": %v`, errs)
+		}
+		header.Set("Authorization", "Bearer "+token)
+	}
+	return
+}
+
+// dialWebSocket connects to the server's WebSocket gateway, through its
+// proxy if it has one, and returns the SSH byte stream carried in binary
+// messages. timeout bounds the connection and the handshakes.
+func dialWebSocket(server *Server, timeout time.Duration) (conn net.Conn, err error) {
+	gateway, err := server.webSocketURL()
+	if err != nil {
+		return
+	}
+	header, err := server.webSocketHeader(os.LookupEnv)
+	if err != nil {
+		return
+	}
+	addr := gateway.Host
+	if gateway.Port() == "" {
+		addr = net.JoinHostPort(gateway.Hostname(), webSocketDefaultPorts[gateway.Scheme])
+	}
+	if server.UsesProxy() {
+		conn, err = dialProxy(server.Proxy, addr, timeout)
+	} else {
+		conn, err = net.DialTimeout("tcp", addr, timeout)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to websocket gateway %s: %v", addr, err)
+	}
+	_ = conn.SetDeadline(time.Now().Add(timeout))
+	if gateway.Scheme == "wss" {
+		tlsConn := tls.Client(conn, &tls.Config{ServerName: gateway.Hostname()})
+		if err = tlsConn.Handshake(); err != nil {
+			_ = conn.Close()
+			return nil, fmt.Errorf("websocket gateway %s: TLS handshake failed: %v", addr, err)
+		}
+		conn = tlsConn
+	}
+	ws, err := webSocketHandshake(conn, gateway, header)
+	if err != nil {
+		_ = conn.Close()
+		return nil, fmt.Errorf("websocket gateway %s: %v", gateway.Redacted(), err)
+	}
+	_ = conn.SetDeadline(time.Time{})
+	return ws, nil
+}
+
+// webSocketHandshake upgrades conn to a WebSocket connection to gateway.
+func webSocketHandshake(conn net.Conn, gateway *url.URL, header http.Header) (ws *webSocketConn, err error) {
+	nonce := make([]byte, 16)
+	if _, err = rand.Read(nonce); err != nil {
+		return
+	}
+	key := base64.StdEncoding.EncodeToString(nonce)
+	req := &http.Request{
+		Method: http.MethodGet,
+		URL:    &url.URL{Path: gateway.Path, RawPath: gateway.RawPath, RawQuery: gateway.RawQuery},
+		Host:   gateway.Host,
+		Header: header,
+	}
+	req.Header.Set("Upgrade", "websocket")
+	req.Header.Set("Connection", "Upgrade")
+	req.Header.Set("Sec-WebSocket-Key", key)
+	req.Header.Set("Sec-WebSocket-Version", "13")
+	if gateway.User != nil && req.Header.Get("Authorization") == "" {
+		password, _ := gateway.User.Password()
+		req.SetBasicAuth(gateway.User.Username(), password)
+	}
+	if err = req.Write(conn); err != nil {
+		return
+	}
+	// 服务器的 SSH 版本行可能紧跟在响应之后，已读入缓冲的部分要交给握手
+	r := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(r, req)
+	if err != nil {
+		return nil, fmt.Errorf("invalid handshake response: %v", err)
+	}
+	_ = resp.Body.Close()
+	if resp.StatusCode != http.StatusSwitchingProtocols {
+		return nil, fmt.Errorf("upgrade refused: %s", resp.Status)
+	}
+	sum := sha1.Sum([]byte(key + webSocketGUID))
+	if resp.Header.Get("Sec-WebSocket-Accept") != base64.StdEncoding.EncodeToString(sum[:]) {
+		return nil, errors.New("invalid Sec-WebSocket-Accept in the handshake response")
+	}
+	return &webSocketConn{Conn: conn, r: r}, nil
+}
+
+// webSocketConn carries a byte stream in the binary messages of a
+// WebSocket client connection. Message boundaries are ignored; pings are
+// answered.
+type webSocketConn struct {
+	net.Conn
+	r *bufio.Reader
+	// remaining is how much of the current data frame is still unread, and
+	// mask its masking key, if any, at offset maskPos.
+	remaining uint64
+	mask      []byte
+	maskPos   int
+
+	writeMu sync.Mutex
+	closed  bool
+}
+
+func (c *webSocketConn) Read(b []byte) (n int, err error) {
+	for c.remaining == 0 {
+		if err = c.nextFrame(); err != nil {
+			return
+		}
+	}
+	if uint64(len(b)) > c.remaining {
+		b = b[:c.remaining]
+	}
+	n, err = c.r.Read(b)
+	for i := range n {
+		if c.mask != nil {
+			b[i] ^= c.mask[c.maskPos%4]
+			c.maskPos++
+		}
+	}
+	c.remaining -= uint64(n)
+	return
+}
+
+// nextFrame reads frame headers until a data frame with a payload,
+// handling the control frames in between.
+func (c *webSocketConn) nextFrame() (err error) {
+	head := make([]byte, 2)
+	if _, err = io.ReadFull(c.r, head); err != nil {
+		return
+	}
+	opcode := head[0] & 0x0f
+	length := uint64(head[1] & 0x7f)
+	switch length {
+	case 126:
+		ext := make([]byte, 2)
+		if _, err = io.ReadFull(c.r, ext); err != nil {
+			return
+		}
+		length = uint64(binary.BigEndian.Uint16(ext))
+	case 127:
+		ext := make([]byte, 8)
+		if _, err = io.ReadFull(c.r, ext); err != nil {
+			return
+		}
+		length = binary.BigEndian.Uint64(ext)
+	}
+	var mask []byte
+	if head[1]&0x80 != 0 {
+		mask = make([]byte, 4)
+		if _, err = io.ReadFull(c.r, mask); err != nil {
+			return
+		}
+	}
+
+	switch opcode {
+	case wsContinuation, wsText, wsBinary:
+		c.remaining, c.mask, c.maskPos = length, mask, 0
+		return nil
+	}
+	// 控制帧的内容最多 125 字节
+	if length > 125 {
+		return fmt.Errorf("websocket control frame of %d bytes", length)
+	}
+	payload := make([]byte, length)
+	if _, err = io.ReadFull(c.r, payload); err != nil {
+		return
+	}
+	for i := range payload {
+		if mask != nil {
+			payload[i] ^= mask[i%4]
+		}
+	}
+	switch opcode {
+	case wsPing:
+		return c.writeFrame(wsPong, payload)
+	case wsPong:
+		return nil
+	case wsClose:
+		_ = c.writeFrame(wsClose, payload)
+		return io.EOF
+	}
+	return fmt.Errorf("unknown websocket opcode %d", opcode)
+}
+
+func (c *webSocketConn) Write(b []byte) (int, error) {
+	if err := c.writeFrame(wsBinary, b); err != nil {
+		return 0, err
+	}
+	return len(b), nil
+}
+
+// writeFrame sends payload in one frame, masked as clients must.
+func (c *webSocketConn) writeFrame(opcode byte, payload []byte) (err error) {
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+	if c.closed {
+		return net.ErrClosed
+	}
+	frame := make([]byte, 0, 14+len(payload))
+	frame = append(frame, 0x80|opcode)
+	switch n := len(payload); {
+	case n < 126:
+		frame = append(frame, 0x80|byte(n))
+	case n <= 0xffff:
+		frame = binary.BigEndian.AppendUint16(append(frame, 0x80|126), uint16(n))
+	default:
+		frame = binary.BigEndian.AppendUint64(append(frame, 0x80|127), uint64(n))
+	}
+	mask := make([]byte, 4)
+	if _, err = rand.Read(mask); err != nil {
+		return
+	}
+	frame = append(frame, mask...)
+	for i, b := range payload {
+		frame = append(frame, b^mask[i%4])
+	}
+	_, err = c.Conn.Write(frame)
+	if opcode == wsClose {
+		c.closed = true
+	}
+	return
+}
+
+// Close sends a close frame, without waiting for the reply, and closes the
+// connection.
+func (c *webSocketConn) Close() error {
+	_ = c.Conn.SetWriteDeadline(time.Now().Add(time.Second))
+	// 1000：正常关闭
+	_ = c.writeFrame(wsClose, []byte{0x03, 0xe8})
+	return c.Conn.Close()
+}
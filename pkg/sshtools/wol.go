@@ -0,0 +1,97 @@
+package sshtools
+
+import (
+	"bytes"
+	"encoding/hex"
+	"fmt"
+	"net"
+	"strconv"
+)
+
+// DefaultWakeBroadcast is where magic packets go when a server sets no
+// wake_broadcast: the limited broadcast address and the discard port.
+const DefaultWakeBroadcast = "255.255.255.255:9"
+
+// MagicPacket returns the Wake-on-LAN packet for mac: six 0xff bytes and
+// the address sixteen times.
+func MagicPacket(mac string) ([]byte, error) {
+	hw, err := net.ParseMAC(mac)
+	if err != nil {
+		return nil, err
+	}
+	if len(hw) != 6 {
+		return nil, fmt.Errorf("%q is not a 48-bit MAC address", mac)
+	}
+	return append(bytes.Repeat([]byte{0xff}, 6), bytes.Repeat(hw, 16)...), nil
+}
+
+// WakeBroadcast returns where the magic packet of server goes.
+func (s *Server) WakeBroadcast() string {
+	if s.WakeBroadcastAddr != "" {
+		return s.WakeBroadcastAddr
+	}
+	return DefaultWakeBroadcast
+}
+
+// SendWakeOnLAN broadcasts the magic packet of server from this machine.
+func SendWakeOnLAN(server *Server) (err error) {
+	packet, err := MagicPacket(server.MacAddress)
+	if err != nil {
+		return
+	}
+	addr, err := net.ResolveUDPAddr("udp4", server.WakeBroadcast())
+	if err != nil {
+		return fmt.Errorf("failed to resolve wake_broadcast: %v", err)
+	}
+	// Go 的 UDP 套接字默认已设置 SO_BROADCAST，可以直接发往广播地址
+	conn, err := net.DialUDP("udp4", nil, addr)
+	if err != nil {
+		return fmt.Errorf("failed to send the magic packet: %v", err)
+	}
+	defer func() { _ = conn.Close() }()
+	if _, err = conn.Write(packet); err != nil {
+		return fmt.Errorf("failed to send the magic packet: %v", err)
+	}
+	return
+}
+
+// WakeCommand returns the shell command that makes a wake_relay send the
+// magic packet of server, with wakeonlan or else python3.
+func WakeCommand(server *Server) (command string, err error) {
+	packet, err := MagicPacket(server.MacAddress)
+	if err != nil {
+		return
+	}
+	host, port, err := net.SplitHostPort(server.WakeBroadcast())
+	if err != nil {
+		return
+	}
+	if _, err = strconv.Atoi(port); err != nil {
+		return "", fmt.Errorf("invalid wake_broadcast port %q", port)
+	}
+	python := fmt.Sprintf(`import socket; s = socket.socket(socket.AF_INET, socket.SOCK_DGRAM); s.setsockopt(socket.SOL_SOCKET, socket.SO_BROADCAST, 1); s.sendto(bytes.fromhex("%s"), ("%s", %s))`,
+		hex.EncodeToString(packet), host, port)
+	command = fmt.Sprintf("if command -v wakeonlan >/dev/null 2>&1; then exec wakeonlan -i %s -p %s %s; else exec python3 -c %s; fi",
+		ShellQuote(host), port, ShellQuote(server.MacAddress), ShellQuote(python))
+	return
+}
+
+// checkWake validates mac_address and wake_broadcast.
+func checkWake(s *Server) (msgs []string) {
+	if s.MacAddress != "" {
+		if _, err := MagicPacket(s.MacAddress); err != nil {
+			msgs = append(msgs, fmt.Sprintf(`"mac_address": %v`, err))
+		}
+	}
+	if s.WakeBroadcastAddr != "" {
+		if host, port, err := net.SplitHostPort(s.WakeBroadcastAddr); err != nil || net.ParseIP(host).To4() == nil {
+			msgs = append(msgs, fmt.Sprintf(`"wake_broadcast" %q is not an IPv4 address and port such as "192.168.1.255:9"`, s.WakeBroadcastAddr))
+		} else if n, err := strconv.Atoi(port); err != nil || n <= 0 || n > 65535 {
+			msgs = append(msgs, fmt.Sprintf(`"wake_broadcast" %q has an invalid port`, s.WakeBroadcastAddr))
+		}
+	}
+	if (s.WakeBroadcastAddr != "" || s.WakeRelay != "") && s.MacAddress == "" {
+		msgs = append(msgs, `"wake_broadcast" and "wake_relay" need a "mac_address"`)
+	}
+	return
+}
@@ -0,0 +1,622 @@
+package sshtools
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// ZmodemNone as zmodem_dir turns the detection of sz and rz off.
+const ZmodemNone = "none"
+
+// ZMODEM framing, from Chuck Forsberg's protocol description and lrzsz.
+const (
+	zPad   = '*'
+	zDLE   = 0x18
+	zBin   = 'A'
+	zHex   = 'B'
+	zBin32 = 'C'
+
+	zRQInit  = 0
+	zRInit   = 1
+	zSInit   = 2
+	zAck     = 3
+	zFile    = 4
+	zSkip    = 5
+	zNak     = 6
+	zAbort   = 7
+	zFin     = 8
+	zRPos    = 9
+	zData    = 10
+	zEOF     = 11
+	zFErr    = 12
+	zCan     = 16
+	zCommand = 18
+
+	// Ends of data subpackets: end of frame, go on, ack wanted and go on,
+	// ack wanted and wait.
+	zCRCE = 'h'
+	zCRCG = 'i'
+	zCRCQ = 'j'
+	zCRCW = 'k'
+	zRUB0 = 'l'
+	zRUB1 = 'm'
+
+	// ZRINIT capabilities: full duplex, receiving during disk writes,
+	// 32-bit CRCs.
+	zCanFDX  = 0x01
+	zCanOVIO = 0x02
+	zCanFC32 = 0x20
+
+	// zmodemMax bounds a subpacket: ZMODEM-8k sends 8 KiB ones.
+	zmodemMax = 16 * 1024
+	// zmodemGarbage is how much output may come before a header is given
+	// up on, e.g. when sz died and the shell prompt is back.
+	zmodemGarbage = 4096
+)
+
+var (
+	// zmodemStart starts the ZRQINIT header sz sends, zmodemUpload the
+	// ZRINIT header of rz waiting for files.
+	zmodemStart  = []byte("**\x18B00")
+	zmodemUpload = []byte("**\x18B01")
+	// zmodemAbort cancels a transfer on the other side, as lrzsz does.
+	zmodemAbort = []byte("\x18\x18\x18\x18\x18\x18\x18\x18\x08\x08\x08\x08\x08\x08\x08\x08")
+
+	errZmodemCancelled = errors.New("cancelled")
+	errZmodemAborted   = errors.New("the sender aborted the transfer")
+	// errZmodemGarbled is a header or subpacket that failed its CRC or
+	// was cut short; the receiver asks for the data again.
+	errZmodemGarbled = errors.New("garbled data")
+)
+
+// zmodemReader passes the output of a session through and, when sz starts
+// sending files, receives them into dir before going on. The transfer runs
+// on the reader's goroutine, so output before and after it stays in order.
+type zmodemReader struct {
+	r io.Reader
+	// w is the session's stdin, for the receiver's headers.
+	w io.Writer
+	// status shows progress on the local terminal.
+	status io.Writer
+	dir    string
+	log    func(level int, format string, args ...any)
+
+	// tail is the end of the previous read, to find a start split across
+	// reads; start is the output after one, for the next Read to receive.
+	tail    []byte
+	start   []byte
+	upload  bool
+	pending []byte
+	active  atomic.Bool
+	cancel  atomic.Bool
+}
+
+func (z *zmodemReader) Read(p []byte) (n int, err error) {
+	if z.start != nil {
+		z.transfer()
+	}
+	if len(z.pending) > 0 {
+		n = copy(p, z.pending)
+		z.pending = z.pending[n:]
+		return
+	}
+
+	n, err = z.r.Read(p)
+	if n == 0 {
+		return
+	}
+	data := append(z.tail, p[:n]...)
+	i, upload := bytes.Index(data, zmodemStart), false
+	if j := bytes.Index(data, zmodemUpload); j >= 0 && (i < 0 || j < i) {
+		i, upload = j, true
+	}
+	if i < 0 {
+		z.tail = append([]byte(nil), data[max(len(data)-len(zmodemStart)+1, 0):]...)
+		return
+	}
+	// 起始序列之前的输出照常显示，之后的交给下一次 Read 中的传输
+	end := i + len(zmodemStart) - len(z.tail)
+	z.start = append([]byte{}, p[end:n]...)
+	z.upload = upload
+	z.tail = nil
+	n = max(i-(len(data)-n), 0)
+	return
+}
+
+// Active reports whether a transfer is running; local keystrokes are not
+// sent to the session then.
+func (z *zmodemReader) Active() bool {
+	return z.active.Load()
+}
+
+// Cancel stops the running transfer, as Ctrl-C during it does.
+func (z *zmodemReader) Cancel() {
+	if z.active.Load() && !z.cancel.Swap(true) {
+		_, _ = z.w.Write(zmodemAbort)
+	}
+}
+
+// transfer runs the transfer found by Read, keeping the output that came
+// after its end for the following reads.
+func (z *zmodemReader) transfer() {
+	r := bufio.NewReader(io.MultiReader(bytes.NewReader(z.start), z.r))
+	z.start = nil
+	z.cancel.Store(false)
+	z.active.Store(true)
+	defer z.active.Store(false)
+
+	if z.upload {
+		// 不支持 rz 上传：取消对方的等待，并跳过其余的 ZRINIT 头
+		_, _ = z.w.Write(zmodemAbort)
+		skipHexHeader(r)
+		fmt.Fprint(z.status, "\r\nsshtools: rz uploads are not supported, use sshtools put\r\n")
+	} else {
+		rx := &zmodemReceiver{r: r, w: z.w, dir: z.dir, status: z.status, cancelled: z.cancel.Load}
+		files, err := rx.run()
+		if err != nil {
+			if !z.cancel.Load() {
+				_, _ = z.w.Write(zmodemAbort)
+			}
+			fmt.Fprintf(z.status, "\r\nsshtools: ZMODEM transfer failed: %v\r\n", err)
+		} else if z.log != nil {
+			z.log(1, "received %d file(s) with ZMODEM", files)
+		}
+	}
+	rest, _ := r.Peek(r.Buffered())
+	z.pending = append(z.pending, rest...)
+}
+
+// skipHexHeader drops the rest of a hex header after its first digits.
+func skipHexHeader(r *bufio.Reader) {
+	for range 16 {
+		b, err := r.Peek(1)
+		if err != nil || strings.IndexByte("0123456789abcdef\r\n\x8a\x11", b[0]) < 0 {
+			return
+		}
+		_, _ = r.ReadByte()
+	}
+}
+
+// zmodemReceiver receives files sent with sz. The ZRQINIT header that
+// started the transfer has already been read.
+type zmodemReceiver struct {
+	r         *bufio.Reader
+	w         io.Writer
+	dir       string
+	status    io.Writer
+	cancelled func() bool
+	buf       []byte
+}
+
+// run receives files until the sender finishes the session.
+func (z *zmodemReceiver) run() (files int, err error) {
+	if err = z.sendRInit(); err != nil {
+		return
+	}
+	for {
+		typ, _, long, errs := z.readHeader()
+		if errors.Is(errs, errZmodemGarbled) {
+			if err = z.sendHeader(zNak, 0); err != nil {
+				return
+			}
+			continue
+		}
+		if errs != nil {
+			return files, errs
+		}
+		switch typ {
+		case zRQInit, zEOF:
+			err = z.sendRInit()
+		case zSInit:
+			// 对方的 Attn 序列用不到，确认即可
+			if _, _, err = z.readSubpacket(long); err == nil {
+				err = z.sendHeader(zAck, 0)
+			}
+		case zFile:
+			var received bool
+			if received, err = z.receiveFile(long); received {
+				files++
+			}
+		case zFin:
+			if err = z.sendHeader(zFin, 0); err == nil {
+				z.readOO()
+			}
+			return
+		case zCommand:
+			// 远程要求在本机执行命令，一律拒绝
+			return files, errors.New("refused a request to run a local command")
+		case zAbort, zFErr, zCan:
+			return files, errZmodemAborted
+		}
+		if err != nil {
+			return
+		}
+	}
+}
+
+// receiveFile receives the file announced by a ZFILE header, or skips it
+// when it cannot be saved.
+func (z *zmodemReceiver) receiveFile(long bool) (received bool, err error) {
+	info, _, err := z.readSubpacket(long)
+	if errors.Is(err, errZmodemGarbled) {
+		return false, z.sendHeader(zNak, 0)
+	}
+	if err != nil {
+		return
+	}
+	name, size, mtime := parseZmodemFile(info)
+	file, target, errs := z.create(name)
+	if errs != nil {
+		fmt.Fprintf(z.status, "\r\nsshtools: skipping %s: %v\r\n", strconv.Quote(name), errs)
+		return false, z.sendHeader(zSkip, 0)
+	}
+	done := false
+	defer func() {
+		if !done {
+			_ = file.Close()
+			_ = os.Remove(target)
+		}
+	}()
+	if size >= 0 {
+		fmt.Fprintf(z.status, "\r\nsshtools: receiving %s (%s) with ZMODEM, Ctrl-C cancels\r\n", name, FormatBytes(float64(size)))
+	} else {
+		fmt.Fprintf(z.status, "\r\nsshtools: receiving %s with ZMODEM, Ctrl-C cancels\r\n", name)
+	}
+
+	var pos uint32
+	if err = z.sendHeader(zRPos, pos); err != nil {
+		return
+	}
+	for {
+		typ, at, long, errs := z.readHeader()
+		if errors.Is(errs, errZmodemGarbled) {
+			if err = z.sendHeader(zRPos, pos); err != nil {
+				return
+			}
+			continue
+		}
+		if errs != nil {
+			return false, errs
+		}
+		switch typ {
+		case zData:
+			if at != pos {
+				err = z.sendHeader(zRPos, pos)
+				break
+			}
+			err = z.receiveData(file, long, &pos)
+		case zEOF:
+			if at != pos {
+				// 数据尚未全部到达，ZEOF 与当前位置不符时忽略
+				continue
+			}
+			if err = file.Close(); err != nil {
+				return
+			}
+			done = true
+			if mtime > 0 {
+				_ = os.Chtimes(target, time.Now(), time.Unix(mtime, 0))
+			}
+			fmt.Fprintf(z.status, "sshtools: saved %s (%s)\r\n", target, FormatBytes(float64(pos)))
+			return true, z.sendRInit()
+		case zFile:
+			// 对方没有收到 ZRPOS，重发了 ZFILE
+			if _, _, err = z.readSubpacket(long); err == nil || errors.Is(err, errZmodemGarbled) {
+				err = z.sendHeader(zRPos, pos)
+			}
+		case zFin, zAbort, zFErr, zCan:
+			return false, errZmodemAborted
+		}
+		if err != nil {
+			return
+		}
+	}
+}
+
+// receiveData writes the subpackets of a ZDATA frame to file, acknowledging
+// them as asked, until the frame ends or its data is garbled.
+func (z *zmodemReceiver) receiveData(file *os.File, long bool, pos *uint32) (err error) {
+	for {
+		data, end, errs := z.readSubpacket(long)
+		if errors.Is(errs, errZmodemGarbled) {
+			// 从最后一个完好的位置重发
+			return z.sendHeader(zRPos, *pos)
+		}
+		if errs != nil {
+			return errs
+		}
+		if _, err = file.Write(data); err != nil {
+			return fmt.Errorf("failed to write %s: %v", file.Name(), err)
+		}
+		*pos += uint32(len(data))
+		switch end {
+		case zCRCW:
+			return z.sendHeader(zAck, *pos)
+		case zCRCQ:
+			if err = z.sendHeader(zAck, *pos); err != nil {
+				return
+			}
+		case zCRCE:
+			return
+		}
+	}
+}
+
+// create opens a new file in dir for name, the base name of what the
+// sender called it. An existing file is kept and a number added instead.
+func (z *zmodemReceiver) create(name string) (file *os.File, target string, err error) {
+	base := path.Base(strings.ReplaceAll(name, `\`, "/"))
+	if base == "." || base == ".." || base == "/" || strings.ContainsFunc(base, func(r rune) bool { return r < 0x20 || r == 0x7f }) {
+		return nil, "", errors.New("not a valid file name")
+	}
+	dir := z.dir
+	if dir == "" {
+		dir = "."
+	}
+	ext := filepath.Ext(base)
+	target = filepath.Join(dir, base)
+	for i := 1; ; i++ {
+		file, err = os.OpenFile(target, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o644)
+		if !errors.Is(err, fs.ErrExist) {
+			return
+		}
+		target = filepath.Join(dir, fmt.Sprintf("%s.%d%s", strings.TrimSuffix(base, ext), i, ext))
+	}
+}
+
+// parseZmodemFile reads the name, size (-1 when unknown) and modification
+// time of a ZFILE subpacket: "name\0size mtime mode ...\0", with the
+// mtime in octal.
+func parseZmodemFile(info []byte) (name string, size, mtime int64) {
+	nameBytes, rest, _ := bytes.Cut(info, []byte{0})
+	name, size = string(nameBytes), -1
+	rest, _, _ = bytes.Cut(rest, []byte{0})
+	fields := strings.Fields(string(rest))
+	if len(fields) > 0 {
+		if n, err := strconv.ParseInt(fields[0], 10, 64); err == nil {
+			size = n
+		}
+	}
+	if len(fields) > 1 {
+		mtime, _ = strconv.ParseInt(fields[1], 8, 64)
+	}
+	return
+}
+
+// readOO reads the "OO" sz ends the session with, and the end of the
+// ZFIN header before it.
+func (z *zmodemReceiver) readOO() {
+	skipHexHeader(z.r)
+	for range 2 {
+		b, err := z.r.ReadByte()
+		if err != nil {
+			return
+		}
+		if b != 'O' {
+			_ = z.r.UnreadByte()
+			return
+		}
+	}
+}
+
+// readByte reads a byte of the transfer, giving up when it was cancelled.
+func (z *zmodemReceiver) readByte() (b byte, err error) {
+	if z.cancelled() {
+		return 0, errZmodemCancelled
+	}
+	return z.r.ReadByte()
+}
+
+// readEscaped reads a ZDLE-escaped byte, or the end of a subpacket. Raw
+// XON and XOFF are flow control and skipped.
+func (z *zmodemReceiver) readEscaped() (b, end byte, err error) {
+	for {
+		if b, err = z.readByte(); err != nil {
+			return
+		}
+		switch b {
+		case 0x11, 0x13, 0x91, 0x93:
+			continue
+		case zDLE:
+		default:
+			return
+		}
+		if b, err = z.readByte(); err != nil {
+			return
+		}
+		switch {
+		case b == zCRCE || b == zCRCG || b == zCRCQ || b == zCRCW:
+			return 0, b, nil
+		case b == zRUB0:
+			return 0x7f, 0, nil
+		case b == zRUB1:
+			return 0xff, 0, nil
+		case b == zDLE:
+			// 连续的 ZDLE（CAN）是对方取消传输
+			return 0, 0, errZmodemAborted
+		case b&0x60 == 0x40:
+			return b ^ 0x40, 0, nil
+		}
+		return 0, 0, errZmodemGarbled
+	}
+}
+
+// readHeader reads the next header, skipping any other output before it,
+// and returns its type and its data as a position. long reports a binary
+// header with a 32-bit CRC, whose subpackets use one as well.
+func (z *zmodemReceiver) readHeader() (typ byte, pos uint32, long bool, err error) {
+	var b byte
+	for skipped := 0; ; skipped++ {
+		if skipped > zmodemGarbage {
+			return 0, 0, false, errors.New("no ZMODEM header from the sender")
+		}
+		if b, err = z.readByte(); err != nil {
+			return
+		}
+		if b != zPad {
+			continue
+		}
+		for b == zPad {
+			if b, err = z.readByte(); err != nil {
+				return
+			}
+		}
+		if b != zDLE {
+			continue
+		}
+		if b, err = z.readByte(); err != nil {
+			return
+		}
+		var hdr []byte
+		switch b {
+		case zHex:
+			hdr, err = z.readHexHeader()
+		case zBin:
+			hdr, err = z.readBinaryHeader(false)
+		case zBin32:
+			hdr, err = z.readBinaryHeader(true)
+			long = true
+		default:
+			continue
+		}
+		if err != nil {
+			return
+		}
+		return hdr[0], binary.LittleEndian.Uint32(hdr[1:5]), long, nil
+	}
+}
+
+// readHexHeader reads the type, data and CRC-16 of a hex header as hex
+// digits.
+func (z *zmodemReceiver) readHexHeader() (hdr []byte, err error) {
+	digits := make([]byte, 14)
+	for i := range digits {
+		if digits[i], err = z.readByte(); err != nil {
+			return
+		}
+	}
+	raw := make([]byte, 7)
+	if _, errs := hex.Decode(raw, digits); errs != nil {
+		return nil, errZmodemGarbled
+	}
+	if crc16(0, raw[:5]) != binary.BigEndian.Uint16(raw[5:]) {
+		return nil, errZmodemGarbled
+	}
+	return raw[:5], nil
+}
+
+// readBinaryHeader reads the escaped type, data and CRC of a binary header.
+func (z *zmodemReceiver) readBinaryHeader(long bool) (hdr []byte, err error) {
+	size := 7
+	if long {
+		size = 9
+	}
+	raw := make([]byte, size)
+	for i := range raw {
+		var end byte
+		if raw[i], end, err = z.readEscaped(); err != nil {
+			return
+		}
+		if end != 0 {
+			return nil, errZmodemGarbled
+		}
+	}
+	if !checkZmodemCRC(raw[:5], raw[5:], long) {
+		return nil, errZmodemGarbled
+	}
+	return raw[:5], nil
+}
+
+// readSubpacket reads a data subpacket and its CRC, which covers the data
+// and the end. The data is only valid until the next call.
+func (z *zmodemReceiver) readSubpacket(long bool) (data []byte, end byte, err error) {
+	data = z.buf[:0]
+	for {
+		var b byte
+		if b, end, err = z.readEscaped(); err != nil {
+			return
+		}
+		if end != 0 {
+			break
+		}
+		if len(data) >= zmodemMax {
+			return nil, 0, errZmodemGarbled
+		}
+		data = append(data, b)
+	}
+	z.buf = data
+	crc := make([]byte, 2)
+	if long {
+		crc = make([]byte, 4)
+	}
+	for i := range crc {
+		var e byte
+		if crc[i], e, err = z.readEscaped(); err != nil {
+			return
+		}
+		if e != 0 {
+			return nil, 0, errZmodemGarbled
+		}
+	}
+	if !checkZmodemCRC(append(data, end), crc, long) {
+		return nil, 0, errZmodemGarbled
+	}
+	return
+}
+
+// checkZmodemCRC checks the CRC-16 (big-endian) or CRC-32 (little-endian)
+// of data.
+func checkZmodemCRC(data, crc []byte, long bool) bool {
+	if long {
+		return crc32.ChecksumIEEE(data) == binary.LittleEndian.Uint32(crc)
+	}
+	return crc16(0, data) == binary.BigEndian.Uint16(crc)
+}
+
+// sendRInit tells the sender the receiver is ready for a file.
+func (z *zmodemReceiver) sendRInit() error {
+	return z.sendHeader(zRInit, uint32(zCanFDX|zCanOVIO|zCanFC32)<<24)
+}
+
+// sendHeader sends a hex header, as receivers do, with data as its
+// position or, shifted into the high byte, its flags.
+func (z *zmodemReceiver) sendHeader(typ byte, data uint32) (err error) {
+	hdr := []byte{typ, 0, 0, 0, 0}
+	binary.LittleEndian.PutUint32(hdr[1:], data)
+	s := fmt.Sprintf("**\x18B%x%04x\r\x8a", hdr, crc16(0, hdr))
+	if typ != zFin && typ != zAck {
+		s += "\x11"
+	}
+	_, err = io.WriteString(z.w, s)
+	return
+}
+
+// crc16 updates crc with data, as the CRC-16/XMODEM of ZMODEM headers and
+// subpackets.
+func crc16(crc uint16, data []byte) uint16 {
+	for _, b := range data {
+		crc ^= uint16(b) << 8
+		for range 8 {
+			if crc&0x8000 != 0 {
+				crc = crc<<1 ^ 0x1021
+			} else {
+				crc <<= 1
+			}
+		}
+	}
+	return crc
+}